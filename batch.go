@@ -258,6 +258,9 @@ type Batch struct {
 	commit    sync.WaitGroup
 	commitErr error
 	applied   uint32 // updated atomically
+	// fsyncWait is signaled once the WAL sync of a batch committed with Sync
+	// completes. See Batch.SyncWait.
+	fsyncWait sync.WaitGroup
 }
 
 var _ Reader = (*Batch)(nil)
@@ -348,6 +351,11 @@ func (b *Batch) refreshMemTableSize() {
 		if !ok {
 			break
 		}
+		if kind == InternalKeyKindLogData {
+			// LogData is only written to the WAL, and isn't applied to the
+			// memtable.
+			continue
+		}
 		b.memTableSize += memTableEntrySize(len(key), len(value))
 		switch kind {
 		case InternalKeyKindRangeDelete:
@@ -387,6 +395,10 @@ func (b *Batch) Apply(batch *Batch, _ *WriteOptions) error {
 			if !ok {
 				break
 			}
+			if kind == InternalKeyKindLogData {
+				// LogData is neither indexed nor applied to the memtable.
+				continue
+			}
 			switch kind {
 			case InternalKeyKindRangeDelete:
 				b.countRangeDels++
@@ -434,7 +446,7 @@ func (b *Batch) Get(key []byte) ([]byte, io.Closer, error) {
 	if b.index == nil {
 		return nil, nil, ErrNotIndexed
 	}
-	return b.db.getInternal(key, b, nil /* snapshot */)
+	return b.db.getInternal(key, b, snapshotIterOpts{}, nil /* valueBuf */)
 }
 
 func (b *Batch) prepareDeferredKeyValueRecord(keyLen, valueLen int, kind InternalKeyKind) {
@@ -549,7 +561,9 @@ func (b *Batch) SetDeferred(keyLen, valueLen int) *DeferredBatchOp {
 
 // Merge adds an action to the batch that merges the value at key with the new
 // value. The details of the merge are dependent upon the configured merge
-// operator.
+// operator. The value is not checked against the merge operator until the
+// key is read, unless Options.Experimental.MergeValidation is enabled, in
+// which case the batch is rejected when it is committed.
 //
 // It is safe to modify the contents of the arguments after Merge returns.
 func (b *Batch) Merge(key, value []byte, _ *WriteOptions) error {
@@ -649,6 +663,61 @@ func (b *Batch) DeleteRange(start, end []byte, _ *WriteOptions) error {
 	return nil
 }
 
+// DeleteSpan deletes all of the point keys (and values) and all of the range
+// keys in the range [start,end) (inclusive on start, exclusive on end). It's
+// equivalent to both a DeleteRange and a RangeKeyDelete over the same bounds.
+// The two deletions are encoded into the batch together, and like every
+// operation within a batch, they're committed atomically.
+//
+// Range keys require that the DB's format major version be at least
+// FormatRangeKeys, so committing a batch containing a DeleteSpan to an older
+// DB fails.
+//
+// It is safe to modify the contents of the arguments after DeleteSpan
+// returns.
+func (b *Batch) DeleteSpan(start, end []byte, opts *WriteOptions) error {
+	if err := b.DeleteRange(start, end, opts); err != nil {
+		return err
+	}
+	return b.RangeKeyDelete(start, end, opts)
+}
+
+// DeletePrefix deletes all of the point keys (and values) whose Split prefix
+// equals the provided prefix, such as all of the suffixed versions of a key.
+// Keys which merely begin with the provided bytes, but have a different Split
+// prefix, are not deleted. It's equivalent to a DeleteRange from the prefix to
+// the prefix's immediate successor, the smallest key greater than all keys
+// with the prefix (see Comparer.ImmediateSuccessor). Like DeleteRange,
+// DeletePrefix does NOT delete overlapping range keys.
+//
+// The batch must have been created by a DB configured with a Comparer that
+// implements Split.
+//
+// It is safe to modify the contents of the arguments after DeletePrefix
+// returns.
+func (b *Batch) DeletePrefix(prefix []byte, opts *WriteOptions) error {
+	if b.db == nil || b.db.opts.Comparer.Split == nil {
+		return errors.New("pebble: DeletePrefix requires a Comparer with Split")
+	}
+	return b.DeleteRange(prefix, prefixEnd(b.db.opts.Comparer, prefix), opts)
+}
+
+// prefixEnd returns the exclusive end of the key range spanned by the keys
+// whose Split prefix equals the provided prefix: the Comparer's
+// ImmediateSuccessor of the prefix if it's implemented, and otherwise the
+// prefix with a 0x00 byte appended. The latter is the immediate successor of
+// the prefix for any Comparer which orders prefixes bytewise, and unlike a
+// bytewise successor, exists for every prefix, including one consisting
+// entirely of 0xff bytes.
+func prefixEnd(comparer *Comparer, prefix []byte) []byte {
+	if succ := comparer.ImmediateSuccessor; succ != nil {
+		return succ(nil, prefix)
+	}
+	end := make([]byte, len(prefix)+1)
+	copy(end, prefix)
+	return end
+}
+
 // DeleteRangeDeferred is similar to DeleteRange in that it adds a delete range
 // operation to the batch, except it only takes in key lengths instead of
 // complete slices, letting the caller encode into those objects and then call
@@ -802,7 +871,8 @@ func (b *Batch) Empty() bool {
 	return len(b.data) <= batchHeaderLen
 }
 
-// Len returns the current size of the batch in bytes.
+// Len returns the current size of the batch in bytes, which is the length of
+// the batch's representation, including its header and any LogData.
 func (b *Batch) Len() int {
 	if len(b.data) <= batchHeaderLen {
 		return batchHeaderLen
@@ -848,7 +918,7 @@ func (b *Batch) NewIter(o *IterOptions) *Iterator {
 	if b.index == nil {
 		return &Iterator{err: ErrNotIndexed}
 	}
-	return b.db.newIterInternal(b, nil /* snapshot */, o)
+	return b.db.newIterInternal(b, snapshotIterOpts{}, o)
 }
 
 // newInternalIter creates a new internalIterator that iterates over the
@@ -1028,6 +1098,18 @@ func (b *Batch) Commit(o *WriteOptions) error {
 	return b.db.Apply(b, o)
 }
 
+// SyncWait waits for the WAL sync of a batch applied through
+// DB.ApplyNoSyncWait, returning any error syncing it. Once SyncWait returns
+// nil the batch is durable. SyncWait must be called before the batch is closed
+// or reset.
+func (b *Batch) SyncWait() error {
+	b.fsyncWait.Wait()
+	if b.commitErr != nil {
+		b.db = nil // prevent batch reuse on error
+	}
+	return b.commitErr
+}
+
 // Close closes the batch without committing it.
 func (b *Batch) Close() error {
 	b.release()
@@ -1055,8 +1137,13 @@ func (b *Batch) init(cap int) {
 // returned by Repr()) is not modified. It is only necessary to call this
 // method if a batch is explicitly being reused. Close automatically takes are
 // of releasing resources when appropriate for batches that are internally
-// being reused.
+// being reused. Reset waits for the WAL sync of a batch applied with
+// DB.ApplyNoSyncWait to complete.
 func (b *Batch) Reset() {
+	// The WAL sync of a batch applied with DB.ApplyNoSyncWait may still be
+	// outstanding, in which case the commit pipeline references the batch
+	// until it completes.
+	b.fsyncWait.Wait()
 	b.count = 0
 	b.countRangeDels = 0
 	b.countRangeKeys = 0
@@ -1068,6 +1155,7 @@ func (b *Batch) Reset() {
 	b.rangeKeysSeqNum = 0
 	b.flushable = nil
 	b.commit = sync.WaitGroup{}
+	b.fsyncWait = sync.WaitGroup{}
 	b.commitErr = nil
 	atomic.StoreUint32(&b.applied, 0)
 	if b.data != nil {
@@ -1081,6 +1169,10 @@ func (b *Batch) Reset() {
 			// Otherwise, reset the buffer for re-use.
 			b.data = b.data[:batchHeaderLen]
 			b.setSeqNum(0)
+			// The count in the header is only written when the batch is
+			// committed; clear it so that a reused buffer doesn't carry a
+			// stale count.
+			binary.LittleEndian.PutUint32(b.countData(), 0)
 		}
 	}
 	if b.index != nil {
@@ -1138,7 +1230,9 @@ func (b *Batch) setCount(v uint32) {
 }
 
 // Count returns the count of memtable-modifying operations in this batch. All
-// operations with the except of LogData increment this count.
+// operations with the exception of LogData increment this count, including
+// each range deletion and range key operation. A DeleteSpan counts as two
+// operations: a range deletion and a range key deletion.
 func (b *Batch) Count() uint32 {
 	if b.count > math.MaxUint32 {
 		panic(ErrInvalidBatch)
@@ -1147,10 +1241,12 @@ func (b *Batch) Count() uint32 {
 }
 
 // Reader returns a BatchReader for the current batch contents. If the batch is
-// mutated, the new entries will not be visible to the reader.
+// mutated, the new entries will not be visible to the reader. Reading the
+// batch does not modify it, and the batch may be read whether or not it has
+// been committed.
 func (b *Batch) Reader() BatchReader {
-	if len(b.data) == 0 {
-		b.init(batchHeaderLen)
+	if len(b.data) <= batchHeaderLen {
+		return nil
 	}
 	return b.data[batchHeaderLen:]
 }
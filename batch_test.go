@@ -21,6 +21,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/batchskl"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -145,6 +146,119 @@ func TestBatch(t *testing.T) {
 	verifyTestCases(&b, testCases)
 }
 
+func TestBatchReaderAllKinds(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	b := d.NewBatch()
+	require.Nil(t, b.Reader())
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Merge([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.Delete([]byte("c"), nil))
+	require.NoError(t, b.SingleDelete([]byte("d"), nil))
+	require.NoError(t, b.DeleteRange([]byte("e"), []byte("f"), nil))
+	require.NoError(t, b.LogData([]byte("audit"), nil))
+	require.NoError(t, b.RangeKeySet([]byte("g"), []byte("h"), []byte("@5"), []byte("3"), nil))
+	require.NoError(t, b.RangeKeyUnset([]byte("i"), []byte("j"), []byte("@6"), nil))
+	require.NoError(t, b.RangeKeyDelete([]byte("k"), []byte("l"), nil))
+
+	read := func() string {
+		var buf bytes.Buffer
+		r := b.Reader()
+		for {
+			kind, k, v, ok := r.Next()
+			if !ok {
+				break
+			}
+			switch kind {
+			case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+				s, err := rangekey.Decode(base.MakeInternalKey(k, 0, kind), v, nil)
+				require.NoError(t, err)
+				fmt.Fprintf(&buf, "%s: %s\n", kind, s)
+			default:
+				fmt.Fprintf(&buf, "%s: %q %q\n", kind, k, v)
+			}
+		}
+		require.Empty(t, r)
+		return buf.String()
+	}
+	const expected = `SET: "a" "1"
+MERGE: "b" "2"
+DEL: "c" ""
+SINGLEDEL: "d" ""
+RANGEDEL: "e" "f"
+LOGDATA: "audit" ""
+RANGEKEYSET: g-h:{(#0,RANGEKEYSET,@5,3)}
+RANGEKEYUNSET: i-j:{(#0,RANGEKEYUNSET,@6)}
+RANGEKEYDEL: k-l:{(#0,RANGEKEYDEL)}
+`
+	repr := append([]byte(nil), b.Repr()...)
+	require.Equal(t, expected, read())
+	// Reading the batch doesn't modify it, and it may be read again.
+	require.Equal(t, repr, b.Repr())
+	require.Equal(t, expected, read())
+
+	// The batch may be read after it's committed.
+	require.NoError(t, b.Commit(nil))
+	require.Equal(t, expected, read())
+	require.NoError(t, b.Close())
+}
+
+func TestBatchDeleteSpan(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.RangeKeySet([]byte("a"), []byte("f"), []byte("@1"), []byte("v"), nil))
+	// Flush the keys written so far, so that the deletion must apply to keys
+	// within both sstables and the memtable.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("c@2"), []byte("c@2"), nil))
+	require.NoError(t, d.RangeKeySet([]byte("c"), []byte("e"), []byte("@2"), []byte("w"), nil))
+
+	b := d.NewBatch()
+	require.NoError(t, b.DeleteSpan([]byte("b"), []byte("d"), nil))
+	require.Equal(t, uint32(2), b.Count())
+	require.NoError(t, b.Commit(nil))
+
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	var buf bytes.Buffer
+	for valid := iter.First(); valid; valid = iter.Next() {
+		fmt.Fprintf(&buf, "%s:", iter.Key())
+		if hasPoint, hasRange := iter.HasPointAndRange(); hasPoint {
+			fmt.Fprintf(&buf, " point=%s", iter.Value())
+			if hasRange {
+				fmt.Fprint(&buf, ",")
+			}
+		}
+		if _, hasRange := iter.HasPointAndRange(); hasRange {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&buf, " [%s-%s)", start, end)
+			for _, rk := range iter.RangeKeys() {
+				fmt.Fprintf(&buf, " %s=%s", rk.Suffix, rk.Value)
+			}
+		}
+		fmt.Fprintln(&buf)
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, `a: point=a, [a-b) @1=v
+d: point=d, [d-e) @2=w @1=v
+e: point=e, [e-f) @1=v
+`, buf.String())
+}
+
 func TestBatchLen(t *testing.T) {
 	var b Batch
 
@@ -169,6 +283,84 @@ func TestBatchLen(t *testing.T) {
 	requireLenAndReprEq(43)
 }
 
+func TestBatchLenAndCount(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	ops := []struct {
+		fn       func(b *Batch) error
+		mutating int
+	}{
+		{func(b *Batch) error { return b.Set([]byte("a"), []byte("1"), nil) }, 1},
+		{func(b *Batch) error { return b.Merge([]byte("b"), []byte("2"), nil) }, 1},
+		{func(b *Batch) error { return b.Delete([]byte("c"), nil) }, 1},
+		{func(b *Batch) error { return b.SingleDelete([]byte("d"), nil) }, 1},
+		{func(b *Batch) error { return b.DeleteRange([]byte("e"), []byte("f"), nil) }, 1},
+		{func(b *Batch) error { return b.LogData([]byte("audit"), nil) }, 0},
+		{func(b *Batch) error {
+			return b.RangeKeySet([]byte("g"), []byte("h"), []byte("@5"), []byte("3"), nil)
+		}, 1},
+		{func(b *Batch) error { return b.RangeKeyUnset([]byte("i"), []byte("j"), []byte("@6"), nil) }, 1},
+		{func(b *Batch) error { return b.RangeKeyDelete([]byte("k"), []byte("l"), nil) }, 1},
+		{func(b *Batch) error { return b.DeleteSpan([]byte("m"), []byte("n"), nil) }, 2},
+		{func(b *Batch) error {
+			op := b.SetDeferred(1, 1)
+			op.Key[0], op.Value[0] = 'o', '4'
+			return op.Finish()
+		}, 1},
+	}
+
+	for _, indexed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("indexed=%t", indexed), func(t *testing.T) {
+			newBatch := d.NewBatch
+			if indexed {
+				newBatch = d.NewIndexedBatch
+			}
+			b := newBatch()
+			var count uint32
+			for _, op := range ops {
+				require.NoError(t, op.fn(b))
+				count += uint32(op.mutating)
+				require.Equal(t, count, b.Count())
+				require.Equal(t, len(b.Repr()), b.Len())
+			}
+
+			// Applying the batch to another batch, or constructing one from its
+			// representation, preserves the counts. LogData isn't applied to
+			// the memtable, nor indexed.
+			b2 := newBatch()
+			require.NoError(t, b2.Apply(b, nil))
+			require.Equal(t, count, b2.Count())
+			require.Equal(t, b.Len(), b2.Len())
+			require.Equal(t, b.memTableSize, b2.memTableSize)
+
+			b3 := d.NewBatch()
+			require.NoError(t, b3.SetRepr(append([]byte(nil), b.Repr()...)))
+			require.Equal(t, count, b3.Count())
+			require.Equal(t, b.Len(), b3.Len())
+			require.Equal(t, b.memTableSize, b3.memTableSize)
+
+			if indexed {
+				iter := b2.NewIter(nil)
+				var keys []string
+				for valid := iter.First(); valid; valid = iter.Next() {
+					keys = append(keys, string(iter.Key()))
+				}
+				require.NoError(t, iter.Close())
+				require.Equal(t, []string{"a", "b", "o"}, keys)
+			}
+			for _, b := range []*Batch{b, b2, b3} {
+				require.NoError(t, b.Close())
+			}
+		})
+	}
+}
+
 func TestBatchEmpty(t *testing.T) {
 	var b Batch
 	require.True(t, b.Empty())
@@ -842,6 +1034,58 @@ func TestBatchRangeOps(t *testing.T) {
 	})
 }
 
+func TestBatchDeletePrefix(t *testing.T) {
+	keys := []string{"foo", "foo@1", "foo@2", "foobar@3", "fop@1", "fo@2", "\xff\xff@1", "\xff\xff\xff@2"}
+
+	var d *DB
+	collect := func() []string {
+		var got []string
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			got = append(got, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		return got
+	}
+
+	// Only the keys with the Split prefix "foo" are deleted, not those with
+	// the prefix "foobar", whether or not the Comparer implements
+	// ImmediateSuccessor.
+	for _, immediateSuccessor := range []bool{true, false} {
+		comparer := *testkeys.Comparer
+		if !immediateSuccessor {
+			comparer.ImmediateSuccessor = nil
+		}
+		var err error
+		d, err = Open("", &Options{
+			Comparer: &comparer,
+			FS:       vfs.NewMem(),
+		})
+		require.NoError(t, err)
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte(k), nil, nil))
+		}
+
+		b := d.NewBatch()
+		require.NoError(t, b.DeletePrefix([]byte("foo"), nil))
+		require.Equal(t, uint64(1), b.countRangeDels)
+		require.NoError(t, b.Commit(nil))
+		require.Equal(t, []string{"fo@2", "foobar@3", "fop@1", "\xff\xff@1", "\xff\xff\xff@2"}, collect())
+
+		// A prefix consisting entirely of 0xff bytes has an immediate
+		// successor too.
+		b = d.NewBatch()
+		require.NoError(t, b.DeletePrefix([]byte("\xff\xff"), nil))
+		require.NoError(t, b.Commit(nil))
+		require.Equal(t, []string{"fo@2", "foobar@3", "fop@1", "\xff\xff\xff@2"}, collect())
+		require.NoError(t, d.Close())
+	}
+
+	// A batch not associated with a DB has no Comparer.
+	var b2 Batch
+	require.Error(t, b2.DeletePrefix([]byte("foo"), nil))
+}
+
 func TestBatchTooLarge(t *testing.T) {
 	var b Batch
 	var result interface{}
@@ -1298,3 +1542,75 @@ func TestBatchSpanCaching(t *testing.T) {
 		}
 	}
 }
+
+// intValueMerger sums merge operands, which must be decimal integers.
+type intValueMerger struct {
+	sum int
+}
+
+func (m *intValueMerger) add(value []byte) error {
+	v, err := strconv.Atoi(string(value))
+	if err != nil {
+		return err
+	}
+	m.sum += v
+	return nil
+}
+
+func (m *intValueMerger) MergeNewer(value []byte) error { return m.add(value) }
+func (m *intValueMerger) MergeOlder(value []byte) error { return m.add(value) }
+
+func (m *intValueMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	return []byte(strconv.Itoa(m.sum)), nil, nil
+}
+
+func TestBatchMergeValidation(t *testing.T) {
+	merger := &Merger{
+		Name: "test.int",
+		Merge: func(key, value []byte) (ValueMerger, error) {
+			m := &intValueMerger{}
+			if err := m.add(value); err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	}
+	for _, validate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("validate=%t", validate), func(t *testing.T) {
+			opts := &Options{FS: vfs.NewMem(), Merger: merger}
+			opts.Experimental.MergeValidation = validate
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			b := d.NewBatch()
+			require.NoError(t, b.Merge([]byte("a"), []byte("1"), nil))
+			require.NoError(t, b.Commit(nil))
+
+			b = d.NewBatch()
+			require.NoError(t, b.Merge([]byte("a"), []byte("2"), nil))
+			require.NoError(t, b.Merge([]byte("b"), []byte("two"), nil))
+			err = b.Commit(nil)
+			if validate {
+				// The invalid operand is rejected at commit time, and none of the
+				// batch is applied.
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `merge operand for key b rejected by merger "test.int"`)
+				require.NoError(t, b.Close())
+				v, closer, err := d.Get([]byte("a"))
+				require.NoError(t, err)
+				require.Equal(t, "1", string(v))
+				require.NoError(t, closer.Close())
+				_, _, err = d.Get([]byte("b"))
+				require.ErrorIs(t, err, ErrNotFound)
+				return
+			}
+			// Without validation, the invalid operand is only detected when the
+			// key is read.
+			require.NoError(t, err)
+			_, _, err = d.Get([]byte("b"))
+			require.Error(t, err)
+			require.NotErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// blobFileCache holds the readers of the DB's blob files, which are opened
+// when a value is first read from them. A blob file's reader remains open
+// until the blob file is deleted, once no sstable references it, or the DB is
+// closed.
+type blobFileCache struct {
+	fs      vfs.FS
+	dirname string
+	mu      struct {
+		sync.Mutex
+		readers map[FileNum]*blob.Reader
+	}
+}
+
+func newBlobFileCache(fs vfs.FS, dirname string) *blobFileCache {
+	c := &blobFileCache{fs: fs, dirname: dirname}
+	c.mu.readers = make(map[FileNum]*blob.Reader)
+	return c
+}
+
+func (c *blobFileCache) reader(fileNum FileNum) (*blob.Reader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.mu.readers[fileNum]; ok {
+		return r, nil
+	}
+	f, err := c.fs.Open(base.MakeFilepath(c.fs, c.dirname, fileTypeBlob, fileNum))
+	if err != nil {
+		return nil, err
+	}
+	r, err := blob.NewReader(f, fileNum)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.readers[fileNum] = r
+	return r, nil
+}
+
+// readValue reads the value identified by an encoded blob.Handle, the value of
+// a BLOBSET key. The value is read into buf if it has sufficient capacity.
+func (c *blobFileCache) readValue(handle, buf []byte) ([]byte, error) {
+	h, err := blob.DecodeHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.reader(h.FileNum)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadValue(h, buf)
+}
+
+// evict closes the reader of a deleted blob file.
+func (c *blobFileCache) evict(fileNum FileNum) {
+	c.mu.Lock()
+	r := c.mu.readers[fileNum]
+	delete(c.mu.readers, fileNum)
+	c.mu.Unlock()
+	if r != nil {
+		_ = r.Close()
+	}
+}
+
+func (c *blobFileCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for fileNum, r := range c.mu.readers {
+		err = firstError(err, r.Close())
+		delete(c.mu.readers, fileNum)
+	}
+	return err
+}
+
+// blobValueIter wraps the point iterator of an sstable referencing blob
+// files, surfacing its BLOBSET keys as SETWITHDEL keys with the values read
+// from the blob files. SETWITHDEL preserves the semantics of BLOBSET should
+// the keys be rewritten, as by a compaction.
+//
+// If lazy is set, BLOBSET keys are surfaced as-is, leaving the Iterator to
+// read their values only if they're retrieved through Iterator.Value or
+// Iterator.LazyValue.
+type blobValueIter struct {
+	internalIterator
+	blobFiles *blobFileCache
+	lazy      bool
+	key       InternalKey
+	err       error
+	stats     base.InternalIteratorStats
+}
+
+var _ base.InternalIteratorWithStats = (*blobValueIter)(nil)
+
+func (i *blobValueIter) resolve(key *InternalKey, value []byte) (*InternalKey, []byte) {
+	if key == nil || key.Kind() != InternalKeyKindBlobSet {
+		return key, value
+	}
+	h, err := blob.DecodeHandle(value)
+	if err != nil {
+		i.err = err
+		return nil, nil
+	}
+	i.stats.SeparatedPointValue.Count++
+	i.stats.SeparatedPointValue.ValueBytes += h.Length
+	if i.lazy {
+		return key, value
+	}
+	value, i.err = i.blobFiles.readValue(value, nil /* buf */)
+	if i.err != nil {
+		return nil, nil
+	}
+	i.stats.SeparatedPointValue.ValueBytesFetched += h.Length
+	i.key = *key
+	i.key.SetKind(InternalKeyKindSetWithDelete)
+	return &i.key, value
+}
+
+func (i *blobValueIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, []byte) {
+	i.err = nil
+	return i.resolve(i.internalIterator.SeekGE(key, flags))
+}
+
+func (i *blobValueIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, []byte) {
+	i.err = nil
+	return i.resolve(i.internalIterator.SeekPrefixGE(prefix, key, flags))
+}
+
+func (i *blobValueIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, []byte) {
+	i.err = nil
+	return i.resolve(i.internalIterator.SeekLT(key, flags))
+}
+
+func (i *blobValueIter) First() (*InternalKey, []byte) {
+	i.err = nil
+	return i.resolve(i.internalIterator.First())
+}
+
+func (i *blobValueIter) Last() (*InternalKey, []byte) {
+	i.err = nil
+	return i.resolve(i.internalIterator.Last())
+}
+
+func (i *blobValueIter) Next() (*InternalKey, []byte) {
+	if i.err != nil {
+		return nil, nil
+	}
+	return i.resolve(i.internalIterator.Next())
+}
+
+func (i *blobValueIter) Prev() (*InternalKey, []byte) {
+	if i.err != nil {
+		return nil, nil
+	}
+	return i.resolve(i.internalIterator.Prev())
+}
+
+func (i *blobValueIter) Error() error {
+	return firstError(i.err, i.internalIterator.Error())
+}
+
+func (i *blobValueIter) String() string {
+	return fmt.Sprintf("blob-values(%s)", i.internalIterator.String())
+}
+
+// MaybeFilteredKeys implements filteredIter.
+func (i *blobValueIter) MaybeFilteredKeys() bool {
+	if fi, ok := i.internalIterator.(filteredIter); ok {
+		return fi.MaybeFilteredKeys()
+	}
+	return false
+}
+
+// Stats implements base.InternalIteratorWithStats.
+func (i *blobValueIter) Stats() base.InternalIteratorStats {
+	stats := i.stats
+	if si, ok := i.internalIterator.(base.InternalIteratorWithStats); ok {
+		stats.Merge(si.Stats())
+	}
+	return stats
+}
+
+// ResetStats implements base.InternalIteratorWithStats.
+func (i *blobValueIter) ResetStats() {
+	i.stats = base.InternalIteratorStats{}
+	if si, ok := i.internalIterator.(base.InternalIteratorWithStats); ok {
+		si.ResetStats()
+	}
+}
+
+// valueSeparator separates the large values written by a flush or compaction
+// into blob files, one per output sstable, and tracks the blob files
+// referenced by each output sstable.
+type valueSeparator struct {
+	// threshold is the minimum length of a separated value, or zero if values
+	// aren't separated. The BLOBSET keys of the compaction's inputs are
+	// written to its outputs whether or not values are separated.
+	threshold int
+	// inputBlobFiles holds the sizes of the blob files referenced by the
+	// compaction's inputs.
+	inputBlobFiles map[FileNum]uint64
+	// newBlobFile creates a new blob file, returning its file number.
+	newBlobFile func() (FileNum, vfs.File, error)
+
+	w         *blob.Writer
+	fileNum   FileNum
+	refs      []manifest.BlobReference
+	handleBuf []byte
+	// created holds the file numbers of the blob files created, which are
+	// removed if the compaction fails.
+	created []FileNum
+}
+
+func newValueSeparator(
+	threshold int, inputs []compactionLevel, newBlobFile func() (FileNum, vfs.File, error),
+) *valueSeparator {
+	s := &valueSeparator{threshold: threshold, newBlobFile: newBlobFile}
+	for _, cl := range inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			for _, ref := range f.BlobReferences {
+				if s.inputBlobFiles == nil {
+					s.inputBlobFiles = make(map[FileNum]uint64)
+				}
+				s.inputBlobFiles[ref.FileNum] = ref.Size
+			}
+		}
+	}
+	return s
+}
+
+// add returns the key and value to write to the current output sstable in
+// place of the provided key and value, separating the value into the current
+// output's blob file if it's large enough.
+func (s *valueSeparator) add(key InternalKey, value []byte) (InternalKey, []byte, error) {
+	switch key.Kind() {
+	case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		if s.threshold <= 0 || len(value) < s.threshold {
+			return key, value, nil
+		}
+		if s.w == nil {
+			fileNum, f, err := s.newBlobFile()
+			if err != nil {
+				return key, nil, err
+			}
+			s.created = append(s.created, fileNum)
+			s.w = blob.NewWriter(f, fileNum)
+			s.fileNum = fileNum
+		}
+		h, err := s.w.Add(value)
+		if err != nil {
+			return key, nil, err
+		}
+		key.SetKind(InternalKeyKindBlobSet)
+		s.handleBuf = h.Encode(s.handleBuf[:0])
+		return key, s.handleBuf, nil
+
+	case InternalKeyKindBlobSet:
+		h, err := blob.DecodeHandle(value)
+		if err != nil {
+			return key, nil, err
+		}
+		if !s.referenced(h.FileNum) {
+			size, ok := s.inputBlobFiles[h.FileNum]
+			if !ok {
+				return key, nil, errors.AssertionFailedf(
+					"pebble: %s references blob file %s, which no input sstable references",
+					key.Pretty(DefaultComparer.FormatKey), h.FileNum)
+			}
+			s.refs = append(s.refs, manifest.BlobReference{FileNum: h.FileNum, Size: size})
+		}
+	}
+	return key, value, nil
+}
+
+func (s *valueSeparator) referenced(fileNum FileNum) bool {
+	for i := range s.refs {
+		if s.refs[i].FileNum == fileNum {
+			return true
+		}
+	}
+	return false
+}
+
+// finishOutput finishes the blob file of the current output sstable, if any,
+// and returns the blob files the output references.
+func (s *valueSeparator) finishOutput() ([]manifest.BlobReference, error) {
+	if s.w != nil {
+		err := s.w.Close()
+		size := s.w.Size()
+		s.w = nil
+		if err != nil {
+			return nil, err
+		}
+		s.refs = append(s.refs, manifest.BlobReference{FileNum: s.fileNum, Size: size})
+	}
+	refs := s.refs
+	s.refs = nil
+	return refs, nil
+}
+
+// abort closes the blob file of the current output sstable, if any, after the
+// compaction fails.
+func (s *valueSeparator) abort() {
+	if s.w != nil {
+		_ = s.w.Close()
+		s.w = nil
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSeparation(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.ValueSeparationThreshold = 100
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	blobFiles := func() []string {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var names []string
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeBlob {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	largeValue := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('a' + i%26)}, 1000)
+	}
+	check := func(n int) {
+		for i := 0; i < n; i++ {
+			k := []byte(fmt.Sprintf("large%03d", i))
+			v, closer, err := d.Get(k)
+			require.NoError(t, err)
+			require.Equal(t, largeValue(i), v)
+			require.NoError(t, closer.Close())
+		}
+		v, closer, err := d.Get([]byte("small"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("small"), v)
+		require.NoError(t, closer.Close())
+
+		iter := d.NewIter(nil)
+		var count int
+		for valid := iter.First(); valid; valid = iter.Next() {
+			count++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, n+1, count)
+	}
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("large%03d", i)), largeValue(i), nil))
+	}
+	require.NoError(t, d.Set([]byte("small"), []byte("small"), nil))
+	require.NoError(t, d.Flush())
+
+	// The large values are written to a blob file alongside the flushed
+	// sstable, rather than to the sstable itself.
+	require.Len(t, blobFiles(), 1)
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	for _, info := range tables[0] {
+		require.Less(t, info.Size, uint64(n*1000))
+	}
+	check(n)
+
+	// A compaction carries the references to the blob file over to its
+	// outputs, without rewriting the values.
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	require.Len(t, blobFiles(), 1)
+	check(n)
+
+	// A MERGE operand above a separated value merges with the value read from
+	// the blob file.
+	require.NoError(t, d.Merge([]byte("large000"), []byte("!"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	v, closer, err := d.Get([]byte("large000"))
+	require.NoError(t, err)
+	require.Equal(t, append(largeValue(0), '!'), v)
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.Set([]byte("large000"), largeValue(0), nil))
+
+	// The blob file survives reopening the DB.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check(n)
+	require.NoError(t, d.CheckConsistency())
+
+	// Once no sstable references the blob files, they're deleted.
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("z"), nil))
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	require.Empty(t, blobFiles())
+	require.NoError(t, d.Close())
+}
+
+func TestValueSeparationFormatMajorVersion(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		FormatMajorVersion:          FormatValueSeparation - 1,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.ValueSeparationThreshold = 100
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	blobFiles := func() int {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var n int
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeBlob {
+				n++
+			}
+		}
+		return n
+	}
+	largeValue := bytes.Repeat([]byte("a"), 1000)
+
+	// Below FormatValueSeparation, values remain in the flushed sstable.
+	require.NoError(t, d.Set([]byte("a"), largeValue, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 0, blobFiles())
+
+	// Once the format is ratcheted, they're separated.
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatValueSeparation))
+	require.NoError(t, d.Set([]byte("b"), largeValue, nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 1, blobFiles())
+}
+
+func TestIteratorLazyValue(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.ValueSeparationThreshold = 100
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	largeValue := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('a' + i%26)}, 1000)
+	}
+	const n = 10
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("large%03d", i)), largeValue(i), nil))
+	}
+	require.NoError(t, d.Set([]byte("small"), []byte("small"), nil))
+	require.NoError(t, d.Flush())
+
+	// Iterating without retrieving the values doesn't read them from the blob
+	// file.
+	iter := d.NewIter(nil)
+	var count int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		count++
+	}
+	require.NoError(t, iter.Error())
+	require.Equal(t, n+1, count)
+	stats := iter.Stats().InternalStats.SeparatedPointValue
+	require.Equal(t, uint64(n), stats.Count)
+	require.Equal(t, uint64(n*1000), stats.ValueBytes)
+	require.Equal(t, uint64(0), stats.ValueBytesFetched)
+
+	// Retrieving them through LazyValue reads them into the provided buffer.
+	iter.ResetStats()
+	buf := make([]byte, 0, 2000)
+	for valid := iter.First(); valid; valid = iter.Next() {
+		v, callerOwned, err := iter.LazyValue().Value(buf)
+		require.NoError(t, err)
+		if string(iter.Key()) == "small" {
+			require.False(t, callerOwned)
+			require.Equal(t, []byte("small"), v)
+			continue
+		}
+		var i int
+		_, err = fmt.Sscanf(string(iter.Key()), "large%03d", &i)
+		require.NoError(t, err)
+		require.True(t, callerOwned)
+		require.Equal(t, largeValue(i), v)
+		require.Equal(t, &buf[:1][0], &v[0])
+	}
+	require.NoError(t, iter.Error())
+	stats = iter.Stats().InternalStats.SeparatedPointValue
+	require.Equal(t, uint64(n), stats.Count)
+	require.Equal(t, uint64(n*1000), stats.ValueBytesFetched)
+
+	// As does Value, in either direction.
+	i := n - 1
+	for valid := iter.SeekLT([]byte("small")); valid; valid = iter.Prev() {
+		require.Equal(t, largeValue(i), iter.Value())
+		i--
+	}
+	require.NoError(t, iter.Error())
+	require.Equal(t, -1, i)
+	require.NoError(t, iter.Close())
+
+	// A MERGE operand above a separated value merges with the value read from
+	// the blob file, in either direction.
+	require.NoError(t, d.Merge([]byte("large000"), []byte("!"), nil))
+	require.NoError(t, d.Merge([]byte("large001"), []byte("!"), nil))
+	iter = d.NewIter(&IterOptions{UpperBound: []byte("large002")})
+	require.True(t, iter.First())
+	require.Equal(t, append(largeValue(0), '!'), iter.Value())
+	require.True(t, iter.Next())
+	require.Equal(t, append(largeValue(1), '!'), iter.Value())
+	require.False(t, iter.Next())
+	require.True(t, iter.Last())
+	require.Equal(t, append(largeValue(1), '!'), iter.Value())
+	require.True(t, iter.Prev())
+	require.Equal(t, append(largeValue(0), '!'), iter.Value())
+	require.False(t, iter.Prev())
+	require.NoError(t, iter.Close())
+}
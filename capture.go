@@ -0,0 +1,42 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/pebble/internal/private"
+
+// capturerHolder holds the DB's private.Capturer, which may be nil, within the
+// DB's atomic.Value, which requires a consistent concrete type.
+type capturerHolder struct {
+	c private.Capturer
+}
+
+// loadCapturer returns the Capturer of the DB's workload, or nil if its
+// workload isn't being captured.
+func (d *DB) loadCapturer() private.Capturer {
+	h, _ := d.capturer.Load().(capturerHolder)
+	return h.c
+}
+
+// setCapturer is a hook for installing a Capturer on a DB. Its first parameter
+// is a *pebble.DB and its second the Capturer, or nil to stop capturing the
+// DB's workload.
+//
+// This function is used by the replay package to capture a DB's workload.
+func setCapturer(untypedDB interface{}, c private.Capturer) {
+	d := untypedDB.(*DB)
+	d.capturer.Store(capturerHolder{c: c})
+}
+
+// captureOp records an operation on the iterator if its DB's workload is
+// being captured.
+func (i *Iterator) captureOp(op private.IterOp, key, limit []byte) {
+	if i.capture != nil {
+		i.capture.CaptureOp(op, key, limit)
+	}
+}
+
+func init() {
+	private.SetCapturer = setCapturer
+}
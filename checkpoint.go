@@ -6,9 +6,12 @@ package pebble
 
 import (
 	"os"
+	"sync/atomic"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
 )
@@ -19,6 +22,10 @@ type checkpointOptions struct {
 	// flushWAL set to true will force a flush and sync of the WAL prior to
 	// checkpointing.
 	flushWAL bool
+
+	// If set, any sstables that don't overlap with these spans are excluded
+	// from the checkpoint.
+	restrictToSpans []KeyRange
 }
 
 // CheckpointOption set optional parameters used by `DB.Checkpoint`.
@@ -39,6 +46,22 @@ func WithFlushedWAL() CheckpointOption {
 	}
 }
 
+// WithRestrictToSpans specifies spans of interest for the checkpoint. Any
+// sstables that don't overlap with any of these spans are excluded from the
+// checkpoint, and the checkpoint's MANIFEST describes only the included
+// sstables.
+//
+// Note that the checkpoint may still surface keys outside of these spans, from
+// sstables that partially overlap the spans and from the WALs, which are
+// copied in their entirety. Moreover, keys outside of the spans may not be
+// consistent: for example, a key's tombstone may be excluded while an older
+// value of the key is included.
+func WithRestrictToSpans(spans []KeyRange) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.restrictToSpans = spans
+	}
+}
+
 // mkdirAllAndSyncParents creates destDir and any of its missing parents.
 // Those missing parents, as well as the closest existing ancestor, are synced.
 // Returns a handle to the directory created at destDir.
@@ -138,20 +161,43 @@ func (d *DB) Checkpoint(
 	// we read, otherwise we might copy a versionEdit not reflected in the
 	// sstables we copy/link.
 	d.mu.versions.logLock()
-	// Get the unflushed log files, the current version, and the current manifest
-	// file number.
+	// Get the unflushed log files and their directories, the current version,
+	// and the current manifest file number.
 	memQueue := d.mu.mem.queue
+	logDirs := make(map[FileNum]string, len(d.mu.log.queue))
+	for _, fi := range d.mu.log.queue {
+		logDirs[fi.fileNum] = d.logDirname(fi)
+	}
 	current := d.mu.versions.currentVersion()
 	formatVers := d.mu.formatVers.vers
 	manifestFileNum := d.mu.versions.manifestFileNum
 	manifestSize := d.mu.versions.manifest.Size()
 	optionsFileNum := d.optionsFileNum
+	// A restricted checkpoint writes a new MANIFEST rather than copying the
+	// existing one, so capture the state needed to describe the LSM.
+	manifestState := versionEdit{
+		ComparerName:       d.mu.versions.cmpName,
+		MinUnflushedLogNum: d.mu.versions.minUnflushedLogNum,
+		NextFileNum:        d.mu.versions.nextFileNum,
+		LastSeqNum:         atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum) - 1,
+	}
 
 	// Release the manifest and DB.mu so we don't block other operations on
 	// the database.
 	d.mu.versions.logUnlock()
 	d.mu.Unlock()
 
+	// A checkpoint's sstables are linked or copied into its directory, which
+	// isn't possible for sstables stored remotely.
+	for l := range current.Levels {
+		iter := current.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.RemoteLocator != "" {
+				return errors.Errorf("pebble: cannot checkpoint remote sstable %s", f.FileNum)
+			}
+		}
+	}
+
 	// Wrap the normal filesystem with one which wraps newly created files with
 	// vfs.NewSyncingFile.
 	fs := syncingFS{
@@ -223,7 +269,22 @@ func (d *DB) Checkpoint(
 		// copy.
 		srcPath := base.MakeFilepath(fs, d.dirname, fileTypeManifest, manifestFileNum)
 		destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-		ckErr = vfs.LimitedCopy(fs, srcPath, destPath, manifestSize)
+		if opt.restrictToSpans != nil {
+			// Write a MANIFEST containing a single snapshot of the subset of
+			// the sstables that overlap the spans.
+			ve := manifestState
+			for l := range current.Levels {
+				iter := current.Levels[l].Iter()
+				for f := iter.First(); f != nil; f = iter.Next() {
+					if d.overlapsSpans(f, opt.restrictToSpans) {
+						ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: l, Meta: f})
+					}
+				}
+			}
+			ckErr = writeCheckpointManifest(fs, destPath, &ve)
+		} else {
+			ckErr = vfs.LimitedCopy(fs, srcPath, destPath, manifestSize)
+		}
 		if ckErr != nil {
 			return ckErr
 		}
@@ -248,11 +309,34 @@ func (d *DB) Checkpoint(
 		}
 	}
 
-	// Link or copy the sstables.
+	// Link or copy the sstables, and the blob files they reference. Virtual
+	// sstables may share a backing sstable, and sstables may share blob
+	// files, which need only be linked or copied once.
+	copied := make(map[FileNum]struct{})
 	for l := range current.Levels {
 		iter := current.Levels[l].Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
-			srcPath := base.MakeFilepath(fs, d.dirname, fileTypeTable, f.FileNum)
+			if opt.restrictToSpans != nil && !d.overlapsSpans(f, opt.restrictToSpans) {
+				continue
+			}
+			for _, ref := range f.BlobReferences {
+				if _, ok := copied[ref.FileNum]; ok {
+					continue
+				}
+				copied[ref.FileNum] = struct{}{}
+				srcPath := base.MakeFilepath(fs, d.dirname, fileTypeBlob, ref.FileNum)
+				destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
+				ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
+				if ckErr != nil {
+					return ckErr
+				}
+			}
+			fileNum := f.PhysicalFileNum()
+			if _, ok := copied[fileNum]; ok {
+				continue
+			}
+			copied[fileNum] = struct{}{}
+			srcPath := base.MakeFilepath(fs, d.dirname, fileTypeTable, fileNum)
 			destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
 			ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
 			if ckErr != nil {
@@ -269,7 +353,8 @@ func (d *DB) Checkpoint(
 		if logNum == 0 {
 			continue
 		}
-		srcPath := base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum)
+		// NB: The WAL may reside in the WAL failover secondary directory.
+		srcPath := base.MakeFilepath(fs, logDirs[logNum], fileTypeLog, logNum)
 		destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
 		ckErr = vfs.Copy(fs, srcPath, destPath)
 		if ckErr != nil {
@@ -286,3 +371,38 @@ func (d *DB) Checkpoint(
 	dir = nil
 	return ckErr
 }
+
+// overlapsSpans returns true if the user key bounds of the sstable overlap any
+// of the provided spans.
+func (d *DB) overlapsSpans(f *fileMetadata, spans []KeyRange) bool {
+	for _, s := range spans {
+		if d.cmp(f.Smallest.UserKey, s.End) < 0 && d.cmp(s.Start, f.Largest.UserKey) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCheckpointManifest writes a MANIFEST at path containing the single
+// version edit ve.
+func writeCheckpointManifest(fs vfs.FS, path string, ve *versionEdit) (err error) {
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = firstError(err, f.Close())
+	}()
+	rw := record.NewWriter(f)
+	w, err := rw.Next()
+	if err != nil {
+		return err
+	}
+	if err := ve.Encode(w); err != nil {
+		return err
+	}
+	if err := rw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
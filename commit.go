@@ -8,8 +8,10 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/record"
 )
 
@@ -125,6 +127,9 @@ type commitEnv struct {
 	// The visible sequence number at which reads should be performed. Ratcheted
 	// upwards atomically as batches are applied to the memtable.
 	visibleSeqNum *uint64
+	// The sequence number below which all batches have been synced to the WAL.
+	// Ratcheted upwards atomically as synced batches commit. May be nil.
+	syncedSeqNum *uint64
 
 	// Apply the batch to the specified memtable. Called concurrently.
 	apply func(b *Batch, mem *memTable) error
@@ -134,6 +139,13 @@ type commitEnv struct {
 	// the memtable the batch should be applied to. Serial execution enforced by
 	// commitPipeline.mu.
 	write func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error)
+
+	// The duration beyond which a stage of a commit is reported through
+	// stall. No stalls are reported if zero.
+	stallThreshold time.Duration
+	// Invoked when a stage of a commit exceeds stallThreshold. May be nil.
+	// Called concurrently.
+	stall func(info CommitPipelineStallInfo)
 }
 
 // A commitPipeline manages the stages of committing a set of mutations
@@ -217,10 +229,23 @@ type commitPipeline struct {
 	// Queue of pending batches to commit.
 	pending commitQueue
 	env     commitEnv
-	sem     chan struct{}
+	// sem bounds the number of concurrent commits. A commit takes a slot from
+	// sem, through which the progress of the commit is tracked (see
+	// commitSlot), and returns the slot once the commit completes.
+	sem   chan *commitSlot
+	slots []commitSlot
 	// The mutex to use for synchronizing access to logSeqNum and serializing
 	// calls to commitEnv.write().
 	mu sync.Mutex
+	// latency holds the distributions of the latencies of the stages of
+	// sampled commits in microseconds, indexed by CommitStage.
+	latency [numCommitStages]commitLatencyHistogram
+	// latencySampleInterval is the interval, in commits through each slot, at
+	// which the latencies of commits are sampled.
+	latencySampleInterval uint32
+	// stopStallWatch, if non-nil, stops the goroutine detecting stalled
+	// commits when closed.
+	stopStallWatch chan struct{}
 }
 
 func newCommitPipeline(env commitEnv) *commitPipeline {
@@ -229,20 +254,54 @@ func newCommitPipeline(env commitEnv) *commitPipeline {
 		// NB: the commit concurrency is one less than SyncConcurrency because we
 		// have to allow one "slot" for a concurrent WAL rotation which will close
 		// and sync the WAL.
-		sem: make(chan struct{}, record.SyncConcurrency-1),
+		sem:                   make(chan *commitSlot, record.SyncConcurrency-1),
+		slots:                 make([]commitSlot, record.SyncConcurrency-1),
+		latencySampleInterval: commitLatencySampleInterval,
+	}
+	for i := range p.slots {
+		p.sem <- &p.slots[i]
+	}
+	if env.stall != nil && env.stallThreshold > 0 {
+		p.stopStallWatch = make(chan struct{})
+		go p.watchStalls()
 	}
 	return p
 }
 
+// Close stops the detection of stalled commits.
+func (p *commitPipeline) Close() {
+	if p.stopStallWatch != nil {
+		close(p.stopStallWatch)
+	}
+}
+
 // Commit the specified batch, writing it to the WAL, optionally syncing the
 // WAL, and applying the batch to the memtable. Upon successful return the
 // batch's mutations will be visible for reading.
-func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
+//
+// If syncWAL and noSyncWait are both true, Commit returns without waiting for
+// the WAL sync, which may still be in progress. The caller must then wait for
+// it through Batch.SyncWait, which returns any error syncing the WAL.
+func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	if b.Empty() {
 		return nil
 	}
 
-	p.sem <- struct{}{}
+	if b.Count() == invalidBatchCount {
+		b.db = nil // prevent batch reuse on error
+		return ErrInvalidBatch
+	}
+
+	s := <-p.sem
+	timer := s.begin(p)
+
+	// A batch committed without waiting for its WAL sync hands its slot off to
+	// a goroutine waiting for the sync, which releases it.
+	var async *asyncSync
+	if syncWAL && noSyncWait {
+		async = &asyncSync{}
+		b.fsyncWait.Add(1)
+	}
 
 	// Prepare the batch for committing: enqueuing the batch in the pending
 	// queue, determining the batch sequence number and writing the data to the
@@ -250,29 +309,78 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
 	//
 	// NB: We set Batch.commitErr on error so that the batch won't be a candidate
 	// for reuse. See Batch.release().
-	mem, err := p.prepare(b, syncWAL)
+	s.setStage(CommitStageWALWrite)
+	mem, err := p.prepare(b, syncWAL, async)
 	if err != nil {
 		b.db = nil // prevent batch reuse on error
+		// NB: the slot isn't released as the batch remains in the pending
+		// queue, but it's marked idle so that it isn't reported as stalled.
+		s.setIdle()
+		p.abandonAsyncSync(b, async, err)
 		return err
 	}
+	timer.record(CommitStageWALWrite)
+	// The sequence number following the batch's, which is durable once the
+	// batch's WAL sync completes. It's computed before the batch is applied,
+	// as the caller of a large batch clears its contents once it's committed.
+	syncedSeqNum := b.SeqNum() + uint64(b.Count())
 
 	// Apply the batch to the memtable.
+	s.setStage(CommitStageMemTableApply)
 	if err := p.env.apply(b, mem); err != nil {
 		b.db = nil // prevent batch reuse on error
+		s.setIdle()
+		p.abandonAsyncSync(b, async, err)
 		return err
 	}
+	timer.record(CommitStageMemTableApply)
 
 	// Publish the batch sequence number.
+	s.setStage(CommitStagePublish)
 	p.publish(b)
-
-	<-p.sem
+	timer.record(CommitStagePublish)
+
+	switch {
+	case syncWAL && noSyncWait:
+		// The batch's slot in the semaphore is released once its WAL sync
+		// completes, bounding the syncs queued in the WAL writer.
+		s.setStage(CommitStageWALSync)
+		go p.awaitAsyncSync(b, async, s, syncedSeqNum)
+		return nil
+	case syncWAL:
+		// Wait for the WAL sync, which may have completed while the batch was
+		// applied and published.
+		s.setStage(CommitStageWALSync)
+		b.fsyncWait.Wait()
+		timer.record(CommitStageWALSync)
+	}
+	s.setIdle()
+	p.sem <- s
 
 	if b.commitErr != nil {
 		b.db = nil // prevent batch reuse on error
+	} else if syncWAL {
+		// Syncing the WAL persisted every batch written to it before b, all of
+		// which have lower sequence numbers.
+		p.ratchetSyncedSeqNum(syncedSeqNum)
 	}
 	return b.commitErr
 }
 
+// ratchetSyncedSeqNum ratchets the synced sequence number up to seqNum.
+func (p *commitPipeline) ratchetSyncedSeqNum(seqNum uint64) {
+	if p.env.syncedSeqNum == nil {
+		return
+	}
+	for {
+		curSeqNum := atomic.LoadUint64(p.env.syncedSeqNum)
+		if seqNum <= curSeqNum ||
+			atomic.CompareAndSwapUint64(p.env.syncedSeqNum, curSeqNum, seqNum) {
+			return
+		}
+	}
+}
+
 // AllocateSeqNum allocates count sequence numbers, invokes the prepare
 // callback, then the apply callback, and then publishes the sequence
 // numbers. AllocateSeqNum does not write to the WAL or add entries to the
@@ -281,6 +389,26 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool) error {
 // invoked with commitPipeline.mu held, but note that DB.mu is not held and
 // must be locked if necessary.
 func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(seqNum uint64)) {
+	_ = p.allocateSeqNum(count, 0 /* seqNum */, prepare, apply)
+}
+
+// AllocateSeqNumAt is like AllocateSeqNum, but allocates the count sequence
+// numbers beginning at seqNum rather than at the next sequence number. The
+// sequence numbers between the next sequence number and seqNum are skipped.
+// If seqNum is less than the next sequence number, AllocateSeqNumAt returns
+// an error without invoking either callback.
+func (p *commitPipeline) AllocateSeqNumAt(
+	seqNum uint64, count int, prepare func(), apply func(seqNum uint64),
+) error {
+	if seqNum == 0 {
+		return errors.New("pebble: cannot allocate the zero sequence number")
+	}
+	return p.allocateSeqNum(count, seqNum, prepare, apply)
+}
+
+func (p *commitPipeline) allocateSeqNum(
+	count int, atSeqNum uint64, prepare func(), apply func(seqNum uint64),
+) error {
 	// This method is similar to Commit and prepare. Be careful about trying to
 	// share additional code with those methods because Commit and prepare are
 	// performance critical code paths.
@@ -294,10 +422,22 @@ func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(se
 	b.setCount(uint32(count))
 	b.commit.Add(1)
 
-	p.sem <- struct{}{}
+	s := <-p.sem
 
 	p.mu.Lock()
 
+	if atSeqNum != 0 {
+		// Verify the requested sequence number has not already been
+		// allocated. commitPipeline.mu prevents other goroutines from
+		// allocating sequence numbers concurrently.
+		if next := atomic.LoadUint64(p.env.logSeqNum); atSeqNum < next {
+			p.mu.Unlock()
+			p.sem <- s
+			return errors.Errorf("pebble: sequence number %d is less than the next sequence number %d",
+				errors.Safe(atSeqNum), errors.Safe(next))
+		}
+	}
+
 	// Enqueue the batch in the pending queue. Note that while the pending queue
 	// is lock-free, we want the order of batches to be the same as the sequence
 	// number order.
@@ -306,8 +446,15 @@ func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(se
 	// Assign the batch a sequence number. Note that we use atomic operations
 	// here to handle concurrent reads of logSeqNum. commitPipeline.mu provides
 	// mutual exclusion for other goroutines writing to logSeqNum.
-	logSeqNum := atomic.AddUint64(p.env.logSeqNum, uint64(count)) - uint64(count)
-	seqNum := logSeqNum
+	var logSeqNum, seqNum uint64
+	if atSeqNum != 0 {
+		logSeqNum = atomic.LoadUint64(p.env.logSeqNum)
+		atomic.StoreUint64(p.env.logSeqNum, atSeqNum+uint64(count))
+		seqNum = atSeqNum
+	} else {
+		logSeqNum = atomic.AddUint64(p.env.logSeqNum, uint64(count)) - uint64(count)
+		seqNum = logSeqNum
+	}
 	if seqNum == 0 {
 		// We can't use the value 0 for the global seqnum during ingestion, because
 		// 0 indicates no global seqnum. So allocate one more seqnum.
@@ -341,26 +488,28 @@ func (p *commitPipeline) AllocateSeqNum(count int, prepare func(), apply func(se
 	// Publish the sequence number.
 	p.publish(b)
 
-	<-p.sem
+	p.sem <- s
+	return nil
 }
 
-func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
+// prepare enqueues the batch in the pending queue, assigns its sequence number
+// and writes it to the WAL. If the batch is committed without waiting for its
+// WAL sync, async tracks the sync.
+func (p *commitPipeline) prepare(b *Batch, syncWAL bool, async *asyncSync) (*memTable, error) {
 	n := uint64(b.Count())
-	if n == invalidBatchCount {
-		return nil, ErrInvalidBatch
-	}
-	count := 1
-	if syncWAL {
-		count++
-	}
-	// count represents the waiting needed for publish, and optionally the
-	// waiting needed for the WAL sync.
-	b.commit.Add(count)
+	// b.commit is signaled once the batch is published. The WAL sync, if
+	// any, is waited for separately so that its latency may be recorded.
+	b.commit.Add(1)
 
 	var syncWG *sync.WaitGroup
 	var syncErr *error
-	if syncWAL {
-		syncWG, syncErr = &b.commit, &b.commitErr
+	switch {
+	case async != nil:
+		async.wg.Add(1)
+		syncWG, syncErr = &async.wg, &async.err
+	case syncWAL:
+		b.fsyncWait.Add(1)
+		syncWG, syncErr = &b.fsyncWait, &b.commitErr
 	}
 
 	p.mu.Lock()
@@ -383,6 +532,44 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool) (*memTable, error) {
 	return mem, err
 }
 
+// asyncSync tracks the WAL sync of a batch committed without waiting for it.
+// The WAL writer signals wg once it has synced the batch, setting err to any
+// error syncing it.
+type asyncSync struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+// awaitAsyncSync waits for the WAL sync of a batch committed without waiting
+// for it, and then releases the batch's slot s and Batch.SyncWait. The batch
+// isn't otherwise accessed, as the caller may clear its contents once it's
+// committed. syncedSeqNum is the sequence number following the batch's.
+func (p *commitPipeline) awaitAsyncSync(
+	b *Batch, async *asyncSync, s *commitSlot, syncedSeqNum uint64,
+) {
+	async.wg.Wait()
+	if async.err != nil {
+		b.commitErr = async.err
+	} else {
+		p.ratchetSyncedSeqNum(syncedSeqNum)
+	}
+	s.setIdle()
+	p.sem <- s
+	b.fsyncWait.Done()
+}
+
+// abandonAsyncSync releases Batch.SyncWait of a batch committed without
+// waiting for its WAL sync if the commit failed with err, which SyncWait
+// returns. The WAL sync, if the batch was written to the WAL, isn't waited
+// for.
+func (p *commitPipeline) abandonAsyncSync(b *Batch, async *asyncSync, err error) {
+	if async == nil {
+		return
+	}
+	b.commitErr = err
+	b.fsyncWait.Done()
+}
+
 func (p *commitPipeline) publish(b *Batch) {
 	// Mark the batch as applied.
 	atomic.StoreUint32(&b.applied, 1)
@@ -397,8 +584,7 @@ func (p *commitPipeline) publish(b *Batch) {
 	for {
 		t := p.pending.dequeue()
 		if t == nil {
-			// Wait for another goroutine to publish us. We might also be waiting for
-			// the WAL sync to finish.
+			// Wait for another goroutine to publish us.
 			b.commit.Wait()
 			break
 		}
@@ -0,0 +1,212 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// commitLatencySampleInterval is the default interval at which the latencies
+// of commits are sampled: the latencies of one of every
+// commitLatencySampleInterval commits are recorded.
+const commitLatencySampleInterval = 16
+
+// minStallCheckInterval is the minimum interval at which the commit slots are
+// checked for stalled commits.
+const minStallCheckInterval = time.Millisecond
+
+// commitSlot is a slot in the commit pipeline's semaphore. It tracks the stage
+// of the commit holding it, so that a stalled commit may be detected while the
+// stage is in progress.
+type commitSlot struct {
+	// state is the stage of the commit holding the slot, tagged with the
+	// slot's generation, which distinguishes successive commits through the
+	// slot. It's encoded as gen<<8 | (stage+1), with a zero stage if the slot
+	// is idle. Written by the holder of the slot and read atomically by
+	// commitPipeline.watchStalls.
+	state uint64
+	// gen and commits are only accessed by the holder of the slot.
+	gen     uint64
+	commits uint32
+	// Pad the slot to a cache line, as the slots of concurrent commits are
+	// updated concurrently.
+	_ [44]byte
+}
+
+// begin is called by a commit upon acquiring the slot. It returns the timer
+// for the latencies of the commit's stages.
+func (s *commitSlot) begin(p *commitPipeline) commitTimer {
+	s.gen++
+	s.commits++
+	t := commitTimer{p: p}
+	if s.commits%p.latencySampleInterval == 0 {
+		t.sampled = true
+		t.start = time.Now()
+	}
+	return t
+}
+
+// setStage records that the commit holding the slot entered the stage.
+func (s *commitSlot) setStage(stage CommitStage) {
+	atomic.StoreUint64(&s.state, s.gen<<8|uint64(stage+1))
+}
+
+// setIdle records that the commit holding the slot is done with it.
+func (s *commitSlot) setIdle() {
+	atomic.StoreUint64(&s.state, s.gen<<8)
+}
+
+// commitSlotStage returns the stage encoded in a commitSlot's state, and false
+// if the slot is idle.
+func commitSlotStage(state uint64) (CommitStage, bool) {
+	stage := state & (1<<8 - 1)
+	return CommitStage(stage) - 1, stage != 0
+}
+
+// commitTimer measures the latencies of the stages of a commit. Only sampled
+// commits are timed, sparing the remaining commits calls to time.Now.
+type commitTimer struct {
+	p       *commitPipeline
+	start   time.Time
+	sampled bool
+}
+
+// record records the latency of the stage, which just completed, if the commit
+// is sampled.
+func (t *commitTimer) record(stage CommitStage) {
+	if !t.sampled {
+		return
+	}
+	now := time.Now()
+	t.p.latency[stage].record(now.Sub(t.start))
+	t.start = now
+}
+
+// commitLatencySubBucketBits is the number of bits of precision of the buckets
+// of a commitLatencyHistogram below the most significant bit of a value.
+const commitLatencySubBucketBits = 3
+
+// commitLatencyBuckets is the number of buckets of a commitLatencyHistogram,
+// covering every uint64 value.
+const commitLatencyBuckets = (64 - commitLatencySubBucketBits + 1) << commitLatencySubBucketBits
+
+// commitLatencyHistogram is a lock-free histogram of latencies in
+// microseconds. Values are counted in log-linear buckets with a relative error
+// of at most 1/2^commitLatencySubBucketBits, and converted to an
+// hdrhistogram.Histogram when read.
+type commitLatencyHistogram struct {
+	counts [commitLatencyBuckets]uint64
+}
+
+// record records the latency d.
+func (h *commitLatencyHistogram) record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddUint64(&h.counts[commitLatencyBucket(uint64(v))], 1)
+}
+
+// commitLatencyBucket returns the index of the bucket counting v.
+func commitLatencyBucket(v uint64) int {
+	const sub = 1 << commitLatencySubBucketBits
+	if v < sub {
+		return int(v)
+	}
+	// The bucket is determined by the position of the most significant bit of
+	// v and the commitLatencySubBucketBits bits below it.
+	shift := bits.Len64(v) - commitLatencySubBucketBits - 1
+	return (shift+1)<<commitLatencySubBucketBits + int(v>>uint(shift)) - sub
+}
+
+// commitLatencyBucketMidpoint returns the midpoint of the values counted by
+// the bucket i.
+func commitLatencyBucketMidpoint(i int) int64 {
+	const sub = 1 << commitLatencySubBucketBits
+	if i < 2*sub {
+		return int64(i)
+	}
+	shift := uint(i>>commitLatencySubBucketBits - 1)
+	low := uint64(i&(sub-1)+sub) << shift
+	return int64(low + (1<<shift)/2)
+}
+
+// histogram returns the recorded latencies as an hdrhistogram.Histogram with
+// a maximum value of 30s, as for the WAL writer's sync latencies. Latencies
+// beyond the maximum are recorded as the maximum.
+func (h *commitLatencyHistogram) histogram() *hdrhistogram.Histogram {
+	hist := hdrhistogram.New(0, (30 * time.Second).Microseconds(), 2)
+	for i := range h.counts {
+		n := atomic.LoadUint64(&h.counts[i])
+		if n == 0 {
+			continue
+		}
+		v := commitLatencyBucketMidpoint(i)
+		if v > hist.HighestTrackableValue() {
+			v = hist.HighestTrackableValue()
+		}
+		_ = hist.RecordValues(v, int64(n))
+	}
+	return hist
+}
+
+// latencyHistograms returns the distributions of the latencies of the stages
+// of the sampled commits, indexed by CommitStage.
+func (p *commitPipeline) latencyHistograms() [numCommitStages]*hdrhistogram.Histogram {
+	var hists [numCommitStages]*hdrhistogram.Histogram
+	for i := range p.latency {
+		hists[i] = p.latency[i].histogram()
+	}
+	return hists
+}
+
+// watchStalls periodically checks the commit slots for a commit which has
+// remained in a stage for longer than the stall threshold, reporting each such
+// stage of a commit once, while the stage is in progress. It runs until
+// p.stopStallWatch is closed.
+func (p *commitPipeline) watchStalls() {
+	interval := p.env.stallThreshold / 4
+	if interval < minStallCheckInterval {
+		interval = minStallCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// observed holds, for each slot, the last observed state of the slot, when
+	// it was first observed, and whether a stall was reported for it.
+	type observation struct {
+		state    uint64
+		since    time.Time
+		reported bool
+	}
+	observed := make([]observation, len(p.slots))
+	for {
+		select {
+		case <-p.stopStallWatch:
+			return
+		case now := <-ticker.C:
+			for i := range p.slots {
+				o := &observed[i]
+				state := atomic.LoadUint64(&p.slots[i].state)
+				if state != o.state {
+					*o = observation{state: state, since: now}
+					continue
+				}
+				stage, ok := commitSlotStage(state)
+				if !ok || o.reported {
+					continue
+				}
+				if d := now.Sub(o.since); d >= p.env.stallThreshold {
+					o.reported = true
+					p.env.stall(CommitPipelineStallInfo{Stage: stage, Duration: d})
+				}
+			}
+		}
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/record"
@@ -99,7 +101,7 @@ func TestCommitPipeline(t *testing.T) {
 			defer wg.Done()
 			var b Batch
 			_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
-			_ = p.Commit(&b, false)
+			_ = p.Commit(&b, false, false)
 		}(i)
 	}
 	wg.Wait()
@@ -213,7 +215,7 @@ func TestCommitPipelineWALClose(t *testing.T) {
 				errCh <- err
 				return
 			}
-			errCh <- p.Commit(b, true /* sync */)
+			errCh <- p.Commit(b, true /* sync */, false /* noSyncWait */)
 		}(i)
 	}
 
@@ -230,6 +232,227 @@ func TestCommitPipelineWALClose(t *testing.T) {
 	}
 }
 
+func TestCommitPipelineNoSyncWait(t *testing.T) {
+	var logSeqNum, syncedSeqNum uint64
+	var writeErr, applyErr error
+	syncDone := make(chan struct{})
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     &logSeqNum,
+		visibleSeqNum: new(uint64),
+		syncedSeqNum:  &syncedSeqNum,
+		apply: func(b *Batch, mem *memTable) error {
+			return applyErr
+		},
+		write: func(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
+			if writeErr != nil {
+				return nil, writeErr
+			}
+			go func() {
+				<-syncDone
+				syncWG.Done()
+			}()
+			return nil, nil
+		},
+	})
+	defer p.Close()
+
+	newBatch := func() *Batch {
+		b := &Batch{}
+		require.NoError(t, b.Set([]byte("a"), nil, nil))
+		require.NoError(t, b.Set([]byte("b"), nil, nil))
+		return b
+	}
+
+	// The synced sequence number is ratcheted past the batch once its WAL
+	// sync completes, even if the batch's contents were cleared after the
+	// commit, as they are for large batches.
+	logSeqNum = 10
+	b := newBatch()
+	require.NoError(t, p.Commit(b, true /* sync */, true /* noSyncWait */))
+	b.data = nil
+	require.Zero(t, atomic.LoadUint64(&syncedSeqNum))
+	close(syncDone)
+	require.NoError(t, b.SyncWait())
+	require.EqualValues(t, 12, atomic.LoadUint64(&syncedSeqNum))
+
+	// A commit that fails returns its error from SyncWait, rather than
+	// blocking it.
+	writeErr = errors.New("write failed")
+	b = newBatch()
+	require.Equal(t, writeErr, p.Commit(b, true /* sync */, true /* noSyncWait */))
+	require.Equal(t, writeErr, b.SyncWait())
+
+	writeErr, applyErr = nil, errors.New("apply failed")
+	b = newBatch()
+	require.Equal(t, applyErr, p.Commit(b, true /* sync */, true /* noSyncWait */))
+	require.Equal(t, applyErr, b.SyncWait())
+}
+
+// walSyncBlockingFS blocks the syncs of the WALs it creates, while blocking
+// is set, until a token is received from release.
+type walSyncBlockingFS struct {
+	vfs.FS
+	blocking *uint32
+	release  chan struct{}
+}
+
+func (fs walSyncBlockingFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil || !strings.HasSuffix(name, ".log") {
+		return f, err
+	}
+	return walSyncBlockingFile{File: f, fs: fs}, nil
+}
+
+type walSyncBlockingFile struct {
+	vfs.File
+	fs walSyncBlockingFS
+}
+
+func (f walSyncBlockingFile) Sync() error {
+	if atomic.LoadUint32(f.fs.blocking) == 1 {
+		<-f.fs.release
+	}
+	return f.File.Sync()
+}
+
+func TestCommitPipelineLatencyMetrics(t *testing.T) {
+	const threshold = 10 * time.Millisecond
+	const n = 5
+	// Each WAL sync is blocked until the stall of the commit waiting for it
+	// is reported, so that every synced commit stalls regardless of the
+	// speed of the machine.
+	fs := walSyncBlockingFS{
+		FS:       vfs.NewMem(),
+		blocking: new(uint32),
+		release:  make(chan struct{}, n),
+	}
+	var mu sync.Mutex
+	var stalls []CommitPipelineStallInfo
+	opts := &Options{
+		FS: fs,
+		EventListener: EventListener{
+			CommitPipelineStall: func(info CommitPipelineStallInfo) {
+				if info.Stage != CommitStageWALSync {
+					return
+				}
+				mu.Lock()
+				stalls = append(stalls, info)
+				mu.Unlock()
+				fs.release <- struct{}{}
+			},
+		},
+	}
+	opts.Experimental.CommitPipelineStallThreshold = threshold
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	// Sample the latencies of every commit.
+	d.commit.latencySampleInterval = 1
+
+	atomic.StoreUint32(fs.blocking, 1)
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprint(i)), nil, NoSync))
+	}
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprint(i)), nil, Sync))
+	}
+	atomic.StoreUint32(fs.blocking, 0)
+
+	// Every commit passes through the first three stages, but only synced
+	// commits wait for the WAL sync, which stalled.
+	m := d.Metrics()
+	require.EqualValues(t, 2*n, m.Commit.WALWriteLatencyMicros.TotalCount())
+	require.EqualValues(t, 2*n, m.Commit.MemTableApplyLatencyMicros.TotalCount())
+	require.EqualValues(t, 2*n, m.Commit.PublishLatencyMicros.TotalCount())
+	require.EqualValues(t, n, m.Commit.WALSyncLatencyMicros.TotalCount())
+	require.Greater(t, m.Commit.WALSyncLatencyMicros.Min(), (threshold / 2).Microseconds())
+
+	// Each synced commit reported a stall of its WAL sync.
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, stalls, n)
+	for _, s := range stalls {
+		require.GreaterOrEqual(t, s.Duration, threshold)
+	}
+}
+
+func TestCommitPipelineStallInProgress(t *testing.T) {
+	stalls := make(chan CommitPipelineStallInfo, 1)
+	unblock := make(chan struct{})
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     new(uint64),
+		visibleSeqNum: new(uint64),
+		apply: func(b *Batch, mem *memTable) error {
+			<-unblock
+			return nil
+		},
+		write: func(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
+			return nil, nil
+		},
+		stallThreshold: 10 * time.Millisecond,
+		stall: func(info CommitPipelineStallInfo) {
+			stalls <- info
+		},
+	})
+	defer p.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		b := &Batch{}
+		if err := b.Set([]byte("foo"), nil, nil); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- p.Commit(b, false /* sync */, false /* noSyncWait */)
+	}()
+
+	// The stall is reported while the memtable application remains blocked.
+	info := <-stalls
+	require.Equal(t, CommitStageMemTableApply, info.Stage)
+	require.GreaterOrEqual(t, info.Duration, 10*time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("commit completed before unblocking: %v", err)
+	default:
+	}
+	close(unblock)
+	require.NoError(t, <-errCh)
+
+	// The stall is reported once.
+	select {
+	case info := <-stalls:
+		t.Fatalf("unexpected stall: %s", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCommitLatencyHistogram(t *testing.T) {
+	var h commitLatencyHistogram
+	for _, d := range []time.Duration{
+		0, 7 * time.Microsecond, 100 * time.Microsecond, 5 * time.Millisecond, time.Second, time.Hour,
+	} {
+		h.record(d)
+	}
+	hist := h.histogram()
+	require.EqualValues(t, 6, hist.TotalCount())
+	require.EqualValues(t, 0, hist.Min())
+	require.InEpsilon(t, 100, hist.ValueAtQuantile(50), 0.125)
+	require.InEpsilon(t, 5000, hist.ValueAtQuantile(60), 0.125)
+	require.InEpsilon(t, 1e6, hist.ValueAtQuantile(80), 0.125)
+	require.InEpsilon(t, hist.HighestTrackableValue(), hist.Max(), 0.125)
+
+	// Every value is counted by a bucket whose midpoint is within the
+	// relative error of the value.
+	for _, v := range []uint64{0, 1, 7, 8, 15, 16, 17, 31, 32, 1000, 1 << 40, 1<<64 - 1} {
+		i := commitLatencyBucket(v)
+		require.Less(t, i, commitLatencyBuckets)
+		if v < 1<<62 {
+			require.InDelta(t, float64(v), float64(commitLatencyBucketMidpoint(i)), float64(v)/8+0.5)
+		}
+	}
+}
+
 func BenchmarkCommitPipeline(b *testing.B) {
 	for _, parallelism := range []int{1, 2, 4, 8, 16, 32, 64, 128} {
 		b.Run(fmt.Sprintf("parallel=%d", parallelism), func(b *testing.B) {
@@ -279,7 +502,7 @@ func BenchmarkCommitPipeline(b *testing.B) {
 					batch := newBatch(nil)
 					binary.BigEndian.PutUint64(buf, rng.Uint64())
 					batch.Set(buf, buf, nil)
-					if err := p.Commit(batch, true /* sync */); err != nil {
+					if err := p.Commit(batch, true /* sync */, false /* noSyncWait */); err != nil {
 						b.Fatal(err)
 					}
 					batch.release()
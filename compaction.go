@@ -24,6 +24,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/internal/rangedel"
 	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -147,6 +148,36 @@ func (f *fileSizeSplitter) onNewOutput(key *InternalKey) []byte {
 	return nil
 }
 
+// keyCountSplitter is a compactionOutputSplitter that splits outputs once the
+// current output contains maxKeys point keys. Like the fileSizeSplitter, it
+// may advise splits in the middle of a user key, and must be wrapped in a
+// userKeyChangeSplitter.
+type keyCountSplitter struct {
+	maxKeys uint64
+	count   uint64
+}
+
+func (k *keyCountSplitter) shouldSplitBefore(
+	key *InternalKey, tw *sstable.Writer,
+) compactionSplitSuggestion {
+	if tw == nil {
+		k.count = 0
+	}
+	if key.Kind() == InternalKeyKindRangeDelete || rangekey.IsRangeKey(key.Kind()) {
+		return noSplit
+	}
+	if k.count >= k.maxKeys {
+		return splitNow
+	}
+	k.count++
+	return noSplit
+}
+
+func (k *keyCountSplitter) onNewOutput(key *InternalKey) []byte {
+	k.count = 0
+	return nil
+}
+
 type limitFuncSplitter struct {
 	c         *compaction
 	limitFunc func(userKey []byte) []byte
@@ -256,11 +287,32 @@ func (u *userKeyChangeSplitter) onNewOutput(key *InternalKey) []byte {
 	return u.splitter.onNewOutput(key)
 }
 
+// optionsSplitter is a compactionOutputSplitter that defers to the
+// OutputSplitter constructed by Options.Experimental.NewOutputSplitter. Like
+// the fileSizeSplitter, it may advise splits in the middle of a user key, and
+// must be wrapped in a userKeyChangeSplitter.
+type optionsSplitter struct {
+	splitter OutputSplitter
+}
+
+func (o *optionsSplitter) shouldSplitBefore(
+	key *InternalKey, tw *sstable.Writer,
+) compactionSplitSuggestion {
+	if o.splitter.ShouldSplitBefore(*key, tw) == sstable.SplitNow {
+		return splitNow
+	}
+	return noSplit
+}
+
+func (o *optionsSplitter) onNewOutput(key *InternalKey) []byte {
+	return nil
+}
+
 // compactionFile is a vfs.File wrapper that, on every write, updates a metric
 // in `versions` on bytes written by in-progress compactions so far. It also
 // increments a per-compaction `written` int.
 type compactionFile struct {
-	vfs.File
+	objstorage.Writable
 
 	versions *versionSet
 	written  *int64
@@ -268,7 +320,7 @@ type compactionFile struct {
 
 // Write implements the io.Writer interface.
 func (c *compactionFile) Write(p []byte) (n int, err error) {
-	n, err = c.File.Write(p)
+	n, err = c.Writable.Write(p)
 	if err != nil {
 		return n, err
 	}
@@ -278,34 +330,63 @@ func (c *compactionFile) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
-type compactionKind int
+// CompactionKind identifies the kind of a compaction, which determines how it
+// was picked and how its inputs are processed.
+type CompactionKind int
 
 const (
-	compactionKindDefault compactionKind = iota
-	compactionKindFlush
-	compactionKindMove
-	compactionKindDeleteOnly
-	compactionKindElisionOnly
-	compactionKindRead
-	compactionKindRewrite
+	// CompactionKindDefault is a compaction picked to reduce the size or
+	// sublevel count of a level, or requested through DB.Compact.
+	CompactionKindDefault CompactionKind = iota
+	// CompactionKindFlush is a flush of one or more memtables to L0.
+	CompactionKindFlush
+	// CompactionKindMove is a compaction that moves an sstable to the next
+	// level without rewriting it.
+	CompactionKindMove
+	// CompactionKindDeleteOnly is a compaction that deletes sstables covered
+	// by range tombstones without reading them.
+	CompactionKindDeleteOnly
+	// CompactionKindElisionOnly is a compaction of a single bottommost
+	// sstable that rewrites it to elide its obsolete keys.
+	CompactionKindElisionOnly
+	// CompactionKindRead is a compaction triggered by reads of overlapping
+	// sstables.
+	CompactionKindRead
+	// CompactionKindRewrite is a compaction that rewrites sstables marked for
+	// compaction.
+	CompactionKindRewrite
+	// CompactionKindTombstoneDensity is a compaction of an sstable whose
+	// tombstone density exceeds
+	// Options.Experimental.TombstoneDensityCompactionThreshold into the next
+	// level.
+	CompactionKindTombstoneDensity
+	// CompactionKindFilterRewrite is a compaction that rewrites the filter
+	// block of an sstable, copying the remainder of the sstable verbatim. See
+	// DB.RewriteFilters.
+	CompactionKindFilterRewrite
 )
 
-func (k compactionKind) String() string {
+// String implements fmt.Stringer.
+func (k CompactionKind) String() string {
 	switch k {
-	case compactionKindDefault:
+	case CompactionKindDefault:
 		return "default"
-	case compactionKindFlush:
+	case CompactionKindFlush:
 		return "flush"
-	case compactionKindMove:
+	case CompactionKindMove:
 		return "move"
-	case compactionKindDeleteOnly:
+	case CompactionKindDeleteOnly:
 		return "delete-only"
-	case compactionKindElisionOnly:
+	case CompactionKindElisionOnly:
 		return "elision-only"
-	case compactionKindRead:
+	case CompactionKindRead:
 		return "read"
-	case compactionKindRewrite:
+	case CompactionKindRewrite:
 		return "rewrite"
+	case CompactionKindTombstoneDensity:
+		return "tombstone-density"
+	case CompactionKindFilterRewrite:
+		return "filter-rewrite"
 	}
 	return "?"
 }
@@ -315,7 +396,7 @@ func (k compactionKind) String() string {
 // keys in the last snapshot stripe, as well as coalesce range keys within
 // snapshot stripes.
 func rangeKeyCompactionTransform(
-	snapshots []uint64, elideRangeKey func(start, end []byte) bool,
+	snapshots []uint64, elideRangeKey func(start, end []byte) bool, merge RangeKeyMerge,
 ) keyspan.Transformer {
 	return keyspan.TransformerFunc(func(cmp base.Compare, s keyspan.Span, dst *keyspan.Span) error {
 		elideInLastStripe := func(keys []keyspan.Key) []keyspan.Key {
@@ -348,7 +429,7 @@ func rangeKeyCompactionTransform(
 			}
 			if j > start {
 				keysDst := dst.Keys[usedLen:cap(dst.Keys)]
-				if err := rangekey.Coalesce(cmp, s.Keys[start:j], &keysDst); err != nil {
+				if err := rangekey.Coalesce(cmp, merge, s.Keys[start:j], &keysDst); err != nil {
 					return err
 				}
 				if j == len(s.Keys) {
@@ -362,7 +443,7 @@ func rangeKeyCompactionTransform(
 		}
 		if j < len(s.Keys) {
 			keysDst := dst.Keys[usedLen:cap(dst.Keys)]
-			if err := rangekey.Coalesce(cmp, s.Keys[j:], &keysDst); err != nil {
+			if err := rangekey.Coalesce(cmp, merge, s.Keys[j:], &keysDst); err != nil {
 				return err
 			}
 			keysDst = elideInLastStripe(keysDst)
@@ -376,7 +457,7 @@ func rangeKeyCompactionTransform(
 // compaction is a table compaction from one level to the next, starting from a
 // given version.
 type compaction struct {
-	kind      compactionKind
+	kind      CompactionKind
 	cmp       Compare
 	equal     Equal
 	formatKey base.FormatKey
@@ -385,6 +466,10 @@ type compaction struct {
 
 	score float64
 
+	// rangeKeyMerge is the optional merge function for overlapping
+	// RangeKeySets, from Options.RangeKeyMerger.
+	rangeKeyMerge RangeKeyMerge
+
 	// startLevel is the level that is being compacted. Inputs from startLevel
 	// and outputLevel will be merged to produce a set of outputLevel files.
 	startLevel *compactionLevel
@@ -405,6 +490,9 @@ type compaction struct {
 	// maxOutputFileSize is the maximum size of an individual table created
 	// during compaction.
 	maxOutputFileSize uint64
+	// maxOutputTableKeys is the maximum number of point keys in an individual
+	// table created during compaction, or zero if unlimited.
+	maxOutputTableKeys uint64
 	// maxOverlapBytes is the maximum number of bytes of overlap allowed for a
 	// single output table with the tables in the grandparent level.
 	maxOverlapBytes uint64
@@ -508,19 +596,21 @@ func (c *compaction) makeInfo(jobID int) CompactionInfo {
 
 func newCompaction(pc *pickedCompaction, opts *Options) *compaction {
 	c := &compaction{
-		kind:              compactionKindDefault,
-		cmp:               pc.cmp,
-		equal:             opts.equal(),
-		formatKey:         opts.Comparer.FormatKey,
-		score:             pc.score,
-		inputs:            pc.inputs,
-		smallest:          pc.smallest,
-		largest:           pc.largest,
-		logger:            opts.Logger,
-		version:           pc.version,
-		maxOutputFileSize: pc.maxOutputFileSize,
-		maxOverlapBytes:   pc.maxOverlapBytes,
-		l0SublevelInfo:    pc.l0SublevelInfo,
+		kind:               CompactionKindDefault,
+		cmp:                pc.cmp,
+		equal:              opts.equal(),
+		formatKey:          opts.Comparer.FormatKey,
+		rangeKeyMerge:      opts.rangeKeyMerge(),
+		score:              pc.score,
+		inputs:             pc.inputs,
+		smallest:           pc.smallest,
+		largest:            pc.largest,
+		logger:             opts.Logger,
+		version:            pc.version,
+		maxOutputFileSize:  pc.maxOutputFileSize,
+		maxOutputTableKeys: pc.maxOutputTableKeys,
+		maxOverlapBytes:    pc.maxOverlapBytes,
+		l0SublevelInfo:     pc.l0SublevelInfo,
 	}
 	c.startLevel = &c.inputs[0]
 	c.outputLevel = &c.inputs[1]
@@ -538,20 +628,20 @@ func newCompaction(pc *pickedCompaction, opts *Options) *compaction {
 	c.setupInuseKeyRanges()
 
 	c.kind = pc.kind
-	if c.kind == compactionKindDefault && c.outputLevel.files.Empty() && !c.hasExtraLevelData() &&
+	if c.kind == CompactionKindDefault && c.outputLevel.files.Empty() && !c.hasExtraLevelData() &&
 		c.startLevel.files.Len() == 1 && c.grandparents.SizeSum() <= c.maxOverlapBytes {
 		// This compaction can be converted into a trivial move from one level
 		// to the next. We avoid such a move if there is lots of overlapping
 		// grandparent data. Otherwise, the move could create a parent file
 		// that will require a very expensive merge later on.
-		c.kind = compactionKindMove
+		c.kind = CompactionKindMove
 	}
 	return c
 }
 
 func newDeleteOnlyCompaction(opts *Options, cur *version, inputs []compactionLevel) *compaction {
 	c := &compaction{
-		kind:      compactionKindDeleteOnly,
+		kind:      CompactionKindDeleteOnly,
 		cmp:       opts.Comparer.Compare,
 		equal:     opts.equal(),
 		formatKey: opts.Comparer.FormatKey,
@@ -569,6 +659,31 @@ func newDeleteOnlyCompaction(opts *Options, cur *version, inputs []compactionLev
 	return c
 }
 
+// newFilterRewriteCompaction returns a compaction rewriting the filter block of
+// the physical sstable f within the provided level. The rewritten sstable
+// replaces f within the level.
+func newFilterRewriteCompaction(
+	opts *Options, cur *version, level int, f *fileMetadata,
+) *compaction {
+	c := &compaction{
+		kind:      CompactionKindFilterRewrite,
+		cmp:       opts.Comparer.Compare,
+		equal:     opts.equal(),
+		formatKey: opts.Comparer.FormatKey,
+		logger:    opts.Logger,
+		version:   cur,
+		inputs: []compactionLevel{
+			{level: level, files: manifest.NewLevelSliceKeySorted(opts.Comparer.Compare, []*fileMetadata{f})},
+			{level: level},
+		},
+		smallest: f.Smallest,
+		largest:  f.Largest,
+	}
+	c.startLevel = &c.inputs[0]
+	c.outputLevel = &c.inputs[1]
+	return c
+}
+
 func adjustGrandparentOverlapBytesForFlush(c *compaction, flushingBytes uint64) {
 	// Heuristic to place a lower bound on compaction output file size
 	// caused by Lbase. Prior to this heuristic we have observed an L0 in
@@ -638,10 +753,11 @@ func adjustGrandparentOverlapBytesForFlush(c *compaction, flushingBytes uint64)
 
 func newFlush(opts *Options, cur *version, baseLevel int, flushing flushableList) *compaction {
 	c := &compaction{
-		kind:              compactionKindFlush,
+		kind:              CompactionKindFlush,
 		cmp:               opts.Comparer.Compare,
 		equal:             opts.equal(),
 		formatKey:         opts.Comparer.FormatKey,
+		rangeKeyMerge:     opts.rangeKeyMerge(),
 		logger:            opts.Logger,
 		version:           cur,
 		inputs:            []compactionLevel{{level: -1}, {level: 0}},
@@ -708,6 +824,7 @@ func newFlush(opts *Options, cur *version, baseLevel int, flushing flushableList
 
 	if opts.FlushSplitBytes > 0 {
 		c.maxOutputFileSize = uint64(opts.Level(0).TargetFileSize)
+		c.maxOutputTableKeys = uint64(opts.Level(0).MaxTableKeys)
 		c.maxOverlapBytes = maxGrandparentOverlapBytes(opts, 0)
 		c.grandparents = c.version.Overlaps(baseLevel, c.cmp, c.smallest.UserKey,
 			c.largest.UserKey, c.largest.IsExclusiveSentinel())
@@ -1014,7 +1131,7 @@ func (c *compaction) newInputIter(
 			}
 			if rangeKeyIter := f.newRangeKeyIter(nil); rangeKeyIter != nil {
 				mi := &keyspan.MergingIter{}
-				mi.Init(c.cmp, rangeKeyCompactionTransform(snapshots, c.elideRangeKey), rangeKeyIter)
+				mi.Init(c.cmp, rangeKeyCompactionTransform(snapshots, c.elideRangeKey, c.rangeKeyMerge), rangeKeyIter)
 				c.rangeKeyInterleaving.Init(c.cmp, base.WrapIterWithStats(iter), mi, nil /* hooks */, nil /* lowerBound */, nil /* upperBound */)
 				iter = &c.rangeKeyInterleaving
 			}
@@ -1041,7 +1158,7 @@ func (c *compaction) newInputIter(
 		var iter base.InternalIteratorWithStats = newMergingIter(c.logger, c.cmp, nil, iters...)
 		if len(rangeKeyIters) > 0 {
 			mi := &keyspan.MergingIter{}
-			mi.Init(c.cmp, rangeKeyCompactionTransform(snapshots, c.elideRangeKey), rangeKeyIters...)
+			mi.Init(c.cmp, rangeKeyCompactionTransform(snapshots, c.elideRangeKey, c.rangeKeyMerge), rangeKeyIters...)
 			c.rangeKeyInterleaving.Init(c.cmp, base.WrapIterWithStats(iter), mi, nil /* hooks */, nil /* lowerBound */, nil /* upperBound */)
 			iter = &c.rangeKeyInterleaving
 		}
@@ -1115,7 +1232,7 @@ func (c *compaction) newInputIter(
 		f *manifest.FileMetadata, slice manifest.LevelSlice, _ *IterOptions, bytesIterated *uint64,
 	) (keyspan.FragmentIterator, error) {
 		iter, rangeDelIter, err := newIters(f, nil, /* iter options */
-			internalIterOpts{bytesIterated: &c.bytesIterated})
+			internalIterOpts{bytesIterated: &c.bytesIterated, compaction: true})
 		if err == nil {
 			// TODO(peter): It is mildly wasteful to open the point iterator only to
 			// immediately close it. One way to solve this would be to add new
@@ -1165,8 +1282,10 @@ func (c *compaction) newInputIter(
 	// TODO(bananabrick): Get rid of the extra manifest.Level parameter and fold it into
 	// compactionLevel.
 	addItersForLevel := func(level *compactionLevel, l manifest.Level) error {
-		iters = append(iters, newLevelIter(iterOpts, c.cmp, nil /* split */, newIters,
-			level.files.Iter(), l, &c.bytesIterated))
+		pointIter := &levelIter{}
+		pointIter.init(iterOpts, c.cmp, nil /* split */, newIters, level.files.Iter(), l,
+			internalIterOpts{bytesIterated: &c.bytesIterated, compaction: true})
+		iters = append(iters, pointIter)
 		// Create a wrapping closure to turn newRangeDelIter into a
 		// keyspan.TableNewSpanIter, and return a LevelIter that lazily creates
 		// rangedel iterators. This is safe now that range deletions are truncated
@@ -1249,7 +1368,7 @@ func (c *compaction) newInputIter(
 	pointKeyIter := newMergingIter(c.logger, c.cmp, nil, iters...)
 	if len(rangeKeyIters) > 0 {
 		mi := &keyspan.MergingIter{}
-		mi.Init(c.cmp, rangeKeyCompactionTransform(snapshots, c.elideRangeKey), rangeKeyIters...)
+		mi.Init(c.cmp, rangeKeyCompactionTransform(snapshots, c.elideRangeKey, c.rangeKeyMerge), rangeKeyIters...)
 		di := &keyspan.DefragmentingIter{}
 		di.Init(c.cmp, mi, keyspan.DefragmentInternal, keyspan.StaticDefragmentReducer)
 		c.rangeKeyInterleaving.Init(c.cmp, pointKeyIter, di, nil /* hooks */, nil /* lowerBound */, nil /* upperBound */)
@@ -1421,11 +1540,30 @@ func (d *DB) maybeScheduleFlush() {
 	if !d.passedFlushThreshold() {
 		return
 	}
+	// Once the queued memtables reach MemTableStopWritesThreshold, writes are
+	// stalled until a flush frees them, so the flush is admitted regardless
+	// of the CompactionScheduler.
+	if size, limit := d.queuedMemTableBytesLocked(); size < limit &&
+		!d.admitLocked(CompactionRequestFlush) {
+		return
+	}
 
 	d.mu.compact.flushing = true
 	go d.flush()
 }
 
+// queuedMemTableBytesLocked returns the total size of the queued memtables,
+// including the mutable memtable, and the size at which writes are stopped.
+//
+// d.mu must be held when calling this.
+func (d *DB) queuedMemTableBytesLocked() (size, limit uint64) {
+	for i := range d.mu.mem.queue {
+		size += d.mu.mem.queue[i].totalBytes()
+	}
+	limit = uint64(d.opts.MemTableStopWritesThreshold) * uint64(d.opts.MemTableSize)
+	return size, limit
+}
+
 func (d *DB) passedFlushThreshold() bool {
 	var n int
 	var size uint64
@@ -1611,7 +1749,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 	bytesFlushed = c.bytesIterated
 	d.maybeUpdateDeleteCompactionHints(c)
 	d.removeInProgressCompaction(c)
-	d.mu.versions.incrementCompactions(c.kind, c.extraLevels)
+	d.mu.versions.incrementCompactions(c.kind, c.extraLevels, c.metrics)
 	d.mu.versions.incrementCompactionBytes(-c.bytesWritten)
 
 	var flushed flushableList
@@ -1663,7 +1801,7 @@ func (d *DB) maybeScheduleCompactionAsync() {
 //
 // d.mu must be held when calling this.
 func (d *DB) maybeScheduleCompaction() {
-	d.maybeScheduleCompactionPicker(pickAuto)
+	d.maybeScheduleCompactionPicker(pickAuto, false /* force */)
 }
 
 func pickAuto(picker compactionPicker, env compactionEnv) *pickedCompaction {
@@ -1675,11 +1813,13 @@ func pickElisionOnly(picker compactionPicker, env compactionEnv) *pickedCompacti
 }
 
 // maybeScheduleCompactionPicker schedules a compaction if necessary,
-// calling `pickFunc` to pick automatic compactions.
+// calling `pickFunc` to pick automatic compactions. If force is true, the
+// compactions picked by `pickFunc` are scheduled even while automatic
+// compactions are disabled.
 //
 // d.mu must be held when calling this.
 func (d *DB) maybeScheduleCompactionPicker(
-	pickFunc func(compactionPicker, compactionEnv) *pickedCompaction,
+	pickFunc func(compactionPicker, compactionEnv) *pickedCompaction, force bool,
 ) {
 	if d.closed.Load() != nil || d.opts.ReadOnly {
 		return
@@ -1715,7 +1855,8 @@ func (d *DB) maybeScheduleCompactionPicker(
 	// cheap and reduce future compaction work.
 	if len(d.mu.compact.deletionHints) > 0 &&
 		d.mu.compact.compactingCount < maxConcurrentCompactions &&
-		!d.opts.DisableAutomaticCompactions {
+		d.automaticCompactionsEnabledLocked() &&
+		d.admitLocked(CompactionRequestAuto) {
 		v := d.mu.versions.currentVersion()
 		snapshots := d.mu.snapshots.toSlice()
 		inputs, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, d.mu.compact.deletionHints, snapshots)
@@ -1731,12 +1872,19 @@ func (d *DB) maybeScheduleCompactionPicker(
 
 	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < maxConcurrentCompactions {
 		manual := d.mu.compact.manual[0]
+		if !d.admitLocked(CompactionRequestManual) {
+			// As with the inability to run the head, denying its admission
+			// blocks later manual compactions.
+			manual.retries++
+			break
+		}
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		pc, retryLater := d.mu.versions.picker.pickManual(env, manual)
 		if pc != nil {
 			c := newCompaction(pc, d.opts)
 			d.mu.compact.manual = d.mu.compact.manual[1:]
 			d.mu.compact.compactingCount++
+			d.mu.compact.manualCompactingCount++
 			d.addInProgressCompaction(c)
 			go d.compact(c, manual.done)
 		} else if !retryLater {
@@ -1750,7 +1898,10 @@ func (d *DB) maybeScheduleCompactionPicker(
 		}
 	}
 
-	for !d.opts.DisableAutomaticCompactions && d.mu.compact.compactingCount < maxConcurrentCompactions {
+	for (force || d.automaticCompactionsEnabledLocked()) && d.mu.compact.compactingCount < maxConcurrentCompactions {
+		if !d.admitLocked(CompactionRequestAuto) {
+			break
+		}
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		env.readCompactionEnv = readCompactionEnv{
 			readCompactions:          &d.mu.compact.readCompactions,
@@ -1768,6 +1919,16 @@ func (d *DB) maybeScheduleCompactionPicker(
 	}
 }
 
+// automaticCompactionsEnabledLocked returns true if automatic compactions may
+// be scheduled: they're enabled both by the options and at runtime, or a
+// DrainCompactions call is in progress.
+//
+// d.mu must be held when calling this.
+func (d *DB) automaticCompactionsEnabledLocked() bool {
+	enabled := !d.opts.DisableAutomaticCompactions && !d.mu.compact.autoDisabled
+	return enabled || d.mu.compact.draining > 0
+}
+
 // deleteCompactionHintType indicates whether the deleteCompactionHint was
 // generated from a span containing a range del (point key only), a range key
 // delete (range key only), or both a point and range key.
@@ -2051,9 +2212,15 @@ func (d *DB) compact(c *compaction, errChannel chan error) {
 			d.opts.EventListener.BackgroundError(err)
 		}
 		d.mu.compact.compactingCount--
+		if errChannel != nil {
+			d.mu.compact.manualCompactingCount--
+		}
 		// The previous compaction may have produced too many files in a
-		// level, so reschedule another compaction if needed.
+		// level, so reschedule another compaction if needed. A flush may also
+		// have been denied admission by the CompactionScheduler while the
+		// compaction was in progress.
 		d.maybeScheduleCompaction()
+		d.maybeScheduleFlush()
 		d.mu.compact.cond.Broadcast()
 	})
 }
@@ -2101,7 +2268,7 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 
 	d.maybeUpdateDeleteCompactionHints(c)
 	d.removeInProgressCompaction(c)
-	d.mu.versions.incrementCompactions(c.kind, c.extraLevels)
+	d.mu.versions.incrementCompactions(c.kind, c.extraLevels, c.metrics)
 	d.mu.versions.incrementCompactionBytes(-c.bytesWritten)
 
 	info.TotalDuration = d.timeNow().Sub(startTime)
@@ -2120,6 +2287,65 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	return err
 }
 
+// singleDeleteInvariantViolation returns the function the compaction iterator
+// calls when it detects a misuse of SingleDelete, or nil if
+// Options.Experimental.SingleDeleteValidation is disabled.
+func (d *DB) singleDeleteInvariantViolation() func(userKey []byte) error {
+	if !d.opts.Experimental.SingleDeleteValidation {
+		return nil
+	}
+	callback := d.opts.Experimental.SingleDeleteInvariantViolationCallback
+	return func(userKey []byte) error {
+		if callback == nil {
+			return errors.Errorf("pebble: SINGLEDEL of %s consumed a SET with an older write of the same key",
+				d.opts.Comparer.FormatKey(userKey))
+		}
+		callback(userKey)
+		return nil
+	}
+}
+
+// obsoleteVersionGC returns the function the compaction iterator calls to
+// determine whether the point keys for a user key may be dropped as an
+// obsolete MVCC version, or nil if Options.Experimental.MVCCGCDecodeSuffix is
+// not set. The returned function must be called with user keys in increasing
+// order.
+func (d *DB) obsoleteVersionGC(c *compaction) func(userKey []byte) bool {
+	decode := d.opts.Experimental.MVCCGCDecodeSuffix
+	if decode == nil {
+		return nil
+	}
+	split := d.opts.Comparer.Split
+	threshold := d.opts.Experimental.MVCCGCThreshold
+	keepFromSuffix := d.opts.Experimental.MVCCGCKeepFromSuffix
+	var prefix []byte
+	var keepFrom uint64
+	var havePrefix bool
+	return func(userKey []byte) bool {
+		n := split(userKey)
+		if n == len(userKey) {
+			return false
+		}
+		// Keys with the same prefix are adjacent, so the oldest version to
+		// retain only needs to be determined when the prefix changes.
+		if !havePrefix || !c.equal(prefix, userKey[:n]) {
+			prefix = append(prefix[:0], userKey[:n]...)
+			havePrefix = true
+			keepFrom = threshold
+			if keepFromSuffix != nil {
+				keepFrom = keepFromSuffix(prefix)
+			}
+		}
+		version, ok := decode(userKey[n:])
+		if !ok || version >= keepFrom {
+			return false
+		}
+		// Dropping the version must not resurface an older write of the user
+		// key beneath the compaction's output level.
+		return c.elideTombstone(userKey)
+	}
+}
+
 // runCompactions runs a compaction that produces new on-disk tables from
 // memtables or old on-disk tables.
 //
@@ -2142,7 +2368,7 @@ func (d *DB) runCompaction(
 
 	// Check for a delete-only compaction. This can occur when wide range
 	// tombstones completely contain sstables.
-	if c.kind == compactionKindDeleteOnly {
+	if c.kind == CompactionKindDeleteOnly {
 		c.metrics = make(map[int]*LevelMetrics, len(c.inputs))
 		ve := &versionEdit{
 			DeletedFiles: map[deletedFileEntry]*fileMetadata{},
@@ -2167,7 +2393,7 @@ func (d *DB) runCompaction(
 	// such a move if there is lots of overlapping grandparent data. Otherwise,
 	// the move could create a parent file that will require a very expensive
 	// merge later on.
-	if c.kind == compactionKindMove {
+	if c.kind == CompactionKindMove {
 		iter := c.startLevel.files.Iter()
 		meta := iter.First()
 		c.metrics = map[int]*LevelMetrics{
@@ -2193,6 +2419,10 @@ func (d *DB) runCompaction(
 		return ve, nil, nil
 	}
 
+	if c.kind == CompactionKindFilterRewrite {
+		return d.runFilterRewriteCompaction(jobID, c)
+	}
+
 	defer func() {
 		if retErr != nil {
 			pendingOutputs = nil
@@ -2217,11 +2447,27 @@ func (d *DB) runCompaction(
 	c.allowedZeroSeqNum = c.allowZeroSeqNum()
 	iter := newCompactionIter(c.cmp, c.equal, c.formatKey, d.merge, iiter, snapshots,
 		&c.rangeDelFrag, &c.rangeKeyFrag, c.allowedZeroSeqNum, c.elideTombstone,
-		c.elideRangeTombstone, d.FormatMajorVersion())
+		c.elideRangeTombstone, d.singleDeleteInvariantViolation(), d.obsoleteVersionGC(c),
+		d.FormatMajorVersion())
+	iter.readBlobValue = d.tableCache.blobFiles.readValue
+
+	// Values are only separated into blob files once the DB's format permits
+	// blob files and BLOBSET keys.
+	sepThreshold := d.opts.Experimental.ValueSeparationThreshold
+	if formatVers < FormatValueSeparation {
+		sepThreshold = 0
+	}
+	sep := newValueSeparator(sepThreshold, c.inputs, func() (FileNum, vfs.File, error) {
+		d.mu.Lock()
+		fileNum := d.mu.versions.getNextFileNum()
+		d.mu.Unlock()
+		f, err := d.opts.FS.Create(base.MakeFilepath(d.opts.FS, d.dirname, fileTypeBlob, fileNum))
+		return fileNum, f, err
+	})
 
 	var (
-		filenames []string
-		tw        *sstable.Writer
+		createdFiles []FileNum
+		tw           *sstable.Writer
 	)
 	defer func() {
 		if iter != nil {
@@ -2231,8 +2477,12 @@ func (d *DB) runCompaction(
 			retErr = firstError(retErr, tw.Close())
 		}
 		if retErr != nil {
-			for _, filename := range filenames {
-				d.opts.FS.Remove(filename)
+			sep.abort()
+			for _, fileNum := range createdFiles {
+				_ = d.objProvider.Remove(fileNum)
+			}
+			for _, fileNum := range sep.created {
+				_ = d.opts.FS.Remove(base.MakeFilepath(d.opts.FS, d.dirname, fileTypeBlob, fileNum))
 			}
 		}
 		for _, closer := range c.closers {
@@ -2268,6 +2518,9 @@ func (d *DB) runCompaction(
 		// Cannot yet write block properties.
 		writerOpts.BlockPropertyCollectors = nil
 	}
+	if d.opts.BackgroundIOLimiter != nil {
+		writerOpts.WriteLimiter = d.opts.BackgroundIOLimiter
+	}
 
 	// prevPointKey is a sstable.WriterOption that provides access to
 	// the last point key written to a writer's sstable. When a new
@@ -2293,11 +2546,13 @@ func (d *DB) runCompaction(
 		pendingOutputs = append(pendingOutputs, fileMeta)
 		d.mu.Unlock()
 
-		filename := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, fileNum)
-		file, err := d.opts.FS.Create(filename)
+		writable, objMeta, err := d.objProvider.Create(fileNum, objstorage.CreateOptions{
+			Locator: d.opts.Experimental.CreateOnRemote,
+		})
 		if err != nil {
 			return err
 		}
+		fileMeta.RemoteLocator = objMeta.Locator
 		reason := "flushing"
 		if c.flushing == nil {
 			reason = "compacting"
@@ -2305,19 +2560,15 @@ func (d *DB) runCompaction(
 		d.opts.EventListener.TableCreated(TableCreateInfo{
 			JobID:   jobID,
 			Reason:  reason,
-			Path:    filename,
+			Path:    d.objProvider.Path(objMeta),
 			FileNum: fileNum,
 		})
-		file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
-			NoSyncOnClose: d.opts.NoSyncOnClose,
-			BytesPerSync:  d.opts.BytesPerSync,
-		})
-		file = &compactionFile{
-			File:     file,
+		file := &compactionFile{
+			Writable: writable,
 			versions: d.mu.versions,
 			written:  &c.bytesWritten,
 		}
-		filenames = append(filenames, filename)
+		createdFiles = append(createdFiles, fileNum)
 		cacheOpts := private.SSTableCacheOpts(d.cacheID, fileNum).(sstable.WriterOption)
 		internalTableOpt := private.SSTableInternalTableOpt.(sstable.WriterOption)
 		if d.opts.Experimental.CPUWorkPermissionGranter != nil {
@@ -2441,6 +2692,9 @@ func (d *DB) runCompaction(
 		}
 		tw = nil
 		meta := ve.NewFiles[len(ve.NewFiles)-1].Meta
+		if meta.BlobReferences, err = sep.finishOutput(); err != nil {
+			return err
+		}
 		meta.Size = writerMeta.Size
 		meta.SmallestSeqNum = writerMeta.SmallestSeqNum
 		meta.LargestSeqNum = writerMeta.LargestSeqNum
@@ -2547,30 +2801,45 @@ func (d *DB) runCompaction(
 	// the splitterGroup can be composed of multiple splitters. In this case,
 	// we start off with splitters for file sizes, grandparent limits, and (for
 	// L0 splits) L0 limits, before wrapping them in an splitterGroup.
+	unsafePrevUserKey := func() []byte {
+		// Return the largest point key written to tw or the start of
+		// the current range deletion in the fragmenter, whichever is
+		// greater.
+		prevPoint := prevPointKey.UnsafeKey()
+		if c.cmp(prevPoint.UserKey, c.rangeDelFrag.Start()) > 0 {
+			return prevPoint.UserKey
+		}
+		return c.rangeDelFrag.Start()
+	}
 	outputSplitters := []compactionOutputSplitter{
 		// We do not split the same user key across different sstables within
 		// one flush or compaction. The fileSizeSplitter may request a split in
 		// the middle of a user key, so the userKeyChangeSplitter ensures we are
 		// at a user key change boundary when doing a split.
 		&userKeyChangeSplitter{
-			cmp:      c.cmp,
-			splitter: &fileSizeSplitter{maxFileSize: c.maxOutputFileSize},
-			unsafePrevUserKey: func() []byte {
-				// Return the largest point key written to tw or the start of
-				// the current range deletion in the fragmenter, whichever is
-				// greater.
-				prevPoint := prevPointKey.UnsafeKey()
-				if c.cmp(prevPoint.UserKey, c.rangeDelFrag.Start()) > 0 {
-					return prevPoint.UserKey
-				}
-				return c.rangeDelFrag.Start()
-			},
+			cmp:               c.cmp,
+			splitter:          &fileSizeSplitter{maxFileSize: c.maxOutputFileSize},
+			unsafePrevUserKey: unsafePrevUserKey,
 		},
 		&limitFuncSplitter{c: c, limitFunc: c.findGrandparentLimit},
 	}
 	if splitL0Outputs {
 		outputSplitters = append(outputSplitters, &limitFuncSplitter{c: c, limitFunc: c.findL0Limit})
 	}
+	if c.maxOutputTableKeys > 0 {
+		outputSplitters = append(outputSplitters, &userKeyChangeSplitter{
+			cmp:               c.cmp,
+			splitter:          &keyCountSplitter{maxKeys: c.maxOutputTableKeys},
+			unsafePrevUserKey: unsafePrevUserKey,
+		})
+	}
+	if d.opts.Experimental.NewOutputSplitter != nil {
+		outputSplitters = append(outputSplitters, &userKeyChangeSplitter{
+			cmp:               c.cmp,
+			splitter:          &optionsSplitter{splitter: d.opts.Experimental.NewOutputSplitter()},
+			unsafePrevUserKey: unsafePrevUserKey,
+		})
+	}
 	splitter := &splitterGroup{cmp: c.cmp, splitters: outputSplitters}
 
 	// Each outer loop iteration produces one output file. An iteration that
@@ -2650,7 +2919,11 @@ func (d *DB) runCompaction(
 					return nil, pendingOutputs, err
 				}
 			}
-			if err := tw.Add(*key, val); err != nil {
+			outKey, outVal, err := sep.add(*key, val)
+			if err != nil {
+				return nil, pendingOutputs, err
+			}
+			if err := tw.Add(outKey, outVal); err != nil {
 				return nil, pendingOutputs, err
 			}
 		}
@@ -2706,6 +2979,100 @@ func (d *DB) runCompaction(
 	return ve, pendingOutputs, nil
 }
 
+// runFilterRewriteCompaction runs a compaction rewriting the filter block of
+// its single input sstable. The remainder of the sstable is copied verbatim to
+// the output sstable, which inherits the bounds, sequence numbers and stats of
+// the input sstable. See sstable.RewriteFilter.
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) runFilterRewriteCompaction(
+	jobID int, c *compaction,
+) (ve *versionEdit, pendingOutputs []*fileMetadata, retErr error) {
+	iter := c.startLevel.files.Iter()
+	f := iter.First()
+	meta := &fileMetadata{
+		FileNum:        d.mu.versions.getNextFileNum(),
+		CreationTime:   f.CreationTime,
+		SmallestSeqNum: f.SmallestSeqNum,
+		LargestSeqNum:  f.LargestSeqNum,
+		BlobReferences: f.BlobReferences,
+		Stats:          f.Stats,
+	}
+	if f.HasPointKeys {
+		meta.ExtendPointKeyBounds(d.cmp, f.SmallestPointKey, f.LargestPointKey)
+	}
+	if f.HasRangeKeys {
+		meta.ExtendRangeKeyBounds(d.cmp, f.SmallestRangeKey, f.LargestRangeKey)
+	}
+	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, d.mu.formatVers.vers.MaxTableFormat())
+
+	// Release the d.mu lock while doing I/O.
+	// Note the unusual order: Unlock and then Lock.
+	d.mu.Unlock()
+	defer d.mu.Lock()
+
+	var created bool
+	defer func() {
+		if retErr != nil && created {
+			_ = d.objProvider.Remove(meta.FileNum)
+		}
+	}()
+	err := d.tableCache.withReader(f, func(r *sstable.Reader) (err error) {
+		writable, objMeta, err := d.objProvider.Create(meta.FileNum, objstorage.CreateOptions{
+			Locator: d.opts.Experimental.CreateOnRemote,
+		})
+		if err != nil {
+			return err
+		}
+		created = true
+		meta.RemoteLocator = objMeta.Locator
+		d.opts.EventListener.TableCreated(TableCreateInfo{
+			JobID:   jobID,
+			Reason:  "compacting",
+			Path:    d.objProvider.Path(objMeta),
+			FileNum: meta.FileNum,
+		})
+		file := &compactionFile{
+			Writable: writable,
+			versions: d.mu.versions,
+			written:  &c.bytesWritten,
+		}
+		meta.Size, err = sstable.RewriteFilter(r, file, writerOpts)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.dataDir.Sync(); err != nil {
+		return nil, nil, err
+	}
+
+	c.metrics = map[int]*LevelMetrics{
+		c.outputLevel.level: {
+			Size:            int64(meta.Size) - int64(f.Size),
+			BytesIn:         f.Size,
+			BytesRead:       f.Size,
+			BytesCompacted:  meta.Size,
+			TablesCompacted: 1,
+		},
+	}
+	ve = &versionEdit{
+		DeletedFiles: map[deletedFileEntry]*fileMetadata{
+			{Level: c.startLevel.level, FileNum: f.FileNum}: f,
+		},
+		NewFiles: []newFileEntry{
+			{Level: c.outputLevel.level, Meta: meta},
+		},
+	}
+
+	// Refresh the disk available statistic whenever a compaction/flush
+	// completes, before re-acquiring the mutex.
+	_ = d.calculateDiskAvailableBytes()
+
+	return ve, []*fileMetadata{meta}, nil
+}
+
 // validateVersionEdit validates that start and end keys across new and deleted
 // files in a versionEdit pass the given validation function.
 func validateVersionEdit(
@@ -2756,6 +3123,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 	var obsoleteTables []*fileMetadata
 	var obsoleteManifests []fileInfo
 	var obsoleteOptions []fileInfo
+	var obsoleteBlobFiles []fileInfo
 
 	for _, filename := range list {
 		fileType, fileNum, ok := base.ParseFilename(d.opts.FS, filename)
@@ -2801,6 +3169,15 @@ func (d *DB) scanObsoleteFiles(list []string) {
 				fileMeta.Size = uint64(stat.Size())
 			}
 			obsoleteTables = append(obsoleteTables, fileMeta)
+		case fileTypeBlob:
+			if _, ok := d.mu.versions.blobFiles[fileNum]; ok {
+				continue
+			}
+			fi := fileInfo{fileNum: fileNum}
+			if stat, err := d.opts.FS.Stat(filename); err == nil {
+				fi.fileSize = uint64(stat.Size())
+			}
+			obsoleteBlobFiles = append(obsoleteBlobFiles, fi)
 		default:
 			// Don't delete files we don't know about.
 			continue
@@ -2813,6 +3190,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 	d.mu.versions.incrementObsoleteTablesLocked(obsoleteTables)
 	d.mu.versions.obsoleteManifests = merge(d.mu.versions.obsoleteManifests, obsoleteManifests)
 	d.mu.versions.obsoleteOptions = merge(d.mu.versions.obsoleteOptions, obsoleteOptions)
+	d.mu.versions.obsoleteBlobFiles = merge(d.mu.versions.obsoleteBlobFiles, obsoleteBlobFiles)
 }
 
 // disableFileDeletions disables file deletions and then waits for any
@@ -2898,6 +3276,9 @@ type obsoleteFile struct {
 type fileInfo struct {
 	fileNum  FileNum
 	fileSize uint64
+	// dir is the directory containing the file if it is a WAL residing in the
+	// WAL failover secondary directory, and empty otherwise.
+	dir string
 }
 
 // d.mu must be held when calling this, but the mutex may be dropped and
@@ -2953,12 +3334,15 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	obsoleteOptions := d.mu.versions.obsoleteOptions
 	d.mu.versions.obsoleteOptions = nil
 
+	obsoleteBlobFiles := d.mu.versions.obsoleteBlobFiles
+	d.mu.versions.obsoleteBlobFiles = nil
+
 	// Release d.mu while doing I/O
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
-	files := [4]struct {
+	files := [5]struct {
 		fileType fileType
 		obsolete []fileInfo
 	}{
@@ -2966,6 +3350,7 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 		{fileTypeTable, obsoleteTables},
 		{fileTypeManifest, obsoleteManifests},
 		{fileTypeOptions, obsoleteOptions},
+		{fileTypeBlob, obsoleteBlobFiles},
 	}
 	_, noRecycle := d.opts.Cleaner.(base.NeedsFileContents)
 	filesToDelete := make([]obsoleteFile, 0, len(files))
@@ -2979,12 +3364,17 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 			dir := d.dirname
 			switch f.fileType {
 			case fileTypeLog:
-				if !noRecycle && d.logRecycler.add(fi) {
+				// WALs in the WAL failover secondary directory are not
+				// recycled, since new WALs are only written there after the
+				// primary WAL directory has been deemed unhealthy.
+				if !noRecycle && fi.dir == "" && d.logRecycler.add(fi) {
 					continue
 				}
-				dir = d.walDirname
+				dir = d.logDirname(fi)
 			case fileTypeTable:
 				d.tableCache.evict(fi.fileNum)
+			case fileTypeBlob:
+				d.tableCache.blobFiles.evict(fi.fileNum)
 			}
 
 			filesToDelete = append(filesToDelete, obsoleteFile{
@@ -3017,12 +3407,15 @@ func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile) {
 
 	for _, of := range files {
 		path := base.MakeFilepath(d.opts.FS, of.dir, of.fileType, of.fileNum)
-		if of.fileType == fileTypeTable {
+		switch of.fileType {
+		case fileTypeTable:
 			_ = pacer.maybeThrottle(of.fileSize)
 			d.mu.Lock()
 			d.mu.versions.metrics.Table.ObsoleteCount--
 			d.mu.versions.metrics.Table.ObsoleteSize -= of.fileSize
 			d.mu.Unlock()
+		case fileTypeBlob:
+			_ = pacer.maybeThrottle(of.fileSize)
 		}
 		d.deleteObsoleteFile(of.fileType, jobID, path, of.fileNum)
 	}
@@ -3032,7 +3425,7 @@ func (d *DB) maybeScheduleObsoleteTableDeletion() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if len(d.mu.versions.obsoleteTables) == 0 {
+	if len(d.mu.versions.obsoleteTables) == 0 && len(d.mu.versions.obsoleteBlobFiles) == 0 {
 		return
 	}
 	if !d.acquireCleaningTurn(false) {
@@ -3056,7 +3449,15 @@ func (d *DB) maybeScheduleObsoleteTableDeletion() {
 func (d *DB) deleteObsoleteFile(fileType fileType, jobID int, path string, fileNum FileNum) {
 	// TODO(peter): need to handle this error, probably by re-adding the
 	// file that couldn't be deleted to one of the obsolete slices map.
-	err := d.opts.Cleaner.Clean(d.opts.FS, fileType, path)
+	var err error
+	if fileType == fileTypeTable && d.objProvider.Lookup(fileNum).IsRemote() {
+		// Sstables stored remotely are removed from their storage, rather than
+		// handed to the Cleaner.
+		path = d.objProvider.Path(d.objProvider.Lookup(fileNum))
+		err = d.objProvider.Remove(fileNum)
+	} else {
+		err = d.opts.Cleaner.Clean(d.opts.FS, fileType, path)
+	}
 	if oserror.IsNotExist(err) {
 		return
 	}
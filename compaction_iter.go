@@ -208,6 +208,20 @@ type compactionIter struct {
 	allowZeroSeqNum     bool
 	elideTombstone      func(key []byte) bool
 	elideRangeTombstone func(start, end []byte) bool
+	// singleDeleteInvariantViolation, if non-nil, is called when a SINGLEDEL
+	// consumes a SET that has another SET, SETWITHDEL or MERGE for the same
+	// user key beneath it in the same snapshot stripe. Such a SINGLEDEL was
+	// applied to a key written more than once, and the older write will be
+	// resurrected. A non-nil error aborts the compaction.
+	singleDeleteInvariantViolation func(userKey []byte) error
+	// elideObsoleteVersion, if non-nil, returns true if the point keys for the
+	// specified user key may be dropped as an obsolete MVCC version. It's only
+	// consulted while there are no snapshots, so that every key for the user
+	// key lies within a single stripe.
+	elideObsoleteVersion func(userKey []byte) bool
+	// readBlobValue reads the value of a BLOBSET key from its blob file. It's
+	// used to merge MERGE operands with a BLOBSET beneath them.
+	readBlobValue func(handle, buf []byte) ([]byte, error)
 	// The on-disk format major version. This informs the types of keys that
 	// may be written to disk during a compaction.
 	formatVersion FormatMajorVersion
@@ -225,6 +239,8 @@ func newCompactionIter(
 	allowZeroSeqNum bool,
 	elideTombstone func(key []byte) bool,
 	elideRangeTombstone func(start, end []byte) bool,
+	singleDeleteInvariantViolation func(userKey []byte) error,
+	elideObsoleteVersion func(userKey []byte) bool,
 	formatVersion FormatMajorVersion,
 ) *compactionIter {
 	i := &compactionIter{
@@ -238,6 +254,9 @@ func newCompactionIter(
 		elideTombstone:      elideTombstone,
 		elideRangeTombstone: elideRangeTombstone,
 		formatVersion:       formatVersion,
+
+		singleDeleteInvariantViolation: singleDeleteInvariantViolation,
+		elideObsoleteVersion:           elideObsoleteVersion,
 	}
 	i.rangeDelFrag.Cmp = cmp
 	i.rangeDelFrag.Format = formatKey
@@ -327,6 +346,15 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 			continue
 		}
 
+		// If the key is an obsolete MVCC version, skip it along with the rest
+		// of the stripe, which holds every older key for the same user key.
+		if i.elideObsoleteVersion != nil && len(i.snapshots) == 0 &&
+			i.elideObsoleteVersion(i.iterKey.UserKey) {
+			i.saveKey()
+			i.skipInStripe()
+			continue
+		}
+
 		switch i.iterKey.Kind() {
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
 			// If we're at the last snapshot stripe and the tombstone can be elided
@@ -349,11 +377,17 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 				if i.singleDeleteNext() {
 					return &i.key, i.value
 				}
+				if i.err != nil {
+					// The SINGLEDEL failed validation. Stop without emitting
+					// the keys beneath it, so that the compaction fails.
+					i.valid = false
+					return nil, nil
+				}
 
 				continue
 			}
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			// The key we emit for this entry is a function of the current key
 			// kind, and whether this entry is followed by a DEL/SINGLEDEL
 			// entry. setNext() does the work to move the iterator forward,
@@ -532,9 +566,11 @@ func (i *compactionIter) setNext() {
 	// There are two cases where we can early return and skip the remaining
 	// records in the stripe:
 	// - If the DB does not SETWITHDEL.
-	// - If this key is already a SETWITHDEL.
+	// - If this key is already a SETWITHDEL, or a BLOBSET, which is
+	//   treated as one.
 	if i.formatVersion < FormatSetWithDelete ||
-		i.iterKey.Kind() == InternalKeyKindSetWithDelete {
+		i.iterKey.Kind() == InternalKeyKindSetWithDelete ||
+		i.iterKey.Kind() == InternalKeyKindBlobSet {
 		i.skip = true
 		return
 	}
@@ -641,7 +677,7 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			i.skip = true
 			return sameStripeSkippable
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			if i.rangeDelFrag.Covers(*key, i.curSnapshotSeqNum) {
 				// We change the kind of the result key to a Set so that it shadows
 				// keys in lower levels. That is, MERGE+RANGEDEL -> SET. This isn't
@@ -656,7 +692,13 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			// value and return. We change the kind of the resulting key to a
 			// Set so that it shadows keys in lower levels. That is:
 			// MERGE + (SET*) -> SET.
-			i.err = valueMerger.MergeOlder(i.iterValue)
+			value := i.iterValue
+			if key.Kind() == InternalKeyKindBlobSet {
+				value, i.err = i.readBlobValue(value, nil /* buf */)
+			}
+			if i.err == nil {
+				i.err = valueMerger.MergeOlder(value)
+			}
 			if i.err != nil {
 				i.valid = false
 				return sameStripeSkippable
@@ -707,16 +749,28 @@ func (i *compactionIter) singleDeleteNext() bool {
 
 		key := i.iterKey
 		switch key.Kind() {
-		case InternalKeyKindDelete, InternalKeyKindMerge, InternalKeyKindSetWithDelete:
-			// We've hit a Delete, Merge or SetWithDelete, transform the
-			// SingleDelete into a full Delete.
+		case InternalKeyKindDelete, InternalKeyKindMerge, InternalKeyKindSetWithDelete,
+			InternalKeyKindBlobSet:
+			// We've hit a Delete, Merge, SetWithDelete or BlobSet, transform
+			// the SingleDelete into a full Delete.
 			i.key.SetKind(InternalKeyKindDelete)
 			i.skip = true
 			return true
 
 		case InternalKeyKindSet:
-			i.nextInStripe()
+			change := i.nextInStripe()
 			i.valid = false
+			if i.singleDeleteInvariantViolation != nil && change == sameStripeSkippable {
+				switch i.iterKey.Kind() {
+				case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet,
+					InternalKeyKindMerge:
+					// The SINGLEDEL consumed the SET, exposing an older write
+					// of the same key.
+					if err := i.singleDeleteInvariantViolation(i.key.UserKey); err != nil {
+						i.err = err
+					}
+				}
+			}
 			return false
 
 		case InternalKeyKindSingleDelete:
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -130,6 +131,8 @@ func TestCompactionIter(t *testing.T) {
 			func(_, _ []byte) bool {
 				return elideTombstones
 			},
+			nil, /* singleDeleteInvariantViolation */
+			nil, /* elideObsoleteVersion */
 			formatVersion,
 		)
 	}
@@ -273,3 +276,96 @@ func TestCompactionIter(t *testing.T) {
 		})
 	}
 }
+
+func TestCompactionIterSingleDeleteValidation(t *testing.T) {
+	var keys []InternalKey
+	var vals [][]byte
+	for _, s := range []string{
+		// A SINGLEDEL over a single SET is correct usage.
+		"a.SINGLEDEL.3", "a.SET.2",
+		// A SINGLEDEL over a key set twice resurrects the older SET.
+		"b.SINGLEDEL.6", "b.SET.5", "b.SET.4",
+		// The same applies to a MERGE beneath the SET.
+		"c.SINGLEDEL.9", "c.SET.8", "c.MERGE.7",
+		// The older SET is in a lower snapshot stripe and remains visible to
+		// the snapshot regardless.
+		"d.SINGLEDEL.12", "d.SET.11", "d.SET.10",
+	} {
+		keys = append(keys, base.ParseInternalKey(s))
+		vals = append(vals, nil)
+	}
+
+	run := func(violation func(userKey []byte) error) (string, error) {
+		return runCompactionIterSingleDelete(keys, vals, violation)
+	}
+
+	// Without validation, the misuse goes unnoticed.
+	withoutValidation, err := run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var violations []string
+	withValidation, err := run(func(userKey []byte) error {
+		violations = append(violations, string(userKey))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withValidation != withoutValidation {
+		t.Fatalf("validation changed the compaction output:\n%s\nvs\n%s", withValidation, withoutValidation)
+	}
+	if got, want := strings.Join(violations, ","), "b,c"; got != want {
+		t.Fatalf("expected violations %q, but found %q", want, got)
+	}
+
+	// An error returned for a violation aborts the compaction without
+	// emitting the older SET that the SINGLEDEL exposed.
+	violationErr := func(userKey []byte) error {
+		return errors.Errorf("violation on %s", userKey)
+	}
+	out, err := run(violationErr)
+	if err == nil || err.Error() != "violation on b" {
+		t.Fatalf("expected violation error, but found %v", err)
+	}
+	if out != "" {
+		t.Fatalf("expected no output, but found %q", out)
+	}
+
+	// The error is not lost when a MERGE beneath the SET is merged next.
+	out, err = runCompactionIterSingleDelete(keys[5:8], vals[5:8], violationErr)
+	if err == nil || err.Error() != "violation on c" {
+		t.Fatalf("expected violation error, but found %v", err)
+	}
+	if out != "" {
+		t.Fatalf("expected no output, but found %q", out)
+	}
+}
+
+func runCompactionIterSingleDelete(
+	keys []InternalKey, vals [][]byte, violation func(userKey []byte) error,
+) (string, error) {
+	iter := newCompactionIter(
+		DefaultComparer.Compare,
+		DefaultComparer.Equal,
+		DefaultComparer.FormatKey,
+		DefaultMerger.Merge,
+		&fakeIter{keys: keys, vals: vals},
+		[]uint64{11}, /* snapshots */
+		&keyspan.Fragmenter{},
+		&keyspan.Fragmenter{},
+		false, /* allowZeroSeqNum */
+		func([]byte) bool { return false },
+		func(_, _ []byte) bool { return false },
+		violation,
+		nil, /* elideObsoleteVersion */
+		FormatNewest,
+	)
+	defer iter.Close()
+	var buf bytes.Buffer
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		fmt.Fprintf(&buf, "%s\n", key)
+	}
+	return buf.String(), iter.Error()
+}
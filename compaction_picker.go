@@ -140,7 +140,7 @@ type pickedCompaction struct {
 	score float64
 
 	// kind indicates the kind of compaction.
-	kind compactionKind
+	kind CompactionKind
 
 	// startLevel is the level that is being compacted. Inputs from startLevel
 	// and outputLevel will be merged to produce a set of outputLevel files.
@@ -175,6 +175,9 @@ type pickedCompaction struct {
 	// maxOutputFileSize is the maximum size of an individual table created
 	// during compaction.
 	maxOutputFileSize uint64
+	// maxOutputTableKeys is the maximum number of point keys in an individual
+	// table created during compaction, or zero if unlimited.
+	maxOutputTableKeys uint64
 	// maxOverlapBytes is the maximum number of bytes of overlap allowed for a
 	// single output table with the tables in the grandparent level.
 	maxOverlapBytes uint64
@@ -223,6 +226,7 @@ func newPickedCompaction(
 		inputs:                 []compactionLevel{{level: startLevel}, {level: outputLevel}},
 		adjustedOutputLevel:    adjustedOutputLevel,
 		maxOutputFileSize:      uint64(opts.Level(adjustedOutputLevel).TargetFileSize),
+		maxOutputTableKeys:     uint64(opts.Level(adjustedOutputLevel).MaxTableKeys),
 		maxOverlapBytes:        maxGrandparentOverlapBytes(opts, adjustedOutputLevel),
 		maxReadCompactionBytes: maxReadCompactionBytes(opts, adjustedOutputLevel),
 	}
@@ -576,6 +580,53 @@ type candidateLevelInfo struct {
 	file manifest.LevelFile
 }
 
+// CompactionCandidate describes a score-based compaction the compaction
+// picker may pick. See Options.Experimental.CompactionHeuristicHook.
+type CompactionCandidate struct {
+	// Score is the compaction score of Level, which is at least 1.
+	Score float64
+	// Level is the level being compacted, and OutputLevel the level the
+	// compaction's outputs are written to.
+	Level       int
+	OutputLevel int
+	// Smallest and Largest are the smallest and largest user keys of the
+	// compaction's inputs. They must not be modified.
+	Smallest, Largest []byte
+
+	pc *pickedCompaction
+}
+
+func makeCompactionCandidate(pc *pickedCompaction) CompactionCandidate {
+	return CompactionCandidate{
+		Score:       pc.score,
+		Level:       pc.startLevel.level,
+		OutputLevel: pc.outputLevel.level,
+		Smallest:    pc.smallest.UserKey,
+		Largest:     pc.largest.UserKey,
+		pc:          pc,
+	}
+}
+
+// pickCompactionCandidate returns the compaction of the first candidate
+// returned by hook, ignoring any candidates not generated by the picker. The
+// hook is passed the candidates in order of decreasing score. If the hook
+// vetoes every candidate while writes are stalled by the read amplification
+// of L0, the veto is ignored and the highest scoring candidate is returned, as
+// only compactions can resume writes.
+func (p *compactionPickerByScore) pickCompactionCandidate(
+	hook func([]CompactionCandidate) []CompactionCandidate, candidates []CompactionCandidate,
+) *pickedCompaction {
+	for _, c := range hook(append([]CompactionCandidate(nil), candidates...)) {
+		if c.pc != nil {
+			return c.pc
+		}
+	}
+	if p.vers.L0Sublevels.ReadAmplification() >= p.opts.L0StopWritesThreshold {
+		return candidates[0].pc
+	}
+	return nil
+}
+
 // compensatedSize returns f's file size, inflated according to compaction
 // priorities.
 func compensatedSize(f *fileMetadata) uint64 {
@@ -1113,7 +1164,11 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 
 	// Check for a score-based compaction. "scores" has been sorted in order of
 	// decreasing score. For each level with a score >= 1, we attempt to find a
-	// compaction anchored at at that level.
+	// compaction anchored at at that level. If a CompactionHeuristicHook is
+	// configured, the compactions found for every such level are instead
+	// collected as candidates for the hook to choose from.
+	hook := p.opts.Experimental.CompactionHeuristicHook
+	var candidates []CompactionCandidate
 	for i := range scores {
 		info := &scores[i]
 		if info.score < 1 {
@@ -1129,6 +1184,10 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 			// concurrently.
 			if pc != nil && !inputRangeAlreadyCompacting(env, pc) {
 				pc.score = info.score
+				if hook != nil {
+					candidates = append(candidates, makeCompactionCandidate(pc))
+					continue
+				}
 				// TODO(peter): remove
 				if false {
 					logCompaction(pc)
@@ -1149,6 +1208,10 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		// Fail-safe to protect against compacting the same sstable concurrently.
 		if pc != nil && !inputRangeAlreadyCompacting(env, pc) {
 			pc.score = info.score
+			if hook != nil {
+				candidates = append(candidates, makeCompactionCandidate(pc))
+				continue
+			}
 			// TODO(peter): remove
 			if false {
 				logCompaction(pc)
@@ -1156,6 +1219,11 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 			return pc
 		}
 	}
+	if len(candidates) > 0 {
+		if pc := p.pickCompactionCandidate(hook, candidates); pc != nil {
+			return pc
+		}
+	}
 
 	// Check for L6 files with tombstones that may be elided. These files may
 	// exist if a snapshot prevented the elision of a tombstone or because of
@@ -1165,6 +1233,13 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		return pc
 	}
 
+	// Check for files in L0-L5 dense with tombstones, which may be compacted
+	// into the next level to drop the keys they delete. Like elision-only
+	// compactions, these only reclaim disk space.
+	if pc := p.pickTombstoneDensityCompaction(env); pc != nil {
+		return pc
+	}
+
 	if pc := p.pickReadTriggeredCompaction(env); pc != nil {
 		return pc
 	}
@@ -1200,6 +1275,9 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 	// MarkedForCompaction field is persisted in the manifest. That's okay. We
 	// previously would've ignored the designation, whereas now we'll re-compact
 	// the file in place.
+	//
+	// Files may also be marked for compaction through DB.Mark, if enabled
+	// through Options.Experimental.EnableMarkedForCompaction.
 	if p.vers.Stats.MarkedForCompaction > 0 {
 		if pc := p.pickRewriteCompaction(env); pc != nil {
 			return pc
@@ -1335,7 +1413,7 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	// Construct a picked compaction of the elision candidate's atomic
 	// compaction unit.
 	pc = newPickedCompaction(p.opts, p.vers, numLevels-1, numLevels-1, p.baseLevel)
-	pc.kind = compactionKindElisionOnly
+	pc.kind = CompactionKindElisionOnly
 	var isCompacting bool
 	pc.startLevel.files, isCompacting = expandToAtomicUnit(p.opts.Comparer.Compare, lf.Slice(), false /* disableIsCompacting */)
 	if isCompacting {
@@ -1349,6 +1427,82 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	return nil
 }
 
+// tombstoneDensityAnnotator implements the manifest.Annotator interface,
+// annotating B-Tree nodes with the *fileMetadata of the file within the
+// subtree with the highest tombstone density of at least the threshold.
+type tombstoneDensityAnnotator struct {
+	threshold float64
+}
+
+var _ manifest.Annotator = tombstoneDensityAnnotator{}
+
+func (a tombstoneDensityAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a tombstoneDensityAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (interface{}, bool) {
+	if f.Compacting {
+		return dst, true
+	}
+	if !f.Stats.Valid {
+		return dst, false
+	}
+	if f.Stats.TombstoneDensity < a.threshold {
+		return dst, true
+	}
+	return a.Merge(f, dst), true
+}
+
+func (a tombstoneDensityAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	f := v.(*fileMetadata)
+	if accum == nil || accum.(*fileMetadata).Stats.TombstoneDensity < f.Stats.TombstoneDensity {
+		return f
+	}
+	return accum
+}
+
+// pickTombstoneDensityCompaction looks for compactions of sstables in L0-L5
+// whose tombstone density is at least
+// Options.Experimental.TombstoneDensityCompactionThreshold.
+func (p *compactionPickerByScore) pickTombstoneDensityCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	threshold := p.opts.Experimental.TombstoneDensityCompactionThreshold
+	if threshold <= 0 {
+		return nil
+	}
+	cmp := p.opts.Comparer.Compare
+	for l := 0; l < numLevels-1; l++ {
+		v := p.vers.Levels[l].Annotation(tombstoneDensityAnnotator{threshold: threshold})
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		if candidate.Compacting {
+			continue
+		}
+
+		pc = newPickedCompaction(p.opts, p.vers, l, defaultOutputLevel(l, p.baseLevel), p.baseLevel)
+		pc.startLevel.files = p.vers.Overlaps(l, cmp, candidate.Smallest.UserKey,
+			candidate.Largest.UserKey, candidate.Largest.IsExclusiveSentinel())
+		if !pc.setupInputs(p.opts, p.diskAvailBytes(), pc.startLevel) {
+			continue
+		}
+		// Fail-safe to protect against compacting the same sstable concurrently.
+		if inputRangeAlreadyCompacting(env, pc) {
+			continue
+		}
+		pc.kind = CompactionKindTombstoneDensity
+		return pc
+	}
+	return nil
+}
+
 // pickRewriteCompaction attempts to construct a compaction that
 // rewrites a file marked for compaction. pickRewriteCompaction will
 // pull in adjacent files in the file's atomic compaction unit if
@@ -1397,7 +1551,7 @@ func (p *compactionPickerByScore) pickRewriteCompaction(env compactionEnv) (pc *
 
 		pc = newPickedCompaction(p.opts, p.vers, l, l, p.baseLevel)
 		pc.outputLevel.level = l
-		pc.kind = compactionKindRewrite
+		pc.kind = CompactionKindRewrite
 		pc.startLevel.files = inputs
 		pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
 
@@ -1636,7 +1790,7 @@ func pickReadTriggeredCompactionHelper(
 	if inputRangeAlreadyCompacting(env, pc) {
 		return nil
 	}
-	pc.kind = compactionKindRead
+	pc.kind = CompactionKindRead
 
 	// Prevent read compactions which are too wide.
 	outputOverlaps := pc.version.Overlaps(
@@ -320,6 +320,82 @@ func TestCompactionPickerTargetLevel(t *testing.T) {
 		})
 }
 
+func TestCompactionPickerHeuristicHook(t *testing.T) {
+	opts := &Options{}
+	opts.EnsureDefaults()
+	opts.LBaseMaxBytes = 1 << 20
+
+	newFile := func(fileNum FileNum, start, end string, seqNum uint64, size uint64) *fileMetadata {
+		m := (&fileMetadata{
+			FileNum:        fileNum,
+			SmallestSeqNum: seqNum,
+			LargestSeqNum:  seqNum,
+			Size:           size,
+		}).ExtendPointKeyBounds(opts.Comparer.Compare,
+			base.MakeInternalKey([]byte(start), seqNum, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(end), seqNum, InternalKeyKindSet))
+		return m
+	}
+	// L4 and L5 both exceed their maximum sizes, with L4's score the higher.
+	var files [numLevels][]*fileMetadata
+	files[4] = []*fileMetadata{newFile(1, "a", "b", 3, 16<<20)}
+	files[5] = []*fileMetadata{newFile(2, "x", "y", 2, 16<<20)}
+	files[6] = []*fileMetadata{newFile(3, "a", "b", 1, 32<<20), newFile(4, "x", "z", 1, 32<<20)}
+
+	pick := func(files [numLevels][]*fileMetadata) *pickedCompaction {
+		var sizes [numLevels]int64
+		for level := range files {
+			for _, f := range files[level] {
+				sizes[level] += int64(f.Size)
+			}
+		}
+		p := newCompactionPicker(newVersion(opts, files), opts, nil, sizes, diskAvailBytesInf)
+		return p.pickAuto(compactionEnv{earliestUnflushedSeqNum: InternalKeySeqNumMax})
+	}
+
+	pc := pick(files)
+	require.NotNil(t, pc)
+	require.Equal(t, 4, pc.startLevel.level)
+
+	// A hook prioritizing compactions of keys >= "x" picks the compaction of
+	// L5, despite its lower score.
+	var levels []int
+	opts.Experimental.CompactionHeuristicHook = func(candidates []CompactionCandidate) []CompactionCandidate {
+		levels = levels[:0]
+		for _, c := range candidates {
+			levels = append(levels, c.Level)
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return bytes.Compare(candidates[i].Largest, []byte("x")) >= 0 &&
+				bytes.Compare(candidates[j].Largest, []byte("x")) < 0
+		})
+		return candidates
+	}
+	pc = pick(files)
+	require.NotNil(t, pc)
+	require.Equal(t, []int{4, 5}, levels)
+	require.Equal(t, 5, pc.startLevel.level)
+	require.Equal(t, 6, pc.outputLevel.level)
+	require.Equal(t, "x", string(pc.smallest.UserKey))
+	require.Equal(t, "z", string(pc.largest.UserKey))
+
+	// A hook vetoing every candidate prevents score-based compactions...
+	opts.Experimental.CompactionHeuristicHook = func([]CompactionCandidate) []CompactionCandidate {
+		return nil
+	}
+	require.Nil(t, pick(files))
+
+	// ...unless writes are stalled by L0's read amplification, in which case
+	// the highest scoring candidate is picked.
+	for i := 0; i < opts.L0StopWritesThreshold; i++ {
+		files[0] = append(files[0], newFile(FileNum(10+i), "m", "n", uint64(10+i), 1<<10))
+	}
+	pc = pick(files)
+	require.NotNil(t, pc)
+	opts.Experimental.CompactionHeuristicHook = nil
+	require.Equal(t, pick(files).startLevel.level, pc.startLevel.level)
+}
+
 func TestCompactionPickerEstimatedCompactionDebt(t *testing.T) {
 	datadriven.RunTest(t, "testdata/compaction_picker_estimated_debt",
 		func(d *datadriven.TestData) string {
@@ -0,0 +1,117 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// CompactionRequestKind identifies the kind of work a CompactionRequest asks
+// to begin.
+type CompactionRequestKind int
+
+const (
+	// CompactionRequestFlush requests a flush of the immutable memtables.
+	CompactionRequestFlush CompactionRequestKind = iota
+	// CompactionRequestManual requests a compaction requested through
+	// DB.Compact, at the head of the queue of manual compactions.
+	CompactionRequestManual
+	// CompactionRequestAuto requests an automatic compaction, which is only
+	// picked once admitted.
+	CompactionRequestAuto
+)
+
+// String implements fmt.Stringer.
+func (k CompactionRequestKind) String() string {
+	switch k {
+	case CompactionRequestFlush:
+		return "flush"
+	case CompactionRequestManual:
+		return "manual"
+	case CompactionRequestAuto:
+		return "auto"
+	default:
+		return "unknown"
+	}
+}
+
+// priority returns the priority of requests of the kind: flushes free the
+// memtables that writes may be stalled on, and callers are blocked on manual
+// compactions.
+func (k CompactionRequestKind) priority() int {
+	switch k {
+	case CompactionRequestFlush:
+		return 2
+	case CompactionRequestManual:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CompactionCounts holds counts of flushes and compactions by kind.
+type CompactionCounts struct {
+	Flushes   int
+	Manual    int
+	Automatic int
+}
+
+// CompactionRequest describes a flush or compaction awaiting admission by a
+// CompactionScheduler.
+type CompactionRequest struct {
+	Kind CompactionRequestKind
+	// Priority orders the request relative to requests of other kinds, higher
+	// values being more urgent. Flushes have the highest priority, followed
+	// by manual compactions and then automatic compactions.
+	Priority int
+	// InProgress holds the numbers of flushes and compactions already in
+	// progress. Delete-only compactions are counted as automatic compactions.
+	InProgress CompactionCounts
+	// PendingManual is the number of manual compactions waiting to be
+	// scheduled, including the requested one for a CompactionRequestManual.
+	PendingManual int
+}
+
+// CompactionScheduler decides the admission of flushes and compactions,
+// allowing a policy such as fairness between manual and automatic
+// compactions to be imposed. It's consulted once the DB has determined that a
+// flush or compaction may begin, within the limit of
+// MaxConcurrentCompactions.
+//
+// A denied request is retried when the DB next considers scheduling work of
+// its kind, which happens at the latest when an in-progress flush or
+// compaction completes. A scheduler that denies a compaction while no flush or
+// compaction is in progress may stall the compaction indefinitely. A flush is
+// admitted without consulting the scheduler once the queued memtables reach
+// Options.MemTableStopWritesThreshold, so that writes aren't stalled
+// indefinitely.
+type CompactionScheduler interface {
+	// Admit returns true if the requested flush or compaction may begin. It's
+	// called while the DB's mutex is held, and must not call into the DB.
+	Admit(req CompactionRequest) bool
+}
+
+// defaultCompactionScheduler admits every request, leaving the scheduling of
+// flushes and compactions to the DB.
+type defaultCompactionScheduler struct{}
+
+// Admit implements CompactionScheduler.
+func (defaultCompactionScheduler) Admit(CompactionRequest) bool { return true }
+
+// admitLocked consults the configured CompactionScheduler on the admission
+// of a flush or compaction of the provided kind.
+//
+// d.mu must be held when calling this.
+func (d *DB) admitLocked(kind CompactionRequestKind) bool {
+	req := CompactionRequest{
+		Kind:     kind,
+		Priority: kind.priority(),
+		InProgress: CompactionCounts{
+			Manual:    d.mu.compact.manualCompactingCount,
+			Automatic: d.mu.compact.compactingCount - d.mu.compact.manualCompactingCount,
+		},
+		PendingManual: len(d.mu.compact.manual),
+	}
+	if d.mu.compact.flushing {
+		req.InProgress.Flushes = 1
+	}
+	return d.opts.Experimental.CompactionScheduler.Admit(req)
+}
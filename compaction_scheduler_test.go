@@ -0,0 +1,182 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// oneManualCompactionScheduler admits at most one manual compaction at a
+// time, and every flush and automatic compaction.
+type oneManualCompactionScheduler struct {
+	mu       sync.Mutex
+	requests []CompactionRequest
+	denied   int
+}
+
+func (s *oneManualCompactionScheduler) Admit(req CompactionRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	if req.Kind == CompactionRequestManual && req.InProgress.Manual > 0 {
+		s.denied++
+		return false
+	}
+	return true
+}
+
+func (s *oneManualCompactionScheduler) numDenied() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.denied
+}
+
+func TestCompactionScheduler(t *testing.T) {
+	scheduler := &oneManualCompactionScheduler{}
+	// Block the first manual compaction while it's writing its output, with
+	// the DB's mutex released.
+	var blockOnce sync.Once
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var armed bool
+	opts := &Options{
+		FS:                       vfs.NewMem(),
+		L0CompactionThreshold:    100,
+		L0StopWritesThreshold:    100,
+		MaxConcurrentCompactions: func() int { return 4 },
+		EventListener: EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if armed && info.Reason == "compacting" {
+					blockOnce.Do(func() {
+						close(blocked)
+						<-release
+					})
+				}
+			},
+		},
+	}
+	opts.Experimental.CompactionScheduler = scheduler
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write two disjoint key ranges, each to a pair of overlapping sstables
+	// so that compacting a range rewrites it.
+	for _, prefix := range []string{"a", "b"} {
+		for j := 0; j < 2; j++ {
+			for i := 0; i < 10; i++ {
+				k := []byte(fmt.Sprintf("%s%02d", prefix, i))
+				require.NoError(t, d.Set(k, k, nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+	}
+	armed = true
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	compact := func(start, end string) {
+		defer wg.Done()
+		require.NoError(t, d.Compact([]byte(start), []byte(end), false /* parallelize */))
+	}
+	go compact("a", "a99")
+	<-blocked
+
+	// While the first manual compaction is in progress, a second is denied
+	// admission.
+	go compact("b", "b99")
+	for scheduler.numDenied() == 0 {
+		d.mu.Lock()
+		d.maybeScheduleCompaction()
+		d.mu.Unlock()
+	}
+
+	// But automatic compactions continue to be admitted.
+	d.mu.Lock()
+	d.maybeScheduleCompaction()
+	d.mu.Unlock()
+	scheduler.mu.Lock()
+	last := scheduler.requests[len(scheduler.requests)-1]
+	scheduler.mu.Unlock()
+	require.Equal(t, CompactionRequestAuto, last.Kind)
+	require.Equal(t, 1, last.InProgress.Manual)
+
+	// Once the first manual compaction completes, the second is admitted.
+	close(release)
+	wg.Wait()
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	var admittedManual int
+	for _, req := range scheduler.requests {
+		require.LessOrEqual(t, req.InProgress.Manual, 1)
+		require.Equal(t, req.Kind.priority(), req.Priority)
+		if req.Kind == CompactionRequestManual && req.InProgress.Manual == 0 {
+			admittedManual++
+		}
+	}
+	require.Equal(t, 2, admittedManual)
+}
+
+// denyFlushesScheduler denies every flush, counting them.
+type denyFlushesScheduler struct {
+	mu     sync.Mutex
+	denied int
+}
+
+func (s *denyFlushesScheduler) Admit(req CompactionRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Kind == CompactionRequestFlush {
+		s.denied++
+		return false
+	}
+	return true
+}
+
+func TestCompactionSchedulerStopWrites(t *testing.T) {
+	scheduler := &denyFlushesScheduler{}
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		MemTableSize:                1 << 20,
+		MemTableStopWritesThreshold: 2,
+	}
+	opts.Experimental.CompactionScheduler = scheduler
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Writing several memtables' worth of data fills the memtables, which
+	// are flushed despite the scheduler denying every flush, rather than
+	// stalling writes indefinitely.
+	done := make(chan error, 1)
+	go func() {
+		v := make([]byte, 1<<10)
+		for i := 0; i < 10<<10; i++ {
+			if err := d.Set([]byte(fmt.Sprintf("%06d", i)), v, nil); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("writes stalled")
+	}
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	require.Greater(t, scheduler.denied, 0)
+	require.Greater(t, d.Metrics().Flush.Count, int64(0))
+}
@@ -6,7 +6,10 @@ package pebble
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"path/filepath"
@@ -23,11 +26,15 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/internal/testkeys/blockprop"
+	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -872,7 +879,7 @@ func TestCompactionTransform(t *testing.T) {
 				disableSpanElision: disableElision,
 				inuseKeyRanges:     keyRanges,
 			}
-			transformer := rangeKeyCompactionTransform(snapshots, c.elideRangeTombstone)
+			transformer := rangeKeyCompactionTransform(snapshots, c.elideRangeTombstone, nil /* merge */)
 			if err := transformer.Transform(base.DefaultComparer.Compare, span, &outSpan); err != nil {
 				return fmt.Sprintf("error: %s", err)
 			}
@@ -2389,6 +2396,268 @@ func TestCompactionReadTriggered(t *testing.T) {
 		})
 }
 
+func TestCompactionReadTriggeredReducesOverlap(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	opts.Experimental.ReadCompactionRate = 1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Ingest two sstables spanning the same keys. The first is ingested into
+	// L6, and the second overlaps it and is ingested into L0.
+	ingest := func(name, value string) {
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+		for c := 'a'; c <= 'z'; c++ {
+			require.NoError(t, w.Set([]byte{byte(c)}, []byte(value)))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}))
+	}
+	ingest("ext1", "old")
+	ingest("ext2", "new")
+	require.Equal(t, int64(1), d.Metrics().Levels[0].NumFiles)
+	require.Equal(t, int64(1), d.Metrics().Levels[6].NumFiles)
+
+	// Repeatedly reading the overlapping range exhausts the allowed seeks of
+	// the L0 sstable, triggering a read compaction that moves its keys into
+	// L6.
+	for i := 0; i < 100 && d.Metrics().Compact.ReadCount == 0; i++ {
+		iter := d.NewIter(nil)
+		iter.readSampling.forceReadSampling = true
+		n := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			require.Equal(t, "new", string(iter.Value()))
+			n++
+		}
+		require.Equal(t, 26, n)
+		require.NoError(t, iter.Close())
+
+		// Closing the iterator may asynchronously schedule the read
+		// compaction. Wait for it to complete.
+		d.compactionSchedulers.Wait()
+		d.mu.Lock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		d.mu.Unlock()
+	}
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Compact.ReadCount)
+	require.Equal(t, int64(0), m.Levels[0].NumFiles)
+	require.Equal(t, int64(1), m.Levels[6].NumFiles)
+}
+
+func TestCompactionOutputSplitter(t *testing.T) {
+	// suffixIntervals writes keys with increasing suffixes, compacts them into
+	// L6 and returns the number of L6 sstables and the widest interval of
+	// suffixes collected within any of them.
+	suffixIntervals := func(newSplitter func() OutputSplitter) (tables int, maxWidth uint64) {
+		opts := &Options{
+			BlockPropertyCollectors: []func() BlockPropertyCollector{
+				blockprop.NewBlockPropertyCollector,
+			},
+			Comparer:                    testkeys.Comparer,
+			DisableAutomaticCompactions: true,
+			FormatMajorVersion:          FormatNewest,
+			FS:                          vfs.NewMem(),
+		}
+		opts.Experimental.NewOutputSplitter = newSplitter
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		for i := 0; i < 1000; i++ {
+			key := testkeys.KeyAt(testkeys.Alpha(4), i, i+1)
+			require.NoError(t, d.Set(key, []byte("value"), nil))
+		}
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Compact([]byte("a"), []byte("zzzz"), false /* parallelize */))
+
+		levels, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		for l := 0; l < numLevels-1; l++ {
+			require.Empty(t, levels[l])
+		}
+		for _, info := range levels[numLevels-1] {
+			// The property is prefixed by the collector's short ID, and
+			// encodes the interval's lower bound followed by its width.
+			prop := []byte(info.Properties.UserProperties["pebble.internal.testkeys.suffixes"])
+			require.Greater(t, len(prop), 1)
+			prop = prop[1:]
+			_, n := binary.Uvarint(prop)
+			require.Greater(t, n, 0)
+			width, _ := binary.Uvarint(prop[n:])
+			if width > maxWidth {
+				maxWidth = width
+			}
+		}
+		return len(levels[numLevels-1]), maxWidth
+	}
+
+	tables, width := suffixIntervals(nil)
+	require.Equal(t, 1, tables)
+	require.Equal(t, uint64(1000), width)
+
+	tables, width = suffixIntervals(func() OutputSplitter { return blockprop.NewOutputSplitter(100) })
+	require.GreaterOrEqual(t, tables, 10)
+	require.LessOrEqual(t, width, uint64(100))
+}
+
+func TestCompactionPerLevelOptions(t *testing.T) {
+	// L0-L5 use Snappy with bloom filters, while L6 uses Zstd without filters.
+	opts := &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+		Levels:                      make([]LevelOptions, numLevels),
+	}
+	for i := range opts.Levels {
+		opts.Levels[i].Compression = SnappyCompression
+		opts.Levels[i].FilterPolicy = bloom.FilterPolicy(10)
+	}
+	opts.Levels[numLevels-1].Compression = ZstdCompression
+	opts.Levels[numLevels-1].FilterPolicy = nil
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	checkTables := func(level int, compression string, filter bool) {
+		levels, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		for l := range levels {
+			if l != level {
+				require.Empty(t, levels[l], "L%d", l)
+			}
+		}
+		require.NotEmpty(t, levels[level])
+		for _, info := range levels[level] {
+			require.Equal(t, compression, info.Properties.CompressionName)
+			if filter {
+				require.Equal(t, "rocksdb.BuiltinBloomFilter", info.Properties.FilterPolicyName)
+				require.NotZero(t, info.Properties.FilterSize)
+			} else {
+				require.Empty(t, info.Properties.FilterPolicyName)
+				require.Zero(t, info.Properties.FilterSize)
+			}
+		}
+	}
+
+	// Flush two overlapping memtables, so the compaction into L6 rewrites the
+	// sstables rather than moving them.
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("key%03d", j))
+			require.NoError(t, d.Set(key, []byte(fmt.Sprintf("value%d", i)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	checkTables(0, "Snappy", true)
+
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false /* parallelize */))
+	checkTables(numLevels-1, "ZSTD", false)
+
+	for j := 0; j < 100; j++ {
+		v, closer, err := d.Get([]byte(fmt.Sprintf("key%03d", j)))
+		require.NoError(t, err)
+		require.Equal(t, "value1", string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestCompactionMaxTableKeys(t *testing.T) {
+	outputTables := func(maxTableKeys int64) []SSTableInfo {
+		opts := &Options{
+			DisableAutomaticCompactions: true,
+			FS:                          vfs.NewMem(),
+			Levels:                      make([]LevelOptions, numLevels),
+		}
+		for i := range opts.Levels {
+			opts.Levels[i].MaxTableKeys = maxTableKeys
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		// Flush two overlapping memtables of tiny keys, so the compaction into
+		// L6 rewrites the sstables rather than moving them.
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 1000; j++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", j)), nil, nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+		require.NoError(t, d.Compact([]byte("0000"), []byte("9999"), false /* parallelize */))
+
+		levels, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		for l := 0; l < numLevels-1; l++ {
+			require.Empty(t, levels[l])
+		}
+		return levels[numLevels-1]
+	}
+
+	// Size-based splitting alone produces a single sstable spanning the
+	// entire key range.
+	tables := outputTables(0)
+	require.Len(t, tables, 1)
+	require.Equal(t, uint64(1000), tables[0].Properties.NumEntries)
+
+	// Each output bounded by the key count spans a narrower key range.
+	tables = outputTables(100)
+	require.Len(t, tables, 10)
+	for i, info := range tables {
+		require.Equal(t, uint64(100), info.Properties.NumEntries)
+		require.Equal(t, fmt.Sprintf("%04d", 100*i), string(info.Smallest.UserKey))
+		require.Equal(t, fmt.Sprintf("%04d", 100*i+99), string(info.Largest.UserKey))
+	}
+}
+
+func TestCompactionBackgroundIOLimiter(t *testing.T) {
+	const bytesPerSec = 512 << 10
+	limiter := NewIOLimiter(bytesPerSec)
+	d, err := Open("", &Options{
+		BackgroundIOLimiter:         limiter,
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Flush two overlapping memtables of incompressible values without a
+	// limit, so the compaction into L6 rewrites the sstables.
+	limiter.SetRate(0)
+	require.Zero(t, limiter.Rate())
+	rng := rand.New(rand.NewSource(0))
+	value := make([]byte, 1<<10)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 768; j++ {
+			rng.Read(value)
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", j)), value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	limiter.SetRate(bytesPerSec)
+	require.Equal(t, bytesPerSec, limiter.Rate())
+	start := time.Now()
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false /* parallelize */))
+	elapsed := time.Since(start)
+
+	// Every byte beyond the limiter's burst must have waited for the limiter.
+	levels, err := d.SSTables()
+	require.NoError(t, err)
+	require.NotEmpty(t, levels[numLevels-1])
+	var size uint64
+	for _, info := range levels[numLevels-1] {
+		size += info.Size
+	}
+	require.Greater(t, size, uint64(768<<10))
+	minElapsed := time.Duration(float64(size-bytesPerSec) / bytesPerSec * float64(time.Second))
+	require.GreaterOrEqual(t, elapsed, minElapsed)
+}
+
 func TestCompactionInuseKeyRanges(t *testing.T) {
 	cmp := DefaultComparer.Compare
 	parseMeta := func(s string) *fileMetadata {
@@ -3201,6 +3470,68 @@ func TestCompactFlushQueuedLargeBatch(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestCompactAsync(t *testing.T) {
+	d, err := Open("", testingRandomized(&Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	}))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Write several overlapping L0 tables, leaving the last writes in the
+	// memtable so that the compactions must also wait for a flush.
+	for i := 0; i < 4; i++ {
+		for c := 'a'; c <= 'z'; c++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%c%d", c, i)), []byte("v"), nil))
+		}
+		if i < 3 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// Launch compactions over disjoint and overlapping ranges. The bounds are
+	// reused once CompactAsync returns.
+	var chans []<-chan error
+	var start, end []byte
+	for _, r := range [][2]string{{"a", "f"}, {"g", "m"}, {"n", "z"}, {"a", "z"}, {"c", "p"}} {
+		start = append(start[:0], r[0]...)
+		end = append(end[:0], r[1]+"\xff"...)
+		ch, err := d.CompactAsync(start, end)
+		require.NoError(t, err)
+		chans = append(chans, ch)
+	}
+	for i, ch := range chans {
+		select {
+		case err := <-ch:
+			require.NoError(t, err)
+		case <-time.After(30 * time.Second):
+			t.Fatalf("compaction %d did not complete", i)
+		}
+		// The channel is closed once the result is delivered.
+		_, ok := <-ch
+		require.False(t, ok)
+	}
+
+	// Every key was compacted out of the memtable and L0.
+	d.mu.Lock()
+	v := d.mu.versions.currentVersion()
+	require.Equal(t, 0, v.Levels[0].Len())
+	require.True(t, d.mu.mem.mutable.empty())
+	d.mu.Unlock()
+	iter := d.NewIter(nil)
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 4*26, n)
+
+	_, err = d.CompactAsync([]byte("b"), []byte("a"))
+	require.Error(t, err)
+}
+
 // Regression test for #747. Test a problematic series of "cleaner" operations
 // that could previously lead to DB.disableFileDeletions blocking forever even
 // though no cleaning was in progress.
@@ -3654,6 +3985,66 @@ func TestMarkedForCompaction(t *testing.T) {
 	})
 }
 
+func TestMark(t *testing.T) {
+	opts := &Options{
+		FS:                        vfs.NewMem(),
+		FormatMajorVersion:        FormatNewest,
+		L0CompactionThreshold:     100,
+		L0CompactionFileThreshold: 100,
+		L0StopWritesThreshold:     100,
+	}
+	opts.Experimental.EnableMarkedForCompaction = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Flush three non-overlapping sstables into L0, which remain there.
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 3)
+	var files []FileNum
+	for _, info := range tables[0] {
+		files = append(files, info.FileNum)
+	}
+
+	// Marking sstables fails unless it's enabled.
+	d.opts.Experimental.EnableMarkedForCompaction = false
+	require.Error(t, d.Mark(files))
+	d.opts.Experimental.EnableMarkedForCompaction = true
+
+	// Marking an sstable that doesn't exist fails.
+	require.Error(t, d.Mark([]FileNum{files[0], 100}))
+	require.Equal(t, 0, d.Metrics().Compact.MarkedFiles)
+
+	// Background compactions rewrite each of the marked sstables.
+	require.NoError(t, d.Mark(files))
+	d.mu.Lock()
+	for d.mu.versions.currentVersion().Stats.MarkedForCompaction > 0 || d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+
+	m := d.Metrics()
+	require.Equal(t, 0, m.Compact.MarkedFiles)
+	require.Equal(t, int64(3), m.Compact.ByKind[CompactionKindRewrite].Count)
+	tables, err = d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 3)
+	for _, info := range tables[0] {
+		require.NotContains(t, files, info.FileNum)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, []byte(k), v)
+		require.NoError(t, closer.Close())
+	}
+}
+
 // createManifestErrorInjector injects errors (when enabled) into vfs.FS calls
 // to create MANIFEST files.
 type createManifestErrorInjector struct {
@@ -3776,3 +4167,733 @@ func TestCompaction_LogAndApplyFails(t *testing.T) {
 		})
 	}
 }
+
+func TestCompactionInfoInputsOutputs(t *testing.T) {
+	mem := vfs.NewMem()
+	var mu sync.Mutex
+	var infos []CompactionInfo
+	d, err := Open("", &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		EventListener: EventListener{
+			CompactionEnd: func(info CompactionInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				infos = append(infos, info)
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	write := func(value string) {
+		for i := 0; i < 3; i++ {
+			for c := 'a'; c <= 'z'; c++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%c%d", c, i)), []byte(value), nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+	}
+	// The first compaction moves the L0 tables into L6. The second compaction
+	// has inputs in both L0 and L6.
+	write("foo")
+	require.NoError(t, d.Compact([]byte("a"), []byte("z\xff"), false /* parallelize */))
+	write("bar")
+	require.NoError(t, d.Compact([]byte("a"), []byte("z\xff"), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	// Read the version edits from the MANIFEST, recording the metadata of
+	// every table added.
+	filenames, err := mem.List("")
+	require.NoError(t, err)
+	var edits []*versionEdit
+	for _, filename := range filenames {
+		fileType, _, ok := base.ParseFilename(mem, filename)
+		if !ok || fileType != fileTypeManifest {
+			continue
+		}
+		f, err := mem.Open(filename)
+		require.NoError(t, err)
+		rr := record.NewReader(f, 0 /* logNum */)
+		for {
+			r, err := rr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			ve := &versionEdit{}
+			require.NoError(t, ve.Decode(r))
+			edits = append(edits, ve)
+		}
+		require.NoError(t, f.Close())
+	}
+	tables := make(map[base.FileNum]TableInfo)
+	for _, ve := range edits {
+		for _, nf := range ve.NewFiles {
+			tables[nf.Meta.FileNum] = nf.Meta.TableInfo()
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, infos, 2)
+	require.Len(t, infos[1].Input, 2)
+	for _, info := range infos {
+		require.True(t, info.Done)
+		require.NoError(t, info.Err)
+
+		// Find the version edit that deleted the compaction's inputs.
+		deleted := make(map[deletedFileEntry]bool)
+		for _, level := range info.Input {
+			for _, table := range level.Tables {
+				deleted[deletedFileEntry{Level: level.Level, FileNum: table.FileNum}] = true
+				require.Equal(t, tables[table.FileNum], table)
+			}
+		}
+		var edit *versionEdit
+		for _, ve := range edits {
+			if len(ve.DeletedFiles) != len(deleted) {
+				continue
+			}
+			match := true
+			for e := range ve.DeletedFiles {
+				match = match && deleted[e]
+			}
+			if match {
+				edit = ve
+				break
+			}
+		}
+		require.NotNil(t, edit, "no version edit for compaction: %s", info)
+
+		// The compaction's outputs are the tables added by the version edit.
+		require.Len(t, info.Output.Tables, len(edit.NewFiles))
+		for i, nf := range edit.NewFiles {
+			require.Equal(t, info.Output.Level, nf.Level)
+			require.Equal(t, nf.Meta.TableInfo(), info.Output.Tables[i])
+		}
+		require.NotEmpty(t, info.Output.Tables)
+	}
+}
+
+func TestCompactionSingleDeleteValidation(t *testing.T) {
+	var violations []string
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.SingleDeleteValidation = true
+	opts.Experimental.SingleDeleteInvariantViolationCallback = func(userKey []byte) {
+		violations = append(violations, string(userKey))
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Setting "a" twice before single deleting it is a misuse; "b" is fine.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.SingleDelete([]byte("a"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("1"), nil))
+	require.NoError(t, d.SingleDelete([]byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, []string{"a"}, violations)
+}
+
+func TestCompactionL0Concurrency(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		L0CompactionThreshold:       2,
+		MaxConcurrentCompactions:    func() int { return 4 },
+	}
+	opts.Experimental.L0CompactionConcurrency = 1
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numRanges = 8
+	const depth = 4
+	key := func(r, k int) []byte {
+		return []byte(fmt.Sprintf("%c%02d", 'a'+r, k))
+	}
+
+	// Ingest one table per key range into the bottommost level. The tables
+	// bound how far each L0 compaction may be extended, so that the L0
+	// compactions remain confined to a single key range.
+	for r := 0; r < numRanges; r++ {
+		name := fmt.Sprintf("ext%d", r)
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{
+			TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+		})
+		for k := 0; k < 10; k++ {
+			require.NoError(t, w.Set(key(r, k), nil))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}))
+	}
+
+	// Build an L0 of disjoint key ranges, each of which is stacked several
+	// sublevels deep.
+	for i := 0; i < depth; i++ {
+		for r := 0; r < numRanges; r++ {
+			for k := 0; k < 10; k++ {
+				require.NoError(t, d.Set(key(r, k), key(r, k), nil))
+			}
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	d.mu.Lock()
+	require.Equal(t, numRanges*depth, d.mu.versions.currentVersion().Levels[0].Len())
+	d.opts.DisableAutomaticCompactions = false
+	d.maybeScheduleCompaction()
+
+	// The picker runs synchronously while d.mu is held, so all of the
+	// compactions it chose are visible here.
+	type bounds struct{ smallest, largest InternalKey }
+	var l0Compactions []bounds
+	for c := range d.mu.compact.inProgress {
+		if c.startLevel != nil && c.startLevel.level == 0 {
+			l0Compactions = append(l0Compactions, bounds{c.smallest, c.largest})
+		}
+	}
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	v := d.mu.versions.currentVersion()
+	d.mu.Unlock()
+
+	require.Greater(t, len(l0Compactions), 1)
+	for i := range l0Compactions {
+		for j := i + 1; j < len(l0Compactions); j++ {
+			a, b := l0Compactions[i], l0Compactions[j]
+			overlaps := d.cmp(a.smallest.UserKey, b.largest.UserKey) <= 0 &&
+				d.cmp(b.smallest.UserKey, a.largest.UserKey) <= 0
+			require.False(t, overlaps, "compactions [%s, %s] and [%s, %s] overlap",
+				a.smallest, a.largest, b.smallest, b.largest)
+		}
+	}
+
+	// The outputs of the concurrent compactions must not overlap one another
+	// within any level below L0.
+	require.NoError(t, v.CheckOrdering(d.cmp, d.opts.Comparer.FormatKey))
+
+	iter := d.NewIter(nil)
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		require.Equal(t, iter.Key(), iter.Value())
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, numRanges*10, n)
+}
+
+func TestCompactionTombstoneDensity(t *testing.T) {
+	opts := &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+	}
+	opts.Experimental.TombstoneDensityCompactionThreshold = 0.5
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numKeys = 1000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	value := bytes.Repeat([]byte("v"), 1<<10)
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set(key(i), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact(key(0), key(numKeys), false /* parallelize */))
+
+	// Delete most of the keys. Without a compaction of the tombstones into
+	// L6, the deleted keys continue to occupy disk space.
+	for i := 0; i < numKeys; i++ {
+		if i%10 != 0 {
+			require.NoError(t, d.Delete(key(i), nil))
+		}
+	}
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+
+	levels, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, levels[0], 1)
+	require.Equal(t, 1.0, levels[0][0].TombstoneDensity)
+	require.Len(t, levels[numLevels-1], 1)
+	require.Equal(t, 0.0, levels[numLevels-1][0].TombstoneDensity)
+	sizeBefore := levels[numLevels-1][0].Size
+
+	d.mu.Lock()
+	d.opts.DisableAutomaticCompactions = false
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 ||
+		d.mu.versions.metrics.Compact.ByKind[CompactionKindTombstoneDensity].Count == 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+
+	levels, err = d.SSTables()
+	require.NoError(t, err)
+	for l := 0; l < numLevels-1; l++ {
+		require.Empty(t, levels[l])
+	}
+	var sizeAfter uint64
+	for _, info := range levels[numLevels-1] {
+		sizeAfter += info.Size
+	}
+	require.Less(t, sizeAfter, sizeBefore/5)
+
+	iter := d.NewIter(nil)
+	var n int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		require.Equal(t, string(key(n*10)), string(iter.Key()))
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, numKeys/10, n)
+}
+
+func TestCompactionDeleteOnlyCoveredTables(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          mem,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Ingest three non-overlapping sstables into L6.
+	for i, keys := range [][]string{{"a", "b"}, {"c", "d"}, {"e", "f"}} {
+		name := fmt.Sprintf("ext%d", i)
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte(k)))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}))
+	}
+	levels, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, levels[numLevels-1], 3)
+	covered := make(map[FileNum]bool)
+	for _, info := range levels[numLevels-1] {
+		covered[info.FileNum] = true
+	}
+
+	// A range deletion covering all of the L6 sstables allows them to be
+	// dropped once the table stats for the flushed tombstone are collected.
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("z"), nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.opts.DisableAutomaticCompactions = false
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 ||
+		d.mu.versions.metrics.Compact.ByKind[CompactionKindDeleteOnly].Count == 0 {
+		d.mu.compact.cond.Wait()
+	}
+	// The sstables are dropped without being rewritten.
+	require.Zero(t, d.mu.versions.metrics.Compact.ByKind[CompactionKindDefault].Count)
+	d.mu.Unlock()
+
+	levels, err = d.SSTables()
+	require.NoError(t, err)
+	for _, info := range levels[numLevels-1] {
+		require.False(t, covered[info.FileNum], "L6 sstable %s was not dropped", info.FileNum)
+	}
+	iter := d.NewIter(nil)
+	require.False(t, iter.First())
+	require.NoError(t, iter.Close())
+}
+
+func TestCompactionValidateComparer(t *testing.T) {
+	// The comparer's Separator begins returning a key beyond its upper bound
+	// once broken is set.
+	var broken bool
+	comparer := *DefaultComparer
+	comparer.Name = "broken-separator"
+	comparer.Separator = func(dst, a, b []byte) []byte {
+		if broken {
+			return append(dst, 'z')
+		}
+		return DefaultComparer.Separator(dst, a, b)
+	}
+	opts := &Options{
+		Comparer:                    &comparer,
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+		Levels:                      []LevelOptions{{BlockSize: 256}},
+	}
+	opts.Experimental.ValidateComparer = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Flush two overlapping memtables, so the compaction into L6 rewrites the
+	// sstables rather than moving them.
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%03d", i)) }
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			require.NoError(t, d.Set(key(j), []byte(fmt.Sprintf("value%d", i)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	broken = true
+	err = d.Compact(key(0), key(100), false /* parallelize */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "comparer broken-separator: Separator(")
+	require.Contains(t, err.Error(), "= z, which does not lie within")
+
+	// The failed compaction leaves the LSM unchanged.
+	broken = false
+	levels, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, levels[0], 2)
+	for j := 0; j < 100; j++ {
+		v, closer, err := d.Get(key(j))
+		require.NoError(t, err)
+		require.Equal(t, "value1", string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestCompactionDisableAndDrain(t *testing.T) {
+	opts := &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 2,
+		L0StopWritesThreshold: 4,
+		LBaseMaxBytes:         64 << 10,
+	}
+	opts.Levels = []LevelOptions{{TargetFileSize: 16 << 10}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	compactions := func() int64 {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.versions.metrics.Compact.Count
+	}
+
+	// Write enough overlapping flushes to exceed L0StopWritesThreshold many
+	// times over. The writes must not stall.
+	d.DisableAutomaticCompactions()
+	rng := rand.New(rand.NewSource(1))
+	value := make([]byte, 100)
+	const flushes = 20
+	for i := 0; i < flushes; i++ {
+		for j := 0; j < 200; j++ {
+			k := []byte(fmt.Sprintf("%06d", rng.Intn(10000)))
+			require.NoError(t, d.Set(k, value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, int64(0), compactions())
+	require.Equal(t, int64(flushes), d.Metrics().Levels[0].NumFiles)
+
+	// A drain with a context that's already done returns immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, d.DrainCompactions(ctx), context.Canceled)
+
+	// Draining performs compactions until none remain, leaving L0 below its
+	// compaction threshold, and every level within its size target.
+	require.NoError(t, d.DrainCompactions(context.Background()))
+	require.Greater(t, compactions(), int64(0))
+	d.mu.Lock()
+	env := compactionEnv{earliestUnflushedSeqNum: InternalKeySeqNumMax}
+	env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
+	env.readCompactionEnv = readCompactionEnv{
+		readCompactions:          &d.mu.compact.readCompactions,
+		rescheduleReadCompaction: &d.mu.compact.rescheduleReadCompaction,
+	}
+	require.Nil(t, d.mu.versions.picker.pickAuto(env))
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.Less(t, int(m.Levels[0].Sublevels), opts.L0CompactionThreshold)
+
+	// Automatic compactions remain disabled after the drain.
+	n := compactions()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, d.Set([]byte("a"), value, nil))
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, n, compactions())
+
+	// Until they're re-enabled.
+	d.EnableAutomaticCompactions()
+	d.mu.Lock()
+	for d.mu.versions.metrics.Compact.Count == n || d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+}
+
+func TestCompactionDisabledByOptionsL0Stall(t *testing.T) {
+	// A DB opened with Options.DisableAutomaticCompactions continues to stall
+	// writes on L0, unlike one whose automatic compactions are disabled
+	// through DB.DisableAutomaticCompactions.
+	stalls := make(chan WriteStallBeginInfo, 1)
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		L0CompactionThreshold:       1,
+		L0StopWritesThreshold:       2,
+		EventListener: EventListener{
+			WriteStallBegin: func(info WriteStallBeginInfo) {
+				stalls <- info
+			},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte("a"), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Set([]byte("z"), nil, nil))
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Flush() }()
+	require.Equal(t, WriteStallL0FileCount, (<-stalls).Cause)
+
+	// A manual compaction of L0 ends the stall.
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false /* parallelize */))
+	require.NoError(t, <-errCh)
+}
+
+func TestCompactionRewriteFilters(t *testing.T) {
+	mem := vfs.NewMem()
+	open := func(bitsPerKey int) *DB {
+		opts := &Options{
+			FS:                          mem,
+			Comparer:                    testkeys.Comparer,
+			DisableAutomaticCompactions: true,
+		}
+		opts.Levels = make([]LevelOptions, numLevels)
+		for l := range opts.Levels {
+			opts.Levels[l] = LevelOptions{
+				FilterPolicy:   bloom.FilterPolicy(bitsPerKey),
+				TargetFileSize: 4 << 10,
+			}
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		return d
+	}
+
+	// Write the keys 0000, 0002, ..., 3998 into L6 with a weak filter policy.
+	d := open(1)
+	const n = 2000
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("%04d", 2*i))
+		require.NoError(t, d.Set(k, k, nil))
+	}
+	require.NoError(t, d.Compact([]byte("0"), []byte("9"), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	// Reopen with a stronger filter policy.
+	d = open(10)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// filterHits looks up absent keys, returning the number of lookups that
+	// the filters rule out.
+	filterHits := func() int64 {
+		before := d.Metrics().Filter.Hits
+		iter := d.NewIter(&IterOptions{UseL6Filters: true})
+		for i := 0; i < n; i++ {
+			require.False(t, iter.SeekPrefixGE([]byte(fmt.Sprintf("%04d", 2*i+1))))
+		}
+		require.NoError(t, iter.Close())
+		return d.Metrics().Filter.Hits - before
+	}
+	type tableInfo struct {
+		smallest, largest string
+		numDataBlocks     uint64
+		dataSize          uint64
+		filterSize        uint64
+		// data holds the table's data blocks.
+		data string
+	}
+	tables := func() []tableInfo {
+		levels, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		var infos []tableInfo
+		for l := range levels {
+			for _, tbl := range levels[l] {
+				require.Equal(t, numLevels-1, l)
+				f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeTable, tbl.FileNum))
+				require.NoError(t, err)
+				data := make([]byte, tbl.Properties.DataSize)
+				_, err = f.ReadAt(data, 0)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+				infos = append(infos, tableInfo{
+					smallest:      string(tbl.Smallest.UserKey),
+					largest:       string(tbl.Largest.UserKey),
+					numDataBlocks: tbl.Properties.NumDataBlocks,
+					dataSize:      tbl.Properties.DataSize,
+					filterSize:    tbl.Properties.FilterSize,
+					data:          string(data),
+				})
+			}
+		}
+		return infos
+	}
+	hitsBefore := filterHits()
+	tablesBefore := tables()
+	require.Greater(t, len(tablesBefore), 1)
+
+	require.NoError(t, d.RewriteFilters(numLevels-1))
+	m := d.Metrics()
+	require.Equal(t, int64(len(tablesBefore)), m.Compact.ByKind[CompactionKindFilterRewrite].Count)
+	require.Equal(t, int64(0), m.Compact.RewriteCount)
+
+	// The filters are larger and rule out many more lookups, while the data
+	// blocks and table bounds are unchanged, as the data blocks are copied
+	// verbatim.
+	hitsAfter := filterHits()
+	require.Greater(t, hitsAfter, hitsBefore)
+	tablesAfter := tables()
+	require.Equal(t, len(tablesBefore), len(tablesAfter))
+	for i := range tablesBefore {
+		require.Greater(t, tablesAfter[i].filterSize, tablesBefore[i].filterSize)
+		tablesAfter[i].filterSize = tablesBefore[i].filterSize
+		require.Equal(t, tablesBefore[i], tablesAfter[i])
+	}
+
+	// Rewriting an empty level is a noop.
+	require.NoError(t, d.RewriteFilters(0))
+	require.Error(t, d.RewriteFilters(numLevels))
+}
+
+func TestCompactionObsoleteVersionGC(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		Comparer:                    testkeys.Comparer,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.MVCCGCDecodeSuffix = func(suffix []byte) (uint64, bool) {
+		v, err := testkeys.ParseSuffix(suffix)
+		return uint64(v), err == nil
+	}
+	// Retain versions @5 and newer of prefix "a", and versions @3 and newer of
+	// every other prefix.
+	opts.Experimental.MVCCGCThreshold = 3
+	var calls []string
+	opts.Experimental.MVCCGCKeepFromSuffix = func(prefix []byte) uint64 {
+		calls = append(calls, string(prefix))
+		if string(prefix) == "a" {
+			return 5
+		}
+		return opts.Experimental.MVCCGCThreshold
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, prefix := range []string{"a", "b"} {
+		for v := 1; v <= 6; v++ {
+			k := fmt.Sprintf("%s@%d", prefix, v)
+			require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		}
+	}
+	require.NoError(t, d.Delete([]byte("b@1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+
+	keys := func() string {
+		iter := d.NewIter(nil)
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+	const all = "a@6 a@5 a@4 a@3 a@2 a@1 b@6 b@5 b@4 b@3 b@2 c"
+
+	// Flushes don't drop obsolete versions.
+	require.Equal(t, all, keys())
+
+	// Nor do compactions while a snapshot is open.
+	snap := d.NewSnapshot()
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.Equal(t, all, keys())
+
+	// Once the snapshot is closed, a compaction drops the versions older than
+	// those retained for each prefix. The oldest version to retain is
+	// determined once per prefix. Overwrite a key so that the compaction
+	// rewrites the L6 table written by the previous one.
+	require.NoError(t, snap.Close())
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+	calls = nil
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	require.Equal(t, "a@6 a@5 b@6 b@5 b@4 b@3 c", keys())
+	require.Equal(t, []string{"a", "b"}, calls)
+}
+
+func TestCompactionPerLevelBlockSize(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		Levels:                      make([]LevelOptions, numLevels),
+	}
+	for i := range opts.Levels {
+		opts.Levels[i] = LevelOptions{BlockSize: 1 << 10, Compression: NoCompression}
+	}
+	opts.Levels[6].BlockSize = 8 << 10
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write two overlapping L0 tables, so that they're rewritten rather than
+	// moved into L6.
+	for j := 0; j < 2; j++ {
+		for i := j; i < 2000; i += 2 {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte("v"), 100), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	// checkBlockSizes checks that the data blocks of each of the level's
+	// sstables, other than its last block, are close to the level's block
+	// size.
+	checkBlockSizes := func(level int) {
+		blockSize := uint64(opts.Levels[level].BlockSize)
+		d.mu.Lock()
+		files := d.mu.versions.currentVersion().Levels[level].Slice()
+		d.mu.Unlock()
+		require.NotZero(t, files.Len())
+		files.Each(func(m *fileMetadata) {
+			f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeTable, m.FileNum))
+			require.NoError(t, err)
+			r, err := sstable.NewReader(f, sstable.ReaderOptions{})
+			require.NoError(t, err)
+			defer r.Close()
+			layout, err := r.Layout()
+			require.NoError(t, err)
+			require.Greater(t, len(layout.Data), 1)
+			for _, bh := range layout.Data[:len(layout.Data)-1] {
+				require.GreaterOrEqual(t, bh.Length, blockSize/2)
+				require.LessOrEqual(t, bh.Length, blockSize+200)
+			}
+		})
+	}
+
+	// The flushes wrote L0 tables with L0's block size, and the compaction
+	// into L6 rewrites them with L6's.
+	checkBlockSizes(0)
+	require.NoError(t, d.Compact([]byte("0000"), []byte("9999"), false /* parallelize */))
+	checkBlockSizes(6)
+}
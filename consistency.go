@@ -0,0 +1,182 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// CheckConsistency checks the integrity of the LSM. It checks that:
+//   - Every sstable referenced by the current version exists with the size
+//     recorded in the MANIFEST, whether it's stored locally or remotely.
+//   - The sstables within each L0 sublevel and within each of L1-L6 are
+//     ordered and have non-overlapping key ranges.
+//   - The smallest and largest user keys recorded for each sstable match its
+//     contents, and its keys lie within its recorded sequence number range.
+//   - The entries in the DB respect the sequence number ordering across
+//     levels, as verified by CheckLevels.
+//
+// Rather than stopping at the first violation, CheckConsistency returns an
+// error describing all of them. It reads every sstable in the DB, and is
+// expensive for a large DB.
+func (d *DB) CheckConsistency() error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+
+	readState := d.loadReadState()
+	defer readState.unref()
+	v := readState.current
+	format := d.opts.Comparer.FormatKey
+
+	var errs []error
+	if err := v.CheckConsistency(d.dirname, d.opts.FS); err != nil {
+		errs = append(errs, err)
+	}
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.RemoteLocator != "" {
+				if err := d.checkRemoteTableSize(f); err != nil {
+					errs = append(errs, errors.Wrapf(err, "L%d: %s", errors.Safe(level), f.FileNum))
+				}
+			}
+		}
+	}
+	for sublevel := len(v.L0SublevelFiles) - 1; sublevel >= 0; sublevel-- {
+		files := v.L0SublevelFiles[sublevel].Iter()
+		if err := manifest.CheckOrdering(d.cmp, format, manifest.L0Sublevel(sublevel), files); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for level := range v.Levels {
+		files := v.Levels[level].Iter()
+		if err := manifest.CheckOrdering(d.cmp, format, manifest.Level(level), files); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			// The bounds of a virtual sstable may be looser than the keys of
+			// its backing sstable that lie within them.
+			if f.Virtual {
+				continue
+			}
+			if err := d.checkTableContents(f); err != nil {
+				errs = append(errs, errors.Wrapf(err, "L%d: %s", errors.Safe(level), f.FileNum))
+			}
+		}
+	}
+	if err := d.CheckLevels(nil); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i := range errs {
+		msgs[i] = errs[i].Error()
+	}
+	return base.CorruptionErrorf("pebble: %d consistency violation(s):\n%s",
+		errors.Safe(len(errs)), strings.Join(msgs, "\n"))
+}
+
+// checkRemoteTableSize checks that the size of the remote object holding the
+// physical sstable of f matches the size recorded in the MANIFEST.
+func (d *DB) checkRemoteTableSize(f *fileMetadata) error {
+	size := f.Size
+	if f.Virtual {
+		size = f.FileBacking.Size
+	}
+	objSize, err := d.objProvider.Size(f.PhysicalFileNum())
+	if err != nil {
+		return err
+	}
+	if objSize != int64(size) {
+		return errors.Errorf("file size mismatch (%s): %d (remote) != %d (MANIFEST)",
+			d.objProvider.Path(d.objProvider.Lookup(f.PhysicalFileNum())),
+			errors.Safe(objSize), errors.Safe(size))
+	}
+	return nil
+}
+
+// checkTableContents checks that the smallest and largest user keys recorded
+// for an sstable are those of its keys, and that the sequence numbers of its
+// keys lie within its recorded sequence number range.
+func (d *DB) checkTableContents(f *fileMetadata) error {
+	var smallest, largest []byte
+	smallestSeqNum, largestSeqNum := uint64(InternalKeySeqNumMax), uint64(0)
+	empty := true
+	add := func(start, end []byte, seqNum uint64) {
+		if empty || d.cmp(start, smallest) < 0 {
+			smallest = append(smallest[:0], start...)
+		}
+		if empty || d.cmp(end, largest) > 0 {
+			largest = append(largest[:0], end...)
+		}
+		if seqNum < smallestSeqNum {
+			smallestSeqNum = seqNum
+		}
+		if seqNum > largestSeqNum {
+			largestSeqNum = seqNum
+		}
+		empty = false
+	}
+
+	iter, rangeDelIter, err := d.newIters(f, nil /* opts */, internalIterOpts{})
+	if err != nil {
+		return err
+	}
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		add(key.UserKey, key.UserKey, key.SeqNum())
+	}
+	err = firstError(iter.Error(), iter.Close())
+	if rangeDelIter != nil {
+		for s := rangeDelIter.First(); s != nil; s = rangeDelIter.Next() {
+			for _, k := range s.Keys {
+				add(s.Start, s.End, k.SeqNum())
+			}
+		}
+		err = firstError(err, firstError(rangeDelIter.Error(), rangeDelIter.Close()))
+	}
+	if err == nil && f.HasRangeKeys {
+		rangeKeyIter, err := d.tableNewRangeKeyIter(f, nil /* opts */)
+		if err != nil {
+			return err
+		}
+		for s := rangeKeyIter.First(); s != nil; s = rangeKeyIter.Next() {
+			for _, k := range s.Keys {
+				add(s.Start, s.End, k.SeqNum())
+			}
+		}
+		if err := firstError(rangeKeyIter.Error(), rangeKeyIter.Close()); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	format := d.opts.Comparer.FormatKey
+	if empty {
+		return errors.New("sstable contains no keys")
+	}
+	if d.cmp(smallest, f.Smallest.UserKey) != 0 || d.cmp(largest, f.Largest.UserKey) != 0 {
+		return errors.Errorf("bounds [%s-%s] do not match the sstable's keys [%s-%s]",
+			format(f.Smallest.UserKey), format(f.Largest.UserKey), format(smallest), format(largest))
+	}
+	if smallestSeqNum < f.SmallestSeqNum || largestSeqNum > f.LargestSeqNum {
+		return errors.Errorf("sequence numbers #%d-#%d do not contain the sstable's keys #%d-#%d",
+			errors.Safe(f.SmallestSeqNum), errors.Safe(f.LargestSeqNum),
+			errors.Safe(smallestSeqNum), errors.Safe(largestSeqNum))
+	}
+	return nil
+}
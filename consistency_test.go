@@ -0,0 +1,72 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Ingest two non-overlapping sstables into L6.
+	ingest := func(name string, keys ...string) {
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte(k)))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}))
+	}
+	ingest("ext1", "a", "b", "c")
+	ingest("ext2", "d", "e", "f")
+	require.NoError(t, d.CheckConsistency())
+
+	var files []*fileMetadata
+	d.mu.Lock()
+	d.mu.versions.currentVersion().Levels[6].Slice().Each(func(f *fileMetadata) {
+		files = append(files, f)
+	})
+	d.mu.Unlock()
+	require.Len(t, files, 2)
+
+	// Truncate the first sstable.
+	path := base.MakeFilepath(mem, "", fileTypeTable, files[0].FileNum)
+	f, err := mem.Open(path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = mem.Create(path)
+	require.NoError(t, err)
+	_, err = f.Write(data[:len(data)/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Fabricate an overlap between the two sstables by widening the bounds of
+	// the second.
+	smallest := files[1].Smallest
+	files[1].Smallest = base.MakeInternalKey([]byte("b"), 0, InternalKeyKindSet)
+	defer func() { files[1].Smallest = smallest }()
+
+	err = d.CheckConsistency()
+	require.Error(t, err)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.Contains(t, err.Error(), "file size mismatch")
+	require.Contains(t, err.Error(), "have overlapping ranges")
+	require.Contains(t, err.Error(), "bounds [b-f] do not match the sstable's keys [d-f]")
+}
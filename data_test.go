@@ -108,6 +108,13 @@ func runIterCmd(d *datadriven.TestData, iter *Iterator, closeIter bool) string {
 			validityState = iter.SeekGEWithLimit(
 				[]byte(parts[1]), []byte(parts[2]))
 			printValidityState = true
+		case "seek-prefix-ge-limit":
+			if len(parts) != 3 {
+				return "seek-prefix-ge-limit <key> <limit>\n"
+			}
+			validityState = iter.SeekPrefixGEWithLimit(
+				[]byte(parts[1]), []byte(parts[2]))
+			printValidityState = true
 		case "seek-lt-limit":
 			if len(parts) != 3 {
 				return "seek-lt-limit <key> <limit>\n"
@@ -133,6 +140,10 @@ func runIterCmd(d *datadriven.TestData, iter *Iterator, closeIter bool) string {
 			valid = iter.Last()
 		case "next":
 			valid = iter.Next()
+		case "next-prefix":
+			valid = iter.NextPrefix()
+		case "prev-prefix":
+			valid = iter.PrevPrefix()
 		case "prev":
 			valid = iter.Prev()
 		case "set-bounds":
@@ -425,7 +436,10 @@ func runInternalIterCmd(d *datadriven.TestData, iter internalIterator, opts ...i
 		case "stats":
 			ii, ok := iter.(internalIteratorWithStats)
 			if ok {
-				fmt.Fprintf(&b, "%+v\n", ii.Stats())
+				stats := ii.Stats()
+				// The time spent reading blocks is nondeterministic.
+				stats.BlockReadDuration = 0
+				fmt.Fprintf(&b, "%+v\n", stats)
 			}
 			continue
 		case "reset-stats":
@@ -1002,7 +1016,7 @@ func runForceIngestCmd(td *datadriven.TestData, d *DB) error {
 		*fileMetadata,
 	) (int, error) {
 		return level, nil
-	})
+	}, 0 /* seqNum */, nil /* exciseSpan */)
 	return err
 }
 
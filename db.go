@@ -6,6 +6,7 @@
 package pebble // import "github.com/cockroachdb/pebble"
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -20,6 +21,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/manual"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
@@ -244,16 +246,27 @@ type DB struct {
 	// The on-disk size of the current OPTIONS file.
 	optionsFileSize uint64
 
+	// fileLock is nil if the DB was opened with Options.ReadOnlyStrict.
 	fileLock io.Closer
 	dataDir  vfs.File
 	walDir   vfs.File
+	// walFailover holds the state of WAL failover to a secondary directory,
+	// if configured by Options.WALFailover.
+	walFailover walFailover
 
+	// objProvider routes the reads and writes of the DB's sstables to the
+	// local filesystem or remote storage holding them.
+	objProvider          objstorage.Provider
 	tableCache           *tableCacheContainer
 	newIters             tableNewIters
 	tableNewRangeKeyIter keyspan.TableNewSpanIter
 
 	commit *commitPipeline
 
+	// capturer holds a capturerHolder, whose Capturer records the DB's
+	// workload while it's being captured by the replay package.
+	capturer atomic.Value
+
 	// readState provides access to the state needed for reading without needing
 	// to acquire DB.mu.
 	readState struct {
@@ -359,10 +372,10 @@ type DB struct {
 			// log.LogWriter are invalid while switching is true.
 			switching bool
 			// nextSize is the size of the next memtable. The memtable size starts at
-			// min(256KB,Options.MemTableSize) and doubles each time a new memtable
-			// is allocated up to Options.MemTableSize. This reduces the memory
-			// footprint of memtables when lots of DB instances are used concurrently
-			// in test environments.
+			// min(Options.MemTableSizeRamp,Options.MemTableSize) and doubles each
+			// time a new memtable is allocated up to Options.MemTableSize. This
+			// reduces the memory footprint of memtables when lots of DB instances
+			// are used concurrently in test environments.
 			nextSize int
 		}
 
@@ -375,6 +388,9 @@ type DB struct {
 			flushing bool
 			// The number of ongoing compactions.
 			compactingCount int
+			// The number of ongoing manual compactions, a subset of
+			// compactingCount.
+			manualCompactingCount int
 			// The list of deletion hints, suggesting ranges for delete-only
 			// compactions.
 			deletionHints []deleteCompactionHint
@@ -392,6 +408,15 @@ type DB struct {
 			// compactions which we might have to perform.
 			readCompactions readCompactionQueue
 
+			// autoDisabled is set while automatic compactions are disabled
+			// through DB.DisableAutomaticCompactions.
+			autoDisabled bool
+
+			// draining is the number of in-progress DrainCompactions calls.
+			// Automatic compactions are scheduled while draining is non-zero,
+			// even if they're disabled.
+			draining int
+
 			// Flush throughput metric.
 			flushWriteThroughput ThroughputMetric
 			// The idle start time for the flush "loop", i.e., when the flushing
@@ -462,9 +487,41 @@ var _ Writer = (*DB)(nil)
 // slice will remain valid until the returned Closer is closed. On success, the
 // caller MUST call closer.Close() or a memory leak will occur.
 func (d *DB) Get(key []byte) ([]byte, io.Closer, error) {
-	return d.getInternal(key, nil /* batch */, nil /* snapshot */)
+	return d.getInternal(key, nil /* batch */, snapshotIterOpts{}, nil /* valueBuf */)
 }
 
+// GetBuffered gets the value for the given key, as Get does, reading it into
+// the provided buffer if the buffer has sufficient capacity. It returns
+// ErrNotFound if the DB does not contain the key.
+//
+// If the value fits within buf, it's returned within buf's backing array, and
+// the resources pinned to read the value are released before GetBuffered
+// returns. Otherwise, the returned value, which the caller should not modify,
+// is pinned as it is by Get. Either way, the returned slice will remain valid
+// until the returned Closer is closed. On success, the caller MUST call
+// closer.Close() or a memory leak will occur.
+//
+// Reusing a buffer across calls avoids allocating the values that must be
+// read rather than referenced in place, such as those stored in blob files.
+func (d *DB) GetBuffered(key, buf []byte) ([]byte, io.Closer, error) {
+	value, closer, err := d.getInternal(key, nil /* batch */, snapshotIterOpts{}, buf)
+	if err != nil || cap(buf) < len(value) {
+		return value, closer, err
+	}
+	// The value may have been read into buf already, in which case the copy
+	// is a no-op.
+	value = append(buf[:0], value...)
+	if err := closer.Close(); err != nil {
+		return nil, nil, err
+	}
+	return value, noopCloser{}, nil
+}
+
+// noopCloser is the io.Closer of a value that pins no resources.
+type noopCloser struct{}
+
+func (noopCloser) Close() error { return nil }
+
 type getIterAlloc struct {
 	dbi    Iterator
 	keyBuf []byte
@@ -477,7 +534,9 @@ var getIterAllocPool = sync.Pool{
 	},
 }
 
-func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer, error) {
+func (d *DB) getInternal(
+	key []byte, b *Batch, sOpts snapshotIterOpts, valueBuf []byte,
+) ([]byte, io.Closer, error) {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -485,13 +544,13 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
 	// compaction. The readState is unref'd by Iterator.Close().
-	readState := d.loadReadState()
+	readState := sOpts.loadReadState(d)
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
 	var seqNum uint64
-	if s != nil {
-		seqNum = s.seqNum
+	if sOpts.seqNum != 0 {
+		seqNum = sOpts.seqNum
 	} else {
 		seqNum = atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
 	}
@@ -534,6 +593,10 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		split:        d.split,
 		readState:    readState,
 		keyBuf:       buf.keyBuf,
+		// A value stored in a blob file is read into valueBuf if it has
+		// sufficient capacity.
+		lazyValueBuf: valueBuf,
+		blobValues:   blobValueFetcher{blobFiles: d.tableCache.blobFiles},
 	}
 
 	if !i.First() {
@@ -625,7 +688,8 @@ func (d *DB) Merge(key, value []byte, opts *WriteOptions) error {
 
 // LogData adds the specified to the batch. The data will be written to the
 // WAL, but not added to memtables or sstables. Log data is never indexed,
-// which makes it useful for testing WAL performance.
+// which makes it useful for testing WAL performance. The log data in WALs
+// replayed by Open is passed to EventListener.LogDataReplayed.
 //
 // It is safe to modify the contents of the argument after LogData returns.
 func (d *DB) LogData(data []byte, opts *WriteOptions) error {
@@ -700,6 +764,27 @@ func (d *DB) RangeKeyDelete(start, end []byte, opts *WriteOptions) error {
 //
 // It is safe to modify the contents of the arguments after Apply returns.
 func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
+	return d.applyInternal(batch, opts, false /* noSyncWait */)
+}
+
+// ApplyNoSyncWait is like Apply, but returns as soon as the batch's mutations
+// are visible, without waiting for the WAL to be synced. The batch becomes
+// durable once the sync completes, which the caller waits for by calling
+// Batch.SyncWait. This allows a writer to pipeline its commits, overlapping
+// the WAL syncs of batches it has already applied with the application of
+// subsequent ones. The batch is referenced until its sync completes, so
+// closing or resetting the batch also waits for the sync.
+//
+// opts must request a sync. An error syncing the WAL is returned by
+// Batch.SyncWait.
+func (d *DB) ApplyNoSyncWait(batch *Batch, opts *WriteOptions) error {
+	if !opts.GetSync() {
+		return errors.New("pebble: ApplyNoSyncWait requires WriteOptions.Sync")
+	}
+	return d.applyInternal(batch, opts, true /* noSyncWait */)
+}
+
+func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -732,13 +817,19 @@ func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
 		// TODO(jackson): Assert that all range key operands are suffixless.
 	}
 
+	if d.opts.Experimental.MergeValidation {
+		if err := d.validateMerges(batch); err != nil {
+			return err
+		}
+	}
+
 	if batch.db == nil {
 		batch.refreshMemTableSize()
 	}
 	if int(batch.memTableSize) >= d.largeBatchThreshold {
 		batch.flushable = newFlushableBatch(batch, d.opts.Comparer)
 	}
-	if err := d.commit.Commit(batch, sync); err != nil {
+	if err := d.commit.Commit(batch, sync, noSyncWait); err != nil {
 		// There isn't much we can do on an error here. The commit pipeline will be
 		// horked at this point.
 		d.opts.Logger.Fatalf("%v", err)
@@ -756,6 +847,35 @@ func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
 	return nil
 }
 
+// validateMerges checks that each merge operand in the batch is accepted by
+// the configured Merger.
+func (d *DB) validateMerges(b *Batch) error {
+	for r := b.Reader(); ; {
+		kind, ukey, value, ok := r.Next()
+		if !ok {
+			return nil
+		}
+		if kind != InternalKeyKindMerge {
+			continue
+		}
+		err := func() error {
+			m, err := d.opts.Merger.Merge(ukey, value)
+			if err != nil {
+				return err
+			}
+			_, closer, err := m.Finish(false /* includesBase */)
+			if closer != nil {
+				err = firstError(err, closer.Close())
+			}
+			return err
+		}()
+		if err != nil {
+			return errors.Wrapf(err, "pebble: merge operand for key %s rejected by merger %q",
+				d.opts.Comparer.FormatKey(ukey), errors.Safe(d.opts.Merger.Name))
+		}
+	}
+}
+
 func (d *DB) commitApply(b *Batch, mem *memTable) error {
 	if b.flushable != nil {
 		// This is a large batch which was already added to the immutable queue.
@@ -786,6 +906,11 @@ func (d *DB) commitApply(b *Batch, mem *memTable) error {
 func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
 	var size int64
 	repr := b.Repr()
+	// commitWrite is called with the commit pipeline's mutex held, so batches
+	// are captured in sequence number order.
+	if c := d.loadCapturer(); c != nil {
+		c.CaptureBatch(repr)
+	}
 
 	if b.flushable != nil {
 		// We have a large batch. Such batches are special in that they don't get
@@ -860,7 +985,7 @@ var iterAllocPool = sync.Pool{
 
 // newIterInternal constructs a new iterator, merging in batch iterators as an extra
 // level.
-func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterator {
+func (d *DB) newIterInternal(batch *Batch, sOpts snapshotIterOpts, o *IterOptions) *Iterator {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -875,7 +1000,7 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 	if o != nil && o.RangeKeyMasking.Suffix != nil && o.KeyTypes != IterKeyTypePointsAndRanges {
 		panic("pebble: range key masking requires IterKeyTypePointsAndRanges")
 	}
-	if (batch != nil || s != nil) && (o != nil && o.OnlyReadGuaranteedDurable) {
+	if (batch != nil || sOpts.seqNum != 0) && (o != nil && o.OnlyReadGuaranteedDurable) {
 		// We could add support for OnlyReadGuaranteedDurable on snapshots if
 		// there was a need: this would require checking that the sequence number
 		// of the snapshot has been flushed, by comparing with
@@ -885,17 +1010,22 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
 	// compaction. The readState is unref'd by Iterator.Close().
-	readState := d.loadReadState()
+	readState := sOpts.loadReadState(d)
 
 	// Determine the seqnum to read at after grabbing the read state (current and
 	// memtables) above.
 	var seqNum uint64
-	if s != nil {
-		seqNum = s.seqNum
+	if sOpts.seqNum != 0 {
+		seqNum = sOpts.seqNum
 	} else {
 		seqNum = atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
 	}
 
+	visibleSeqNum := seqNum
+	if o != nil && o.OnlyReadGuaranteedDurable {
+		seqNum = readState.durableSeqNum(visibleSeqNum)
+	}
+
 	// Bundle various structures under a single umbrella in order to allocate
 	// them together.
 	buf := iterAllocPool.Get().(*iterAlloc)
@@ -906,6 +1036,8 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 		equal:               d.equal,
 		merge:               d.merge,
 		split:               d.split,
+		immediateSuccessor:  d.opts.Comparer.ImmediateSuccessor,
+		rangeKeyMerge:       d.opts.rangeKeyMerge(),
 		readState:           readState,
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
@@ -914,6 +1046,7 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 		newIters:            d.newIters,
 		newIterRangeKey:     d.tableNewRangeKeyIter,
 		seqNum:              seqNum,
+		visibleSeqNum:       visibleSeqNum,
 	}
 	if o != nil {
 		dbi.opts = *o
@@ -922,6 +1055,8 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 	dbi.opts.logger = d.opts.Logger
 	if batch != nil {
 		dbi.batchSeqNum = dbi.batch.nextSeqNum()
+	} else if c := d.loadCapturer(); c != nil {
+		dbi.capture = c.CaptureNewIter(&dbi.opts, visibleSeqNum)
 	}
 	return finishInitializingIter(buf)
 }
@@ -934,17 +1069,13 @@ func finishInitializingIter(buf *iterAlloc) *Iterator {
 	// Short-hand.
 	dbi := &buf.dbi
 	memtables := dbi.readState.memtables
-	if dbi.opts.OnlyReadGuaranteedDurable {
-		memtables = nil
-	} else {
-		// We only need to read from memtables which contain sequence numbers older
-		// than seqNum. Trim off newer memtables.
-		for i := len(memtables) - 1; i >= 0; i-- {
-			if logSeqNum := memtables[i].logSeqNum; logSeqNum < dbi.seqNum {
-				break
-			}
-			memtables = memtables[:i]
+	// We only need to read from memtables which contain sequence numbers older
+	// than seqNum. Trim off newer memtables.
+	for i := len(memtables) - 1; i >= 0; i-- {
+		if logSeqNum := memtables[i].logSeqNum; logSeqNum < dbi.seqNum {
+			break
 		}
+		memtables = memtables[:i]
 	}
 
 	if dbi.opts.pointKeys() {
@@ -1102,7 +1233,13 @@ func (i *Iterator) constructPointIter(memtables flushableList, buf *iterAlloc) {
 	levelsIndex := len(levels)
 	mlevels = mlevels[:numMergingLevels]
 	levels = levels[:numLevelIters]
-	var internalOpts internalIterOpts
+	internalOpts := internalIterOpts{
+		immediateSuccessor: i.immediateSuccessor,
+		lazyBlobValues:     true,
+	}
+	if i.readState != nil {
+		i.blobValues.blobFiles = i.readState.db.tableCache.blobFiles
+	}
 	if i.opts.RangeKeyMasking.Filter != nil {
 		internalOpts.boundLimitedFilter = &i.rangeKeyMasking
 	}
@@ -1163,7 +1300,7 @@ func (d *DB) NewIndexedBatch() *Batch {
 // apparent memory and disk usage leak. Use snapshots (see NewSnapshot) for
 // point-in-time snapshots which avoids these problems.
 func (d *DB) NewIter(o *IterOptions) *Iterator {
-	return d.newIterInternal(nil /* batch */, nil /* snapshot */, o)
+	return d.newIterInternal(nil /* batch */, snapshotIterOpts{}, o)
 }
 
 // NewSnapshot returns a point-in-time view of the current DB state. Iterators
@@ -1222,6 +1359,10 @@ func (d *DB) Close() error {
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	for d.walFailover.closing > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.commit.Close()
 
 	var err error
 	if n := len(d.mu.compact.inProgress); n > 0 {
@@ -1234,7 +1375,9 @@ func (d *DB) Close() error {
 	} else if d.mu.log.LogWriter != nil {
 		panic("pebble: log-writer should be nil in read-only mode")
 	}
-	err = firstError(err, d.fileLock.Close())
+	if d.fileLock != nil {
+		err = firstError(err, d.fileLock.Close())
+	}
 
 	// Note that versionSet.close() only closes the MANIFEST. The versions list
 	// is still valid for the checks below.
@@ -1244,6 +1387,9 @@ func (d *DB) Close() error {
 	if d.dataDir != d.walDir {
 		err = firstError(err, d.walDir.Close())
 	}
+	if d.walFailover.dir != nil {
+		err = firstError(err, d.walFailover.dir.Close())
+	}
 
 	d.readState.val.unrefLocked()
 
@@ -1275,7 +1421,7 @@ func (d *DB) Close() error {
 	// There may still be obsolete tables if an existing async cleaning job
 	// prevented a new cleaning job when a readState was unrefed. If needed,
 	// synchronously delete obsolete files.
-	if len(d.mu.versions.obsoleteTables) > 0 {
+	if len(d.mu.versions.obsoleteTables) > 0 || len(d.mu.versions.obsoleteBlobFiles) > 0 {
 		d.deleteObsoleteFiles(d.mu.nextJobID, true /* waitForOngoing */)
 	}
 	// Wait for all the deletion goroutines spawned by cleaning jobs to finish.
@@ -1303,6 +1449,44 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		return errors.Errorf("Compact start %s is not less than end %s",
 			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
 	}
+	return d.compactRange(start, end, parallelize)
+}
+
+// CompactAsync is like Compact, but returns without waiting for the
+// compaction to complete. The manual compactions are queued alongside those
+// scheduled by Compact, and are interleaved with automatic compactions in the
+// same manner.
+//
+// If no error is returned, the result of the compaction is sent on the
+// returned channel, which is then closed. The compaction cannot be canceled
+// once scheduled; a caller that stops waiting on the channel, for example
+// because a context is done, must still receive from the channel before
+// closing the DB.
+func (d *DB) CompactAsync(start, end []byte) (<-chan error, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if d.cmp(start, end) >= 0 {
+		return nil, errors.Errorf("Compact start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	// The caller may reuse the bounds once CompactAsync returns.
+	start = append([]byte(nil), start...)
+	end = append([]byte(nil), end...)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.compactRange(start, end, false /* parallelize */)
+		close(done)
+	}()
+	return done, nil
+}
+
+// compactRange compacts the range [start, end], first waiting for any
+// overlapping memtables to flush.
+func (d *DB) compactRange(start, end []byte, parallelize bool) error {
 	iStart := base.MakeInternalKey(start, InternalKeySeqNumMax, InternalKeyKindMax)
 	iEnd := base.MakeInternalKey(end, 0, 0)
 	m := (&fileMetadata{}).ExtendPointKeyBounds(d.cmp, iStart, iEnd)
@@ -1437,6 +1621,248 @@ func (d *DB) splitManualCompaction(
 	return splitCompactions
 }
 
+// DisableAutomaticCompactions stops the scheduling of automatic compactions,
+// as if the DB were opened with Options.DisableAutomaticCompactions. In-progress
+// compactions run to completion, and manual compactions continue to be
+// performed. Until automatic compactions are re-enabled, writes are not
+// stalled by the number of L0 sublevels (see Options.L0StopWritesThreshold),
+// so L0 may grow without bound.
+func (d *DB) DisableAutomaticCompactions() {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.compact.autoDisabled = true
+}
+
+// EnableAutomaticCompactions resumes the scheduling of automatic compactions
+// after a call to DisableAutomaticCompactions. It doesn't enable automatic
+// compactions on a DB opened with Options.DisableAutomaticCompactions.
+func (d *DB) EnableAutomaticCompactions() {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.compact.autoDisabled = false
+	// Writes stalled on L0 are now waiting on automatic compactions.
+	d.maybeScheduleCompaction()
+	d.mu.compact.cond.Broadcast()
+}
+
+// DrainCompactions performs the compactions that automatic compactions would
+// pick, until none remain and no compactions are in progress. DrainCompactions
+// schedules them even while automatic compactions are disabled, leaving the
+// LSM in the shape automatic compactions would eventually produce. Concurrent
+// writes may prolong the drain.
+//
+// DrainCompactions returns ctx.Err() if the context is done before the
+// compactions quiesce. Compactions already scheduled run to completion.
+func (d *DB) DrainCompactions(ctx context.Context) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// Wake the loop below if the context is done while it's waiting.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.mu.Lock()
+			d.mu.compact.cond.Broadcast()
+			d.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.compact.draining++
+	defer func() { d.mu.compact.draining-- }()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.closed.Load() != nil {
+			return ErrClosed
+		}
+		d.maybeScheduleCompaction()
+		if d.mu.compact.compactingCount == 0 && !d.mu.compact.flushing {
+			return nil
+		}
+		d.mu.compact.cond.Wait()
+	}
+}
+
+// RewriteFilters rewrites every sstable within the provided level so that
+// its filter block is built with the filter policy currently configured for
+// the level (see LevelOptions.FilterPolicy), for instance after a change to
+// the policy's bits per key. Only the filter blocks are rebuilt, from the keys
+// of each sstable: the remainder of each sstable, including its data blocks,
+// is copied verbatim to a new sstable replacing it within the level.
+//
+// RewriteFilters blocks until every sstable within the level at the time of
+// the call has been rewritten, unless compacted away in the meantime, and
+// performs the rewrites even while automatic compactions are disabled.
+// Virtual sstables are not rewritten, as they share their filter block with
+// the other virtual sstables backed by the same sstable.
+func (d *DB) RewriteFilters(level int) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if level < 0 || level >= numLevels {
+		return errors.Errorf("pebble: invalid level %d", errors.Safe(level))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var files []*fileMetadata
+	iter := d.mu.versions.currentVersion().Levels[level].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if !f.Virtual {
+			files = append(files, f)
+		}
+	}
+	for _, f := range files {
+		if err := d.rewriteFilterLocked(level, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteFilterLocked runs a compaction rewriting the filter block of the
+// sstable f within the provided level, waiting for it to complete. It waits
+// for any in-progress compaction of f to complete first, and is a noop if f
+// is then no longer within the level.
+//
+// d.mu must be held when calling this.
+func (d *DB) rewriteFilterLocked(level int, f *fileMetadata) error {
+	for f.Compacting || d.mu.compact.compactingCount >= d.opts.MaxConcurrentCompactions() {
+		if d.closed.Load() != nil {
+			return ErrClosed
+		}
+		d.mu.compact.cond.Wait()
+	}
+
+	// Lock the manifest for a coherent view of the LSM, as when picking
+	// compactions in maybeScheduleCompactionPicker.
+	d.mu.versions.logLock()
+	vers := d.mu.versions.currentVersion()
+	if vers.Levels[level].Find(d.cmp, f) == nil {
+		d.mu.versions.logUnlock()
+		return nil
+	}
+	c := newFilterRewriteCompaction(d.opts, vers, level, f)
+	d.mu.compact.compactingCount++
+	d.mu.compact.manualCompactingCount++
+	d.addInProgressCompaction(c)
+	d.mu.versions.logUnlock()
+
+	errCh := make(chan error, 1)
+	go d.compact(c, errCh)
+	d.mu.Unlock()
+	err := <-errCh
+	d.mu.Lock()
+	return err
+}
+
+// Mark durably marks the provided sstables for compaction. Marked sstables are
+// rewritten in place by background rewrite compactions, at a lower priority
+// than any other automatic compaction. Metrics.Compact.MarkedFiles reports the
+// number of sstables that remain to be rewritten. Mark returns an error
+// without marking any sstable if one of the provided sstables isn't in the
+// current version of the LSM, or if
+// Options.Experimental.EnableMarkedForCompaction isn't set.
+func (d *DB) Mark(files []FileNum) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if !d.opts.Experimental.EnableMarkedForCompaction {
+		return errors.New("pebble: marking sstables for compaction requires Options.Experimental.EnableMarkedForCompaction")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.markFilesForCompactionLocked(files); err != nil {
+		return err
+	}
+	d.maybeScheduleCompaction()
+	return nil
+}
+
+// markFilesForCompactionLocked durably marks the provided sstables for
+// compaction, to be rewritten by rewrite compactions.
+//
+// d.mu must be held when calling this.
+func (d *DB) markFilesForCompactionLocked(files []FileNum) error {
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+
+	// Lock the manifest for a coherent view of the LSM.
+	d.mu.versions.logLock()
+	vers := d.mu.versions.currentVersion()
+	found := make(map[FileNum]bool, len(files))
+	for _, fileNum := range files {
+		found[fileNum] = false
+	}
+	var toMark [numLevels][]*fileMetadata
+	for level := range vers.Levels {
+		iter := vers.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if _, ok := found[f.FileNum]; ok {
+				found[f.FileNum] = true
+				toMark[level] = append(toMark[level], f)
+			}
+		}
+	}
+	for _, fileNum := range files {
+		if !found[fileNum] {
+			d.mu.versions.logUnlock()
+			return errors.Errorf("pebble: sstable %s not found in the current version", fileNum)
+		}
+	}
+
+	var marked bool
+	for level := range toMark {
+		var markedLevel bool
+		for _, f := range toMark[level] {
+			if !f.MarkedForCompaction {
+				f.MarkedForCompaction = true
+				vers.Stats.MarkedForCompaction++
+				markedLevel = true
+			}
+		}
+		if markedLevel {
+			// See markFilesWithSplitUserKeysLocked, which marks files
+			// similarly.
+			vers.Levels[level].InvalidateAnnotation(markedForCompactionAnnotator{})
+			marked = true
+		}
+	}
+	if !marked {
+		d.mu.versions.logUnlock()
+		return nil
+	}
+	return d.mu.versions.logAndApply(
+		jobID,
+		&manifest.VersionEdit{},
+		map[int]*LevelMetrics{},
+		true, /* forceRotation */
+		func() []compactionInfo { return d.getInProgressCompactionInfoLocked(nil) })
+}
+
 // Flush the memtable to stable storage.
 func (d *DB) Flush() error {
 	flushDone, err := d.AsyncFlush()
@@ -1471,6 +1897,89 @@ func (d *DB) AsyncFlush() (<-chan struct{}, error) {
 	return flushed, nil
 }
 
+// FlushRange flushes the memtable data overlapping the key range [start, end)
+// to stable storage, blocking until the resulting sstables are installed in
+// the LSM. Keys, range deletions and range keys overlapping the range are all
+// flushed.
+//
+// The granularity of the flush is coarse. Memtables are flushed in their
+// entirety and in order, so every memtable up to and including the most recent
+// one containing data within the range is flushed, including any data outside
+// of the range. If no memtable contains data within the range, FlushRange
+// returns without flushing.
+func (d *DB) FlushRange(start, end []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("FlushRange start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+
+	flushed, err := func() (chan struct{}, error) {
+		d.commit.mu.Lock()
+		defer d.commit.mu.Unlock()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		queue := d.mu.mem.queue
+		for i := len(queue) - 1; i >= 0; i-- {
+			if !flushableOverlaps(d.cmp, queue[i].flushable, start, end) {
+				continue
+			}
+			flushed := queue[i].flushed
+			if i == len(queue)-1 {
+				// The mutable memtable overlaps the range. Rotate it, forcing a
+				// flush of it and all of the immutable memtables.
+				if err := d.makeRoomForWrite(nil); err != nil {
+					return nil, err
+				}
+				return flushed, nil
+			}
+			// An immutable memtable overlaps the range. Force the flush of it and
+			// the memtables queued before it, which may otherwise wait for more
+			// data to accumulate.
+			for j := 0; j <= i; j++ {
+				queue[j].flushForced = true
+			}
+			d.maybeScheduleFlush()
+			return flushed, nil
+		}
+		return nil, nil
+	}()
+	if err != nil || flushed == nil {
+		return err
+	}
+	<-flushed
+	return nil
+}
+
+// flushableOverlaps returns true if the flushable contains any point keys,
+// range deletions or range keys overlapping the key range [start, end).
+func flushableOverlaps(cmp Compare, mem flushable, start, end []byte) bool {
+	iter := mem.newIter(nil)
+	key, _ := iter.SeekGE(start, base.SeekGEFlagsNone)
+	overlaps := key != nil && cmp(key.UserKey, end) < 0
+	_ = iter.Close()
+	if overlaps {
+		return true
+	}
+	spanOverlaps := func(iter keyspan.FragmentIterator) bool {
+		if iter == nil {
+			return false
+		}
+		defer iter.Close()
+		if s := iter.SeekLT(start); s != nil && cmp(s.End, start) > 0 {
+			return true
+		}
+		s := iter.SeekGE(start)
+		return s != nil && cmp(s.Start, end) < 0
+	}
+	return spanOverlaps(mem.newRangeDelIter(nil)) || spanOverlaps(mem.newRangeKeyIter(nil))
+}
+
 // InternalIntervalMetrics returns the InternalIntervalMetrics and resets for
 // the next interval (which is until the next call to this method).
 func (d *DB) InternalIntervalMetrics() *InternalIntervalMetrics {
@@ -1497,6 +2006,17 @@ func (d *DB) Metrics() *Metrics {
 
 	d.mu.Lock()
 	*metrics = d.mu.versions.metrics
+	if byKind := metrics.Compact.ByKind; byKind != nil {
+		metrics.Compact.ByKind = make(map[CompactionKind]CompactionKindMetrics, len(byKind))
+		for kind, m := range byKind {
+			metrics.Compact.ByKind[kind] = m
+		}
+	}
+	hists := d.commit.latencyHistograms()
+	metrics.Commit.WALWriteLatencyMicros = hists[CommitStageWALWrite]
+	metrics.Commit.MemTableApplyLatencyMicros = hists[CommitStageMemTableApply]
+	metrics.Commit.PublishLatencyMicros = hists[CommitStagePublish]
+	metrics.Commit.WALSyncLatencyMicros = hists[CommitStageWALSync]
 	metrics.Compact.EstimatedDebt = d.mu.versions.picker.estimatedCompactionDebt(0)
 	metrics.Compact.InProgressBytes = atomic.LoadInt64(&d.mu.versions.atomic.atomicInProgressBytes)
 	metrics.Compact.NumInProgress = int64(d.mu.compact.compactingCount)
@@ -1608,22 +2128,35 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 
 	destTables := make([]SSTableInfo, totalTables)
 	destLevels := make([][]SSTableInfo, len(srcLevels))
+	// Copy the tables' stats, which are protected by DB.mu, in a single
+	// critical section.
+	d.mu.Lock()
 	for i := range destLevels {
 		iter := srcLevels[i].Iter()
 		j := 0
 		for m := iter.First(); m != nil; m = iter.Next() {
 			destTables[j] = SSTableInfo{TableInfo: m.TableInfo()}
-			if opt.withProperties {
+			destTables[j].TombstoneDensity = m.Stats.TombstoneDensity
+			j++
+		}
+		destLevels[i] = destTables[:j]
+		destTables = destTables[j:]
+	}
+	d.mu.Unlock()
+
+	if opt.withProperties {
+		for i := range destLevels {
+			iter := srcLevels[i].Iter()
+			j := 0
+			for m := iter.First(); m != nil; m = iter.Next() {
 				p, err := d.tableCache.getTableProperties(m)
 				if err != nil {
 					return nil, err
 				}
-				destTables[j].Properties = p
+				destLevels[i][j].Properties = p
+				j++
 			}
-			j++
 		}
-		destLevels[i] = destTables[:j]
-		destTables = destTables[j:]
 	}
 	return destLevels, nil
 }
@@ -1640,11 +2173,32 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 // - There may also exist WAL entries for unflushed keys in this range. This
 //   estimation currently excludes space used for the range in the WAL.
 func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
+	u, err := d.EstimateDiskUsageByBackingType(start, end)
+	if err != nil {
+		return 0, err
+	}
+	return u.Local + u.Remote, nil
+}
+
+// DiskUsageEstimate holds the estimated space used by a key range, split by
+// where the sstables storing the range are backed.
+type DiskUsageEstimate struct {
+	// Local is the estimated space used by sstables on the local filesystem.
+	Local uint64
+	// Remote is the estimated space used by sstables held in remote storage
+	// (see Options.RemoteStorage).
+	Remote uint64
+}
+
+// EstimateDiskUsageByBackingType is like EstimateDiskUsage, but splits the
+// estimate between sstables stored on the local filesystem and sstables held
+// in remote storage.
+func (d *DB) EstimateDiskUsageByBackingType(start, end []byte) (DiskUsageEstimate, error) {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
 	if d.opts.Comparer.Compare(start, end) > 0 {
-		return 0, errors.New("invalid key-range specified (start > end)")
+		return DiskUsageEstimate{}, errors.New("invalid key-range specified (start > end)")
 	}
 
 	// Grab and reference the current readState. This prevents the underlying
@@ -1653,7 +2207,7 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	readState := d.loadReadState()
 	defer readState.unref()
 
-	var totalSize uint64
+	var u DiskUsageEstimate
 	for level, files := range readState.current.Levels {
 		iter := files.Iter()
 		if level > 0 {
@@ -1664,26 +2218,50 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 			iter = overlaps.Iter()
 		}
 		for file := iter.First(); file != nil; file = iter.Next() {
+			var size uint64
 			if d.opts.Comparer.Compare(start, file.Smallest.UserKey) <= 0 &&
 				d.opts.Comparer.Compare(file.Largest.UserKey, end) <= 0 {
 				// The range fully contains the file, so skip looking it up in
 				// table cache/looking at its indexes, and add the full file size.
-				totalSize += file.Size
+				size = file.Size
 			} else if d.opts.Comparer.Compare(file.Smallest.UserKey, end) <= 0 &&
 				d.opts.Comparer.Compare(start, file.Largest.UserKey) <= 0 {
-				var size uint64
-				err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
-					size, err = r.EstimateDiskUsage(start, end)
-					return err
-				})
+				var err error
+				size, err = d.estimateTableDiskUsage(file, start, end)
 				if err != nil {
-					return 0, err
+					return DiskUsageEstimate{}, err
 				}
-				totalSize += size
 			}
+			if file.RemoteLocator != "" {
+				u.Remote += size
+			} else {
+				u.Local += size
+			}
+		}
+	}
+	return u, nil
+}
+
+// estimateTableDiskUsage returns the estimated disk usage of the keys of the
+// sstable file within the user key range [start, end]. For a virtual sstable,
+// the range is restricted to the bounds of the virtual sstable, so that only
+// the portion of the backing sstable visible through the virtual sstable is
+// counted.
+func (d *DB) estimateTableDiskUsage(file *fileMetadata, start, end []byte) (uint64, error) {
+	if file.Virtual {
+		if d.cmp(start, file.Smallest.UserKey) < 0 {
+			start = file.Smallest.UserKey
+		}
+		if d.cmp(end, file.Largest.UserKey) > 0 {
+			end = file.Largest.UserKey
 		}
 	}
-	return totalSize, nil
+	var size uint64
+	err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
+		size, err = r.EstimateDiskUsage(start, end)
+		return err
+	})
+	return size, err
 }
 
 func (d *DB) walPreallocateSize() int {
@@ -1700,6 +2278,22 @@ func (d *DB) walPreallocateSize() int {
 	return size
 }
 
+// newLogWriter returns a LogWriter for the WAL with the given file number,
+// which notifies the EventListener of each of its syncs.
+func (d *DB) newLogWriter(f vfs.File, logNum FileNum) *record.LogWriter {
+	w := record.NewLogWriter(f, logNum)
+	w.SetMinSyncInterval(d.opts.WALMinSyncInterval)
+	w.SetOnSync(func(size int64, syncLatency time.Duration, err error) {
+		d.opts.EventListener.WALSynced(WALSyncInfo{
+			FileNum:  logNum,
+			Size:     uint64(size),
+			Duration: syncLatency,
+			Err:      err,
+		})
+	})
+	return w
+}
+
 func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushableEntry) {
 	size := d.mu.mem.nextSize
 	if d.mu.mem.nextSize < d.opts.MemTableSize {
@@ -1743,6 +2337,34 @@ func (d *DB) newFlushableEntry(f flushable, logNum FileNum, logSeqNum uint64) *f
 	}
 }
 
+// writeStallBeginLocked invokes the WriteStallBegin event listener. DB.mu must
+// be held by the caller, and is released while the listener runs so that it
+// may call into the DB.
+func (d *DB) writeStallBeginLocked(info WriteStallBeginInfo) {
+	d.mu.Unlock()
+	defer d.mu.Lock()
+	d.opts.EventListener.WriteStallBegin(info)
+}
+
+// writeStallEndLocked invokes the WriteStallEnd event listener, releasing
+// DB.mu while it runs like writeStallBeginLocked.
+func (d *DB) writeStallEndLocked() {
+	d.mu.Unlock()
+	defer d.mu.Lock()
+	d.opts.EventListener.WriteStallEnd()
+}
+
+// mergeLogWriterMetricsLocked accumulates the metrics of a closed LogWriter.
+//
+// d.mu must be held when calling this.
+func (d *DB) mergeLogWriterMetricsLocked(metrics *record.LogWriterMetrics) {
+	if d.mu.log.metrics == nil {
+		d.mu.log.metrics = metrics
+	} else if err := d.mu.log.metrics.Merge(metrics); err != nil {
+		d.opts.Logger.Infof("metrics error: %s", err)
+	}
+}
+
 // makeRoomForWrite ensures that the memtable has room to hold the contents of
 // Batch. It reserves the space in the memtable and adds a reference to the
 // memtable. The caller must later ensure that the memtable is unreferenced. If
@@ -1760,47 +2382,64 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			d.mu.mem.cond.Wait()
 			continue
 		}
-		if b != nil && b.flushable == nil {
+		// If the primary WAL directory has been deemed unhealthy, rotate the
+		// memtable so that the new WAL is written to the secondary directory.
+		// The rotated memtable is not forced to flush.
+		failover := d.shouldFailoverWALLocked()
+		if b != nil && b.flushable == nil && !failover {
 			err := d.mu.mem.mutable.prepare(b)
 			if err != arenaskl.ErrArenaFull {
 				if stalled {
-					d.opts.EventListener.WriteStallEnd()
+					d.writeStallEndLocked()
 				}
 				return err
 			}
-		} else if !force {
+		} else if !force && !failover {
 			if stalled {
-				d.opts.EventListener.WriteStallEnd()
+				d.writeStallEndLocked()
 			}
 			return nil
 		}
-		// force || err == ErrArenaFull, so we need to rotate the current memtable.
-		{
-			var size uint64
-			for i := range d.mu.mem.queue {
-				size += d.mu.mem.queue[i].totalBytes()
-			}
-			if size >= uint64(d.opts.MemTableStopWritesThreshold)*uint64(d.opts.MemTableSize) {
-				// We have filled up the current memtable, but already queued memtables
-				// are still flushing, so we wait.
-				if !stalled {
-					stalled = true
-					d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-						Reason: "memtable count limit reached",
-					})
-				}
-				d.mu.compact.cond.Wait()
+		// force || failover || err == ErrArenaFull, so we need to rotate the
+		// current memtable.
+		if size, limit := d.queuedMemTableBytesLocked(); size >= limit {
+			// We have filled up the current memtable, but already queued memtables
+			// are still flushing, so we wait.
+			if !stalled {
+				stalled = true
+				d.writeStallBeginLocked(WriteStallBeginInfo{
+					Reason:    "memtable count limit reached",
+					Cause:     WriteStallMemTableCount,
+					Current:   size,
+					Threshold: limit,
+				})
+				// DB.mu was released while the event was handled, so the
+				// stall may have already cleared.
 				continue
 			}
+			// The flush freeing the queued memtables may have been denied by
+			// the CompactionScheduler before the limit was reached. It's
+			// admitted regardless of the scheduler now, so ask again.
+			d.maybeScheduleFlush()
+			d.mu.compact.cond.Wait()
+			continue
 		}
+		// While automatic compactions are disabled through
+		// DB.DisableAutomaticCompactions, only a manual compaction or drain can
+		// reduce L0's read amplification. Let L0 grow rather than stalling
+		// writes indefinitely.
 		l0ReadAmp := d.mu.versions.currentVersion().L0Sublevels.ReadAmplification()
-		if l0ReadAmp >= d.opts.L0StopWritesThreshold {
+		if l0ReadAmp >= d.opts.L0StopWritesThreshold && !d.mu.compact.autoDisabled {
 			// There are too many level-0 files, so we wait.
 			if !stalled {
 				stalled = true
-				d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-					Reason: "L0 file count limit exceeded",
+				d.writeStallBeginLocked(WriteStallBeginInfo{
+					Reason:    "L0 file count limit exceeded",
+					Cause:     WriteStallL0FileCount,
+					Current:   uint64(l0ReadAmp),
+					Threshold: uint64(d.opts.L0StopWritesThreshold),
 				})
+				continue
 			}
 			d.mu.compact.cond.Wait()
 			continue
@@ -1810,6 +2449,7 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		var newLogFile vfs.File
 		var newLogSize uint64
 		var prevLogSize uint64
+		var newLogSecondary bool
 		var err error
 
 		if !d.opts.DisableWAL {
@@ -1826,26 +2466,44 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			if d.mu.log.queue[len(d.mu.log.queue)-1].fileSize < prevLogSize {
 				d.mu.log.queue[len(d.mu.log.queue)-1].fileSize = prevLogSize
 			}
-			d.mu.Unlock()
-
-			// Close the previous log first. This writes an EOF trailer
-			// signifying the end of the file and syncs it to disk. We must
-			// close the previous log before linking the new log file,
-			// otherwise a crash could leave both logs with unclean tails, and
-			// Open will treat the previous log as corrupt.
-			err = d.mu.log.LogWriter.Close()
-			metrics := d.mu.log.LogWriter.Metrics()
-			d.mu.Lock()
-			if d.mu.log.metrics == nil {
-				d.mu.log.metrics = metrics
-			} else {
-				if err := d.mu.log.metrics.Merge(metrics); err != nil {
-					d.opts.Logger.Infof("metrics error: %s", err)
+			// Once failed over, all new WALs are written to the secondary
+			// directory.
+			walDirname, walDir := d.walDirname, d.walDir
+			if newLogSecondary = d.walFailedOver(); newLogSecondary {
+				walDirname, walDir = d.walFailover.dirname, d.walFailover.dir
+			}
+			if failover {
+				// Closing the previous log may block indefinitely on the
+				// unhealthy primary WAL directory, so it's abandoned and closed
+				// asynchronously. Record this in the MANIFEST before writing to
+				// the new log, so that Open tolerates an unclean tail on the
+				// abandoned log.
+				prevLogNum := d.mu.log.queue[len(d.mu.log.queue)-1].fileNum
+				d.mu.versions.logLock()
+				err = d.mu.versions.logAndApply(jobID, &versionEdit{
+					FailedOverLogNums: []FileNum{prevLogNum},
+				}, map[int]*LevelMetrics{}, false, /* forceRotation */
+					func() []compactionInfo { return d.getInProgressCompactionInfoLocked(nil) })
+				if err == nil {
+					d.closeFailedOverLogLocked(d.mu.log.LogWriter, prevLogNum)
 				}
 			}
 			d.mu.Unlock()
 
-			newLogName := base.MakeFilepath(d.opts.FS, d.walDirname, fileTypeLog, newLogNum)
+			if !failover {
+				// Close the previous log first. This writes an EOF trailer
+				// signifying the end of the file and syncs it to disk. We must
+				// close the previous log before linking the new log file,
+				// otherwise a crash could leave both logs with unclean tails,
+				// and Open will treat the previous log as corrupt.
+				err = d.mu.log.LogWriter.Close()
+				metrics := d.mu.log.LogWriter.Metrics()
+				d.mu.Lock()
+				d.mergeLogWriterMetricsLocked(metrics)
+				d.mu.Unlock()
+			}
+
+			newLogName := base.MakeFilepath(d.opts.FS, walDirname, fileTypeLog, newLogNum)
 
 			// Try to use a recycled log file. Recycling log files is an important
 			// performance optimization as it is faster to sync a file that has
@@ -1856,7 +2514,9 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			var recycleLog fileInfo
 			var recycleOK bool
 			if err == nil {
-				recycleLog, recycleOK = d.logRecycler.peek()
+				if !newLogSecondary {
+					recycleLog, recycleOK = d.logRecycler.peek()
+				}
 				if recycleOK {
 					recycleLogName := base.MakeFilepath(d.opts.FS, d.walDirname, fileTypeLog, recycleLog.fileNum)
 					newLogFile, err = d.opts.FS.ReuseForWrite(recycleLogName, newLogName)
@@ -1886,17 +2546,13 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			if err == nil {
 				// TODO(peter): RocksDB delays sync of the parent directory until the
 				// first time the log is synced. Is that worthwhile?
-				err = d.walDir.Sync()
+				err = walDir.Sync()
 			}
 
 			if err != nil && newLogFile != nil {
 				newLogFile.Close()
 			} else if err == nil {
-				newLogFile = vfs.NewSyncingFile(newLogFile, vfs.SyncingFileOptions{
-					NoSyncOnClose:   d.opts.NoSyncOnClose,
-					BytesPerSync:    d.opts.WALBytesPerSync,
-					PreallocateSize: d.walPreallocateSize(),
-				})
+				newLogFile = d.newLogFile(newLogFile, newLogName, newLogSecondary)
 			}
 
 			if recycleOK {
@@ -1928,9 +2584,12 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		}
 
 		if !d.opts.DisableWAL {
-			d.mu.log.queue = append(d.mu.log.queue, fileInfo{fileNum: newLogNum, fileSize: newLogSize})
-			d.mu.log.LogWriter = record.NewLogWriter(newLogFile, newLogNum)
-			d.mu.log.LogWriter.SetMinSyncInterval(d.opts.WALMinSyncInterval)
+			fi := fileInfo{fileNum: newLogNum, fileSize: newLogSize}
+			if newLogSecondary {
+				fi.dir = d.walFailover.dirname
+			}
+			d.mu.log.queue = append(d.mu.log.queue, fi)
+			d.mu.log.LogWriter = d.newLogWriter(newLogFile, newLogNum)
 		}
 
 		immMem := d.mu.mem.mutable
@@ -19,6 +19,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -449,6 +450,58 @@ func TestGetNoCache(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestGetBuffered(t *testing.T) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatNewest,
+	}
+	opts.Experimental.ValueSeparationThreshold = 100
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	small := []byte("small")
+	large := bytes.Repeat([]byte("l"), 1000)
+	require.NoError(t, d.Set([]byte("flushed"), small, nil))
+	require.NoError(t, d.Set([]byte("separated"), large, nil))
+	require.NoError(t, d.Merge([]byte("merged"), small, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("memtable"), small, nil))
+	require.NoError(t, d.Merge([]byte("merged"), small, nil))
+
+	buf := make([]byte, 0, 2000)
+	for _, tc := range []struct {
+		key   string
+		value []byte
+	}{
+		{"flushed", small},
+		{"separated", large},
+		{"merged", append(append([]byte(nil), small...), small...)},
+		{"memtable", small},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			// A value that fits within the buffer is returned within it.
+			v, closer, err := d.GetBuffered([]byte(tc.key), buf)
+			require.NoError(t, err)
+			require.Equal(t, tc.value, v)
+			require.Equal(t, &buf[:1][0], &v[0])
+			require.NoError(t, closer.Close())
+
+			// A value that doesn't is returned as by Get.
+			v, closer, err = d.GetBuffered([]byte(tc.key), make([]byte, 0, 1))
+			require.NoError(t, err)
+			require.Equal(t, tc.value, v)
+			require.NoError(t, closer.Close())
+		})
+	}
+
+	_, _, err = d.GetBuffered([]byte("missing"), buf)
+	require.Equal(t, ErrNotFound, err)
+
+	// Closing the DB fails if any of the iterators backing the calls above
+	// were leaked.
+	require.NoError(t, d.Close())
+}
+
 func TestGetMerge(t *testing.T) {
 	d, err := Open("", testingRandomized(&Options{
 		FS: vfs.NewMem(),
@@ -570,6 +623,116 @@ func TestMergerClosing(t *testing.T) {
 	require.True(t, m.closed)
 }
 
+// counterMerger is a ValueMerger that sums decimal-encoded counters, folding
+// each operand into the running sum as it's received.
+type counterMerger struct {
+	sum          uint64
+	operands     int
+	includesBase bool
+	buf          [20]byte
+}
+
+func (m *counterMerger) add(value []byte) error {
+	v, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return err
+	}
+	m.sum += v
+	m.operands++
+	return nil
+}
+
+func (m *counterMerger) MergeNewer(value []byte) error {
+	return m.add(value)
+}
+
+func (m *counterMerger) MergeOlder(value []byte) error {
+	return m.add(value)
+}
+
+func (m *counterMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	m.includesBase = includesBase
+	return strconv.AppendUint(m.buf[:0], m.sum, 10), nil, nil
+}
+
+func TestMergeManyOperands(t *testing.T) {
+	// Merge operands are folded into the ValueMerger one at a time as the
+	// iterator steps over them, so the memory used to merge a key is bounded
+	// by the ValueMerger's state rather than by the number of operands.
+	var last *counterMerger
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		Merger: &Merger{
+			Merge: func(key, value []byte) (base.ValueMerger, error) {
+				last = &counterMerger{}
+				return last, last.add(value)
+			},
+			Name: "counter",
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	key := []byte("counter")
+	require.NoError(t, d.Set(key, []byte("1000000"), nil))
+	const numMerges = 10000
+	var snap *Snapshot
+	for i := 1; i <= numMerges; i++ {
+		require.NoError(t, d.Merge(key, []byte("1"), nil))
+		if i%2500 == 0 {
+			require.NoError(t, d.Flush())
+		}
+		if i == numMerges/2 {
+			snap = d.NewSnapshot()
+		}
+	}
+	// Leave some operands in the memtable.
+	require.NoError(t, d.Merge(key, []byte("1"), nil))
+	defer func() {
+		require.NoError(t, snap.Close())
+	}()
+
+	read := func(r Reader) string {
+		iter := r.NewIter(nil)
+		defer func() { require.NoError(t, iter.Close()) }()
+		require.True(t, iter.First())
+		require.Equal(t, key, iter.Key())
+		v := string(iter.Value())
+		require.False(t, iter.Next())
+		return v
+	}
+
+	// The base value is merged along with every operand. Flushes have
+	// already collapsed runs of operands within each snapshot stripe, so the
+	// iterator merges only a handful of operands.
+	require.Equal(t, "1010001", read(d))
+	require.Less(t, last.operands, 10)
+	require.True(t, last.includesBase)
+
+	// The snapshot only observes the operands visible at its sequence number,
+	// even though newer operands have since been flushed alongside them.
+	require.Equal(t, "1005000", read(snap))
+	require.True(t, last.includesBase)
+
+	// Operands that have not been flushed are merged by the iterator one at a
+	// time, allocating a bounded amount of memory independent of the number
+	// of operands.
+	hot := []byte("hot")
+	for i := 0; i < numMerges; i++ {
+		require.NoError(t, d.Merge(hot, []byte("1"), nil))
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		iter := d.NewIter(nil)
+		require.True(t, iter.SeekGE(hot))
+		require.Equal(t, "10000", string(iter.Value()))
+		require.NoError(t, iter.Close())
+	})
+	require.Equal(t, numMerges, last.operands)
+	require.Less(t, allocs, float64(100))
+}
+
 func TestLogData(t *testing.T) {
 	d, err := Open("", testingRandomized(&Options{
 		FS: vfs.NewMem(),
@@ -861,6 +1024,34 @@ func TestMemTableReservationLeak(t *testing.T) {
 	}
 }
 
+func TestMemTableSizeRamp(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:               vfs.NewMem(),
+		MemTableSize:     4 << 20,
+		MemTableSizeRamp: 512 << 10,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	mutable := func() *memTable {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.mem.mutable
+	}
+	// Fill each memtable until it's rotated, so that the size of the next
+	// memtable is doubled.
+	value := make([]byte, 32<<10)
+	var n int
+	for _, size := range []int{512 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20} {
+		mem := mutable()
+		require.Equal(t, size, len(mem.arenaBuf))
+		for mutable() == mem {
+			require.NoError(t, d.Set([]byte(fmt.Sprint(n)), value, NoSync))
+			n++
+		}
+	}
+}
+
 func TestCacheEvict(t *testing.T) {
 	cache := NewCache(10 << 20)
 	defer cache.Unref()
@@ -1090,6 +1281,181 @@ func TestDBConcurrentCompactClose(t *testing.T) {
 	}
 }
 
+func TestDBApplyNoSyncWait(t *testing.T) {
+	fs := vfs.NewStrictMem()
+	d, err := Open("", &Options{FS: fs})
+	require.NoError(t, err)
+
+	// The batch must request a sync.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), nil, nil))
+	require.Error(t, d.ApplyNoSyncWait(b, NoSync))
+	require.NoError(t, b.Close())
+
+	// Apply more batches than may have syncs queued in the WAL writer at once,
+	// without waiting for their syncs. Each batch is visible as soon as it's
+	// applied.
+	const n = 2000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%04d", i)) }
+	batches := make([]*Batch, n)
+	for i := range batches {
+		batches[i] = d.NewBatch()
+		require.NoError(t, batches[i].Set(key(i), key(i), nil))
+		require.NoError(t, d.ApplyNoSyncWait(batches[i], Sync))
+		v, closer, err := d.Get(key(i))
+		require.NoError(t, err)
+		require.Equal(t, key(i), v)
+		require.NoError(t, closer.Close())
+	}
+	for _, b := range batches {
+		require.NoError(t, b.SyncWait())
+		require.NoError(t, b.Close())
+	}
+
+	// Once SyncWait returns, the batches are durable. Simulate a crash that
+	// loses any unsynced data, and reopen the DB.
+	fs.SetIgnoreSyncs(true)
+	require.NoError(t, d.Close())
+	fs.ResetToSyncedState()
+	fs.SetIgnoreSyncs(false)
+	d, err = Open("", &Options{FS: fs})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for i := 0; i < n; i++ {
+		v, closer, err := d.Get(key(i))
+		require.NoError(t, err)
+		require.Equal(t, key(i), v)
+		require.NoError(t, closer.Close())
+	}
+}
+
+func TestDBApplyNoSyncWaitClose(t *testing.T) {
+	// Slow down WAL syncs so that batches are closed while their syncs are
+	// outstanding.
+	fs := vfs.NewLatencyFS(vfs.NewMem(), vfs.LatencyConfig{Sync: time.Millisecond})
+	d, err := Open("", &Options{FS: fs})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Closing a batch without calling SyncWait waits for its sync before the
+	// batch is recycled, so that the recycled batch isn't signaled by the sync
+	// of its previous commit.
+	for i := 0; i < 100; i++ {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("%04d", i)), nil, nil))
+		require.NoError(t, d.ApplyNoSyncWait(b, Sync))
+		require.NoError(t, b.Close())
+	}
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.ApplyNoSyncWait(b, Sync))
+	b.Reset()
+	require.NoError(t, b.SyncWait())
+	require.NoError(t, b.Close())
+}
+
+func TestRemoteStorage(t *testing.T) {
+	mem := vfs.NewMem()
+	storage := remote.NewInMem()
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		RemoteStorage:               map[remote.Locator]remote.Storage{"bucket": storage},
+	}
+	opts.Experimental.CreateOnRemote = "bucket"
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// localTables returns the names of the sstables on the local filesystem.
+	localTables := func() []string {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var tables []string
+		for _, name := range ls {
+			if typ, _, ok := base.ParseFilename(mem, name); ok && typ == fileTypeTable {
+				tables = append(tables, name)
+			}
+		}
+		return tables
+	}
+	remoteObjects := func() []string {
+		names, err := storage.List("")
+		require.NoError(t, err)
+		return names
+	}
+	// currentTables returns the names of the remote objects holding the
+	// sstables of the current version.
+	currentTables := func() []string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var names []string
+		for _, files := range d.mu.versions.currentVersion().Levels {
+			iter := files.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				require.Equal(t, remote.Locator("bucket"), f.RemoteLocator)
+				names = append(names, fmt.Sprintf("%s.sst", f.FileNum))
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	scan := func() string {
+		iter := d.NewIter(nil)
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, fmt.Sprintf("%s:%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(keys, " ")
+	}
+
+	// A flush creates its sstable remotely.
+	for _, k := range []string{"a", "c", "e"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.Empty(t, localTables())
+	require.Len(t, remoteObjects(), 1)
+
+	// An ingested sstable is copied to the remote storage.
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+	require.NoError(t, w.Set([]byte("b"), []byte("b")))
+	require.NoError(t, w.Set([]byte("d"), []byte("d")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	require.Empty(t, localTables())
+	require.Len(t, remoteObjects(), 2)
+	require.Equal(t, currentTables(), remoteObjects())
+	require.Equal(t, "a:a b:b c:c d:d e:e", scan())
+
+	// A compaction reads its inputs from and writes its outputs to the
+	// remote storage, deleting the obsolete inputs.
+	require.NoError(t, d.Set([]byte("c"), []byte("c2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	require.Empty(t, localTables())
+	require.Equal(t, currentTables(), remoteObjects())
+	require.Equal(t, "a:a b:b c:c2 d:d e:e", scan())
+	require.NoError(t, d.CheckConsistency())
+
+	// Checkpoints can't link remote sstables.
+	require.Error(t, d.Checkpoint("checkpoint"))
+	require.NoError(t, d.Close())
+
+	// The remote sstables recorded in the MANIFEST require their storage to be
+	// configured.
+	_, err = Open("", &Options{FS: mem})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `no remote storage configured for locator "bucket"`)
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, "a:a b:b c:c2 d:d e:e", scan())
+	require.NoError(t, d.Close())
+}
+
 func TestDBApplyBatchNilDB(t *testing.T) {
 	d, err := Open("", &Options{FS: vfs.NewMem()})
 	require.NoError(t, err)
@@ -1207,6 +1573,30 @@ func TestSSTables(t *testing.T) {
 			require.NotNil(t, info.Properties)
 		}
 	}
+
+	// The table infos match the metadata of the current version, which holds
+	// both sstables within L0.
+	require.Len(t, tableInfos[0], 2)
+	for l := 1; l < numLevels; l++ {
+		require.Empty(t, tableInfos[l])
+	}
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[0].Slice()
+	d.mu.Unlock()
+	var i int
+	files.Each(func(m *fileMetadata) {
+		info := tableInfos[0][i]
+		key := []string{"hello", "world"}[i]
+		require.Equal(t, m.FileNum, info.FileNum)
+		require.Equal(t, m.Size, info.Size)
+		require.Equal(t, key, string(info.Smallest.UserKey))
+		require.Equal(t, key, string(info.Largest.UserKey))
+		require.Equal(t, uint64(i+1), info.SmallestSeqNum)
+		require.Equal(t, uint64(i+1), info.LargestSeqNum)
+		require.Equal(t, uint64(1), info.Properties.NumEntries)
+		require.Equal(t, uint64(len(key)+8), info.Properties.RawKeySize)
+		i++
+	})
 }
 
 func BenchmarkDelete(b *testing.B) {
@@ -1264,6 +1654,59 @@ func BenchmarkDelete(b *testing.B) {
 	})
 }
 
+func BenchmarkGetBuffered(b *testing.B) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatNewest,
+	}
+	opts.Experimental.ValueSeparationThreshold = 100
+	d, err := Open("", opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	const keyCount = 1000
+	keys := make([][]byte, keyCount)
+	val := bytes.Repeat([]byte("x"), 1000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%04d", i))
+		if err := d.Set(keys[i], val, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	// Flush the values, separating them into a blob file.
+	if err := d.Flush(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("get", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, closer, err := d.Get(keys[i%keyCount])
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = closer.Close()
+		}
+	})
+	b.Run("get-buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 2*len(val))
+		for i := 0; i < b.N; i++ {
+			_, closer, err := d.GetBuffered(keys[i%keyCount], buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = closer.Close()
+		}
+	})
+}
+
 func BenchmarkNewIterReadAmp(b *testing.B) {
 	for _, readAmp := range []int{10, 100, 1000} {
 		b.Run(strconv.Itoa(readAmp), func(b *testing.B) {
@@ -1312,3 +1755,124 @@ func verifyGetNotFound(t *testing.T, r Reader, key []byte) {
 		t.Fatalf("expected nil, but got %s", val)
 	}
 }
+
+func TestEstimateDiskUsageAccuracy(t *testing.T) {
+	opts := &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+	}
+	for i := 0; i < numLevels; i++ {
+		opts.Levels = append(opts.Levels, LevelOptions{
+			BlockSize:      4 << 10,
+			Compression:    NoCompression,
+			TargetFileSize: 256 << 10,
+		})
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write 10,000 keys with 1 KB random values, split across many L6 tables
+	// and L0 tables. Each key-value pair occupies just over 1 KB.
+	const n = 10000
+	const valueSize = 1 << 10
+	rng := rand.New(rand.NewSource(0))
+	value := make([]byte, valueSize)
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+	for i := 0; i < n; i++ {
+		rng.Read(value)
+		require.NoError(t, d.Set(key(i), value, nil))
+		if i == n*9/10 {
+			require.NoError(t, d.Compact(key(0), key(n), false))
+		}
+	}
+	require.NoError(t, d.Flush())
+	m := d.Metrics()
+	require.Greater(t, m.Levels[6].NumFiles, int64(10))
+	require.Greater(t, m.Levels[0].NumFiles, int64(0))
+
+	// A range containing every table is estimated at the total size of the
+	// tables.
+	total, err := d.EstimateDiskUsage(key(0), key(n))
+	require.NoError(t, err)
+	require.Equal(t, uint64(m.Total().Size), total)
+
+	for _, r := range [][2]int{{0, 5000}, {1234, 1300}, {2500, 7500}, {8950, 9500}, {9990, n}} {
+		t.Run(fmt.Sprintf("%d-%d", r[0], r[1]), func(t *testing.T) {
+			before := d.Metrics().BlockCache.ByType["data"]
+			est, err := d.EstimateDiskUsage(key(r[0]), key(r[1]))
+			require.NoError(t, err)
+			// The estimate is computed from index blocks alone.
+			require.Equal(t, before, d.Metrics().BlockCache.ByType["data"])
+			// The range [start, end] is inclusive. Each partially overlapping
+			// table contributes whole data blocks, overestimating by less than a
+			// block at either end, and the metadata of fully contained tables
+			// is included.
+			actual := uint64(r[1]-r[0]+1) * valueSize
+			tolerance := 8*uint64(4<<10) + actual/20
+			require.InDelta(t, float64(actual), float64(est), float64(tolerance),
+				"estimate %d, actual %d", est, actual)
+		})
+	}
+}
+
+func TestEstimateDiskUsageByBackingType(t *testing.T) {
+	mem := vfs.NewMem()
+	storage := remote.NewInMem()
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		RemoteStorage:               map[remote.Locator]remote.Storage{"bucket": storage},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), bytes.Repeat([]byte(k), 100), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// Reopen the DB creating new sstables remotely, so that the DB holds one
+	// local and one remote sstable.
+	opts.Experimental.CreateOnRemote = "bucket"
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for _, k := range []string{"x", "y", "z"} {
+		require.NoError(t, d.Set([]byte(k), bytes.Repeat([]byte(k), 200), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	var localSize, remoteSize uint64
+	d.mu.Lock()
+	iter := d.mu.versions.currentVersion().Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if f.RemoteLocator != "" {
+			remoteSize += f.Size
+		} else {
+			localSize += f.Size
+		}
+	}
+	d.mu.Unlock()
+	require.NotZero(t, localSize)
+	require.NotZero(t, remoteSize)
+
+	u, err := d.EstimateDiskUsageByBackingType([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, DiskUsageEstimate{Local: localSize, Remote: remoteSize}, u)
+	total, err := d.EstimateDiskUsage([]byte("a"), []byte("z"))
+	require.NoError(t, err)
+	require.Equal(t, localSize+remoteSize, total)
+
+	u, err = d.EstimateDiskUsageByBackingType([]byte("a"), []byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, DiskUsageEstimate{Local: localSize}, u)
+
+	// A range partially overlapping the remote sstable reads its index from
+	// the remote storage.
+	u, err = d.EstimateDiskUsageByBackingType([]byte("y"), []byte("zz"))
+	require.NoError(t, err)
+	require.Zero(t, u.Local)
+	require.NotZero(t, u.Remote)
+	require.LessOrEqual(t, u.Remote, remoteSize)
+}
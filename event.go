@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -395,9 +396,131 @@ func (i WALDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] WAL deleted %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// WALSyncInfo contains the info for a WAL sync event.
+type WALSyncInfo struct {
+	// FileNum is the file number of the synced WAL.
+	FileNum FileNum
+	// Size is the number of bytes written to the WAL as of the sync.
+	Size uint64
+	// Duration is the time spent syncing the WAL.
+	Duration time.Duration
+	Err      error
+}
+
+func (i WALSyncInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i WALSyncInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	if i.Err != nil {
+		w.Printf("WAL %s sync error: %s", redact.Safe(i.FileNum), i.Err)
+		return
+	}
+	w.Printf("WAL %s synced (%s) in %.1fs",
+		redact.Safe(i.FileNum), redact.Safe(humanize.Uint64(i.Size)), redact.Safe(i.Duration.Seconds()))
+}
+
+// CommitStage identifies a stage of the commit pipeline.
+type CommitStage int
+
+const (
+	// CommitStageWALWrite is the writing of a batch to the WAL, including
+	// waiting for the commits ahead of it to write theirs.
+	CommitStageWALWrite CommitStage = iota
+	// CommitStageMemTableApply is the application of a batch to the memtable.
+	CommitStageMemTableApply
+	// CommitStagePublish is the publication of a batch's sequence number,
+	// including waiting for the earlier batches to be applied.
+	CommitStagePublish
+	// CommitStageWALSync is the wait for the WAL sync of a batch committed
+	// with Sync, after the batch is published. It isn't recorded for batches
+	// committed through DB.ApplyNoSyncWait.
+	CommitStageWALSync
+	numCommitStages
+)
+
+// String implements the fmt.Stringer interface.
+func (s CommitStage) String() string {
+	switch s {
+	case CommitStageWALWrite:
+		return "WAL write"
+	case CommitStageMemTableApply:
+		return "memtable apply"
+	case CommitStagePublish:
+		return "publish"
+	case CommitStageWALSync:
+		return "WAL sync"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(s))
+	}
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (s CommitStage) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Print(redact.SafeString(s.String()))
+}
+
+// CommitPipelineStallInfo contains the info for a commit pipeline stall event.
+type CommitPipelineStallInfo struct {
+	// Stage is the stage of the commit that exceeded the threshold.
+	Stage CommitStage
+	// Duration is the time the commit had spent in the stage when the stall
+	// was detected. The stage may continue beyond it.
+	Duration time.Duration
+}
+
+func (i CommitPipelineStallInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i CommitPipelineStallInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("commit pipeline stall: %s in progress for %.3fs", i.Stage, redact.Safe(i.Duration.Seconds()))
+}
+
+// WriteStallCause classifies the cause of a write stall.
+type WriteStallCause int
+
+const (
+	// WriteStallMemTableCount indicates that writes stalled because the
+	// queued memtables reached MemTableStopWritesThreshold while they're
+	// flushed.
+	WriteStallMemTableCount WriteStallCause = iota
+	// WriteStallL0FileCount indicates that writes stalled because L0's read
+	// amplification reached L0StopWritesThreshold while L0 is compacted.
+	WriteStallL0FileCount
+)
+
+// String implements the fmt.Stringer interface.
+func (c WriteStallCause) String() string {
+	switch c {
+	case WriteStallMemTableCount:
+		return "memtable count"
+	case WriteStallL0FileCount:
+		return "L0 file count"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(c))
+	}
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (c WriteStallCause) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Print(redact.SafeString(c.String()))
+}
+
 // WriteStallBeginInfo contains the info for a write stall begin event.
 type WriteStallBeginInfo struct {
 	Reason string
+	// Cause classifies the cause of the stall.
+	Cause WriteStallCause
+	// Current is the value that reached Threshold, causing the stall. For a
+	// WriteStallMemTableCount stall, they're the bytes of the queued
+	// memtables and the limit on them, MemTableStopWritesThreshold times
+	// MemTableSize. For a WriteStallL0FileCount stall, they're L0's read
+	// amplification and L0StopWritesThreshold.
+	Current   uint64
+	Threshold uint64
 }
 
 func (i WriteStallBeginInfo) String() string {
@@ -406,7 +529,8 @@ func (i WriteStallBeginInfo) String() string {
 
 // SafeFormat implements redact.SafeFormatter.
 func (i WriteStallBeginInfo) SafeFormat(w redact.SafePrinter, _ rune) {
-	w.Printf("write stall beginning: %s", redact.Safe(i.Reason))
+	w.Printf("write stall beginning: %s (current %d, threshold %d)",
+		redact.Safe(i.Reason), redact.Safe(i.Current), redact.Safe(i.Threshold))
 }
 
 // EventListener contains a set of functions that will be invoked when various
@@ -427,6 +551,12 @@ type EventListener struct {
 	// has been installed.
 	CompactionEnd func(CompactionInfo)
 
+	// CommitPipelineStall is invoked when a stage of a commit takes longer
+	// than Options.Experimental.CommitPipelineStallThreshold, while the stage
+	// is in progress. It's invoked at most once per stage of a commit, from a
+	// background goroutine, and must not block.
+	CommitPipelineStall func(CommitPipelineStallInfo)
+
 	// DiskSlow is invoked after a disk write operation on a file created
 	// with a disk health checking vfs.FS (see vfs.DefaultWithDiskHealthChecks)
 	// is observed to exceed the specified disk slowness threshold duration.
@@ -444,6 +574,14 @@ type EventListener struct {
 	// is upgraded.
 	FormatUpgrade func(FormatMajorVersion)
 
+	// LogDataReplayed is invoked during Open for each record written by
+	// LogData to the WALs replayed by Open, in the order the records were
+	// written. The record's data must not be retained after the call returns.
+	// The seqNum is the sequence number of the batch that contained the
+	// record, plus the number of keys in the batch preceding the record; ie,
+	// the sequence number of the next key written after the record.
+	LogDataReplayed func(seqNum uint64, data []byte)
+
 	// ManifestCreated is invoked after a manifest has been created.
 	ManifestCreated func(ManifestCreateInfo)
 
@@ -473,7 +611,15 @@ type EventListener struct {
 	// WALDeleted is invoked after a WAL has been deleted.
 	WALDeleted func(WALDeleteInfo)
 
-	// WriteStallBegin is invoked when writes are intentionally delayed.
+	// WALSynced is invoked after each sync of a WAL, before the writes awaiting
+	// the sync are acknowledged. It's invoked from the WAL's flush goroutine,
+	// and must not block.
+	WALSynced func(WALSyncInfo)
+
+	// WriteStallBegin is invoked when writes are intentionally delayed. It's
+	// invoked from the write path without holding DB.mu, so it may call into
+	// the DB, for instance to collect its Metrics. The stalled write remains
+	// blocked until it returns.
 	WriteStallBegin func(WriteStallBeginInfo)
 
 	// WriteStallEnd is invoked when delayed writes are released.
@@ -500,6 +646,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.CompactionEnd == nil {
 		l.CompactionEnd = func(info CompactionInfo) {}
 	}
+	if l.CommitPipelineStall == nil {
+		l.CommitPipelineStall = func(info CommitPipelineStallInfo) {}
+	}
 	if l.DiskSlow == nil {
 		l.DiskSlow = func(info DiskSlowInfo) {}
 	}
@@ -512,6 +661,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.FormatUpgrade == nil {
 		l.FormatUpgrade = func(v FormatMajorVersion) {}
 	}
+	if l.LogDataReplayed == nil {
+		l.LogDataReplayed = func(seqNum uint64, data []byte) {}
+	}
 	if l.ManifestCreated == nil {
 		l.ManifestCreated = func(info ManifestCreateInfo) {}
 	}
@@ -539,6 +691,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.WALDeleted == nil {
 		l.WALDeleted = func(info WALDeleteInfo) {}
 	}
+	if l.WALSynced == nil {
+		l.WALSynced = func(info WALSyncInfo) {}
+	}
 	if l.WriteStallBegin == nil {
 		l.WriteStallBegin = func(info WriteStallBeginInfo) {}
 	}
@@ -564,6 +719,9 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		CompactionEnd: func(info CompactionInfo) {
 			logger.Infof("%s", info)
 		},
+		CommitPipelineStall: func(info CommitPipelineStallInfo) {
+			logger.Infof("%s", info)
+		},
 		DiskSlow: func(info DiskSlowInfo) {
 			logger.Infof("%s", info)
 		},
@@ -576,6 +734,7 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		FormatUpgrade: func(v FormatMajorVersion) {
 			logger.Infof("upgraded to format version: %s", v)
 		},
+		LogDataReplayed: func(seqNum uint64, data []byte) {},
 		ManifestCreated: func(info ManifestCreateInfo) {
 			logger.Infof("%s", info)
 		},
@@ -603,6 +762,12 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		WALDeleted: func(info WALDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		WALSynced: func(info WALSyncInfo) {
+			// WALs are synced too frequently to log each sync.
+			if info.Err != nil {
+				logger.Infof("%s", info)
+			}
+		},
 		WriteStallBegin: func(info WriteStallBeginInfo) {
 			logger.Infof("%s", info)
 		},
@@ -629,6 +794,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.CompactionEnd(info)
 			b.CompactionEnd(info)
 		},
+		CommitPipelineStall: func(info CommitPipelineStallInfo) {
+			a.CommitPipelineStall(info)
+			b.CommitPipelineStall(info)
+		},
 		DiskSlow: func(info DiskSlowInfo) {
 			a.DiskSlow(info)
 			b.DiskSlow(info)
@@ -645,6 +814,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.FormatUpgrade(v)
 			b.FormatUpgrade(v)
 		},
+		LogDataReplayed: func(seqNum uint64, data []byte) {
+			a.LogDataReplayed(seqNum, data)
+			b.LogDataReplayed(seqNum, data)
+		},
 		ManifestCreated: func(info ManifestCreateInfo) {
 			a.ManifestCreated(info)
 			b.ManifestCreated(info)
@@ -681,6 +854,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.WALDeleted(info)
 			b.WALDeleted(info)
 		},
+		WALSynced: func(info WALSyncInfo) {
+			a.WALSynced(info)
+			b.WALSynced(info)
+		},
 		WriteStallBegin: func(info WriteStallBeginInfo) {
 			a.WriteStallBegin(info)
 			b.WriteStallBegin(info)
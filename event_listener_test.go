@@ -279,9 +279,10 @@ func TestWriteStallEvents(t *testing.T) {
 	testCases := []struct {
 		delayFlush bool
 		expected   string
+		cause      WriteStallCause
 	}{
-		{true, "memtable count limit reached"},
-		{false, "L0 file count limit exceeded"},
+		{true, "memtable count limit reached", WriteStallMemTableCount},
+		{false, "L0 file count limit exceeded", WriteStallL0FileCount},
 	}
 
 	for _, c := range testCases {
@@ -290,6 +291,8 @@ func TestWriteStallEvents(t *testing.T) {
 			createReleased := make(chan struct{}, flushCount)
 			var buf syncedBuffer
 			var delayOnce sync.Once
+			var d *DB
+			var causes []WriteStallCause
 			listener := EventListener{
 				TableCreated: func(info TableCreateInfo) {
 					if c.delayFlush == (info.Reason == "flushing") {
@@ -300,6 +303,13 @@ func TestWriteStallEvents(t *testing.T) {
 				},
 				WriteStallBegin: func(info WriteStallBeginInfo) {
 					fmt.Fprintln(&buf, info.String())
+					causes = append(causes, info.Cause)
+					require.GreaterOrEqual(t, info.Current, info.Threshold)
+					if info.Cause == WriteStallL0FileCount {
+						require.Equal(t, uint64(2), info.Threshold)
+					}
+					// The handler may call into the DB without deadlocking.
+					_ = d.Metrics()
 					createReleased <- struct{}{}
 				},
 				WriteStallEnd: func() {
@@ -310,7 +320,8 @@ func TestWriteStallEvents(t *testing.T) {
 					}
 				},
 			}
-			d, err := Open("db", &Options{
+			var err error
+			d, err = Open("db", &Options{
 				EventListener:               listener,
 				FS:                          vfs.NewMem(),
 				MemTableSize:                initialMemTableSize,
@@ -345,6 +356,7 @@ func TestWriteStallEvents(t *testing.T) {
 			events := buf.String()
 			require.Contains(t, events, c.expected)
 			require.Contains(t, events, writeStallEnd)
+			require.Contains(t, causes, c.cause)
 			if testing.Verbose() {
 				t.Logf("\n%s", events)
 			}
@@ -412,3 +424,84 @@ func testAllCallbacksSetInEventListener(t *testing.T, e EventListener) {
 		require.False(t, fVal.IsNil(), "unexpected nil field: %s", fType.Name)
 	}
 }
+
+func TestEventListenerWALSynced(t *testing.T) {
+	var mu sync.Mutex
+	var created []FileNum
+	synced := make(map[FileNum][]WALSyncInfo)
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		EventListener: EventListener{
+			WALCreated: func(info WALCreateInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				created = append(created, info.FileNum)
+			},
+			WALSynced: func(info WALSyncInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				synced[info.FileNum] = append(synced[info.FileNum], info)
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 2; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprint(j)), nil, Sync))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, created, 4)
+	for _, fileNum := range created[:3] {
+		infos := synced[fileNum]
+		require.NotEmpty(t, infos, "WAL %s", fileNum)
+		var size uint64
+		for _, info := range infos {
+			require.NoError(t, info.Err)
+			require.Less(t, size, info.Size)
+			size = info.Size
+		}
+	}
+}
+
+func TestEventListenerLogDataReplayed(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.LogData([]byte("x"), nil))
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("b"), nil, nil))
+	require.NoError(t, b.LogData([]byte("y"), nil))
+	require.NoError(t, b.Set([]byte("c"), nil, nil))
+	require.NoError(t, d.Apply(b, nil))
+	require.NoError(t, d.LogData([]byte("z"), Sync))
+	require.NoError(t, d.Close())
+
+	var replayed []string
+	d, err = Open("", &Options{
+		FS: mem,
+		EventListener: EventListener{
+			LogDataReplayed: func(seqNum uint64, data []byte) {
+				replayed = append(replayed, fmt.Sprintf("%s#%d", data, seqNum))
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"x#2", "y#3", "z#4"}, replayed)
+
+	// The log data doesn't create any keys.
+	iter := d.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a", "b", "c"}, keys)
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,333 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// exciseSpanOverlaps returns true if the key range [smallest, largest]
+// overlaps the excise span.
+func exciseSpanOverlaps(cmp Compare, span KeyRange, smallest, largest InternalKey) bool {
+	if cmp(smallest.UserKey, span.End) >= 0 {
+		return false
+	}
+	c := cmp(largest.UserKey, span.Start)
+	return c > 0 || (c == 0 && !largest.IsExclusiveSentinel())
+}
+
+// exciseSpanContains returns true if the key range [smallest, largest] lies
+// entirely within the excise span.
+func exciseSpanContains(cmp Compare, span KeyRange, smallest, largest InternalKey) bool {
+	if cmp(smallest.UserKey, span.Start) < 0 {
+		return false
+	}
+	c := cmp(largest.UserKey, span.End)
+	return c < 0 || (c == 0 && largest.IsExclusiveSentinel())
+}
+
+// exciseConflictsWithCompactionLocked returns true if an in-progress
+// compaction's key range overlaps the excise span. Such a compaction may be
+// reading or deleting sstables that the excise would delete. Flushes are
+// ignored: any memtable containing keys within the excise span is flushed
+// before the excise is applied.
+//
+// d.mu must be held when calling this.
+func (d *DB) exciseConflictsWithCompactionLocked(span KeyRange) bool {
+	for c := range d.mu.compact.inProgress {
+		if c.kind == CompactionKindFlush {
+			continue
+		}
+		if exciseSpanOverlaps(d.cmp, span, c.smallest, c.largest) {
+			return true
+		}
+	}
+	return false
+}
+
+// excise adds to ve the changes to v necessary to remove all keys within the
+// excise span that are older than seqNum. Sstables lying entirely within the
+// span are deleted. Sstables straddling a boundary of the span are deleted and
+// replaced by virtual sstables backed by the straddling sstable, exposing only
+// the keys outside of the span, so that no data is rewritten. Range deletions
+// and range keys are truncated to exclude the span. The metrics for the
+// affected levels are updated accordingly.
+//
+// Both d.mu and the manifest lock must be held when calling this, and no
+// in-progress compaction may overlap the excise span. The format major
+// version must be at least FormatVirtualSSTables.
+func (d *DB) excise(
+	ve *versionEdit,
+	v *version,
+	span KeyRange,
+	seqNum uint64,
+	metrics map[int]*LevelMetrics,
+) (retErr error) {
+	if d.mu.formatVers.vers < FormatVirtualSSTables {
+		return errors.Errorf("pebble: excise requires format major version %s or higher",
+			FormatVirtualSSTables)
+	}
+	// Release the virtual sstables created for the excise if it fails.
+	numNewFiles := len(ve.NewFiles)
+	defer func() {
+		if retErr != nil {
+			d.discardExciseOutputsLocked(ve.NewFiles[numNewFiles:])
+		}
+	}()
+	for level := range v.Levels {
+		var files []*fileMetadata
+		iter := v.Levels[level].Iter()
+		if level == 0 {
+			// L0 sstables may overlap one another, so examine every sstable.
+			for f := iter.First(); f != nil; f = iter.Next() {
+				files = append(files, f)
+			}
+		} else {
+			overlaps := v.Overlaps(level, d.cmp, span.Start, span.End, true /* exclusiveEnd */)
+			iter := overlaps.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				files = append(files, f)
+			}
+		}
+
+		for _, f := range files {
+			if !exciseSpanOverlaps(d.cmp, span, f.Smallest, f.Largest) {
+				continue
+			}
+			if level == 0 && f.SmallestSeqNum >= seqNum {
+				// The sstable was flushed after the memtables overlapping the
+				// excise span, and contains only keys newer than the excise.
+				continue
+			}
+			if f.LargestSeqNum >= seqNum {
+				// The sstable contains keys newer than the excise below L0, which
+				// can only occur if a compaction of a newly flushed sstable
+				// completed before the excise was applied.
+				return errors.Errorf("pebble: sstable %s overlapping excise span contains keys newer than the excise",
+					f.FileNum)
+			}
+
+			levelMetrics := metrics[level]
+			if levelMetrics == nil {
+				levelMetrics = &LevelMetrics{}
+				metrics[level] = levelMetrics
+			}
+			ve.DeletedFiles[deletedFileEntry{Level: level, FileNum: f.FileNum}] = f
+			levelMetrics.NumFiles--
+			levelMetrics.Size -= int64(f.Size)
+
+			if exciseSpanContains(d.cmp, span, f.Smallest, f.Largest) {
+				continue
+			}
+			newFiles, err := d.exciseVirtualTable(f, span)
+			if err != nil {
+				return err
+			}
+			for _, m := range newFiles {
+				ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: level, Meta: m})
+				levelMetrics.NumFiles++
+				levelMetrics.Size += int64(m.Size)
+			}
+		}
+	}
+	return nil
+}
+
+// discardExciseOutputsLocked discards the virtual sstables created by an
+// excise that failed to be applied, releasing their references to the
+// sstables backing them. The excised sstables continue to reference the
+// backing sstables, so this never releases the last reference.
+//
+// d.mu must be held when calling this.
+func (d *DB) discardExciseOutputsLocked(files []newFileEntry) {
+	for _, e := range files {
+		e.Meta.FileBacking.Unref()
+	}
+}
+
+// exciseVirtualTable creates virtual sstables exposing the keys of the sstable
+// f lying outside of the excise span: one exposing the keys before the start
+// of the span, and one exposing the keys at or after the end of the span.
+// Either virtual sstable is omitted if it would be empty. The virtual sstables
+// are backed by the physical sstable containing f's keys, so no data is
+// rewritten. Each virtual sstable returned holds a reference to its backing.
+//
+// d.mu must be held when calling this.
+func (d *DB) exciseVirtualTable(f *fileMetadata, span KeyRange) (_ []*fileMetadata, retErr error) {
+	iter, rangeDelIter, err := d.newIters(f, nil /* opts */, internalIterOpts{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		retErr = firstError(retErr, iter.Close())
+		if rangeDelIter != nil {
+			retErr = firstError(retErr, rangeDelIter.Close())
+		}
+	}()
+	var rangeKeyIter keyspan.FragmentIterator
+	if f.HasRangeKeys {
+		if rangeKeyIter, err = d.tableNewRangeKeyIter(f, nil /* opts */); err != nil {
+			return nil, err
+		}
+		defer func() {
+			retErr = firstError(retErr, rangeKeyIter.Close())
+		}()
+	}
+
+	backing := d.mu.versions.fileBackingLocked(f)
+	var newFiles []*fileMetadata
+	defer func() {
+		if retErr != nil {
+			for range newFiles {
+				backing.Unref()
+			}
+		}
+	}()
+	bounds := [2][2][]byte{{nil, span.Start}, {span.End, nil}}
+	for _, b := range bounds {
+		m := &fileMetadata{
+			CreationTime: f.CreationTime,
+			// The virtual sstable retains the sequence numbers of the backing
+			// sstable. In particular, an ingested sstable's global sequence
+			// number continues to apply.
+			SmallestSeqNum: f.SmallestSeqNum,
+			LargestSeqNum:  f.LargestSeqNum,
+			Virtual:        true,
+			FileBacking:    backing,
+			RemoteLocator:  f.RemoteLocator,
+			BlobReferences: f.BlobReferences,
+		}
+		key, _ := firstWithin(iter, b[0], b[1], d.cmp)
+		if key != nil {
+			smallest := key.Clone()
+			var largest *InternalKey
+			if b[1] == nil {
+				largest, _ = iter.Last()
+			} else {
+				largest, _ = iter.SeekLT(b[1], base.SeekLTFlagsNone)
+			}
+			m.ExtendPointKeyBounds(d.cmp, smallest, largest.Clone())
+		}
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+		if rangeDelIter != nil {
+			smallest, largest, ok := exciseSpanBounds(d.cmp, rangeDelIter, b[0], b[1])
+			if ok {
+				m.ExtendPointKeyBounds(d.cmp, smallest, largest)
+			}
+			if err := rangeDelIter.Error(); err != nil {
+				return nil, err
+			}
+		}
+		if rangeKeyIter != nil {
+			smallest, largest, ok := exciseSpanBounds(d.cmp, rangeKeyIter, b[0], b[1])
+			if ok {
+				m.ExtendRangeKeyBounds(d.cmp, smallest, largest)
+			}
+			if err := rangeKeyIter.Error(); err != nil {
+				return nil, err
+			}
+		}
+		if !m.HasPointKeys && !m.HasRangeKeys {
+			continue
+		}
+
+		m.FileNum = d.mu.versions.getNextFileNum()
+		size, err := d.estimateTableDiskUsage(m, m.Smallest.UserKey, m.Largest.UserKey)
+		if err != nil {
+			return nil, err
+		}
+		// A virtual sstable has a non-zero size, so that it's accounted for by
+		// compaction heuristics.
+		if size == 0 {
+			size = 1
+		}
+		m.Size = size
+		if err := m.Validate(d.cmp, d.opts.Comparer.FormatKey); err != nil {
+			return nil, err
+		}
+		backing.Ref()
+		newFiles = append(newFiles, m)
+	}
+	return newFiles, nil
+}
+
+// firstWithin positions iter at its first key within [lower, upper),
+// returning nil if there is no such key. A nil lower or upper bound is
+// unbounded.
+func firstWithin(iter internalIterator, lower, upper []byte, cmp Compare) (*InternalKey, []byte) {
+	var key *InternalKey
+	var value []byte
+	if lower == nil {
+		key, value = iter.First()
+	} else {
+		key, value = iter.SeekGE(lower, base.SeekGEFlagsNone)
+	}
+	if key == nil || (upper != nil && cmp(key.UserKey, upper) >= 0) {
+		return nil, nil
+	}
+	return key, value
+}
+
+// exciseSpanBounds returns the smallest and largest keys of the spans of iter
+// once truncated to [lower, upper), and false if no span overlaps [lower,
+// upper). A nil lower or upper bound is unbounded. The caller is responsible
+// for checking iter.Error().
+func exciseSpanBounds(
+	cmp Compare, iter keyspan.FragmentIterator, lower, upper []byte,
+) (smallest, largest InternalKey, ok bool) {
+	var s *keyspan.Span
+	if lower == nil {
+		s = iter.First()
+	} else if s = iter.SeekLT(lower); s == nil || cmp(s.End, lower) <= 0 {
+		s = iter.Next()
+	}
+	if s == nil || (upper != nil && cmp(s.Start, upper) >= 0) {
+		return InternalKey{}, InternalKey{}, false
+	}
+	first := *s
+	if lower != nil && cmp(first.Start, lower) < 0 {
+		first.Start = lower
+	}
+	smallest = first.SmallestKey().Clone()
+
+	if upper == nil {
+		s = iter.Last()
+	} else {
+		s = iter.SeekLT(upper)
+	}
+	last := *s
+	if upper != nil && cmp(last.End, upper) > 0 {
+		last.End = upper
+	}
+	largest = last.LargestKey().Clone()
+	return smallest, largest, true
+}
+
+// ingestExciseTargetLevel is the ingestTargetLevelFunc used when ingesting
+// with an excise span. The ingested sstables lie within the excise span, and
+// the excise removes all existing keys within it, so the sstables do not
+// overlap any data in the LSM once the excise is applied. They're placed into
+// the lowest level which has no in-progress compaction outputting to an
+// overlapping key range.
+func ingestExciseTargetLevel(
+	newIters tableNewIters,
+	iterOps IterOptions,
+	cmp Compare,
+	v *version,
+	baseLevel int,
+	compactions map[*compaction]struct{},
+	meta *fileMetadata,
+) (int, error) {
+	for level := numLevels - 1; level > 0; level-- {
+		if !ingestCompactionOverlaps(cmp, compactions, level, meta) {
+			return level, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExcise(t *testing.T) {
+	var mem vfs.FS
+	var d *DB
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	reset := func(formatVers FormatMajorVersion) {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+
+		mem = vfs.NewMem()
+		require.NoError(t, mem.MkdirAll("ext", 0755))
+		opts := &Options{
+			Comparer:                    testkeys.Comparer,
+			FS:                          mem,
+			FormatMajorVersion:          formatVers,
+			L0CompactionThreshold:       100,
+			L0StopWritesThreshold:       100,
+			DebugCheck:                  DebugCheckLevels,
+			DisableAutomaticCompactions: true,
+		}
+		var err error
+		d, err = Open("", opts)
+		require.NoError(t, err)
+	}
+	reset(FormatNewest)
+
+	datadriven.RunTest(t, "testdata/excise", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "reset":
+			formatVers := FormatNewest
+			for _, arg := range td.CmdArgs {
+				switch arg.Key {
+				case "format-major-version":
+					v, err := strconv.Atoi(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
+					formatVers = FormatMajorVersion(v)
+				default:
+					return fmt.Sprintf("unknown argument: %s", arg.Key)
+				}
+			}
+			reset(formatVers)
+			return ""
+
+		case "batch":
+			b := d.NewIndexedBatch()
+			if err := runBatchDefineCmd(td, b); err != nil {
+				return err.Error()
+			}
+			if err := b.Commit(nil); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "build":
+			if err := runBuildCmd(td, d, mem); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "flush":
+			if err := d.Flush(); err != nil {
+				return err.Error()
+			}
+			return runLSMCmd(td, d)
+
+		case "ingest":
+			if err := runIngestCmd(td, d, mem); err != nil {
+				return err.Error()
+			}
+			return runLSMCmd(td, d)
+
+		case "ingest-and-excise":
+			var paths []string
+			var span KeyRange
+			for _, arg := range td.CmdArgs {
+				switch arg.Key {
+				case "excise":
+					parts := strings.Split(arg.Vals[0], "-")
+					if len(parts) != 2 {
+						return fmt.Sprintf("expected excise=<start>-<end>: %s", arg.Vals[0])
+					}
+					span = KeyRange{Start: []byte(parts[0]), End: []byte(parts[1])}
+				default:
+					paths = append(paths, arg.String())
+				}
+			}
+			if err := d.IngestAndExcise(paths, span); err != nil {
+				return err.Error()
+			}
+			return runLSMCmd(td, d)
+
+		case "get":
+			return runGetCmd(td, d)
+
+		case "iter":
+			iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+			return runIterCmd(td, iter, true)
+
+		case "lsm":
+			return runLSMCmd(td, d)
+
+		default:
+			return fmt.Sprintf("unknown command: %s", td.Cmd)
+		}
+	})
+}
+
+func TestExciseReadersBeforeEdit(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatVirtualSSTables})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, d.Set([]byte(k), []byte("old"), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	collect := func(iter *Iterator) []string {
+		var kvs []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			kvs = append(kvs, fmt.Sprintf("%s:%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return kvs
+	}
+
+	// An iterator opened before the excise continues to observe the old keys
+	// within the excise span.
+	before := d.NewIter(nil)
+
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat())
+	tw := sstable.NewWriter(f, w)
+	require.NoError(t, tw.Set([]byte("c"), []byte("new")))
+	require.NoError(t, tw.Close())
+	require.NoError(t, d.IngestAndExcise([]string{"ext"}, KeyRange{Start: []byte("b"), End: []byte("e")}))
+
+	require.Equal(t, []string{"a:old", "b:old", "c:old", "d:old", "e:old"}, collect(before))
+	require.Equal(t, []string{"a:old", "c:new", "e:old"}, collect(d.NewIter(nil)))
+
+	// Ingested sstables must lie within the excise span.
+	f, err = mem.Create("ext")
+	require.NoError(t, err)
+	tw = sstable.NewWriter(f, w)
+	require.NoError(t, tw.Set([]byte("f"), []byte("new")))
+	require.NoError(t, tw.Close())
+	err = d.IngestAndExcise([]string{"ext"}, KeyRange{Start: []byte("b"), End: []byte("e")})
+	require.True(t, err != nil && strings.Contains(err.Error(), "not contained within the excise span"),
+		"unexpected error: %v", err)
+	require.Error(t, d.IngestAndExcise(nil, KeyRange{Start: []byte("e"), End: []byte("b")}))
+}
+
+func TestExciseWithOpenSnapshots(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatVirtualSSTables})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("b"), []byte("old"), nil))
+	require.NoError(t, d.Flush())
+
+	ingest := func() error {
+		f, err := mem.Create("ext")
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+		require.NoError(t, w.Set([]byte("c"), []byte("new")))
+		require.NoError(t, w.Close())
+		return d.IngestAndExcise([]string{"ext"}, KeyRange{Start: []byte("a"), End: []byte("d")})
+	}
+
+	// The excise would remove a key visible to the snapshot, so it fails and
+	// leaves the LSM unmodified.
+	snap := d.NewSnapshot()
+	require.ErrorIs(t, ingest(), ErrExciseWithOpenSnapshots)
+	v, closer, err := snap.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "old", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = d.Get([]byte("c"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Once the snapshot is closed the excise succeeds.
+	require.NoError(t, snap.Close())
+	require.NoError(t, ingest())
+	_, _, err = d.Get([]byte("b"))
+	require.ErrorIs(t, err, ErrNotFound)
+	v, closer, err = d.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, "new", string(v))
+	require.NoError(t, closer.Close())
+}
+
+func TestExciseVirtualSSTables(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                          mem,
+		FormatMajorVersion:          FormatVirtualSSTables,
+		DisableAutomaticCompactions: true,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		require.NoError(t, d.Set([]byte(k), []byte("old"), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("g"), false /* parallelize */))
+
+	levelFiles := func(level int) []*fileMetadata {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var files []*fileMetadata
+		iter := d.mu.versions.currentVersion().Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, f)
+		}
+		return files
+	}
+	backingRefs := func(fileNum FileNum) int32 {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		b, ok := d.mu.versions.fileBackings[fileNum]
+		if !ok {
+			return 0
+		}
+		return b.Refs()
+	}
+	exists := func(fileNum FileNum) bool {
+		_, err := mem.Stat(base.MakeFilepath(mem, "", fileTypeTable, fileNum))
+		return err == nil
+	}
+	collect := func() []string {
+		iter := d.NewIter(nil)
+		var kvs []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			kvs = append(kvs, fmt.Sprintf("%s:%s", iter.Key(), iter.Value()))
+		}
+		require.NoError(t, iter.Close())
+		return kvs
+	}
+
+	files := levelFiles(6)
+	require.Len(t, files, 1)
+	physical := files[0].FileNum
+
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+	require.NoError(t, w.Set([]byte("c"), []byte("new")))
+	require.NoError(t, w.Set([]byte("d"), []byte("new")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.IngestAndExcise([]string{"ext"}, KeyRange{Start: []byte("c"), End: []byte("e")}))
+
+	// The excised sstable is replaced by two virtual sstables backed by it.
+	checkVirtual := func() {
+		files := levelFiles(6)
+		require.Len(t, files, 3)
+		var virtual []*fileMetadata
+		for _, f := range files {
+			if f.Virtual {
+				virtual = append(virtual, f)
+			}
+		}
+		require.Len(t, virtual, 2)
+		require.Equal(t, "a", string(virtual[0].Smallest.UserKey))
+		require.Equal(t, "b", string(virtual[0].Largest.UserKey))
+		require.Equal(t, "e", string(virtual[1].Smallest.UserKey))
+		require.Equal(t, "f", string(virtual[1].Largest.UserKey))
+		require.True(t, virtual[0].FileBacking == virtual[1].FileBacking)
+		require.Equal(t, physical, virtual[0].FileBacking.FileNum)
+		require.Equal(t, int32(2), backingRefs(physical))
+		require.True(t, exists(physical))
+		require.Equal(t, []string{"a:old", "b:old", "c:new", "d:new", "e:old", "f:old"}, collect())
+	}
+	checkVirtual()
+
+	// The virtual sstables persist across a restart.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	checkVirtual()
+
+	// Compacting away one of the virtual sstables leaves the backing sstable
+	// in place for the other. The compaction of a flushed sstable into L6
+	// rewrites the overlapping virtual sstable.
+	compactKey := func(k string) {
+		require.NoError(t, d.Set([]byte(k), []byte("new"), nil))
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Compact([]byte(k), []byte(k+"\x00"), false /* parallelize */))
+	}
+	compactKey("a")
+	require.Equal(t, int32(1), backingRefs(physical))
+	require.True(t, exists(physical))
+
+	// An iterator's reference to the remaining virtual sstable keeps the
+	// backing sstable alive once the virtual sstable is compacted away.
+	iter := d.NewIter(nil)
+	compactKey("f")
+	for _, f := range levelFiles(6) {
+		require.False(t, f.Virtual)
+	}
+	require.Equal(t, int32(1), backingRefs(physical))
+	require.True(t, exists(physical))
+	require.NoError(t, iter.Close())
+	require.Equal(t, int32(0), backingRefs(physical))
+	require.NoError(t, d.Close())
+	require.False(t, exists(physical))
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a:new", "b:old", "c:new", "d:new", "e:old", "f:new"}, collect())
+	require.NoError(t, d.Close())
+}
@@ -59,6 +59,7 @@ func NewExternalIter(
 		equal:               o.equal(),
 		merge:               o.Merger.Merge,
 		split:               o.Comparer.Split,
+		rangeKeyMerge:       o.rangeKeyMerge(),
 		readState:           nil,
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
@@ -98,6 +99,8 @@ func validateExternalIterOpts(iterOpts *IterOptions) error {
 		return errors.Errorf("pebble: external iterator: OnlyReadGuaranteedDurable unsupported")
 	case iterOpts.UseL6Filters:
 		return errors.Errorf("pebble: external iterator: UseL6Filters unsupported")
+	case iterOpts.SkipCorruptBlocks:
+		return errors.Errorf("pebble: external iterator: SkipCorruptBlocks unsupported")
 	}
 	return nil
 }
@@ -149,6 +152,7 @@ func finishInitializingExternal(it *Iterator) {
 			it.rangeKey.init(it.cmp, it.split, &it.opts)
 			it.rangeKey.rangeKeyIter = it.rangeKey.iterConfig.Init(
 				it.cmp,
+				it.rangeKeyMerge,
 				base.InternalKeySeqNumMax,
 			)
 			for _, r := range it.externalReaders {
@@ -44,7 +44,7 @@ func flushExternalTable(untypedDB interface{}, path string, originalMeta *fileMe
 	}
 
 	// Hard link the sstable into the DB directory.
-	if err := ingestLink(jobID, d.opts, d.dirname, []string{path}, []*fileMetadata{m}); err != nil {
+	if err := ingestLink(jobID, d.opts, d.objProvider, []string{path}, []*fileMetadata{m}); err != nil {
 		return err
 	}
 	if err := d.dataDir.Sync(); err != nil {
@@ -80,7 +80,7 @@ func flushExternalTable(untypedDB interface{}, path string, originalMeta *fileMe
 	if err != nil {
 		// NB: logAndApply will release d.mu.versions.logLock  unconditionally.
 		d.mu.Unlock()
-		if err2 := ingestCleanup(d.opts.FS, d.dirname, []*fileMetadata{m}); err2 != nil {
+		if err2 := ingestCleanup(d.objProvider, []*fileMetadata{m}); err2 != nil {
 			d.opts.Logger.Infof("flush external cleanup failed: %v", err2)
 		}
 		return err
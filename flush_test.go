@@ -11,6 +11,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -19,6 +20,8 @@ func TestManualFlush(t *testing.T) {
 	getOptions := func() *Options {
 		opts := &Options{
 			FS:                    vfs.NewMem(),
+			Comparer:              testkeys.Comparer,
+			FormatMajorVersion:    FormatNewest,
 			L0CompactionThreshold: 10,
 		}
 		opts.DisableAutomaticCompactions = true
@@ -50,6 +53,19 @@ func TestManualFlush(t *testing.T) {
 			d.mu.Unlock()
 			return s
 
+		case "flush-range":
+			var start, end string
+			td.ScanArgs(t, "start", &start)
+			td.ScanArgs(t, "end", &end)
+			if err := d.FlushRange([]byte(start), []byte(end)); err != nil {
+				return err.Error()
+			}
+
+			d.mu.Lock()
+			s := d.mu.versions.currentVersion().String()
+			d.mu.Unlock()
+			return s
+
 		case "async-flush":
 			d.mu.Lock()
 			cur := d.mu.versions.currentVersion()
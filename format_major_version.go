@@ -92,11 +92,23 @@ const (
 	// version will have a table format version of at least Pebblev1 (Block
 	// Properties).
 	FormatMinTableFormatPebblev1
+	// FormatVirtualSSTables is a format major version that introduces virtual
+	// sstables: tables in the manifest that reference a key range of a
+	// physical sstable shared with other tables. IngestAndExcise requires this
+	// format major version, replacing the sstables straddling the excise span
+	// with virtual sstables.
+	FormatVirtualSSTables
+	// FormatValueSeparation is a format major version that introduces blob
+	// files: flushes and compactions at or above this format major version
+	// may separate large values into blob files, referenced by BLOBSET keys
+	// within sstables and by the blob references of the sstables' manifest
+	// entries. See Options.Experimental.ValueSeparationThreshold.
+	FormatValueSeparation
 	// FormatNewest always contains the most recent format major version.
 	// NB: When adding new versions, the MaxTableFormat method should also be
 	// updated to return the maximum allowable version for the new
 	// FormatMajorVersion.
-	FormatNewest FormatMajorVersion = FormatMinTableFormatPebblev1
+	FormatNewest FormatMajorVersion = FormatValueSeparation
 )
 
 // MaxTableFormat returns the maximum sstable.TableFormat that can be used at
@@ -108,7 +120,8 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 		return sstable.TableFormatRocksDBv2
 	case FormatBlockPropertyCollector, FormatSplitUserKeysMarked, FormatMarkedCompacted:
 		return sstable.TableFormatPebblev1
-	case FormatRangeKeys, FormatMinTableFormatPebblev1:
+	case FormatRangeKeys, FormatMinTableFormatPebblev1, FormatVirtualSSTables,
+		FormatValueSeparation:
 		return sstable.TableFormatPebblev2
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -123,7 +136,7 @@ func (v FormatMajorVersion) MinTableFormat() sstable.TableFormat {
 		FormatVersioned, FormatSetWithDelete, FormatBlockPropertyCollector,
 		FormatSplitUserKeysMarked, FormatMarkedCompacted, FormatRangeKeys:
 		return sstable.TableFormatLevelDB
-	case FormatMinTableFormatPebblev1:
+	case FormatMinTableFormatPebblev1, FormatVirtualSSTables, FormatValueSeparation:
 		return sstable.TableFormatPebblev1
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -229,6 +242,12 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 	FormatMinTableFormatPebblev1: func(d *DB) error {
 		return d.finalizeFormatVersUpgrade(FormatMinTableFormatPebblev1)
 	},
+	FormatVirtualSSTables: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatVirtualSSTables)
+	},
+	FormatValueSeparation: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatValueSeparation)
+	},
 }
 
 const formatVersionMarkerName = `format-version`
@@ -347,7 +366,7 @@ func (d *DB) compactMarkedFilesLocked() error {
 		// compaction.
 		d.maybeScheduleCompactionPicker(func(picker compactionPicker, env compactionEnv) *pickedCompaction {
 			return picker.pickRewriteCompaction(env)
-		})
+		}, true /* force */)
 
 		// The above attempt might succeed and schedule a rewrite compaction. Or
 		// there might not be available compaction concurrency to schedule the
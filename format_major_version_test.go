@@ -13,6 +13,7 @@ import (
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
@@ -45,6 +46,10 @@ func TestRatchetFormat(t *testing.T) {
 	require.Equal(t, FormatRangeKeys, d.FormatMajorVersion())
 	require.NoError(t, d.RatchetFormatMajorVersion(FormatMinTableFormatPebblev1))
 	require.Equal(t, FormatMinTableFormatPebblev1, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatVirtualSSTables))
+	require.Equal(t, FormatVirtualSSTables, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatValueSeparation))
+	require.Equal(t, FormatValueSeparation, d.FormatMajorVersion())
 	require.NoError(t, d.Close())
 
 	// If we Open the database again, leaving the default format, the
@@ -68,6 +73,70 @@ func TestRatchetFormat(t *testing.T) {
 	require.EqualError(t, err, `pebble: database "" written in format major version 999999`)
 }
 
+func TestRatchetFormatOnline(t *testing.T) {
+	fs := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS:                 fs,
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatMostCompatible,
+	})
+	require.NoError(t, err)
+
+	// Write keys into both sstables and the memtable.
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%04d", i)) }
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set(key(i), key(i), nil))
+		if i%25 == 24 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// Ratchet to the newest format while writes continue concurrently.
+	done := make(chan error, 1)
+	go func() {
+		for i := 100; i < 200; i++ {
+			if err := d.Set(key(i), key(i), nil); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatNewest))
+	require.NoError(t, <-done)
+	require.Equal(t, FormatNewest, d.FormatMajorVersion())
+
+	// Ratcheting is idempotent, and downgrades are rejected.
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatNewest))
+	require.Error(t, d.RatchetFormatMajorVersion(FormatMostCompatible))
+	require.Equal(t, FormatNewest, d.FormatMajorVersion())
+
+	// Writes at the new format succeed, including keys requiring it.
+	require.NoError(t, d.RangeKeySet(key(200), key(300), nil, []byte("rk"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact(key(0), key(300), false /* parallelize */))
+	verify := func(d *DB) {
+		iter := d.NewIter(nil)
+		var n int
+		for valid := iter.First(); valid; valid = iter.Next() {
+			require.Equal(t, key(n), iter.Key())
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 200, n)
+		require.NoError(t, d.CheckLevels(nil))
+	}
+	verify(d)
+	require.NoError(t, d.Close())
+
+	// The database reopens at the ratcheted format.
+	d, err = Open("", &Options{FS: fs, Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	require.Equal(t, FormatNewest, d.FormatMajorVersion())
+	verify(d)
+	require.NoError(t, d.Close())
+}
+
 func testBasicDB(d *DB) error {
 	key := []byte("a")
 	value := []byte("b")
@@ -202,6 +271,8 @@ func TestFormatMajorVersions_TableFormat(t *testing.T) {
 		FormatMarkedCompacted:         {sstable.TableFormatLevelDB, sstable.TableFormatPebblev1},
 		FormatRangeKeys:               {sstable.TableFormatLevelDB, sstable.TableFormatPebblev2},
 		FormatMinTableFormatPebblev1:  {sstable.TableFormatPebblev1, sstable.TableFormatPebblev2},
+		FormatVirtualSSTables:         {sstable.TableFormatPebblev1, sstable.TableFormatPebblev2},
+		FormatValueSeparation:         {sstable.TableFormatPebblev1, sstable.TableFormatPebblev2},
 	}
 
 	// Valid versions.
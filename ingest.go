@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"io"
 	"sort"
 	"time"
 
@@ -13,6 +14,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -252,19 +255,52 @@ func ingestSortAndVerify(cmp Compare, meta []*fileMetadata, paths []string) erro
 	return nil
 }
 
-func ingestCleanup(fs vfs.FS, dirname string, meta []*fileMetadata) error {
+func ingestCleanup(objProvider objstorage.Provider, meta []*fileMetadata) error {
 	var firstErr error
 	for i := range meta {
-		target := base.MakeFilepath(fs, dirname, fileTypeTable, meta[i].FileNum)
-		if err := fs.Remove(target); err != nil {
+		if err := objProvider.Remove(meta[i].FileNum); err != nil {
 			firstErr = firstError(firstErr, err)
 		}
 	}
 	return firstErr
 }
 
+// ingestCopyToRemote copies the sstable at path to a new object on the remote
+// storage identified by locator.
+func ingestCopyToRemote(
+	fs vfs.FS,
+	objProvider objstorage.Provider,
+	path string,
+	fileNum FileNum,
+	locator remote.Locator,
+) (_ objstorage.ObjectMetadata, retErr error) {
+	src, err := fs.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return objstorage.ObjectMetadata{}, err
+	}
+	defer func() { retErr = firstError(retErr, src.Close()) }()
+
+	w, objMeta, err := objProvider.Create(fileNum, objstorage.CreateOptions{Locator: locator})
+	if err != nil {
+		return objstorage.ObjectMetadata{}, err
+	}
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Sync()
+	}
+	if err = firstError(err, w.Close()); err != nil {
+		_ = objProvider.Remove(fileNum)
+		return objstorage.ObjectMetadata{}, err
+	}
+	return objMeta, nil
+}
+
 func ingestLink(
-	jobID int, opts *Options, dirname string, paths []string, meta []*fileMetadata,
+	jobID int,
+	opts *Options,
+	objProvider objstorage.Provider,
+	paths []string,
+	meta []*fileMetadata,
 ) error {
 	// Wrap the normal filesystem with one which wraps newly created files with
 	// vfs.NewSyncingFile.
@@ -277,9 +313,18 @@ func ingestLink(
 	}
 
 	for i := range paths {
-		target := base.MakeFilepath(fs, dirname, fileTypeTable, meta[i].FileNum)
+		target := objProvider.Path(objstorage.ObjectMetadata{FileNum: meta[i].FileNum})
 		var err error
-		if _, ok := opts.FS.(*vfs.MemFS); ok && opts.DebugCheck != nil {
+		if locator := opts.Experimental.CreateOnRemote; locator != "" {
+			// Sstables ingested into a DB creating its sstables remotely are
+			// copied to the remote storage.
+			var objMeta objstorage.ObjectMetadata
+			objMeta, err = ingestCopyToRemote(opts.FS, objProvider, paths[i], meta[i].FileNum, locator)
+			if err == nil {
+				meta[i].RemoteLocator = objMeta.Locator
+				target = objProvider.Path(objMeta)
+			}
+		} else if _, ok := opts.FS.(*vfs.MemFS); ok && opts.DebugCheck != nil {
 			// The combination of MemFS+Ingest+DebugCheck produces awkwardness around
 			// the subsequent deletion of files. The problem is that MemFS implements
 			// the Windows semantics of disallowing removal of an open file. This is
@@ -299,7 +344,7 @@ func ingestLink(
 			err = vfs.LinkOrCopy(fs, paths[i], target)
 		}
 		if err != nil {
-			if err2 := ingestCleanup(fs, dirname, meta[:i]); err2 != nil {
+			if err2 := ingestCleanup(objProvider, meta[:i]); err2 != nil {
 				opts.Logger.Infof("ingest cleanup failed: %v", err2)
 			}
 			return err
@@ -503,40 +548,15 @@ func ingestTargetLevel(
 	targetLevel := 0
 
 	// Do we overlap with keys in L0?
-	iter := v.Levels[0].Iter()
-	for meta0 := iter.First(); meta0 != nil; meta0 = iter.Next() {
-		c1 := sstableKeyCompare(cmp, meta.Smallest, meta0.Largest)
-		c2 := sstableKeyCompare(cmp, meta.Largest, meta0.Smallest)
-		if c1 > 0 || c2 < 0 {
-			continue
-		}
-
-		iter, rangeDelIter, err := newIters(iter.Current(), nil, internalIterOpts{})
-		if err != nil {
-			return 0, err
-		}
-		overlap := overlapWithIterator(iter, &rangeDelIter, meta, cmp)
-		iter.Close()
-		if rangeDelIter != nil {
-			rangeDelIter.Close()
-		}
-		if overlap {
-			return targetLevel, nil
-		}
+	if overlap, err := ingestDataOverlaps(newIters, iterOps, cmp, v, 0, meta); err != nil || overlap {
+		return targetLevel, err
 	}
 
 	level := baseLevel
 	for ; level < numLevels; level++ {
-		levelIter := newLevelIter(iterOps, cmp, nil /* split */, newIters,
-			v.Levels[level].Iter(), manifest.Level(level), nil)
-		var rangeDelIter keyspan.FragmentIterator
-		// Pass in a non-nil pointer to rangeDelIter so that levelIter.findFileGE
-		// sets it up for the target file.
-		levelIter.initRangeDel(&rangeDelIter)
-		overlap := overlapWithIterator(levelIter, &rangeDelIter, meta, cmp)
-		levelIter.Close() // Closes range del iter as well.
-		if overlap {
-			return targetLevel, nil
+		overlap, err := ingestDataOverlaps(newIters, iterOps, cmp, v, level, meta)
+		if err != nil || overlap {
+			return targetLevel, err
 		}
 
 		// Check boundary overlap.
@@ -553,22 +573,122 @@ func ingestTargetLevel(
 		// to check since all keys in them will either be from c.startLevel or
 		// c.outputLevel, both levels having their data overlap already tested
 		// negative (else we'd have returned earlier).
-		overlaps := false
-		for c := range compactions {
-			if c.outputLevel == nil || level != c.outputLevel.level {
+		if !ingestCompactionOverlaps(cmp, compactions, level, meta) {
+			targetLevel = level
+		}
+	}
+	return targetLevel, nil
+}
+
+// ingestDataOverlaps returns true if meta overlaps any data within the provided
+// level of v. Note that, as described in ingestTargetLevel, the check is
+// approximate: any existing point that falls within meta's bounds, or any
+// range deletion that overlaps them, counts as overlap.
+func ingestDataOverlaps(
+	newIters tableNewIters,
+	iterOps IterOptions,
+	cmp Compare,
+	v *version,
+	level int,
+	meta *fileMetadata,
+) (bool, error) {
+	if level == 0 {
+		iter := v.Levels[0].Iter()
+		for meta0 := iter.First(); meta0 != nil; meta0 = iter.Next() {
+			c1 := sstableKeyCompare(cmp, meta.Smallest, meta0.Largest)
+			c2 := sstableKeyCompare(cmp, meta.Largest, meta0.Smallest)
+			if c1 > 0 || c2 < 0 {
 				continue
 			}
-			if cmp(meta.Smallest.UserKey, c.largest.UserKey) <= 0 &&
-				cmp(meta.Largest.UserKey, c.smallest.UserKey) >= 0 {
-				overlaps = true
-				break
+
+			iter, rangeDelIter, err := newIters(iter.Current(), nil, internalIterOpts{})
+			if err != nil {
+				return false, err
+			}
+			overlap := overlapWithIterator(iter, &rangeDelIter, meta, cmp)
+			iter.Close()
+			if rangeDelIter != nil {
+				rangeDelIter.Close()
+			}
+			if overlap {
+				return true, nil
 			}
 		}
-		if !overlaps {
-			targetLevel = level
+		return false, nil
+	}
+
+	levelIter := newLevelIter(iterOps, cmp, nil /* split */, newIters,
+		v.Levels[level].Iter(), manifest.Level(level), nil)
+	var rangeDelIter keyspan.FragmentIterator
+	// Pass in a non-nil pointer to rangeDelIter so that levelIter.findFileGE
+	// sets it up for the target file.
+	levelIter.initRangeDel(&rangeDelIter)
+	overlap := overlapWithIterator(levelIter, &rangeDelIter, meta, cmp)
+	levelIter.Close() // Closes range del iter as well.
+	return overlap, nil
+}
+
+// ingestCompactionOverlaps returns true if meta's bounds overlap the bounds of
+// any in-progress compaction outputting to the provided level.
+func ingestCompactionOverlaps(
+	cmp Compare, compactions map[*compaction]struct{}, level int, meta *fileMetadata,
+) bool {
+	for c := range compactions {
+		if c.outputLevel == nil || level != c.outputLevel.level {
+			continue
+		}
+		if cmp(meta.Smallest.UserKey, c.largest.UserKey) <= 0 &&
+			cmp(meta.Largest.UserKey, c.smallest.UserKey) >= 0 {
+			return true
 		}
 	}
-	return targetLevel, nil
+	return false
+}
+
+// ingestForcedTargetLevel returns an ingestTargetLevelFunc that places every
+// sstable into the provided level, returning an error if doing so would
+// violate the LSM invariants. To place meta at level i where i > 0:
+//   - there must not be any data overlap with levels < i, since the ingested
+//     keys are newer than any existing keys and would be shadowed by older
+//     keys in higher levels.
+//   - there must not be any file boundary overlap with level i, or with any
+//     in-progress compaction outputting to level i, since files within level i
+//     must not overlap.
+func ingestForcedTargetLevel(level int) ingestTargetLevelFunc {
+	return func(
+		newIters tableNewIters,
+		iterOps IterOptions,
+		cmp Compare,
+		v *version,
+		baseLevel int,
+		compactions map[*compaction]struct{},
+		meta *fileMetadata,
+	) (int, error) {
+		if level == 0 {
+			return 0, nil
+		}
+		for l := 0; l < level; l++ {
+			overlap, err := ingestDataOverlaps(newIters, iterOps, cmp, v, l, meta)
+			if err != nil {
+				return 0, err
+			}
+			if overlap {
+				return 0, errors.Errorf("pebble: cannot ingest sstable %s into L%d: overlaps data in L%d",
+					meta.FileNum, errors.Safe(level), errors.Safe(l))
+			}
+		}
+		boundaryOverlaps := v.Overlaps(level, cmp, meta.Smallest.UserKey,
+			meta.Largest.UserKey, meta.Largest.IsExclusiveSentinel())
+		if !boundaryOverlaps.Empty() {
+			return 0, errors.Errorf("pebble: cannot ingest sstable %s into L%d: overlaps existing sstables in L%d",
+				meta.FileNum, errors.Safe(level), errors.Safe(level))
+		}
+		if ingestCompactionOverlaps(cmp, compactions, level, meta) {
+			return 0, errors.Errorf("pebble: cannot ingest sstable %s into L%d: overlaps an in-progress compaction into L%d",
+				meta.FileNum, errors.Safe(level), errors.Safe(level))
+		}
+		return level, nil
+	}
 }
 
 // Ingest ingests a set of sstables into the DB. Ingestion of the files is
@@ -620,7 +740,92 @@ func (d *DB) Ingest(paths []string) error {
 	if d.opts.ReadOnly {
 		return ErrReadOnly
 	}
-	_, err := d.ingest(paths, ingestTargetLevel)
+	_, err := d.ingest(paths, ingestTargetLevel, 0 /* seqNum */, nil /* exciseSpan */)
+	return err
+}
+
+// ErrExciseWithOpenSnapshots is returned by IngestAndExcise when a Snapshot is
+// open, as the excise would remove keys visible to the Snapshot.
+var ErrExciseWithOpenSnapshots = errors.New("pebble: cannot excise while snapshots are open")
+
+// IngestAndExcise does the same as Ingest, but additionally removes all keys
+// within exciseSpan that existed prior to the ingestion. The removal and the
+// ingestion are applied atomically, in a single version edit: readers using an
+// iterator acquired before the edit observe the old keys, and readers
+// thereafter observe only the ingested keys within the span. Every ingested
+// sstable must lie within exciseSpan.
+//
+// Memtables containing keys within exciseSpan are flushed before the excise is
+// applied. Sstables lying entirely within exciseSpan are dropped from the LSM,
+// and sstables straddling the bounds of exciseSpan are replaced by virtual
+// sstables exposing only their keys outside of exciseSpan, so no data is
+// rewritten. IngestAndExcise requires a format major version of at least
+// FormatVirtualSSTables.
+//
+// The excise would remove keys visible to an open Snapshot, so
+// IngestAndExcise fails with ErrExciseWithOpenSnapshots while any Snapshot
+// is open, including an EventuallyFileOnlySnapshot that has not yet
+// transitioned to a file-only snapshot.
+func (d *DB) IngestAndExcise(paths []string, exciseSpan KeyRange) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if v := d.FormatMajorVersion(); v < FormatVirtualSSTables {
+		return errors.Errorf("pebble: IngestAndExcise requires format major version %s or higher, found %s",
+			FormatVirtualSSTables, v)
+	}
+	if d.cmp(exciseSpan.Start, exciseSpan.End) >= 0 {
+		return errors.Errorf("pebble: excise span start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(exciseSpan.Start), d.opts.Comparer.FormatKey(exciseSpan.End))
+	}
+	_, err := d.ingest(paths, ingestTargetLevel, 0 /* seqNum */, &exciseSpan)
+	return err
+}
+
+// IngestExternalWithSeqNum does the same as Ingest, but assigns the provided
+// sequence number to the ingested sstables rather than allocating the next
+// sequence number. This allows a replica to reproduce the sequence numbers
+// assigned by the DB it is replicating. The provided sequence number must be
+// greater than the sequence number of every key already written to the DB, or
+// an error is returned and nothing is ingested. Sequence numbers between the
+// last allocated sequence number and seqNum are skipped, and subsequent writes
+// are assigned sequence numbers greater than seqNum.
+func (d *DB) IngestExternalWithSeqNum(paths []string, seqNum uint64) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if seqNum == 0 {
+		return errors.New("pebble: ingest sequence number must be non-zero")
+	}
+	_, err := d.ingest(paths, ingestTargetLevel, seqNum, nil /* exciseSpan */)
+	return err
+}
+
+// IngestInto does the same as Ingest, but places every sstable into the
+// provided level of the LSM rather than choosing the level automatically. This
+// is intended for bulk loads of data that has been pre-sorted by level. An
+// error is returned, and nothing is ingested, if placing an sstable into level
+// would violate the LSM invariants: an sstable may not be placed into a level
+// i > 0 if it overlaps data in any level above i (including memtables, which
+// are flushed to L0 prior to ingestion), or overlaps the bounds of any
+// sstable in level i.
+func (d *DB) IngestInto(paths []string, level int) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if level < 0 || level >= numLevels {
+		return errors.Errorf("pebble: invalid ingest level %d", errors.Safe(level))
+	}
+	_, err := d.ingest(paths, ingestForcedTargetLevel(level), 0 /* seqNum */, nil /* exciseSpan */)
 	return err
 }
 
@@ -646,11 +851,16 @@ func (d *DB) IngestWithStats(paths []string) (IngestOperationStats, error) {
 	if d.opts.ReadOnly {
 		return IngestOperationStats{}, ErrReadOnly
 	}
-	return d.ingest(paths, ingestTargetLevel)
+	return d.ingest(paths, ingestTargetLevel, 0 /* seqNum */, nil /* exciseSpan */)
 }
 
+// ingest ingests the sstables at the provided paths. If seqNum is non-zero,
+// the sstables are assigned seqNum rather than the next sequence number. If
+// exciseSpan is non-nil, all existing keys within it are removed by the same
+// version edit that adds the sstables, and the sstables are placed by
+// ingestExciseTargetLevel rather than targetLevelFunc.
 func (d *DB) ingest(
-	paths []string, targetLevelFunc ingestTargetLevelFunc,
+	paths []string, targetLevelFunc ingestTargetLevelFunc, seqNum uint64, exciseSpan *KeyRange,
 ) (IngestOperationStats, error) {
 	// Allocate file numbers for all of the files being ingested and mark them as
 	// pending in order to prevent them from being deleted. Note that this causes
@@ -672,7 +882,7 @@ func (d *DB) ingest(
 	if err != nil {
 		return IngestOperationStats{}, err
 	}
-	if len(meta) == 0 {
+	if len(meta) == 0 && exciseSpan == nil {
 		// All of the sstables to be ingested were empty. Nothing to do.
 		return IngestOperationStats{}, nil
 	}
@@ -682,12 +892,27 @@ func (d *DB) ingest(
 		return IngestOperationStats{}, err
 	}
 
+	// Verify the sstables lie within the excise span, if any, and construct
+	// bounds for the excise span for use when checking memtable overlap.
+	var exciseMeta []*fileMetadata
+	if exciseSpan != nil {
+		for i, m := range meta {
+			if !exciseSpanContains(d.cmp, *exciseSpan, m.Smallest, m.Largest) {
+				return IngestOperationStats{}, errors.Errorf(
+					"pebble: ingested sstable %s is not contained within the excise span", paths[i])
+			}
+		}
+		exciseMeta = []*fileMetadata{(&fileMetadata{}).ExtendPointKeyBounds(d.cmp,
+			base.MakeInternalKey(exciseSpan.Start, InternalKeySeqNumMax, InternalKeyKindMax),
+			base.MakeRangeDeleteSentinelKey(exciseSpan.End))}
+	}
+
 	// Hard link the sstables into the DB directory. Since the sstables aren't
 	// referenced by a version, they won't be used. If the hard linking fails
 	// (e.g. because the files reside on a different filesystem), ingestLink will
 	// fall back to copying, and if that fails we undo our work and return an
 	// error.
-	if err := ingestLink(jobID, d.opts, d.dirname, paths, meta); err != nil {
+	if err := ingestLink(jobID, d.opts, d.objProvider, paths, meta); err != nil {
 		return IngestOperationStats{}, err
 	}
 	// Fsync the directory we added the tables to. We need to do this at some
@@ -711,13 +936,23 @@ func (d *DB) ingest(
 		// overlaps.
 		for i := len(d.mu.mem.queue) - 1; i >= 0; i-- {
 			m := d.mu.mem.queue[i]
-			if ingestMemtableOverlaps(d.cmp, m, meta) {
+			if ingestMemtableOverlaps(d.cmp, m, meta) ||
+				(exciseMeta != nil && flushableOverlapsKeyRanges(d.cmp, m.flushable, exciseMeta)) {
 				mem = m
 				if mem.flushable == d.mu.mem.mutable {
 					err = d.makeRoomForWrite(nil)
 				}
 				mem.flushForced = true
 				d.maybeScheduleFlush()
+				if exciseSpan != nil && err == nil {
+					// Wait for the flush while still holding d.commit.mu, so that
+					// no keys newer than the ingestion may be flushed before the
+					// excise is applied. Every memtable containing keys older than
+					// the ingestion is flushed before or along with mem.
+					d.mu.Unlock()
+					<-mem.flushed
+					d.mu.Lock()
+				}
 				return
 			}
 		}
@@ -748,13 +983,19 @@ func (d *DB) ingest(
 
 		// Assign the sstables to the correct level in the LSM and apply the
 		// version edit.
-		ve, err = d.ingestApply(jobID, meta, targetLevelFunc)
+		ve, err = d.ingestApply(jobID, meta, targetLevelFunc, exciseSpan, seqNum)
 	}
 
-	d.commit.AllocateSeqNum(len(meta), prepare, apply)
+	if seqNum != 0 {
+		if err2 := d.commit.AllocateSeqNumAt(seqNum, len(meta), prepare, apply); err2 != nil {
+			err = err2
+		}
+	} else {
+		d.commit.AllocateSeqNum(len(meta), prepare, apply)
+	}
 
 	if err != nil {
-		if err2 := ingestCleanup(d.opts.FS, d.dirname, meta); err2 != nil {
+		if err2 := ingestCleanup(d.objProvider, meta); err2 != nil {
 			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
 		}
 	} else {
@@ -766,17 +1007,21 @@ func (d *DB) ingest(
 	}
 
 	info := TableIngestInfo{
-		JobID:        jobID,
-		GlobalSeqNum: meta[0].SmallestSeqNum,
-		Err:          err,
+		JobID: jobID,
+		Err:   err,
+	}
+	if len(meta) > 0 {
+		info.GlobalSeqNum = meta[0].SmallestSeqNum
 	}
 	var stats IngestOperationStats
 	if ve != nil {
+		// NB: The ingested sstables precede any sstables written by an excise
+		// within ve.NewFiles.
 		info.Tables = make([]struct {
 			TableInfo
 			Level int
-		}, len(ve.NewFiles))
-		for i := range ve.NewFiles {
+		}, len(meta))
+		for i := range meta {
 			e := &ve.NewFiles[i]
 			info.Tables[i].Level = e.Level
 			info.Tables[i].TableInfo = e.Meta.TableInfo()
@@ -802,7 +1047,11 @@ type ingestTargetLevelFunc func(
 ) (int, error)
 
 func (d *DB) ingestApply(
-	jobID int, meta []*fileMetadata, findTargetLevel ingestTargetLevelFunc,
+	jobID int,
+	meta []*fileMetadata,
+	findTargetLevel ingestTargetLevelFunc,
+	exciseSpan *KeyRange,
+	seqNum uint64,
 ) (*versionEdit, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -819,9 +1068,34 @@ func (d *DB) ingestApply(
 	// logAndApply unconditionally releases the manifest lock, but any earlier
 	// returns must unlock the manifest.
 	d.mu.versions.logLock()
+	if exciseSpan != nil {
+		// Wait for any compactions overlapping the excise span to complete, as
+		// they may delete or rewrite the sstables the excise would remove. The
+		// manifest lock is released while waiting to allow the compactions to
+		// install their version edits.
+		for d.exciseConflictsWithCompactionLocked(*exciseSpan) {
+			d.mu.versions.logUnlock()
+			d.mu.compact.cond.Wait()
+			d.mu.versions.logLock()
+		}
+	}
 	current := d.mu.versions.currentVersion()
 	baseLevel := d.mu.versions.picker.getBaseLevel()
 	iterOps := IterOptions{logger: d.opts.Logger}
+	if exciseSpan != nil {
+		// An open snapshot would observe the removal of the keys it reads. The
+		// check is made while holding d.mu, which NewSnapshot acquires.
+		if !d.mu.snapshots.empty() {
+			d.mu.versions.logUnlock()
+			return nil, ErrExciseWithOpenSnapshots
+		}
+		ve.DeletedFiles = map[deletedFileEntry]*fileMetadata{}
+		if err := d.excise(ve, current, *exciseSpan, seqNum, metrics); err != nil {
+			d.mu.versions.logUnlock()
+			return nil, err
+		}
+		findTargetLevel = ingestExciseTargetLevel
+	}
 	for i := range meta {
 		// Determine the lowest level in the LSM for which the sstable doesn't
 		// overlap any existing files in the level.
@@ -847,6 +1121,8 @@ func (d *DB) ingestApply(
 	if err := d.mu.versions.logAndApply(jobID, ve, metrics, false /* forceRotation */, func() []compactionInfo {
 		return d.getInProgressCompactionInfoLocked(nil)
 	}); err != nil {
+		// Discard the sstables created by the excise.
+		d.discardExciseOutputsLocked(ve.NewFiles[len(meta):])
 		return nil, err
 	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -24,6 +25,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/kr/pretty"
@@ -286,7 +289,8 @@ func TestIngestLink(t *testing.T) {
 				mem.Remove(paths[i])
 			}
 
-			err := ingestLink(0 /* jobID */, opts, dir, paths, meta)
+			objProvider := objstorage.New(objstorage.Settings{FS: mem, FSDirName: dir})
+			err := ingestLink(0 /* jobID */, opts, objProvider, paths, meta)
 			if i < count {
 				if err == nil {
 					t.Fatalf("expected error, but found success")
@@ -346,7 +350,8 @@ func TestIngestLinkFallback(t *testing.T) {
 	opts.EnsureDefaults()
 
 	meta := []*fileMetadata{{FileNum: 1}}
-	require.NoError(t, ingestLink(0, opts, "", []string{"source"}, meta))
+	objProvider := objstorage.New(objstorage.Settings{FS: opts.FS})
+	require.NoError(t, ingestLink(0, opts, objProvider, []string{"source"}, meta))
 
 	dest, err := mem.Open("000001.sst")
 	require.NoError(t, err)
@@ -1046,6 +1051,241 @@ func TestIngestStats(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestIngestExternalWriter(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS:                 mem,
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	writerOpts := d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat())
+
+	// Keys added out of order are rejected, and the error is sticky.
+	f, err := mem.Create("unordered")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, writerOpts)
+	require.NoError(t, w.Set([]byte("b"), []byte("b")))
+	err = w.Set([]byte("a"), []byte("a"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "keys must be added in strictly increasing order")
+	require.Equal(t, err, w.Delete([]byte("c")))
+	require.Equal(t, err, w.Close())
+	err = w.RangeKeySet([]byte("b"), []byte("c"), nil, nil)
+	require.Error(t, err)
+
+	// Build a table from each kind of key, and ingest it.
+	f, err = mem.Create("ext")
+	require.NoError(t, err)
+	w = sstable.NewWriter(f, writerOpts)
+	require.NoError(t, w.Set([]byte("a"), []byte("va")))
+	require.NoError(t, w.Delete([]byte("b")))
+	require.NoError(t, w.Merge([]byte("c"), []byte("vc")))
+	require.NoError(t, w.DeleteRange([]byte("d"), []byte("f")))
+	require.NoError(t, w.Set([]byte("g"), []byte("vg")))
+	require.NoError(t, w.RangeKeySet([]byte("h"), []byte("j"), []byte("@5"), []byte("vh")))
+	require.NoError(t, w.RangeKeyUnset([]byte("j"), []byte("k"), []byte("@5")))
+	require.NoError(t, w.RangeKeyDelete([]byte("k"), []byte("m")))
+	err = w.RangeKeySet([]byte("a"), []byte("b"), nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "spans must be added in order")
+	require.NoError(t, w.Close())
+
+	meta, err := w.Metadata()
+	require.NoError(t, err)
+	require.True(t, meta.HasPointKeys && meta.HasRangeDelKeys && meta.HasRangeKeys)
+	require.Equal(t, "a", string(meta.SmallestPoint.UserKey))
+	require.Equal(t, "g", string(meta.LargestPoint.UserKey))
+	require.Equal(t, "d", string(meta.SmallestRangeDel.UserKey))
+	require.Equal(t, "f", string(meta.LargestRangeDel.UserKey))
+	require.Equal(t, "h", string(meta.SmallestRangeKey.UserKey))
+	require.Equal(t, "m", string(meta.LargestRangeKey.UserKey))
+	require.Equal(t, uint64(0), meta.SmallestSeqNum)
+	require.Equal(t, uint64(0), meta.LargestSeqNum)
+	require.Equal(t, uint64(8), meta.Properties.NumEntries+meta.Properties.NumRangeKeys())
+
+	// The range deletion deletes keys already in the DB.
+	require.NoError(t, d.Set([]byte("e"), []byte("ve"), nil))
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	var buf bytes.Buffer
+	for valid := iter.First(); valid; valid = iter.Next() {
+		fmt.Fprintf(&buf, "%s:", iter.Key())
+		if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+			fmt.Fprintf(&buf, " %s", iter.Value())
+		}
+		start, end := iter.RangeBounds()
+		for _, rk := range iter.RangeKeys() {
+			fmt.Fprintf(&buf, " [%s-%s) %s=%s", start, end, rk.Suffix, rk.Value)
+		}
+		fmt.Fprintln(&buf)
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, "a: va\nc: vc\ng: vg\nh: [h-j) @5=vh\n", buf.String())
+}
+
+func TestIngestExternalWithSeqNum(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS: mem,
+	})
+	require.NoError(t, err)
+
+	ingest := func(seqNum uint64, kvs ...string) error {
+		t.Helper()
+		f, err := mem.Create("ext")
+		require.NoError(t, err)
+
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		for i := 0; i < len(kvs); i += 2 {
+			require.NoError(t, w.Set([]byte(kvs[i]), []byte(kvs[i+1])))
+		}
+		require.NoError(t, w.Close())
+		return d.IngestExternalWithSeqNum([]string{"ext"}, seqNum)
+	}
+	get := func(key string) string {
+		t.Helper()
+		v, closer, err := d.Get([]byte(key))
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	seqNums := func() []uint64 {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var seqNums []uint64
+		v := d.mu.versions.currentVersion()
+		for level := range v.Levels {
+			iter := v.Levels[level].Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				seqNums = append(seqNums, f.SmallestSeqNum)
+			}
+		}
+		sort.Slice(seqNums, func(i, j int) bool { return seqNums[i] < seqNums[j] })
+		return seqNums
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+
+	// Ingest two overlapping tables at sequence numbers ahead of the next
+	// sequence number. The table with the larger sequence number shadows the
+	// other, and both shadow the flushed memtable.
+	require.NoError(t, ingest(10, "a", "2", "b", "2"))
+	require.NoError(t, ingest(20, "b", "3", "c", "3"))
+	require.Equal(t, []uint64{1, 10, 20}, seqNums())
+	require.Equal(t, "2", get("a"))
+	require.Equal(t, "3", get("b"))
+	require.Equal(t, "3", get("c"))
+
+	// Ingesting at a sequence number that has already been allocated would
+	// violate the sequence number ordering and is rejected.
+	err = ingest(20, "d", "4")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "less than the next sequence number 21")
+	require.Equal(t, []uint64{1, 10, 20}, seqNums())
+	_, _, err = d.Get([]byte("d"))
+	require.Equal(t, ErrNotFound, err)
+	require.Error(t, ingest(0, "d", "4"))
+
+	// Subsequent writes are assigned sequence numbers larger than those of the
+	// ingested tables, and shadow them.
+	require.NoError(t, d.Set([]byte("b"), []byte("5"), nil))
+	require.Equal(t, "5", get("b"))
+	require.EqualValues(t, 22, atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum))
+	require.NoError(t, d.Close())
+
+	// The sequence numbers survive a restart.
+	d, err = Open("", &Options{
+		FS: mem,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "2", get("a"))
+	require.Equal(t, "5", get("b"))
+	require.Equal(t, "3", get("c"))
+	require.Less(t, uint64(21), atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum))
+	require.NoError(t, d.Close())
+}
+
+func TestIngestInto(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS: mem,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	write := func(path string, keys ...string) {
+		t.Helper()
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte(k)))
+		}
+		require.NoError(t, w.Close())
+	}
+	levelFiles := func() map[int]int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		files := make(map[int]int)
+		v := d.mu.versions.currentVersion()
+		for level := range v.Levels {
+			if n := v.Levels[level].Len(); n > 0 {
+				files[level] = n
+			}
+		}
+		return files
+	}
+
+	// Non-overlapping sstables may be forced into L6, and into L5 above them.
+	write("ext1", "a", "b")
+	write("ext2", "c", "d")
+	require.NoError(t, d.IngestInto([]string{"ext1", "ext2"}, 6))
+	require.Equal(t, map[int]int{6: 2}, levelFiles())
+	write("ext3", "e", "f")
+	require.NoError(t, d.IngestInto([]string{"ext3"}, 5))
+	require.Equal(t, map[int]int{5: 1, 6: 2}, levelFiles())
+
+	// An sstable overlapping the bounds of an existing sstable in the target
+	// level is rejected.
+	write("ext4", "b", "bb")
+	err = d.IngestInto([]string{"ext4"}, 6)
+	require.Error(t, err)
+	require.Regexp(t, `cannot ingest sstable \d+ into L6: overlaps existing sstables in L6`, err.Error())
+
+	// An sstable overlapping data in a higher level is rejected, as it would
+	// be shadowed by the older data.
+	write("ext5", "ee", "g")
+	err = d.IngestInto([]string{"ext5"}, 6)
+	require.Error(t, err)
+	require.Regexp(t, `cannot ingest sstable \d+ into L6: overlaps data in L5`, err.Error())
+
+	// A write to the memtable overlapping the ingested sstable is flushed to L0,
+	// which also prevents the sstable from being placed into L6.
+	require.NoError(t, d.Set([]byte("x"), nil, nil))
+	write("ext6", "x", "y")
+	err = d.IngestInto([]string{"ext6"}, 6)
+	require.Error(t, err)
+	require.Regexp(t, `cannot ingest sstable \d+ into L6: overlaps data in L0`, err.Error())
+	require.Equal(t, map[int]int{0: 1, 5: 1, 6: 2}, levelFiles())
+
+	// Ingestion into L0 is always permitted.
+	require.NoError(t, d.IngestInto([]string{"ext6"}, 0))
+	require.Equal(t, map[int]int{0: 2, 5: 1, 6: 2}, levelFiles())
+
+	require.Error(t, d.IngestInto([]string{"ext4"}, numLevels))
+
+	iter := d.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a", "b", "c", "d", "e", "f", "x", "y"}, keys)
+}
+
 func TestIngestFlushQueuedLargeBatch(t *testing.T) {
 	// Verify that ingestion forces a flush of a queued large batch.
 
@@ -1460,7 +1700,7 @@ func TestIngestCleanup(t *testing.T) {
 				toRemove = append(toRemove, &fileMetadata{FileNum: fn})
 			}
 
-			err := ingestCleanup(mem, "", toRemove)
+			err := ingestCleanup(objstorage.New(objstorage.Settings{FS: mem}), toRemove)
 			if tc.wantErr != nil {
 				require.Equal(t, tc.wantErr, err)
 			} else {
@@ -22,6 +22,7 @@ const (
 	InternalKeyKindRangeKeySet     = base.InternalKeyKindRangeKeySet
 	InternalKeyKindRangeKeyUnset   = base.InternalKeyKindRangeKeyUnset
 	InternalKeyKindRangeKeyDelete  = base.InternalKeyKindRangeKeyDelete
+	InternalKeyKindBlobSet         = base.InternalKeyKindBlobSet
 	InternalKeyKindInvalid         = base.InternalKeyKindInvalid
 	InternalKeySeqNumBatch         = base.InternalKeySeqNumBatch
 	InternalKeySeqNumMax           = base.InternalKeySeqNumMax
@@ -31,6 +32,23 @@ const (
 // InternalKey exports the base.InternalKey type.
 type InternalKey = base.InternalKey
 
+// InternalIterator exports the base.InternalIterator interface, the iterator
+// over internal keys implemented by memtables, batches and sstables.
+type InternalIterator = base.InternalIterator
+
+// SeekGEFlags exports the base.SeekGEFlags type.
+type SeekGEFlags = base.SeekGEFlags
+
+// SeekLTFlags exports the base.SeekLTFlags type.
+type SeekLTFlags = base.SeekLTFlags
+
+// SeekGEFlagsNone and SeekLTFlagsNone are the default seek flags, with all
+// flags disabled.
+const (
+	SeekGEFlagsNone = base.SeekGEFlagsNone
+	SeekLTFlagsNone = base.SeekLTFlagsNone
+)
+
 type internalIterator = base.InternalIterator
 
 type internalIteratorWithStats = base.InternalIteratorWithStats
@@ -66,6 +66,17 @@ type Separator func(dst, a, b []byte) []byte
 // key must be valid to pass to Compare.
 type Successor func(dst, a []byte) []byte
 
+// ImmediateSuccessor is invoked with a prefix key, one for which Split(a) ==
+// len(a), and returns the smallest key that is larger than every key with the
+// prefix a, such as every suffixed version of a. The dst parameter may be used
+// to store the returned key, though it is valid to pass nil. The returned key
+// must be valid to pass to Compare.
+//
+// For example, a Comparer that orders keys by prefix and separates the suffix
+// from the prefix with "@" may return "foo\x00" for the prefix "foo", which
+// sorts after "foo@5" but before "foobar".
+type ImmediateSuccessor func(dst, prefix []byte) []byte
+
 // Split returns the length of the prefix of the user key that corresponds to
 // the key portion of an MVCC encoding scheme to enable the use of prefix bloom
 // filters.
@@ -113,6 +124,13 @@ type Comparer struct {
 	Split          Split
 	Successor      Successor
 
+	// ImmediateSuccessor is optional. When provided, it's used to construct
+	// the exclusive end of the key range spanned by a prefix, such as the end
+	// of the range deletion written by Batch.DeletePrefix. When omitted, the
+	// prefix with a 0x00 byte appended is used, which is the immediate
+	// successor of the prefix if the Comparer orders prefixes bytewise.
+	ImmediateSuccessor ImmediateSuccessor
+
 	// Name is the name of the comparer.
 	//
 	// The Level-DB on-disk format stores the comparer name, and opening a
@@ -60,6 +60,15 @@ const (
 	InternalKeyKindRangeKeyUnset InternalKeyKind = 20
 	InternalKeyKindRangeKeySet   InternalKeyKind = 21
 
+	// InternalKeyKindBlobSet keys are SET keys whose value is stored in a blob
+	// file, and whose value within the sstable is a blob.Handle identifying
+	// it. They're written by flushes and compactions when value separation is
+	// enabled, and are only surfaced by the iterators of compactions: other
+	// readers see them as SET keys with their values resolved. Compactions
+	// treat them as SETWITHDEL keys, since whether a DEL or SINGLEDEL lies
+	// beneath a separated value isn't tracked.
+	InternalKeyKindBlobSet InternalKeyKind = 22
+
 	// This maximum value isn't part of the file format. It's unlikely,
 	// but future extensions may increase this value.
 	//
@@ -69,7 +78,7 @@ const (
 	// which sorts 'less than or equal to' any other valid internalKeyKind, when
 	// searching for any kind of internal key formed by a certain user key and
 	// seqNum.
-	InternalKeyKindMax InternalKeyKind = 21
+	InternalKeyKindMax InternalKeyKind = 22
 
 	// InternalKeyZeroSeqnumMaxTrailer is the largest trailer with a
 	// zero sequence number.
@@ -111,6 +120,7 @@ var internalKeyKindNames = []string{
 	InternalKeyKindRangeKeySet:    "RANGEKEYSET",
 	InternalKeyKindRangeKeyUnset:  "RANGEKEYUNSET",
 	InternalKeyKindRangeKeyDelete: "RANGEKEYDEL",
+	InternalKeyKindBlobSet:        "BLOBSET",
 	InternalKeyKindInvalid:        "INVALID",
 }
 
@@ -195,6 +205,7 @@ var kindsMap = map[string]InternalKeyKind{
 	"RANGEKEYSET":   InternalKeyKindRangeKeySet,
 	"RANGEKEYUNSET": InternalKeyKindRangeKeyUnset,
 	"RANGEKEYDEL":   InternalKeyKindRangeKeyDelete,
+	"BLOBSET":       InternalKeyKindBlobSet,
 }
 
 // ParseInternalKey parses the string representation of an internal key. The
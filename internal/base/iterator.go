@@ -4,7 +4,10 @@
 
 package base
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // InternalIterator iterates over a DB's key/value pairs in key order. Unlike
 // the Iterator interface, the returned keys are InternalKeys composed of the
@@ -313,6 +316,14 @@ type InternalIteratorStats struct {
 	BlockBytes uint64
 	// Subset of BlockBytes that were in the block cache.
 	BlockBytesInCache uint64
+	// The count of the loaded blocks included in BlockBytes.
+	BlockCount uint64
+	// Time spent reading the loaded blocks that weren't in the block cache.
+	BlockReadDuration time.Duration
+	// The count of corrupt blocks that were skipped rather than loaded, when
+	// configured to skip them. A block may be counted more than once if it's
+	// encountered repeatedly.
+	SkippedCorruptBlocks uint64
 
 	// The following can repeatedly count the same points if they are iterated
 	// over multiple times. Additionally, they may count a point twice when
@@ -330,16 +341,35 @@ type InternalIteratorStats struct {
 	// can be useful for discovering instances of
 	// https://github.com/cockroachdb/pebble/issues/1070.
 	PointsCoveredByRangeTombstones uint64
+
+	// Stats related to points in sstables whose values are stored separately,
+	// in blob files.
+	SeparatedPointValue struct {
+		// Count is a count of points whose values are stored in blob files.
+		Count uint64
+		// ValueBytes represents the total byte length of the values (in blob
+		// files) of the points corresponding to Count.
+		ValueBytes uint64
+		// ValueBytesFetched is the total byte length of the values (in blob
+		// files) that were actually retrieved.
+		ValueBytesFetched uint64
+	}
 }
 
 // Merge merges the stats in from into the given stats.
 func (s *InternalIteratorStats) Merge(from InternalIteratorStats) {
 	s.BlockBytes += from.BlockBytes
 	s.BlockBytesInCache += from.BlockBytesInCache
+	s.BlockCount += from.BlockCount
+	s.BlockReadDuration += from.BlockReadDuration
+	s.SkippedCorruptBlocks += from.SkippedCorruptBlocks
 	s.KeyBytes += from.KeyBytes
 	s.ValueBytes += from.ValueBytes
 	s.PointCount += from.PointCount
 	s.PointsCoveredByRangeTombstones += from.PointsCoveredByRangeTombstones
+	s.SeparatedPointValue.Count += from.SeparatedPointValue.Count
+	s.SeparatedPointValue.ValueBytes += from.SeparatedPointValue.ValueBytes
+	s.SeparatedPointValue.ValueBytesFetched += from.SeparatedPointValue.ValueBytesFetched
 }
 
 type internalIteratorWithEmptyStats struct {
@@ -6,6 +6,7 @@ package base
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
 	"testing"
@@ -21,17 +22,28 @@ func setRandUint64(v reflect.Value) uint64 {
 
 func TestInternalIteratorStatsMerge(t *testing.T) {
 	var from, to, expected InternalIteratorStats
-	n := reflect.ValueOf(from).NumField()
-	for i := 0; i < n; i++ {
-		switch reflect.ValueOf(from).Type().Field(i).Type.Kind() {
-		case reflect.Uint64:
-			v1 := setRandUint64(reflect.ValueOf(&from).Elem().Field(i))
-			v2 := setRandUint64(reflect.ValueOf(&to).Elem().Field(i))
-			reflect.ValueOf(&expected).Elem().Field(i).SetUint(v1 + v2)
-		default:
-			t.Fatalf("unknown kind %v", reflect.ValueOf(from).Type().Field(i).Type.Kind())
+	var setFields func(from, to, expected reflect.Value)
+	setFields = func(from, to, expected reflect.Value) {
+		for i := 0; i < from.NumField(); i++ {
+			switch from.Type().Field(i).Type.Kind() {
+			case reflect.Uint64:
+				v1 := setRandUint64(from.Field(i))
+				v2 := setRandUint64(to.Field(i))
+				expected.Field(i).SetUint(v1 + v2)
+			case reflect.Int64:
+				v1 := rand.Int63n(math.MaxInt64 / 2)
+				v2 := rand.Int63n(math.MaxInt64 / 2)
+				from.Field(i).SetInt(v1)
+				to.Field(i).SetInt(v2)
+				expected.Field(i).SetInt(v1 + v2)
+			case reflect.Struct:
+				setFields(from.Field(i), to.Field(i), expected.Field(i))
+			default:
+				t.Fatalf("unknown kind %v", from.Type().Field(i).Type.Kind())
+			}
 		}
 	}
+	setFields(reflect.ValueOf(&from).Elem(), reflect.ValueOf(&to).Elem(), reflect.ValueOf(&expected).Elem())
 	to.Merge(from)
 	require.Equal(t, expected, to)
 }
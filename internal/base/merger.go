@@ -85,6 +85,15 @@ type DeletableValueMerger interface {
 //
 // The merge operation is invoked when a merge value is encountered during a
 // read, either during a compaction or during iteration.
+//
+// There is no separate partial merge operation. Operands are passed to the
+// ValueMerger one at a time as they're encountered, so a ValueMerger that folds
+// each operand into its state immediately merges an arbitrary number of
+// operands in bounded memory. Flushes and compactions merge runs of operands
+// that are not separated by a snapshot, calling Finish with includesBase set
+// to false unless the oldest operand is known to be included. Iteration always
+// observes every visible operand and calls Finish with includesBase set to
+// true.
 type Merger struct {
 	Merge Merge
 
@@ -95,6 +104,32 @@ type Merger struct {
 	Name string
 }
 
+// RangeKeyMerge combines the values of two overlapping RangeKeySets with the
+// same suffix into a single value. The newer value belongs to the RangeKeySet
+// with the higher sequence number. The caller retains ownership of newer and
+// older, so the returned value must not alias either of them.
+type RangeKeyMerge func(suffix, newer, older []byte) ([]byte, error)
+
+// RangeKeyMerger defines an associative merge operation for the values of
+// range keys. When configured, overlapping RangeKeySets with the same suffix
+// are merged over the intersection of their spans rather than the newer
+// RangeKeySet shadowing the older one. A RangeKeyUnset or RangeKeyDelete still
+// shadows all older RangeKeySets it applies to, and the values of RangeKeySets
+// on either side of it are never merged.
+//
+// The merge operation is invoked whenever range keys are coalesced, both
+// during iteration and during flushes and compactions. A compaction may merge
+// a subset of the RangeKeySets for a suffix, so the merge operation must be
+// associative:
+//
+//   Merge(s, A, Merge(s, B, C)) == Merge(s, Merge(s, A, B), C)
+type RangeKeyMerger struct {
+	Merge RangeKeyMerge
+
+	// Name is the name of the range key merger.
+	Name string
+}
+
 // AppendValueMerger concatenates merge operands in order from oldest to newest.
 type AppendValueMerger struct {
 	buf []byte
@@ -0,0 +1,211 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package blob implements blob files, which hold the values separated from
+// the sstables of a DB. A blob file is a sequence of values, each followed by
+// a 4-byte checksum, terminated by a footer holding a magic number:
+//
+//	+---------+----------+---------+----------+-----+--------+
+//	| value 0 | checksum | value 1 | checksum | ... | footer |
+//	+---------+----------+---------+----------+-----+--------+
+//
+// Values are identified by Handles, which sstables store in place of the
+// values.
+package blob // import "github.com/cockroachdb/pebble/internal/blob"
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	checksumLen = 4
+	magic       = "\xf0\x9f\xab\x99blob"
+	footerLen   = 8
+)
+
+// Handle identifies a value within a blob file.
+type Handle struct {
+	FileNum base.FileNum
+	Offset  uint64
+	Length  uint64
+}
+
+// Encode appends the encoding of the handle to dst, returning the result.
+func (h Handle) Encode(dst []byte) []byte {
+	var buf [3 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(h.FileNum))
+	n += binary.PutUvarint(buf[n:], h.Offset)
+	n += binary.PutUvarint(buf[n:], h.Length)
+	return append(dst, buf[:n]...)
+}
+
+// DecodeHandle decodes a handle encoded by Handle.Encode.
+func DecodeHandle(src []byte) (Handle, error) {
+	var h Handle
+	var fields [3]uint64
+	for i := range fields {
+		v, n := binary.Uvarint(src)
+		if n <= 0 {
+			return Handle{}, base.CorruptionErrorf("pebble: invalid blob handle")
+		}
+		fields[i] = v
+		src = src[n:]
+	}
+	if len(src) != 0 {
+		return Handle{}, base.CorruptionErrorf("pebble: invalid blob handle")
+	}
+	h.FileNum, h.Offset, h.Length = base.FileNum(fields[0]), fields[1], fields[2]
+	return h, nil
+}
+
+type writeCloseSyncer interface {
+	io.WriteCloser
+	Sync() error
+}
+
+// Writer writes the values of a blob file.
+type Writer struct {
+	f       writeCloseSyncer
+	fileNum base.FileNum
+	offset  uint64
+	err     error
+}
+
+// NewWriter returns a Writer writing the blob file with the given file number
+// to f.
+func NewWriter(f writeCloseSyncer, fileNum base.FileNum) *Writer {
+	return &Writer{f: f, fileNum: fileNum}
+}
+
+// Add appends a value to the blob file, returning its handle.
+func (w *Writer) Add(value []byte) (Handle, error) {
+	if w.err != nil {
+		return Handle{}, w.err
+	}
+	h := Handle{FileNum: w.fileNum, Offset: w.offset, Length: uint64(len(value))}
+	var checksum [checksumLen]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc.New(value).Value())
+	if _, err := w.f.Write(value); err != nil {
+		w.err = err
+		return Handle{}, err
+	}
+	if _, err := w.f.Write(checksum[:]); err != nil {
+		w.err = err
+		return Handle{}, err
+	}
+	w.offset += uint64(len(value)) + checksumLen
+	return h, nil
+}
+
+// Size returns the number of bytes written to the blob file, including the
+// footer once the Writer is closed.
+func (w *Writer) Size() uint64 {
+	return w.offset
+}
+
+// Close writes the footer of the blob file, and syncs and closes it.
+func (w *Writer) Close() error {
+	if w.err == nil {
+		if _, err := io.WriteString(w.f, magic); err != nil {
+			w.err = err
+		} else {
+			w.offset += footerLen
+		}
+	}
+	if w.err == nil {
+		w.err = w.f.Sync()
+	}
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	// Prevent further use of the Writer.
+	w.err = errors.New("pebble: blob writer is closed")
+	return nil
+}
+
+// Reader reads the values of a blob file. It's safe for concurrent use.
+type Reader struct {
+	f       vfs.File
+	fileNum base.FileNum
+	size    uint64
+}
+
+// NewReader returns a Reader for the blob file with the given file number,
+// read from f. The Reader takes ownership of f, which is closed when the
+// Reader is closed.
+func NewReader(f vfs.File, fileNum base.FileNum) (*Reader, error) {
+	r := &Reader{f: f, fileNum: fileNum}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, firstError(err, f.Close())
+	}
+	var footer [footerLen]byte
+	if stat.Size() < int64(footerLen) {
+		return nil, firstError(
+			base.CorruptionErrorf("pebble: blob file %s is too short", fileNum), f.Close())
+	}
+	r.size = uint64(stat.Size())
+	if _, err := f.ReadAt(footer[:], stat.Size()-int64(footerLen)); err != nil {
+		return nil, firstError(err, f.Close())
+	}
+	if string(footer[:]) != magic {
+		return nil, firstError(
+			base.CorruptionErrorf("pebble: blob file %s has an invalid footer", fileNum), f.Close())
+	}
+	return r, nil
+}
+
+// ReadValue reads the value identified by the handle. The value is read into
+// buf if it has sufficient capacity, and into a newly allocated slice
+// otherwise.
+func (r *Reader) ReadValue(h Handle, buf []byte) ([]byte, error) {
+	if h.FileNum != r.fileNum {
+		return nil, errors.AssertionFailedf("pebble: blob handle for %s read from blob file %s",
+			h.FileNum, r.fileNum)
+	}
+	// Check that the value and its checksum lie within the file's data,
+	// without overflowing on a corrupt handle.
+	dataLen := r.size - uint64(footerLen)
+	if dataLen < checksumLen || h.Length > dataLen-checksumLen ||
+		h.Offset > dataLen-checksumLen-h.Length {
+		return nil, base.CorruptionErrorf(
+			"pebble: blob handle (offset %d, length %d) beyond the end of blob file %s",
+			errors.Safe(h.Offset), errors.Safe(h.Length), r.fileNum)
+	}
+	if n := h.Length + checksumLen; uint64(cap(buf)) >= n {
+		buf = buf[:n]
+	} else {
+		buf = make([]byte, n)
+	}
+	if _, err := r.f.ReadAt(buf, int64(h.Offset)); err != nil {
+		return nil, err
+	}
+	value := buf[:h.Length]
+	if crc.New(value).Value() != binary.LittleEndian.Uint32(buf[h.Length:]) {
+		return nil, base.CorruptionErrorf("pebble: checksum mismatch in blob file %s at offset %d",
+			r.fileNum, errors.Safe(h.Offset))
+	}
+	return value, nil
+}
+
+// Close closes the blob file.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+func firstError(err0, err1 error) error {
+	if err0 != nil {
+		return err0
+	}
+	return err1
+}
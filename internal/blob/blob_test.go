@@ -0,0 +1,114 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blob
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobFile(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("000007.blob")
+	require.NoError(t, err)
+	w := NewWriter(f, 7)
+
+	var values [][]byte
+	var handles []Handle
+	for i := 0; i < 100; i++ {
+		v := bytes.Repeat([]byte(fmt.Sprint(i)), i)
+		h, err := w.Add(v)
+		require.NoError(t, err)
+		values = append(values, v)
+		handles = append(handles, h)
+	}
+	require.NoError(t, w.Close())
+	_, err = w.Add([]byte("foo"))
+	require.Error(t, err)
+	stat, err := mem.Stat("000007.blob")
+	require.NoError(t, err)
+	require.Equal(t, uint64(stat.Size()), w.Size())
+
+	f, err = mem.Open("000007.blob")
+	require.NoError(t, err)
+	r, err := NewReader(f, 7)
+	require.NoError(t, err)
+	var buf []byte
+	for i, h := range handles {
+		decoded, err := DecodeHandle(h.Encode(nil))
+		require.NoError(t, err)
+		require.Equal(t, h, decoded)
+		v, err := r.ReadValue(decoded, nil)
+		require.NoError(t, err)
+		require.Equal(t, values[i], v)
+
+		// A buffer with sufficient capacity is reused.
+		buf = make([]byte, 0, len(v)+64)
+		v, err = r.ReadValue(decoded, buf)
+		require.NoError(t, err)
+		require.Equal(t, values[i], v)
+		require.Equal(t, &buf[:1][0], &v[:1][0])
+	}
+
+	// A handle beyond the end of the file is rejected.
+	_, err = r.ReadValue(Handle{FileNum: 7, Offset: w.Size(), Length: 1}, nil)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	// Including one whose end overflows.
+	for _, h := range []Handle{
+		{FileNum: 7, Offset: 1, Length: math.MaxUint64},
+		{FileNum: 7, Offset: math.MaxUint64, Length: 1},
+		{FileNum: 7, Offset: math.MaxUint64 - 2, Length: 0},
+	} {
+		_, err = r.ReadValue(h, nil)
+		require.True(t, errors.Is(err, base.ErrCorruption), "%+v", h)
+	}
+	require.NoError(t, r.Close())
+
+	// As is a handle whose value doesn't match its checksum.
+	f, err = mem.Open("000007.blob")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	h := handles[len(handles)-1]
+	data[h.Offset] ^= 0xff
+	f, err = mem.Create("000007.blob")
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = mem.Open("000007.blob")
+	require.NoError(t, err)
+	r, err = NewReader(f, 7)
+	require.NoError(t, err)
+	_, err = r.ReadValue(h, nil)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.NoError(t, r.Close())
+}
+
+func TestBlobFileIncomplete(t *testing.T) {
+	// A blob file without a footer, such as one left behind by a crash, is
+	// rejected when opened.
+	mem := vfs.NewMem()
+	f, err := mem.Create("000001.blob")
+	require.NoError(t, err)
+	w := NewWriter(f, 1)
+	_, err = w.Add([]byte("value"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = mem.Open("000001.blob")
+	require.NoError(t, err)
+	_, err = NewReader(f, 1)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+}
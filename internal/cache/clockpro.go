@@ -77,8 +77,9 @@ func (h Handle) Release() {
 }
 
 type shard struct {
-	hits   int64
-	misses int64
+	// Hits and misses, indexed by BlockType.
+	hits   [numBlockTypes]int64
+	misses [numBlockTypes]int64
 
 	mu sync.RWMutex
 
@@ -103,6 +104,16 @@ type shard struct {
 	sizeCold int64
 	sizeTest int64
 
+	// Pinned entries are kept in their own list, out of reach of the clock
+	// hands. Their size is excluded from the target size of the clock.
+	handPinned  *entry
+	sizePinned  int64
+	countPinned int64
+
+	// sizeByID holds the size of the resident (hot, cold and pinned) entries
+	// cached under each ID. IDs without resident entries are absent.
+	sizeByID map[uint64]int64
+
 	// The count fields are used exclusively for asserting expectations.
 	// We've seen infinite looping (cockroachdb/cockroach#70154) that
 	// could be explained by a corrupted sizeCold. Through asserting on
@@ -113,7 +124,7 @@ type shard struct {
 	countTest int64
 }
 
-func (c *shard) Get(id uint64, fileNum base.FileNum, offset uint64) Handle {
+func (c *shard) Get(id uint64, fileNum base.FileNum, offset uint64, typ BlockType) Handle {
 	c.mu.RLock()
 	var value *Value
 	if e := c.blocks.Get(key{fileKey{id, fileNum}, offset}); e != nil {
@@ -124,10 +135,10 @@ func (c *shard) Get(id uint64, fileNum base.FileNum, offset uint64) Handle {
 	}
 	c.mu.RUnlock()
 	if value == nil {
-		atomic.AddInt64(&c.misses, 1)
+		atomic.AddInt64(&c.misses[typ], 1)
 		return Handle{}
 	}
-	atomic.AddInt64(&c.hits, 1)
+	atomic.AddInt64(&c.hits[typ], 1)
 	return Handle{value: value}
 }
 
@@ -151,6 +162,7 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 			value.ref.trace("add-cold")
 			c.sizeCold += e.size
 			c.countCold++
+			c.addIDSize(id, e.size)
 		} else {
 			value.ref.trace("skip-cold")
 			e.free()
@@ -163,13 +175,18 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 		atomic.StoreInt32(&e.referenced, 1)
 		delta := int64(len(value.buf)) - e.size
 		e.size = int64(len(value.buf))
-		if e.ptype == etHot {
+		switch e.ptype {
+		case etHot:
 			value.ref.trace("add-hot")
 			c.sizeHot += delta
-		} else {
+		case etPinned:
+			value.ref.trace("add-pinned")
+			c.sizePinned += delta
+		default:
 			value.ref.trace("add-cold")
 			c.sizeCold += delta
 		}
+		c.addIDSize(id, delta)
 		c.evict()
 
 	default:
@@ -192,6 +209,7 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 			value.ref.trace("add-hot")
 			c.sizeHot += e.size
 			c.countHot++
+			c.addIDSize(id, e.size)
 		} else {
 			value.ref.trace("skip-hot")
 			e.free()
@@ -218,6 +236,10 @@ func (c *shard) checkConsistency() {
 		panic(fmt.Sprintf("pebble: mismatch %d cold size, %d cold count", c.sizeCold, c.countCold))
 	case c.sizeTest > 0 && c.countTest == 0:
 		panic(fmt.Sprintf("pebble: mismatch %d test size, %d test count", c.sizeTest, c.countTest))
+	case c.sizePinned < 0 || c.countPinned < 0:
+		panic(fmt.Sprintf("pebble: unexpected negative: %d (%d bytes) pinned", c.countPinned, c.sizePinned))
+	case c.sizePinned > 0 && c.countPinned == 0:
+		panic(fmt.Sprintf("pebble: mismatch %d pinned size, %d pinned count", c.sizePinned, c.countPinned))
 	}
 }
 
@@ -245,6 +267,45 @@ func (c *shard) Delete(id uint64, fileNum base.FileNum, offset uint64) {
 	c.checkConsistency()
 }
 
+// Pin exempts the cached value for the specified file and offset from
+// eviction, returning false if no value is present.
+func (c *shard) Pin(id uint64, fileNum base.FileNum, offset uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.blocks.Get(key{fileKey{id, fileNum}, offset})
+	if e == nil || e.peekValue() == nil {
+		return false
+	}
+	switch e.ptype {
+	case etPinned:
+		return true
+	case etHot:
+		c.sizeHot -= e.size
+		c.countHot--
+	case etCold:
+		c.sizeCold -= e.size
+		c.countCold--
+	}
+	c.unlinkBlock(e)
+	e.ptype = etPinned
+	c.sizePinned += e.size
+	c.countPinned++
+	if c.handPinned == nil {
+		c.handPinned = e
+	} else {
+		c.handPinned.link(e)
+	}
+
+	// Pinning the entry reduces the target size of the clock.
+	if targetSize := c.targetSize(); c.coldTarget > targetSize {
+		c.coldTarget = targetSize
+	}
+	c.evict()
+	c.checkConsistency()
+	return true
+}
+
 // EvictFile evicts all of the cache values for the specified file.
 func (c *shard) EvictFile(id uint64, fileNum base.FileNum) {
 	c.mu.Lock()
@@ -277,9 +338,31 @@ func (c *shard) Free() {
 		c.metaDel(c.handHot)
 		e.free()
 	}
+	for c.handPinned != nil {
+		e := c.handPinned
+		c.metaDel(c.handPinned)
+		e.free()
+	}
 
 	c.blocks.free()
 	c.files.free()
+	c.sizeByID = nil
+}
+
+// addIDSize adjusts the size of the resident entries cached under the
+// specified ID by delta.
+func (c *shard) addIDSize(id uint64, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if c.sizeByID == nil {
+		c.sizeByID = make(map[uint64]int64)
+	}
+	if n := c.sizeByID[id] + delta; n != 0 {
+		c.sizeByID[id] = n
+	} else {
+		delete(c.sizeByID, id)
+	}
 }
 
 func (c *shard) Reserve(n int) {
@@ -303,13 +386,13 @@ func (c *shard) Reserve(n int) {
 // Size returns the current space used by the cache.
 func (c *shard) Size() int64 {
 	c.mu.RLock()
-	size := c.sizeHot + c.sizeCold
+	size := c.sizeHot + c.sizeCold + c.sizePinned
 	c.mu.RUnlock()
 	return size
 }
 
 func (c *shard) targetSize() int64 {
-	target := c.maxSize - c.reservedSize
+	target := c.maxSize - c.reservedSize - c.sizePinned
 	// Always return a positive integer for targetSize. This is so that we don't
 	// end up in an infinite loop in evict(), in cases where reservedSize is
 	// greater than or equal to maxSize.
@@ -373,6 +456,30 @@ func (c *shard) metaDel(e *entry) {
 		delete(c.entries, e)
 	}
 
+	c.unlinkBlock(e)
+
+	fkey := e.key.file()
+	if next := e.unlinkFile(); e == next {
+		c.files.Delete(fkey)
+	} else {
+		c.files.Put(fkey, next)
+	}
+}
+
+// Unlink the entry from the list of blocks it belongs to, ensuring that
+// hand{Hot,Cold,Test,Pinned} are not pointing at the entry.
+func (c *shard) unlinkBlock(e *entry) {
+	if e.ptype == etPinned {
+		if e == c.handPinned {
+			c.handPinned = c.handPinned.prev()
+		}
+		if e.unlink() == e {
+			// This was the last pinned entry.
+			c.handPinned = nil
+		}
+		return
+	}
+
 	if e == c.handHot {
 		c.handHot = c.handHot.prev()
 	}
@@ -384,18 +491,11 @@ func (c *shard) metaDel(e *entry) {
 	}
 
 	if e.unlink() == e {
-		// This was the last entry in the cache.
+		// This was the last entry in the clock.
 		c.handHot = nil
 		c.handCold = nil
 		c.handTest = nil
 	}
-
-	fkey := e.key.file()
-	if next := e.unlinkFile(); e == next {
-		c.files.Delete(fkey)
-	} else {
-		c.files.Put(fkey, next)
-	}
 }
 
 // Check that the specified entry is not referenced by the cache.
@@ -466,6 +566,12 @@ func (c *shard) metaEvict(e *entry) {
 	case etTest:
 		c.sizeTest -= e.size
 		c.countTest--
+	case etPinned:
+		c.sizePinned -= e.size
+		c.countPinned--
+	}
+	if e.ptype != etTest {
+		c.addIDSize(e.key.id, -e.size)
 	}
 	c.metaDel(e)
 	c.metaCheck(e)
@@ -503,6 +609,7 @@ func (c *shard) runHandCold(countColdDebug, sizeColdDebug int64) {
 			e.ptype = etTest
 			c.sizeCold -= e.size
 			c.countCold--
+			c.addIDSize(e.key.id, -e.size)
 			c.sizeTest += e.size
 			c.countTest++
 			for c.targetSize() < c.sizeTest && c.handTest != nil {
@@ -572,6 +679,49 @@ func (c *shard) runHandTest() {
 	c.handTest = c.handTest.next()
 }
 
+// BlockType identifies the kind of sstable block looked up in the cache. It is
+// used only to attribute cache hits and misses.
+type BlockType uint8
+
+// The block types. BlockTypeUnknown is used for lookups that don't identify
+// the kind of block.
+const (
+	BlockTypeUnknown BlockType = iota
+	BlockTypeData
+	BlockTypeIndex
+	BlockTypeFilter
+	BlockTypeRangeDel
+	BlockTypeRangeKey
+	BlockTypeProperties
+	BlockTypeMeta
+	numBlockTypes
+)
+
+var blockTypeNames = [numBlockTypes]string{
+	BlockTypeUnknown:    "unknown",
+	BlockTypeData:       "data",
+	BlockTypeIndex:      "index",
+	BlockTypeFilter:     "filter",
+	BlockTypeRangeDel:   "range-del",
+	BlockTypeRangeKey:   "range-key",
+	BlockTypeProperties: "properties",
+	BlockTypeMeta:       "meta",
+}
+
+// String implements fmt.Stringer.
+func (t BlockType) String() string {
+	if t < numBlockTypes {
+		return blockTypeNames[t]
+	}
+	return fmt.Sprintf("BlockType(%d)", t)
+}
+
+// HitMiss holds the number of cache hits and misses.
+type HitMiss struct {
+	Hits   int64
+	Misses int64
+}
+
 // Metrics holds metrics for the cache.
 type Metrics struct {
 	// The number of bytes inuse by the cache.
@@ -582,6 +732,17 @@ type Metrics struct {
 	Hits int64
 	// The number of cache misses.
 	Misses int64
+	// The number of bytes in use by pinned values, which are exempt from
+	// eviction. PinnedSize is included in Size.
+	PinnedSize int64
+	// ByType breaks down Hits and Misses by block type, keyed by the
+	// BlockType's string. Lookups of an unknown block type are only included
+	// if there are any.
+	ByType map[string]HitMiss
+	// SizeByID breaks down Size by the ID under which values are cached,
+	// attributing the usage of a cache shared by multiple DBs to each of them.
+	// IDs without any values in the cache are omitted.
+	SizeByID map[uint64]int64
 }
 
 // Cache implements Pebble's sharded block cache. The Clock-PRO algorithm is
@@ -739,7 +900,22 @@ func (c *Cache) Unref() {
 // Get retrieves the cache value for the specified file and offset, returning
 // nil if no value is present.
 func (c *Cache) Get(id uint64, fileNum base.FileNum, offset uint64) Handle {
-	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset)
+	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset, BlockTypeUnknown)
+}
+
+// GetBlock is like Get, but attributes the hit or miss to the provided block
+// type in Metrics.ByType.
+func (c *Cache) GetBlock(id uint64, fileNum base.FileNum, offset uint64, typ BlockType) Handle {
+	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset, typ)
+}
+
+// Pin exempts the cached value for the specified file and offset from
+// eviction, returning false if no value is present. A pinned value remains in
+// the cache until it is removed by Delete or EvictFile. Pinned values count
+// against the capacity of the cache, and values that are not pinned are
+// evicted to make room for them.
+func (c *Cache) Pin(id uint64, fileNum base.FileNum, offset uint64) bool {
+	return c.getShard(id, fileNum, offset).Pin(id, fileNum, offset)
 }
 
 // Set sets the cache value for the specified file and offset, overwriting an
@@ -798,6 +974,17 @@ func (c *Cache) Free(v *Value) {
 	v.release()
 }
 
+// NewUncachedHandle returns a handle for a value allocated by Alloc that has
+// not been added to the cache. Releasing the handle frees the value. This
+// allows a caller to hand out a value through the same interface as cached
+// values without polluting the cache.
+func (c *Cache) NewUncachedHandle(v *Value) Handle {
+	if n := v.refs(); n > 1 {
+		panic(fmt.Sprintf("pebble: Value has been added to the cache: refs=%d", n))
+	}
+	return Handle{value: v}
+}
+
 // Reserve N bytes in the cache. This effectively shrinks the size of the cache
 // by N bytes, without actually consuming any memory. The returned closure
 // should be invoked to release the reservation.
@@ -822,14 +1009,33 @@ func (c *Cache) Reserve(n int) func() {
 // Metrics returns the metrics for the cache.
 func (c *Cache) Metrics() Metrics {
 	var m Metrics
+	var byType [numBlockTypes]HitMiss
 	for i := range c.shards {
 		s := &c.shards[i]
 		s.mu.RLock()
 		m.Count += int64(s.blocks.Count())
-		m.Size += s.sizeHot + s.sizeCold
+		m.Size += s.sizeHot + s.sizeCold + s.sizePinned
+		m.PinnedSize += s.sizePinned
+		for id, size := range s.sizeByID {
+			if m.SizeByID == nil {
+				m.SizeByID = make(map[uint64]int64)
+			}
+			m.SizeByID[id] += size
+		}
 		s.mu.RUnlock()
-		m.Hits += atomic.LoadInt64(&s.hits)
-		m.Misses += atomic.LoadInt64(&s.misses)
+		for t := range byType {
+			byType[t].Hits += atomic.LoadInt64(&s.hits[t])
+			byType[t].Misses += atomic.LoadInt64(&s.misses[t])
+		}
+	}
+	m.ByType = make(map[string]HitMiss, len(byType))
+	for t, hm := range byType {
+		m.Hits += hm.Hits
+		m.Misses += hm.Misses
+		if BlockType(t) == BlockTypeUnknown && hm == (HitMiss{}) {
+			continue
+		}
+		m.ByType[BlockType(t).String()] = hm
 	}
 	return m
 }
@@ -96,6 +96,85 @@ func TestCacheDelete(t *testing.T) {
 	}
 }
 
+func TestCacheMetricsByType(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	cache.Set(1, 0, 0, testValue(cache, "a", 5)).Release()
+	cache.GetBlock(1, 0, 0, BlockTypeData).Release()
+	cache.GetBlock(1, 0, 0, BlockTypeData).Release()
+	cache.GetBlock(1, 1, 0, BlockTypeData).Release()
+	cache.GetBlock(1, 0, 0, BlockTypeIndex).Release()
+	cache.GetBlock(1, 2, 0, BlockTypeFilter).Release()
+
+	m := cache.Metrics()
+	require.Equal(t, int64(3), m.Hits)
+	require.Equal(t, int64(2), m.Misses)
+	require.Equal(t, map[string]HitMiss{
+		"data":       {Hits: 2, Misses: 1},
+		"index":      {Hits: 1},
+		"filter":     {Misses: 1},
+		"range-del":  {},
+		"range-key":  {},
+		"properties": {},
+		"meta":       {},
+	}, m.ByType)
+
+	// Lookups that don't identify a block type are only reported once they
+	// occur.
+	cache.Get(1, 3, 0).Release()
+	m = cache.Metrics()
+	require.Equal(t, int64(3), m.Misses)
+	require.Equal(t, HitMiss{Misses: 1}, m.ByType["unknown"])
+}
+
+func TestCachePin(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	// Pinning a missing value fails.
+	require.False(t, cache.Pin(1, 0, 0))
+
+	cache.Set(1, 0, 0, testValue(cache, "p", 10)).Release()
+	cache.Set(1, 0, 10, testValue(cache, "p", 10)).Release()
+	require.True(t, cache.Pin(1, 0, 0))
+	require.True(t, cache.Pin(1, 0, 0))
+	require.True(t, cache.Pin(1, 0, 10))
+	require.Equal(t, int64(20), cache.Metrics().PinnedSize)
+
+	// Fill the cache several times over with unpinned values. The pinned values
+	// remain resident while the unpinned values are evicted to stay within the
+	// capacity of the cache.
+	for i := 1; i <= 100; i++ {
+		cache.Set(1, base.FileNum(i), 0, testValue(cache, "d", 10)).Release()
+		require.LessOrEqual(t, cache.Size(), int64(100))
+	}
+	for _, offset := range []uint64{0, 10} {
+		h := cache.Get(1, 0, offset)
+		require.Equal(t, "pppppppppp", string(h.Get()))
+		h.Release()
+	}
+
+	// Replacing a pinned value keeps it pinned.
+	cache.Set(1, 0, 10, testValue(cache, "q", 5)).Release()
+	require.Equal(t, int64(15), cache.Metrics().PinnedSize)
+
+	// Pinned values are removed by Delete and EvictFile.
+	cache.Delete(1, 0, 10)
+	require.Equal(t, int64(10), cache.Metrics().PinnedSize)
+	cache.EvictFile(1, 0)
+	require.Nil(t, cache.Get(1, 0, 0).Get())
+	m := cache.Metrics()
+	require.Equal(t, int64(0), m.PinnedSize)
+	require.Equal(t, m.Size, cache.Size())
+
+	// With the pinned values gone, the full capacity is available again.
+	for i := 1; i <= 10; i++ {
+		cache.Set(1, base.FileNum(i), 0, testValue(cache, "d", 10)).Release()
+	}
+	require.Greater(t, cache.Size(), int64(80))
+}
+
 func TestEvictFile(t *testing.T) {
 	cache := newShards(100, 1)
 	defer cache.Unref()
@@ -138,13 +217,16 @@ func TestMultipleDBs(t *testing.T) {
 
 	cache.Set(1, 0, 0, testValue(cache, "a", 5)).Release()
 	cache.Set(2, 0, 0, testValue(cache, "b", 5)).Release()
-	if expected, size := int64(10), cache.Size(); expected != size {
+	cache.Set(2, 0, 5, testValue(cache, "b", 3)).Release()
+	if expected, size := int64(13), cache.Size(); expected != size {
 		t.Fatalf("expected cache size %d, but found %d", expected, size)
 	}
+	require.Equal(t, map[uint64]int64{1: 5, 2: 8}, cache.Metrics().SizeByID)
 	cache.EvictFile(1, 0)
-	if expected, size := int64(5), cache.Size(); expected != size {
+	if expected, size := int64(8), cache.Size(); expected != size {
 		t.Fatalf("expected cache size %d, but found %d", expected, size)
 	}
+	require.Equal(t, map[uint64]int64{2: 8}, cache.Metrics().SizeByID)
 	h := cache.Get(1, 0, 0)
 	if v := h.Get(); v != nil {
 		t.Fatalf("expected not present, but found %s", v)
@@ -10,6 +10,9 @@ const (
 	etTest entryType = iota
 	etCold
 	etHot
+	// etPinned entries are exempt from eviction. They are linked into the
+	// shard's list of pinned entries rather than the clock.
+	etPinned
 )
 
 func (p entryType) String() string {
@@ -20,6 +23,8 @@ func (p entryType) String() string {
 		return "cold"
 	case etHot:
 		return "hot"
+	case etPinned:
+		return "pinned"
 	}
 	return "unknown"
 }
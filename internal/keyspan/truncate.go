@@ -7,8 +7,9 @@ package keyspan
 import "github.com/cockroachdb/pebble/internal/base"
 
 // Truncate creates a new iterator where every span in the supplied iterator is
-// truncated to be contained within the range [lower, upper). If start and end
-// are specified, filter out any spans that are completely outside those bounds.
+// truncated to be contained within the range [lower, upper). A nil lower or
+// upper bound leaves spans untruncated on that side. If start and end are
+// specified, filter out any spans that are completely outside those bounds.
 func Truncate(
 	cmp base.Compare, iter FragmentIterator, lower, upper []byte, start, end *base.InternalKey,
 ) FragmentIterator {
@@ -49,10 +50,10 @@ func Truncate(
 			}
 		}
 		// Truncate the bounds to lower and upper.
-		if cmp(in.Start, lower) < 0 {
+		if lower != nil && cmp(in.Start, lower) < 0 {
 			out.Start = lower
 		}
-		if cmp(in.End, upper) > 0 {
+		if upper != nil && cmp(in.End, upper) > 0 {
 			out.End = upper
 		}
 		return !out.Empty() && cmp(out.Start, out.End) < 0
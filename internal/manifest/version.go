@@ -16,6 +16,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/vfs"
 )
 
@@ -39,6 +40,10 @@ type TableInfo struct {
 	SmallestSeqNum uint64
 	// LargestSeqNum is the largest sequence number in the table.
 	LargestSeqNum uint64
+	// TombstoneDensity is the fraction of the table's entries that are point
+	// or range deletions. It is only populated by DB.SSTables, and only once
+	// the table's statistics have been loaded.
+	TombstoneDensity float64
 }
 
 // TableStats contains statistics on a table used for compaction heuristics.
@@ -52,6 +57,15 @@ type TableStats struct {
 	NumDeletions uint64
 	// NumRangeKeys is the total number of range keys in the table.
 	NumRangeKeys uint64
+	// The number of MERGE, SINGLEDEL and RANGEDEL entries in the table.
+	NumMergeOperands   uint64
+	NumSingleDeletions uint64
+	NumRangeDeletions  uint64
+	// The number of RANGEKEYSET, RANGEKEYUNSET and RANGEKEYDEL keys in the
+	// table, which sum to NumRangeKeys.
+	NumRangeKeySets   uint64
+	NumRangeKeyUnsets uint64
+	NumRangeKeyDels   uint64
 	// Estimate of the total disk space that may be dropped by this table's
 	// point deletions by compacting them.
 	PointDeletionsBytesEstimate uint64
@@ -66,6 +80,8 @@ type TableStats struct {
 	// if snapshots or move compactions prevented the elision of their range
 	// tombstones.
 	RangeDeletionsBytesEstimate uint64
+	// The fraction of the table's entries that are point or range deletions.
+	TombstoneDensity float64
 }
 
 // boundType represents the type of key (point or range) present as the smallest
@@ -133,6 +149,15 @@ type FileMetadata struct {
 	Largest  InternalKey
 	// Stats describe table statistics. Protected by DB.mu.
 	Stats TableStats
+	// FileBacking describes the physical sstable backing a virtual sstable.
+	// It's nil for physical sstables.
+	FileBacking *FileBacking
+	// RemoteLocator identifies the remote storage holding the table's physical
+	// sstable. It's empty for sstables stored on the local filesystem.
+	RemoteLocator remote.Locator
+	// BlobReferences identifies the blob files holding values separated from
+	// the table, which the table's BLOBSET keys reference.
+	BlobReferences []BlobReference
 
 	SubLevel         int
 	L0Index          int
@@ -175,6 +200,10 @@ type FileMetadata struct {
 	HasPointKeys bool
 	// HasRangeKeys tracks whether the table contains any range keys.
 	HasRangeKeys bool
+	// Virtual is true if the table is a virtual sstable: a table that has no
+	// file of its own, but instead exposes the keys within its bounds of the
+	// physical sstable described by FileBacking.
+	Virtual bool
 	// smallestSet and largestSet track whether the overall bounds have been set.
 	boundsSet bool
 	// boundTypeSmallest and boundTypeLargest provide an indication as to which
@@ -183,6 +212,52 @@ type FileMetadata struct {
 	boundTypeSmallest, boundTypeLargest boundType
 }
 
+// BlobReference identifies a blob file referenced by an sstable.
+type BlobReference struct {
+	FileNum base.FileNum
+	// Size is the size of the blob file in bytes.
+	Size uint64
+}
+
+// FileBacking describes a physical sstable that backs one or more virtual
+// sstables.
+type FileBacking struct {
+	// Reference count for the backing file: the number of FileMetadatas,
+	// virtual or physical, that reference the file. The reference count is
+	// maintained by the DB, which deletes the file once it falls to zero.
+	refs int32
+	// FileNum is the file number of the physical sstable.
+	FileNum base.FileNum
+	// Size is the size of the physical sstable, in bytes.
+	Size uint64
+}
+
+// Ref increments the backing's reference count.
+func (b *FileBacking) Ref() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+// Unref decrements the backing's reference count, returning the remaining
+// number of references.
+func (b *FileBacking) Unref() int32 {
+	return atomic.AddInt32(&b.refs, -1)
+}
+
+// Refs returns the backing's reference count.
+func (b *FileBacking) Refs() int32 {
+	return atomic.LoadInt32(&b.refs)
+}
+
+// PhysicalFileNum returns the file number of the sstable on disk containing
+// the table's keys. For a virtual sstable this is the file number of its
+// backing sstable, and otherwise it's the table's own file number.
+func (m *FileMetadata) PhysicalFileNum() base.FileNum {
+	if m.Virtual {
+		return m.FileBacking.FileNum
+	}
+	return m.FileNum
+}
+
 // ExtendPointKeyBounds attempts to extend the lower and upper point key bounds
 // and overall table bounds with the given smallest and largest keys. The
 // smallest and largest bounds may not be extended if the table already has a
@@ -385,6 +460,10 @@ func (m *FileMetadata) Validate(cmp Compare, formatKey base.FormatKey) error {
 		return base.CorruptionErrorf("file %s has inconsistent seqnum bounds: %d vs %d",
 			errors.Safe(m.FileNum), m.SmallestSeqNum, m.LargestSeqNum)
 	}
+	if m.Virtual && m.FileBacking == nil {
+		return base.CorruptionErrorf("virtual file %s has no backing file",
+			errors.Safe(m.FileNum))
+	}
 
 	// Point key validation.
 
@@ -940,27 +1019,41 @@ func (v *Version) CheckOrdering(cmp Compare, format base.FormatKey) error {
 }
 
 // CheckConsistency checks that all of the files listed in the version exist
-// and their on-disk sizes match the sizes listed in the version.
+// and their on-disk sizes match the sizes listed in the version. For virtual
+// sstables, the backing sstables are checked. Sstables stored remotely are
+// skipped, but the blob files they reference are checked.
 func (v *Version) CheckConsistency(dirname string, fs vfs.FS) error {
 	var buf bytes.Buffer
 	var args []interface{}
 
+	check := func(level int, fileType base.FileType, fileNum base.FileNum, size uint64) {
+		path := base.MakeFilepath(fs, dirname, fileType, fileNum)
+		info, err := fs.Stat(path)
+		if err != nil {
+			buf.WriteString("L%d: %s: %v\n")
+			args = append(args, errors.Safe(level), errors.Safe(fileNum), err)
+			return
+		}
+		if info.Size() != int64(size) {
+			buf.WriteString("L%d: %s: file size mismatch (%s): %d (disk) != %d (MANIFEST)\n")
+			args = append(args, errors.Safe(level), errors.Safe(fileNum), path,
+				errors.Safe(info.Size()), errors.Safe(size))
+		}
+	}
 	for level, files := range v.Levels {
 		iter := files.Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
-			path := base.MakeFilepath(fs, dirname, base.FileTypeTable, f.FileNum)
-			info, err := fs.Stat(path)
-			if err != nil {
-				buf.WriteString("L%d: %s: %v\n")
-				args = append(args, errors.Safe(level), errors.Safe(f.FileNum), err)
-				continue
+			for _, ref := range f.BlobReferences {
+				check(level, base.FileTypeBlob, ref.FileNum, ref.Size)
 			}
-			if info.Size() != int64(f.Size) {
-				buf.WriteString("L%d: %s: file size mismatch (%s): %d (disk) != %d (MANIFEST)\n")
-				args = append(args, errors.Safe(level), errors.Safe(f.FileNum), path,
-					errors.Safe(info.Size()), errors.Safe(f.Size))
+			if f.RemoteLocator != "" {
 				continue
 			}
+			fileNum, size := f.FileNum, f.Size
+			if f.Virtual {
+				fileNum, size = f.FileBacking.FileNum, f.FileBacking.Size
+			}
+			check(level, base.FileTypeTable, fileNum, size)
 		}
 	}
 
@@ -13,6 +13,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
 )
 
 // TODO(peter): describe the MANIFEST file format, independently of the C++
@@ -48,13 +49,17 @@ const (
 	tagMaxColumnFamily  = 203
 
 	// Pebble tags.
-	tagNewFile5 = 104 // Range keys.
+	tagNewFile5      = 104 // Range keys.
+	tagFailedOverLog = 105 // WALs abandoned by WAL failover.
 
 	// The custom tags sub-format used by tagNewFile4 and above.
 	customTagTerminate         = 1
 	customTagNeedsCompaction   = 2
 	customTagCreationTime      = 6
 	customTagPathID            = 65
+	customTagVirtual           = 66
+	customTagRemoteLocator     = 67
+	customTagBlobReferences    = 68
 	customTagNonSafeIgnoreMask = 1 << 6
 )
 
@@ -97,6 +102,13 @@ type VersionEdit struct {
 	// for the WAL, MANIFEST, sstable, and OPTIONS files.
 	NextFileNum base.FileNum
 
+	// FailedOverLogNums holds the file numbers of WALs in the primary WAL
+	// directory that were abandoned when the WAL failed over to the secondary
+	// WAL directory. An abandoned WAL is closed asynchronously, and may be left
+	// with an unclean tail that recovery must tolerate even though later WALs
+	// follow it.
+	FailedOverLogNums []base.FileNum
+
 	// LastSeqNum is an upper bound on the sequence numbers that have been
 	// assigned in flushed WALs. Unflushed WALs (that will be replayed during
 	// recovery) may contain sequence numbers greater than this value.
@@ -146,6 +158,13 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			v.NextFileNum = n
 
+		case tagFailedOverLog:
+			n, err := d.readFileNum()
+			if err != nil {
+				return err
+			}
+			v.FailedOverLogNums = append(v.FailedOverLogNums, n)
+
 		case tagLastSequence:
 			n, err := d.readUvarint()
 			if err != nil {
@@ -267,6 +286,9 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			var markedForCompaction bool
 			var creationTime uint64
+			var backing *FileBacking
+			var remoteLocator remote.Locator
+			var blobRefs []BlobReference
 			if tag == tagNewFile4 || tag == tagNewFile5 {
 				for {
 					customTag, err := d.readUvarint()
@@ -297,6 +319,43 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					case customTagPathID:
 						return base.CorruptionErrorf("new-file4: path-id field not supported")
 
+					case customTagVirtual:
+						backingFileNum, n := binary.Uvarint(field)
+						if n <= 0 {
+							return base.CorruptionErrorf("new-file4: invalid virtual backing file number")
+						}
+						backingSize, m := binary.Uvarint(field[n:])
+						if m <= 0 || n+m != len(field) {
+							return base.CorruptionErrorf("new-file4: invalid virtual backing file size")
+						}
+						backing = &FileBacking{
+							FileNum: base.FileNum(backingFileNum),
+							Size:    backingSize,
+						}
+
+					case customTagRemoteLocator:
+						if len(field) == 0 {
+							return base.CorruptionErrorf("new-file4: empty remote locator")
+						}
+						remoteLocator = remote.Locator(field)
+
+					case customTagBlobReferences:
+						for len(field) > 0 {
+							blobFileNum, n := binary.Uvarint(field)
+							if n <= 0 {
+								return base.CorruptionErrorf("new-file4: invalid blob file number")
+							}
+							blobSize, m := binary.Uvarint(field[n:])
+							if m <= 0 {
+								return base.CorruptionErrorf("new-file4: invalid blob file size")
+							}
+							blobRefs = append(blobRefs, BlobReference{
+								FileNum: base.FileNum(blobFileNum),
+								Size:    blobSize,
+							})
+							field = field[n+m:]
+						}
+
 					default:
 						if (customTag & customTagNonSafeIgnoreMask) != 0 {
 							return base.CorruptionErrorf("new-file4: custom field not supported: %d", customTag)
@@ -311,6 +370,10 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				SmallestSeqNum:      smallestSeqNum,
 				LargestSeqNum:       largestSeqNum,
 				MarkedForCompaction: markedForCompaction,
+				Virtual:             backing != nil,
+				FileBacking:         backing,
+				RemoteLocator:       remoteLocator,
+				BlobReferences:      blobRefs,
 			}
 			if tag != tagNewFile5 { // no range keys present
 				m.SmallestPointKey = base.DecodeInternalKey(smallestPointKey)
@@ -384,6 +447,10 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(tagNextFileNumber)
 		e.writeUvarint(uint64(v.NextFileNum))
 	}
+	for _, n := range v.FailedOverLogNums {
+		e.writeUvarint(tagFailedOverLog)
+		e.writeUvarint(uint64(n))
+	}
 	// RocksDB requires LastSeqNum to be encoded for the first MANIFEST entry,
 	// even though its value is zero. We detect this by encoding LastSeqNum when
 	// ComparerName is set.
@@ -397,7 +464,8 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(uint64(x.FileNum))
 	}
 	for _, x := range v.NewFiles {
-		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0
+		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.Virtual ||
+			x.Meta.RemoteLocator != "" || len(x.Meta.BlobReferences) > 0
 		var tag uint64
 		switch {
 		case x.Meta.HasRangeKeys:
@@ -450,6 +518,27 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 				e.writeUvarint(customTagNeedsCompaction)
 				e.writeBytes([]byte{1})
 			}
+			if x.Meta.Virtual {
+				e.writeUvarint(customTagVirtual)
+				var buf [2 * binary.MaxVarintLen64]byte
+				n := binary.PutUvarint(buf[:], uint64(x.Meta.FileBacking.FileNum))
+				n += binary.PutUvarint(buf[n:], x.Meta.FileBacking.Size)
+				e.writeBytes(buf[:n])
+			}
+			if x.Meta.RemoteLocator != "" {
+				e.writeUvarint(customTagRemoteLocator)
+				e.writeString(string(x.Meta.RemoteLocator))
+			}
+			if len(x.Meta.BlobReferences) > 0 {
+				e.writeUvarint(customTagBlobReferences)
+				buf := make([]byte, 0, 2*binary.MaxVarintLen64*len(x.Meta.BlobReferences))
+				var tmp [binary.MaxVarintLen64]byte
+				for _, ref := range x.Meta.BlobReferences {
+					buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(ref.FileNum))]...)
+					buf = append(buf, tmp[:binary.PutUvarint(tmp[:], ref.Size)]...)
+				}
+				e.writeBytes(buf)
+			}
 			e.writeUvarint(customTagTerminate)
 		}
 	}
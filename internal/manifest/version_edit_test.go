@@ -90,6 +90,44 @@ func TestVersionEditRoundTrip(t *testing.T) {
 		base.MakeExclusiveSentinelKey(base.InternalKeyKindRangeKeySet, []byte("z")),
 	)
 
+	m5 := (&FileMetadata{
+		FileNum:        810,
+		Size:           4050,
+		CreationTime:   810070,
+		SmallestSeqNum: 9,
+		LargestSeqNum:  11,
+		Virtual:        true,
+		FileBacking:    &FileBacking{FileNum: 809, Size: 8090},
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("a"), 0, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("c"), 0, base.InternalKeyKindSet),
+	)
+
+	m6 := (&FileMetadata{
+		FileNum:        811,
+		Size:           8110,
+		SmallestSeqNum: 12,
+		LargestSeqNum:  12,
+		RemoteLocator:  "bucket",
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("d"), 0, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("f"), 0, base.InternalKeyKindSet),
+	)
+
+	m7 := (&FileMetadata{
+		FileNum:        812,
+		Size:           8120,
+		SmallestSeqNum: 13,
+		LargestSeqNum:  13,
+		BlobReferences: []BlobReference{{FileNum: 813, Size: 8130}, {FileNum: 814, Size: 8140}},
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("g"), 0, base.InternalKeyKindBlobSet),
+		base.MakeInternalKey([]byte("h"), 0, base.InternalKeyKindBlobSet),
+	)
+
 	testCases := []VersionEdit{
 		// An empty version edit.
 		{},
@@ -99,6 +137,7 @@ func TestVersionEditRoundTrip(t *testing.T) {
 			MinUnflushedLogNum: 22,
 			ObsoletePrevLogNum: 33,
 			NextFileNum:        44,
+			FailedOverLogNums:  []base.FileNum{20, 21},
 			LastSeqNum:         55,
 			DeletedFiles: map[DeletedFileEntry]*FileMetadata{
 				{
@@ -127,6 +166,18 @@ func TestVersionEditRoundTrip(t *testing.T) {
 					Level: 6,
 					Meta:  m4,
 				},
+				{
+					Level: 6,
+					Meta:  m5,
+				},
+				{
+					Level: 6,
+					Meta:  m6,
+				},
+				{
+					Level: 6,
+					Meta:  m7,
+				},
 			},
 		},
 	}
@@ -0,0 +1,61 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package private
+
+// IterOp identifies an Iterator operation recorded by a workload capture.
+type IterOp uint8
+
+// The Iterator operations recorded by a workload capture.
+const (
+	IterSeekGE IterOp = iota + 1
+	IterSeekPrefixGE
+	IterSeekLT
+	IterFirst
+	IterLast
+	IterNext
+	IterNextPrefix
+	IterPrev
+	IterPrevPrefix
+	IterSetBounds
+	IterClose
+	IterSetOptions
+)
+
+// Capturer receives the operations performed on a DB whose workload is being
+// captured. Its methods are called synchronously by the operations, and must
+// be safe for concurrent use.
+type Capturer interface {
+	// CaptureBatch is called with the representation of each batch committed
+	// to the DB, in sequence number order. It's called with the commit
+	// pipeline's mutex held, and must not block.
+	CaptureBatch(repr []byte)
+	// CaptureNewIter is called when an Iterator over the DB is created, with
+	// the Iterator's options, a *pebble.IterOptions, and the sequence number
+	// of its view: the Iterator observes the batches with lower sequence
+	// numbers. It returns the IterCapturer that records the Iterator's
+	// operations.
+	CaptureNewIter(opts interface{}, seqNum uint64) IterCapturer
+}
+
+// IterCapturer receives the operations performed on an Iterator over a DB
+// whose workload is being captured. Its methods are only called by the
+// goroutine using the Iterator.
+type IterCapturer interface {
+	// CaptureOp is called with each operation on the Iterator, other than
+	// IterSetOptions. For seeks and steps, key and limit are the operation's
+	// arguments. For IterSetBounds, they're the new lower and upper bounds.
+	CaptureOp(op IterOp, key, limit []byte)
+	// CaptureSetOptions is called with the options, a *pebble.IterOptions,
+	// passed to the Iterator's SetOptions.
+	CaptureSetOptions(opts interface{})
+}
+
+// SetCapturer is a hook for installing a Capturer on a DB. Its first parameter
+// is a *pebble.DB and its second the Capturer, or nil to stop capturing the
+// DB's workload.
+//
+// This function is wrapped by the replay package. Clients should use the
+// replay package rather than calling this private hook directly.
+var SetCapturer func(interface{}, Capturer)
@@ -18,6 +18,7 @@ import (
 // for user iteration.
 type UserIteratorConfig struct {
 	snapshot   uint64
+	merge      base.RangeKeyMerge
 	miter      keyspan.MergingIter
 	diter      keyspan.DefragmentingIter
 	liters     [manifest.NumLevels]keyspan.LevelIter
@@ -36,11 +37,16 @@ type UserIteratorConfig struct {
 // RangeKeySets describing the current state of range keys.
 //
 // The snapshot sequence number parameter determines which keys are visible. Any
-// keys not visible at the provided snapshot are ignored.
+// keys not visible at the provided snapshot are ignored. The optional merge
+// function is used to combine overlapping RangeKeySets with the same suffix.
 func (ui *UserIteratorConfig) Init(
-	cmp base.Compare, snapshot uint64, iters ...keyspan.FragmentIterator,
+	cmp base.Compare,
+	merge base.RangeKeyMerge,
+	snapshot uint64,
+	iters ...keyspan.FragmentIterator,
 ) keyspan.FragmentIterator {
 	ui.snapshot = snapshot
+	ui.merge = merge
 	ui.defragBufA.keys = ui.defragBufAlloc[0][:0]
 	ui.defragBufB.keys = ui.defragBufAlloc[1][:0]
 	ui.miter.Init(cmp, ui, iters...)
@@ -77,7 +83,7 @@ func (ui *UserIteratorConfig) Transform(cmp base.Compare, s keyspan.Span, dst *k
 	// Apply shadowing of keys.
 	dst.Start = s.Start
 	dst.End = s.End
-	if err := Coalesce(cmp, s.Visible(ui.snapshot).Keys, &dst.Keys); err != nil {
+	if err := Coalesce(cmp, ui.merge, s.Visible(ui.snapshot).Keys, &dst.Keys); err != nil {
 		return err
 	}
 
@@ -186,7 +192,14 @@ func (ui *UserIteratorConfig) ShouldDefragment(cmp base.Compare, a, b *keyspan.S
 // keys do not affect one another. Ingested sstables are expected to be
 // consistent with respect to the set/unset suffixes: A given suffix should be
 // set or unset but not both.
-func Coalesce(cmp base.Compare, keys []keyspan.Key, dst *[]keyspan.Key) error {
+//
+// If merge is non-nil, a RANGEKEYSET doesn't shadow older RANGEKEYSETs with the
+// same suffix. Instead, their values are combined through merge, from newest to
+// oldest, until a RANGEKEYUNSET of the suffix or a RANGEKEYDEL is encountered.
+// The resulting RANGEKEYSET adopts the largest sequence number.
+func Coalesce(
+	cmp base.Compare, merge base.RangeKeyMerge, keys []keyspan.Key, dst *[]keyspan.Key,
+) error {
 	// TODO(jackson): Currently, Coalesce doesn't actually perform the sequence
 	// number promotion described in the comment above.
 
@@ -195,6 +208,10 @@ func Coalesce(cmp base.Compare, keys []keyspan.Key, dst *[]keyspan.Key) error {
 		keys: (*dst)[:0],
 	}
 	var deleted bool
+	// unset holds the suffixes of RANGEKEYSETs that are shadowed by a more
+	// recent RANGEKEYUNSET, and so must not be merged with older RANGEKEYSETs.
+	// It's only populated if merge is non-nil.
+	var unset [][]byte
 	for i := 0; i < len(keys) && !deleted; i++ {
 		k := keys[i]
 		if invariants.Enabled && i > 0 && k.Trailer > keys[i-1].Trailer {
@@ -209,9 +226,18 @@ func Coalesce(cmp base.Compare, keys []keyspan.Key, dst *[]keyspan.Key) error {
 		case base.InternalKeyKindRangeKeySet:
 			n := len(keysBySuffix.keys)
 
-			if keysBySuffix.get(n, k.Suffix) < n {
+			if j := keysBySuffix.get(n, k.Suffix); j < n {
 				// This suffix is already set or unset at a higher sequence
-				// number. Skip.
+				// number. Merge the values if both are sets and the newer set
+				// isn't shadowed by an intervening unset. Otherwise, skip.
+				if merge != nil && keysBySuffix.keys[j].Kind() == base.InternalKeyKindRangeKeySet &&
+					!containsSuffix(cmp, unset, k.Suffix) {
+					v, err := merge(k.Suffix, keysBySuffix.keys[j].Value, k.Value)
+					if err != nil {
+						return err
+					}
+					keysBySuffix.keys[j].Value = v
+				}
 				continue
 			}
 			keysBySuffix.keys = append(keysBySuffix.keys, k)
@@ -221,7 +247,11 @@ func Coalesce(cmp base.Compare, keys []keyspan.Key, dst *[]keyspan.Key) error {
 
 			if keysBySuffix.get(n, k.Suffix) < n {
 				// This suffix is already set or unset at a higher sequence
-				// number. Skip.
+				// number. Skip, but prevent the more recent set from merging
+				// with any older sets.
+				if merge != nil {
+					unset = append(unset, k.Suffix)
+				}
 				continue
 			}
 			keysBySuffix.keys = append(keysBySuffix.keys, k)
@@ -246,6 +276,15 @@ func Coalesce(cmp base.Compare, keys []keyspan.Key, dst *[]keyspan.Key) error {
 	return nil
 }
 
+func containsSuffix(cmp base.Compare, suffixes [][]byte, suffix []byte) bool {
+	for i := range suffixes {
+		if cmp(suffixes[i], suffix) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // SortBySuffix sorts the provided keys by suffix.
 func SortBySuffix(cmp base.Compare, keys []keyspan.Key) {
 	bySuffix := keysBySuffix{
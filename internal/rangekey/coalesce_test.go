@@ -30,12 +30,20 @@ func TestCoalesce(t *testing.T) {
 		switch td.Cmd {
 		case "coalesce":
 			buf.Reset()
+			// The merge argument configures a merge function that joins
+			// values from newest to oldest.
+			var merge base.RangeKeyMerge
+			if td.HasArg("merge") {
+				merge = func(suffix, newer, older []byte) ([]byte, error) {
+					return []byte(fmt.Sprintf("%s+%s", newer, older)), nil
+				}
+			}
 			span := keyspan.ParseSpan(td.Input)
 			coalesced := keyspan.Span{
 				Start: span.Start,
 				End:   span.End,
 			}
-			if err := Coalesce(cmp, span.Keys, &coalesced.Keys); err != nil {
+			if err := Coalesce(cmp, merge, span.Keys, &coalesced.Keys); err != nil {
 				return err.Error()
 			}
 			fmt.Fprintln(&buf, coalesced)
@@ -73,7 +81,7 @@ func TestIter(t *testing.T) {
 				s = s.Visible(visibleSeqNum)
 				dst.Start = s.Start
 				dst.End = s.End
-				return Coalesce(cmp, s.Keys, &dst.Keys)
+				return Coalesce(cmp, nil /* merge */, s.Keys, &dst.Keys)
 			})
 			iter.Init(cmp, transform, keyspan.NewIter(cmp, spans))
 			return "OK"
@@ -134,7 +142,7 @@ func TestDefragmenting(t *testing.T) {
 			return ""
 		case "iter":
 			var userIterCfg UserIteratorConfig
-			iter := userIterCfg.Init(cmp, base.InternalKeySeqNumMax, keyspan.NewIter(cmp, spans))
+			iter := userIterCfg.Init(cmp, nil /* merge */, base.InternalKeySeqNumMax, keyspan.NewIter(cmp, spans))
 			for _, line := range strings.Split(td.Input, "\n") {
 				runIterOp(&buf, iter, line)
 			}
@@ -213,8 +221,8 @@ func testDefragmentingIteRandomizedOnce(t *testing.T, seed int64) {
 	fragmented = fragment(cmp, formatKey, fragmented)
 
 	var referenceCfg, fragmentedCfg UserIteratorConfig
-	referenceIter := referenceCfg.Init(cmp, base.InternalKeySeqNumMax, keyspan.NewIter(cmp, original))
-	fragmentedIter := fragmentedCfg.Init(cmp, base.InternalKeySeqNumMax, keyspan.NewIter(cmp, fragmented))
+	referenceIter := referenceCfg.Init(cmp, nil /* merge */, base.InternalKeySeqNumMax, keyspan.NewIter(cmp, original))
+	fragmentedIter := fragmentedCfg.Init(cmp, nil /* merge */, base.InternalKeySeqNumMax, keyspan.NewIter(cmp, fragmented))
 
 	// Generate 100 random operations and run them against both iterators.
 	const numIterOps = 100
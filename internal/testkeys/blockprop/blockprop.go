@@ -4,13 +4,16 @@
 
 // Package blockprop implements interval block property collectors and filters
 // on the suffixes of keys in the format used by the testkeys package (eg,
-// 'key@5').
+// 'key@5'), as well as on other attributes of key-value pairs. Collectors and
+// their filters may be looked up by name through a registry.
 package blockprop
 
 import (
 	"math"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/sstable"
 )
@@ -63,6 +66,30 @@ func (f MaskingFilter) SetSuffix(suffix []byte) error {
 	return nil
 }
 
+// SetSuffixRange configures the filter to mask point keys with suffixes
+// within the inclusive range [lower, upper]. An empty upper suffix
+// leaves the range unbounded, masking all point keys with suffixes ≥ lower
+// like SetSuffix. An error is returned if upper < lower.
+func (f MaskingFilter) SetSuffixRange(lower, upper []byte) error {
+	lts, err := testkeys.ParseSuffix(lower)
+	if err != nil {
+		return err
+	}
+	if len(upper) == 0 {
+		f.BlockIntervalFilter.SetInterval(uint64(lts), math.MaxUint64)
+		return nil
+	}
+	uts, err := testkeys.ParseSuffix(upper)
+	if err != nil {
+		return err
+	}
+	if uts < lts {
+		return errors.Errorf("blockprop: upper suffix %q < lower suffix %q", upper, lower)
+	}
+	f.BlockIntervalFilter.SetInterval(uint64(lts), uint64(uts)+1)
+	return nil
+}
+
 // Intersects implements the BlockPropertyFilter interface.
 func (f MaskingFilter) Intersects(prop []byte) (bool, error) {
 	return f.BlockIntervalFilter.Intersects(prop)
@@ -115,3 +142,60 @@ func (c *suffixIntervalCollector) FinishDataBlock() (lower, upper uint64, err er
 	c.initialized = false
 	return l, u, nil
 }
+
+// NewOutputSplitter constructs an sstable.OutputSplitter that keeps the
+// interval of testkey suffixes collected by the block property collector
+// narrow within each output sstable. It advises a split before a point key
+// whose suffix would widen the output's suffix interval, [lower, upper), to
+// more than maxWidth. Unsuffixed keys do not widen the interval.
+func NewOutputSplitter(maxWidth uint64) sstable.OutputSplitter {
+	return &suffixIntervalSplitter{maxWidth: maxWidth}
+}
+
+// suffixIntervalSplitter maintains the interval over the suffixes of the point
+// keys added to the current output sstable.
+type suffixIntervalSplitter struct {
+	maxWidth  uint64
+	interval  suffixIntervalCollector
+	splitting bool
+}
+
+// ShouldSplitBefore implements sstable.OutputSplitter.
+func (s *suffixIntervalSplitter) ShouldSplitBefore(
+	key base.InternalKey, tw *sstable.Writer,
+) sstable.SplitDecision {
+	if tw == nil {
+		s.interval = suffixIntervalCollector{}
+		s.splitting = false
+	}
+	if s.splitting {
+		return sstable.SplitNow
+	}
+	if k := key.Kind(); k == base.InternalKeyKindRangeDelete || rangekey.IsRangeKey(k) {
+		return sstable.NoSplit
+	}
+	i := testkeys.Comparer.Split(key.UserKey)
+	if i == len(key.UserKey) {
+		return sstable.NoSplit
+	}
+	ts, err := testkeys.ParseSuffix(key.UserKey[i:])
+	if err != nil {
+		return sstable.NoSplit
+	}
+	lower, upper := uint64(ts), uint64(ts)+1
+	if s.interval.initialized {
+		if s.interval.lower < lower {
+			lower = s.interval.lower
+		}
+		if s.interval.upper > upper {
+			upper = s.interval.upper
+		}
+		if upper-lower > s.maxWidth {
+			s.splitting = true
+			return sstable.SplitNow
+		}
+	}
+	s.interval.initialized = true
+	s.interval.lower, s.interval.upper = lower, upper
+	return sstable.NoSplit
+}
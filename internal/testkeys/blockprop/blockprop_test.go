@@ -0,0 +1,608 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blockprop
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func pointKey(key string) base.InternalKey {
+	return base.MakeInternalKey([]byte(key), 0, base.InternalKeyKindSet)
+}
+
+func TestSuffixSetCollector(t *testing.T) {
+	c := NewSuffixSetCollector(3)().(*suffixSetCollector)
+	finish := func() suffixSet {
+		buf, err := c.FinishDataBlock(nil)
+		require.NoError(t, err)
+		c.AddPrevDataBlockToIndexBlock()
+		var set suffixSet
+		require.NoError(t, set.decode(buf))
+		return set
+	}
+
+	// Disjoint suffixes are tracked as disjoint intervals, and adjacent
+	// suffixes are merged.
+	for _, k := range []string{"a@999", "b@2", "c@1", "d@500"} {
+		require.NoError(t, c.Add(pointKey(k), nil))
+	}
+	require.Equal(t, suffixSet{{1, 3}, {500, 501}, {999, 1000}}, finish())
+
+	// The set is reset between blocks.
+	require.NoError(t, c.Add(pointKey("e@7"), nil))
+	require.Equal(t, suffixSet{{7, 8}}, finish())
+
+	// Exceeding the maximum number of intervals collapses the set into a
+	// single coarse interval, which subsequent suffixes extend.
+	for _, k := range []string{"f@10", "g@20", "h@30", "i@40", "j@5", "k@15"} {
+		require.NoError(t, c.Add(pointKey(k), nil))
+	}
+	require.Equal(t, suffixSet{{5, 41}}, finish())
+
+	// The overflow state is reset between blocks too.
+	for _, k := range []string{"l@10", "m@20"} {
+		require.NoError(t, c.Add(pointKey(k), nil))
+	}
+	require.Equal(t, suffixSet{{10, 11}, {20, 21}}, finish())
+
+	// Unsuffixed keys produce the universal set.
+	require.NoError(t, c.Add(pointKey("n"), nil))
+	require.Equal(t, suffixSet{{0, math.MaxUint64}}, finish())
+
+	// An empty block produces the empty set.
+	require.Equal(t, suffixSet(nil), finish())
+
+	// The table set is the coarsened union of all blocks.
+	buf, err := c.FinishTable(nil)
+	require.NoError(t, err)
+	var set suffixSet
+	require.NoError(t, set.decode(buf))
+	require.Equal(t, suffixSet{{0, math.MaxUint64}}, set)
+}
+
+func TestSuffixSetEncoding(t *testing.T) {
+	for _, set := range []suffixSet{
+		nil,
+		{{0, 1}},
+		{{1, 3}, {500, 501}, {999, 1000}},
+		{{0, math.MaxUint64}},
+	} {
+		var decoded suffixSet
+		require.NoError(t, decoded.decode(set.encode(nil)))
+		if len(set) == 0 {
+			require.Empty(t, decoded)
+		} else {
+			require.Equal(t, set, decoded)
+		}
+	}
+}
+
+func TestSuffixSetFilter(t *testing.T) {
+	prop := suffixSet{{1, 3}, {999, 1000}}.encode(nil)
+	testCases := []struct {
+		lower, upper uint64
+		want         bool
+	}{
+		{0, 1, false},
+		{0, 2, true},
+		{2, 3, true},
+		{3, 999, false},
+		{500, 600, false},
+		{500, 1000, true},
+		{1000, math.MaxUint64, false},
+		{5, 5, false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("[%d,%d)", tc.lower, tc.upper), func(t *testing.T) {
+			got, err := NewSuffixSetFilter(tc.lower, tc.upper).Intersects(prop)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+// writeTable writes a new sstable using the provided write function and opens
+// it for reading.
+func writeTable(
+	t *testing.T, opts sstable.WriterOptions, write func(w *sstable.Writer) error,
+) *sstable.Reader {
+	fs := vfs.NewMem()
+	f, err := fs.Create("test")
+	require.NoError(t, err)
+	opts.Comparer = testkeys.Comparer
+	opts.TableFormat = sstable.TableFormatPebblev2
+	w := sstable.NewWriter(f, opts)
+	require.NoError(t, write(w))
+	require.NoError(t, w.Close())
+
+	f, err = fs.Open("test")
+	require.NoError(t, err)
+	r, err := sstable.NewReader(f, sstable.ReaderOptions{Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	return r
+}
+
+// countFilteredKeys returns the number of keys surfaced by an iterator over
+// r filtered by the provided block-property filters, or -1 if the table is
+// excluded entirely.
+func countFilteredKeys(t *testing.T, r *sstable.Reader, filters ...sstable.BlockPropertyFilter) int {
+	filterer := sstable.NewBlockPropertiesFilterer(filters, nil)
+	ok, err := filterer.IntersectsUserPropsAndFinishInit(r.Properties.UserProperties)
+	require.NoError(t, err)
+	if !ok {
+		return -1
+	}
+	iter, err := r.NewIterWithBlockPropertyFilters(nil, nil, filterer, false /* useFilterBlock */)
+	require.NoError(t, err)
+	defer iter.Close()
+	var n int
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Error())
+	return n
+}
+
+func TestSuffixSetBlockSkipping(t *testing.T) {
+	// Write keys alternating between suffixes @1 and @999, followed by a
+	// single key with suffix @550. Every data block contains both the low and
+	// high suffixes, so the single-interval collector is unable to exclude any
+	// block for a query over [500, 600). The suffix set collector only admits
+	// the block containing @550.
+	const n = 500
+	ks := testkeys.Alpha(3)
+	var keys [][]byte
+	for i := 0; i < n; i++ {
+		ts := 1
+		if i%2 == 1 {
+			ts = 999
+		}
+		keys = append(keys, testkeys.KeyAt(ks, i, ts))
+	}
+	keys = append(keys, testkeys.KeyAt(ks, n, 550))
+
+	r := writeTable(t, sstable.WriterOptions{
+		BlockSize: 256,
+		BlockPropertyCollectors: []func() sstable.BlockPropertyCollector{
+			NewBlockPropertyCollector,
+			NewSuffixSetCollector(DefaultMaxSuffixIntervals),
+		},
+	}, func(w *sstable.Writer) error {
+		value := make([]byte, 10)
+		for _, k := range keys {
+			if err := w.Set(k, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer func() { require.NoError(t, r.Close()) }()
+	require.Greater(t, r.Properties.NumDataBlocks, uint64(10))
+
+	intervalCount := countFilteredKeys(t, r, NewBlockPropertyFilter(500, 600))
+	require.Equal(t, n+1, intervalCount)
+
+	setCount := countFilteredKeys(t, r, NewSuffixSetFilter(500, 600))
+	require.Greater(t, setCount, 0)
+	require.Less(t, setCount, intervalCount/10)
+
+	// A query over a range containing no suffixes excludes the whole table.
+	require.Equal(t, -1, countFilteredKeys(t, r, NewSuffixSetFilter(2, 500)))
+	require.Equal(t, n+1, countFilteredKeys(t, r, NewBlockPropertyFilter(2, 500)))
+}
+
+func TestValueSizeCollector(t *testing.T) {
+	c := &valueSizeIntervalCollector{}
+	for _, n := range []int{5, 1, 20} {
+		require.NoError(t, c.Add(pointKey("a"), make([]byte, n)))
+	}
+	lower, upper, err := c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{1, 21}, [2]uint64{lower, upper})
+
+	// Range key sets contribute each of their logical values, independent of
+	// the length of the encoded internal value.
+	suffixValues := []rangekey.SuffixValue{
+		{Suffix: []byte("@1"), Value: make([]byte, 3)},
+		{Suffix: []byte("@2"), Value: make([]byte, 7)},
+	}
+	v := make([]byte, rangekey.EncodedSetValueLen([]byte("z"), suffixValues))
+	rangekey.EncodeSetValue(v, []byte("z"), suffixValues)
+	require.NoError(t, c.Add(base.MakeInternalKey([]byte("a"), 0, base.InternalKeyKindRangeKeySet), v))
+	// Range key unsets and deletes are ignored.
+	require.NoError(t, c.Add(base.MakeInternalKey([]byte("a"), 0, base.InternalKeyKindRangeKeyDelete), []byte("z")))
+	lower, upper, err = c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{3, 8}, [2]uint64{lower, upper})
+
+	// An empty block produces the empty interval.
+	lower, upper, err = c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0, 0}, [2]uint64{lower, upper})
+}
+
+func TestValueSizeBlockSkipping(t *testing.T) {
+	// Write runs of keys with small values interleaved with runs of keys with
+	// large values.
+	const n = 400
+	ks := testkeys.Alpha(3)
+	r := writeTable(t, sstable.WriterOptions{
+		BlockSize:               512,
+		BlockPropertyCollectors: []func() sstable.BlockPropertyCollector{NewValueSizeCollector},
+	}, func(w *sstable.Writer) error {
+		small, large := make([]byte, 8), make([]byte, 1024)
+		for i := 0; i < n; i++ {
+			v := small
+			if (i/50)%2 == 1 {
+				v = large
+			}
+			if err := w.Set(testkeys.Key(ks, i), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer func() { require.NoError(t, r.Close()) }()
+
+	require.Equal(t, n, countFilteredKeys(t, r, NewValueSizeFilter(0, 2048)))
+	// Every block containing exclusively large values is excluded, leaving
+	// the small values and at most a few large values sharing a block with
+	// them.
+	smallCount := countFilteredKeys(t, r, NewValueSizeFilter(0, 16))
+	require.GreaterOrEqual(t, smallCount, n/2)
+	require.LessOrEqual(t, smallCount, n/2+4)
+	// Conversely, blocks of small values are excluded when filtering for
+	// large values.
+	largeCount := countFilteredKeys(t, r, NewValueSizeFilter(1024, 2048))
+	require.GreaterOrEqual(t, largeCount, n/2)
+	require.Less(t, largeCount, n/2+50)
+	// No value is larger than 1024 bytes, so the whole table is excluded.
+	require.Equal(t, -1, countFilteredKeys(t, r, NewValueSizeFilter(1025, math.MaxUint64)))
+}
+
+func TestRegistry(t *testing.T) {
+	require.Subset(t, Registered(), []string{"suffix", "suffixset", "tombstones", "valuesize"})
+
+	collectors, err := NewCollectors("suffix", "valuesize")
+	require.NoError(t, err)
+	require.Len(t, collectors, 2)
+	require.Equal(t, blockPropertyName, collectors[0]().Name())
+	require.Equal(t, valueSizePropertyName, collectors[1]().Name())
+	_, err = NewCollectors("suffix", "unknown")
+	require.Error(t, err)
+
+	for spec, name := range map[string]string{
+		"suffix:1,5":      blockPropertyName,
+		"suffixset:0, 10": suffixSetPropertyName,
+		"valuesize:3,4":   valueSizePropertyName,
+	} {
+		f, err := ParseFilter(spec)
+		require.NoError(t, err)
+		require.Equal(t, name, f.Name())
+	}
+	for _, spec := range []string{"unknown:1,2", "suffix", "suffix:1", "suffix:a,b"} {
+		_, err := ParseFilter(spec)
+		require.Error(t, err, spec)
+	}
+
+	// Registering a custom collector makes it available by name, and
+	// registering a name twice panics.
+	Register("test-custom", NewBlockPropertyCollector,
+		func(args string) (sstable.BlockPropertyFilter, error) {
+			return NewBlockPropertyFilter(0, uint64(len(args))), nil
+		})
+	r, ok := Lookup("test-custom")
+	require.True(t, ok)
+	require.Equal(t, "test-custom", r.Name)
+	f, err := ParseFilter("test-custom:abc")
+	require.NoError(t, err)
+	require.Equal(t, blockPropertyName, f.Name())
+	require.Panics(t, func() {
+		Register("test-custom", NewBlockPropertyCollector, r.NewFilter)
+	})
+
+	// Filters reconstructed from specs filter tables written with collectors
+	// constructed by name.
+	ks := testkeys.Alpha(2)
+	rd := writeTable(t, sstable.WriterOptions{BlockPropertyCollectors: collectors},
+		func(w *sstable.Writer) error {
+			for i := 0; i < 10; i++ {
+				if err := w.Set(testkeys.KeyAt(ks, i, 5), []byte("value")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	defer func() { require.NoError(t, rd.Close()) }()
+	for spec, want := range map[string]int{
+		"suffix:5,6":    10,
+		"suffix:6,10":   -1,
+		"valuesize:5,6": 10,
+		"valuesize:0,5": -1,
+	} {
+		f, err := ParseFilter(spec)
+		require.NoError(t, err)
+		require.Equal(t, want, countFilteredKeys(t, rd, f), spec)
+	}
+}
+
+func TestMaskingFilterSetSuffixRange(t *testing.T) {
+	f := NewMaskingFilter()
+	intersects := func(lower, upper uint64) bool {
+		ok, err := f.Intersects(encodeInterval(lower, upper))
+		require.NoError(t, err)
+		return ok
+	}
+
+	require.NoError(t, f.SetSuffix([]byte("@5")))
+	require.False(t, intersects(1, 5))
+	require.True(t, intersects(1, 6))
+	require.True(t, intersects(1000, 1001))
+
+	// The upper suffix is inclusive.
+	require.NoError(t, f.SetSuffixRange([]byte("@5"), []byte("@10")))
+	require.False(t, intersects(1, 5))
+	require.True(t, intersects(5, 6))
+	require.True(t, intersects(10, 11))
+	require.False(t, intersects(11, 12))
+
+	// A range containing exactly one suffix.
+	require.NoError(t, f.SetSuffixRange([]byte("@7"), []byte("@7")))
+	require.False(t, intersects(6, 7))
+	require.True(t, intersects(7, 8))
+	require.False(t, intersects(8, 9))
+
+	// An empty upper suffix leaves the range unbounded.
+	require.NoError(t, f.SetSuffixRange([]byte("@5"), nil))
+	require.False(t, intersects(1, 5))
+	require.True(t, intersects(math.MaxUint64-1, math.MaxUint64))
+
+	// An upper suffix less than the lower suffix is an error, and malformed
+	// suffixes are errors.
+	require.Error(t, f.SetSuffixRange([]byte("@10"), []byte("@5")))
+	require.Error(t, f.SetSuffixRange([]byte("@x"), []byte("@5")))
+	require.Error(t, f.SetSuffixRange([]byte("@5"), []byte("@x")))
+}
+
+// encodeInterval encodes the interval [lower, upper) in the format used by
+// sstable.BlockIntervalCollector.
+func encodeInterval(lower, upper uint64) []byte {
+	c := sstable.NewBlockIntervalCollector("", &fixedIntervalCollector{lower, upper}, nil)
+	buf, err := c.FinishDataBlock(nil)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+type fixedIntervalCollector struct {
+	lower, upper uint64
+}
+
+func (c *fixedIntervalCollector) Add(base.InternalKey, []byte) error { return nil }
+
+func (c *fixedIntervalCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	return c.lower, c.upper, nil
+}
+
+func TestTombstoneCountCollector(t *testing.T) {
+	c := &tombstoneCountIntervalCollector{}
+	for _, kind := range []base.InternalKeyKind{
+		base.InternalKeyKindSet,
+		base.InternalKeyKindDelete,
+		base.InternalKeyKindMerge,
+		base.InternalKeyKindSingleDelete,
+		base.InternalKeyKindDelete,
+		base.InternalKeyKindRangeDelete,
+	} {
+		require.NoError(t, c.Add(base.MakeInternalKey([]byte("a"), 0, kind), nil))
+	}
+	lower, upper, err := c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{3, 4}, [2]uint64{lower, upper})
+
+	// The count is reset between blocks.
+	require.NoError(t, c.Add(pointKey("a"), nil))
+	lower, upper, err = c.FinishDataBlock()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0, 1}, [2]uint64{lower, upper})
+}
+
+func TestTombstoneCountBlockSkipping(t *testing.T) {
+	// Write runs of sets interleaved with runs of deletes, with a suffix
+	// collector configured as well to exercise filter composition.
+	const n = 400
+	ks := testkeys.Alpha(3)
+	r := writeTable(t, sstable.WriterOptions{
+		BlockSize: 256,
+		BlockPropertyCollectors: []func() sstable.BlockPropertyCollector{
+			NewBlockPropertyCollector,
+			NewTombstoneCountCollector,
+		},
+	}, func(w *sstable.Writer) error {
+		for i := 0; i < n; i++ {
+			k := testkeys.KeyAt(ks, i, i)
+			if (i/50)%2 == 1 {
+				if err := w.Delete(k); err != nil {
+					return err
+				}
+			} else if err := w.Set(k, make([]byte, 10)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer func() { require.NoError(t, r.Close()) }()
+
+	require.Equal(t, n, countFilteredKeys(t, r, NewTombstoneCountFilter(0, math.MaxUint64)))
+	// Blocks containing no tombstones are skipped. Blocks straddling a run of
+	// sets and a run of deletes contain a few sets.
+	dense := countFilteredKeys(t, r, NewTombstoneCountFilter(1, math.MaxUint64))
+	require.GreaterOrEqual(t, dense, n/2)
+	require.Less(t, dense, n/2+n/5)
+	// Conversely, blocks containing any tombstones are skipped if filtering
+	// for blocks without tombstones, including the straddling blocks.
+	sparse := countFilteredKeys(t, r, NewTombstoneCountFilter(0, 1))
+	require.LessOrEqual(t, sparse, n/2)
+	require.Greater(t, sparse, n/2-n/5)
+	require.Equal(t, n, dense+sparse)
+
+	// Composing with the suffix filter excludes blocks excluded by either
+	// filter: All keys with suffixes < 50 are sets.
+	composed := countFilteredKeys(t, r,
+		NewTombstoneCountFilter(1, math.MaxUint64), NewBlockPropertyFilter(0, 50))
+	require.Less(t, composed, n/10)
+}
+
+func TestTableLevelProperties(t *testing.T) {
+	// Write keys with suffixes @10-@19 and values of 5-9 bytes, and no
+	// tombstones.
+	collectors := []func() sstable.BlockPropertyCollector{
+		NewBlockPropertyCollector,
+		NewValueSizeCollector,
+		NewTombstoneCountCollector,
+	}
+	const n = 10
+	ks := testkeys.Alpha(2)
+	r := writeTable(t, sstable.WriterOptions{
+		BlockSize:               1,
+		BlockPropertyCollectors: collectors,
+	}, func(w *sstable.Writer) error {
+		for i := 0; i < n; i++ {
+			if err := w.Set(testkeys.KeyAt(ks, i, 10+i), make([]byte, 5+i%5)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer func() { require.NoError(t, r.Close()) }()
+
+	// Each collector's table-level property is stored under its name,
+	// prefixed by the collector's index.
+	for i, newCollector := range collectors {
+		name := newCollector().Name()
+		prop, ok := r.Properties.UserProperties[name]
+		require.True(t, ok, name)
+		require.NotEmpty(t, prop, name)
+		require.Equal(t, byte(i), prop[0], name)
+	}
+
+	// Each table-level property excludes the whole table from filters that
+	// don't intersect it.
+	testCases := []struct {
+		filter sstable.BlockPropertyFilter
+		want   int
+	}{
+		{NewBlockPropertyFilter(10, 20), n},
+		{NewBlockPropertyFilter(15, 16), 1},
+		{NewBlockPropertyFilter(20, 30), -1},
+		{NewValueSizeFilter(5, 10), n},
+		{NewValueSizeFilter(10, math.MaxUint64), -1},
+		{NewTombstoneCountFilter(0, 1), n},
+		{NewTombstoneCountFilter(1, math.MaxUint64), -1},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, countFilteredKeys(t, r, tc.filter))
+	}
+}
+
+func TestTableSuffixBounds(t *testing.T) {
+	ks := testkeys.Alpha(2)
+	opts := sstable.WriterOptions{
+		TablePropertyCollectors: []func() sstable.TablePropertyCollector{
+			NewTableSuffixBoundsCollector,
+		},
+	}
+	writeKeys := func(keys ...[]byte) func(w *sstable.Writer) error {
+		return func(w *sstable.Writer) error {
+			for _, k := range keys {
+				if err := w.Set(k, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	testCases := []struct {
+		name     string
+		opts     sstable.WriterOptions
+		write    func(w *sstable.Writer) error
+		min, max uint64
+		ok       bool
+	}{
+		{
+			name:  "suffixed",
+			opts:  opts,
+			write: writeKeys(testkeys.KeyAt(ks, 0, 20), testkeys.KeyAt(ks, 1, 5), testkeys.KeyAt(ks, 2, 9)),
+			min:   5, max: 20, ok: true,
+		},
+		{
+			name:  "unsuffixed",
+			opts:  opts,
+			write: writeKeys(testkeys.KeyAt(ks, 0, 20), testkeys.Key(ks, 1)),
+			min:   0, max: math.MaxUint64, ok: true,
+		},
+		{
+			name: "range-keys",
+			opts: opts,
+			write: func(w *sstable.Writer) error {
+				if err := w.Set(testkeys.KeyAt(ks, 0, 7), nil); err != nil {
+					return err
+				}
+				return w.RangeKeySet(testkeys.Key(ks, 1), testkeys.Key(ks, 2), []byte("@3"), nil)
+			},
+			// Range keys are not reflected in the bounds.
+			min: 7, max: 7, ok: true,
+		},
+		{
+			name: "range-deletions",
+			opts: opts,
+			write: func(w *sstable.Writer) error {
+				return w.DeleteRange(testkeys.Key(ks, 1), testkeys.Key(ks, 2))
+			},
+			ok: false,
+		},
+		{
+			name:  "no-collector",
+			write: writeKeys(testkeys.KeyAt(ks, 0, 20)),
+			min:   0, max: math.MaxUint64, ok: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := writeTable(t, tc.opts, tc.write)
+			defer func() { require.NoError(t, r.Close()) }()
+			min, max, ok, err := TableSuffixBounds(r)
+			require.NoError(t, err)
+			require.Equal(t, tc.ok, ok)
+			if ok {
+				require.Equal(t, [2]uint64{tc.min, tc.max}, [2]uint64{min, max})
+			}
+		})
+	}
+}
+
+func TestTableSuffixBoundsSuffixReplacement(t *testing.T) {
+	c := NewTableSuffixBoundsCollector().(*tableSuffixBoundsCollector)
+	require.NoError(t, c.Add(pointKey("a@5"), nil))
+	require.NoError(t, c.UpdateKeySuffixes(nil, []byte("@5"), []byte("@12")))
+	props := map[string]string{}
+	require.NoError(t, c.Finish(props))
+	r := &sstable.Reader{}
+	r.Properties.UserProperties = props
+	min, max, ok, err := TableSuffixBounds(r)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, [2]uint64{12, 12}, [2]uint64{min, max})
+}
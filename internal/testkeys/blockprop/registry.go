@@ -0,0 +1,165 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blockprop
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// Registration describes a named block property collector and the
+// constructor for its corresponding filter.
+type Registration struct {
+	// Name is the name under which the collector was registered.
+	Name string
+	// NewCollector constructs a new instance of the collector.
+	NewCollector func() sstable.BlockPropertyCollector
+	// NewFilter constructs a filter over the collector's properties from a
+	// collector-specific argument string.
+	NewFilter func(args string) (sstable.BlockPropertyFilter, error)
+}
+
+var registry struct {
+	sync.Mutex
+	m map[string]Registration
+}
+
+// Register makes a block property collector and its corresponding filter
+// constructor available by the provided name. It panics if a collector is
+// already registered with the name, or if either constructor is nil.
+func Register(
+	name string,
+	newCollector func() sstable.BlockPropertyCollector,
+	newFilter func(args string) (sstable.BlockPropertyFilter, error),
+) {
+	if newCollector == nil || newFilter == nil {
+		panic(fmt.Sprintf("blockprop: nil constructor registered for %q", name))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.m[name]; ok {
+		panic(fmt.Sprintf("blockprop: %q registered twice", name))
+	}
+	if registry.m == nil {
+		registry.m = make(map[string]Registration)
+	}
+	registry.m[name] = Registration{
+		Name:         name,
+		NewCollector: newCollector,
+		NewFilter:    newFilter,
+	}
+}
+
+// Lookup returns the registration for the provided name, and whether one
+// exists.
+func Lookup(name string) (Registration, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	r, ok := registry.m[name]
+	return r, ok
+}
+
+// Registered returns the sorted names of all registered collectors.
+func Registered() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	names := make([]string, 0, len(registry.m))
+	for name := range registry.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCollectors returns the collector constructors registered under the
+// provided names, in order, suitable for use as
+// sstable.WriterOptions.BlockPropertyCollectors.
+func NewCollectors(names ...string) ([]func() sstable.BlockPropertyCollector, error) {
+	collectors := make([]func() sstable.BlockPropertyCollector, len(names))
+	for i, name := range names {
+		r, ok := Lookup(name)
+		if !ok {
+			return nil, errors.Errorf("blockprop: unknown collector %q", name)
+		}
+		collectors[i] = r.NewCollector
+	}
+	return collectors, nil
+}
+
+// ParseFilter constructs a filter from a spec of the form `<name>:<args>`,
+// where name is the name of a registered collector and args is passed to the
+// collector's filter constructor. The `:<args>` portion may be omitted, in
+// which case the filter constructor is passed the empty string.
+func ParseFilter(spec string) (sstable.BlockPropertyFilter, error) {
+	name, args := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		name, args = spec[:i], spec[i+1:]
+	}
+	r, ok := Lookup(name)
+	if !ok {
+		return nil, errors.Errorf("blockprop: unknown collector %q", name)
+	}
+	f, err := r.NewFilter(args)
+	if err != nil {
+		return nil, errors.Wrapf(err, "blockprop: parsing %q filter", name)
+	}
+	return f, nil
+}
+
+// parseInterval parses an interval of the form `<lower>,<upper>`.
+func parseInterval(args string) (lower, upper uint64, err error) {
+	fields := strings.Split(args, ",")
+	if len(fields) != 2 {
+		return 0, 0, errors.Errorf("expected interval of the form <lower>,<upper>, found %q", args)
+	}
+	if lower, err = strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if upper, err = strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return lower, upper, nil
+}
+
+func init() {
+	Register("suffix", NewBlockPropertyCollector,
+		func(args string) (sstable.BlockPropertyFilter, error) {
+			lower, upper, err := parseInterval(args)
+			if err != nil {
+				return nil, err
+			}
+			return NewBlockPropertyFilter(lower, upper), nil
+		})
+	Register("suffixset", NewSuffixSetCollector(DefaultMaxSuffixIntervals),
+		func(args string) (sstable.BlockPropertyFilter, error) {
+			lower, upper, err := parseInterval(args)
+			if err != nil {
+				return nil, err
+			}
+			return NewSuffixSetFilter(lower, upper), nil
+		})
+	Register("valuesize", NewValueSizeCollector,
+		func(args string) (sstable.BlockPropertyFilter, error) {
+			lower, upper, err := parseInterval(args)
+			if err != nil {
+				return nil, err
+			}
+			return NewValueSizeFilter(lower, upper), nil
+		})
+	Register("tombstones", NewTombstoneCountCollector,
+		func(args string) (sstable.BlockPropertyFilter, error) {
+			lower, upper, err := parseInterval(args)
+			if err != nil {
+				return nil, err
+			}
+			return NewTombstoneCountFilter(lower, upper), nil
+		})
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blockprop
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+const suffixSetPropertyName = `pebble.internal.testkeys.suffixset`
+
+// DefaultMaxSuffixIntervals is the default maximum number of disjoint
+// intervals maintained by a suffix set collector for a single block.
+const DefaultMaxSuffixIntervals = 4
+
+// NewSuffixSetCollector constructs a sstable property collector over testkey
+// suffixes that maintains up to maxIntervals disjoint [lower, upper) intervals
+// per block, rather than the single interval maintained by the collector
+// returned by NewBlockPropertyCollector. If a block's suffixes require more
+// than maxIntervals intervals, the block's property falls back to the single
+// coarse interval [min, max+1).
+func NewSuffixSetCollector(maxIntervals int) func() sstable.BlockPropertyCollector {
+	if maxIntervals <= 0 {
+		maxIntervals = DefaultMaxSuffixIntervals
+	}
+	return func() sstable.BlockPropertyCollector {
+		return &suffixSetCollector{maxIntervals: maxIntervals}
+	}
+}
+
+// NewSuffixSetFilter constructs a new block-property filter that operates on
+// the properties collected by a suffix set collector. It excludes blocks
+// containing exclusively suffixed keys whose suffixes all fall outside of the
+// range [filterMin, filterMax), including blocks whose suffixes fall on either
+// side of the range.
+func NewSuffixSetFilter(filterMin, filterMax uint64) *SuffixSetFilter {
+	return &SuffixSetFilter{lower: filterMin, upper: filterMax}
+}
+
+// SuffixSetFilter implements sstable.BlockPropertyFilter over the properties
+// collected by a suffix set collector.
+type SuffixSetFilter struct {
+	lower, upper uint64
+}
+
+var _ sstable.BlockPropertyFilter = (*SuffixSetFilter)(nil)
+
+// Name implements the BlockPropertyFilter interface.
+func (f *SuffixSetFilter) Name() string {
+	return suffixSetPropertyName
+}
+
+// Intersects implements the BlockPropertyFilter interface.
+func (f *SuffixSetFilter) Intersects(prop []byte) (bool, error) {
+	if f.lower >= f.upper {
+		return false, nil
+	}
+	var set suffixSet
+	if err := set.decode(prop); err != nil {
+		return false, err
+	}
+	// The intervals are sorted and disjoint. Find the first interval with an
+	// upper bound above the filter's lower bound.
+	i := sort.Search(len(set), func(i int) bool { return set[i].upper > f.lower })
+	return i < len(set) && set[i].lower < f.upper, nil
+}
+
+// SetInterval adjusts the [lower, upper) bounds used by the filter. It is not
+// generally safe to alter the filter while it's in use.
+func (f *SuffixSetFilter) SetInterval(lower, upper uint64) {
+	f.lower, f.upper = lower, upper
+}
+
+type suffixInterval struct {
+	lower, upper uint64
+}
+
+// suffixSet is a sorted list of disjoint, non-adjacent, non-empty
+// [lower, upper) intervals.
+//
+// The set is encoded as a sequence of varint pairs, one per interval. The
+// first integer of each pair is the distance from the previous interval's
+// upper bound (or zero for the first interval) to the interval's lower bound,
+// and the second integer is the interval's width. The empty set is encoded as
+// the empty byte slice.
+type suffixSet []suffixInterval
+
+// add adds the interval x to the set, merging it with any intervals it
+// overlaps or abuts.
+func (s *suffixSet) add(x suffixInterval) {
+	if x.lower >= x.upper {
+		return
+	}
+	set := *s
+	// Find the first interval that overlaps or abuts x, and the first interval
+	// beyond x.
+	i := sort.Search(len(set), func(i int) bool { return set[i].upper >= x.lower })
+	j := i
+	for j < len(set) && set[j].lower <= x.upper {
+		if set[j].lower < x.lower {
+			x.lower = set[j].lower
+		}
+		if set[j].upper > x.upper {
+			x.upper = set[j].upper
+		}
+		j++
+	}
+	if i == j {
+		// Insert x at index i.
+		set = append(set, suffixInterval{})
+		copy(set[i+1:], set[i:])
+		set[i] = x
+	} else {
+		// Replace set[i:j] with x.
+		set[i] = x
+		set = append(set[:i+1], set[j:]...)
+	}
+	*s = set
+}
+
+// union adds all the intervals in x to the set. If the resulting set contains
+// more than maxIntervals intervals, it's collapsed into a single interval.
+func (s *suffixSet) union(x suffixSet, maxIntervals int) {
+	for i := range x {
+		s.add(x[i])
+	}
+	s.coarsen(maxIntervals)
+}
+
+// coarsen collapses the set into the single interval [min, max) if it
+// contains more than maxIntervals intervals.
+func (s *suffixSet) coarsen(maxIntervals int) {
+	set := *s
+	if len(set) > maxIntervals {
+		set[0].upper = set[len(set)-1].upper
+		*s = set[:1]
+	}
+}
+
+func (s suffixSet) encode(buf []byte) []byte {
+	var encoded [binary.MaxVarintLen64 * 2]byte
+	var prevUpper uint64
+	for i := range s {
+		n := binary.PutUvarint(encoded[:], s[i].lower-prevUpper)
+		n += binary.PutUvarint(encoded[n:], s[i].upper-s[i].lower)
+		buf = append(buf, encoded[:n]...)
+		prevUpper = s[i].upper
+	}
+	return buf
+}
+
+func (s *suffixSet) decode(buf []byte) error {
+	set := (*s)[:0]
+	var prevUpper uint64
+	for len(buf) > 0 {
+		delta, n := binary.Uvarint(buf)
+		if n <= 0 || n >= len(buf) {
+			return base.CorruptionErrorf("cannot decode suffix set from buf %x", buf)
+		}
+		buf = buf[n:]
+		width, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return base.CorruptionErrorf("cannot decode suffix set from buf %x", buf)
+		}
+		buf = buf[n:]
+		x := suffixInterval{lower: prevUpper + delta}
+		x.upper = x.lower + width
+		if x.lower < prevUpper || x.upper <= x.lower {
+			return base.CorruptionErrorf("unexpected overflow decoding suffix set interval [%d, %d)",
+				x.lower, x.upper)
+		}
+		set = append(set, x)
+		prevUpper = x.upper
+	}
+	*s = set
+	return nil
+}
+
+// suffixSetCollector implements sstable.BlockPropertyCollector, maintaining a
+// set of up to maxIntervals disjoint intervals over the timestamps in
+// MVCC-like suffixes for point keys (e.g. foo@123). Range keys are ignored.
+type suffixSetCollector struct {
+	maxIntervals int
+	// overflowed is set when the current data block's set exceeded
+	// maxIntervals and was collapsed into a single coarse interval. Subsequent
+	// suffixes in the same block extend the coarse interval.
+	overflowed bool
+
+	blockSet suffixSet
+	prevSet  suffixSet
+	indexSet suffixSet
+	tableSet suffixSet
+}
+
+var _ sstable.BlockPropertyCollector = (*suffixSetCollector)(nil)
+
+// Name implements the BlockPropertyCollector interface.
+func (c *suffixSetCollector) Name() string {
+	return suffixSetPropertyName
+}
+
+// Add implements the BlockPropertyCollector interface by adding the timestamp
+// in the suffix of this point key to the current block's set.
+func (c *suffixSetCollector) Add(key base.InternalKey, value []byte) error {
+	if rangekey.IsRangeKey(key.Kind()) {
+		return nil
+	}
+	x := suffixInterval{lower: 0, upper: math.MaxUint64}
+	if i := testkeys.Comparer.Split(key.UserKey); i < len(key.UserKey) {
+		ts, err := testkeys.ParseSuffix(key.UserKey[i:])
+		if err != nil {
+			return err
+		}
+		x = suffixInterval{lower: uint64(ts), upper: uint64(ts) + 1}
+	}
+	if c.overflowed {
+		if x.lower < c.blockSet[0].lower {
+			c.blockSet[0].lower = x.lower
+		}
+		if x.upper > c.blockSet[0].upper {
+			c.blockSet[0].upper = x.upper
+		}
+		return nil
+	}
+	c.blockSet.add(x)
+	if len(c.blockSet) > c.maxIntervals {
+		c.blockSet.coarsen(c.maxIntervals)
+		c.overflowed = true
+	}
+	return nil
+}
+
+// FinishDataBlock implements the BlockPropertyCollector interface.
+func (c *suffixSetCollector) FinishDataBlock(buf []byte) ([]byte, error) {
+	buf = c.blockSet.encode(buf)
+	c.tableSet.union(c.blockSet, c.maxIntervals)
+	// Retain the block's set until AddPrevDataBlockToIndexBlock, and reset the
+	// current block's set while reusing the previous block's memory.
+	c.prevSet, c.blockSet = c.blockSet, c.prevSet[:0]
+	c.overflowed = false
+	return buf, nil
+}
+
+// AddPrevDataBlockToIndexBlock implements the BlockPropertyCollector
+// interface.
+func (c *suffixSetCollector) AddPrevDataBlockToIndexBlock() {
+	c.indexSet.union(c.prevSet, c.maxIntervals)
+	c.prevSet = c.prevSet[:0]
+}
+
+// FinishIndexBlock implements the BlockPropertyCollector interface.
+func (c *suffixSetCollector) FinishIndexBlock(buf []byte) ([]byte, error) {
+	buf = c.indexSet.encode(buf)
+	c.indexSet = c.indexSet[:0]
+	return buf, nil
+}
+
+// FinishTable implements the BlockPropertyCollector interface.
+func (c *suffixSetCollector) FinishTable(buf []byte) ([]byte, error) {
+	return c.tableSet.encode(buf), nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blockprop
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+const tableSuffixBoundsPropertyName = `pebble.internal.testkeys.suffixbounds`
+
+// NewTableSuffixBoundsCollector constructs a sstable table property collector
+// that records the minimum and maximum testkey suffixes within the table. The
+// bounds may be read back through TableSuffixBounds, allowing a time-bounded
+// scan to skip an sstable without reading its index.
+//
+// The bounds cover point keys only. Unsuffixed point keys widen the bounds to
+// [0, math.MaxUint64]. Range deletions are not suffixed and are ignored. Range
+// keys are not passed to table property collectors by the sstable.Writer, so
+// the bounds do not reflect range key suffixes.
+func NewTableSuffixBoundsCollector() sstable.TablePropertyCollector {
+	return &tableSuffixBoundsCollector{}
+}
+
+// TableSuffixBounds returns the inclusive [min, max] bounds on the suffixes
+// within the table, as recorded by the collector returned by
+// NewTableSuffixBoundsCollector. If the table contains no point keys, ok is
+// false. Since unsuffixed point keys widen the bounds to [0, math.MaxUint64],
+// a table containing only unsuffixed point keys has ok true. If the table was
+// not written with the collector, ok is true and the bounds are
+// [0, math.MaxUint64], since any suffix may be present.
+func TableSuffixBounds(r *sstable.Reader) (min, max uint64, ok bool, err error) {
+	prop, present := r.Properties.UserProperties[tableSuffixBoundsPropertyName]
+	if !present {
+		return 0, math.MaxUint64, true, nil
+	}
+	if len(prop) == 0 {
+		return 0, 0, false, nil
+	}
+	buf := []byte(prop)
+	min, n := binary.Uvarint(buf)
+	if n <= 0 || n >= len(buf) {
+		return 0, 0, false, base.CorruptionErrorf("cannot decode suffix bounds from %x", buf)
+	}
+	delta, m := binary.Uvarint(buf[n:])
+	if m <= 0 || n+m != len(buf) || min+delta < min {
+		return 0, 0, false, base.CorruptionErrorf("cannot decode suffix bounds from %x", buf)
+	}
+	return min, min + delta, true, nil
+}
+
+// tableSuffixBoundsCollector implements sstable.TablePropertyCollector and
+// sstable.SuffixReplaceableTableCollector, maintaining the inclusive bounds
+// of the timestamps in MVCC-like suffixes for keys (e.g. foo@123).
+type tableSuffixBoundsCollector struct {
+	initialized bool
+	min, max    uint64
+}
+
+var _ sstable.SuffixReplaceableTableCollector = (*tableSuffixBoundsCollector)(nil)
+
+// Add implements the TablePropertyCollector interface.
+func (c *tableSuffixBoundsCollector) Add(key sstable.InternalKey, value []byte) error {
+	if key.Kind() == base.InternalKeyKindRangeDelete {
+		return nil
+	}
+	i := testkeys.Comparer.Split(key.UserKey)
+	if i == len(key.UserKey) {
+		c.widen(0)
+		c.widen(math.MaxUint64)
+		return nil
+	}
+	return c.addSuffix(key.UserKey[i:])
+}
+
+func (c *tableSuffixBoundsCollector) addSuffix(suffix []byte) error {
+	ts, err := testkeys.ParseSuffix(suffix)
+	if err != nil {
+		return err
+	}
+	c.widen(uint64(ts))
+	return nil
+}
+
+func (c *tableSuffixBoundsCollector) widen(ts uint64) {
+	if !c.initialized {
+		c.min, c.max = ts, ts
+		c.initialized = true
+		return
+	}
+	if ts < c.min {
+		c.min = ts
+	}
+	if ts > c.max {
+		c.max = ts
+	}
+}
+
+// Finish implements the TablePropertyCollector interface.
+func (c *tableSuffixBoundsCollector) Finish(userProps map[string]string) error {
+	var prop string
+	if c.initialized {
+		var buf [binary.MaxVarintLen64 * 2]byte
+		n := binary.PutUvarint(buf[:], c.min)
+		n += binary.PutUvarint(buf[n:], c.max-c.min)
+		prop = string(buf[:n])
+	}
+	// NB: The property is populated even if empty, since its presence
+	// indicates that the collector was used when writing.
+	userProps[tableSuffixBoundsPropertyName] = prop
+	return nil
+}
+
+// Name implements the TablePropertyCollector interface.
+func (c *tableSuffixBoundsCollector) Name() string {
+	return tableSuffixBoundsPropertyName
+}
+
+// UpdateKeySuffixes implements the SuffixReplaceableTableCollector interface.
+// All keys within the table share the new suffix, so the bounds collapse to
+// the new suffix.
+func (c *tableSuffixBoundsCollector) UpdateKeySuffixes(
+	oldProps map[string]string, oldSuffix, newSuffix []byte,
+) error {
+	c.initialized = false
+	return c.addSuffix(newSuffix)
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blockprop
+
+import (
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+const tombstoneCountPropertyName = `pebble.internal.testkeys.tombstones`
+
+// NewTombstoneCountCollector constructs a sstable property collector over the
+// number of point tombstones (DELETE and SINGLEDEL keys) in each data block.
+//
+// A data block containing n point tombstones is represented by the interval
+// [n, n+1). Index blocks and tables are represented by the union of their data
+// blocks' intervals, ie, [min, max+1) where min and max are the minimum and
+// maximum number of tombstones contained within any one data block.
+func NewTombstoneCountCollector() sstable.BlockPropertyCollector {
+	return sstable.NewBlockIntervalCollector(
+		tombstoneCountPropertyName,
+		&tombstoneCountIntervalCollector{},
+		nil)
+}
+
+// NewTombstoneCountFilter constructs a new block-property filter that excludes
+// blocks containing a number of point tombstones outside of the range
+// [filterMin, filterMax). For example, a filter over [1, math.MaxUint64)
+// excludes blocks containing no tombstones.
+//
+// Since the filter examines key kinds, it may surface deleted keys if a block
+// containing a key's tombstone is filtered while a block containing the
+// deleted key is not. See the comment on sstable.BlockPropertyFilter.
+func NewTombstoneCountFilter(filterMin, filterMax uint64) *sstable.BlockIntervalFilter {
+	return sstable.NewBlockIntervalFilter(tombstoneCountPropertyName, filterMin, filterMax)
+}
+
+var _ sstable.DataBlockIntervalCollector = (*tombstoneCountIntervalCollector)(nil)
+
+// tombstoneCountIntervalCollector counts the point tombstones in a data block.
+type tombstoneCountIntervalCollector struct {
+	count uint64
+}
+
+// Add implements DataBlockIntervalCollector by incrementing the count of
+// tombstones if the key is a DELETE or SINGLEDEL.
+func (c *tombstoneCountIntervalCollector) Add(key base.InternalKey, value []byte) error {
+	switch key.Kind() {
+	case base.InternalKeyKindDelete, base.InternalKeyKindSingleDelete:
+		c.count++
+	}
+	return nil
+}
+
+// FinishDataBlock implements DataBlockIntervalCollector.
+func (c *tombstoneCountIntervalCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	n := c.count
+	c.count = 0
+	return n, n + 1, nil
+}
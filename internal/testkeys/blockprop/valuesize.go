@@ -0,0 +1,90 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blockprop
+
+import (
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+const valueSizePropertyName = `pebble.internal.testkeys.valuesize`
+
+// NewValueSizeCollector constructs a sstable property collector over the
+// lengths of values.
+//
+// Point keys contribute the length of their value. A range key set contributes
+// the length of each of the logical values it encodes, one per suffix. Range
+// key unsets and deletes have no values and are ignored.
+func NewValueSizeCollector() sstable.BlockPropertyCollector {
+	return sstable.NewBlockIntervalCollector(
+		valueSizePropertyName,
+		&valueSizeIntervalCollector{},
+		&valueSizeIntervalCollector{})
+}
+
+// NewValueSizeFilter constructs a new block-property filter that excludes
+// blocks containing exclusively values with lengths that fall outside of the
+// range [filterMin, filterMax).
+//
+// Since the filter examines values, it may surface deleted keys if a block
+// containing a key's tombstone is filtered while a block containing the
+// deleted key is not. See the comment on sstable.BlockPropertyFilter.
+func NewValueSizeFilter(filterMin, filterMax uint64) *sstable.BlockIntervalFilter {
+	return sstable.NewBlockIntervalFilter(valueSizePropertyName, filterMin, filterMax)
+}
+
+var _ sstable.DataBlockIntervalCollector = (*valueSizeIntervalCollector)(nil)
+
+// valueSizeIntervalCollector maintains an interval over the lengths of values.
+type valueSizeIntervalCollector struct {
+	initialized  bool
+	lower, upper uint64
+	keysBuf      []keyspan.Key
+}
+
+// Add implements DataBlockIntervalCollector by adding the length(s) of the
+// value(s) in this record to the current interval.
+func (c *valueSizeIntervalCollector) Add(key base.InternalKey, value []byte) error {
+	switch key.Kind() {
+	case base.InternalKeyKindRangeKeySet:
+		s, err := rangekey.Decode(key, value, c.keysBuf[:0])
+		if err != nil {
+			return err
+		}
+		for i := range s.Keys {
+			c.addLen(uint64(len(s.Keys[i].Value)))
+		}
+		c.keysBuf = s.Keys[:0]
+	case base.InternalKeyKindRangeKeyUnset, base.InternalKeyKindRangeKeyDelete:
+		// Range key unsets and deletes don't encode any values.
+	default:
+		c.addLen(uint64(len(value)))
+	}
+	return nil
+}
+
+func (c *valueSizeIntervalCollector) addLen(n uint64) {
+	if !c.initialized {
+		c.lower, c.upper = n, n+1
+		c.initialized = true
+		return
+	}
+	if n < c.lower {
+		c.lower = n
+	}
+	if n >= c.upper {
+		c.upper = n + 1
+	}
+}
+
+// FinishDataBlock implements DataBlockIntervalCollector.
+func (c *valueSizeIntervalCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	l, u := c.lower, c.upper
+	c.lower, c.upper = 0, 0
+	c.initialized = false
+	return l, u, nil
+}
@@ -76,6 +76,11 @@ var Comparer *base.Comparer = &base.Comparer{
 		// The successor is > a[:ai], so we only need to add the sentinel.
 		return append(dst, 0)
 	},
+	ImmediateSuccessor: func(dst, prefix []byte) []byte {
+		// Every key with the prefix sorts before the prefix with a 0x00 byte
+		// appended, since keys are ordered by prefix first.
+		return append(append(dst, prefix...), 0x00)
+	},
 	Split: split,
 	Name:  "pebble.internal.testkeys",
 }
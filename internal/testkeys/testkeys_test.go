@@ -177,6 +177,22 @@ func TestSuffixLen(t *testing.T) {
 	}
 }
 
+func TestImmediateSuccessor(t *testing.T) {
+	succ := Comparer.ImmediateSuccessor(nil, []byte("foo"))
+	// Every suffixed version of the prefix sorts before the successor.
+	for _, k := range []string{"foo", "foo@1", "foo@5", "foo@100"} {
+		require.Less(t, Comparer.Compare([]byte(k), succ), 0, k)
+	}
+	// Keys with a different prefix that share "foo" as a byte prefix sort
+	// after the successor, which itself doesn't exceed the next prefix.
+	require.Less(t, Comparer.Compare(succ, []byte("foobar")), 0)
+	require.Less(t, Comparer.Compare(succ, []byte("foobar@1")), 0)
+	require.LessOrEqual(t, Comparer.Compare(succ, []byte("fop")), 0)
+
+	// The successor is appended to dst.
+	require.Equal(t, []byte("xfoo\x00"), Comparer.ImmediateSuccessor([]byte("x"), []byte("foo")))
+}
+
 func TestDivvy(t *testing.T) {
 	var buf bytes.Buffer
 	datadriven.RunTest(t, "testdata/divvy", func(d *datadriven.TestData) string {
@@ -0,0 +1,273 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// InvariantIter wraps an Iterator, asserting after each positioning operation
+// that the Iterator behaved as its contract requires, and panicking with a
+// description of the violation otherwise. It's intended for the tests of
+// custom Comparers and Mergers, whose bugs surface as misbehaving iterators.
+// InvariantIter checks that:
+//   - Next and NextPrefix move to a key after the previous key, and Prev and
+//     PrevPrefix to a key before it. NextPrefix and PrevPrefix also move to a
+//     new prefix.
+//   - SeekGE and SeekPrefixGE move to a key at or after the seek key, with
+//     SeekPrefixGE remaining within the seek key's prefix, and SeekLT moves
+//     to a key before the seek key.
+//   - Every key lies within the Iterator's bounds.
+//   - The contents of the slices returned by Key and Value aren't modified
+//     before the Iterator is repositioned.
+//
+// The *WithLimit variants of the positioning operations, and the Iterator's
+// other methods, pass through unchecked.
+type InvariantIter struct {
+	*Iterator
+	formatKey base.FormatKey
+	// valid is true if the Iterator was positioned at a key following the
+	// last positioning operation, which key and value hold copies of.
+	valid    bool
+	hasPoint bool
+	key      []byte
+	value    []byte
+}
+
+// NewInvariantIter returns an InvariantIter wrapping the provided Iterator.
+// The Iterator must not be used directly while it's wrapped.
+func NewInvariantIter(it *Iterator) *InvariantIter {
+	formatKey := base.DefaultFormatter
+	if it.readState != nil {
+		formatKey = it.readState.db.opts.Comparer.FormatKey
+	}
+	return &InvariantIter{Iterator: it, formatKey: formatKey}
+}
+
+// SeekGE implements Iterator.SeekGE, checking that the Iterator moved to a key
+// at or after key.
+func (i *InvariantIter) SeekGE(key []byte) bool {
+	i.checkUnmodified("SeekGE")
+	valid := i.Iterator.SeekGE(key)
+	i.checkPosition("SeekGE", valid)
+	if valid && i.cmp(i.Iterator.Key(), key) < 0 {
+		i.failf("SeekGE(%s) moved to %s, which sorts before the seek key",
+			i.formatKey(key), i.formatKey(i.Iterator.Key()))
+	}
+	return i.record(valid)
+}
+
+// SeekPrefixGE implements Iterator.SeekPrefixGE, checking that the Iterator
+// moved to a key at or after key that shares its prefix.
+func (i *InvariantIter) SeekPrefixGE(key []byte) bool {
+	i.checkUnmodified("SeekPrefixGE")
+	valid := i.Iterator.SeekPrefixGE(key)
+	i.checkPosition("SeekPrefixGE", valid)
+	if valid {
+		k := i.Iterator.Key()
+		if i.cmp(k, key) < 0 {
+			i.failf("SeekPrefixGE(%s) moved to %s, which sorts before the seek key",
+				i.formatKey(key), i.formatKey(k))
+		}
+		if i.split != nil && !i.equal(k[:i.split(k)], key[:i.split(key)]) {
+			i.failf("SeekPrefixGE(%s) moved to %s, which has a different prefix",
+				i.formatKey(key), i.formatKey(k))
+		}
+	}
+	return i.record(valid)
+}
+
+// SeekLT implements Iterator.SeekLT, checking that the Iterator moved to a key
+// before key.
+func (i *InvariantIter) SeekLT(key []byte) bool {
+	i.checkUnmodified("SeekLT")
+	valid := i.Iterator.SeekLT(key)
+	i.checkPosition("SeekLT", valid)
+	if valid && i.cmp(i.Iterator.Key(), key) >= 0 {
+		i.failf("SeekLT(%s) moved to %s, which doesn't sort before the seek key",
+			i.formatKey(key), i.formatKey(i.Iterator.Key()))
+	}
+	return i.record(valid)
+}
+
+// First implements Iterator.First.
+func (i *InvariantIter) First() bool {
+	i.checkUnmodified("First")
+	valid := i.Iterator.First()
+	i.checkPosition("First", valid)
+	return i.record(valid)
+}
+
+// Last implements Iterator.Last.
+func (i *InvariantIter) Last() bool {
+	i.checkUnmodified("Last")
+	valid := i.Iterator.Last()
+	i.checkPosition("Last", valid)
+	return i.record(valid)
+}
+
+// Next implements Iterator.Next, checking that the Iterator moved to a key
+// after the previous key.
+func (i *InvariantIter) Next() bool {
+	i.checkUnmodified("Next")
+	valid := i.Iterator.Next()
+	i.checkPosition("Next", valid)
+	if valid && i.valid && i.cmp(i.Iterator.Key(), i.key) <= 0 {
+		i.failf("Next moved from %s to %s, which doesn't sort after it",
+			i.formatKey(i.key), i.formatKey(i.Iterator.Key()))
+	}
+	return i.record(valid)
+}
+
+// NextPrefix implements Iterator.NextPrefix, checking that the Iterator moved
+// to a key after the previous key with a different prefix.
+func (i *InvariantIter) NextPrefix() bool {
+	i.checkUnmodified("NextPrefix")
+	valid := i.Iterator.NextPrefix()
+	i.checkPosition("NextPrefix", valid)
+	if valid && i.valid {
+		k := i.Iterator.Key()
+		if i.cmp(k, i.key) <= 0 {
+			i.failf("NextPrefix moved from %s to %s, which doesn't sort after it",
+				i.formatKey(i.key), i.formatKey(k))
+		}
+		if i.split != nil && i.equal(k[:i.split(k)], i.key[:i.split(i.key)]) {
+			i.failf("NextPrefix moved from %s to %s, which has the same prefix",
+				i.formatKey(i.key), i.formatKey(k))
+		}
+	}
+	return i.record(valid)
+}
+
+// Prev implements Iterator.Prev, checking that the Iterator moved to a key
+// before the previous key.
+func (i *InvariantIter) Prev() bool {
+	i.checkUnmodified("Prev")
+	valid := i.Iterator.Prev()
+	i.checkPosition("Prev", valid)
+	if valid && i.valid && i.cmp(i.Iterator.Key(), i.key) >= 0 {
+		i.failf("Prev moved from %s to %s, which doesn't sort before it",
+			i.formatKey(i.key), i.formatKey(i.Iterator.Key()))
+	}
+	return i.record(valid)
+}
+
+// PrevPrefix implements Iterator.PrevPrefix, checking that the Iterator moved
+// to a key before the previous key with a different prefix.
+func (i *InvariantIter) PrevPrefix() bool {
+	i.checkUnmodified("PrevPrefix")
+	valid := i.Iterator.PrevPrefix()
+	i.checkPosition("PrevPrefix", valid)
+	if valid && i.valid {
+		k := i.Iterator.Key()
+		if i.cmp(k, i.key) >= 0 {
+			i.failf("PrevPrefix moved from %s to %s, which doesn't sort before it",
+				i.formatKey(i.key), i.formatKey(k))
+		}
+		if i.split != nil && i.equal(k[:i.split(k)], i.key[:i.split(i.key)]) {
+			i.failf("PrevPrefix moved from %s to %s, which has the same prefix",
+				i.formatKey(i.key), i.formatKey(k))
+		}
+	}
+	return i.record(valid)
+}
+
+// SeekGEWithLimit implements Iterator.SeekGEWithLimit.
+func (i *InvariantIter) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
+	state := i.Iterator.SeekGEWithLimit(key, limit)
+	i.record(state == IterValid)
+	return state
+}
+
+// SeekLTWithLimit implements Iterator.SeekLTWithLimit.
+func (i *InvariantIter) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
+	state := i.Iterator.SeekLTWithLimit(key, limit)
+	i.record(state == IterValid)
+	return state
+}
+
+// NextWithLimit implements Iterator.NextWithLimit.
+func (i *InvariantIter) NextWithLimit(limit []byte) IterValidityState {
+	state := i.Iterator.NextWithLimit(limit)
+	i.record(state == IterValid)
+	return state
+}
+
+// PrevWithLimit implements Iterator.PrevWithLimit.
+func (i *InvariantIter) PrevWithLimit(limit []byte) IterValidityState {
+	state := i.Iterator.PrevWithLimit(limit)
+	i.record(state == IterValid)
+	return state
+}
+
+// SetBounds implements Iterator.SetBounds.
+func (i *InvariantIter) SetBounds(lower, upper []byte) {
+	i.Iterator.SetBounds(lower, upper)
+	i.valid = false
+}
+
+// SetOptions implements Iterator.SetOptions.
+func (i *InvariantIter) SetOptions(o *IterOptions) {
+	i.Iterator.SetOptions(o)
+	i.valid = false
+}
+
+// checkUnmodified checks that the contents of the current key and value
+// haven't changed since the Iterator was positioned.
+func (i *InvariantIter) checkUnmodified(op string) {
+	if !i.valid {
+		return
+	}
+	if !bytes.Equal(i.Iterator.Key(), i.key) {
+		i.failf("%s: key %s was modified to %s while the iterator was positioned at it",
+			op, i.formatKey(i.key), i.formatKey(i.Iterator.Key()))
+	}
+	if i.hasPoint && !bytes.Equal(i.Iterator.Value(), i.value) {
+		i.failf("%s: value %q of key %s was modified to %q while the iterator was positioned at it",
+			op, i.value, i.formatKey(i.key), i.Iterator.Value())
+	}
+}
+
+// checkPosition checks that the key the Iterator is positioned at, if any,
+// lies within its bounds.
+func (i *InvariantIter) checkPosition(op string, valid bool) {
+	if valid != i.Iterator.Valid() {
+		i.failf("%s returned %t, but Valid returns %t", op, valid, i.Iterator.Valid())
+	}
+	if !valid {
+		return
+	}
+	k := i.Iterator.Key()
+	if lower := i.opts.LowerBound; lower != nil && i.cmp(k, lower) < 0 {
+		i.failf("%s moved to %s, which sorts before the lower bound %s",
+			op, i.formatKey(k), i.formatKey(lower))
+	}
+	if upper := i.opts.UpperBound; upper != nil && i.cmp(k, upper) >= 0 {
+		i.failf("%s moved to %s, which doesn't sort before the upper bound %s",
+			op, i.formatKey(k), i.formatKey(upper))
+	}
+}
+
+// record copies the key and value the Iterator is positioned at, to be
+// checked by the next positioning operation.
+func (i *InvariantIter) record(valid bool) bool {
+	i.valid = valid
+	i.hasPoint = false
+	if valid {
+		i.key = append(i.key[:0], i.Iterator.Key()...)
+		i.hasPoint, _ = i.Iterator.HasPointAndRange()
+		if i.hasPoint {
+			i.value = append(i.value[:0], i.Iterator.Value()...)
+		}
+	}
+	return valid
+}
+
+func (i *InvariantIter) failf(format string, args ...interface{}) {
+	panic(fmt.Sprintf("pebble: iterator invariant violated: "+format, args...))
+}
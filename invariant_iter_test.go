@@ -0,0 +1,90 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvariantIter(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("000"), nil, nil))
+	for i := 0; i < 200; i++ {
+		k := []byte(fmt.Sprintf("%03d", rng.Intn(100)))
+		require.NoError(t, d.Set(k, k, nil))
+		if i == 100 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// A correctly behaving iterator passes the checks.
+	key := func() []byte { return []byte(fmt.Sprintf("%03d", rng.Intn(110))) }
+	iter := NewInvariantIter(d.NewIter(&IterOptions{LowerBound: []byte("010"), UpperBound: []byte("090")}))
+	for i := 0; i < 1000; i++ {
+		switch rng.Intn(8) {
+		case 0:
+			iter.SeekGE(key())
+		case 1:
+			iter.SeekLT(key())
+		case 2:
+			iter.First()
+		case 3:
+			iter.Last()
+		case 4, 5:
+			iter.Next()
+		case 6, 7:
+			iter.Prev()
+		}
+	}
+	require.NoError(t, iter.Close())
+
+	// Modifying the current key is caught by the next positioning operation.
+	// The key may alias the memtable's contents, so it's restored afterwards.
+	iter = NewInvariantIter(d.NewIter(nil))
+	require.True(t, iter.First())
+	iter.Key()[0] = 'x'
+	require.PanicsWithValue(t,
+		`pebble: iterator invariant violated: Next: key 000 was modified to x00 while the iterator was positioned at it`,
+		func() { iter.Next() })
+	iter.Key()[0] = '0'
+	require.NoError(t, iter.Close())
+}
+
+func TestInvariantIterBuggyComparer(t *testing.T) {
+	// The buggy comparer orders keys by their first byte, but considers keys
+	// with the same first byte unequal. The memtable orders "a2" before "a1"
+	// as the newer version of the same key, and the iterator surfaces both.
+	cmp := *DefaultComparer
+	cmp.Compare = func(a, b []byte) int {
+		return bytes.Compare(a[:1], b[:1])
+	}
+	cmp.Name = "buggy"
+	d, err := Open("", &Options{FS: vfs.NewMem(), Comparer: &cmp})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for _, k := range []string{"a1", "a2", "b1"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+	}
+
+	iter := NewInvariantIter(d.NewIter(nil))
+	require.True(t, iter.First())
+	require.PanicsWithValue(t,
+		`pebble: iterator invariant violated: Next moved from a2 to a1, which doesn't sort after it`,
+		func() { iter.Next() })
+	require.NoError(t, iter.Close())
+}
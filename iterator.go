@@ -10,6 +10,7 @@ import (
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/cockroachdb/errors"
@@ -19,7 +20,9 @@ import (
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/redact"
 )
@@ -119,6 +122,40 @@ type IteratorStats struct {
 	// ReverseStepCount includes Prev.
 	ReverseStepCount [NumStatsKind]int
 	InternalStats    InternalIteratorStats
+	// LevelStats breaks down the block reads included in InternalStats by the
+	// level of the LSM read. Reads of L0's sublevels are attributed to L0.
+	LevelStats [numLevels]IteratorLevelStats
+	// RangeKeyStats contains the stats of the iterator's range-key iterator
+	// stack. They're only populated by iterators configured to surface range
+	// keys.
+	RangeKeyStats RangeKeyIteratorStats
+}
+
+// RangeKeyIteratorStats contains the stats of an Iterator's range-key
+// iterator stack.
+type RangeKeyIteratorStats struct {
+	// The count of sstable range-key blocks read, whether or not they were in
+	// the block cache. An sstable's range-key block is read when its range
+	// keys are first iterated over.
+	BlockCount uint64
+	// The count of range keys surfaced by the iterator. A range key is counted
+	// each time the iterator moves onto it from a position that wasn't covered
+	// by the same range key.
+	Count uint64
+	// Time spent within the range-key iterator stack, merging the range keys
+	// of each level of the LSM.
+	MergeDuration time.Duration
+}
+
+// IteratorLevelStats contains the stats of an Iterator's block reads from a
+// level of the LSM.
+type IteratorLevelStats struct {
+	// Bytes in the loaded blocks. See InternalIteratorStats.BlockBytes.
+	BlockBytes uint64
+	// Time spent reading the loaded blocks that weren't in the block cache.
+	BlockReadDuration time.Duration
+	// The count of the loaded blocks.
+	BlockCount uint64
 }
 
 var _ redact.SafeFormatter = &IteratorStats{}
@@ -172,6 +209,11 @@ type Iterator struct {
 	value       []byte
 	valueBuf    []byte
 	valueCloser io.Closer
+	// lazyValue is set if value holds the encoded handle of a value stored in
+	// a blob file, which is read into lazyValueBuf only once it's retrieved.
+	lazyValue    bool
+	lazyValueBuf []byte
+	blobValues   blobValueFetcher
 	// boundsBuf holds two buffers used to store the lower and upper bounds.
 	// Whenever the Iterator's bounds change, the new bounds are copied into
 	// boundsBuf[boundsBufIdx]. The two bounds share a slice to reduce
@@ -185,9 +227,13 @@ type Iterator struct {
 	alloc               *iterAlloc
 	getIterAlloc        *getIterAlloc
 	prefixOrFullSeekKey []byte
-	readSampling        readSampling
-	stats               IteratorStats
-	externalReaders     []*sstable.Reader
+	// prevPrefixBuf holds the prefix of the current key during PrevPrefix,
+	// which repositions the iterator through seeks that overwrite keyBuf and
+	// prefixOrFullSeekKey.
+	prevPrefixBuf   []byte
+	readSampling    readSampling
+	stats           IteratorStats
+	externalReaders []*sstable.Reader
 
 	// Following fields used when constructing an iterator stack, eg, in Clone
 	// and SetOptions or when re-fragmenting a batch's range keys/range dels.
@@ -197,11 +243,22 @@ type Iterator struct {
 	newIterRangeKey  keyspan.TableNewSpanIter
 	lazyCombinedIter lazyCombinedIter
 	seqNum           uint64
+	// visibleSeqNum is the sequence number the iterator reads at if
+	// OnlyReadGuaranteedDurable is not set. If OnlyReadGuaranteedDurable is
+	// set, seqNum is the lower sequence number below which the keys visible at
+	// visibleSeqNum are guaranteed durable.
+	visibleSeqNum uint64
 	// batchSeqNum is used by Iterators over indexed batches to detect when the
 	// underlying batch has been mutated. The batch beneath an indexed batch may
 	// be mutated while the Iterator is open, but new keys are not surfaced
 	// until the next call to SetOptions.
 	batchSeqNum uint64
+	// immediateSuccessor is the Comparer's optional ImmediateSuccessor,
+	// passed to the levelIters of the iterator stack.
+	immediateSuccessor ImmediateSuccessor
+	// rangeKeyMerge is the optional merge function for overlapping
+	// RangeKeySets, from Options.RangeKeyMerger.
+	rangeKeyMerge RangeKeyMerge
 	// batch{PointIter,RangeDelIter,RangeKeyIter} are used when the Iterator is
 	// configured to read through an indexed batch. If a batch is set, these
 	// iterators will be included within the iterator stack regardless of
@@ -211,6 +268,9 @@ type Iterator struct {
 	batchPointIter    batchIter
 	batchRangeDelIter keyspan.Iter
 	batchRangeKeyIter keyspan.Iter
+	// capture is non-nil if the Iterator was created while its DB's workload
+	// was being captured, in which case it records the Iterator's operations.
+	capture private.IterCapturer
 
 	// Keeping the bools here after all the 8 byte aligned fields shrinks the
 	// sizeof this struct by 24 bytes.
@@ -235,6 +295,9 @@ type Iterator struct {
 	// Used for deriving the value of SeekPrefixGE(..., trySeekUsingNext),
 	// and SeekGE/SeekLT optimizations
 	lastPositioningOp lastPositioningOpKind
+	// The number of errors Reset has recovered the iterator from, bounded by
+	// opts.RetryPolicy.MaxRetries.
+	retries int
 	// Used in some tests to disable the random disabling of seek optimizations.
 	forceEnableSeekOpt bool
 }
@@ -259,6 +322,18 @@ type iteratorRangeKeyState struct {
 	// buf is used to save range-key data before moving the range-key iterator.
 	// Start and end boundaries, suffixes and values are all copied into buf.
 	buf []byte
+	// prevPosHadRangeKey records whether the iterator's position before the
+	// most recent positioning operation was valid and covered by a range key.
+	// If it was, prevStart and prevEnd hold copies of that range key's
+	// boundaries, backed by prevBuf. See Iterator.RangeKeyChanged.
+	prevPosHadRangeKey bool
+	prevStart          []byte
+	prevEnd            []byte
+	prevBuf            []byte
+
+	// timer wraps the range key iterator stack, accumulating the time spent
+	// within it in the Iterator's stats.
+	timer rangeKeyIterTimer
 
 	// iterConfig holds fields that are used for the construction of the
 	// iterator stack, but do not need to be directly accessed during iteration.
@@ -371,6 +446,7 @@ type readSampling struct {
 func (i *Iterator) findNextEntry(limit []byte) {
 	i.iterValidityState = IterExhausted
 	i.pos = iterPosCurForward
+	i.lazyValue = false
 	if i.opts.rangeKeys() && i.rangeKey != nil {
 		i.rangeKey.rangeKeyOnly = false
 	}
@@ -425,10 +501,11 @@ func (i *Iterator) findNextEntry(limit []byte) {
 			i.nextUserKey()
 			continue
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
 			i.value = i.iterValue
+			i.lazyValue = key.Kind() == InternalKeyKindBlobSet
 			i.iterValidityState = IterValid
 			i.setRangeKey()
 			return
@@ -487,8 +564,9 @@ func (i *Iterator) nextPointCurrentUserKey() bool {
 	case InternalKeyKindDelete, InternalKeyKindSingleDelete:
 		return false
 
-	case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+	case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 		i.value = i.iterValue
+		i.lazyValue = key.Kind() == InternalKeyKindBlobSet
 		return true
 
 	case InternalKeyKindMerge:
@@ -679,6 +757,7 @@ func (i *Iterator) sampleRead() {
 func (i *Iterator) findPrevEntry(limit []byte) {
 	i.iterValidityState = IterExhausted
 	i.pos = iterPosCurReverse
+	i.lazyValue = false
 	if i.opts.rangeKeys() && i.rangeKey != nil {
 		i.rangeKey.rangeKeyOnly = false
 	}
@@ -775,6 +854,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
 			i.value = nil
+			i.lazyValue = false
 			i.iterValidityState = IterExhausted
 			valueMerger = nil
 			i.iterKey, i.iterValue = i.iter.Prev()
@@ -795,15 +875,17 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			}
 			continue
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
 			// iterValue is owned by i.iter and could change after the Prev()
 			// call, so use valueBuf instead. Note that valueBuf is only used
 			// in this one instance; everywhere else (eg. in findNextEntry),
 			// we just point i.value to the unsafe i.iter-owned value buffer.
+			// The handle of a BLOBSET's value is copied in the same way.
 			i.valueBuf = append(i.valueBuf[:0], i.iterValue...)
 			i.value = i.valueBuf
+			i.lazyValue = key.Kind() == InternalKeyKindBlobSet
 			// TODO(jackson): We may save the same range key many times. We can
 			// avoid that with some help from the InterleavingIter. See also the
 			// TODO in saveRangeKey.
@@ -825,6 +907,16 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 				}
 				i.iterValidityState = IterValid
 			} else if valueMerger == nil {
+				// The merge's base is the value of the older SET or BLOBSET
+				// at this user key.
+				if i.lazyValue {
+					i.value, i.err = i.blobValues.fetch(i.value, nil /* buf */)
+					i.lazyValue = false
+					if i.err != nil {
+						i.iterValidityState = IterExhausted
+						return
+					}
+				}
 				valueMerger, i.err = i.merge(i.key, i.value)
 				if i.err == nil {
 					i.err = valueMerger.MergeNewer(i.iterValue)
@@ -921,6 +1013,15 @@ func (i *Iterator) mergeNext(key InternalKey, valueMerger ValueMerger) {
 			i.err = valueMerger.MergeOlder(i.iterValue)
 			return
 
+		case InternalKeyKindBlobSet:
+			// We've hit a Set value stored in a blob file. Read it, merge with
+			// the existing value and return.
+			var value []byte
+			if value, i.err = i.blobValues.fetch(i.iterValue, nil /* buf */); i.err == nil {
+				i.err = valueMerger.MergeOlder(value)
+			}
+			return
+
 		case InternalKeyKindMerge:
 			// We've hit another Merge value. Merge with the existing value and
 			// continue looping.
@@ -961,12 +1062,17 @@ func (i *Iterator) SeekGE(key []byte) bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace [key, limit).
 func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
+	i.captureOp(private.IterSeekGE, key, limit)
 	lastPositioningOp := i.lastPositioningOp
 	// Set it to unknown, since this operation may not succeed, in which case
 	// the SeekGE following this should not make any assumption about iterator
 	// position.
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
+	if i.stickyErr() {
+		return i.iterValidityState
+	}
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
 	i.stats.ForwardSeekCount[InterfaceCall]++
@@ -1080,12 +1186,35 @@ func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
 //
 // See ExampleIterator_SeekPrefixGE for a working example.
 func (i *Iterator) SeekPrefixGE(key []byte) bool {
+	return i.SeekPrefixGEWithLimit(key, nil) == IterValid
+}
+
+// SeekPrefixGEWithLimit moves the iterator to the first key/value pair whose
+// key is greater than or equal to the given key and which has the same
+// "prefix" as the given key. It puts the iterator in prefix iteration mode,
+// with the same semantics as SeekPrefixGE, including the use of bloom filters
+// on the prefix to avoid reading tables that don't contain it.
+//
+// The prefix isn't passed separately. As with SeekPrefixGE, it's derived from
+// key using Comparer.Split, so it can't disagree with the key.
+//
+// If limit is provided, it serves as a best-effort exclusive limit. If the
+// first key greater than or equal to the given search key with the search
+// key's prefix is also greater than or equal to limit, the Iterator may pause
+// and return IterAtLimit. Because limits are best-effort,
+// SeekPrefixGEWithLimit may return a key beyond limit.
+func (i *Iterator) SeekPrefixGEWithLimit(key []byte, limit []byte) IterValidityState {
+	i.captureOp(private.IterSeekPrefixGE, key, limit)
 	lastPositioningOp := i.lastPositioningOp
 	// Set it to unknown, since this operation may not succeed, in which case
 	// the SeekPrefixGE following this should not make any assumption about
 	// iterator position.
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
+	if i.stickyErr() {
+		return i.iterValidityState
+	}
 	i.err = nil // clear cached iteration error
 	i.stats.ForwardSeekCount[InterfaceCall]++
 
@@ -1118,8 +1247,10 @@ func (i *Iterator) SeekPrefixGE(key []byte) bool {
 		//   MERGE, SET pair where the MERGE is consumed and the iterator is
 		//   at the SET.
 		// In general some versions of i.prefix could have been consumed by
-		// the iterator, so we only optimize for cmp < 0.
-		if cmp < 0 {
+		// the iterator, so we only optimize for cmp < 0. Like SeekGEWithLimit,
+		// we don't optimize limited seeks or seeks following a seek that
+		// paused at its limit.
+		if cmp < 0 && i.iterValidityState != IterAtLimit && limit == nil {
 			flags = flags.EnableTrySeekUsingNext()
 		}
 		if invariants.Enabled && flags.TrySeekUsingNext() && !i.forceEnableSeekOpt && disableSeekOpt(key, uintptr(unsafe.Pointer(i))) {
@@ -1140,26 +1271,26 @@ func (i *Iterator) SeekPrefixGE(key []byte) bool {
 		if n := i.split(lowerBound); !bytes.Equal(i.prefixOrFullSeekKey, lowerBound[:n]) {
 			i.err = errors.New("pebble: SeekPrefixGE supplied with key outside of lower bound")
 			i.iterValidityState = IterExhausted
-			return false
+			return i.iterValidityState
 		}
 		key = lowerBound
 	} else if upperBound := i.opts.GetUpperBound(); upperBound != nil && i.cmp(key, upperBound) > 0 {
 		if n := i.split(upperBound); !bytes.Equal(i.prefixOrFullSeekKey, upperBound[:n]) {
 			i.err = errors.New("pebble: SeekPrefixGE supplied with key outside of upper bound")
 			i.iterValidityState = IterExhausted
-			return false
+			return i.iterValidityState
 		}
 		key = upperBound
 	}
 
 	i.iterKey, i.iterValue = i.iter.SeekPrefixGE(i.prefixOrFullSeekKey, key, flags)
 	i.stats.ForwardSeekCount[InternalIterCall]++
-	i.findNextEntry(nil)
+	i.findNextEntry(limit)
 	i.maybeSampleRead()
 	if i.Error() == nil {
 		i.lastPositioningOp = seekPrefixGELastPositioningOp
 	}
-	return i.iterValidityState == IterValid
+	return i.iterValidityState
 }
 
 // Deterministic disabling of the seek optimization. It uses the iterator
@@ -1190,12 +1321,17 @@ func (i *Iterator) SeekLT(key []byte) bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace up to limit.
 func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
+	i.captureOp(private.IterSeekLT, key, limit)
 	lastPositioningOp := i.lastPositioningOp
 	// Set it to unknown, since this operation may not succeed, in which case
 	// the SeekLT following this should not make any assumption about iterator
 	// position.
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
+	if i.stickyErr() {
+		return i.iterValidityState
+	}
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
 	i.stats.ReverseSeekCount[InterfaceCall]++
@@ -1250,9 +1386,14 @@ func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
 // First moves the iterator the the first key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) First() bool {
+	i.captureOp(private.IterFirst, nil, nil)
+	if i.stickyErr() {
+		return false
+	}
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
 	i.stats.ForwardSeekCount[InterfaceCall]++
 	if lowerBound := i.opts.GetLowerBound(); lowerBound != nil {
@@ -1270,9 +1411,14 @@ func (i *Iterator) First() bool {
 // Last moves the iterator the the last key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) Last() bool {
+	i.captureOp(private.IterLast, nil, nil)
+	if i.stickyErr() {
+		return false
+	}
 	i.err = nil // clear cached iteration error
 	i.hasPrefix = false
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
 	i.stats.ReverseSeekCount[InterfaceCall]++
 	if upperBound := i.opts.GetUpperBound(); upperBound != nil {
@@ -1304,16 +1450,13 @@ func (i *Iterator) Next() bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace up to limit.
 func (i *Iterator) NextWithLimit(limit []byte) IterValidityState {
+	i.captureOp(private.IterNext, nil, limit)
 	i.stats.ForwardStepCount[InterfaceCall]++
-	if limit != nil && i.hasPrefix {
-		i.err = errors.New("cannot use limit with prefix iteration")
-		i.iterValidityState = IterExhausted
-		return i.iterValidityState
-	}
 	if i.err != nil {
 		return i.iterValidityState
 	}
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
 	switch i.pos {
 	case iterPosCurForward:
@@ -1376,6 +1519,139 @@ func (i *Iterator) NextWithLimit(limit []byte) IterValidityState {
 	return i.iterValidityState
 }
 
+// NextPrefix moves the iterator to the next key/value pair with a prefix
+// different from the prefix of the key at the current iterator position. The
+// prefix of a key is determined by the user-defined Comparer.Split function.
+// Returns true if the iterator is pointing at a valid entry and false
+// otherwise.
+//
+// NextPrefix is useful for visiting only the newest version of each MVCC key:
+// rather than surfacing and discarding every older version of the current
+// prefix, NextPrefix steps the underlying iterators past them directly. Range
+// keys that begin within the current prefix are skipped, but range keys
+// covering the next position are surfaced as usual.
+//
+// If the iterator is not positioned at a valid entry, NextPrefix behaves like
+// Next. If the iterator is in prefix iteration mode (see SeekPrefixGE), every
+// remaining key shares the current prefix and NextPrefix exhausts the
+// iterator.
+func (i *Iterator) NextPrefix() bool {
+	if i.split == nil {
+		panic("pebble: split must be provided for NextPrefix")
+	}
+	if i.capture != nil {
+		// Record NextPrefix alone, rather than the operations it's built on.
+		c := i.capture
+		c.CaptureOp(private.IterNextPrefix, nil, nil)
+		i.capture = nil
+		defer func() { i.capture = c }()
+	}
+	if i.err != nil {
+		return false
+	}
+	if i.iterValidityState != IterValid {
+		return i.Next()
+	}
+	if i.hasPrefix {
+		i.iterValidityState = IterExhausted
+		return false
+	}
+	i.stats.ForwardStepCount[InterfaceCall]++
+	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
+	i.requiresReposition = false
+
+	// Save the current prefix. NB: prefixOrFullSeekKey is only consulted in
+	// prefix iteration mode or after a seek, so it's free to be reused here.
+	i.prefixOrFullSeekKey = append(i.prefixOrFullSeekKey[:0], i.key[:i.split(i.key)]...)
+	prefix := i.prefixOrFullSeekKey
+
+	if i.pos != iterPosCurForward && i.pos != iterPosNext {
+		// Switching directions. Let Next reposition the underlying iterator in
+		// the forward direction.
+		if !i.Next() || !bytes.Equal(prefix, i.key[:i.split(i.key)]) {
+			return i.iterValidityState == IterValid
+		}
+	}
+	if i.pos == iterPosCurForward {
+		// The underlying iterator is positioned at the current key. Otherwise,
+		// it's already positioned beyond it (iterPosNext).
+		i.iterKey, i.iterValue = i.iter.Next()
+		i.stats.ForwardStepCount[InternalIterCall]++
+	}
+	for i.iterKey != nil {
+		if n := i.split(i.iterKey.UserKey); !bytes.Equal(prefix, i.iterKey.UserKey[:n]) {
+			break
+		}
+		i.iterKey, i.iterValue = i.iter.Next()
+		i.stats.ForwardStepCount[InternalIterCall]++
+	}
+	i.findNextEntry(nil)
+	i.maybeSampleRead()
+	return i.iterValidityState == IterValid
+}
+
+// PrevPrefix moves the iterator to the previous key/value pair with a prefix
+// different from the prefix of the key at the current iterator position. The
+// prefix of a key is determined by the user-defined Comparer.Split function.
+// Returns true if the iterator is pointing at a valid entry and false
+// otherwise.
+//
+// PrevPrefix complements NextPrefix, and is useful for visiting only the
+// newest version of each MVCC key in descending order: the iterator is left at
+// the first key (within the iterator bounds) of the previous prefix, which for
+// an MVCC Comparer is its newest version, rather than at the last key that
+// Prev would surface. PrevPrefix repositions the iterator through a SeekLT to
+// the current prefix followed by a SeekGE to the previous prefix, so older
+// versions are never stepped over individually. As with SeekGE, if a range key
+// covers the previous prefix, the iterator may pause at the prefix itself to
+// surface the range key.
+//
+// If the iterator is not positioned at a valid entry, PrevPrefix behaves like
+// Prev. Reverse iteration is not supported in prefix iteration mode (see
+// SeekPrefixGE).
+func (i *Iterator) PrevPrefix() bool {
+	if i.split == nil {
+		panic("pebble: split must be provided for PrevPrefix")
+	}
+	if i.capture != nil {
+		// Record PrevPrefix alone, rather than the operations it's built on.
+		c := i.capture
+		c.CaptureOp(private.IterPrevPrefix, nil, nil)
+		i.capture = nil
+		defer func() { i.capture = c }()
+	}
+	if i.err != nil {
+		return false
+	}
+	if i.iterValidityState != IterValid {
+		return i.Prev()
+	}
+	if i.hasPrefix {
+		i.err = errReversePrefixIteration
+		i.iterValidityState = IterExhausted
+		return false
+	}
+	// The seeks below account for themselves in the interface call stats. A
+	// PrevPrefix is recorded as a single reverse step instead.
+	forwardSeeks := i.stats.ForwardSeekCount[InterfaceCall]
+	reverseSeeks := i.stats.ReverseSeekCount[InterfaceCall]
+	defer func() {
+		i.stats.ForwardSeekCount[InterfaceCall] = forwardSeeks
+		i.stats.ReverseSeekCount[InterfaceCall] = reverseSeeks
+		i.stats.ReverseStepCount[InterfaceCall]++
+	}()
+
+	i.prevPrefixBuf = append(i.prevPrefixBuf[:0], i.key[:i.split(i.key)]...)
+	if !i.SeekLT(i.prevPrefixBuf) {
+		return false
+	}
+	// The iterator is positioned at the last key of the previous prefix. Seek
+	// to the prefix's first key.
+	i.prevPrefixBuf = append(i.prevPrefixBuf[:0], i.key[:i.split(i.key)]...)
+	return i.SeekGE(i.prevPrefixBuf)
+}
+
 // Prev moves the iterator to the previous key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) Prev() bool {
@@ -1393,11 +1669,13 @@ func (i *Iterator) Prev() bool {
 // guarantees it will surface any range keys with bounds overlapping the
 // keyspace up to limit.
 func (i *Iterator) PrevWithLimit(limit []byte) IterValidityState {
+	i.captureOp(private.IterPrev, nil, limit)
 	i.stats.ReverseStepCount[InterfaceCall]++
 	if i.err != nil {
 		return i.iterValidityState
 	}
 	i.lastPositioningOp = unknownLastPositionOp
+	i.savePrevRangeKeyPos()
 	i.requiresReposition = false
 	if i.hasPrefix {
 		i.err = errReversePrefixIteration
@@ -1553,6 +1831,9 @@ func (i *Iterator) saveRangeKey() {
 		i.rangeKey.hasRangeKey = false
 		return
 	}
+	if !i.rangeKey.hasRangeKey || !i.equal(i.rangeKey.start, s.Start) {
+		i.stats.RangeKeyStats.Count++
+	}
 	i.rangeKey.hasRangeKey = true
 	// TODO(jackson): Rather than naively copying all the range key state every
 	// time, we could copy only if it actually changed from the currently saved
@@ -1579,6 +1860,55 @@ func (i *Iterator) saveRangeKey() {
 	sort.Sort(&i.rangeKey.keys)
 }
 
+// savePrevRangeKeyPos records the range key covering the iterator's current
+// position, so that RangeKeyChanged may determine whether the positioning
+// operation about to be performed moves the iterator onto a different range
+// key. It must be called before the positioning operation clears
+// requiresReposition.
+func (i *Iterator) savePrevRangeKeyPos() {
+	if i.rangeKey == nil {
+		return
+	}
+	i.rangeKey.prevPosHadRangeKey = i.opts.rangeKeys() && i.rangeKey.hasRangeKey && i.Valid()
+	if !i.rangeKey.prevPosHadRangeKey {
+		return
+	}
+	// The current range key's boundaries may be backed by buffers owned by
+	// the range key iterator stack, so they must be copied to survive the
+	// positioning operation.
+	buf := append(i.rangeKey.prevBuf[:0], i.rangeKey.start...)
+	buf = append(buf, i.rangeKey.end...)
+	i.rangeKey.prevStart = buf[:len(i.rangeKey.start)]
+	i.rangeKey.prevEnd = buf[len(i.rangeKey.start):]
+	i.rangeKey.prevBuf = buf
+}
+
+// RangeKeyChanged indicates whether the most recent positioning operation
+// moved the iterator onto a position covered by a different set of range keys
+// than the iterator's previous position. This includes stepping into a range
+// key from a position with no range keys, and stepping out of a range key onto
+// a position with no range keys. Invalid iterator positions are considered to
+// hold no range keys, so the first valid position covered by a range key after
+// an exhausted or paused position, or after SetBounds or SetOptions, reports a
+// change.
+//
+// When RangeKeyChanged returns false, the range keys reported by RangeBounds
+// and RangeKeys are identical to those at the previous position, and callers
+// that have already decoded them may avoid doing so again. RangeKeyChanged
+// always returns false if the iterator isn't at a valid position or isn't
+// configured to surface range keys.
+func (i *Iterator) RangeKeyChanged() bool {
+	if !i.Valid() || i.rangeKey == nil || !i.opts.rangeKeys() {
+		return false
+	}
+	if !i.rangeKey.hasRangeKey {
+		return i.rangeKey.prevPosHadRangeKey
+	}
+	return !i.rangeKey.prevPosHadRangeKey ||
+		!i.equal(i.rangeKey.prevStart, i.rangeKey.start) ||
+		!i.equal(i.rangeKey.prevEnd, i.rangeKey.end)
+}
+
 // HasPointAndRange indicates whether there exists a point key, a range key or
 // both at the current iterator position.
 func (i *Iterator) HasPointAndRange() (hasPoint, hasRange bool) {
@@ -1613,11 +1943,83 @@ func (i *Iterator) Key() []byte {
 // caller should not modify the contents of the returned slice, and its
 // contents may change on the next call to Next.
 //
+// A value stored in a blob file is read when it's first retrieved. If it
+// can't be read, Value returns nil and the error is surfaced by Error.
+//
 // Only valid if HasPointAndRange() returns true for hasPoint.
 func (i *Iterator) Value() []byte {
+	if i.lazyValue {
+		i.lazyValue = false
+		v, err := i.blobValues.fetch(i.value, i.lazyValueBuf[:0])
+		if err != nil {
+			i.err = err
+			i.value = nil
+			return nil
+		}
+		i.lazyValueBuf = v
+		i.value = v
+	}
 	return i.value
 }
 
+// LazyValue returns the value of the current key/value pair, deferring the
+// read of a value stored in a blob file until LazyValue.Value is called. An
+// iterator that doesn't call Value avoids reading such values altogether. The
+// returned LazyValue is only valid until the iterator is next repositioned.
+//
+// Only valid if HasPointAndRange() returns true for hasPoint.
+func (i *Iterator) LazyValue() LazyValue {
+	if !i.lazyValue {
+		return LazyValue{value: i.value}
+	}
+	return LazyValue{value: i.value, fetcher: &i.blobValues}
+}
+
+// LazyValue is the value of a key/value pair, which may not have been read
+// yet. It's obtained through Iterator.LazyValue.
+type LazyValue struct {
+	// value is the value itself, or the encoded handle of a value stored in a
+	// blob file if fetcher is non-nil.
+	value   []byte
+	fetcher *blobValueFetcher
+}
+
+// Value returns the value, reading it if it has not been read yet. A value
+// that's read is read into buf if buf has sufficient capacity, and into a
+// newly allocated slice otherwise, and callerOwned is true. Otherwise, the
+// returned value is owned by the iterator and callerOwned is false; the
+// caller should not modify its contents, which may change when the iterator
+// is next repositioned.
+func (lv LazyValue) Value(buf []byte) (val []byte, callerOwned bool, err error) {
+	if lv.fetcher == nil {
+		return lv.value, false, nil
+	}
+	val, err = lv.fetcher.fetch(lv.value, buf[:0])
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// blobValueFetcher reads the values of an Iterator's BLOBSET keys, tracking
+// the bytes read for the Iterator's stats.
+type blobValueFetcher struct {
+	blobFiles    *blobFileCache
+	bytesFetched uint64
+}
+
+func (f *blobValueFetcher) fetch(handle, buf []byte) ([]byte, error) {
+	if f.blobFiles == nil {
+		return nil, errors.AssertionFailedf("pebble: BLOBSET key read by an iterator without blob files")
+	}
+	v, err := f.blobFiles.readValue(handle, buf)
+	if err != nil {
+		return nil, err
+	}
+	f.bytesFetched += uint64(len(v))
+	return v, nil
+}
+
 // RangeKeys returns the range key values and their suffixes covering the
 // current iterator position. The range bounds may be retrieved separately
 // through Iterator.RangeBounds().
@@ -1643,11 +2045,111 @@ func (i *Iterator) Error() error {
 	return err
 }
 
+// Reset recovers the iterator from a retryable error, as determined by the
+// IterOptions.RetryPolicy it was configured with, such as a transient failure
+// reading an sstable from a remote storage. The iterator stack is
+// reconstructed, so the sstables that failed to be read are read anew, and the
+// iterator must be repositioned with a call to SeekGE, SeekPrefixGE, SeekLT,
+// First, or Last.
+//
+// Reset returns nil if the iterator was recovered or isn't in an error state.
+// Otherwise it returns the error, which isn't retryable, and the iterator
+// remains in its error state.
+func (i *Iterator) Reset() error {
+	err := i.Error()
+	if err == nil {
+		return nil
+	}
+	if !i.retryable(err) {
+		i.err = err
+		return err
+	}
+
+	// The internal iterators retain the errors they encountered, so they're
+	// discarded. Closing them reports the same errors.
+	err = nil
+	if i.pointIter != nil {
+		err = i.pointIter.Close()
+		i.pointIter = nil
+	}
+	if i.rangeKey != nil {
+		err = firstError(err, i.rangeKey.rangeKeyIter.Close())
+		i.rangeKey = nil
+	}
+	if err != nil && !i.retryable(err) {
+		i.err = err
+		return err
+	}
+	i.err = nil
+	i.retries++
+	o := i.opts
+	i.SetOptions(&o)
+	return nil
+}
+
+// retryable returns true if the iterator's RetryPolicy permits Reset to
+// recover it from err.
+func (i *Iterator) retryable(err error) bool {
+	p := i.opts.RetryPolicy
+	if p == nil || (p.MaxRetries > 0 && i.retries >= p.MaxRetries) {
+		return false
+	}
+	if p.IsRetryable != nil {
+		return p.IsRetryable(err)
+	}
+	return errors.Is(err, objstorage.ErrRetryable)
+}
+
+// stickyErr returns true, exhausting the iterator, if the iterator is in an
+// error state that persists across positioning operations. Under a
+// RetryPolicy, only Reset clears an error.
+func (i *Iterator) stickyErr() bool {
+	if i.opts.RetryPolicy == nil {
+		return false
+	}
+	if err := i.Error(); err != nil {
+		i.err = err
+		i.iterValidityState = IterExhausted
+		return true
+	}
+	return false
+}
+
+// releaseReadState hands any pending read compactions to the DB and releases
+// the iterator's reference to its readState. The iterator's child iterators
+// must have been closed.
+func (i *Iterator) releaseReadState() {
+	if i.readState == nil {
+		return
+	}
+	if i.readSampling.pendingCompactions.size > 0 {
+		// Copy pending read compactions using db.mu.Lock()
+		i.readState.db.mu.Lock()
+		i.readState.db.mu.compact.readCompactions.combine(&i.readSampling.pendingCompactions, i.cmp)
+		i.readSampling.pendingCompactions = readCompactionQueue{}
+		reschedule := i.readState.db.mu.compact.rescheduleReadCompaction
+		i.readState.db.mu.compact.rescheduleReadCompaction = false
+		concurrentCompactions := i.readState.db.mu.compact.compactingCount
+		i.readState.db.mu.Unlock()
+
+		if reschedule && concurrentCompactions == 0 {
+			// In a read heavy workload, flushes may not happen frequently enough to
+			// schedule compactions.
+			i.readState.db.compactionSchedulers.Add(1)
+			go i.readState.db.maybeScheduleCompactionAsync()
+		}
+	}
+
+	i.readState.unref()
+	i.readState = nil
+}
+
 // Close closes the iterator and returns any accumulated error. Exhausting
 // all the key/value pairs in a table is not considered to be an error.
 // It is not valid to call any method, including Close, after the iterator
 // has been closed.
 func (i *Iterator) Close() error {
+	i.captureOp(private.IterClose, nil, nil)
 	i.err = firstError(i.err, i.rangeKeyMasking.err)
 	// Close the child iterator before releasing the readState because when the
 	// readState is released sstables referenced by the readState may be deleted
@@ -1674,27 +2176,7 @@ func (i *Iterator) Close() error {
 	}
 	err := i.err
 
-	if i.readState != nil {
-		if i.readSampling.pendingCompactions.size > 0 {
-			// Copy pending read compactions using db.mu.Lock()
-			i.readState.db.mu.Lock()
-			i.readState.db.mu.compact.readCompactions.combine(&i.readSampling.pendingCompactions, i.cmp)
-			reschedule := i.readState.db.mu.compact.rescheduleReadCompaction
-			i.readState.db.mu.compact.rescheduleReadCompaction = false
-			concurrentCompactions := i.readState.db.mu.compact.compactingCount
-			i.readState.db.mu.Unlock()
-
-			if reschedule && concurrentCompactions == 0 {
-				// In a read heavy workload, flushes may not happen frequently enough to
-				// schedule compactions.
-				i.readState.db.compactionSchedulers.Add(1)
-				go i.readState.db.maybeScheduleCompactionAsync()
-			}
-		}
-
-		i.readState.unref()
-		i.readState = nil
-	}
+	i.releaseReadState()
 
 	for _, r := range i.externalReaders {
 		err = firstError(err, r.Close())
@@ -1714,7 +2196,7 @@ func (i *Iterator) Close() error {
 		if cap(i.rangeKey.buf) >= maxKeyBufCacheSize {
 			i.rangeKey.buf = nil
 		}
-		*i.rangeKey = iteratorRangeKeyState{buf: i.rangeKey.buf}
+		*i.rangeKey = iteratorRangeKeyState{buf: i.rangeKey.buf, prevBuf: i.rangeKey.prevBuf}
 		iterRangeKeyStateAllocPool.Put(i.rangeKey)
 		i.rangeKey = nil
 	}
@@ -1764,6 +2246,7 @@ func (i *Iterator) Close() error {
 // The iterator will always be invalidated and must be repositioned with a call
 // to SeekGE, SeekPrefixGE, SeekLT, First, or Last.
 func (i *Iterator) SetBounds(lower, upper []byte) {
+	i.captureOp(private.IterSetBounds, lower, upper)
 	// Ensure that the Iterator appears exhausted, regardless of whether we
 	// actually have to invalidate the internal iterator. Optimizations that
 	// avoid exhaustion are an internal implementation detail that shouldn't
@@ -1835,6 +2318,9 @@ func (i *Iterator) saveBounds(lower, upper []byte) {
 //
 // If only lower and upper bounds need to be modified, prefer SetBounds.
 func (i *Iterator) SetOptions(o *IterOptions) {
+	if i.capture != nil {
+		i.capture.CaptureSetOptions(o)
+	}
 	if i.externalReaders != nil {
 		if err := validateExternalIterOpts(o); err != nil {
 			panic(err)
@@ -1854,8 +2340,8 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	// so that we reconstruct an iterator state from scratch.
 	//
 	// If OnlyReadGuaranteedDurable changed, the iterator stacks are incorrect,
-	// improperly including or excluding memtables. Invalidate them so that
-	// finishInitializingIter will reconstruct them.
+	// reading at the wrong sequence number. Invalidate them so that
+	// finishInitializingIter will reconstruct them at the new sequence number.
 	//
 	// If either the original options or the new options specify a table filter,
 	// we need to reconstruct the iterator stacks. If they both supply a table
@@ -1864,11 +2350,21 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	closeBoth := i.err != nil ||
 		o.OnlyReadGuaranteedDurable != i.opts.OnlyReadGuaranteedDurable ||
 		o.TableFilter != nil || i.opts.TableFilter != nil
+	if o.OnlyReadGuaranteedDurable != i.opts.OnlyReadGuaranteedDurable && i.readState != nil {
+		i.seqNum = i.visibleSeqNum
+		if o.OnlyReadGuaranteedDurable {
+			i.seqNum = i.readState.durableSeqNum(i.visibleSeqNum)
+		}
+	}
 
 	// If either options specify block property filters for an iterator stack,
-	// reconstruct it.
+	// reconstruct it. The point iterator stack must also be reconstructed if
+	// NoCachePollution or SkipCorruptBlocks changed, since they're fixed when
+	// each sstable iterator is opened.
 	if i.pointIter != nil && (closeBoth || len(o.PointKeyFilters) > 0 || len(i.opts.PointKeyFilters) > 0 ||
-		o.RangeKeyMasking.Filter != nil || i.opts.RangeKeyMasking.Filter != nil) {
+		o.RangeKeyMasking.Filter != nil || i.opts.RangeKeyMasking.Filter != nil ||
+		o.NoCachePollution != i.opts.NoCachePollution ||
+		o.SkipCorruptBlocks != i.opts.SkipCorruptBlocks) {
 		i.err = firstError(i.err, i.pointIter.Close())
 		i.pointIter = nil
 	}
@@ -1959,7 +2455,9 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 		(i.pointIter != nil || !i.opts.pointKeys()) &&
 		(i.rangeKey != nil || !i.opts.rangeKeys() || i.opts.KeyTypes == IterKeyTypePointsAndRanges) &&
 		i.equal(o.RangeKeyMasking.Suffix, i.opts.RangeKeyMasking.Suffix) &&
-		o.UseL6Filters == i.opts.UseL6Filters {
+		o.UseL6Filters == i.opts.UseL6Filters &&
+		o.NoCachePollution == i.opts.NoCachePollution &&
+		o.SkipCorruptBlocks == i.opts.SkipCorruptBlocks {
 		// The options are identical, so we can likely use the fast path. In
 		// addition to all the above constraints, we cannot use the fast path if
 		// configured to perform lazy combined iteration but an indexed batch
@@ -2007,12 +2505,37 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	finishInitializingIter(i.alloc)
 }
 
+// SetPointKeyFilter replaces the block-property filters applied to point keys,
+// configured through IterOptions.PointKeyFilters, with the provided filter. A
+// nil filter removes all point-key filters. The iterator's other options are
+// unchanged.
+//
+// SetPointKeyFilter allows a scan to adjust its filtering criteria as it
+// progresses. A filter may also be reconfigured in place (eg, through
+// BlockIntervalFilter.SetInterval) and passed to SetPointKeyFilter again: until
+// then, the iterator may continue to apply the filter's previous criteria to
+// the sstables it has already opened.
+//
+// Like SetOptions, SetPointKeyFilter invalidates the iterator, which must be
+// repositioned with a call to SeekGE, SeekPrefixGE, SeekLT, First, or Last.
+// The new filter is applied to every sstable and block read thereafter.
+func (i *Iterator) SetPointKeyFilter(filter BlockPropertyFilter) {
+	o := i.opts
+	o.PointKeyFilters = nil
+	if filter != nil {
+		o.PointKeyFilters = []BlockPropertyFilter{filter}
+	}
+	i.SetOptions(&o)
+}
+
 func (i *Iterator) invalidate() {
 	i.lastPositioningOp = unknownLastPositionOp
 	i.hasPrefix = false
 	i.iterKey = nil
 	i.iterValue = nil
-	i.err = nil
+	if i.opts.RetryPolicy == nil {
+		i.err = nil
+	}
 	// This switch statement isn't necessary for correctness since callers
 	// should call a repositioning method. We could have arbitrarily set i.pos
 	// to one of the values. But it results in more intuitive behavior in
@@ -2040,6 +2563,7 @@ func (i *Iterator) Metrics() IteratorMetrics {
 // ResetStats resets the stats to 0.
 func (i *Iterator) ResetStats() {
 	i.stats = IteratorStats{}
+	i.blobValues.bytesFetched = 0
 	i.iter.ResetStats()
 }
 
@@ -2047,6 +2571,10 @@ func (i *Iterator) ResetStats() {
 func (i *Iterator) Stats() IteratorStats {
 	stats := i.stats
 	stats.InternalStats = i.iter.Stats()
+	stats.InternalStats.SeparatedPointValue.ValueBytesFetched += i.blobValues.bytesFetched
+	if mi, ok := i.pointIter.(*mergingIter); ok {
+		mi.addLevelStats(&stats.LevelStats)
+	}
 	return stats
 }
 
@@ -2104,6 +2632,8 @@ func (i *Iterator) Clone(opts CloneOptions) (*Iterator, error) {
 		equal:               i.equal,
 		merge:               i.merge,
 		split:               i.split,
+		immediateSuccessor:  i.immediateSuccessor,
+		rangeKeyMerge:       i.rangeKeyMerge,
 		readState:           readState,
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
@@ -2113,6 +2643,7 @@ func (i *Iterator) Clone(opts CloneOptions) (*Iterator, error) {
 		newIters:            i.newIters,
 		newIterRangeKey:     i.newIterRangeKey,
 		seqNum:              i.seqNum,
+		visibleSeqNum:       i.visibleSeqNum,
 	}
 	dbi.saveBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
 
@@ -2125,6 +2656,37 @@ func (i *Iterator) Clone(opts CloneOptions) (*Iterator, error) {
 	return finishInitializingIter(buf), nil
 }
 
+// CloneWithBounds is like Clone, but the clone is configured with the
+// provided lower and upper bounds rather than those of the cloned Iterator's
+// options. The remaining options are taken from opts.IterOptions if set, or
+// from the cloned Iterator otherwise. A nil opts is equivalent to the zero
+// CloneOptions.
+//
+// The clone reads the same version of the LSM as i, without re-reading the
+// current version or refreshing the view of the memtables. Each clone holds
+// its own reference on the shared state, so the clone and the cloned
+// Iterator may be closed in any order. CloneWithBounds may be used to
+// partition a scan across several goroutines, each of which must use its own
+// clone; the cloned Iterator itself must not be used concurrently.
+func (i *Iterator) CloneWithBounds(
+	lower, upper []byte, opts *CloneOptions,
+) (*Iterator, error) {
+	var cloneOpts CloneOptions
+	if opts != nil {
+		cloneOpts = *opts
+	}
+	var iterOpts IterOptions
+	if cloneOpts.IterOptions != nil {
+		iterOpts = *cloneOpts.IterOptions
+	} else {
+		iterOpts = i.opts
+	}
+	iterOpts.LowerBound = lower
+	iterOpts.UpperBound = upper
+	cloneOpts.IterOptions = &iterOpts
+	return i.Clone(cloneOpts)
+}
+
 func (stats *IteratorStats) String() string {
 	return redact.StringWithoutMarkers(stats)
 }
@@ -2153,5 +2715,15 @@ func (stats *IteratorStats) SafeFormat(s redact.SafePrinter, verb rune) {
 			humanize.SI.Uint64(stats.InternalStats.ValueBytes),
 			humanize.SI.Uint64(stats.InternalStats.PointsCoveredByRangeTombstones),
 		)
+		if stats.InternalStats.SeparatedPointValue.Count > 0 {
+			s.Printf(", (separated: (count %s, bytes %s, fetched %s))",
+				humanize.SI.Uint64(stats.InternalStats.SeparatedPointValue.Count),
+				humanize.IEC.Uint64(stats.InternalStats.SeparatedPointValue.ValueBytes),
+				humanize.IEC.Uint64(stats.InternalStats.SeparatedPointValue.ValueBytesFetched))
+		}
+	}
+	if stats.RangeKeyStats.BlockCount > 0 || stats.RangeKeyStats.Count > 0 {
+		s.Printf(",\n(range-key-stats: (blocks %d, count %d))",
+			redact.Safe(stats.RangeKeyStats.BlockCount), redact.Safe(stats.RangeKeyStats.Count))
 	}
 }
@@ -0,0 +1,139 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// IterPool is a pool of Iterators over a DB, which reduces the cost of
+// constructing iterators for workloads performing many short scans. An
+// Iterator returned to the pool through Put releases its internal iterators
+// and its view of the DB, but retains its allocations. A subsequent Get
+// rebinds it to the current state of the DB, reconstructing its internal
+// iterators within the retained allocations.
+//
+// Pooled iterators don't hold references to the DB's memtables or sstables.
+// The pool must be closed before the DB is closed.
+//
+// An IterPool is safe for concurrent use, but the Iterators it returns are
+// not.
+type IterPool struct {
+	db *DB
+	mu sync.Mutex
+	// iters holds the iterators returned to the pool, most recently returned
+	// last.
+	iters []*Iterator
+}
+
+// NewIterPool returns a new, empty pool of Iterators over the DB.
+func (d *DB) NewIterPool() *IterPool {
+	return &IterPool{db: d}
+}
+
+// Get returns an unpositioned Iterator over the current state of the DB,
+// configured with the provided options, as if it were returned by
+// DB.NewIter. The Iterator must be returned to the pool through Put rather
+// than closed.
+func (p *IterPool) Get(o *IterOptions) *Iterator {
+	if o == nil {
+		o = &IterOptions{}
+	}
+	iter := p.pop()
+	if iter == nil {
+		return p.db.NewIter(o)
+	}
+	p.rebind(iter)
+	iter.SetOptions(o)
+	iter.ResetStats()
+	return iter
+}
+
+// Put returns an Iterator obtained through Get to the pool. The Iterator must
+// not be used after Put is called. Iterators in an error state are closed
+// rather than pooled.
+func (p *IterPool) Put(iter *Iterator) {
+	if iter.readState == nil || iter.readState.db != p.db || iter.batch != nil {
+		panic("pebble: iterator was not obtained from the pool")
+	}
+	if iter.Error() != nil || p.release(iter) != nil {
+		_ = iter.Close()
+		return
+	}
+	p.mu.Lock()
+	p.iters = append(p.iters, iter)
+	p.mu.Unlock()
+}
+
+// Close closes the pooled iterators. The pool may continue to be used after
+// Close.
+func (p *IterPool) Close() error {
+	p.mu.Lock()
+	iters := p.iters
+	p.iters = nil
+	p.mu.Unlock()
+
+	var err error
+	for _, iter := range iters {
+		err = firstError(err, iter.Close())
+	}
+	return err
+}
+
+func (p *IterPool) pop() *Iterator {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.iters)
+	if n == 0 {
+		return nil
+	}
+	iter := p.iters[n-1]
+	p.iters[n-1] = nil
+	p.iters = p.iters[:n-1]
+	return iter
+}
+
+// release closes the internal iterators of an iterator returned to the pool,
+// and releases its readState, so that it doesn't hold references to any
+// memtables or sstables while pooled.
+func (p *IterPool) release(iter *Iterator) error {
+	var err error
+	if iter.iter != nil {
+		err = iter.iter.Close()
+		iter.iter = nil
+	}
+	// See Iterator.Close: the point and range key iterators may have been
+	// disconnected from iter.iter.
+	if iter.pointIter != nil {
+		err = firstError(err, iter.pointIter.Close())
+		iter.pointIter = nil
+	}
+	if iter.rangeKey != nil {
+		if iter.rangeKey.rangeKeyIter != nil {
+			err = firstError(err, iter.rangeKey.rangeKeyIter.Close())
+		}
+		iter.rangeKey = nil
+	}
+	if iter.valueCloser != nil {
+		err = firstError(err, iter.valueCloser.Close())
+		iter.valueCloser = nil
+	}
+	iter.releaseReadState()
+	return err
+}
+
+// rebind binds a pooled iterator to the DB's current readState and visible
+// sequence number. Its internal iterators are reconstructed by the subsequent
+// call to SetOptions.
+func (p *IterPool) rebind(iter *Iterator) {
+	d := p.db
+	iter.readState = d.loadReadState()
+	iter.visibleSeqNum = atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	iter.seqNum = iter.visibleSeqNum
+	if iter.opts.OnlyReadGuaranteedDurable {
+		iter.seqNum = iter.readState.durableSeqNum(iter.visibleSeqNum)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterPool(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	p := d.NewIterPool()
+	var last *Iterator
+	scan := func(o *IterOptions) []string {
+		iter := p.Get(o)
+		if last != nil {
+			// The pooled iterator is reused.
+			require.True(t, iter == last)
+		}
+		last = iter
+		var keys []string
+		for valid := iter.First(); valid && len(keys) < 2; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		p.Put(iter)
+
+		// The pooled iterator doesn't pin the DB's current readState, or any
+		// earlier one.
+		require.Nil(t, iter.readState)
+		require.Equal(t, int32(1), atomic.LoadInt32(&d.readState.val.refcnt))
+		return keys
+	}
+
+	// The pooled iterator is reused, applying the new bounds.
+	require.Equal(t, []string{"a", "b"}, scan(nil))
+	require.Equal(t, []string{"c"}, scan(&IterOptions{LowerBound: []byte("c"), UpperBound: []byte("d")}))
+	require.Equal(t, []string{"a", "b"}, scan(nil))
+
+	// The reused iterator observes writes made while it was pooled.
+	require.NoError(t, d.Set([]byte("e"), []byte("e"), nil))
+	require.Equal(t, []string{"d", "e"}, scan(&IterOptions{LowerBound: []byte("d")}))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+	require.Equal(t, []string{"b", "c"}, scan(nil))
+
+	// As well as changes to the LSM.
+	require.NoError(t, d.Compact([]byte("a"), []byte("f"), false /* parallelize */))
+	require.Equal(t, []string{"b", "c"}, scan(nil))
+	require.Equal(t, []string{"e"}, scan(&IterOptions{LowerBound: []byte("e")}))
+
+	require.NoError(t, p.Close())
+}
+
+func BenchmarkIterPool(b *testing.B) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(b, err)
+	defer func() { require.NoError(b, d.Close()) }()
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		require.NoError(b, d.Set(k, k, nil))
+	}
+	require.NoError(b, d.Flush())
+
+	scan := func(iter *Iterator, i int) {
+		for valid := iter.SeekGE([]byte(fmt.Sprintf("%04d", i%1000))); valid && iter.Key()[3] != '9'; valid = iter.Next() {
+		}
+	}
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			iter := d.NewIter(nil)
+			scan(iter, i)
+			if err := iter.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		p := d.NewIterPool()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			iter := p.Get(nil)
+			scan(iter, i)
+			p.Put(iter)
+		}
+		b.StopTimer()
+		if err := p.Close(); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
@@ -9,21 +9,26 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/internal/testkeys/blockprop"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -1094,6 +1099,62 @@ func TestIteratorSeekOpt(t *testing.T) {
 	})
 }
 
+func TestIteratorSeekPrefixGEWithLimit(t *testing.T) {
+	opts := &Options{
+		Comparer: testkeys.Comparer,
+		FS:       vfs.NewMem(),
+		Levels:   []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a@5", "a@3", "a@1", "b@2", "c@4", "c@2"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	iter := d.NewIter(nil)
+	defer func() { require.NoError(t, iter.Close()) }()
+	key := func() string {
+		if !iter.Valid() {
+			return "."
+		}
+		return string(iter.Key())
+	}
+
+	// Without a limit, the seek behaves like SeekPrefixGE.
+	require.Equal(t, IterValid, iter.SeekPrefixGEWithLimit([]byte("a@4"), nil))
+	require.Equal(t, "a@3", key())
+	require.True(t, iter.Next())
+	require.Equal(t, "a@1", key())
+	require.False(t, iter.Next())
+
+	// The limit is an exclusive limit, following SeekGEWithLimit.
+	require.Equal(t, IterAtLimit, iter.SeekPrefixGEWithLimit([]byte("a@4"), []byte("a@3")))
+	require.Equal(t, IterValid, iter.SeekPrefixGEWithLimit([]byte("a@4"), []byte("a@2")))
+	require.Equal(t, "a@3", key())
+	// Iteration may continue from a paused position, and remains limited to
+	// the prefix.
+	require.Equal(t, IterAtLimit, iter.SeekPrefixGEWithLimit([]byte("c"), []byte("c@5")))
+	require.Equal(t, IterValid, iter.NextWithLimit([]byte("c@1")))
+	require.Equal(t, "c@4", key())
+	require.Equal(t, IterValid, iter.NextWithLimit(nil))
+	require.Equal(t, "c@2", key())
+	require.Equal(t, IterExhausted, iter.NextWithLimit(nil))
+
+	// A limit beyond the prefix does not prevent exhaustion within the prefix.
+	require.Equal(t, IterExhausted, iter.SeekPrefixGEWithLimit([]byte("b@1"), []byte("z")))
+
+	// Seeking a prefix that is not present is short-circuited by the bloom
+	// filter.
+	iter2 := d.NewIter(nil)
+	defer func() { require.NoError(t, iter2.Close()) }()
+	hits := d.Metrics().Filter.Hits
+	require.Equal(t, IterExhausted, iter2.SeekPrefixGEWithLimit([]byte("bb@1"), []byte("z")))
+	require.Equal(t, hits+1, d.Metrics().Filter.Hits)
+}
+
 type errorSeekIter struct {
 	internalIterator
 	// Fields controlling error injection for seeks.
@@ -1508,6 +1569,144 @@ func TestIteratorRandomizedBlockIntervalFilter(t *testing.T) {
 	require.Equal(t, 0, len(matchingKeyValues))
 }
 
+func TestIteratorSetPointKeyFilter(t *testing.T) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+		BlockPropertyCollectors: []func() BlockPropertyCollector{
+			blockprop.NewBlockPropertyCollector,
+		},
+	}
+	// Place every key in its own block.
+	opts.Levels = []LevelOptions{{BlockSize: 1, IndexBlockSize: 1}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write the keys k00@1, k01@2, ..., k19@20.
+	const n = 20
+	key := func(i int) string { return fmt.Sprintf("k%02d@%d", i, i+1) }
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(key(i)), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+
+	filter := blockprop.NewBlockPropertyFilter(1, n+1)
+	iter := d.NewIter(&IterOptions{PointKeyFilters: []BlockPropertyFilter{filter}})
+	defer func() { require.NoError(t, iter.Close()) }()
+	collect := func(valid bool) []string {
+		var keys []string
+		for ; valid && len(keys) < 3; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		return keys
+	}
+	require.Equal(t, []string{key(0), key(1), key(2)}, collect(iter.First()))
+
+	// Narrow the filter to exclude the blocks of keys with suffixes below @10.
+	// Block-property filters only hide keys by skipping their blocks, so the
+	// next seeks in either direction skip over them.
+	filter.SetInterval(10, n+1)
+	iter.SetPointKeyFilter(filter)
+	require.False(t, iter.Valid())
+	require.Equal(t, []string{key(9), key(10), key(11)}, collect(iter.SeekGE([]byte(key(3)))))
+	require.False(t, iter.SeekLT([]byte(key(9))))
+
+	// Replacing the filter with a disjoint one excludes every block.
+	iter.SetPointKeyFilter(blockprop.NewBlockPropertyFilter(n+1, n+2))
+	require.Empty(t, collect(iter.First()))
+
+	// Removing the filter surfaces every key again.
+	iter.SetPointKeyFilter(nil)
+	require.Equal(t, []string{key(0), key(1), key(2)}, collect(iter.First()))
+}
+
+func TestIteratorSkipCorruptBlocks(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	// Place every key in its own data block.
+	opts.Levels = []LevelOptions{{BlockSize: 1}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	const n = 10
+	key := func(i int) string { return fmt.Sprintf("k%02d", i) }
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(key(i)), []byte(key(i)), nil))
+	}
+	require.NoError(t, d.Flush())
+	var files []*fileMetadata
+	d.mu.Lock()
+	d.mu.versions.currentVersion().Levels[0].Slice().Each(func(f *fileMetadata) {
+		files = append(files, f)
+	})
+	d.mu.Unlock()
+	require.Len(t, files, 1)
+	require.NoError(t, d.Close())
+
+	// Corrupt the data block holding the middle key.
+	path := base.MakeFilepath(mem, "", fileTypeTable, files[0].FileNum)
+	f, err := mem.Open(path)
+	require.NoError(t, err)
+	r, err := sstable.NewReader(f, sstable.ReaderOptions{})
+	require.NoError(t, err)
+	layout, err := r.Layout()
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Len(t, layout.Data, n)
+	corrupt := layout.Data[n/2].Offset
+	f, err = mem.Open(path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[corrupt] ^= 0xff
+	f, err = mem.Create(path)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// By default, iteration fails at the corrupt block.
+	iter := d.NewIter(nil)
+	for valid := iter.First(); valid; valid = iter.Next() {
+	}
+	require.True(t, errors.Is(iter.Error(), base.ErrCorruption))
+	require.True(t, errors.Is(iter.Close(), base.ErrCorruption))
+
+	// With SkipCorruptBlocks, the keys on either side of the corrupt block are
+	// returned.
+	iter = d.NewIter(&IterOptions{SkipCorruptBlocks: true})
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Error())
+	var expected []string
+	for i := 0; i < n; i++ {
+		if i != n/2 {
+			expected = append(expected, key(i))
+		}
+	}
+	require.Equal(t, expected, keys)
+	require.Equal(t, uint64(1), iter.Stats().InternalStats.SkippedCorruptBlocks)
+
+	// Reverse iteration skips the block too.
+	keys = keys[:0]
+	for valid := iter.Last(); valid; valid = iter.Prev() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Error())
+	require.Len(t, keys, n-1)
+	require.NoError(t, iter.Close())
+}
+
 func TestIteratorGuaranteedDurable(t *testing.T) {
 	mem := vfs.NewMem()
 	opts := &Options{FS: mem}
@@ -1534,19 +1733,197 @@ func TestIteratorGuaranteedDurable(t *testing.T) {
 		failFunc(t, d.NewIndexedBatch())
 	})
 	t.Run("db", func(t *testing.T) {
-		d.Set([]byte("k"), []byte("v"), nil)
-		foundKV := func(o *IterOptions) bool {
+		foundKV := func(o *IterOptions, k string) bool {
 			iter := d.NewIter(o)
 			defer iter.Close()
-			iter.SeekGE([]byte("k"))
-			return iter.Valid()
-		}
-		require.True(t, foundKV(nil))
-		require.False(t, foundKV(&iterOptions))
+			return iter.SeekGE([]byte(k)) && string(iter.Key()) == k
+		}
+		// A key committed without syncing the WAL is not durable.
+		require.NoError(t, d.Set([]byte("k"), []byte("v"), NoSync))
+		require.True(t, foundKV(nil, "k"))
+		require.False(t, foundKV(&iterOptions, "k"))
+
+		// An iterator that switches to reading only durable state through
+		// SetOptions stops observing the key.
+		iter := d.NewIter(nil)
+		require.True(t, iter.SeekGE([]byte("k")))
+		iter.SetOptions(&iterOptions)
+		require.False(t, iter.SeekGE([]byte("k")))
+		iter.SetOptions(&IterOptions{})
+		require.True(t, iter.SeekGE([]byte("k")))
+		require.NoError(t, iter.Close())
+
+		// Syncing a later batch makes every earlier batch durable.
+		require.NoError(t, d.Set([]byte("l"), []byte("v"), Sync))
+		require.True(t, foundKV(&iterOptions, "k"))
+		require.True(t, foundKV(&iterOptions, "l"))
+
+		// Flushing makes unsynced keys durable.
+		require.NoError(t, d.Set([]byte("m"), []byte("v"), NoSync))
+		require.False(t, foundKV(&iterOptions, "m"))
+		require.NoError(t, d.Flush())
+		require.True(t, foundKV(nil, "m"))
+		require.True(t, foundKV(&iterOptions, "m"))
+	})
+	t.Run("disable-wal", func(t *testing.T) {
+		d, err := Open("", &Options{FS: vfs.NewMem(), DisableWAL: true})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		// Without a WAL, only flushed keys are durable.
+		require.NoError(t, d.Set([]byte("k"), []byte("v"), NoSync))
+		iter := d.NewIter(&iterOptions)
+		require.False(t, iter.First())
+		require.NoError(t, iter.Close())
 		require.NoError(t, d.Flush())
-		require.True(t, foundKV(nil))
-		require.True(t, foundKV(&iterOptions))
+		iter = d.NewIter(&iterOptions)
+		require.True(t, iter.First())
+		require.NoError(t, iter.Close())
+	})
+}
+
+func TestIteratorNoCachePollution(t *testing.T) {
+	c := NewCache(64 << 20)
+	defer c.Unref()
+	d, err := Open("", &Options{
+		Cache: c,
+		FS:    vfs.NewMem(),
+		// Use a single-level index, since index partitions are cached even
+		// when bypassing the cache for data blocks.
+		Levels: []LevelOptions{{BlockSize: 1024, IndexBlockSize: 1 << 20}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numKeys = 10000
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%05d", i)), value, nil))
+	}
+	require.NoError(t, d.Flush())
+
+	scan := func(iter *Iterator) {
+		n := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Error())
+		require.Equal(t, numKeys, n)
+	}
+
+	// Open the table and load the first data block into the cache.
+	iter := d.NewIter(nil)
+	require.True(t, iter.First())
+	require.NoError(t, iter.Close())
+	before := d.Metrics().BlockCache
+
+	iter = d.NewIter(&IterOptions{NoCachePollution: true})
+	scan(iter)
+	after := d.Metrics().BlockCache
+	require.Equal(t, before.Count, after.Count)
+	require.Equal(t, before.Size, after.Size)
+
+	// Clearing the option through SetOptions reconstructs the iterator stack,
+	// after which the scan populates the cache.
+	iter.SetOptions(&IterOptions{})
+	scan(iter)
+	require.NoError(t, iter.Close())
+	require.Greater(t, d.Metrics().BlockCache.Count, after.Count)
+}
+
+func TestIteratorNextPrefix(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write several versions of each key, spread across the memtable and
+	// multiple sstables.
+	const numKeys = 100
+	const numVersions = 10
+	ks := testkeys.Alpha(2)
+	for v := 1; v <= numVersions; v++ {
+		b := d.NewBatch()
+		for i := 0; i < numKeys; i++ {
+			k := testkeys.KeyAt(ks, i, v)
+			require.NoError(t, b.Set(k, k, nil))
+		}
+		require.NoError(t, b.Commit(nil))
+		if v%3 == 0 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	iter := d.NewIter(nil)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.NextPrefix() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Error())
+	require.Len(t, keys, numKeys)
+	for i, k := range keys {
+		require.Equal(t, string(testkeys.KeyAt(ks, i, numVersions)), k)
+	}
+	stats := iter.Stats()
+	require.Equal(t, numKeys, stats.ForwardStepCount[InterfaceCall])
+	require.NoError(t, iter.Close())
+}
+
+func TestIteratorPrevPrefix(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
 	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write several versions of each key, spread across the memtable and
+	// multiple sstables.
+	const numKeys = 100
+	const numVersions = 10
+	ks := testkeys.Alpha(2)
+	for v := 1; v <= numVersions; v++ {
+		b := d.NewBatch()
+		for i := 0; i < numKeys; i++ {
+			k := testkeys.KeyAt(ks, i, v)
+			require.NoError(t, b.Set(k, k, nil))
+		}
+		require.NoError(t, b.Commit(nil))
+		if v%3 == 0 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	scan := func(o *IterOptions, lo, hi int) {
+		iter := d.NewIter(o)
+		defer func() { require.NoError(t, iter.Close()) }()
+
+		// Last lands on the oldest version of the last prefix, and PrevPrefix
+		// on the newest version of each preceding prefix.
+		require.True(t, iter.Last())
+		require.Equal(t, string(testkeys.KeyAt(ks, hi-1, 1)), string(iter.Key()))
+		var keys []string
+		for valid := iter.PrevPrefix(); valid; valid = iter.PrevPrefix() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		require.Len(t, keys, hi-lo-1)
+		for j, k := range keys {
+			require.Equal(t, string(testkeys.KeyAt(ks, hi-2-j, numVersions)), k)
+		}
+		stats := iter.Stats()
+		// Including the PrevPrefix that exhausted the iterator.
+		require.Equal(t, hi-lo, stats.ReverseStepCount[InterfaceCall])
+		require.Equal(t, 1, stats.ReverseSeekCount[InterfaceCall])
+	}
+	scan(nil, 0, numKeys)
+	scan(&IterOptions{
+		LowerBound: testkeys.Key(ks, 20),
+		UpperBound: testkeys.Key(ks, 40),
+	}, 20, 40)
 }
 
 func TestIteratorBoundsLifetimes(t *testing.T) {
@@ -1691,6 +2068,119 @@ func TestIteratorBoundsLifetimes(t *testing.T) {
 // TestSetOptionsEquivalence tests equivalence between SetOptions to mutate an
 // iterator and constructing a new iterator with NewIter. The long-lived
 // iterator and the new iterator should surface identical iterator states.
+func TestIteratorRangeKeyStats(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write many overlapping range keys, which fragment into many spans.
+	ks := testkeys.Alpha(2)
+	buf := make([]byte, ks.MaxLen()+testkeys.MaxSuffixLen)
+	for i := 0; i < 100; i++ {
+		start := testkeys.Key(ks, i)
+		end := testkeys.Key(ks, i+10)
+		suffix := buf[:testkeys.WriteSuffix(buf, i)]
+		require.NoError(t, d.RangeKeySet(start, end, suffix, []byte("v"), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	// Each step of a range-key iterator surfaces a new range key.
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+	var steps uint64
+	for valid := iter.First(); valid; valid = iter.Next() {
+		steps++
+	}
+	require.NoError(t, iter.Error())
+	stats := iter.Stats()
+	require.Equal(t, uint64(1), stats.RangeKeyStats.BlockCount)
+	require.Equal(t, steps, stats.RangeKeyStats.Count)
+	require.Greater(t, int64(stats.RangeKeyStats.MergeDuration), int64(0))
+	require.Contains(t, stats.String(), fmt.Sprintf("(range-key-stats: (blocks 1, count %d))", steps))
+	iter.ResetStats()
+	require.Equal(t, RangeKeyIteratorStats{}, iter.Stats().RangeKeyStats)
+	require.NoError(t, iter.Close())
+
+	// Point keys within the same range key don't count it again.
+	require.NoError(t, d.RangeKeySet([]byte("zz"), []byte("zzz"), []byte("@1"), []byte("v"), nil))
+	for _, k := range []string{"zz1", "zz2", "zz3"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	iter = d.NewIter(&IterOptions{
+		KeyTypes:   IterKeyTypePointsAndRanges,
+		LowerBound: []byte("zz"),
+	})
+	for valid := iter.First(); valid; valid = iter.Next() {
+	}
+	require.NoError(t, iter.Error())
+	require.Equal(t, uint64(1), iter.Stats().RangeKeyStats.Count)
+	require.NoError(t, iter.Close())
+}
+
+func TestIteratorSetOptionsKeyTypes(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.RangeKeySet([]byte("b"), []byte("d"), []byte("@5"), []byte("v"), nil))
+	require.NoError(t, d.Flush())
+
+	// Count the sstable point iterators opened. Exhausting the iterator
+	// closes the sstable iterator, so each section repositions the iterator
+	// before resetting the count.
+	var opened int
+	newIters := d.newIters
+	d.newIters = func(
+		file *manifest.FileMetadata, opts *IterOptions, internalOpts internalIterOpts,
+	) (internalIterator, keyspan.FragmentIterator, error) {
+		opened++
+		return newIters(file, opts, internalOpts)
+	}
+
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsOnly})
+	defer func() { require.NoError(t, iter.Close()) }()
+	scan := func() string {
+		var buf bytes.Buffer
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s", iter.Key())
+			if _, hasRange := iter.HasPointAndRange(); hasRange {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&buf, "[%s-%s)", start, end)
+			}
+			fmt.Fprint(&buf, " ")
+		}
+		require.NoError(t, iter.Error())
+		return buf.String()
+	}
+	require.Equal(t, "a b c ", scan())
+
+	// Switching to points and ranges surfaces the range key, reusing the
+	// existing point iterator stack.
+	require.True(t, iter.First())
+	opened = 0
+	iter.SetOptions(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	require.False(t, iter.Valid())
+	require.Equal(t, "a b[b-d) c[b-d) ", scan())
+	require.Equal(t, 0, opened)
+
+	// Switching back hides it again.
+	require.True(t, iter.First())
+	opened = 0
+	iter.SetOptions(&IterOptions{KeyTypes: IterKeyTypePointsOnly})
+	require.False(t, iter.Valid())
+	require.Equal(t, "a b c ", scan())
+	require.Equal(t, 0, opened)
+}
+
 func TestSetOptionsEquivalence(t *testing.T) {
 	seed := uint64(time.Now().UnixNano())
 	// Call a helper function with the seed so that the seed appears within
@@ -1860,6 +2350,53 @@ func BenchmarkIteratorNext(b *testing.B) {
 	}
 }
 
+func BenchmarkIteratorNextPrefix(b *testing.B) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	}
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", opts)
+	require.NoError(b, err)
+	defer func() { require.NoError(b, d.Close()) }()
+
+	const numVersions = 10
+	ks := testkeys.Alpha(3)
+	ks = ks.EveryN(ks.Count() / 1000)
+	batch := d.NewBatch()
+	for i := 0; i < ks.Count(); i++ {
+		for v := 1; v <= numVersions; v++ {
+			k := testkeys.KeyAt(ks, i, v)
+			require.NoError(b, batch.Set(k, k, nil))
+		}
+	}
+	require.NoError(b, batch.Commit(nil))
+	require.NoError(b, d.Flush())
+
+	b.Run("next", func(b *testing.B) {
+		iter := d.NewIter(nil)
+		var prefix []byte
+		for i := 0; i < b.N; i++ {
+			for valid := iter.First(); valid; valid = iter.Next() {
+				k := iter.Key()
+				if p := k[:testkeys.Comparer.Split(k)]; !bytes.Equal(p, prefix) {
+					prefix = append(prefix[:0], p...)
+				}
+			}
+		}
+		require.NoError(b, iter.Close())
+	})
+	b.Run("next-prefix", func(b *testing.B) {
+		iter := d.NewIter(nil)
+		for i := 0; i < b.N; i++ {
+			for valid := iter.First(); valid; valid = iter.NextPrefix() {
+			}
+		}
+		require.NoError(b, iter.Close())
+	})
+}
+
 func BenchmarkIteratorPrev(b *testing.B) {
 	m, _ := buildMemTable(b)
 	iter := &Iterator{
@@ -2350,3 +2887,329 @@ func BenchmarkCombinedIteratorSeek(b *testing.B) {
 		})
 	}
 }
+
+func TestIteratorCloneWithBounds(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	const numKeys = 10000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+	b := d.NewBatch()
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, b.Set(key(i), key(i), nil))
+		if i%1000 == 999 {
+			require.NoError(t, b.Commit(nil))
+			require.NoError(t, d.Flush())
+			b = d.NewBatch()
+		}
+	}
+	require.NoError(t, b.Close())
+
+	parent := d.NewIter(&IterOptions{LowerBound: key(10), UpperBound: key(20)})
+
+	// Writes after the parent was constructed must not be visible to clones.
+	require.NoError(t, d.Set(key(numKeys), nil, nil))
+
+	const numClones = 2000
+	const span = numKeys / numClones
+	var wg sync.WaitGroup
+	errCh := make(chan error, numClones)
+	clones := make([]*Iterator, numClones)
+	for c := range clones {
+		clones[c], err = parent.CloneWithBounds(key(c*span), key((c+1)*span), nil)
+		require.NoError(t, err)
+	}
+	for c := range clones {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			it := clones[c]
+			n := 0
+			for valid := it.First(); valid; valid = it.Next() {
+				if !bytes.Equal(it.Key(), key(c*span+n)) {
+					errCh <- errors.Errorf("clone %d: found key %q, expected %q", c, it.Key(), key(c*span+n))
+					return
+				}
+				n++
+			}
+			if n != span {
+				errCh <- errors.Errorf("clone %d: found %d keys, expected %d", c, n, span)
+			}
+			errCh <- it.Close()
+		}(c)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	// The parent must remain usable after all of its clones are closed, and
+	// retains its own bounds.
+	var n int
+	for valid := parent.First(); valid; valid = parent.Next() {
+		n++
+	}
+	require.Equal(t, 10, n)
+
+	// Closing the parent before a clone must not affect the clone.
+	clone, err := parent.CloneWithBounds(nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, parent.Close())
+	n = 0
+	for valid := clone.First(); valid; valid = clone.Next() {
+		n++
+	}
+	require.Equal(t, numKeys, n)
+	require.NoError(t, clone.Close())
+}
+
+func TestIteratorRangeKeyChanged(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// The overlapping range keys [b,e)@5 and [d,g)@3 fragment into [b,d),
+	// [d,e) and [e,g), followed by the range key [i,k)@1.
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+	}
+	require.NoError(t, d.RangeKeySet([]byte("b"), []byte("e"), []byte("@5"), nil, nil))
+	require.NoError(t, d.RangeKeySet([]byte("d"), []byte("g"), []byte("@3"), nil, nil))
+	require.NoError(t, d.RangeKeySet([]byte("i"), []byte("k"), []byte("@1"), nil, nil))
+
+	// format describes the iterator position, suffixing keys at which
+	// RangeKeyChanged fires with an asterisk.
+	format := func(iter *Iterator) string {
+		if !iter.Valid() {
+			require.False(t, iter.RangeKeyChanged())
+			return "."
+		}
+		if iter.RangeKeyChanged() {
+			return string(iter.Key()) + "*"
+		}
+		return string(iter.Key())
+	}
+	check := func() {
+		iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+		defer func() { require.NoError(t, iter.Close()) }()
+
+		var res []string
+		for iter.First(); iter.Valid(); iter.Next() {
+			res = append(res, format(iter))
+		}
+		res = append(res, format(iter))
+		require.Equal(t, "a b* c d* e* f g* h i* j .", strings.Join(res, " "))
+
+		res = res[:0]
+		for iter.Last(); iter.Valid(); iter.Prev() {
+			res = append(res, format(iter))
+		}
+		res = append(res, format(iter))
+		require.Equal(t, "j* i h* g f* e d* c* b a* .", strings.Join(res, " "))
+
+		// Seeking within the current range key doesn't change it, but seeking
+		// after SetBounds does, since the iterator was invalidated.
+		res = res[:0]
+		iter.SeekGE([]byte("c"))
+		res = append(res, format(iter))
+		iter.SeekLT([]byte("c"))
+		res = append(res, format(iter))
+		iter.SeekGE([]byte("h"))
+		res = append(res, format(iter))
+		iter.Next()
+		res = append(res, format(iter))
+		iter.SetBounds([]byte("a"), []byte("j"))
+		iter.SeekGE([]byte("i"))
+		res = append(res, format(iter))
+		iter.SeekGE([]byte("i"))
+		res = append(res, format(iter))
+		require.Equal(t, "c* b h* i* i* i", strings.Join(res, " "))
+	}
+
+	// Check both while the keys are in the memtable and once flushed.
+	check()
+	require.NoError(t, d.Flush())
+	check()
+}
+
+func TestIteratorLevelStats(t *testing.T) {
+	d, err := Open("", &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write even keys to L6, and odd keys to L0.
+	for i := 0; i < 100; i += 2 {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), []byte("foo"), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("000"), []byte("100"), false /* parallelize */))
+	for i := 1; i < 100; i += 2 {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), []byte("bar"), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	iter := d.NewIter(nil)
+	defer func() { require.NoError(t, iter.Close()) }()
+	for _, k := range []string{"010", "051", "099"} {
+		require.True(t, iter.SeekGE([]byte(k)))
+		require.Equal(t, k, string(iter.Key()))
+	}
+
+	stats := iter.Stats()
+	var blockBytes, blockCount uint64
+	for level, s := range stats.LevelStats {
+		if level == 0 || level == numLevels-1 {
+			require.NotZero(t, s.BlockBytes, "L%d", level)
+			require.NotZero(t, s.BlockCount, "L%d", level)
+		} else {
+			require.Zero(t, s, "L%d", level)
+		}
+		blockBytes += s.BlockBytes
+		blockCount += s.BlockCount
+	}
+	require.Equal(t, stats.InternalStats.BlockBytes, blockBytes)
+	require.Equal(t, stats.InternalStats.BlockCount, blockCount)
+
+	iter.ResetStats()
+	require.Zero(t, iter.Stats().LevelStats)
+}
+
+// flakyStorage wraps a remote.Storage, failing reads of its objects with err
+// while it's set.
+type flakyStorage struct {
+	remote.Storage
+	mu  sync.Mutex
+	err error
+}
+
+var errTransient = errors.New("transient failure")
+
+func (s *flakyStorage) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *flakyStorage) getErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *flakyStorage) ReadObject(objName string) (remote.ObjectReader, int64, error) {
+	if err := s.getErr(); err != nil {
+		return nil, 0, err
+	}
+	r, size, err := s.Storage.ReadObject(objName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &flakyReader{ObjectReader: r, s: s}, size, nil
+}
+
+func (s *flakyStorage) IsRetryableError(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+type flakyReader struct {
+	remote.ObjectReader
+	s *flakyStorage
+}
+
+func (r *flakyReader) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.s.getErr(); err != nil {
+		return 0, err
+	}
+	return r.ObjectReader.ReadAt(p, off)
+}
+
+func TestIteratorReset(t *testing.T) {
+	storage := &flakyStorage{Storage: remote.NewInMem()}
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		RemoteStorage:               map[remote.Locator]remote.Storage{"bucket": storage},
+	}
+	opts.Experimental.CreateOnRemote = "bucket"
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// flush writes the keys to a new sstable, whose blocks haven't been read.
+	flush := func(keys ...string) {
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	scan := func(iter *Iterator, start string) string {
+		var keys []string
+		for valid := iter.SeekGE([]byte(start)); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		return strings.Join(keys, " ")
+	}
+
+	// A retryable error is sticky until the iterator is reset, after which
+	// the iterator may be re-seeked.
+	flush("a", "b", "c")
+	iter := d.NewIter(&IterOptions{RetryPolicy: &RetryPolicy{}})
+	storage.setErr(errTransient)
+	require.False(t, iter.First())
+	require.True(t, errors.Is(iter.Error(), objstorage.ErrRetryable))
+	storage.setErr(nil)
+	require.False(t, iter.SeekGE([]byte("a")))
+	require.True(t, errors.Is(iter.Error(), errTransient))
+	require.NoError(t, iter.Reset())
+	require.NoError(t, iter.Error())
+	require.Equal(t, "a b c", scan(iter, "a"))
+	require.NoError(t, iter.Reset())
+	require.NoError(t, iter.Close())
+
+	// A non-retryable error remains sticky after the storage recovers.
+	flush("d", "e")
+	errPermanent := errors.New("permanent failure")
+	iter = d.NewIter(&IterOptions{RetryPolicy: &RetryPolicy{}})
+	storage.setErr(errPermanent)
+	require.False(t, iter.SeekGE([]byte("d")))
+	storage.setErr(nil)
+	require.True(t, errors.Is(iter.Reset(), errPermanent))
+	require.False(t, iter.SeekGE([]byte("d")))
+	require.False(t, iter.First())
+	require.True(t, errors.Is(iter.Error(), errPermanent))
+	require.True(t, errors.Is(iter.Close(), errPermanent))
+
+	// The policy bounds the number of times the iterator may be reset.
+	flush("f")
+	iter = d.NewIter(&IterOptions{RetryPolicy: &RetryPolicy{MaxRetries: 1}})
+	storage.setErr(errTransient)
+	require.False(t, iter.SeekGE([]byte("f")))
+	require.NoError(t, iter.Reset())
+	require.False(t, iter.SeekGE([]byte("f")))
+	storage.setErr(nil)
+	require.True(t, errors.Is(iter.Reset(), errTransient))
+	require.True(t, errors.Is(iter.Close(), errTransient))
+
+	// Without a RetryPolicy, no error is retryable.
+	flush("g")
+	iter = d.NewIter(nil)
+	storage.setErr(errTransient)
+	require.False(t, iter.SeekGE([]byte("g")))
+	storage.setErr(nil)
+	require.True(t, errors.Is(iter.Reset(), errTransient))
+	require.True(t, errors.Is(iter.Close(), errTransient))
+
+	iter = d.NewIter(nil)
+	require.Equal(t, "a b c d e f g", scan(iter, "a"))
+	require.NoError(t, iter.Close())
+}
@@ -27,6 +27,18 @@ type tableNewIters func(
 type internalIterOpts struct {
 	bytesIterated      *uint64
 	boundLimitedFilter sstable.BoundLimitedBlockPropertyFilter
+	// immediateSuccessor is the Comparer's optional ImmediateSuccessor, used
+	// by levelIter.SeekPrefixGE to determine whether a prefix lies wholly
+	// within the current file.
+	immediateSuccessor ImmediateSuccessor
+	// compaction is set for the iterators of a compaction's inputs, which
+	// surface BLOBSET keys as-is rather than reading their values from blob
+	// files.
+	compaction bool
+	// lazyBlobValues is set for the iterators of an Iterator, which surface
+	// BLOBSET keys as-is, deferring the reads of their values until they're
+	// retrieved.
+	lazyBlobValues bool
 }
 
 // levelIter provides a merged view of the sstables in a level.
@@ -55,6 +67,9 @@ type levelIter struct {
 	logger Logger
 	cmp    Compare
 	split  Split
+	// succBuf is a scratch buffer for the prefix's immediate successor
+	// computed within SeekPrefixGE.
+	succBuf []byte
 	// The lower/upper bounds for iteration as specified at creation or the most
 	// recent call to SetBounds.
 	lower []byte
@@ -235,6 +250,8 @@ func (l *levelIter) init(
 	l.tableOpts.TableFilter = opts.TableFilter
 	l.tableOpts.PointKeyFilters = opts.PointKeyFilters
 	l.tableOpts.UseL6Filters = opts.UseL6Filters
+	l.tableOpts.NoCachePollution = opts.NoCachePollution
+	l.tableOpts.SkipCorruptBlocks = opts.SkipCorruptBlocks
 	l.tableOpts.level = l.level
 	l.cmp = cmp
 	l.split = split
@@ -704,7 +721,12 @@ func (l *levelIter) SeekPrefixGE(
 	// next file will defeat the optimization for the next SeekPrefixGE that is
 	// called with flags.TrySeekUsingNext(), since for sparse key spaces it is
 	// likely that the next key will also be contained in the current file.
-	if n := l.split(l.iterFile.LargestPointKey.UserKey); l.cmp(prefix, l.iterFile.LargestPointKey.UserKey[:n]) < 0 {
+	if succ := l.internalOpts.immediateSuccessor; succ != nil {
+		l.succBuf = succ(l.succBuf[:0], prefix)
+		if l.cmp(l.succBuf, l.iterFile.LargestPointKey.UserKey) <= 0 {
+			return nil, nil
+		}
+	} else if n := l.split(l.iterFile.LargestPointKey.UserKey); l.cmp(prefix, l.iterFile.LargestPointKey.UserKey[:n]) < 0 {
 		return nil, nil
 	}
 	return l.verify(l.skipEmptyFileForward())
@@ -1029,7 +1051,12 @@ func (l *levelIter) Error() error {
 
 func (l *levelIter) Close() error {
 	if l.iter != nil {
-		l.stats.Merge(l.iter.Stats())
+		stats := l.iter.Stats()
+		if stats.SkippedCorruptBlocks > 0 && l.iterFile != nil {
+			l.logger.Infof("levelIter %s: skipped %d corrupt block(s) in %s",
+				l.level, stats.SkippedCorruptBlocks, l.iterFile.FileNum)
+		}
+		l.stats.Merge(stats)
 		l.err = l.iter.Close()
 		l.iter = nil
 	}
@@ -16,6 +16,12 @@ type Merge = base.Merge
 // Merger exports the base.Merger type.
 type Merger = base.Merger
 
+// RangeKeyMerge exports the base.RangeKeyMerge type.
+type RangeKeyMerge = base.RangeKeyMerge
+
+// RangeKeyMerger exports the base.RangeKeyMerger type.
+type RangeKeyMerger = base.RangeKeyMerger
+
 // ValueMerger exports the base.ValueMerger type.
 type ValueMerger = base.ValueMerger
 
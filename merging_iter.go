@@ -13,6 +13,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
 )
 
 type mergingIterLevel struct {
@@ -275,6 +276,20 @@ func newMergingIter(
 	return m
 }
 
+// NewMergingIter returns an iterator that merges the provided internal
+// iterators, which may be custom sources as well as Pebble's own, in the
+// manner of the iterator that merges the levels of the LSM. The merged
+// iterator surfaces every key of its inputs in increasing internal key order,
+// as defined by cmp, in either direction; it doesn't resolve keys shadowed by
+// newer keys with the same user key. As with newMergingIter, the inputs are
+// assumed to contain no duplicate internal keys. An error encountered by an
+// input is surfaced by the merged iterator's Error, and closing the merged
+// iterator closes its inputs. SeekPrefixGE treats a user key in its entirety
+// as its prefix.
+func NewMergingIter(cmp Compare, iters ...InternalIterator) InternalIterator {
+	return newMergingIter(nil /* logger */, cmp, func(a []byte) int { return len(a) }, iters...)
+}
+
 func (m *mergingIter) init(
 	opts *IterOptions, cmp Compare, split Split, levels ...mergingIterLevel,
 ) {
@@ -1196,6 +1211,22 @@ func (m *mergingIter) Stats() base.InternalIteratorStats {
 	return stats
 }
 
+// addLevelStats adds the block read stats of the levelIters over each level of
+// the LSM to levelStats. The stats of L0's sublevels are added to L0's.
+func (m *mergingIter) addLevelStats(levelStats *[numLevels]IteratorLevelStats) {
+	for i := range m.levels {
+		li, ok := m.levels[i].iter.(*levelIter)
+		if !ok {
+			continue
+		}
+		stats := li.Stats()
+		s := &levelStats[manifest.LevelToInt(li.level)]
+		s.BlockBytes += stats.BlockBytes
+		s.BlockCount += stats.BlockCount
+		s.BlockReadDuration += stats.BlockReadDuration
+	}
+}
+
 // ResetStats implements InternalIteratorWithStats.
 func (m *mergingIter) ResetStats() {
 	m.stats = InternalIteratorStats{}
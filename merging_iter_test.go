@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
@@ -131,6 +132,65 @@ func TestMergingIterNextPrev(t *testing.T) {
 	}
 }
 
+func TestNewMergingIter(t *testing.T) {
+	newSource := func(keys ...string) InternalIterator {
+		f := &fakeIter{}
+		for _, key := range keys {
+			j := strings.Index(key, ":")
+			f.keys = append(f.keys, base.ParseInternalKey(key[:j]))
+			f.vals = append(f.vals, []byte(key[j+1:]))
+		}
+		return f
+	}
+	newIter := func() InternalIterator {
+		return NewMergingIter(DefaultComparer.Compare,
+			newSource("a.SET.3:a3", "d.SET.1:d1", "g.SET.1:g1"),
+			newSource("a.SET.2:a2", "b.MERGE.5:b5", "e.DEL.4:"),
+			newSource("c.SET.2:c2", "e.SET.3:e3", "f.SET.1:f1"))
+	}
+	expected := []string{
+		"a#3,SET:a3", "a#2,SET:a2", "b#5,MERGE:b5", "c#2,SET:c2", "d#1,SET:d1",
+		"e#4,DEL:", "e#3,SET:e3", "f#1,SET:f1", "g#1,SET:g1",
+	}
+	format := func(k *InternalKey, v []byte) string {
+		return fmt.Sprintf("%s#%d,%s:%s", k.UserKey, k.SeqNum(), k.Kind(), v)
+	}
+
+	iter := newIter()
+	var forward, reverse []string
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		forward = append(forward, format(k, v))
+	}
+	for k, v := iter.Last(); k != nil; k, v = iter.Prev() {
+		reverse = append([]string{format(k, v)}, reverse...)
+	}
+	require.Equal(t, expected, forward)
+	require.Equal(t, expected, reverse)
+
+	// Switching directions mid-iteration.
+	k, v := iter.SeekGE([]byte("c"), SeekGEFlagsNone)
+	require.Equal(t, "c#2,SET:c2", format(k, v))
+	k, v = iter.Next()
+	require.Equal(t, "d#1,SET:d1", format(k, v))
+	k, v = iter.Prev()
+	require.Equal(t, "c#2,SET:c2", format(k, v))
+	k, v = iter.SeekLT([]byte("e"), SeekLTFlagsNone)
+	require.Equal(t, "d#1,SET:d1", format(k, v))
+	k, v = iter.Next()
+	require.Equal(t, "e#4,DEL:", format(k, v))
+	require.NoError(t, iter.Error())
+	require.NoError(t, iter.Close())
+
+	// An error encountered by one of the inputs is surfaced by the merged
+	// iterator.
+	iter = NewMergingIter(DefaultComparer.Compare,
+		newSource("a.SET.1:a1"), newErrorIter(errors.New("injected")))
+	k, _ = iter.First()
+	require.Nil(t, k)
+	require.EqualError(t, iter.Error(), "injected")
+	require.Error(t, iter.Close())
+}
+
 func TestMergingIterCornerCases(t *testing.T) {
 	memFS := vfs.NewMem()
 	cmp := DefaultComparer.Compare
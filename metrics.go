@@ -11,6 +11,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/redact"
 )
@@ -122,6 +123,84 @@ func (m *LevelMetrics) format(w redact.SafePrinter, score redact.SafeValue) {
 		redact.Safe(m.WriteAmp()))
 }
 
+// CompactionKindMetrics holds the metrics for the compactions of one kind.
+type CompactionKindMetrics struct {
+	// The number of compactions of this kind.
+	Count int64
+	// The number of bytes read from the input sstables. This is zero for move
+	// and delete-only compactions, which do not read their inputs.
+	BytesRead uint64
+	// The number of bytes written to the output sstables.
+	BytesWritten uint64
+	// The number of bytes of sstables moved to the output level by move
+	// compactions.
+	BytesMoved uint64
+}
+
+// KeyMetrics holds the number of keys of each kind stored in sstables.
+type KeyMetrics struct {
+	// The number of SETs, including SETWITHDELs.
+	SetCount uint64
+	// The number of MERGEs.
+	MergeCount uint64
+	// The number of point DELs.
+	DeleteCount uint64
+	// The number of SINGLEDELs.
+	SingleDeleteCount uint64
+	// The number of RANGEDELs.
+	RangeDeleteCount uint64
+	// The number of RANGEKEYSETs.
+	RangeKeySetCount uint64
+	// The number of RANGEKEYUNSETs.
+	RangeKeyUnsetCount uint64
+	// The number of RANGEKEYDELs.
+	RangeKeyDeleteCount uint64
+}
+
+// tableKeyMetrics returns the number of keys of each kind recorded in an
+// sstable's stats.
+func tableKeyMetrics(s *manifest.TableStats) KeyMetrics {
+	var m KeyMetrics
+	if n := s.NumDeletions + s.NumMergeOperands + s.NumSingleDeletions; s.NumEntries > n {
+		m.SetCount = s.NumEntries - n
+	}
+	m.MergeCount = s.NumMergeOperands
+	m.DeleteCount = s.NumDeletions - s.NumRangeDeletions
+	m.SingleDeleteCount = s.NumSingleDeletions
+	m.RangeDeleteCount = s.NumRangeDeletions
+	m.RangeKeySetCount = s.NumRangeKeySets
+	m.RangeKeyUnsetCount = s.NumRangeKeyUnsets
+	m.RangeKeyDeleteCount = s.NumRangeKeyDels
+	return m
+}
+
+// addTable adds the keys recorded in an sstable's stats to the counts.
+func (m *KeyMetrics) addTable(s *manifest.TableStats) {
+	u := tableKeyMetrics(s)
+	m.SetCount += u.SetCount
+	m.MergeCount += u.MergeCount
+	m.DeleteCount += u.DeleteCount
+	m.SingleDeleteCount += u.SingleDeleteCount
+	m.RangeDeleteCount += u.RangeDeleteCount
+	m.RangeKeySetCount += u.RangeKeySetCount
+	m.RangeKeyUnsetCount += u.RangeKeyUnsetCount
+	m.RangeKeyDeleteCount += u.RangeKeyDeleteCount
+}
+
+// removeTable removes the keys recorded in an sstable's stats from the
+// counts. The stats must have been added with addTable.
+func (m *KeyMetrics) removeTable(s *manifest.TableStats) {
+	u := tableKeyMetrics(s)
+	m.SetCount -= u.SetCount
+	m.MergeCount -= u.MergeCount
+	m.DeleteCount -= u.DeleteCount
+	m.SingleDeleteCount -= u.SingleDeleteCount
+	m.RangeDeleteCount -= u.RangeDeleteCount
+	m.RangeKeySetCount -= u.RangeKeySetCount
+	m.RangeKeyUnsetCount -= u.RangeKeyUnsetCount
+	m.RangeKeyDeleteCount -= u.RangeKeyDeleteCount
+}
+
 // Metrics holds metrics for various subsystems of the DB such as the Cache,
 // Compactions, WAL, and per-Level metrics.
 //
@@ -131,6 +210,17 @@ func (m *LevelMetrics) format(w redact.SafePrinter, score redact.SafeValue) {
 type Metrics struct {
 	BlockCache CacheMetrics
 
+	// Commit holds the distributions of the latencies of the stages of the
+	// commit pipeline, in microseconds, since the DB was opened. See
+	// CommitStage for the stages. The latencies are sampled from a fraction
+	// of the commits, and are approximate.
+	Commit struct {
+		WALWriteLatencyMicros      *hdrhistogram.Histogram
+		MemTableApplyLatencyMicros *hdrhistogram.Histogram
+		PublishLatencyMicros       *hdrhistogram.Histogram
+		WALSyncLatencyMicros       *hdrhistogram.Histogram
+	}
+
 	Compact struct {
 		// The total number of compactions, and per-compaction type counts.
 		Count            int64
@@ -154,6 +244,9 @@ type Metrics struct {
 		// compaction. Such files are compacted in a rewrite compaction
 		// when no other compactions are picked.
 		MarkedFiles int
+		// Per-kind counts and bytes of compactions, keyed by the kind of
+		// compaction. Flushes, which are counted by Flush.Count, are excluded.
+		ByKind map[CompactionKind]CompactionKindMetrics
 	}
 
 	Flush struct {
@@ -163,6 +256,13 @@ type Metrics struct {
 
 	Filter FilterMetrics
 
+	// Keys holds the number of keys of each kind stored in sstables, summed
+	// from the table stats collected in the background. The counts are
+	// estimates: keys in memtables and in sstables whose stats haven't been
+	// loaded yet are excluded, and keys that are shadowed or deleted are
+	// included until a compaction drops them.
+	Keys KeyMetrics
+
 	Levels [numLevels]LevelMetrics
 
 	MemTable struct {
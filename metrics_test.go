@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/redact"
 	"github.com/stretchr/testify/require"
@@ -245,3 +248,228 @@ zmemtbl         0     0 B
 		t.Fatalf("expected%s\nbut found%s", expected, s)
 	}
 }
+
+func TestMetricsBlockCacheByType(t *testing.T) {
+	c := cache.New(64 << 20)
+	defer c.Unref()
+	opts := &Options{
+		Cache:    c,
+		Comparer: testkeys.Comparer,
+		FS:       vfs.NewMem(),
+	}
+	opts.Levels = []LevelOptions{{
+		FilterPolicy: bloom.FilterPolicy(10),
+		// Use a single-level index, so that each Get reads exactly one index
+		// block.
+		IndexBlockSize: 1 << 20,
+	}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), []byte("value"), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	// A prefix seek consults the filter block before reading the index and
+	// data blocks.
+	get := func(key string) cache.Metrics {
+		iter := d.NewIter(nil)
+		require.True(t, iter.SeekPrefixGE([]byte(key)))
+		require.Equal(t, "value", string(iter.Value()))
+		require.NoError(t, iter.Close())
+		return d.Metrics().BlockCache
+	}
+	delta := func(before, after cache.Metrics, typ string) cache.HitMiss {
+		return cache.HitMiss{
+			Hits:   after.ByType[typ].Hits - before.ByType[typ].Hits,
+			Misses: after.ByType[typ].Misses - before.ByType[typ].Misses,
+		}
+	}
+
+	m0 := d.Metrics().BlockCache
+	m1 := get("050")
+	// The first reads of the filter and data blocks miss.
+	require.Equal(t, cache.HitMiss{Misses: 1}, delta(m0, m1, "filter"))
+	require.Equal(t, cache.HitMiss{Misses: 1}, delta(m0, m1, "data"))
+
+	// A second read hits the data, index and filter blocks that are now
+	// cached.
+	m2 := get("050")
+	require.Equal(t, cache.HitMiss{Hits: 1}, delta(m1, m2, "data"))
+	require.Equal(t, cache.HitMiss{Hits: 1}, delta(m1, m2, "index"))
+	require.Equal(t, cache.HitMiss{Hits: 1}, delta(m1, m2, "filter"))
+	require.Equal(t, cache.HitMiss{}, delta(m1, m2, "range-del"))
+
+	// The per-type counters account for all hits and misses.
+	var sum cache.HitMiss
+	for _, hm := range m2.ByType {
+		sum.Hits += hm.Hits
+		sum.Misses += hm.Misses
+	}
+	require.Equal(t, cache.HitMiss{Hits: m2.Hits, Misses: m2.Misses}, sum)
+}
+
+func TestMetricsBlockCacheSharedByID(t *testing.T) {
+	c := cache.New(64 << 20)
+	defer c.Unref()
+	open := func(cacheID uint64) *DB {
+		d, err := Open("", &Options{Cache: c, CacheID: cacheID, FS: vfs.NewMem()})
+		require.NoError(t, err)
+		return d
+	}
+	// The first DB is assigned an ID explicitly, while the second is assigned
+	// one on open.
+	id1 := c.NewID()
+	d1 := open(id1)
+	defer func() { require.NoError(t, d1.Close()) }()
+	d2 := open(0)
+	defer func() { require.NoError(t, d2.Close()) }()
+	id2 := d2.cacheID
+	require.NotEqual(t, id1, id2)
+
+	// Write and read back a different amount of data through each DB.
+	load := func(d *DB, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100), nil))
+		}
+		require.NoError(t, d.Flush())
+		iter := d.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+	load(d1, 1000)
+	load(d2, 100)
+
+	m := d1.Metrics().BlockCache
+	require.Len(t, m.SizeByID, 2)
+	require.Greater(t, m.SizeByID[id1], m.SizeByID[id2])
+	require.Greater(t, m.SizeByID[id2], int64(0))
+	require.Equal(t, m.Size, m.SizeByID[id1]+m.SizeByID[id2])
+	require.Equal(t, m, d2.Metrics().BlockCache)
+}
+
+func TestMetricsCompactByKind(t *testing.T) {
+	d, err := Open("", &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	l6Files := func() []*fileMetadata {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var files []*fileMetadata
+		d.mu.versions.currentVersion().Levels[numLevels-1].Slice().Each(func(f *fileMetadata) {
+			files = append(files, f)
+		})
+		return files
+	}
+
+	// Compacting a single flushed sstable with no overlapping sstables in L6
+	// moves it to L6.
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("f"), false /* parallelize */))
+	files := l6Files()
+	require.Len(t, files, 1)
+	m := d.Metrics()
+	require.Equal(t, map[CompactionKind]CompactionKindMetrics{
+		CompactionKindMove: {Count: 1, BytesMoved: files[0].Size},
+	}, m.Compact.ByKind)
+	require.Equal(t, int64(1), m.Compact.MoveCount)
+
+	// Move an sstable of point tombstones to L6. Once its stats are loaded,
+	// it's picked for an elision-only compaction that drops the tombstones.
+	for _, k := range []string{"k", "l", "m", "n", "o"} {
+		require.NoError(t, d.Delete([]byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("k"), []byte("p"), false /* parallelize */))
+	files = l6Files()
+	require.Len(t, files, 2)
+	moved := files[1].Size
+
+	d.mu.Lock()
+	d.waitTableStats()
+	d.opts.DisableAutomaticCompactions = false
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 || d.mu.versions.metrics.Compact.ElisionOnlyCount == 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+
+	require.Len(t, l6Files(), 1)
+	m = d.Metrics()
+	require.Equal(t, int64(1), m.Compact.ElisionOnlyCount)
+	require.Equal(t, CompactionKindMetrics{Count: 2, BytesMoved: files[0].Size + moved},
+		m.Compact.ByKind[CompactionKindMove])
+	elision := m.Compact.ByKind[CompactionKindElisionOnly]
+	require.Equal(t, int64(1), elision.Count)
+	require.Equal(t, moved, elision.BytesRead)
+	require.Zero(t, elision.BytesWritten)
+	require.Zero(t, elision.BytesMoved)
+	require.Len(t, m.Compact.ByKind, 2)
+}
+
+func TestMetricsKeys(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:                    testkeys.Comparer,
+		DisableAutomaticCompactions: true,
+		FormatMajorVersion:          FormatNewest,
+		FS:                          vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write a known mix of keys, each kind within its own key range so
+	// that no key shadows or deletes another.
+	key := func(prefix string, i int) []byte { return []byte(fmt.Sprintf("%s%03d", prefix, i)) }
+	b := d.NewBatch()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, b.Set(key("a", i), nil, nil))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, b.Merge(key("b", i), nil, nil))
+	}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, b.Delete(key("c", i), nil))
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, b.SingleDelete(key("d", i), nil))
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, b.DeleteRange(key("e", 2*i), key("e", 2*i+1), nil))
+	}
+	for i := 0; i < 4; i++ {
+		require.NoError(t, b.RangeKeySet(key("f", 2*i), key("f", 2*i+1), nil, nil, nil))
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.RangeKeyUnset(key("g", 2*i), key("g", 2*i+1), nil, nil))
+	}
+	require.NoError(t, b.RangeKeyDelete(key("h", 0), key("h", 1), nil))
+	require.NoError(t, b.Commit(nil))
+
+	// Keys in the memtable aren't counted.
+	require.Zero(t, d.Metrics().Keys)
+
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+
+	keys := d.Metrics().Keys
+	require.Equal(t, uint64(100), keys.SetCount)
+	require.Equal(t, uint64(20), keys.MergeCount)
+	require.Equal(t, uint64(10), keys.DeleteCount)
+	require.Equal(t, uint64(5), keys.SingleDeleteCount)
+	require.Equal(t, uint64(3), keys.RangeDeleteCount)
+	require.Equal(t, uint64(4), keys.RangeKeySetCount)
+	require.Equal(t, uint64(2), keys.RangeKeyUnsetCount)
+	require.Equal(t, uint64(1), keys.RangeKeyDeleteCount)
+}
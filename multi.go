@@ -0,0 +1,497 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// multiLogRotateSize is the size beyond which the shared log of a Multi is
+// replaced by a new, empty log before the next commit.
+const multiLogRotateSize = 64 << 20
+
+// Multi is a group of DBs, the column families, each storing a logically
+// separate keyspace, which may be written atomically through MultiBatches.
+// The DB of each family is stored in a subdirectory of the Multi's directory
+// named after the family.
+//
+// The commit of a MultiBatch first writes the mutations of every family to a
+// log shared by the families and syncs it, and then applies each family's
+// mutations to its DB. Once it's synced to the shared log the commit is
+// durable: if applying it to a family fails, or the process crashes before
+// it's applied to every family, it's applied to the remaining families when
+// the Multi is next opened. A commit that fails before it's synced to the
+// shared log isn't applied to any family, and on reopen it's applied either
+// to all or to none of them, depending on whether its write to the shared
+// log persisted.
+//
+// A commit becomes visible to reads through the DB of each family as it's
+// applied to that family, so reads through the DBs returned by Family may
+// observe a commit applied to some families but not yet to others. Reads
+// through a MultiSnapshot observe every commit either in all of its families
+// or in none of them.
+//
+// The families may be read through the DBs returned by Family, but must only
+// be written through Multi.Apply: the recovery of a commit relies on the
+// sequence numbers of each family advancing only through the commits of
+// MultiBatches.
+type Multi struct {
+	fs       vfs.FS
+	dirname  string
+	names    []string
+	families map[string]*DB
+
+	mu struct {
+		sync.Mutex
+		logNum  uint64
+		logFile vfs.File
+		log     *record.Writer
+		// err is set once a commit fails after it may have been written to
+		// the shared log. Subsequent commits fail with err until the Multi is
+		// reopened, which completes any partially applied commit.
+		err error
+	}
+}
+
+// OpenMulti opens the named column families stored under dirname, creating
+// those that don't exist. Every family is opened with the provided options,
+// and shares their block cache. Commits that were written to the shared log
+// but not applied to every family are applied to the remaining ones before
+// OpenMulti returns.
+//
+// All the families written through a MultiBatch must be opened: OpenMulti
+// returns an error if the shared log holds mutations to a family that isn't.
+func OpenMulti(dirname string, families []string, opts *Options) (_ *Multi, err error) {
+	opts = opts.Clone().EnsureDefaults()
+	if opts.ReadOnly {
+		return nil, errors.New("pebble: cannot open a Multi read-only")
+	}
+	if opts.DisableWAL {
+		return nil, errors.New("pebble: cannot open a Multi with the WAL disabled")
+	}
+	if len(families) == 0 {
+		return nil, errors.New("pebble: a Multi requires at least one family")
+	}
+	if opts.Cache == nil {
+		// Share a block cache between the families, each of which holds its
+		// own reference.
+		opts.Cache = cache.New(cacheDefaultSize)
+		defer opts.Cache.Unref()
+	}
+
+	m := &Multi{
+		fs:       opts.FS,
+		dirname:  dirname,
+		names:    append([]string(nil), families...),
+		families: make(map[string]*DB, len(families)),
+	}
+	sort.Strings(m.names)
+	defer func() {
+		if err != nil {
+			_ = m.Close()
+		}
+	}()
+	for i, name := range m.names {
+		if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return nil, errors.Errorf("pebble: invalid family name %q", name)
+		}
+		if i > 0 && m.names[i-1] == name {
+			return nil, errors.Errorf("pebble: duplicate family %q", name)
+		}
+	}
+	// Create the directories of the families, syncing their parent so that
+	// they persist along with the shared log.
+	for _, name := range m.names {
+		if err := m.fs.MkdirAll(m.fs.PathJoin(dirname, name), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.syncDir(); err != nil {
+		return nil, err
+	}
+	for _, name := range m.names {
+		d, err := Open(m.fs.PathJoin(dirname, name), opts)
+		if err != nil {
+			return nil, err
+		}
+		m.families[name] = d
+	}
+
+	// Complete the commits in the existing shared logs, then replace them by
+	// a new log. The completed commits were synced to the families, so the
+	// existing logs may be removed once the new log is created.
+	logNums, err := m.listLogs()
+	if err != nil {
+		return nil, err
+	}
+	for _, logNum := range logNums {
+		if err := m.recoverLog(logNum); err != nil {
+			return nil, err
+		}
+	}
+	m.mu.logNum = 1
+	if n := len(logNums); n > 0 {
+		m.mu.logNum = logNums[n-1] + 1
+	}
+	if err := m.createLogLocked(); err != nil {
+		return nil, err
+	}
+	for _, logNum := range logNums {
+		if err := m.fs.Remove(m.logPath(logNum)); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Family returns the DB of the named family, or nil if the family wasn't
+// opened. The DB must not be written to directly, or closed.
+func (m *Multi) Family(name string) *DB {
+	return m.families[name]
+}
+
+// NewSnapshot returns a snapshot of every family that reflects the same set of
+// commits: each commit is visible in all of its families or in none of them.
+// It returns an error if a commit failed after it was applied to only some of
+// its families, until the Multi is reopened. The MultiSnapshot must be closed
+// before the Multi.
+func (m *Multi) NewSnapshot() (*MultiSnapshot, error) {
+	// Apply holds m.mu while a commit is applied to its families, so the
+	// snapshots can't observe a commit partially applied.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mu.err != nil {
+		return nil, m.mu.err
+	}
+	s := &MultiSnapshot{snapshots: make(map[string]*Snapshot, len(m.names))}
+	for _, name := range m.names {
+		s.snapshots[name] = m.families[name].NewSnapshot()
+	}
+	return s, nil
+}
+
+// NewBatch returns a new empty MultiBatch.
+func (m *Multi) NewBatch() *MultiBatch {
+	return &MultiBatch{m: m, batches: make(map[string]*Batch)}
+}
+
+// Apply atomically applies the mutations of every family in the MultiBatch,
+// syncing them. Commits are serialized: Apply doesn't return until the
+// commit is applied to every family. The commit is durable atomically, and
+// visible atomically to reads through a MultiSnapshot; see Multi.
+func (m *Multi) Apply(mb *MultiBatch) error {
+	if mb.m != m {
+		panic("pebble: MultiBatch belongs to another Multi")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mu.err != nil {
+		return m.mu.err
+	}
+
+	// Each family's mutations are recorded along with the sequence number
+	// that its batch will be assigned, which the family's sequence number
+	// advances past once the batch is applied. A batch holding only LogData
+	// doesn't advance the family's sequence number, so recovery couldn't tell
+	// whether it was applied. It holds no mutations to keep atomic, and isn't
+	// recorded.
+	var buf []byte
+	var tmp [binary.MaxVarintLen64]byte
+	var batches []*Batch
+	var dbs []*DB
+	var unlogged []*Batch
+	var unloggedDBs []*DB
+	for _, name := range m.names {
+		b := mb.batches[name]
+		if b == nil || b.Empty() {
+			continue
+		}
+		d := m.families[name]
+		if b.Count() == 0 {
+			unlogged = append(unlogged, b)
+			unloggedDBs = append(unloggedDBs, d)
+			continue
+		}
+		repr := b.Repr()
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(name)))]...)
+		buf = append(buf, name...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum))]...)
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(repr)))]...)
+		buf = append(buf, repr...)
+		batches = append(batches, b)
+		dbs = append(dbs, d)
+	}
+	if len(batches) == 0 {
+		return m.applyUnloggedLocked(unlogged, unloggedDBs)
+	}
+
+	if m.mu.log.Size() >= multiLogRotateSize {
+		if err := m.rotateLogLocked(); err != nil {
+			return err
+		}
+	}
+	_, err := m.mu.log.WriteRecord(buf)
+	if err == nil {
+		err = m.mu.log.Flush()
+	}
+	if err == nil {
+		err = m.mu.logFile.Sync()
+	}
+	if err != nil {
+		// The commit may nonetheless have been persisted to the shared log, in
+		// which case the next Open applies it.
+		m.mu.err = errors.Wrap(err, "pebble: writing commit to the shared log")
+		return m.mu.err
+	}
+	for i, b := range batches {
+		if err := dbs[i].Apply(b, Sync); err != nil {
+			m.mu.err = errors.Wrap(err, "pebble: commit applied to only some families")
+			return m.mu.err
+		}
+	}
+	return m.applyUnloggedLocked(unlogged, unloggedDBs)
+}
+
+// applyUnloggedLocked applies the batches holding only LogData, which aren't
+// recorded in the shared log, to their families.
+func (m *Multi) applyUnloggedLocked(batches []*Batch, dbs []*DB) error {
+	for i, b := range batches {
+		if err := dbs[i].Apply(b, Sync); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the shared log and the DBs of the families.
+func (m *Multi) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	if m.mu.logFile != nil {
+		err = m.mu.logFile.Close()
+		m.mu.logFile, m.mu.log = nil, nil
+	}
+	for _, name := range m.names {
+		if d := m.families[name]; d != nil {
+			err = firstError(err, d.Close())
+		}
+	}
+	m.families = nil
+	return err
+}
+
+func (m *Multi) logPath(logNum uint64) string {
+	return m.fs.PathJoin(m.dirname, fmt.Sprintf("MULTI-%06d.log", logNum))
+}
+
+// listLogs returns the numbers of the shared logs in the Multi's directory,
+// in increasing order.
+func (m *Multi) listLogs() ([]uint64, error) {
+	ls, err := m.fs.List(m.dirname)
+	if err != nil {
+		return nil, err
+	}
+	var logNums []uint64
+	for _, filename := range ls {
+		if !strings.HasPrefix(filename, "MULTI-") || !strings.HasSuffix(filename, ".log") {
+			continue
+		}
+		s := strings.TrimSuffix(strings.TrimPrefix(filename, "MULTI-"), ".log")
+		if logNum, err := strconv.ParseUint(s, 10, 64); err == nil {
+			logNums = append(logNums, logNum)
+		}
+	}
+	sort.Slice(logNums, func(i, j int) bool { return logNums[i] < logNums[j] })
+	return logNums, nil
+}
+
+// recoverLog applies the commits in the shared log that aren't applied to
+// every family to the remaining families.
+func (m *Multi) recoverLog(logNum uint64) error {
+	filename := m.logPath(logNum)
+	f, err := m.fs.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rr := record.NewReader(f, 0 /* logNum */)
+	for {
+		r, err := rr.Next()
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			// A commit whose write to the log was torn was never applied.
+			if err == io.EOF || record.IsInvalidRecord(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "pebble: error when replaying shared log %q", filename)
+		}
+		if err := m.recoverCommit(filename, data); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Multi) recoverCommit(filename string, data []byte) error {
+	corrupt := func() error {
+		return base.CorruptionErrorf("pebble: corrupt shared log %q", filename)
+	}
+	for len(data) > 0 {
+		n, l := binary.Uvarint(data)
+		if l <= 0 || uint64(len(data)-l) < n {
+			return corrupt()
+		}
+		name := string(data[l : l+int(n)])
+		data = data[l+int(n):]
+		seqNum, l := binary.Uvarint(data)
+		if l <= 0 {
+			return corrupt()
+		}
+		data = data[l:]
+		n, l = binary.Uvarint(data)
+		if l <= 0 || uint64(len(data)-l) < n {
+			return corrupt()
+		}
+		repr := append([]byte(nil), data[l:l+int(n)]...)
+		data = data[l+int(n):]
+
+		d := m.families[name]
+		if d == nil {
+			return errors.Errorf("pebble: shared log %q holds a commit to family %q, which wasn't opened",
+				filename, name)
+		}
+		switch next := atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum); {
+		case next > seqNum:
+			// The family applied its part of the commit.
+			continue
+		case next < seqNum:
+			return errors.Errorf("pebble: family %q is missing commits before sequence number %d",
+				name, errors.Safe(seqNum))
+		}
+		b := d.NewBatch()
+		if err := b.SetRepr(repr); err != nil {
+			return err
+		}
+		if b.Count() == 0 {
+			// Apply doesn't record batches holding only LogData, as whether
+			// they were applied can't be determined. Skip any such batch
+			// rather than apply it on every recovery.
+			continue
+		}
+		if err := d.Apply(b, Sync); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createLogLocked creates the shared log numbered m.mu.logNum.
+func (m *Multi) createLogLocked() error {
+	f, err := m.fs.Create(m.logPath(m.mu.logNum))
+	if err != nil {
+		return err
+	}
+	if err := m.syncDir(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	m.mu.logFile, m.mu.log = f, record.NewWriter(f)
+	return nil
+}
+
+// syncDir syncs the Multi's directory.
+func (m *Multi) syncDir() error {
+	dir, err := m.fs.OpenDir(m.dirname)
+	if err != nil {
+		return err
+	}
+	return firstError(dir.Sync(), dir.Close())
+}
+
+// rotateLogLocked replaces the shared log by a new, empty log. Every commit
+// in the replaced log has been applied to, and synced by, the families.
+func (m *Multi) rotateLogLocked() error {
+	oldFile, oldNum := m.mu.logFile, m.mu.logNum
+	m.mu.logNum++
+	if err := m.createLogLocked(); err != nil {
+		m.mu.logNum = oldNum
+		return err
+	}
+	if err := oldFile.Close(); err != nil {
+		return err
+	}
+	return m.fs.Remove(m.logPath(oldNum))
+}
+
+// MultiBatch is a set of mutations to the families of a Multi, applied
+// atomically by Multi.Apply.
+type MultiBatch struct {
+	m       *Multi
+	batches map[string]*Batch
+}
+
+// Family returns the batch of mutations to the named family. It panics if
+// the family wasn't opened by the Multi.
+func (mb *MultiBatch) Family(name string) *Batch {
+	b := mb.batches[name]
+	if b == nil {
+		d := mb.m.families[name]
+		if d == nil {
+			panic(fmt.Sprintf("pebble: unknown family %q", name))
+		}
+		b = d.NewBatch()
+		mb.batches[name] = b
+	}
+	return b
+}
+
+// Close releases the batches of the MultiBatch.
+func (mb *MultiBatch) Close() error {
+	var err error
+	for _, b := range mb.batches {
+		err = firstError(err, b.Close())
+	}
+	mb.batches = nil
+	return err
+}
+
+// MultiSnapshot is a consistent view of the families of a Multi, returned by
+// Multi.NewSnapshot.
+type MultiSnapshot struct {
+	snapshots map[string]*Snapshot
+}
+
+// Family returns the snapshot of the named family, or nil if the family
+// wasn't opened by the Multi.
+func (s *MultiSnapshot) Family(name string) *Snapshot {
+	return s.snapshots[name]
+}
+
+// Close releases the snapshots of the families.
+func (s *MultiSnapshot) Close() error {
+	var err error
+	for _, snap := range s.snapshots {
+		err = firstError(err, snap.Close())
+	}
+	s.snapshots = nil
+	return err
+}
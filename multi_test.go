@@ -0,0 +1,199 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/errorfs"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMulti(t *testing.T) {
+	const (
+		injectNone int32 = iota
+		// Fail the writes to the shared log.
+		injectSharedLogError
+		// Simulate a crash once family b's WAL is written to, by ignoring
+		// subsequent syncs.
+		injectFamilyCrash
+	)
+	mem := vfs.NewStrictMem()
+	var inject int32
+	fs := errorfs.Wrap(mem, errorfs.InjectorFunc(func(op errorfs.Op, path string) error {
+		switch atomic.LoadInt32(&inject) {
+		case injectSharedLogError:
+			if op == errorfs.OpFileWrite && strings.HasPrefix(path, "MULTI-") {
+				return errorfs.ErrInjected
+			}
+		case injectFamilyCrash:
+			if op == errorfs.OpFileWrite && strings.HasPrefix(path, "b/") &&
+				strings.HasSuffix(path, ".log") {
+				mem.SetIgnoreSyncs(true)
+			}
+		}
+		return nil
+	}))
+
+	families := []string{"a", "b"}
+	open := func() *Multi {
+		m, err := OpenMulti("", families, &Options{FS: fs})
+		require.NoError(t, err)
+		return m
+	}
+	get := func(m *Multi, family, key string) string {
+		v, closer, err := m.Family(family).Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return ""
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	apply := func(m *Multi, key string) error {
+		mb := m.NewBatch()
+		defer mb.Close()
+		require.NoError(t, mb.Family("a").Set([]byte(key), []byte("a-"+key), nil))
+		require.NoError(t, mb.Family("b").Set([]byte(key), []byte("b-"+key), nil))
+		return m.Apply(mb)
+	}
+
+	// A committed batch is reflected by both families after reopening.
+	m := open()
+	require.NoError(t, apply(m, "k1"))
+	require.Equal(t, "a-k1", get(m, "a", "k1"))
+	require.Equal(t, "b-k1", get(m, "b", "k1"))
+	require.NoError(t, m.Close())
+	m = open()
+	require.Equal(t, "a-k1", get(m, "a", "k1"))
+	require.Equal(t, "b-k1", get(m, "b", "k1"))
+
+	// A batch whose write to the shared log fails is applied to neither
+	// family, and the failure is sticky until the Multi is reopened.
+	atomic.StoreInt32(&inject, injectSharedLogError)
+	require.True(t, errors.Is(apply(m, "k2"), errorfs.ErrInjected))
+	atomic.StoreInt32(&inject, injectNone)
+	require.True(t, errors.Is(apply(m, "k3"), errorfs.ErrInjected))
+	require.Equal(t, "", get(m, "a", "k2"))
+	require.Equal(t, "", get(m, "b", "k2"))
+	require.NoError(t, m.Close())
+	m = open()
+	require.Equal(t, "", get(m, "a", "k2"))
+	require.Equal(t, "", get(m, "b", "k2"))
+	require.Equal(t, "a-k1", get(m, "a", "k1"))
+
+	// A crash after a batch is applied to family a, but before it's durably
+	// applied to family b, loses the write to b. The batch is applied to b when
+	// the Multi is reopened.
+	atomic.StoreInt32(&inject, injectFamilyCrash)
+	require.NoError(t, apply(m, "k4"))
+	require.NoError(t, m.Close())
+	atomic.StoreInt32(&inject, injectNone)
+	mem.ResetToSyncedState()
+	mem.SetIgnoreSyncs(false)
+	m = open()
+	require.Equal(t, "a-k4", get(m, "a", "k4"))
+	require.Equal(t, "b-k4", get(m, "b", "k4"))
+
+	// The recovered shared logs were replaced by a single new log.
+	ls, err := fs.List("")
+	require.NoError(t, err)
+	var logs []string
+	for _, name := range ls {
+		if strings.HasPrefix(name, "MULTI-") {
+			logs = append(logs, name)
+		}
+	}
+	require.Len(t, logs, 1)
+	require.NoError(t, apply(m, "k5"))
+	require.NoError(t, m.Close())
+
+	// The shared log holds commits to family b, which must be opened.
+	_, err = OpenMulti("", []string{"a"}, &Options{FS: fs})
+	require.Error(t, err)
+}
+
+func TestMultiLogData(t *testing.T) {
+	fs := vfs.NewMem()
+	m, err := OpenMulti("", []string{"a", "b"}, &Options{FS: fs})
+	require.NoError(t, err)
+
+	// A commit whose batch for family b holds only LogData writes it to b's
+	// WAL.
+	mb := m.NewBatch()
+	require.NoError(t, mb.Family("a").Set([]byte("k"), []byte("v"), nil))
+	require.NoError(t, mb.Family("b").LogData([]byte("data"), nil))
+	require.NoError(t, m.Apply(mb))
+	require.NoError(t, mb.Close())
+	require.NotZero(t, m.Family("b").Metrics().WAL.BytesIn)
+	require.NoError(t, m.Close())
+
+	// The LogData isn't applied again when the Multi is reopened, as it
+	// doesn't advance b's sequence number.
+	for i := 0; i < 2; i++ {
+		m, err = OpenMulti("", []string{"a", "b"}, &Options{FS: fs})
+		require.NoError(t, err)
+		require.Zero(t, m.Family("a").Metrics().WAL.BytesIn)
+		require.Zero(t, m.Family("b").Metrics().WAL.BytesIn)
+		v, closer, err := m.Family("a").Get([]byte("k"))
+		require.NoError(t, err)
+		require.Equal(t, "v", string(v))
+		require.NoError(t, closer.Close())
+		require.NoError(t, m.Close())
+	}
+}
+
+func TestMultiSnapshot(t *testing.T) {
+	m, err := OpenMulti("", []string{"a", "b"}, &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+
+	// Commit batches to both families while taking snapshots. Every snapshot
+	// observes the same commits in both families.
+	const commits = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < commits; i++ {
+			mb := m.NewBatch()
+			key := []byte(fmt.Sprintf("k%03d", i))
+			require.NoError(t, mb.Family("a").Set(key, nil, nil))
+			require.NoError(t, mb.Family("b").Set(key, nil, nil))
+			require.NoError(t, m.Apply(mb))
+			require.NoError(t, mb.Close())
+		}
+	}()
+	count := func(r Reader) int {
+		iter := r.NewIter(nil)
+		n := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Close())
+		return n
+	}
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		s, err := m.NewSnapshot()
+		require.NoError(t, err)
+		require.Equal(t, count(s.Family("a")), count(s.Family("b")))
+		require.NoError(t, s.Close())
+	}
+
+	s, err := m.NewSnapshot()
+	require.NoError(t, err)
+	require.Equal(t, commits, count(s.Family("a")))
+	require.Equal(t, commits, count(s.Family("b")))
+	require.NoError(t, s.Close())
+	require.NoError(t, m.Close())
+}
@@ -0,0 +1,312 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package objstorage routes the reads and writes of a DB's sstables to the
+// storage holding them: either the DB's local filesystem, or one of the remote
+// storages configured through Options.RemoteStorage.
+package objstorage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// Readable is the handle for an object that is open for reading.
+type Readable interface {
+	io.ReaderAt
+	io.Closer
+
+	// Size returns the size of the object.
+	Size() int64
+}
+
+// Writable is the handle for an object that is open for writing.
+//
+// The contents of a remote object only become visible once the Writable is
+// closed, and syncing a remote object is a no-op.
+type Writable interface {
+	io.Writer
+	io.Closer
+	Sync() error
+}
+
+// ErrRetryable marks the errors encountered reading a remote object that its
+// storage reports as transient through remote.Storage.IsRetryableError. Such
+// errors may be detected with errors.Is(err, ErrRetryable).
+var ErrRetryable = errors.New("pebble: retryable remote storage error")
+
+// markRetryable marks err with ErrRetryable if s reports it as transient.
+func markRetryable(s remote.Storage, err error) error {
+	if err != nil && s.IsRetryableError(err) {
+		return errors.Mark(err, ErrRetryable)
+	}
+	return err
+}
+
+// ObjectMetadata describes the storage of an object.
+type ObjectMetadata struct {
+	FileNum base.FileNum
+	// Locator identifies the remote storage holding the object. It's empty for
+	// objects stored on the local filesystem.
+	Locator remote.Locator
+}
+
+// IsRemote returns true if the object is stored remotely.
+func (m ObjectMetadata) IsRemote() bool {
+	return m.Locator != ""
+}
+
+// CreateOptions configures the creation of an object.
+type CreateOptions struct {
+	// Locator identifies the remote storage on which to create the object. If
+	// empty, the object is created on the local filesystem.
+	Locator remote.Locator
+}
+
+// Provider creates and opens the sstables of a DB, identified by file number.
+//
+// The provider tracks which objects are stored remotely; objects it doesn't
+// know of are assumed to be stored on the local filesystem. Remote objects
+// created through the provider are known to it, and the remote objects
+// recorded by the DB's MANIFEST are registered through AddObjects when the DB
+// is opened.
+type Provider interface {
+	// Create creates a new object and opens it for writing.
+	Create(fileNum base.FileNum, opts CreateOptions) (Writable, ObjectMetadata, error)
+
+	// Open opens an existing object for reading.
+	Open(fileNum base.FileNum) (Readable, error)
+
+	// Remove removes an object.
+	Remove(fileNum base.FileNum) error
+
+	// Size returns the size of an object, as reported by its storage.
+	Size(fileNum base.FileNum) (int64, error)
+
+	// Lookup returns the metadata of an object.
+	Lookup(fileNum base.FileNum) ObjectMetadata
+
+	// AddObjects registers existing remote objects with the provider.
+	AddObjects(objs []ObjectMetadata) error
+
+	// Path returns a description of the object's location: its path if it's
+	// stored locally, and otherwise its remote storage and name.
+	Path(meta ObjectMetadata) string
+}
+
+// Settings configures a Provider.
+type Settings struct {
+	// FS and FSDirName locate the objects stored on the local filesystem.
+	FS        vfs.FS
+	FSDirName string
+	// NoSyncOnClose and BytesPerSync configure the syncing of local objects
+	// while they're written. See the equivalent fields of pebble.Options.
+	NoSyncOnClose bool
+	BytesPerSync  int
+	// Remote holds the remote storages objects may be stored on.
+	Remote map[remote.Locator]remote.Storage
+}
+
+// New returns a Provider configured with the given settings.
+func New(settings Settings) Provider {
+	p := &provider{st: settings}
+	p.mu.remote = make(map[base.FileNum]remote.Locator)
+	return p
+}
+
+type provider struct {
+	st Settings
+	mu struct {
+		sync.Mutex
+		// remote maps the file numbers of the known remote objects to the
+		// locators of their storages.
+		remote map[base.FileNum]remote.Locator
+	}
+}
+
+var _ Provider = (*provider)(nil)
+
+// remoteObjectName returns the name of the remote object with the given file
+// number.
+func remoteObjectName(fileNum base.FileNum) string {
+	return fmt.Sprintf("%s.sst", fileNum)
+}
+
+func (p *provider) storage(locator remote.Locator) (remote.Storage, error) {
+	s, ok := p.st.Remote[locator]
+	if !ok {
+		return nil, errors.Errorf("pebble: no remote storage configured for locator %q", errors.Safe(locator))
+	}
+	return s, nil
+}
+
+// Create implements Provider.
+func (p *provider) Create(
+	fileNum base.FileNum, opts CreateOptions,
+) (Writable, ObjectMetadata, error) {
+	meta := ObjectMetadata{FileNum: fileNum, Locator: opts.Locator}
+	if !meta.IsRemote() {
+		f, err := p.st.FS.Create(p.Path(meta))
+		if err != nil {
+			return nil, ObjectMetadata{}, err
+		}
+		f = vfs.NewSyncingFile(f, vfs.SyncingFileOptions{
+			NoSyncOnClose: p.st.NoSyncOnClose,
+			BytesPerSync:  p.st.BytesPerSync,
+		})
+		return f, meta, nil
+	}
+
+	s, err := p.storage(meta.Locator)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	w, err := s.CreateObject(remoteObjectName(fileNum))
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	p.mu.Lock()
+	p.mu.remote[fileNum] = meta.Locator
+	p.mu.Unlock()
+	return remoteWritable{w}, meta, nil
+}
+
+// Open implements Provider.
+func (p *provider) Open(fileNum base.FileNum) (Readable, error) {
+	meta := p.Lookup(fileNum)
+	if !meta.IsRemote() {
+		f, err := p.st.FS.Open(p.Path(meta), vfs.RandomReadsOption)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return &LocalReadable{File: f, size: stat.Size()}, nil
+	}
+
+	s, err := p.storage(meta.Locator)
+	if err != nil {
+		return nil, err
+	}
+	r, size, err := s.ReadObject(remoteObjectName(fileNum))
+	if err != nil {
+		return nil, markRetryable(s, err)
+	}
+	return &remoteReadable{ObjectReader: r, storage: s, size: size}, nil
+}
+
+// Remove implements Provider.
+func (p *provider) Remove(fileNum base.FileNum) error {
+	meta := p.Lookup(fileNum)
+	if !meta.IsRemote() {
+		return p.st.FS.Remove(p.Path(meta))
+	}
+
+	s, err := p.storage(meta.Locator)
+	if err != nil {
+		return err
+	}
+	if err := s.Delete(remoteObjectName(fileNum)); err != nil && !s.IsNotExistError(err) {
+		return err
+	}
+	p.mu.Lock()
+	delete(p.mu.remote, fileNum)
+	p.mu.Unlock()
+	return nil
+}
+
+// Size implements Provider.
+func (p *provider) Size(fileNum base.FileNum) (int64, error) {
+	meta := p.Lookup(fileNum)
+	if !meta.IsRemote() {
+		stat, err := p.st.FS.Stat(p.Path(meta))
+		if err != nil {
+			return 0, err
+		}
+		return stat.Size(), nil
+	}
+
+	s, err := p.storage(meta.Locator)
+	if err != nil {
+		return 0, err
+	}
+	return s.Size(remoteObjectName(fileNum))
+}
+
+// Lookup implements Provider.
+func (p *provider) Lookup(fileNum base.FileNum) ObjectMetadata {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ObjectMetadata{FileNum: fileNum, Locator: p.mu.remote[fileNum]}
+}
+
+// AddObjects implements Provider.
+func (p *provider) AddObjects(objs []ObjectMetadata) error {
+	for _, meta := range objs {
+		if _, err := p.storage(meta.Locator); err != nil {
+			return errors.Wrapf(err, "sstable %s", meta.FileNum)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, meta := range objs {
+		p.mu.remote[meta.FileNum] = meta.Locator
+	}
+	return nil
+}
+
+// Path implements Provider.
+func (p *provider) Path(meta ObjectMetadata) string {
+	if !meta.IsRemote() {
+		return base.MakeFilepath(p.st.FS, p.st.FSDirName, base.FileTypeTable, meta.FileNum)
+	}
+	return fmt.Sprintf("remote://%s/%s", meta.Locator, remoteObjectName(meta.FileNum))
+}
+
+// LocalReadable is the Readable of an object stored on the local filesystem.
+// It exposes the underlying file, which readers may use directly to take
+// advantage of OS-level readahead.
+type LocalReadable struct {
+	vfs.File
+	size int64
+}
+
+var _ Readable = (*LocalReadable)(nil)
+
+// Size implements Readable.
+func (r *LocalReadable) Size() int64 {
+	return r.size
+}
+
+type remoteReadable struct {
+	remote.ObjectReader
+	storage remote.Storage
+	size    int64
+}
+
+func (r *remoteReadable) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ObjectReader.ReadAt(p, off)
+	return n, markRetryable(r.storage, err)
+}
+
+func (r *remoteReadable) Size() int64 {
+	return r.size
+}
+
+type remoteWritable struct {
+	io.WriteCloser
+}
+
+func (remoteWritable) Sync() error {
+	return nil
+}
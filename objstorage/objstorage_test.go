@@ -0,0 +1,86 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider(t *testing.T) {
+	mem := vfs.NewMem()
+	require.NoError(t, mem.MkdirAll("db", 0755))
+	storage := remote.NewInMem()
+	p := New(Settings{
+		FS:        mem,
+		FSDirName: "db",
+		Remote:    map[remote.Locator]remote.Storage{"bucket": storage},
+	})
+
+	create := func(fileNum base.FileNum, opts CreateOptions, data string) ObjectMetadata {
+		w, meta, err := p.Create(fileNum, opts)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Sync())
+		require.NoError(t, w.Close())
+		return meta
+	}
+	read := func(fileNum base.FileNum) string {
+		r, err := p.Open(fileNum)
+		require.NoError(t, err)
+		buf := make([]byte, r.Size())
+		_, err = r.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		return string(buf)
+	}
+
+	local := create(1, CreateOptions{}, "local")
+	require.False(t, local.IsRemote())
+	require.Equal(t, "db/000001.sst", p.Path(local))
+	remoteMeta := create(2, CreateOptions{Locator: "bucket"}, "remote object")
+	require.True(t, remoteMeta.IsRemote())
+	require.Equal(t, "remote://bucket/000002.sst", p.Path(remoteMeta))
+	require.Equal(t, remoteMeta, p.Lookup(2))
+
+	require.Equal(t, "local", read(1))
+	require.Equal(t, "remote object", read(2))
+	size, err := p.Size(2)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("remote object")), size)
+	names, err := storage.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"000002.sst"}, names)
+
+	// A new provider only knows of the remote objects registered with it.
+	p2 := New(Settings{
+		FS:        mem,
+		FSDirName: "db",
+		Remote:    map[remote.Locator]remote.Storage{"bucket": storage},
+	})
+	require.False(t, p2.Lookup(2).IsRemote())
+	require.Error(t, p2.AddObjects([]ObjectMetadata{{FileNum: 3, Locator: "missing"}}))
+	require.NoError(t, p2.AddObjects([]ObjectMetadata{remoteMeta}))
+	r, err := p2.Open(2)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("remote object")), r.Size())
+	require.NoError(t, r.Close())
+
+	// Removing an object removes it from its storage.
+	require.NoError(t, p.Remove(1))
+	_, err = mem.Stat("db/000001.sst")
+	require.True(t, oserror.IsNotExist(err))
+	require.NoError(t, p.Remove(2))
+	require.False(t, p.Lookup(2).IsRemote())
+	names, err = storage.List("")
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
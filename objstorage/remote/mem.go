@@ -0,0 +1,121 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package remote
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+)
+
+// NewInMem returns a Storage that holds its objects in memory, intended for
+// tests.
+func NewInMem() Storage {
+	s := &inMemStorage{}
+	s.mu.objects = make(map[string][]byte)
+	return s
+}
+
+type inMemStorage struct {
+	mu struct {
+		sync.Mutex
+		objects map[string][]byte
+	}
+}
+
+var _ Storage = (*inMemStorage)(nil)
+
+func (s *inMemStorage) CreateObject(objName string) (io.WriteCloser, error) {
+	return &inMemWriter{s: s, name: objName}, nil
+}
+
+func (s *inMemStorage) ReadObject(objName string) (ObjectReader, int64, error) {
+	data, err := s.get(objName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return inMemReader{bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+func (s *inMemStorage) Size(objName string) (int64, error) {
+	data, err := s.get(objName)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (s *inMemStorage) Delete(objName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mu.objects[objName]; !ok {
+		return errors.Wrapf(oserror.ErrNotExist, "remote object %q", objName)
+	}
+	delete(s.mu.objects, objName)
+	return nil
+}
+
+func (s *inMemStorage) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.mu.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *inMemStorage) IsNotExistError(err error) bool {
+	return oserror.IsNotExist(err)
+}
+
+func (s *inMemStorage) IsRetryableError(err error) bool {
+	return false
+}
+
+func (s *inMemStorage) get(objName string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.mu.objects[objName]
+	if !ok {
+		return nil, errors.Wrapf(oserror.ErrNotExist, "remote object %q", objName)
+	}
+	return data, nil
+}
+
+// inMemWriter buffers the contents of an object, which is added to the
+// storage when the writer is closed.
+type inMemWriter struct {
+	s    *inMemStorage
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *inMemWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *inMemWriter) Close() error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.s.mu.objects[w.name] = w.buf.Bytes()
+	return nil
+}
+
+type inMemReader struct {
+	*bytes.Reader
+}
+
+func (inMemReader) Close() error {
+	return nil
+}
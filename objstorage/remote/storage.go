@@ -0,0 +1,55 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package remote defines the interface to the remote storage (eg, an object
+// store) that may hold a DB's sstables.
+package remote
+
+import "io"
+
+// Locator is an opaque identifier of a remote storage, used to look it up in
+// the DB's configuration. A Locator is persisted in the MANIFEST alongside each
+// sstable stored remotely, and so must remain valid for the lifetime of the
+// DB.
+type Locator string
+
+// Storage is the interface to a remote storage holding objects, identified by
+// name. Objects are immutable once created.
+//
+// A Storage must be safe for concurrent use.
+type Storage interface {
+	// CreateObject creates an object with the given name, returning a writer
+	// for its contents. The object becomes visible once the writer is closed
+	// without error.
+	CreateObject(objName string) (io.WriteCloser, error)
+
+	// ReadObject opens an object for reading, returning a reader for its
+	// contents along with its size.
+	ReadObject(objName string) (_ ObjectReader, objSize int64, _ error)
+
+	// Size returns the size of an object.
+	Size(objName string) (int64, error)
+
+	// Delete removes an object.
+	Delete(objName string) error
+
+	// List returns the names of the objects whose names begin with the given
+	// prefix, in sorted order.
+	List(prefix string) ([]string, error)
+
+	// IsNotExistError returns true if err indicates that an object does not
+	// exist.
+	IsNotExistError(err error) bool
+
+	// IsRetryableError returns true if err indicates a transient failure, such
+	// as a timeout or throttling, after which the operation may succeed if
+	// retried.
+	IsRetryableError(err error) bool
+}
+
+// ObjectReader reads the contents of an object.
+type ObjectReader interface {
+	io.ReaderAt
+	io.Closer
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/manual"
 	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -55,6 +56,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	// Make a copy of the options so that we don't mutate the passed in options.
 	opts = opts.Clone()
 	opts = opts.EnsureDefaults()
+	if opts.ReadOnlyStrict {
+		opts.ReadOnly = true
+	}
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
@@ -65,8 +69,12 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		opts.Cache.Ref()
 	}
 
+	cacheID := opts.CacheID
+	if cacheID == 0 {
+		cacheID = opts.Cache.NewID()
+	}
 	d := &DB{
-		cacheID:             opts.Cache.NewID(),
+		cacheID:             cacheID,
 		dirname:             dirname,
 		walDirname:          opts.WALDir,
 		opts:                opts,
@@ -113,24 +121,35 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}()
 
+	d.objProvider = objstorage.New(objstorage.Settings{
+		FS:            opts.FS,
+		FSDirName:     dirname,
+		NoSyncOnClose: opts.NoSyncOnClose,
+		BytesPerSync:  opts.BytesPerSync,
+		Remote:        opts.RemoteStorage,
+	})
 	tableCacheSize := TableCacheSize(opts.MaxOpenFiles)
-	d.tableCache = newTableCacheContainer(opts.TableCache, d.cacheID, dirname, opts.FS, d.opts, tableCacheSize)
+	d.tableCache = newTableCacheContainer(opts.TableCache, d.cacheID, dirname, opts.FS, d.objProvider, d.opts, tableCacheSize)
 	d.newIters = d.tableCache.newIters
 	d.tableNewRangeKeyIter = d.tableCache.newRangeKeyIter
 
 	d.commit = newCommitPipeline(commitEnv{
 		logSeqNum:     &d.mu.versions.atomic.logSeqNum,
 		visibleSeqNum: &d.mu.versions.atomic.visibleSeqNum,
+		syncedSeqNum:  &d.mu.versions.atomic.syncedSeqNum,
 		apply:         d.commitApply,
 		write:         d.commitWrite,
+
+		stallThreshold: opts.Experimental.CommitPipelineStallThreshold,
+		stall:          opts.EventListener.CommitPipelineStall,
 	})
 	d.deletionLimiter = rate.NewLimiter(
 		rate.Limit(d.opts.Experimental.MinDeletionRate),
 		d.opts.Experimental.MinDeletionRate)
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
-	if d.mu.mem.nextSize > initialMemTableSize {
-		d.mu.mem.nextSize = initialMemTableSize
+	if d.mu.mem.nextSize > opts.MemTableSizeRamp {
+		d.mu.mem.nextSize = opts.MemTableSizeRamp
 	}
 	d.mu.mem.cond.L = &d.mu.Mutex
 	d.mu.cleaner.cond.L = &d.mu.Mutex
@@ -167,6 +186,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		if d.walDirname != d.dirname && d.walDir != nil {
 			d.walDir.Close()
 		}
+		if d.walFailover.dir != nil {
+			d.walFailover.dir.Close()
+		}
 		if d.mu.formatVers.marker != nil {
 			d.mu.formatVers.marker.Close()
 		}
@@ -196,15 +218,33 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			return nil, err
 		}
 	}
+	if opts.WALFailover != nil {
+		if opts.WALFailover.Secondary == d.walDirname {
+			return nil, errors.Errorf("pebble: WAL failover secondary directory %q must differ from the WAL directory",
+				opts.WALFailover.Secondary)
+		}
+		d.walFailover.dirname = opts.WALFailover.Secondary
+		if !d.opts.ReadOnly {
+			err := opts.FS.MkdirAll(d.walFailover.dirname, 0755)
+			if err != nil {
+				return nil, err
+			}
+		}
+		d.walFailover.dir, err = opts.FS.OpenDir(d.walFailover.dirname)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// Lock the database directory.
-	fileLock, err := opts.FS.Lock(base.MakeFilepath(opts.FS, dirname, fileTypeLock, 0))
-	if err != nil {
-		d.dataDir.Close()
-		if d.dataDir != d.walDir {
-			d.walDir.Close()
+	// Lock the database directory. Locking creates the LOCK file, so it's
+	// skipped in strict read-only mode.
+	var fileLock io.Closer
+	if !d.opts.ReadOnlyStrict {
+		// The deferred cleanup closes the directories if locking fails.
+		fileLock, err = opts.FS.Lock(base.MakeFilepath(opts.FS, dirname, fileTypeLock, 0))
+		if err != nil {
+			return nil, err
 		}
-		return nil, err
 	}
 	defer func() {
 		if fileLock != nil {
@@ -258,6 +298,22 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		if err := d.mu.versions.currentVersion().CheckConsistency(dirname, opts.FS); err != nil {
 			return nil, err
 		}
+		// Register the sstables stored remotely with the object provider.
+		var remoteObjs []objstorage.ObjectMetadata
+		for _, files := range d.mu.versions.currentVersion().Levels {
+			iter := files.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				if f.RemoteLocator != "" {
+					remoteObjs = append(remoteObjs, objstorage.ObjectMetadata{
+						FileNum: f.PhysicalFileNum(),
+						Locator: f.RemoteLocator,
+					})
+				}
+			}
+		}
+		if err := d.objProvider.AddObjects(remoteObjs); err != nil {
+			return nil, err
+		}
 	}
 
 	// If the Options specify a format major version higher than the
@@ -304,6 +360,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	type fileNumAndName struct {
 		num  FileNum
 		name string
+		dir  string
 	}
 	var logFiles []fileNumAndName
 	var previousOptionsFileNum FileNum
@@ -323,7 +380,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		switch ft {
 		case fileTypeLog:
 			if fn >= d.mu.versions.minUnflushedLogNum {
-				logFiles = append(logFiles, fileNumAndName{fn, filename})
+				logFiles = append(logFiles, fileNumAndName{fn, filename, d.walDirname})
 			}
 			if d.logRecycler.minRecycleLogNum <= fn {
 				d.logRecycler.minRecycleLogNum = fn + 1
@@ -347,6 +404,30 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}
 
+	// WALs may also have been written to the WAL failover secondary directory.
+	// Their file numbers were allocated from the same sequence as the WALs in
+	// the primary directory, so the WALs from both directories are replayed
+	// together in file number order.
+	var secondaryList []string
+	if d.walFailover.dir != nil {
+		secondaryList, err = opts.FS.List(d.walFailover.dirname)
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range secondaryList {
+			ft, fn, ok := base.ParseFilename(opts.FS, filename)
+			if !ok || ft != fileTypeLog {
+				continue
+			}
+			if d.mu.versions.nextFileNum <= fn {
+				d.mu.versions.nextFileNum = fn + 1
+			}
+			if fn >= d.mu.versions.minUnflushedLogNum {
+				logFiles = append(logFiles, fileNumAndName{fn, filename, d.walFailover.dirname})
+			}
+		}
+	}
+
 	// Validate the most-recent OPTIONS file, if there is one.
 	var strictWALTail bool
 	if previousOptionsFilename != "" {
@@ -364,8 +445,11 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	var ve versionEdit
 	for i, lf := range logFiles {
 		lastWAL := i == len(logFiles)-1
+		// A WAL abandoned by WAL failover was closed asynchronously, and may
+		// have an unclean tail even though later WALs follow it.
+		strict := strictWALTail && !lastWAL && !d.mu.versions.isFailedOverLog(lf.num)
 		maxSeqNum, err := d.replayWAL(jobID, &ve, opts.FS,
-			opts.FS.PathJoin(d.walDirname, lf.name), lf.num, strictWALTail && !lastWAL)
+			opts.FS.PathJoin(lf.dir, lf.name), lf.num, strict)
 		if err != nil {
 			return nil, err
 		}
@@ -414,13 +498,8 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		// memtables being flushed, only for the next unflushed memtable.
 		d.mu.mem.queue[len(d.mu.mem.queue)-1].logNum = newLogNum
 
-		logFile = vfs.NewSyncingFile(logFile, vfs.SyncingFileOptions{
-			NoSyncOnClose:   d.opts.NoSyncOnClose,
-			BytesPerSync:    d.opts.WALBytesPerSync,
-			PreallocateSize: d.walPreallocateSize(),
-		})
-		d.mu.log.LogWriter = record.NewLogWriter(logFile, newLogNum)
-		d.mu.log.LogWriter.SetMinSyncInterval(d.opts.WALMinSyncInterval)
+		logFile = d.newLogFile(logFile, newLogName, false /* secondary */)
+		d.mu.log.LogWriter = d.newLogWriter(logFile, newLogNum)
 		d.mu.versions.metrics.WAL.Files++
 	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
@@ -462,6 +541,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	if !d.opts.ReadOnly {
 		d.scanObsoleteFiles(ls)
+		d.scanObsoleteSecondaryLogs(secondaryList)
 		d.deleteObsoleteFiles(jobID, true /* waitForOngoing */)
 	} else {
 		// All the log files are obsolete.
@@ -657,6 +737,7 @@ func (d *DB) replayWAL(
 		b.SetRepr(buf.Bytes())
 		seqNum := b.SeqNum()
 		maxSeqNum = seqNum + uint64(b.Count())
+		d.replayLogData(&b)
 
 		if b.memTableSize >= uint64(d.largeBatchThreshold) {
 			flushMem()
@@ -713,6 +794,23 @@ func (d *DB) replayWAL(
 	return maxSeqNum, err
 }
 
+// replayLogData notifies the EventListener of the LogData records in a batch
+// replayed from a WAL.
+func (d *DB) replayLogData(b *Batch) {
+	seqNum := b.SeqNum()
+	for r := b.Reader(); ; {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			return
+		}
+		if kind == InternalKeyKindLogData {
+			d.opts.EventListener.LogDataReplayed(seqNum, ukey)
+		} else {
+			seqNum++
+		}
+	}
+}
+
 func checkOptions(opts *Options, path string) (strictWALTail bool, err error) {
 	f, err := opts.FS.Open(path)
 	if err != nil {
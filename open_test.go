@@ -20,6 +20,7 @@ import (
 	"syscall"
 	"testing"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
@@ -148,7 +149,7 @@ func TestNewDBFilenames(t *testing.T) {
 			"LOCK",
 			"MANIFEST-000001",
 			"OPTIONS-000003",
-			"marker.format-version.000008.009",
+			"marker.format-version.000010.011",
 			"marker.manifest.000001.MANIFEST-000001",
 		},
 	}
@@ -484,6 +485,65 @@ func TestOpenReadOnly(t *testing.T) {
 	}
 }
 
+func TestOpenReadOnlyStrict(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("flushed"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("unflushed"), nil))
+	require.NoError(t, d.Close())
+
+	// listContents returns the names and sizes of the DB's files.
+	listContents := func() []string {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		sort.Strings(ls)
+		var contents []string
+		for _, name := range ls {
+			fi, err := mem.Stat(name)
+			require.NoError(t, err)
+			contents = append(contents, fmt.Sprintf("%s:%d", name, fi.Size()))
+		}
+		return contents
+	}
+	contents := listContents()
+
+	// Open the DB on a filesystem that rejects every operation that would
+	// create or modify a file. Closing files is permitted.
+	var writes []string
+	fs := errorfs.Wrap(mem, errorfs.InjectorFunc(func(op errorfs.Op, path string) error {
+		if op.OpKind() == errorfs.OpKindWrite && op != errorfs.OpFileClose {
+			writes = append(writes, fmt.Sprintf("%d: %s", op, path))
+			return errors.New("read-only filesystem")
+		}
+		return nil
+	}))
+	_, err = Open("", &Options{FS: fs, ReadOnly: true})
+	require.Error(t, err)
+	require.NotEmpty(t, writes)
+
+	writes = nil
+	d, err = Open("", &Options{FS: fs, ReadOnlyStrict: true})
+	require.NoError(t, err)
+	require.EqualValues(t, ErrReadOnly, d.Flush())
+	require.EqualValues(t, ErrReadOnly, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.EqualValues(t, ErrReadOnly, d.Set([]byte("c"), nil, nil))
+
+	// Both the flushed key and the key replayed from the WAL are visible.
+	var kvs []string
+	iter := d.NewIter(nil)
+	for valid := iter.First(); valid; valid = iter.Next() {
+		kvs = append(kvs, fmt.Sprintf("%s:%s", iter.Key(), iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a:flushed", "b:unflushed"}, kvs)
+	require.NoError(t, d.Close())
+
+	require.Empty(t, writes)
+	require.Equal(t, contents, listContents())
+}
+
 func TestOpenWALReplay(t *testing.T) {
 	largeValue := []byte(strings.Repeat("a", 100<<10))
 	hugeValue := []byte(strings.Repeat("b", 10<<20))
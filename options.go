@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/humanize"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -60,6 +61,9 @@ type BlockPropertyCollector = sstable.BlockPropertyCollector
 // BlockPropertyFilter exports the sstable.BlockPropertyFilter type.
 type BlockPropertyFilter = base.BlockPropertyFilter
 
+// OutputSplitter exports the sstable.OutputSplitter type.
+type OutputSplitter = sstable.OutputSplitter
+
 // IterKeyType configures which types of keys an iterator should surface.
 type IterKeyType int8
 
@@ -89,6 +93,18 @@ func (t IterKeyType) String() string {
 	}
 }
 
+// RetryPolicy configures the recovery of an Iterator from transient errors,
+// such as those reported by a remote storage, through Iterator.Reset.
+type RetryPolicy struct {
+	// IsRetryable returns true if the iterator may recover from err. If nil,
+	// the errors the remote storage holding an sstable reports as transient
+	// through remote.Storage.IsRetryableError are retryable.
+	IsRetryable func(err error) bool
+	// MaxRetries, if positive, bounds the number of times Reset may recover
+	// the iterator. Once exhausted, every error is sticky.
+	MaxRetries int
+}
+
 // IterOptions hold the optional per-query parameters for NewIter.
 //
 // Like Options, a nil *IterOptions is valid and means to use the default
@@ -135,25 +151,24 @@ type IterOptions struct {
 	// - The visible state represents a consistent point in the history of the
 	//   DB.
 	// - The implementation is free to choose a conservative definition of what
-	//   is guaranteed durable. For simplicity, the current implementation
-	//   ignores memtables. A more sophisticated implementation could track the
-	//   highest seqnum that is synced to the WAL and published and use that as
-	//   the visible seqnum for an iterator. Note that the latter approach is
-	//   not strictly better than the former since we can have DBs that are (a)
-	//   synced more rarely than memtable flushes, (b) have no WAL. (a) is
-	//   likely to be true in a future CockroachDB context where the DB
-	//   containing the state machine may be rarely synced.
+	//   is guaranteed durable. The current implementation reads at the higher
+	//   of the sequence number of the oldest unflushed key and the highest
+	//   sequence number synced to the WAL. Keys committed without syncing the
+	//   WAL remain invisible until a later sync or a flush. Ingested sstables
+	//   with sequence numbers more recent than an unsynced memtable key are
+	//   conservatively invisible too. Sequence numbers synced to the WAL are
+	//   tracked by the commit pipeline as synced batches commit, which covers
+	//   every batch written to the WAL before them. A DB with no WAL only
+	//   exposes flushed state.
 	// NB: this current implementation relies on the fact that memtables are
-	// flushed in seqnum order, and any ingested sstables that happen to have a
-	// lower seqnum than a non-flushed memtable don't have any overlapping keys.
-	// This is the fundamental level invariant used in other code too, like when
-	// merging iterators.
+	// flushed in seqnum order, and that keys are written to the WAL in seqnum
+	// order.
 	//
 	// Semantically, using this option provides the caller a "snapshot" as of
-	// the time the most recent memtable was flushed. An alternate interface
-	// would be to add a NewSnapshot variant. Creating a snapshot is heavier
-	// weight than creating an iterator, so we have opted to support this
-	// iterator option.
+	// the time the most recent WAL sync or memtable flush. An alternate
+	// interface would be to add a NewSnapshot variant. Creating a snapshot is
+	// heavier weight than creating an iterator, so we have opted to support
+	// this iterator option.
 	OnlyReadGuaranteedDurable bool
 	// UseL6Filters allows the caller to opt into reading filter blocks for L6
 	// sstables. Helpful if a lot of SeekPrefixGEs are expected in quick
@@ -163,6 +178,26 @@ type IterOptions struct {
 	// existing is not low or if we just expect a one-time Seek (where loading the
 	// data block directly is better).
 	UseL6Filters bool
+	// NoCachePollution prevents the iterator from adding the sstable data
+	// blocks it reads to the block cache. Data blocks already present in the
+	// cache are still used, and index and filter blocks are cached as usual.
+	// This is useful for large scans that are unlikely to revisit the data
+	// they read, such as backups or consistency checks, which would otherwise
+	// evict the working set of other readers from the cache.
+	NoCachePollution bool
+	// SkipCorruptBlocks configures the iterator to skip over sstable blocks
+	// that fail checksum verification, rather than surfacing an error and
+	// aborting iteration. The keys within a skipped block are silently
+	// omitted, so this is only intended for recovery tooling salvaging the
+	// readable contents of a corrupt DB. Skipped blocks are logged, and
+	// counted in Iterator.Stats().InternalStats.SkippedCorruptBlocks.
+	SkipCorruptBlocks bool
+	// RetryPolicy, if set, permits the iterator to recover from transient
+	// errors through Iterator.Reset. Under a RetryPolicy, an error encountered
+	// by the iterator is sticky: positioning methods fail and Error returns it
+	// until Reset clears it, and an error that isn't retryable persists until
+	// the iterator is closed.
+	RetryPolicy *RetryPolicy
 	// Internal options.
 	logger Logger
 	// Level corresponding to this file. Only passed in if constructed by a
@@ -364,6 +399,18 @@ type LevelOptions struct {
 	// The default value is the value of BlockSize.
 	IndexBlockSize int
 
+	// MaxTableKeys is the maximum number of point keys in an sstable created
+	// by a compaction into the level, or by a flush into L0 when flushes are
+	// split (see Options.FlushSplitBytes). An output sstable is finished once
+	// it reaches either TargetFileSize or MaxTableKeys, bounding the key range
+	// of sstables holding many small keys. The keys for a single user key are
+	// never split across sstables, so an sstable may exceed MaxTableKeys. Like
+	// TargetFileSize, the limit for a compaction's output level is taken from
+	// the level's position relative to the base level.
+	//
+	// The default value is 0, which places no limit on the number of keys.
+	MaxTableKeys int64
+
 	// The target file size for the level.
 	TargetFileSize int64
 }
@@ -396,10 +443,58 @@ func (o *LevelOptions) EnsureDefaults() *LevelOptions {
 	return o
 }
 
+// WALFailoverOptions configures failover of the write-ahead log to a secondary
+// directory, providing resilience against a slow disk backing the primary WAL
+// directory.
+type WALFailoverOptions struct {
+	// Secondary is the directory to which new WALs are written once the
+	// primary WAL directory is deemed unhealthy. It should reside on a
+	// different disk than Options.WALDir.
+	Secondary string
+
+	// UnhealthyLatencyThreshold is the duration beyond which a single write or
+	// sync of a WAL in the primary WAL directory causes the directory to be
+	// deemed unhealthy, whether or not the operation has completed. Once
+	// deemed unhealthy, the next write rotates the memtable and WAL, writing
+	// the new WAL to the secondary directory. Subsequent WALs continue to be
+	// written to the secondary directory until the DB is reopened.
+	//
+	// The abandoned WAL is closed in the background, and is recorded in the
+	// MANIFEST so that recovery tolerates an unclean tail. Writes already
+	// committed to the abandoned WAL wait for it to be synced. Note that the
+	// WAL buffers a bounded amount of unsynced data (see
+	// record.CapAllocatedBlocks). If a stalled operation allows the buffer to
+	// fill before the WAL is rotated, writes block until the operation
+	// completes.
+	//
+	// The default value is 100ms.
+	UnhealthyLatencyThreshold time.Duration
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (o *WALFailoverOptions) EnsureDefaults() *WALFailoverOptions {
+	if o == nil {
+		o = &WALFailoverOptions{}
+	}
+	if o.UnhealthyLatencyThreshold <= 0 {
+		o.UnhealthyLatencyThreshold = 100 * time.Millisecond
+	}
+	return o
+}
+
 // Options holds the optional parameters for configuring pebble. These options
 // apply to the DB at large; per-query options are defined by the IterOptions
 // and WriteOptions types.
 type Options struct {
+	// BackgroundIOLimiter, if set, limits the rate at which flushes and
+	// compactions write sstables. The limiter may be shared by multiple DBs,
+	// and its rate may be adjusted while they're open.
+	//
+	// The default value is nil, which doesn't limit background writes.
+	BackgroundIOLimiter *IOLimiter
+
 	// Sync sstables periodically in order to smooth out writes to disk. This
 	// option does not provide any persistency guarantee, but is used to avoid
 	// latency spikes if the OS automatically decides to write out a large chunk
@@ -414,6 +509,16 @@ type Options struct {
 	// The default cache size is 8 MB.
 	Cache *cache.Cache
 
+	// CacheID is the ID under which the DB's blocks are stored in Cache. Cache
+	// metrics attribute usage to each ID through CacheMetrics.SizeByID, so a
+	// caller sharing a Cache between several DBs may assign each an ID to
+	// observe its share of the cache. A non-zero CacheID must be obtained
+	// from Cache.NewID, and must not be used by any other DB sharing the
+	// Cache.
+	//
+	// The default value is 0, in which case Open assigns a new ID.
+	CacheID uint64
+
 	// Cleaner cleans obsolete files.
 	//
 	// The default cleaner uses the DeleteCleaner.
@@ -479,6 +584,15 @@ type Options struct {
 		// is flushed. No automatic flush occurs if zero.
 		DeleteRangeFlushDelay time.Duration
 
+		// CommitPipelineStallThreshold is the duration beyond which a stage of
+		// a commit, such as the WAL write or the wait for the WAL sync, is
+		// reported through EventListener.CommitPipelineStall. Stalls are
+		// detected while the stage is in progress, at an interval of a quarter
+		// of the threshold (and no less than a millisecond). The latencies of
+		// the stages are sampled in Metrics.Commit regardless. No events are
+		// reported if zero.
+		CommitPipelineStallThreshold time.Duration
+
 		// MinDeletionRate is the minimum number of bytes per second that would
 		// be deleted. Deletion pacing is used to slow down deletions when
 		// compactions finish up or readers close, and newly-obsolete files need
@@ -549,11 +663,171 @@ type Options struct {
 		// By default, this value is false.
 		ValidateOnIngest bool
 
+		// ValidateComparer enables validation of the keys returned by the
+		// Comparer's Separator and Successor functions when sstables are
+		// written. A custom Comparer whose Separator returns a key outside of
+		// [a, b), or whose Successor returns a key before its argument, would
+		// otherwise silently produce sstables whose index blocks misdirect
+		// reads. When enabled, the flush or compaction writing the sstable
+		// fails with an error describing the violation instead.
+		//
+		// By default, this value is false.
+		ValidateComparer bool
+
+		// PinTopLevelIndexAndFilter pins the top-level index block and the
+		// filter block of sstables in L0 and L1 in the block cache, exempting
+		// them from eviction. The blocks are pinned when the table is first
+		// read at one of these levels, and remain pinned until the table is
+		// deleted. Pinned blocks count against the capacity of the block
+		// cache; other blocks are evicted to make room for them.
+		//
+		// By default, this value is false.
+		PinTopLevelIndexAndFilter bool
+
+		// TopLevelIndexCacheSize bounds the size in bytes of the top-level
+		// index block of a two-level sstable that the table cache decodes once
+		// when it opens the table and retains outside of the block cache, so
+		// that iterators over the table need not look up and parse the block
+		// each time they are created. The retained index is released when the
+		// table is evicted from the table cache. See
+		// sstable.ReaderOptions.TopLevelIndexCacheSize.
+		//
+		// The default value of 0 disables the retention of top-level indexes.
+		TopLevelIndexCacheSize int
+
+		// SingleDeleteValidation enables detection of misuse of SingleDelete
+		// during flushes and compactions. A SINGLEDEL deletes exactly one SET
+		// of its key; if it is applied to a key that was set more than once,
+		// the older SETs are resurrected once the SINGLEDEL and the newest SET
+		// are compacted together. When enabled, a flush or compaction that
+		// finds a SINGLEDEL consuming a SET with another SET, SETWITHDEL or
+		// MERGE for the same key beneath it within the same snapshot stripe
+		// calls SingleDeleteInvariantViolationCallback. If the callback is nil,
+		// the flush or compaction fails with an error instead.
+		//
+		// By default, this value is false.
+		SingleDeleteValidation bool
+
+		// SingleDeleteInvariantViolationCallback is called when
+		// SingleDeleteValidation is enabled and a misuse of SingleDelete is
+		// detected. The flush or compaction proceeds after the callback
+		// returns.
+		//
+		// NOTE: callers should take care to not mutate or retain the key.
+		SingleDeleteInvariantViolationCallback func(userKey []byte)
+
+		// MergeValidation enables validation of merge operands against the
+		// configured Merger when a batch is committed. Each operand is passed
+		// to Merger.Merge and the resulting ValueMerger is finished, so that a
+		// Merger that cannot decode an operand rejects the commit rather than
+		// failing reads of the key later on. The validation is performed for
+		// every merge operand in the batch, adding overhead to each commit.
+		//
+		// By default, this value is false.
+		MergeValidation bool
+
 		// MultiLevelCompaction allows the compaction of SSTs from more than two
 		// levels iff a conventional two level compaction will quickly trigger a
 		// compaction in the output level.
 		MultiLevelCompaction bool
 
+		// NewOutputSplitter, if set, is called by every flush and compaction to
+		// construct an OutputSplitter that may split the output sstables
+		// beyond the splits made to bound their sizes and overlap with
+		// grandparent sstables. For example, an OutputSplitter may keep the
+		// interval collected by a block property collector narrow within each
+		// output sstable, making block property filtering more effective at
+		// the table level.
+		NewOutputSplitter func() OutputSplitter
+
+		// CompactionHeuristicHook, if set, is called whenever the compaction
+		// picker looks for a score-based compaction, with a candidate
+		// compaction for each level whose score is at least 1, in order of
+		// decreasing score. The compaction of the first candidate it returns is
+		// picked, so the hook may reorder the candidates to prioritize
+		// compactions of particular key ranges, or omit candidates to veto
+		// them. If the hook vetoes every candidate while writes are stalled by
+		// L0's read amplification (see L0StopWritesThreshold), the veto is
+		// ignored and the highest scoring candidate is picked. The hook is
+		// called with DB.mu held, and must not call into the DB.
+		CompactionHeuristicHook func(candidates []CompactionCandidate) []CompactionCandidate
+
+		// TombstoneDensityCompactionThreshold, if positive, enables compactions
+		// of sstables in L0-L5 whose tombstone density, the fraction of their
+		// entries that are point or range deletions, is at least the
+		// threshold. Such an sstable is compacted into the next level to drop
+		// the keys its tombstones delete, even if no writes would otherwise
+		// trigger a compaction of its key range. These compactions are low
+		// priority, and are only picked when no size-based compactions are
+		// needed. The tombstone density of an sstable is computed along with
+		// its other table statistics, and is not available for sstables whose
+		// stats are not yet loaded.
+		//
+		// By default, this value is zero.
+		TombstoneDensityCompactionThreshold float64
+
+		// MVCCGCDecodeSuffix, if set, enables the garbage collection of
+		// obsolete MVCC versions by compactions. It decodes the suffix of a
+		// user key, as determined by Comparer.Split, into the version it
+		// identifies, returning false if the suffix doesn't identify a
+		// version. Versions older than the oldest version to retain for their
+		// prefix, given by MVCCGCKeepFromSuffix or MVCCGCThreshold, are
+		// dropped by compactions, whatever their kind.
+		//
+		// An obsolete version is only dropped when no snapshots are open, and
+		// by a compaction whose output level is the lowest level containing
+		// the user key, so that no older write of the same user key may
+		// resurface. MVCCGCDecodeSuffix requires a Comparer with a Split
+		// function.
+		MVCCGCDecodeSuffix func(suffix []byte) (version uint64, ok bool)
+
+		// MVCCGCThreshold is the oldest version retained for every prefix by
+		// the garbage collection of obsolete MVCC versions, unless
+		// MVCCGCKeepFromSuffix is set. See MVCCGCDecodeSuffix.
+		//
+		// By default, this value is zero, retaining every version.
+		MVCCGCThreshold uint64
+
+		// MVCCGCKeepFromSuffix, if set, is called with each prefix of the
+		// user keys encountered by the garbage collection of obsolete MVCC
+		// versions, and returns the oldest version to retain for the prefix.
+		// It takes precedence over MVCCGCThreshold, and is called once for
+		// each run of keys with the same prefix in a compaction. See
+		// MVCCGCDecodeSuffix.
+		MVCCGCKeepFromSuffix func(prefix []byte) (keepFromSuffix uint64)
+
+		// CreateOnRemote, if set, identifies the remote storage within
+		// Options.RemoteStorage on which the sstables written by flushes,
+		// compactions and ingestions are created. Ingested sstables are copied
+		// to the remote storage. If empty, sstables are created on the local
+		// filesystem.
+		CreateOnRemote remote.Locator
+
+		// ValueSeparationThreshold, if positive, is the minimum length of the
+		// values that flushes and compactions separate from their keys, writing
+		// them to a blob file alongside each output sstable rather than into
+		// the sstable itself. Separating large values keeps sstables small,
+		// and avoids rewriting the values when their keys are compacted. The
+		// values of separated keys are read from the blob files on demand.
+		// Values are only separated once the DB's format major version is at
+		// least FormatValueSeparation.
+		ValueSeparationThreshold int
+
+		// EnableMarkedForCompaction, if set, permits sstables to be marked for
+		// compaction through DB.Mark. Automatic compactions rewrite marked
+		// sstables at the lowest priority, when no other compaction is picked.
+		// Sstables marked by the DB itself, such as those with split user keys
+		// marked by a format major version migration, are rewritten regardless
+		// of this setting. Metrics.Compact.MarkedFiles counts the sstables that
+		// remain marked.
+		EnableMarkedForCompaction bool
+
+		// CompactionScheduler, if set, is consulted on the admission of every
+		// flush, manual compaction and automatic compaction, letting a policy
+		// such as fairness between manual and automatic compactions be
+		// imposed. The default scheduler admits every request.
+		CompactionScheduler CompactionScheduler
+
 		// MaxWriterConcurrency is used to indicate the maximum number of
 		// compression workers the compression queue is allowed to use. If
 		// MaxWriterConcurrency > 0, then the Writer will use parallelism, to
@@ -650,7 +924,7 @@ type Options struct {
 	MaxOpenFiles int
 
 	// The size of a MemTable in steady state. The actual MemTable size starts at
-	// min(256KB, MemTableSize) and doubles for each subsequent MemTable up to
+	// min(MemTableSizeRamp, MemTableSize) and doubles for each subsequent MemTable up to
 	// MemTableSize. This reduces the memory pressure caused by MemTables for
 	// short lived (test) DB instances. Note that more than one MemTable can be
 	// in existence since flushing a MemTable involves creating a new one and
@@ -659,6 +933,13 @@ type Options struct {
 	// the queued MemTables.
 	MemTableSize int
 
+	// MemTableSizeRamp is the size of the first MemTable allocated after the DB
+	// is opened. Each subsequent MemTable doubles in size until reaching
+	// MemTableSize. Setting MemTableSizeRamp >= MemTableSize disables the ramp.
+	//
+	// The default value is 256KB.
+	MemTableSizeRamp int
+
 	// Hard limit on the size of queued of MemTables. Writes are stopped when the
 	// sum of the queued memtable sizes exceeds
 	// MemTableStopWritesThreshold*MemTableSize. This value should be at least 2
@@ -671,6 +952,16 @@ type Options struct {
 	// The default merger concatenates values.
 	Merger *Merger
 
+	// RangeKeyMerger defines the associative merge operation to use for
+	// merging the values of overlapping RangeKeySets with the same suffix,
+	// during both iteration and compaction.
+	//
+	// If nil, the default, the newer of two overlapping RangeKeySets with the
+	// same suffix shadows the older one. The name of the range key merger is
+	// persisted in the OPTIONS file, and a DB may not be reopened with a
+	// different range key merger, or without one.
+	RangeKeyMerger *RangeKeyMerger
+
 	// MaxConcurrentCompactions specifies the maximum number of concurrent
 	// compactions. The default is 1. Concurrent compactions are performed
 	// - when L0 read-amplification passes the L0CompactionConcurrency threshold
@@ -681,7 +972,9 @@ type Options struct {
 
 	// DisableAutomaticCompactions dictates whether automatic compactions are
 	// scheduled or not. The default is false (enabled). This option is only used
-	// externally when running a manual compaction, and internally for tests.
+	// externally when running a manual compaction, and internally for tests. See
+	// also DB.DisableAutomaticCompactions, which toggles automatic compactions
+	// on an open DB.
 	DisableAutomaticCompactions bool
 
 	// NoSyncOnClose decides whether the Pebble instance will enforce a
@@ -701,6 +994,22 @@ type Options struct {
 	// disabled.
 	ReadOnly bool
 
+	// ReadOnlyStrict indicates that the DB should be opened in read-only mode
+	// without writing any file, for instance to inspect a DB on a read-only
+	// filesystem. It implies ReadOnly: the WAL is replayed into memtables that
+	// are never flushed, and flushes, compactions and writes to the DB return
+	// ErrReadOnly. Additionally, the DB directory is not locked, so the DB must
+	// not be concurrently opened by a process that may modify it.
+	ReadOnlyStrict bool
+
+	// RemoteStorage holds the remote storages on which the DB's sstables may
+	// be stored, keyed by the locators recorded in the MANIFEST for the
+	// sstables stored on them. Every remote storage holding sstables of the DB
+	// must be configured when the DB is opened. A remote storage must not be
+	// shared between DBs, which would otherwise delete each other's sstables.
+	// See Experimental.CreateOnRemote.
+	RemoteStorage map[remote.Locator]remote.Storage
+
 	// TableCache is an initialized TableCache which should be set as an
 	// option if the DB needs to be initialized with a pre-existing table cache.
 	// If TableCache is nil, then a table cache which is unique to the DB instance
@@ -735,6 +1044,16 @@ type Options struct {
 	// (i.e. the directory passed to pebble.Open).
 	WALDir string
 
+	// WALFailover configures failover of the WAL to a secondary directory when
+	// writes to WALs in the primary WAL directory (WALDir) are slow. If nil
+	// (the default), WAL failover is disabled.
+	//
+	// Recovery replays the WALs found in both the primary and the secondary
+	// directories in file number order. As with WALDir, the same WALFailover
+	// configuration must be supplied when reopening a DB that may have failed
+	// over, otherwise WALs in the secondary directory will not be replayed.
+	WALFailover *WALFailoverOptions
+
 	// WALMinSyncInterval is the minimum duration between syncs of the WAL. If
 	// WAL syncs are requested faster than this interval, they will be
 	// artificially delayed. Introducing a small artificial delay (500us) between
@@ -870,6 +1189,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.MemTableSize <= 0 {
 		o.MemTableSize = 4 << 20
 	}
+	if o.MemTableSizeRamp <= 0 {
+		o.MemTableSizeRamp = initialMemTableSize
+	}
 	if o.MemTableStopWritesThreshold <= 0 {
 		o.MemTableStopWritesThreshold = 2
 	}
@@ -900,6 +1222,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.FlushSplitBytes <= 0 {
 		o.FlushSplitBytes = 2 * o.Levels[0].TargetFileSize
 	}
+	if o.WALFailover != nil {
+		o.WALFailover = o.WALFailover.EnsureDefaults()
+	}
 	if o.Experimental.ReadCompactionRate == 0 {
 		o.Experimental.ReadCompactionRate = 16000
 	}
@@ -909,6 +1234,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Experimental.TableCacheShards <= 0 {
 		o.Experimental.TableCacheShards = runtime.GOMAXPROCS(0)
 	}
+	if o.Experimental.CompactionScheduler == nil {
+		o.Experimental.CompactionScheduler = defaultCompactionScheduler{}
+	}
 
 	o.initMaps()
 	return o
@@ -921,6 +1249,15 @@ func (o *Options) equal() Equal {
 	return o.Comparer.Equal
 }
 
+// rangeKeyMerge returns the configured RangeKeyMerger's merge function, or nil
+// if there is none.
+func (o *Options) rangeKeyMerge() RangeKeyMerge {
+	if o.RangeKeyMerger == nil {
+		return nil
+	}
+	return o.RangeKeyMerger.Merge
+}
+
 // initMaps initializes the Comparers, Filters, and Mergers maps.
 func (o *Options) initMaps() {
 	for i := range o.Levels {
@@ -981,6 +1318,7 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
+	fmt.Fprintf(&buf, "  commit_pipeline_stall_threshold=%s\n", o.Experimental.CommitPipelineStallThreshold)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
 	fmt.Fprintf(&buf, "  delete_range_flush_delay=%s\n", o.Experimental.DeleteRangeFlushDelay)
@@ -996,9 +1334,13 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
+	fmt.Fprintf(&buf, "  mem_table_size_ramp=%d\n", o.MemTableSizeRamp)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.Experimental.MinDeletionRate)
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
+	if o.RangeKeyMerger != nil {
+		fmt.Fprintf(&buf, "  range_key_merger=%s\n", o.RangeKeyMerger.Name)
+	}
 	fmt.Fprintf(&buf, "  read_compaction_rate=%d\n", o.Experimental.ReadCompactionRate)
 	fmt.Fprintf(&buf, "  read_sampling_multiplier=%d\n", o.Experimental.ReadSamplingMultiplier)
 	fmt.Fprintf(&buf, "  strict_wal_tail=%t\n", o.private.strictWALTail)
@@ -1029,6 +1371,7 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "  filter_policy=%s\n", filterPolicyName(l.FilterPolicy))
 		fmt.Fprintf(&buf, "  filter_type=%s\n", l.FilterType)
 		fmt.Fprintf(&buf, "  index_block_size=%d\n", l.IndexBlockSize)
+		fmt.Fprintf(&buf, "  max_table_keys=%d\n", l.MaxTableKeys)
 		fmt.Fprintf(&buf, "  target_file_size=%d\n", l.TargetFileSize)
 	}
 
@@ -1086,12 +1429,13 @@ func parseOptions(s string, fn func(section, key, value string) error) error {
 // ParseHooks contains callbacks to create options fields which can have
 // user-defined implementations.
 type ParseHooks struct {
-	NewCache        func(size int64) *Cache
-	NewCleaner      func(name string) (Cleaner, error)
-	NewComparer     func(name string) (*Comparer, error)
-	NewFilterPolicy func(name string) (FilterPolicy, error)
-	NewMerger       func(name string) (*Merger, error)
-	SkipUnknown     func(name, value string) bool
+	NewCache          func(size int64) *Cache
+	NewCleaner        func(name string) (Cleaner, error)
+	NewComparer       func(name string) (*Comparer, error)
+	NewFilterPolicy   func(name string) (FilterPolicy, error)
+	NewMerger         func(name string) (*Merger, error)
+	NewRangeKeyMerger func(name string) (*RangeKeyMerger, error)
+	SkipUnknown       func(name, value string) bool
 }
 
 // Parse parses the options from the specified string. Note that certain
@@ -1153,6 +1497,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Comparer, err = hooks.NewComparer(value)
 					}
 				}
+			case "commit_pipeline_stall_threshold":
+				o.Experimental.CommitPipelineStallThreshold, err = time.ParseDuration(value)
 			case "compaction_debt_concurrency":
 				o.Experimental.CompactionDebtConcurrency, err = strconv.Atoi(value)
 			case "delete_range_flush_delay":
@@ -1200,6 +1546,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.MaxOpenFiles, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
+			case "mem_table_size_ramp":
+				o.MemTableSizeRamp, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
 				o.MemTableStopWritesThreshold, err = strconv.Atoi(value)
 			case "min_compaction_rate":
@@ -1223,6 +1571,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Merger, err = hooks.NewMerger(value)
 					}
 				}
+			case "range_key_merger":
+				if hooks != nil && hooks.NewRangeKeyMerger != nil {
+					o.RangeKeyMerger, err = hooks.NewRangeKeyMerger(value)
+				}
 			case "read_compaction_rate":
 				o.Experimental.ReadCompactionRate, err = strconv.ParseInt(value, 10, 64)
 			case "read_sampling_multiplier":
@@ -1307,6 +1659,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "index_block_size":
 				l.IndexBlockSize, err = strconv.Atoi(value)
+			case "max_table_keys":
+				l.MaxTableKeys, err = strconv.ParseInt(value, 10, 64)
 			case "target_file_size":
 				l.TargetFileSize, err = strconv.ParseInt(value, 10, 64)
 			default:
@@ -1340,6 +1694,15 @@ func (o *Options) checkOptions(s string) (strictWALTail bool, err error) {
 				return errors.Errorf("pebble: merger name from file %q != merger name from options %q",
 					errors.Safe(value), errors.Safe(o.Merger.Name))
 			}
+		case "Options.range_key_merger":
+			var name string
+			if o.RangeKeyMerger != nil {
+				name = o.RangeKeyMerger.Name
+			}
+			if value != name {
+				return errors.Errorf("pebble: range key merger name from file %q != range key merger name from options %q",
+					errors.Safe(value), errors.Safe(name))
+			}
 		case "Options.strict_wal_tail":
 			strictWALTail, err = strconv.ParseBool(value)
 			if err != nil {
@@ -1386,9 +1749,20 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "FormatMajorVersion (%d) must be <= %d\n",
 			o.FormatMajorVersion, FormatNewest)
 	}
+	if o.WALFailover != nil {
+		if o.WALFailover.Secondary == "" {
+			fmt.Fprintf(&buf, "WALFailover.Secondary must be specified\n")
+		}
+	}
 	if o.TableCache != nil && o.Cache != o.TableCache.cache {
 		fmt.Fprintf(&buf, "underlying cache in the TableCache and the Cache dont match\n")
 	}
+	if o.Experimental.MVCCGCDecodeSuffix != nil && o.Comparer.Split == nil {
+		fmt.Fprintf(&buf, "Experimental.MVCCGCDecodeSuffix requires a Comparer with a Split function\n")
+	}
+	if l := o.Experimental.CreateOnRemote; l != "" && o.RemoteStorage[l] == nil {
+		fmt.Fprintf(&buf, "Experimental.CreateOnRemote (%q) must be configured in RemoteStorage\n", l)
+	}
 	if buf.Len() == 0 {
 		return nil
 	}
@@ -1406,6 +1780,7 @@ func (o *Options) MakeReaderOptions() sstable.ReaderOptions {
 		if o.Merger != nil {
 			readerOpts.MergerName = o.Merger.Name
 		}
+		readerOpts.TopLevelIndexCacheSize = o.Experimental.TopLevelIndexCacheSize
 	}
 	return readerOpts
 }
@@ -1421,8 +1796,10 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 		if o.Merger != nil {
 			writerOpts.MergerName = o.Merger.Name
 		}
+		writerOpts.RangeKeyMerger = o.RangeKeyMerger
 		writerOpts.TablePropertyCollectors = o.TablePropertyCollectors
 		writerOpts.BlockPropertyCollectors = o.BlockPropertyCollectors
+		writerOpts.ValidateComparer = o.Experimental.ValidateComparer
 	}
 	levelOpts := o.Level(level)
 	writerOpts.BlockRestartInterval = levelOpts.BlockRestartInterval
@@ -72,6 +72,7 @@ func TestOptionsString(t *testing.T) {
   bytes_per_sync=524288
   cache_size=8388608
   cleaner=delete
+  commit_pipeline_stall_threshold=0s
   compaction_debt_concurrency=1073741824
   comparer=leveldb.BytewiseComparator
   delete_range_flush_delay=0s
@@ -87,6 +88,7 @@ func TestOptionsString(t *testing.T) {
   max_manifest_file_size=134217728
   max_open_files=1000
   mem_table_size=4194304
+  mem_table_size_ramp=262144
   mem_table_stop_writes_threshold=2
   min_deletion_rate=0
   merger=pebble.concatenate
@@ -108,6 +110,7 @@ func TestOptionsString(t *testing.T) {
   filter_policy=none
   filter_type=table
   index_block_size=4096
+  max_table_keys=0
   target_file_size=2097152
 `
 
@@ -133,6 +136,17 @@ func TestOptionsCheck(t *testing.T) {
 	tmp.Merger = &Merger{Name: "foo"}
 	require.Regexp(t, `merger name from file.*!=.*`, tmp.Check(s))
 
+	// The range key merger is only recorded if configured, and must match.
+	tmp = *opts
+	tmp.RangeKeyMerger = &RangeKeyMerger{Name: "foo"}
+	require.NoError(t, tmp.Check(s))
+	require.NoError(t, tmp.Check(tmp.String()))
+	tmp2 := tmp
+	tmp2.RangeKeyMerger = &RangeKeyMerger{Name: "bar"}
+	require.Regexp(t, `range key merger name from file.*!=.*`, tmp2.Check(tmp.String()))
+	tmp2.RangeKeyMerger = nil
+	require.Regexp(t, `range key merger name from file.*!=.*`, tmp2.Check(tmp.String()))
+
 	// RocksDB uses a similar (INI-style) syntax for the OPTIONS file, but
 	// different section names and keys.
 	s = `
@@ -222,6 +236,7 @@ func TestOptionsParse(t *testing.T) {
 			opts.Levels[0].BlockSize = 1024
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
+			opts.Experimental.CommitPipelineStallThreshold = time.Second
 			opts.Experimental.CompactionDebtConcurrency = 100
 			opts.Experimental.DeleteRangeFlushDelay = 10 * time.Second
 			opts.Experimental.MinDeletionRate = 200
@@ -9,6 +9,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/sstable"
 )
 
 var nilPacer = &noopPacer{}
@@ -111,6 +112,62 @@ func (p *deletionPacer) maybeThrottle(bytesToDelete uint64) error {
 	return p.limit(bytesToDelete, p.getInfo())
 }
 
+// IOLimiter is a token bucket limiting the rate of bytes written by flushes
+// and compactions. See Options.BackgroundIOLimiter.
+type IOLimiter struct {
+	limiter *rate.Limiter
+}
+
+var _ sstable.WriteLimiter = (*IOLimiter)(nil)
+
+// NewIOLimiter returns an IOLimiter allowing bytesPerSec bytes to be written
+// per second, with bursts of up to bytesPerSec bytes. A non-positive
+// bytesPerSec doesn't limit writes; the burst is then 1MB if the rate is later
+// set.
+func NewIOLimiter(bytesPerSec int) *IOLimiter {
+	burst := bytesPerSec
+	if burst <= 0 {
+		burst = 1 << 20
+	}
+	l := &IOLimiter{limiter: rate.NewLimiter(rate.Inf, burst)}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+// SetRate sets the number of bytes that may be written per second. A
+// non-positive bytesPerSec removes the limit. It may be called concurrently
+// with writes, which observe the new rate from their next block.
+func (l *IOLimiter) SetRate(bytesPerSec int) {
+	if bytesPerSec <= 0 {
+		l.limiter.SetLimit(rate.Inf)
+		return
+	}
+	l.limiter.SetLimit(rate.Limit(bytesPerSec))
+}
+
+// Rate returns the number of bytes that may be written per second, or zero if
+// writes aren't limited.
+func (l *IOLimiter) Rate() int {
+	if limit := l.limiter.Limit(); limit != rate.Inf {
+		return int(limit)
+	}
+	return 0
+}
+
+// Wait blocks until n bytes may be written. It implements the
+// sstable.WriteLimiter interface.
+func (l *IOLimiter) Wait(n int) {
+	burst := l.limiter.Burst()
+	for n > 0 {
+		m := n
+		if m > burst {
+			m = burst
+		}
+		time.Sleep(l.limiter.DelayN(time.Now(), m))
+		n -= m
+	}
+}
+
 type noopPacer struct{}
 
 func (p *noopPacer) maybeThrottle(_ uint64) error {
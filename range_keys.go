@@ -5,6 +5,8 @@
 package pebble
 
 import (
+	"time"
+
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -15,7 +17,22 @@ import (
 // constructRangeKeyIter constructs the range-key iterator stack, populating
 // i.rangeKey.rangeKeyIter with the resulting iterator.
 func (i *Iterator) constructRangeKeyIter() {
-	i.rangeKey.rangeKeyIter = i.rangeKey.iterConfig.Init(i.cmp, i.seqNum)
+	i.rangeKey.timer = rangeKeyIterTimer{
+		iter:  i.rangeKey.iterConfig.Init(i.cmp, i.rangeKeyMerge, i.seqNum),
+		stats: &i.stats.RangeKeyStats,
+	}
+	i.rangeKey.rangeKeyIter = &i.rangeKey.timer
+	// Count the sstable range-key blocks read as the sstables' range-key
+	// iterators are opened.
+	newIterRangeKey := func(
+		file *manifest.FileMetadata, opts *keyspan.SpanIterOptions,
+	) (keyspan.FragmentIterator, error) {
+		iter, err := i.newIterRangeKey(file, opts)
+		if err == nil {
+			i.stats.RangeKeyStats.BlockCount++
+		}
+		return iter, err
+	}
 
 	// If there's an indexed batch with range keys, include it.
 	if i.batch != nil {
@@ -57,7 +74,7 @@ func (i *Iterator) constructRangeKeyIter() {
 	iter := current.RangeKeyLevels[0].Iter()
 	for f := iter.First(); f != nil; f = iter.Next() {
 		spanIterOpts := &keyspan.SpanIterOptions{RangeKeyFilters: i.opts.RangeKeyFilters}
-		spanIter, err := i.newIterRangeKey(f, spanIterOpts)
+		spanIter, err := newIterRangeKey(f, spanIterOpts)
 		if err != nil {
 			i.rangeKey.iterConfig.AddLevel(&errorKeyspanIter{err: err})
 			continue
@@ -72,12 +89,71 @@ func (i *Iterator) constructRangeKeyIter() {
 		}
 		li := i.rangeKey.iterConfig.NewLevelIter()
 		spanIterOpts := keyspan.SpanIterOptions{RangeKeyFilters: i.opts.RangeKeyFilters}
-		li.Init(spanIterOpts, i.cmp, i.newIterRangeKey, current.RangeKeyLevels[level].Iter(),
+		li.Init(spanIterOpts, i.cmp, newIterRangeKey, current.RangeKeyLevels[level].Iter(),
 			manifest.Level(level), i.opts.logger, manifest.KeyTypeRange)
 		i.rangeKey.iterConfig.AddLevel(li)
 	}
 }
 
+// rangeKeyIterTimer wraps an Iterator's range-key iterator stack,
+// accumulating the time spent within it.
+type rangeKeyIterTimer struct {
+	iter  keyspan.FragmentIterator
+	stats *RangeKeyIteratorStats
+}
+
+var _ keyspan.FragmentIterator = (*rangeKeyIterTimer)(nil)
+
+func (t *rangeKeyIterTimer) record(start time.Time) {
+	t.stats.MergeDuration += time.Since(start)
+}
+
+// SeekGE implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) SeekGE(key []byte) *keyspan.Span {
+	defer t.record(time.Now())
+	return t.iter.SeekGE(key)
+}
+
+// SeekLT implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) SeekLT(key []byte) *keyspan.Span {
+	defer t.record(time.Now())
+	return t.iter.SeekLT(key)
+}
+
+// First implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) First() *keyspan.Span {
+	defer t.record(time.Now())
+	return t.iter.First()
+}
+
+// Last implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) Last() *keyspan.Span {
+	defer t.record(time.Now())
+	return t.iter.Last()
+}
+
+// Next implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) Next() *keyspan.Span {
+	defer t.record(time.Now())
+	return t.iter.Next()
+}
+
+// Prev implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) Prev() *keyspan.Span {
+	defer t.record(time.Now())
+	return t.iter.Prev()
+}
+
+// Error implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) Error() error {
+	return t.iter.Error()
+}
+
+// Close implements keyspan.FragmentIterator.
+func (t *rangeKeyIterTimer) Close() error {
+	return t.iter.Close()
+}
+
 // Range key masking
 //
 // Pebble iterators may be configured such that range keys with suffixes mask
@@ -11,8 +11,11 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/internal/testkeys/blockprop"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -172,3 +175,186 @@ func TestRangeKeys(t *testing.T) {
 		require.NoError(t, d.Close())
 	}
 }
+
+// summingRangeKeyMerger interprets range key values as decimal integers and
+// sums them.
+var summingRangeKeyMerger = &RangeKeyMerger{
+	Merge: func(suffix, newer, older []byte) ([]byte, error) {
+		a, err := strconv.Atoi(string(newer))
+		if err != nil {
+			return nil, err
+		}
+		b, err := strconv.Atoi(string(older))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(a + b)), nil
+	},
+	Name: "summing",
+}
+
+func TestRangeKeyMerger(t *testing.T) {
+	for _, merger := range []*RangeKeyMerger{nil, summingRangeKeyMerger} {
+		t.Run(fmt.Sprintf("merger=%t", merger != nil), func(t *testing.T) {
+			d, err := Open("", &Options{
+				FS:                 vfs.NewMem(),
+				Comparer:           testkeys.Comparer,
+				FormatMajorVersion: FormatNewest,
+				RangeKeyMerger:     merger,
+			})
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			scan := func() string {
+				iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+				var buf bytes.Buffer
+				for valid := iter.First(); valid; valid = iter.Next() {
+					start, end := iter.RangeBounds()
+					fmt.Fprintf(&buf, "%s-%s:", start, end)
+					for _, rk := range iter.RangeKeys() {
+						fmt.Fprintf(&buf, " %s=%s", rk.Suffix, rk.Value)
+					}
+					fmt.Fprintln(&buf)
+				}
+				require.NoError(t, iter.Close())
+				return buf.String()
+			}
+
+			require.NoError(t, d.RangeKeySet([]byte("a"), []byte("e"), []byte("@1"), []byte("1"), nil))
+			require.NoError(t, d.RangeKeySet([]byte("c"), []byte("g"), []byte("@1"), []byte("2"), nil))
+			require.NoError(t, d.RangeKeySet([]byte("d"), []byte("f"), []byte("@2"), []byte("5"), nil))
+			rest := "d-f: @2=5 @1=2\nf-g: @1=2\n"
+			if merger != nil {
+				rest = "d-e: @2=5 @1=3\ne-f: @2=5 @1=2\nf-g: @1=2\n"
+			}
+			expected := "a-c: @1=1\nc-d: @1=2\n" + rest
+			if merger != nil {
+				expected = "a-c: @1=1\nc-d: @1=3\n" + rest
+			}
+			require.Equal(t, expected, scan())
+
+			// The merged values are preserved when the range keys are merged by
+			// a flush, and again by a compaction with a newer overlapping set.
+			require.NoError(t, d.Flush())
+			require.Equal(t, expected, scan())
+			require.NoError(t, d.RangeKeySet([]byte("a"), []byte("b"), []byte("@1"), []byte("10"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+			expected = "a-b: @1=10\nb-c: @1=1\nc-d: @1=2\n" + rest
+			if merger != nil {
+				expected = "a-b: @1=11\nb-c: @1=1\nc-d: @1=3\n" + rest
+			}
+			require.Equal(t, expected, scan())
+
+			// An unset shadows all older sets, whether or not a merger is
+			// configured.
+			require.NoError(t, d.RangeKeyUnset([]byte("a"), []byte("z"), []byte("@1"), nil))
+			require.NoError(t, d.RangeKeySet([]byte("a"), []byte("z"), []byte("@1"), []byte("7"), nil))
+			require.Equal(t, "a-d: @1=7\nd-f: @2=5 @1=7\nf-z: @1=7\n", scan())
+		})
+	}
+}
+
+func TestRangeKeyMergerIngestAndReopen(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+		RangeKeyMerger:     summingRangeKeyMerger,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// Overlapping RangeKeySets added to an sstable writer are coalesced using
+	// the range key merger.
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+	require.NoError(t, w.RangeKeySet([]byte("a"), []byte("c"), []byte("@1"), []byte("1")))
+	require.NoError(t, w.RangeKeySet([]byte("b"), []byte("d"), []byte("@1"), []byte("2")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+	var buf bytes.Buffer
+	for valid := iter.First(); valid; valid = iter.Next() {
+		start, end := iter.RangeBounds()
+		fmt.Fprintf(&buf, "%s-%s:", start, end)
+		for _, rk := range iter.RangeKeys() {
+			fmt.Fprintf(&buf, " %s=%s", rk.Suffix, rk.Value)
+		}
+		fmt.Fprintln(&buf)
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, "a-b: @1=1\nb-c: @1=3\nc-d: @1=2\n", buf.String())
+	require.NoError(t, d.Close())
+
+	// The DB may only be reopened with the same range key merger.
+	reopen := func(merger *RangeKeyMerger) error {
+		opts := opts.Clone()
+		opts.RangeKeyMerger = merger
+		d, err := Open("", opts)
+		if err == nil {
+			err = d.Close()
+		}
+		return err
+	}
+	require.Regexp(t, `range key merger name from file.*!=.*`, reopen(nil))
+	require.Regexp(t, `range key merger name from file.*!=.*`,
+		reopen(&RangeKeyMerger{Merge: summingRangeKeyMerger.Merge, Name: "other"}))
+	require.NoError(t, reopen(summingRangeKeyMerger))
+}
+
+func TestRangesOnlyIterReadsNoPointKeys(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		Comparer:                    testkeys.Comparer,
+		FormatMajorVersion:          FormatNewest,
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Flush ten sstables of point keys, every other one with a range key.
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 100; j++ {
+			k := []byte(fmt.Sprintf("%02d-%03d", i, j))
+			require.NoError(t, d.Set(k, k, nil))
+		}
+		if i%2 == 0 {
+			start := []byte(fmt.Sprintf("%02d-", i))
+			end := []byte(fmt.Sprintf("%02d-%03d", i, 50))
+			require.NoError(t, d.RangeKeySet(start, end, []byte("@5"), []byte("ttl"), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	// Fail the test if a point-key iterator is opened over an sstable.
+	newIters := d.newIters
+	d.newIters = func(
+		file *manifest.FileMetadata, opts *IterOptions, internalOpts internalIterOpts,
+	) (internalIterator, keyspan.FragmentIterator, error) {
+		t.Errorf("point-key iterator opened over %s", file.FileNum)
+		return newIters(file, opts, internalOpts)
+	}
+
+	iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+	var spans int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		hasPoint, hasRange := iter.HasPointAndRange()
+		require.False(t, hasPoint)
+		require.True(t, hasRange)
+		require.Equal(t, []RangeKeyData{{Suffix: []byte("@5"), Value: []byte("ttl")}}, iter.RangeKeys())
+		spans++
+	}
+	require.NoError(t, iter.Error())
+	require.Equal(t, 5, spans)
+
+	// Only the range-key blocks of the sstables containing range keys were
+	// read.
+	stats := iter.Stats()
+	require.Equal(t, InternalIteratorStats{}, stats.InternalStats)
+	require.Equal(t, uint64(5), stats.RangeKeyStats.BlockCount)
+	require.NoError(t, iter.Close())
+}
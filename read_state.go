@@ -66,6 +66,24 @@ func (s *readState) unrefLocked() {
 	// current readState.
 }
 
+// durableSeqNum returns the sequence number below which the keys visible at
+// seqNum through the readState are guaranteed to be durable. Keys in flushed
+// sstables are durable, as are keys in memtables that have been synced to the
+// WAL. Keys in ingested sstables with sequence numbers more recent than the
+// oldest unsynced memtable key are conservatively considered not durable.
+func (s *readState) durableSeqNum(seqNum uint64) uint64 {
+	durable := atomic.LoadUint64(&s.db.mu.versions.atomic.syncedSeqNum)
+	if len(s.memtables) == 0 {
+		durable = seqNum
+	} else if flushed := s.memtables[0].logSeqNum; flushed > durable {
+		durable = flushed
+	}
+	if durable > seqNum {
+		durable = seqNum
+	}
+	return durable
+}
+
 // loadReadState returns the current readState. The returned readState must be
 // unreferenced when the caller is finished with it.
 func (d *DB) loadReadState() *readState {
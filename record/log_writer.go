@@ -258,7 +258,10 @@ type LogWriter struct {
 	err error
 	// block is the current block being written. Protected by flusher.Mutex.
 	block *block
-	free  struct {
+	// size is the number of bytes written to w by the flush loop. It's only
+	// accessed by the flush loop.
+	size int64
+	free struct {
 		sync.Mutex
 		// Condition variable used to signal a block is freed.
 		cond      sync.Cond
@@ -281,9 +284,11 @@ type LogWriter struct {
 		err error
 		// minSyncInterval is the minimum duration between syncs.
 		minSyncInterval durationFunc
-		pending         []*block
-		syncQ           syncQueue
-		metrics         *LogWriterMetrics
+		// onSync, if set, is invoked after each sync. See SetOnSync.
+		onSync  func(size int64, syncLatency time.Duration, err error)
+		pending []*block
+		syncQ   syncQueue
+		metrics *LogWriterMetrics
 	}
 
 	// afterFunc is a hook to allow tests to mock out the timer functionality
@@ -340,6 +345,18 @@ func (w *LogWriter) SetMinSyncInterval(minSyncInterval durationFunc) {
 	f.Unlock()
 }
 
+// SetOnSync sets a function to invoke after each sync of the log, with the
+// number of bytes written to the log, the sync's latency and the error
+// encountered writing or syncing the log, if any. The function is invoked from
+// the LogWriter's flush goroutine before the sync's waiters are notified, and
+// must not block.
+func (w *LogWriter) SetOnSync(onSync func(size int64, syncLatency time.Duration, err error)) {
+	f := &w.flusher
+	f.Lock()
+	f.onSync = onSync
+	f.Unlock()
+}
+
 func (w *LogWriter) flushLoop(context.Context) {
 	f := &w.flusher
 	f.Lock()
@@ -449,8 +466,9 @@ func (w *LogWriter) flushLoop(context.Context) {
 			idleStartTime = time.Now()
 			continue
 		}
+		onSync := f.onSync
 		f.Unlock()
-		synced, syncLatency, bytesWritten, err := w.flushPending(data, pending, head, tail)
+		synced, syncLatency, bytesWritten, err := w.flushPending(data, pending, head, tail, onSync)
 		f.Lock()
 		if synced {
 			f.metrics.SyncLatencyMicros.RecordValue(syncLatency.Microseconds())
@@ -490,7 +508,10 @@ func (w *LogWriter) flushLoop(context.Context) {
 }
 
 func (w *LogWriter) flushPending(
-	data []byte, pending []*block, head, tail uint32,
+	data []byte,
+	pending []*block,
+	head, tail uint32,
+	onSync func(size int64, syncLatency time.Duration, err error),
 ) (synced bool, syncLatency time.Duration, bytesWritten int64, err error) {
 	defer func() {
 		// Translate panics into errors. The errors will cause flushLoop to shut
@@ -512,12 +533,16 @@ func (w *LogWriter) flushPending(
 		bytesWritten += int64(n)
 		_, err = w.w.Write(data)
 	}
+	w.size += bytesWritten
 
 	synced = head != tail
 	if synced {
 		if err == nil && w.s != nil {
 			syncLatency, err = w.syncWithLatency()
 		}
+		if onSync != nil {
+			onSync(w.size, syncLatency, err)
+		}
 		f := &w.flusher
 		if popErr := f.syncQ.pop(head, tail, err); popErr != nil {
 			return synced, syncLatency, bytesWritten, popErr
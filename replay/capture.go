@@ -0,0 +1,307 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package replay captures the workload of a DB, the batches committed to it
+// and the operations performed by its iterators, and replays it against a
+// fresh DB for benchmarking and regression testing.
+package replay
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// The kinds of operations in a capture file. The capture file is a sequence
+// of records, each holding one or more operations. Each operation begins with
+// its kind:
+//
+//	opBatch:   kind, batch repr
+//	opNewIter: kind, uvarint iterator ID, uvarint sequence number, iterator options
+//	opIterOp:  kind, uvarint iterator ID, op, key, limit
+//
+// except for an iterator's IterSetOptions operations, which are encoded as
+//
+//	opIterOp:  kind, uvarint iterator ID, op, iterator options
+//
+// Byte strings are encoded as their uvarint length plus one, followed by their
+// contents, with a length of zero denoting a nil byte string. Iterator options
+// are encoded as their key types, lower bound, upper bound, range key masking
+// suffix and a byte of iterOptionsFlags.
+const (
+	opBatch byte = iota + 1
+	opNewIter
+	opIterOp
+)
+
+// iterOptionsFlags encodes the boolean fields of a pebble.IterOptions.
+type iterOptionsFlags byte
+
+const (
+	onlyReadGuaranteedDurable iterOptionsFlags = 1 << iota
+	useL6Filters
+	noCachePollution
+	skipCorruptBlocks
+)
+
+// iterBufferSize is the size of the operations an iterator buffers before
+// queueing them to be written to the capture file.
+const iterBufferSize = 32 << 10
+
+// Capture begins capturing the workload of db to a new file at path on fs: the
+// batches committed to db, and the operations performed by iterators over db
+// created while the capture is in progress. Iterators over indexed batches
+// aren't captured. The function-valued fields of an iterator's options, its
+// filters and retry policy, aren't captured. Other operations, such as
+// ingestions and manual compactions, aren't captured.
+//
+// Batches are queued to be written in sequence number order as they're
+// written to the WAL, and the creation of iterators as they occur. The
+// capture file is written in the background. The creation of an iterator
+// records the sequence number of the iterator's view, or of its snapshot, as
+// the batches recorded before it may include batches it doesn't observe. An
+// iterator buffers its operations, which are queued in chunks and when the
+// iterator is closed, so they may be recorded after batches committed while
+// the iterator was open. An iterator reads a fixed view of the DB, so they're
+// replayed with the same results. The operations of iterators left open when
+// the capture is closed are dropped.
+//
+// The capture continues until the returned Closer is closed, which writes the
+// queued operations, syncs and closes the capture file and returns any error
+// encountered while writing it. Only one capture of a DB may be in progress at
+// a time.
+func Capture(db *pebble.DB, fs vfs.FS, path string) (io.Closer, error) {
+	f, err := fs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &capturer{
+		db:     db,
+		f:      f,
+		w:      record.NewWriter(f),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.writeLoop()
+	private.SetCapturer(db, c)
+	return c, nil
+}
+
+// capturer implements private.Capturer, recording a DB's workload to a
+// capture file.
+//
+// Encoded operations are pushed onto a lock-free stack of captureChunks, which
+// the background writer periodically takes and writes in the order they were
+// pushed, so that neither commits nor iterators wait on one another or on the
+// capture file.
+type capturer struct {
+	db *pebble.DB
+	f  vfs.File
+
+	// Accessed atomically.
+	atomic struct {
+		// The ID most recently assigned to a captured iterator.
+		iterID uint64
+		// Set to 1 once the capture is closed.
+		closed uint32
+		// The most recently pushed captureChunk, a *captureChunk.
+		head unsafe.Pointer
+	}
+
+	// notify wakes the writer after a chunk is pushed, and stop asks it to
+	// write the remaining chunks and exit. done is closed once it has exited.
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	// w and err are only accessed by the writer until done is closed.
+	w   *record.Writer
+	err error
+
+	closeOnce sync.Once
+}
+
+var _ private.Capturer = (*capturer)(nil)
+
+// captureChunk is a sequence of encoded operations written to the capture file
+// as a single record.
+type captureChunk struct {
+	next *captureChunk
+	buf  []byte
+}
+
+// CaptureBatch implements private.Capturer.
+func (c *capturer) CaptureBatch(repr []byte) {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(repr))
+	buf = append(buf, opBatch)
+	buf = appendBytes(buf, repr)
+	c.push(buf)
+}
+
+// CaptureNewIter implements private.Capturer.
+func (c *capturer) CaptureNewIter(opts interface{}, seqNum uint64) private.IterCapturer {
+	ic := &iterCapturer{c: c, id: atomic.AddUint64(&c.atomic.iterID, 1)}
+	// The creation of the iterator is queued immediately, following every
+	// batch the iterator observes.
+	buf := append([]byte(nil), opNewIter)
+	buf = appendUvarint(buf, ic.id)
+	buf = appendUvarint(buf, seqNum)
+	buf = appendIterOptions(buf, opts.(*pebble.IterOptions))
+	c.push(buf)
+	return ic
+}
+
+// push queues buf to be written to the capture file, taking ownership of it.
+func (c *capturer) push(buf []byte) {
+	if atomic.LoadUint32(&c.atomic.closed) == 1 {
+		return
+	}
+	chunk := &captureChunk{buf: buf}
+	for {
+		head := atomic.LoadPointer(&c.atomic.head)
+		chunk.next = (*captureChunk)(head)
+		if atomic.CompareAndSwapPointer(&c.atomic.head, head, unsafe.Pointer(chunk)) {
+			break
+		}
+	}
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes the queued chunks to the capture file until the capture is
+// closed.
+func (c *capturer) writeLoop() {
+	defer close(c.done)
+	for {
+		select {
+		case <-c.notify:
+			c.writeQueued()
+		case <-c.stop:
+			c.writeQueued()
+			return
+		}
+	}
+}
+
+// writeQueued takes the queued chunks and writes them in the order they were
+// pushed.
+func (c *capturer) writeQueued() {
+	var chunks *captureChunk
+	for chunk := (*captureChunk)(atomic.SwapPointer(&c.atomic.head, nil)); chunk != nil; {
+		next := chunk.next
+		chunk.next = chunks
+		chunks, chunk = chunk, next
+	}
+	for ; chunks != nil && c.err == nil; chunks = chunks.next {
+		if _, err := c.w.WriteRecord(chunks.buf); err != nil {
+			c.err = errors.Wrap(err, "pebble: writing workload capture")
+		}
+	}
+}
+
+// Close stops the capture, writes the queued operations, and syncs and closes
+// the capture file.
+func (c *capturer) Close() error {
+	c.closeOnce.Do(func() {
+		private.SetCapturer(c.db, nil)
+		atomic.StoreUint32(&c.atomic.closed, 1)
+		close(c.stop)
+		<-c.done
+		if err := c.w.Close(); err != nil && c.err == nil {
+			c.err = err
+		}
+		if err := c.f.Sync(); err != nil && c.err == nil {
+			c.err = err
+		}
+		if err := c.f.Close(); err != nil && c.err == nil {
+			c.err = err
+		}
+	})
+	return c.err
+}
+
+// iterCapturer implements private.IterCapturer, buffering the operations of
+// an iterator.
+type iterCapturer struct {
+	c   *capturer
+	id  uint64
+	buf []byte
+}
+
+var _ private.IterCapturer = (*iterCapturer)(nil)
+
+// CaptureOp implements private.IterCapturer.
+func (ic *iterCapturer) CaptureOp(op private.IterOp, key, limit []byte) {
+	ic.appendOp(op)
+	ic.buf = appendBytes(ic.buf, key)
+	ic.buf = appendBytes(ic.buf, limit)
+	ic.maybePush(op == private.IterClose)
+}
+
+// CaptureSetOptions implements private.IterCapturer.
+func (ic *iterCapturer) CaptureSetOptions(opts interface{}) {
+	ic.appendOp(private.IterSetOptions)
+	ic.buf = appendIterOptions(ic.buf, opts.(*pebble.IterOptions))
+	ic.maybePush(false)
+}
+
+func (ic *iterCapturer) appendOp(op private.IterOp) {
+	ic.buf = append(ic.buf, opIterOp)
+	ic.buf = appendUvarint(ic.buf, ic.id)
+	ic.buf = append(ic.buf, byte(op))
+}
+
+// maybePush queues the buffered operations if the buffer is full, or if force
+// is set.
+func (ic *iterCapturer) maybePush(force bool) {
+	if force || len(ic.buf) >= iterBufferSize {
+		ic.c.push(ic.buf)
+		ic.buf = nil
+	}
+}
+
+func appendIterOptions(buf []byte, o *pebble.IterOptions) []byte {
+	buf = append(buf, byte(o.KeyTypes))
+	buf = appendBytes(buf, o.LowerBound)
+	buf = appendBytes(buf, o.UpperBound)
+	buf = appendBytes(buf, o.RangeKeyMasking.Suffix)
+	var flags iterOptionsFlags
+	if o.OnlyReadGuaranteedDurable {
+		flags |= onlyReadGuaranteedDurable
+	}
+	if o.UseL6Filters {
+		flags |= useL6Filters
+	}
+	if o.NoCachePollution {
+		flags |= noCachePollution
+	}
+	if o.SkipCorruptBlocks {
+		flags |= skipCorruptBlocks
+	}
+	return append(buf, byte(flags))
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return appendUvarint(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(b))+1)
+	return append(buf, b...)
+}
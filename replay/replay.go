@@ -0,0 +1,396 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package replay
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// batchHeaderLen is the length of the header of a batch's representation: its
+// sequence number and count.
+const batchHeaderLen = 12
+
+// sampleInterval is the number of replayed operations between the samples of
+// a Report.
+const sampleInterval = 1000
+
+// Report describes a replay of a captured workload.
+type Report struct {
+	// Batches is the number of batches applied.
+	Batches int
+	// IterOps is the number of iterator operations performed, including the
+	// creation of iterators.
+	IterOps int
+	// Duration is the total time spent replaying the workload.
+	Duration time.Duration
+	// Samples holds the cumulative progress of the replay, sampled every
+	// sampleInterval operations and once the replay completes.
+	Samples []Sample
+}
+
+// Sample describes the progress of a replay at a point in time.
+type Sample struct {
+	// Ops is the number of operations replayed so far.
+	Ops int
+	// Elapsed is the time elapsed since the replay began.
+	Elapsed time.Duration
+	// Throughput is the number of operations replayed per second so far.
+	Throughput float64
+	// ReadAmp is the read amplification of the DB's LSM.
+	ReadAmp int
+}
+
+// Replay replays the workload captured to path on fs against a new DB
+// created in dirname with the provided options, waiting for each operation to
+// complete before performing the next. The replay is sequential, and so is
+// deterministic for a given capture and options, although the LSM shape may
+// differ from run to run due to the timing of background flushes and
+// compactions.
+//
+// Batches are applied without syncing the WAL, and the results of iterator
+// operations are discarded. Each iterator is created before the batches it
+// didn't observe when captured are applied, so it observes the same batches.
+// Iterator operations for iterators whose creation wasn't captured are
+// skipped.
+func Replay(fs vfs.FS, path, dirname string, opts *pebble.Options) (*Report, error) {
+	r := &replayer{iters: make(map[uint64]*pebble.Iterator)}
+	// The creation of an iterator may be recorded after batches it doesn't
+	// observe. Find those iterators before replaying the capture.
+	if err := readCapture(fs, path, r.findEarlyIter); err != nil {
+		return nil, err
+	}
+	sort.Slice(r.early, func(i, j int) bool {
+		return r.early[i].seqNum < r.early[j].seqNum
+	})
+
+	opts = opts.Clone()
+	opts.ErrorIfExists = true
+	db, err := pebble.Open(dirname, opts)
+	if err != nil {
+		return nil, err
+	}
+	r.db = db
+	r.start = time.Now()
+	err = readCapture(fs, path, r.replayOp)
+	for _, iter := range r.iters {
+		err = firstError(err, iter.Close())
+	}
+	if err == nil {
+		r.sample()
+	}
+	r.report.Duration = time.Since(r.start)
+	if err := firstError(err, db.Close()); err != nil {
+		return nil, err
+	}
+	return &r.report, nil
+}
+
+type replayer struct {
+	db    *pebble.DB
+	iters map[uint64]*pebble.Iterator
+	// early holds the iterators whose creation is recorded after a batch they
+	// don't observe, sorted by sequence number. Each is created before the
+	// first batch it doesn't observe is applied.
+	early []capturedOp
+	// lastBatchSeqNum is the sequence number of the last batch read by
+	// findEarlyIter, and batchSeen is set once one has been read.
+	lastBatchSeqNum uint64
+	batchSeen       bool
+	start           time.Time
+	report          Report
+}
+
+// capturedOp is an operation decoded from a capture file.
+type capturedOp struct {
+	kind byte
+	// repr and seqNum are set for opBatch, seqNum being the sequence number
+	// the batch was committed at.
+	repr []byte
+	// iterID is set for opNewIter and opIterOp, and seqNum for opNewIter,
+	// being the sequence number of the iterator's view. iterOpts is set for
+	// opNewIter and IterSetOptions.
+	iterID   uint64
+	seqNum   uint64
+	iterOp   private.IterOp
+	key      []byte
+	limit    []byte
+	iterOpts *pebble.IterOptions
+}
+
+// readCapture calls fn with each of the operations in the capture file at
+// path on fs, in order.
+func readCapture(fs vfs.FS, path string, fn func(op *capturedOp) error) error {
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rr := record.NewReader(f, 0 /* logNum */)
+	for {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "pebble: reading workload capture")
+		}
+		buf, err := ioutil.ReadAll(rec)
+		if err != nil {
+			return errors.Wrap(err, "pebble: reading workload capture")
+		}
+		if len(buf) == 0 {
+			return errors.New("pebble: empty record in workload capture")
+		}
+		d := decoder{buf: buf}
+		for len(d.buf) > 0 {
+			op, err := d.op()
+			if err != nil {
+				return err
+			}
+			if err := fn(&op); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// findEarlyIter adds the creation of an iterator to r.early if it's recorded
+// after a batch the iterator doesn't observe. Batches are recorded in sequence
+// number order, so it suffices to compare with the last batch.
+func (r *replayer) findEarlyIter(op *capturedOp) error {
+	switch op.kind {
+	case opBatch:
+		r.lastBatchSeqNum, r.batchSeen = op.seqNum, true
+	case opNewIter:
+		if r.batchSeen && op.seqNum <= r.lastBatchSeqNum {
+			r.early = append(r.early, *op)
+		}
+	}
+	return nil
+}
+
+// replayOp replays the operation.
+func (r *replayer) replayOp(op *capturedOp) error {
+	switch op.kind {
+	case opBatch:
+		// Create the iterators that don't observe the batch first.
+		for len(r.early) > 0 && r.early[0].seqNum <= op.seqNum {
+			r.newIter(&r.early[0])
+			r.early = r.early[1:]
+		}
+		b := r.db.NewBatch()
+		if err := b.SetRepr(op.repr); err != nil {
+			return err
+		}
+		if err := r.db.Apply(b, pebble.NoSync); err != nil {
+			return err
+		}
+		r.report.Batches++
+	case opNewIter:
+		if _, ok := r.iters[op.iterID]; ok {
+			// The iterator was created early.
+			return nil
+		}
+		r.newIter(op)
+	case opIterOp:
+		iter := r.iters[op.iterID]
+		if iter == nil {
+			return nil
+		}
+		if op.iterOp == private.IterSetOptions {
+			iter.SetOptions(op.iterOpts)
+		} else if err := r.iterOp(op.iterID, iter, op.iterOp, op.key, op.limit); err != nil {
+			return err
+		}
+		r.report.IterOps++
+	}
+	r.maybeSample()
+	return nil
+}
+
+func (r *replayer) newIter(op *capturedOp) {
+	r.iters[op.iterID] = r.db.NewIter(op.iterOpts)
+	r.report.IterOps++
+}
+
+func (r *replayer) maybeSample() {
+	if ops := r.report.Batches + r.report.IterOps; ops%sampleInterval == 0 {
+		r.sample()
+	}
+}
+
+func (r *replayer) iterOp(
+	id uint64, iter *pebble.Iterator, op private.IterOp, key, limit []byte,
+) error {
+	switch op {
+	case private.IterSeekGE:
+		if limit != nil {
+			iter.SeekGEWithLimit(key, limit)
+		} else {
+			iter.SeekGE(key)
+		}
+	case private.IterSeekPrefixGE:
+		iter.SeekPrefixGE(key)
+	case private.IterSeekLT:
+		if limit != nil {
+			iter.SeekLTWithLimit(key, limit)
+		} else {
+			iter.SeekLT(key)
+		}
+	case private.IterFirst:
+		iter.First()
+	case private.IterLast:
+		iter.Last()
+	case private.IterNext:
+		if limit != nil {
+			iter.NextWithLimit(limit)
+		} else {
+			iter.Next()
+		}
+	case private.IterNextPrefix:
+		iter.NextPrefix()
+	case private.IterPrev:
+		if limit != nil {
+			iter.PrevWithLimit(limit)
+		} else {
+			iter.Prev()
+		}
+	case private.IterPrevPrefix:
+		iter.PrevPrefix()
+	case private.IterSetBounds:
+		iter.SetBounds(key, limit)
+	case private.IterClose:
+		delete(r.iters, id)
+		return iter.Close()
+	default:
+		return errors.Errorf("pebble: unknown iterator op %d in workload capture", errors.Safe(op))
+	}
+	return nil
+}
+
+func (r *replayer) sample() {
+	elapsed := time.Since(r.start)
+	ops := r.report.Batches + r.report.IterOps
+	s := Sample{
+		Ops:     ops,
+		Elapsed: elapsed,
+		ReadAmp: r.db.Metrics().ReadAmp(),
+	}
+	if elapsed > 0 {
+		s.Throughput = float64(ops) / elapsed.Seconds()
+	}
+	r.report.Samples = append(r.report.Samples, s)
+}
+
+// decoder decodes the operations of a capture record, recording the first
+// error encountered.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if len(d.buf) == 0 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n == 0 {
+		return nil
+	}
+	n--
+	if uint64(len(d.buf)) < n {
+		d.fail()
+		return nil
+	}
+	// Copy the bytes, as iterators retain their bounds.
+	b := make([]byte, n)
+	copy(b, d.buf)
+	d.buf = d.buf[n:]
+	return b
+}
+
+// op decodes the next operation.
+func (d *decoder) op() (capturedOp, error) {
+	op := capturedOp{kind: d.byte()}
+	switch op.kind {
+	case opBatch:
+		op.repr = d.bytes()
+		if d.err == nil && len(op.repr) < batchHeaderLen {
+			d.fail()
+		}
+		if d.err == nil {
+			op.seqNum = binary.LittleEndian.Uint64(op.repr)
+		}
+	case opNewIter:
+		op.iterID = d.uvarint()
+		op.seqNum = d.uvarint()
+		op.iterOpts = d.iterOptions()
+	case opIterOp:
+		op.iterID = d.uvarint()
+		op.iterOp = private.IterOp(d.byte())
+		if op.iterOp == private.IterSetOptions {
+			op.iterOpts = d.iterOptions()
+		} else {
+			op.key, op.limit = d.bytes(), d.bytes()
+		}
+	default:
+		if d.err == nil {
+			return op, errors.Errorf("pebble: unknown operation kind %d in workload capture", errors.Safe(op.kind))
+		}
+	}
+	return op, d.err
+}
+
+func (d *decoder) iterOptions() *pebble.IterOptions {
+	o := &pebble.IterOptions{KeyTypes: pebble.IterKeyType(d.byte())}
+	o.LowerBound = d.bytes()
+	o.UpperBound = d.bytes()
+	o.RangeKeyMasking.Suffix = d.bytes()
+	flags := iterOptionsFlags(d.byte())
+	o.OnlyReadGuaranteedDurable = flags&onlyReadGuaranteedDurable != 0
+	o.UseL6Filters = flags&useL6Filters != 0
+	o.NoCachePollution = flags&noCachePollution != 0
+	o.SkipCorruptBlocks = flags&skipCorruptBlocks != 0
+	return o
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errors.New("pebble: corrupt record in workload capture")
+	}
+}
+
+func firstError(err0, err1 error) error {
+	if err0 != nil {
+		return err0
+	}
+	return err1
+}
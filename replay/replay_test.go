@@ -0,0 +1,165 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package replay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	mem := vfs.NewMem()
+	opts := &pebble.Options{FS: mem, MemTableSize: 64 << 10}
+	db, err := pebble.Open("captured", opts)
+	require.NoError(t, err)
+
+	capture, err := Capture(db, mem, "workload")
+	require.NoError(t, err)
+
+	key := func() []byte { return []byte(fmt.Sprintf("%04d", rng.Intn(1000))) }
+	var wantBatches, wantIterOps int
+	for i := 0; i < 3000; i++ {
+		switch rng.Intn(4) {
+		case 0:
+			require.NoError(t, db.Set(key(), key(), nil))
+			wantBatches++
+		case 1:
+			require.NoError(t, db.Delete(key(), nil))
+			wantBatches++
+		case 2:
+			b := db.NewBatch()
+			for j := 0; j < 5; j++ {
+				require.NoError(t, b.Set(key(), key(), nil))
+			}
+			require.NoError(t, b.DeleteRange(key(), key(), nil))
+			require.NoError(t, b.Commit(nil))
+			wantBatches++
+		case 3:
+			iter := db.NewIter(nil)
+			for valid, n := iter.SeekGE(key()), 0; valid && n < 10; valid, n = iter.Next(), n+1 {
+			}
+			iter.SetBounds(nil, key())
+			iter.Last()
+			iter.Prev()
+			iter.SetOptions(&pebble.IterOptions{LowerBound: key(), UseL6Filters: true})
+			iter.First()
+			require.NoError(t, iter.Close())
+			// The creation of the iterator, a seek and up to ten steps,
+			// SetBounds, Last, Prev, SetOptions, First and Close.
+			wantIterOps += 8
+		}
+	}
+	require.NoError(t, capture.Close())
+
+	// Writes after the capture is closed aren't captured.
+	require.NoError(t, db.Set([]byte("uncaptured"), nil, nil))
+
+	scan := func(db *pebble.DB) map[string]string {
+		kvs := make(map[string]string)
+		iter := db.NewIter(nil)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			kvs[string(iter.Key())] = string(iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return kvs
+	}
+	want := scan(db)
+	require.Contains(t, want, "uncaptured")
+	delete(want, "uncaptured")
+	require.NoError(t, db.Close())
+
+	report, err := Replay(mem, "workload", "replayed", opts)
+	require.NoError(t, err)
+	require.Equal(t, wantBatches, report.Batches)
+	require.GreaterOrEqual(t, report.IterOps, wantIterOps)
+	require.NotEmpty(t, report.Samples)
+	last := report.Samples[len(report.Samples)-1]
+	require.Equal(t, report.Batches+report.IterOps, last.Ops)
+
+	db, err = pebble.Open("replayed", opts)
+	require.NoError(t, err)
+	require.Equal(t, want, scan(db))
+	require.NoError(t, db.Close())
+
+	// Replaying into an existing DB fails.
+	_, err = Replay(mem, "workload", "replayed", opts)
+	require.Error(t, err)
+}
+
+func TestIterOptionsEncoding(t *testing.T) {
+	for _, o := range []*pebble.IterOptions{
+		{},
+		{
+			KeyTypes:                  pebble.IterKeyTypePointsAndRanges,
+			LowerBound:                []byte("a"),
+			UpperBound:                []byte{},
+			RangeKeyMasking:           pebble.RangeKeyMasking{Suffix: []byte("@5")},
+			OnlyReadGuaranteedDurable: true,
+			UseL6Filters:              true,
+			NoCachePollution:          true,
+			SkipCorruptBlocks:         true,
+		},
+	} {
+		d := decoder{buf: appendIterOptions(nil, o)}
+		require.Equal(t, o, d.iterOptions())
+		require.NoError(t, d.err)
+		require.Empty(t, d.buf)
+	}
+}
+
+func TestReplayEarlyIter(t *testing.T) {
+	db, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	batch := func(seqNum uint64, value string) capturedOp {
+		b := db.NewBatch()
+		require.NoError(t, b.Set([]byte("a"), []byte(value), nil))
+		repr := append([]byte(nil), b.Repr()...)
+		binary.LittleEndian.PutUint64(repr, seqNum)
+		return capturedOp{kind: opBatch, repr: repr, seqNum: seqNum}
+	}
+	newIter := func(id, seqNum uint64) capturedOp {
+		return capturedOp{kind: opNewIter, iterID: id, seqNum: seqNum, iterOpts: &pebble.IterOptions{}}
+	}
+	// The creation of iterator 1, which observes the first batch, is recorded
+	// after the second batch, as it may be when the second batch is written
+	// to the WAL but not yet published as the iterator is created. Iterator 2
+	// observes both batches.
+	ops := []capturedOp{
+		batch(10, "1"),
+		batch(11, "2"),
+		newIter(1, 11),
+		newIter(2, 12),
+	}
+
+	r := &replayer{db: db, iters: make(map[uint64]*pebble.Iterator)}
+	for i := range ops {
+		require.NoError(t, r.findEarlyIter(&ops[i]))
+	}
+	require.Len(t, r.early, 1)
+	for i := range ops {
+		require.NoError(t, r.replayOp(&ops[i]))
+	}
+	require.Equal(t, 2, r.report.Batches)
+	require.Equal(t, 2, r.report.IterOps)
+	for id, want := range map[uint64]string{1: "1", 2: "2"} {
+		iter := r.iters[id]
+		require.True(t, iter.First())
+		require.Equal(t, want, string(iter.Value()))
+		require.NoError(t, iter.Close())
+	}
+}
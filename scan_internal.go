@@ -0,0 +1,332 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// InternalRangeKey exports the keyspan.Key type. It describes a single
+// internal range key within a span, with its sequence number, kind, and for
+// RANGEKEYSET and RANGEKEYUNSET keys, its suffix.
+type InternalRangeKey = keyspan.Key
+
+// ScanInternalOptions configures a call to DB.ScanInternal.
+type ScanInternalOptions struct {
+	// SeqNum, if non-zero, is the sequence number at which to scan, such as
+	// that of a snapshot. Only keys with sequence numbers less than SeqNum are
+	// surfaced. If zero, the scan reads at the DB's current visible sequence
+	// number.
+	SeqNum uint64
+}
+
+// scanInternalCheckInterval is the number of point keys visited between
+// checks for the cancellation of the context passed to ScanInternal.
+const scanInternalCheckInterval = 1000
+
+// ScanInternal scans all internal keys within the span [lower, upper),
+// surfacing the raw internal point keys, range deletions and range keys of
+// the memtables and every level of the LSM. Unlike an Iterator, ScanInternal
+// does not merge keys or apply deletions: shadowed keys, point tombstones and
+// range tombstones are all surfaced, along with their sequence numbers and
+// kinds. A nil lower or upper bound leaves the scan unbounded in that
+// direction.
+//
+// Point keys are surfaced through visitPointKey in internal key order. The
+// range deletions and range keys of all levels are fragmented and surfaced in
+// order of their start keys through visitRangeDel and visitRangeKey
+// respectively, truncated to the bounds of the scan. A fragment covered by
+// several tombstones or range keys is surfaced with each of them. Any of the
+// visit functions may be nil, in which case keys of that type are not
+// scanned. The byte slices passed to the visit functions are only valid for
+// the duration of the call.
+//
+// Keys with sequence numbers at or above the scan's sequence number (see
+// ScanInternalOptions.SeqNum) are skipped. If a visit function returns an
+// error or ctx is canceled, the scan stops and returns the error.
+func (d *DB) ScanInternal(
+	ctx context.Context,
+	lower, upper []byte,
+	visitPointKey func(key *InternalKey, value []byte) error,
+	visitRangeDel func(start, end []byte, seqNum uint64) error,
+	visitRangeKey func(start, end []byte, keys []InternalRangeKey) error,
+	opts *ScanInternalOptions,
+) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	// Grab and reference the current readState, preventing the files within
+	// the current version from being deleted for the duration of the scan.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	seqNum := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	if opts != nil && opts.SeqNum != 0 {
+		seqNum = opts.SeqNum
+	}
+	s := &scanInternalState{
+		d:         d,
+		ctx:       ctx,
+		readState: readState,
+		seqNum:    seqNum,
+		lower:     lower,
+		upper:     upper,
+		iterOpts:  IterOptions{LowerBound: lower, UpperBound: upper, logger: d.opts.Logger},
+	}
+	// We only need to read from memtables which contain sequence numbers older
+	// than seqNum.
+	s.memtables = readState.memtables
+	for i := len(s.memtables) - 1; i >= 0; i-- {
+		if s.memtables[i].logSeqNum < seqNum {
+			break
+		}
+		s.memtables = s.memtables[:i]
+	}
+
+	if visitPointKey != nil {
+		if err := s.scanPointKeys(visitPointKey); err != nil {
+			return err
+		}
+	}
+	if visitRangeDel != nil {
+		err := s.scanSpans(s.rangeDelIter(), func(span *keyspan.Span) error {
+			for _, k := range span.Keys {
+				if err := visitRangeDel(span.Start, span.End, k.SeqNum()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if visitRangeKey != nil {
+		err := s.scanSpans(s.rangeKeyIter(), func(span *keyspan.Span) error {
+			return visitRangeKey(span.Start, span.End, span.Keys)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanInternalState holds the state of a single call to DB.ScanInternal.
+type scanInternalState struct {
+	d            *DB
+	ctx          context.Context
+	readState    *readState
+	memtables    flushableList
+	seqNum       uint64
+	lower, upper []byte
+	iterOpts     IterOptions
+}
+
+// visible returns true if a key with the provided sequence number is visible
+// to the scan.
+func (s *scanInternalState) visible(seqNum uint64) bool {
+	return base.Visible(seqNum, s.seqNum)
+}
+
+// scanPointKeys merges the point keys of the memtables and all levels of the
+// LSM without applying range deletions or collapsing keys, invoking visit for
+// each visible internal key within the bounds.
+func (s *scanInternalState) scanPointKeys(visit func(key *InternalKey, value []byte) error) error {
+	d := s.d
+	var iters []internalIterator
+	for j := len(s.memtables) - 1; j >= 0; j-- {
+		iters = append(iters, s.memtables[j].newIter(&s.iterOpts))
+	}
+	current := s.readState.current
+	for i := len(current.L0SublevelFiles) - 1; i >= 0; i-- {
+		iters = append(iters, newLevelIter(s.iterOpts, d.cmp, d.split, d.newIters,
+			current.L0SublevelFiles[i].Iter(), manifest.L0Sublevel(i), nil))
+	}
+	for level := 1; level < len(current.Levels); level++ {
+		if current.Levels[level].Empty() {
+			continue
+		}
+		iters = append(iters, newLevelIter(s.iterOpts, d.cmp, d.split, d.newIters,
+			current.Levels[level].Iter(), manifest.Level(level), nil))
+	}
+	iter := newMergingIter(d.opts.Logger, d.cmp, d.split, iters...)
+	iter.SetBounds(s.lower, s.upper)
+
+	var err error
+	var key *InternalKey
+	var value []byte
+	if s.lower != nil {
+		key, value = iter.SeekGE(s.lower, base.SeekGEFlagsNone)
+	} else {
+		key, value = iter.First()
+	}
+	for n := 1; key != nil; n++ {
+		if s.upper != nil && d.cmp(key.UserKey, s.upper) >= 0 {
+			break
+		}
+		if n%scanInternalCheckInterval == 0 {
+			if err = s.ctx.Err(); err != nil {
+				break
+			}
+		}
+		if s.visible(key.SeqNum()) {
+			if err = visit(key, value); err != nil {
+				break
+			}
+		}
+		key, value = iter.Next()
+	}
+	return firstError(err, iter.Close())
+}
+
+// rangeDelIter returns an iterator over the fragmented range deletions of the
+// memtables and all levels of the LSM, surfacing only visible tombstones.
+func (s *scanInternalState) rangeDelIter() keyspan.FragmentIterator {
+	d := s.d
+	var iters []keyspan.FragmentIterator
+	for j := len(s.memtables) - 1; j >= 0; j-- {
+		if rangeDelIter := s.memtables[j].newRangeDelIter(&s.iterOpts); rangeDelIter != nil {
+			iters = append(iters, rangeDelIter)
+		}
+	}
+	newRangeDelIter := func(
+		f *manifest.FileMetadata, _ *keyspan.SpanIterOptions,
+	) (keyspan.FragmentIterator, error) {
+		iter, rangeDelIter, err := d.newIters(f, &s.iterOpts, internalIterOpts{})
+		if err != nil {
+			return nil, err
+		}
+		if err := iter.Close(); err != nil {
+			if rangeDelIter != nil {
+				rangeDelIter.Close()
+			}
+			return nil, err
+		}
+		if rangeDelIter == nil {
+			return emptyKeyspanIter, nil
+		}
+		// Range tombstones are only valid within the bounds of the file.
+		return keyspan.Truncate(d.cmp, rangeDelIter, nil, nil, &f.Smallest, &f.Largest), nil
+	}
+	addLevel := func(files manifest.LevelIterator, level manifest.Level) {
+		li := &keyspan.LevelIter{}
+		li.Init(keyspan.SpanIterOptions{}, d.cmp, newRangeDelIter, files, level,
+			d.opts.Logger, manifest.KeyTypePoint)
+		iters = append(iters, li)
+	}
+	current := s.readState.current
+	for i := len(current.L0SublevelFiles) - 1; i >= 0; i-- {
+		addLevel(current.L0SublevelFiles[i].Iter(), manifest.L0Sublevel(i))
+	}
+	for level := 1; level < len(current.Levels); level++ {
+		if current.Levels[level].Empty() {
+			continue
+		}
+		addLevel(current.Levels[level].Iter(), manifest.Level(level))
+	}
+	return s.mergeSpans(iters)
+}
+
+// rangeKeyIter returns an iterator over the fragmented range keys of the
+// memtables and all levels of the LSM, surfacing only visible range keys.
+// Unlike the range keys surfaced by an Iterator, the range keys are neither
+// coalesced nor defragmented.
+func (s *scanInternalState) rangeKeyIter() keyspan.FragmentIterator {
+	d := s.d
+	var iters []keyspan.FragmentIterator
+	for j := len(s.memtables) - 1; j >= 0; j-- {
+		if rangeKeyIter := s.memtables[j].newRangeKeyIter(&s.iterOpts); rangeKeyIter != nil {
+			iters = append(iters, rangeKeyIter)
+		}
+	}
+	current := s.readState.current
+	// L0 files containing range keys are not organized into sublevels, so
+	// each is added individually. See Iterator.constructRangeKeyIter.
+	iter := current.RangeKeyLevels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		spanIter, err := d.tableNewRangeKeyIter(f, &keyspan.SpanIterOptions{})
+		if err != nil {
+			iters = append(iters, &errorKeyspanIter{err: err})
+			continue
+		}
+		iters = append(iters, spanIter)
+	}
+	for level := 1; level < len(current.RangeKeyLevels); level++ {
+		if current.RangeKeyLevels[level].Empty() {
+			continue
+		}
+		li := &keyspan.LevelIter{}
+		li.Init(keyspan.SpanIterOptions{}, d.cmp, d.tableNewRangeKeyIter,
+			current.RangeKeyLevels[level].Iter(), manifest.Level(level), d.opts.Logger,
+			manifest.KeyTypeRange)
+		iters = append(iters, li)
+	}
+	return s.mergeSpans(iters)
+}
+
+// mergeSpans merges and fragments the spans of the provided iterators,
+// retaining all keys visible to the scan.
+func (s *scanInternalState) mergeSpans(iters []keyspan.FragmentIterator) keyspan.FragmentIterator {
+	m := &keyspan.MergingIter{}
+	m.Init(s.d.cmp, keyspan.TransformerFunc(
+		func(_ base.Compare, in keyspan.Span, out *keyspan.Span) error {
+			out.Start, out.End = in.Start, in.End
+			out.Keys = out.Keys[:0]
+			for _, k := range in.Keys {
+				if s.visible(k.SeqNum()) {
+					out.Keys = append(out.Keys, k)
+				}
+			}
+			return nil
+		}), iters...)
+	return m
+}
+
+// scanSpans invokes visit for each non-empty span of iter that overlaps the
+// bounds of the scan, truncating the span to the bounds. It closes iter.
+func (s *scanInternalState) scanSpans(
+	iter keyspan.FragmentIterator, visit func(span *keyspan.Span) error,
+) error {
+	cmp := s.d.cmp
+	var span *keyspan.Span
+	if s.lower != nil {
+		// SeekLT finds a span that straddles the lower bound, if any.
+		span = iter.SeekLT(s.lower)
+		if span == nil || cmp(span.End, s.lower) <= 0 {
+			span = iter.Next()
+		}
+	} else {
+		span = iter.First()
+	}
+	var err error
+	for ; span != nil; span = iter.Next() {
+		if s.upper != nil && cmp(span.Start, s.upper) >= 0 {
+			break
+		}
+		if err = s.ctx.Err(); err != nil {
+			break
+		}
+		if span.Empty() {
+			continue
+		}
+		truncated := *span
+		if s.lower != nil && cmp(truncated.Start, s.lower) < 0 {
+			truncated.Start = s.lower
+		}
+		if s.upper != nil && cmp(truncated.End, s.upper) > 0 {
+			truncated.End = s.upper
+		}
+		if err = visit(&truncated); err != nil {
+			break
+		}
+	}
+	return firstError(err, firstError(iter.Error(), iter.Close()))
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanInternal(t *testing.T) {
+	var d *DB
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+	snapshots := map[string]*Snapshot{}
+
+	datadriven.RunTest(t, "testdata/scan_internal", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "reset":
+			for _, s := range snapshots {
+				require.NoError(t, s.Close())
+			}
+			snapshots = map[string]*Snapshot{}
+			if d != nil {
+				require.NoError(t, d.Close())
+			}
+			opts := &Options{
+				FS:                          vfs.NewMem(),
+				Comparer:                    testkeys.Comparer,
+				FormatMajorVersion:          FormatRangeKeys,
+				DisableAutomaticCompactions: true,
+			}
+			var err error
+			d, err = Open("", opts)
+			require.NoError(t, err)
+			return ""
+
+		case "batch":
+			b := d.NewBatch()
+			if err := runBatchDefineCmd(td, b); err != nil {
+				return err.Error()
+			}
+			if err := b.Commit(nil); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "flush":
+			if err := d.Flush(); err != nil {
+				return err.Error()
+			}
+			return ""
+
+		case "compact":
+			if err := runCompactCmd(td, d); err != nil {
+				return err.Error()
+			}
+			return runLSMCmd(td, d)
+
+		case "snapshot":
+			snapshots[td.CmdArgs[0].String()] = d.NewSnapshot()
+			return ""
+
+		case "scan-internal":
+			var lower, upper []byte
+			var opts ScanInternalOptions
+			types := "points,rangedels,rangekeys"
+			for _, arg := range td.CmdArgs {
+				switch arg.Key {
+				case "lower":
+					lower = []byte(arg.Vals[0])
+				case "upper":
+					upper = []byte(arg.Vals[0])
+				case "snapshot":
+					s, ok := snapshots[arg.Vals[0]]
+					if !ok {
+						return fmt.Sprintf("unknown snapshot %q", arg.Vals[0])
+					}
+					opts.SeqNum = s.seqNum
+				case "types":
+					types = strings.Join(arg.Vals, ",")
+				default:
+					return fmt.Sprintf("unknown argument %q", arg.Key)
+				}
+			}
+			var buf strings.Builder
+			var visitPointKey func(key *InternalKey, value []byte) error
+			var visitRangeDel func(start, end []byte, seqNum uint64) error
+			var visitRangeKey func(start, end []byte, keys []InternalRangeKey) error
+			if strings.Contains(types, "points") {
+				visitPointKey = func(key *InternalKey, value []byte) error {
+					fmt.Fprintf(&buf, "%s#%d,%s: %s\n", key.UserKey, key.SeqNum(), key.Kind(), value)
+					return nil
+				}
+			}
+			if strings.Contains(types, "rangedels") {
+				visitRangeDel = func(start, end []byte, seqNum uint64) error {
+					fmt.Fprintf(&buf, "rangedel [%s, %s)#%d\n", start, end, seqNum)
+					return nil
+				}
+			}
+			if strings.Contains(types, "rangekeys") {
+				visitRangeKey = func(start, end []byte, keys []InternalRangeKey) error {
+					fmt.Fprintf(&buf, "rangekey [%s, %s):", start, end)
+					for _, k := range keys {
+						fmt.Fprintf(&buf, " (#%d,%s", k.SeqNum(), k.Kind())
+						if k.Suffix != nil {
+							fmt.Fprintf(&buf, ",%s", k.Suffix)
+						}
+						if k.Value != nil {
+							fmt.Fprintf(&buf, ",%s", k.Value)
+						}
+						buf.WriteString(")")
+					}
+					buf.WriteString("\n")
+					return nil
+				}
+			}
+			err := d.ScanInternal(context.Background(), lower, upper,
+				visitPointKey, visitRangeDel, visitRangeKey, &opts)
+			if err != nil {
+				fmt.Fprintf(&buf, "error: %s\n", err)
+			}
+			return buf.String()
+
+		default:
+			return fmt.Sprintf("unknown command %q", td.Cmd)
+		}
+	})
+	for _, s := range snapshots {
+		require.NoError(t, s.Close())
+	}
+}
+
+func TestScanInternalErrors(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for i := 0; i < 2*scanInternalCheckInterval; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%05d", i)), nil, nil))
+	}
+
+	// An error returned by a visit function stops the scan.
+	errStop := errors.New("stop")
+	var n int
+	err = d.ScanInternal(context.Background(), nil, nil,
+		func(key *InternalKey, value []byte) error {
+			if n++; n == 10 {
+				return errStop
+			}
+			return nil
+		}, nil, nil, nil)
+	require.True(t, errors.Is(err, errStop))
+	require.Equal(t, 10, n)
+
+	// A canceled context stops the scan.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n = 0
+	err = d.ScanInternal(ctx, nil, nil,
+		func(key *InternalKey, value []byte) error {
+			n++
+			return nil
+		}, nil, nil, nil)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Less(t, n, 2*scanInternalCheckInterval)
+}
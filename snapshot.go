@@ -5,8 +5,13 @@
 package pebble
 
 import (
+	"context"
 	"io"
 	"math"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
 )
 
 // Snapshot provides a read-only point-in-time view of the DB state.
@@ -35,7 +40,7 @@ func (s *Snapshot) Get(key []byte) ([]byte, io.Closer, error) {
 	if s.db == nil {
 		panic(ErrClosed)
 	}
-	return s.db.getInternal(key, nil /* batch */, s)
+	return s.db.getInternal(key, nil /* batch */, snapshotIterOpts{seqNum: s.seqNum}, nil /* valueBuf */)
 }
 
 // NewIter returns an iterator that is unpositioned (Iterator.Valid() will
@@ -45,7 +50,7 @@ func (s *Snapshot) NewIter(o *IterOptions) *Iterator {
 	if s.db == nil {
 		panic(ErrClosed)
 	}
-	return s.db.newIterInternal(nil /* batch */, s, o)
+	return s.db.newIterInternal(nil /* batch */, snapshotIterOpts{seqNum: s.seqNum}, o)
 }
 
 // Close closes the snapshot, releasing its resources. Close must be called.
@@ -62,7 +67,7 @@ func (s *Snapshot) Close() error {
 	// If s was the previous earliest snapshot, we might be able to reclaim
 	// disk space by dropping obsolete records that were pinned by s.
 	if e := s.db.mu.snapshots.earliest(); e > s.seqNum {
-		s.db.maybeScheduleCompactionPicker(pickElisionOnly)
+		s.db.maybeScheduleCompactionPicker(pickElisionOnly, false /* force */)
 	}
 	s.db.mu.Unlock()
 	s.db = nil
@@ -136,3 +141,334 @@ func (l *snapshotList) remove(s *Snapshot) {
 	s.prev = nil // avoid memory leaks
 	s.list = nil // avoid memory leaks
 }
+
+// snapshotIterOpts describes the state a read through a snapshot observes.
+type snapshotIterOpts struct {
+	// seqNum is the sequence number to read at. A zero seqNum reads at the
+	// DB's visible sequence number.
+	seqNum uint64
+	// readState, if non-nil, is read from in place of the DB's current
+	// readState. The caller must hold a reference on readState for the
+	// duration of the call to which it's passed.
+	readState *readState
+}
+
+// loadReadState returns a referenced readState to read from.
+func (o snapshotIterOpts) loadReadState(d *DB) *readState {
+	if o.readState != nil {
+		o.readState.ref()
+		return o.readState
+	}
+	return d.loadReadState()
+}
+
+// KeyRange encodes a key range in user key space. A KeyRange's Start is
+// inclusive while its End is exclusive.
+type KeyRange struct {
+	Start, End []byte
+}
+
+// EventuallyFileOnlySnapshot (aka EFOS) provides a read-only point-in-time
+// view of the DB state within a set of key ranges, similar to a Snapshot.
+// Initially an EFOS behaves like a Snapshot, preventing compactions from
+// dropping the keys it can observe. Once every memtable containing keys
+// visible to the EFOS within its key ranges has been flushed, the EFOS
+// transitions to referencing the version of the LSM current at the time of
+// the transition. Thereafter it no longer pins a sequence number, allowing
+// compactions to drop obsolete keys, and never reads from memtables. The
+// sstables of the referenced version are retained until the EFOS is closed.
+//
+// Reads through an EFOS must be confined to its key ranges. Keys outside the
+// key ranges may be unflushed at the time of the transition, and will not be
+// observed by reads after the transition.
+type EventuallyFileOnlySnapshot struct {
+	db        *DB
+	seqNum    uint64
+	keyRanges []KeyRange
+
+	// transitioned is closed once the EFOS is file-only.
+	transitioned chan struct{}
+	// closed is closed when the EFOS is closed.
+	closed chan struct{}
+
+	mu struct {
+		sync.RWMutex
+		// snap is the snapshot pinning seqNum until the EFOS transitions to
+		// being file-only.
+		snap *Snapshot
+		// readState is the referenced state read from once the EFOS is
+		// file-only. It holds the version current at the time of the
+		// transition, and no memtables.
+		readState *readState
+		isClosed  bool
+	}
+}
+
+var _ Reader = (*EventuallyFileOnlySnapshot)(nil)
+
+// NewEventuallyFileOnlySnapshot returns a point-in-time view of the current DB
+// state within the provided key ranges. See EventuallyFileOnlySnapshot.
+//
+// The EFOS transitions to being file-only once the memtables containing keys
+// it observes within keyRanges are flushed. A flush is not forced; callers
+// that need the transition to occur promptly may call DB.Flush.
+func (d *DB) NewEventuallyFileOnlySnapshot(keyRanges []KeyRange) *EventuallyFileOnlySnapshot {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	es := &EventuallyFileOnlySnapshot{
+		db:           d,
+		keyRanges:    make([]KeyRange, len(keyRanges)),
+		transitioned: make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+	meta := make([]*fileMetadata, len(keyRanges))
+	for i, kr := range keyRanges {
+		es.keyRanges[i] = KeyRange{
+			Start: append([]byte(nil), kr.Start...),
+			End:   append([]byte(nil), kr.End...),
+		}
+		meta[i] = (&fileMetadata{}).ExtendPointKeyBounds(d.cmp,
+			base.MakeInternalKey(es.keyRanges[i].Start, InternalKeySeqNumMax, InternalKeyKindMax),
+			base.MakeRangeDeleteSentinelKey(es.keyRanges[i].End))
+	}
+	es.mu.snap = d.NewSnapshot()
+	es.seqNum = es.mu.snap.seqNum
+
+	// Find the memtables that may contain keys visible to the snapshot within
+	// its key ranges. Memtables are ordered from oldest to newest, and a
+	// memtable whose logSeqNum is not less than the snapshot's sequence number
+	// only contains newer keys.
+	var pending []*flushableEntry
+	d.mu.Lock()
+	for _, mem := range d.mu.mem.queue {
+		if mem.logSeqNum >= es.seqNum {
+			break
+		}
+		if flushableOverlapsKeyRanges(d.cmp, mem.flushable, meta) {
+			pending = append(pending, mem)
+		}
+	}
+	d.mu.Unlock()
+
+	if len(pending) == 0 {
+		es.transition()
+	} else {
+		go es.waitForFlushes(pending)
+	}
+	return es
+}
+
+// flushableOverlapsKeyRanges returns true if the flushable contains point
+// keys, range deletions or range keys that overlap any of the provided
+// bounds.
+func flushableOverlapsKeyRanges(cmp Compare, mem flushable, meta []*fileMetadata) bool {
+	if rangeKeyIter := mem.newRangeKeyIter(nil); rangeKeyIter != nil {
+		// Conservatively consider any range keys to overlap.
+		_ = rangeKeyIter.Close()
+		return true
+	}
+	return ingestMemtableOverlaps(cmp, mem, meta)
+}
+
+// waitForFlushes waits for the provided memtables to be flushed, and then
+// transitions the EFOS to being file-only.
+func (es *EventuallyFileOnlySnapshot) waitForFlushes(pending []*flushableEntry) {
+	for _, mem := range pending {
+		select {
+		case <-mem.flushed:
+		case <-es.closed:
+			return
+		case <-es.db.closedCh:
+			return
+		}
+	}
+	es.transition()
+}
+
+// transition transitions the EFOS to being file-only, by referencing the
+// current version and releasing the snapshot. All the keys the EFOS observes
+// within its key ranges must be present in the current version.
+func (es *EventuallyFileOnlySnapshot) transition() {
+	d := es.db
+	d.mu.Lock()
+	if d.closed.Load() != nil {
+		d.mu.Unlock()
+		return
+	}
+	// The snapshot has pinned the keys visible at seqNum up to this point, so
+	// the current version contains every key the EFOS observes.
+	rs := &readState{
+		db:      d,
+		refcnt:  1,
+		current: d.mu.versions.currentVersion(),
+	}
+	rs.current.Ref()
+	d.mu.Unlock()
+
+	es.mu.Lock()
+	if es.mu.isClosed {
+		es.mu.Unlock()
+		rs.unref()
+		return
+	}
+	snap := es.mu.snap
+	es.mu.snap = nil
+	es.mu.readState = rs
+	es.mu.Unlock()
+
+	close(es.transitioned)
+	_ = snap.Close()
+}
+
+// snapshotIterOptsLocked returns the state reads through the EFOS should
+// observe. es.mu must be held.
+func (es *EventuallyFileOnlySnapshot) snapshotIterOptsLocked() snapshotIterOpts {
+	if es.mu.isClosed {
+		panic(ErrClosed)
+	}
+	return snapshotIterOpts{seqNum: es.seqNum, readState: es.mu.readState}
+}
+
+// IsFileOnly returns true if the EFOS has transitioned to being file-only.
+func (es *EventuallyFileOnlySnapshot) IsFileOnly() bool {
+	select {
+	case <-es.transitioned:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForFileOnly blocks until the EFOS has transitioned to being file-only,
+// the provided context is done, or the EFOS or DB is closed.
+func (es *EventuallyFileOnlySnapshot) WaitForFileOnly(ctx context.Context) error {
+	select {
+	case <-es.transitioned:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-es.closed:
+		return ErrClosed
+	case <-es.db.closedCh:
+		return ErrClosed
+	}
+}
+
+// Get gets the value for the given key. It returns ErrNotFound if the EFOS
+// does not contain the key.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (es *EventuallyFileOnlySnapshot) Get(key []byte) ([]byte, io.Closer, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.db.getInternal(key, nil /* batch */, es.snapshotIterOptsLocked(), nil /* valueBuf */)
+}
+
+// NewIter returns an iterator that is unpositioned (Iterator.Valid() will
+// return false). The iterator can be positioned via a call to SeekGE,
+// SeekLT, First or Last. The iterator's bounds should be confined to the
+// EFOS's key ranges.
+func (es *EventuallyFileOnlySnapshot) NewIter(o *IterOptions) *Iterator {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.db.newIterInternal(nil /* batch */, es.snapshotIterOptsLocked(), o)
+}
+
+// Close closes the EFOS, releasing its resources. Close must be called.
+// Iterators created from the EFOS remain valid after it is closed.
+func (es *EventuallyFileOnlySnapshot) Close() error {
+	es.mu.Lock()
+	if es.mu.isClosed {
+		es.mu.Unlock()
+		panic(ErrClosed)
+	}
+	es.mu.isClosed = true
+	snap, rs := es.mu.snap, es.mu.readState
+	es.mu.snap, es.mu.readState = nil, nil
+	es.mu.Unlock()
+
+	close(es.closed)
+	if snap != nil {
+		return snap.Close()
+	}
+	if rs != nil {
+		rs.unref()
+	}
+	return nil
+}
+
+// PrefixSnapshot provides a read-only point-in-time view of the DB state
+// restricted to the keys with a prefix, the keys DeletePrefix would delete. A
+// PrefixSnapshot is an EventuallyFileOnlySnapshot of the prefix's key range:
+// once the memtables containing keys with the prefix visible to it have been
+// flushed, it no longer pins a sequence number, even if memtables containing
+// only other keys remain unflushed. Reads through a PrefixSnapshot are
+// confined to the prefix's key range and observe the DB state at the time the
+// PrefixSnapshot was created.
+type PrefixSnapshot struct {
+	*EventuallyFileOnlySnapshot
+	// prefix and end are the inclusive start and exclusive end of the
+	// prefix's key range.
+	prefix, end []byte
+}
+
+var _ Reader = (*PrefixSnapshot)(nil)
+
+// NewPrefixSnapshot returns a point-in-time view of the current DB state
+// restricted to the keys with the provided prefix. See PrefixSnapshot.
+//
+// Like DeletePrefix, the PrefixSnapshot observes the keys whose Split prefix
+// equals the provided prefix.
+func (d *DB) NewPrefixSnapshot(prefix []byte) (*PrefixSnapshot, error) {
+	s := &PrefixSnapshot{prefix: append([]byte(nil), prefix...), end: prefixEnd(d.opts.Comparer, prefix)}
+	s.EventuallyFileOnlySnapshot = d.NewEventuallyFileOnlySnapshot([]KeyRange{{Start: s.prefix, End: s.end}})
+	return s, nil
+}
+
+// contains returns true if the key lies within the snapshot's prefix.
+func (s *PrefixSnapshot) contains(key []byte) bool {
+	return s.db.cmp(key, s.prefix) >= 0 && s.db.cmp(key, s.end) < 0
+}
+
+// Get gets the value for the given key, which must have the PrefixSnapshot's
+// prefix. It returns ErrNotFound if the PrefixSnapshot does not contain the
+// key.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (s *PrefixSnapshot) Get(key []byte) ([]byte, io.Closer, error) {
+	if !s.contains(key) {
+		return nil, nil, errors.Errorf("pebble: key %s does not have the snapshot's prefix %s",
+			s.db.opts.Comparer.FormatKey(key), s.db.opts.Comparer.FormatKey(s.prefix))
+	}
+	return s.EventuallyFileOnlySnapshot.Get(key)
+}
+
+// NewIter returns an iterator that is unpositioned (Iterator.Valid() will
+// return false). The iterator can be positioned via a call to SeekGE,
+// SeekLT, First or Last. The iterator's bounds are narrowed to the
+// PrefixSnapshot's prefix.
+func (s *PrefixSnapshot) NewIter(o *IterOptions) *Iterator {
+	var opts IterOptions
+	if o != nil {
+		opts = *o
+	}
+	cmp := s.db.cmp
+	if opts.LowerBound == nil || cmp(opts.LowerBound, s.prefix) < 0 {
+		opts.LowerBound = s.prefix
+	}
+	if opts.UpperBound == nil || cmp(opts.UpperBound, s.end) > 0 {
+		opts.UpperBound = s.end
+	}
+	if cmp(opts.UpperBound, opts.LowerBound) < 0 {
+		// The provided bounds lie entirely outside the prefix.
+		opts.UpperBound = opts.LowerBound
+	}
+	return s.EventuallyFileOnlySnapshot.NewIter(&opts)
+}
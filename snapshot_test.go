@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"reflect"
 	"runtime"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -348,3 +350,177 @@ func TestNewSnapshotRace(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, d.Close())
 }
+
+func TestEventuallyFileOnlySnapshot(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	set := func(k, v string) {
+		require.NoError(t, d.Set([]byte(k), []byte(v), nil))
+	}
+	read := func(r Reader) string {
+		var buf bytes.Buffer
+		iter := r.NewIter(&IterOptions{LowerBound: []byte("a"), UpperBound: []byte("m")})
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+	get := func(r Reader, k string) string {
+		v, closer, err := r.Get([]byte(k))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	set("a", "1")
+	set("b", "1")
+	set("z", "1")
+	es := d.NewEventuallyFileOnlySnapshot([]KeyRange{{Start: []byte("a"), End: []byte("m")}})
+	defer func() { require.NoError(t, es.Close()) }()
+	require.False(t, es.IsFileOnly())
+
+	// Writes after the EFOS was created are not visible through it.
+	set("a", "2")
+	set("c", "2")
+	require.NoError(t, d.Delete([]byte("b"), nil))
+	require.Equal(t, "a:1 b:1", read(es))
+	require.Equal(t, "a:2 c:2", read(d))
+
+	// Waiting for the transition times out, since the memtable containing
+	// the EFOS's keys has not been flushed.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, es.WaitForFileOnly(ctx), context.DeadlineExceeded)
+
+	require.NoError(t, d.Flush())
+	require.NoError(t, es.WaitForFileOnly(context.Background()))
+	require.True(t, es.IsFileOnly())
+
+	// The EFOS no longer pins a sequence number or the flushed memtable, but
+	// continues to observe the same keys.
+	d.mu.Lock()
+	require.True(t, d.mu.snapshots.empty())
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.MemTable.Count)
+	require.Equal(t, int64(0), m.MemTable.ZombieCount)
+	require.Equal(t, "a:1 b:1", read(es))
+	require.Equal(t, "1", get(es, "a"))
+	require.Equal(t, "1", get(es, "b"))
+	require.Equal(t, "<not found>", get(es, "c"))
+
+	// Compactions may now drop the keys shadowed since the EFOS was created,
+	// while the EFOS continues to read from the version it references.
+	set("b", "3")
+	require.NoError(t, d.Compact([]byte("a"), []byte("z\xff"), false /* parallelize */))
+	require.Equal(t, "a:2 b:3 c:2", read(d))
+	require.Equal(t, "a:1 b:1", read(es))
+}
+
+func TestEventuallyFileOnlySnapshotImmediate(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Unflushed keys outside the EFOS's key ranges don't delay the
+	// transition.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("z"), []byte("1"), nil))
+	es := d.NewEventuallyFileOnlySnapshot([]KeyRange{{Start: []byte("a"), End: []byte("m")}})
+	require.True(t, es.IsFileOnly())
+	require.NoError(t, es.WaitForFileOnly(context.Background()))
+	v, closer, err := es.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, es.Close())
+
+	// Closing an EFOS before its transition releases its snapshot.
+	es = d.NewEventuallyFileOnlySnapshot([]KeyRange{{Start: []byte("y"), End: []byte("zz")}})
+	require.False(t, es.IsFileOnly())
+	require.NoError(t, es.Close())
+	require.ErrorIs(t, es.WaitForFileOnly(context.Background()), ErrClosed)
+	d.mu.Lock()
+	require.True(t, d.mu.snapshots.empty())
+	d.mu.Unlock()
+}
+
+func TestPrefixSnapshot(t *testing.T) {
+	d, err := Open("", &Options{
+		Comparer:                    testkeys.Comparer,
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	set := func(k, v string) {
+		require.NoError(t, d.Set([]byte(k), []byte(v), nil))
+	}
+	read := func(r Reader, o *IterOptions) string {
+		var buf bytes.Buffer
+		iter := r.NewIter(o)
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+
+	set("a@1", "1")
+	set("ab@1", "1")
+	require.NoError(t, d.Flush())
+	set("b@1", "1")
+
+	// The unflushed memtable contains no keys with the prefix, so the
+	// snapshot doesn't pin it.
+	s, err := d.NewPrefixSnapshot([]byte("a"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s.Close()) }()
+	require.True(t, s.IsFileOnly())
+	d.mu.Lock()
+	require.True(t, d.mu.snapshots.empty())
+	d.mu.Unlock()
+
+	// Keys outside the prefix may be written, flushed and compacted, while
+	// reads through the snapshot stay confined to the prefix and consistent.
+	set("a@2", "2")
+	set("b@2", "2")
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	require.Equal(t, "a@2:2 a@1:1 ab@1:1 b@2:2 b@1:1", read(d, nil))
+	require.Equal(t, "a@1:1", read(s, nil))
+	require.Equal(t, "a@1:1", read(s, &IterOptions{UpperBound: []byte("b")}))
+	require.Equal(t, "", read(s, &IterOptions{LowerBound: []byte("b")}))
+	v, closer, err := s.Get([]byte("a@1"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = s.Get([]byte("a@2"))
+	require.ErrorIs(t, err, ErrNotFound)
+	_, _, err = s.Get([]byte("b@1"))
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrNotFound))
+
+	// A snapshot of a prefix with unflushed keys pins them until they're
+	// flushed.
+	set("b@3", "3")
+	s2, err := d.NewPrefixSnapshot([]byte("b"))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s2.Close()) }()
+	require.False(t, s2.IsFileOnly())
+	set("b@4", "4")
+	require.NoError(t, d.Flush())
+	require.NoError(t, s2.WaitForFileOnly(context.Background()))
+	require.Equal(t, "b@3:3 b@2:2 b@1:1", read(s2, nil))
+}
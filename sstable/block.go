@@ -319,6 +319,53 @@ func (i *blockIter) initHandle(cmp Compare, block cache.Handle, globalSeqNum uin
 	return i.init(cmp, block.Get(), globalSeqNum)
 }
 
+// initDecoded is like initHandle, but positions the iterator over a block
+// whose header was parsed ahead of time by decodeBlock. The block is not
+// backed by a cache handle.
+func (i *blockIter) initDecoded(cmp Compare, d *decodedBlock, globalSeqNum uint64) {
+	i.cacheHandle.Release()
+	i.cacheHandle = cache.Handle{}
+	i.cmp = cmp
+	i.restarts = d.restarts
+	i.numRestarts = d.numRestarts
+	i.globalSeqNum = globalSeqNum
+	i.ptr = unsafe.Pointer(&d.data[0])
+	i.data = d.data
+	i.fullKey = i.fullKey[:0]
+	i.val = nil
+	i.clearCache()
+	i.firstKey = d.firstKey
+	if globalSeqNum != 0 && i.restarts > 0 {
+		i.firstKey.SetSeqNum(globalSeqNum)
+	}
+}
+
+// decodedBlock is a block together with the parts of its header that
+// blockIter.init would otherwise parse each time it is initialized. The global
+// sequence number is not applied to firstKey, as it may be assigned after the
+// block is decoded.
+type decodedBlock struct {
+	data        block
+	restarts    int32
+	numRestarts int32
+	firstKey    InternalKey
+}
+
+// decodeBlock parses the header of the given block. The returned decodedBlock
+// retains data, which must not be modified or released while it is in use.
+func decodeBlock(cmp Compare, data block) (*decodedBlock, error) {
+	var i blockIter
+	if err := i.init(cmp, data, 0 /* globalSeqNum */); err != nil {
+		return nil, err
+	}
+	return &decodedBlock{
+		data:        data,
+		restarts:    i.restarts,
+		numRestarts: i.numRestarts,
+		firstKey:    i.firstKey,
+	}, nil
+}
+
 func (i *blockIter) invalidate() {
 	i.clearCache()
 	i.offset = 0
@@ -17,6 +17,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/internal/testkeys"
@@ -1310,7 +1311,7 @@ func runBlockPropsCmd(r *Reader, td *datadriven.TestData) string {
 		if twoLevelIndex {
 			subiter := &blockIter{}
 			subIndex, _, err := r.readBlock(
-				bhp.BlockHandle, nil /* transform */, nil /* readaheadState */)
+				bhp.BlockHandle, nil /* transform */, nil /* readaheadState */, cache.BlockTypeIndex)
 			if err != nil {
 				return err.Error()
 			}
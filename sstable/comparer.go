@@ -21,6 +21,9 @@ type Separator = base.Separator
 // Successor exports the base.Successor type.
 type Successor = base.Successor
 
+// ImmediateSuccessor exports the base.ImmediateSuccessor type.
+type ImmediateSuccessor = base.ImmediateSuccessor
+
 // Split exports the base.Split type.
 type Split = base.Split
 
@@ -32,3 +35,6 @@ var DefaultComparer = base.DefaultComparer
 
 // Merger exports the base.Merger type.
 type Merger = base.Merger
+
+// RangeKeyMerger exports the base.RangeKeyMerger type.
+type RangeKeyMerger = base.RangeKeyMerger
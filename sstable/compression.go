@@ -20,7 +20,7 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	case snappyCompressionBlockType:
 		l, err := snappy.DecodedLen(b)
 		return l, 0, err
-	case zstdCompressionBlockType:
+	case zstdCompressionBlockType, zstdDictCompressionBlockType:
 		// This will also be used by zlib, bzip2 and lz4 to retrieve the decodedLen
 		// if we implement these algorithms in the future.
 		decodedLenU64, varIntLen := binary.Uvarint(b)
@@ -33,7 +33,12 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	}
 }
 
-func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte, error) {
+// decompressInto decompresses compressed into buf. The dict parameter is the
+// table's zstd dictionary, if any, and is required to decompress blocks of
+// type zstdDictCompressionBlockType.
+func decompressInto(
+	blockType blockType, compressed []byte, buf []byte, dict []byte,
+) ([]byte, error) {
 	var result []byte
 	var err error
 	switch blockType {
@@ -41,6 +46,11 @@ func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte,
 		result, err = snappy.Decode(buf, compressed)
 	case zstdCompressionBlockType:
 		result, err = decodeZstd(buf, compressed)
+	case zstdDictCompressionBlockType:
+		if len(dict) == 0 {
+			return nil, base.CorruptionErrorf("pebble/table: block compressed with a zstd dictionary, but table has no dictionary")
+		}
+		result, err = decodeZstdDict(buf, compressed, dict)
 	}
 	if err != nil {
 		return nil, base.MarkCorruptionError(err)
@@ -53,7 +63,10 @@ func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte,
 }
 
 // decompressBlock decompresses an SST block, with space allocated from a cache.
-func decompressBlock(cache *cache.Cache, blockType blockType, b []byte) (*cache.Value, error) {
+// The dict parameter is the table's zstd dictionary, if any.
+func decompressBlock(
+	cache *cache.Cache, blockType blockType, b []byte, dict []byte,
+) (*cache.Value, error) {
 	if blockType == noCompressionBlockType {
 		return nil, nil
 	}
@@ -68,15 +81,18 @@ func decompressBlock(cache *cache.Cache, blockType blockType, b []byte) (*cache.
 	// Allocate sufficient space from the cache.
 	decoded := cache.Alloc(decodedLen)
 	decodedBuf := decoded.Buf()
-	if _, err := decompressInto(blockType, b, decodedBuf); err != nil {
+	if _, err := decompressInto(blockType, b, decodedBuf, dict); err != nil {
 		cache.Free(decoded)
+		return nil, err
 	}
 	return decoded, nil
 }
 
-// compressBlock compresses an SST block, using compressBuf as the desired destination.
+// compressBlock compresses an SST block, using compressBuf as the desired
+// destination. If compression is ZstdCompression and dict is non-empty, the
+// block is compressed using dict as a zstd dictionary.
 func compressBlock(
-	compression Compression, b []byte, compressedBuf []byte,
+	compression Compression, dict []byte, b []byte, compressedBuf []byte,
 ) (blockType blockType, compressed []byte) {
 	switch compression {
 	case SnappyCompression:
@@ -91,6 +107,9 @@ func compressBlock(
 	varIntLen := binary.PutUvarint(compressedBuf, uint64(len(b)))
 	switch compression {
 	case ZstdCompression:
+		if len(dict) > 0 {
+			return zstdDictCompressionBlockType, encodeZstdDict(compressedBuf, varIntLen, b, dict)
+		}
 		return zstdCompressionBlockType, encodeZstd(compressedBuf, varIntLen, b)
 	default:
 		return noCompressionBlockType, b
@@ -9,8 +9,11 @@ package sstable
 
 import (
 	"bytes"
+	"io"
+	"io/ioutil"
 
 	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/errors"
 )
 
 // decodeZstd decompresses b with the Zstandard algorithm.
@@ -32,3 +35,42 @@ func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
 	writer.Close()
 	return buf.Bytes()
 }
+
+// decodeZstdDict decompresses b with the Zstandard algorithm, using dict as
+// the dictionary. It reuses the preallocated capacity of decodedBuf if it is
+// sufficient. On success, it returns the decoded byte slice.
+func decodeZstdDict(decodedBuf, b, dict []byte) ([]byte, error) {
+	reader := zstd.NewReaderDict(bytes.NewReader(b), dict)
+	defer reader.Close()
+	n, err := io.ReadFull(reader, decodedBuf)
+	if err != nil {
+		return nil, err
+	}
+	// Ensure there's no trailing data beyond the expected length.
+	var extra [1]byte
+	if m, _ := reader.Read(extra[:]); m != 0 {
+		return nil, errors.New("pebble/table: zstd block decompressed to more than expected length")
+	}
+	return decodedBuf[:n], nil
+}
+
+// encodeZstdDict is like encodeZstd, but compresses b using dict as the
+// dictionary.
+func encodeZstdDict(compressedBuf []byte, varIntLen int, b, dict []byte) []byte {
+	buf := bytes.NewBuffer(compressedBuf[:varIntLen])
+	writer := zstd.NewWriterLevelDict(buf, 3, dict)
+	writer.Write(b)
+	writer.Close()
+	return buf.Bytes()
+}
+
+// validateZstdDict returns an error if dict cannot be used as a zstd
+// dictionary.
+func validateZstdDict(dict []byte) error {
+	writer := zstd.NewWriterLevelDict(ioutil.Discard, 3, dict)
+	if _, err := writer.Write(nil); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
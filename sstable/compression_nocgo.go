@@ -28,3 +28,34 @@ func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
 	defer encoder.Close()
 	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
 }
+
+// decodeZstdDict decompresses b with the Zstandard algorithm, using dict as
+// the dictionary. It reuses the preallocated capacity of decodedBuf if it is
+// sufficient. On success, it returns the decoded byte slice.
+func decodeZstdDict(decodedBuf, b, dict []byte) ([]byte, error) {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return decoder.DecodeAll(b, decodedBuf[:0])
+}
+
+// encodeZstdDict is like encodeZstd, but compresses b using dict as the
+// dictionary.
+func encodeZstdDict(compressedBuf []byte, varIntLen int, b, dict []byte) []byte {
+	encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderDict(dict))
+	defer encoder.Close()
+	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
+}
+
+// validateZstdDict returns an error if dict cannot be used as a zstd
+// dictionary. Only dictionaries in the zstd dictionary format are supported,
+// not raw content dictionaries.
+func validateZstdDict(dict []byte) error {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dict))
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,360 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+)
+
+// filterMetaPrefix is the prefix of the metaindex entry of a table filter
+// block, which is followed by the name of the filter policy.
+const filterMetaPrefix = "fullfilter."
+
+// RewriteFilter copies the sstable read by r to out, replacing its filter
+// block with one built by the filter policy of the provided WriterOptions, or
+// dropping it if no filter policy is configured. Only the keys are read, to
+// build the filter: the data, index, range deletion and range key blocks are
+// copied verbatim. The top-level index, properties and metaindex blocks are
+// rewritten to account for the new filter block, which shifts the blocks
+// following it.
+//
+// The sstable's table format and checksum type are preserved. The
+// WriterOptions are consulted for the comparer, which must be the comparer
+// the sstable was written with, and the filter policy and type. The size of
+// the rewritten sstable is returned.
+func RewriteFilter(r *Reader, out writeCloseSyncer, o WriterOptions) (size uint64, err error) {
+	defer func() {
+		if out != nil {
+			err = firstError(err, out.Close())
+		}
+	}()
+	o = o.ensureDefaults()
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.tableFormat < TableFormatRocksDBv2 {
+		return 0, errors.Errorf("pebble: cannot rewrite the filter of a %s sstable", r.tableFormat)
+	}
+	if was, is := r.Properties.ComparerName, o.Comparer.Name; was != is {
+		return 0, errors.Errorf("mismatched Comparer %s vs %s, rewriting the filter requires the same splitter", was, is)
+	}
+	if o.FilterPolicy != nil && o.FilterType != TableFilter {
+		return 0, errors.Errorf("unknown filter type: %v", o.FilterType)
+	}
+
+	props := r.Properties
+	props.FilterPolicyName = ""
+	props.FilterSize = 0
+	props.PrefixExtractorName = "nullptr"
+	props.PrefixFiltering = false
+	props.WholeKeyFiltering = false
+	var filterName string
+	var filterBlock []byte
+	if o.FilterPolicy != nil {
+		if filterBlock, err = buildFilter(r, o); err != nil {
+			return 0, err
+		}
+		filterName = filterMetaPrefix + o.FilterPolicy.Name()
+		props.FilterPolicyName = o.FilterPolicy.Name()
+		if o.Comparer.Split != nil {
+			props.PrefixExtractorName = o.Comparer.Name
+			props.PrefixFiltering = true
+		} else {
+			props.WholeKeyFiltering = true
+		}
+	}
+
+	meta, blocks, err := readRewriteLayout(r)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(out)
+	fw := &filterRewriter{
+		r:        r,
+		w:        bw,
+		blockBuf: blockBuf{checksummer: checksummer{checksumType: r.checksumType}},
+		handles:  make(map[uint64]BlockHandle, len(blocks)),
+	}
+	// The data blocks precede the filter block, and are unaffected by it.
+	if err := fw.copy(0, props.DataSize); err != nil {
+		return 0, err
+	}
+	if o.FilterPolicy != nil {
+		bh, err := fw.writeBlock(filterBlock)
+		if err != nil {
+			return 0, err
+		}
+		meta[filterName] = bh
+		props.FilterSize = bh.Length
+	}
+
+	// Copy the remaining blocks, in the order they were written, rewriting
+	// those which refer to the offsets of other blocks.
+	var topLevelIndexSize uint64
+	var rewroteIndex bool
+	for _, bh := range blocks {
+		var newBH BlockHandle
+		switch {
+		case bh == r.indexBH && r.Properties.IndexPartitions > 0:
+			var b []byte
+			if b, err = fw.rewriteTopLevelIndex(bh); err != nil {
+				return 0, err
+			}
+			topLevelIndexSize = uint64(len(b))
+			newBH, err = fw.writeBlock(b)
+			rewroteIndex = true
+		case bh == r.propertiesBH:
+			if r.Properties.IndexPartitions > 0 {
+				if !rewroteIndex {
+					return 0, base.CorruptionErrorf("pebble/table: properties block precedes the top-level index block")
+				}
+				props.IndexSize = props.IndexSize - props.TopLevelIndexSize + topLevelIndexSize
+				props.TopLevelIndexSize = topLevelIndexSize
+			}
+			var raw rawBlockWriter
+			raw.restartInterval = propertiesBlockRestartInterval
+			props.save(&raw)
+			newBH, err = fw.writeBlock(raw.finish())
+		default:
+			newBH, err = fw.copyBlock(bh)
+		}
+		if err != nil {
+			return 0, err
+		}
+		fw.handles[bh.Offset] = newBH
+	}
+
+	// Write the metaindex block, whose entries must be sorted by name.
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var metaindex rawBlockWriter
+	metaindex.restartInterval = 1
+	for _, name := range names {
+		bh := meta[name]
+		if name != filterName {
+			bh = fw.handles[bh.Offset]
+		}
+		n := encodeBlockHandle(fw.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(name)}, fw.blockBuf.tmp[:n])
+	}
+	metaindexBH, err := fw.writeBlock(metaindex.blockWriter.finish())
+	if err != nil {
+		return 0, err
+	}
+
+	footer := footer{
+		format:      r.tableFormat,
+		checksum:    r.checksumType,
+		metaindexBH: metaindexBH,
+		indexBH:     fw.handles[r.indexBH.Offset],
+	}
+	if err := fw.write(footer.encode(fw.blockBuf.tmp[:])); err != nil {
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	err = out.Close()
+	out = nil
+	return fw.size, err
+}
+
+// buildFilter returns the filter block for the point keys of the sstable read
+// by r, built as a Writer would with the provided WriterOptions.
+func buildFilter(r *Reader, o WriterOptions) (_ []byte, err error) {
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = firstError(err, iter.Close())
+	}()
+	filter := newTableFilterWriter(o.FilterPolicy)
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		if o.Comparer.Split != nil {
+			filter.addKey(key.UserKey[:o.Comparer.Split(key.UserKey)])
+		} else {
+			filter.addKey(key.UserKey)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return filter.finish()
+}
+
+// readRewriteLayout returns the entries of the metaindex block of the sstable
+// read by r, excluding any filter block, and the handles of the blocks
+// following the data and filter blocks, other than the metaindex block, in the
+// order they were written. An error is returned if the blocks do not lie
+// contiguously between the filter and metaindex blocks, as the sstable would
+// then not be copied in its entirety.
+func readRewriteLayout(r *Reader) (map[string]BlockHandle, []BlockHandle, error) {
+	h, _, err := r.readBlock(r.metaIndexBH, nil /* transform */, nil /* readaheadState */, cache.BlockTypeMeta)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer h.Release()
+	iter, err := newRawBlockIter(bytes.Compare, h.Get())
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := map[string]BlockHandle{}
+	var blocks []BlockHandle
+	seen := map[uint64]bool{}
+	add := func(bh BlockHandle) {
+		if !seen[bh.Offset] {
+			seen[bh.Offset] = true
+			blocks = append(blocks, bh)
+		}
+	}
+	end := r.Properties.DataSize
+	for valid := iter.First(); valid; valid = iter.Next() {
+		bh, n := decodeBlockHandle(iter.Value())
+		if n == 0 {
+			return nil, nil, base.CorruptionErrorf("pebble/table: invalid table (bad metaindex block handle)")
+		}
+		name := string(iter.Key().UserKey)
+		if strings.HasPrefix(name, filterMetaPrefix) {
+			if bh.Offset != end {
+				return nil, nil, base.CorruptionErrorf("pebble/table: filter block does not follow the data blocks")
+			}
+			end += bh.Length + blockTrailerLen
+			continue
+		}
+		meta[name] = bh
+		add(bh)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	add(r.indexBH)
+	if r.Properties.IndexPartitions > 0 {
+		indexH, err := r.readIndex()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer indexH.Release()
+		topIter, err := newBlockIter(r.Compare, indexH.Get())
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, value := topIter.First(); key != nil; key, value = topIter.Next() {
+			bhp, err := decodeBlockHandleWithProperties(value)
+			if err != nil {
+				return nil, nil, errCorruptIndexEntry
+			}
+			add(bhp.BlockHandle)
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Offset < blocks[j].Offset
+	})
+	for _, bh := range blocks {
+		if bh.Offset != end {
+			return nil, nil, base.CorruptionErrorf(
+				"pebble/table: unexpected block at offset %d, expected offset %d",
+				errors.Safe(bh.Offset), errors.Safe(end))
+		}
+		end += bh.Length + blockTrailerLen
+	}
+	if end != r.metaIndexBH.Offset {
+		return nil, nil, base.CorruptionErrorf(
+			"pebble/table: unexpected metaindex block offset %d, expected offset %d",
+			errors.Safe(r.metaIndexBH.Offset), errors.Safe(end))
+	}
+	return meta, blocks, nil
+}
+
+// filterRewriter writes the sstable produced by RewriteFilter.
+type filterRewriter struct {
+	r        *Reader
+	w        io.Writer
+	blockBuf blockBuf
+	size     uint64
+	// handles maps the offsets of the blocks of the original sstable to their
+	// handles in the rewritten sstable.
+	handles map[uint64]BlockHandle
+}
+
+func (fw *filterRewriter) write(b []byte) error {
+	n, err := fw.w.Write(b)
+	fw.size += uint64(n)
+	return err
+}
+
+// copy copies n bytes of the original sstable, starting at offset.
+func (fw *filterRewriter) copy(offset, n uint64) error {
+	copied, err := io.Copy(fw.w, io.NewSectionReader(fw.r.file, int64(offset), int64(n)))
+	fw.size += uint64(copied)
+	if err == nil && uint64(copied) != n {
+		err = base.CorruptionErrorf("pebble/table: unexpected end of table at offset %d", errors.Safe(offset+uint64(copied)))
+	}
+	return err
+}
+
+// copyBlock copies the block of the original sstable identified by bh,
+// including its trailer, returning its handle in the rewritten sstable.
+func (fw *filterRewriter) copyBlock(bh BlockHandle) (BlockHandle, error) {
+	newBH := BlockHandle{Offset: fw.size, Length: bh.Length}
+	return newBH, fw.copy(bh.Offset, bh.Length+blockTrailerLen)
+}
+
+// writeBlock writes the uncompressed block b, returning its handle.
+func (fw *filterRewriter) writeBlock(b []byte) (BlockHandle, error) {
+	bh := BlockHandle{Offset: fw.size, Length: uint64(len(b))}
+	b = compressAndChecksum(b, NoCompression, nil /* dict */, &fw.blockBuf)
+	if err := fw.write(b); err != nil {
+		return BlockHandle{}, err
+	}
+	return bh, fw.write(fw.blockBuf.tmp[:blockTrailerLen])
+}
+
+// rewriteTopLevelIndex returns the top-level index block of a two-level
+// sstable, identified by bh, with the handles of the index partitions
+// replaced by their handles in the rewritten sstable. The partitions must have
+// been copied already.
+func (fw *filterRewriter) rewriteTopLevelIndex(bh BlockHandle) ([]byte, error) {
+	h, _, err := fw.r.readBlock(bh, nil /* transform */, nil /* readaheadState */, cache.BlockTypeIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Release()
+	iter, err := newBlockIter(fw.r.Compare, h.Get())
+	if err != nil {
+		return nil, err
+	}
+	topLevelIndex := blockWriter{restartInterval: 1}
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		bhp, err := decodeBlockHandleWithProperties(value)
+		if err != nil {
+			return nil, errCorruptIndexEntry
+		}
+		newBH, ok := fw.handles[bhp.Offset]
+		if !ok {
+			return nil, base.CorruptionErrorf("pebble/table: top-level index block precedes an index partition")
+		}
+		bhp.BlockHandle = newBH
+		topLevelIndex.add(*key, encodeBlockHandleWithProperties(fw.blockBuf.tmp[:], bhp))
+	}
+	return topLevelIndex.finish(), nil
+}
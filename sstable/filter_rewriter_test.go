@@ -0,0 +1,83 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteFilter(t *testing.T) {
+	makeTable := func(policy FilterPolicy, indexBlockSize int) []byte {
+		f := &memFile{}
+		w := NewWriter(f, WriterOptions{
+			BlockSize:      256,
+			IndexBlockSize: indexBlockSize,
+			Comparer:       base.DefaultComparer,
+			Compression:    NoCompression,
+			FilterPolicy:   policy,
+			TableFormat:    TableFormatPebblev2,
+		})
+		for i := 0; i < 1000; i++ {
+			k := []byte(fmt.Sprintf("%05d", 2*i))
+			require.NoError(t, w.Set(k, k))
+		}
+		require.NoError(t, w.DeleteRange([]byte("00100"), []byte("00200")))
+		require.NoError(t, w.RangeKeySet([]byte("00300"), []byte("00400"), nil, []byte("v")))
+		require.NoError(t, w.Close())
+		return f.Bytes()
+	}
+
+	weak, strong := bloom.FilterPolicy(1), bloom.FilterPolicy(10)
+	readerOpts := ReaderOptions{
+		Comparer: base.DefaultComparer,
+		Filters:  map[string]FilterPolicy{strong.Name(): strong},
+	}
+	for _, twoLevel := range []bool{false, true} {
+		indexBlockSize := 1 << 20
+		if twoLevel {
+			indexBlockSize = 256
+		}
+		t.Run(fmt.Sprintf("twoLevel=%t", twoLevel), func(t *testing.T) {
+			r, err := NewMemReader(makeTable(weak, indexBlockSize), readerOpts)
+			require.NoError(t, err)
+			defer r.Close()
+			require.Equal(t, twoLevel, r.Properties.IndexPartitions > 0)
+
+			// The rewritten table is identical to one written with the new
+			// filter policy, or without a filter policy.
+			for _, policy := range []FilterPolicy{strong, nil} {
+				rewritten := &memFile{}
+				size, err := RewriteFilter(r, rewritten, WriterOptions{
+					Comparer:     base.DefaultComparer,
+					FilterPolicy: policy,
+				})
+				require.NoError(t, err)
+				require.Equal(t, uint64(rewritten.Len()), size)
+				require.Equal(t, makeTable(policy, indexBlockSize), rewritten.Bytes())
+
+				r2, err := NewMemReader(rewritten.Bytes(), readerOpts)
+				require.NoError(t, err)
+				require.NoError(t, r2.ValidateBlockChecksums())
+				require.NoError(t, r2.Close())
+			}
+		})
+	}
+
+	t.Run("mismatched-comparer", func(t *testing.T) {
+		r, err := NewMemReader(makeTable(weak, 0), readerOpts)
+		require.NoError(t, err)
+		defer r.Close()
+		_, err = RewriteFilter(r, &memFile{}, WriterOptions{
+			Comparer:     test4bSuffixComparer,
+			FilterPolicy: strong,
+		})
+		require.Error(t, err)
+	})
+}
@@ -71,6 +71,13 @@ type TablePropertyCollector interface {
 	Name() string
 }
 
+// WriteLimiter limits the rate at which a Writer writes to its file.
+type WriteLimiter interface {
+	// Wait blocks until n bytes may be written. It's called by the Writer
+	// before each block is written, from the goroutine writing the block.
+	Wait(n int)
+}
+
 // SuffixReplaceableTableCollector is an extension to the TablePropertyCollector
 // interface that allows a table property collector to indicate that it supports
 // being *updated* during suffix replacement, i.e. when an existing SST in which
@@ -117,6 +124,23 @@ type ReaderOptions struct {
 	// written with {Batch,DB}.Merge. The MergerName is checked for consistency
 	// with the value stored in the sstable when it was written.
 	MergerName string
+
+	// VerifyChecksumsOnOpen, if true, causes NewReader to read every block of
+	// the sstable and verify its checksum, failing with an error identifying
+	// the first corrupt block. Otherwise corruption is only detected when a
+	// corrupt block is read.
+	VerifyChecksumsOnOpen bool
+
+	// TopLevelIndexCacheSize bounds the size in bytes of a two-level table's
+	// uncompressed top-level index block that the Reader decodes once when it
+	// is opened and retains in memory it owns, outside of the block cache.
+	// Iterators created on such a table are then positioned over the retained
+	// index rather than looking it up in the block cache and parsing it each
+	// time. The retained index is released when the Reader is closed. Tables
+	// whose top-level index exceeds this size are unaffected.
+	//
+	// The default value of 0 disables the retention of top-level indexes.
+	TopLevelIndexCacheSize int
 }
 
 func (o ReaderOptions) ensureDefaults() ReaderOptions {
@@ -129,6 +153,33 @@ func (o ReaderOptions) ensureDefaults() ReaderOptions {
 	return o
 }
 
+// IterOptions holds the parameters of an iterator created by
+// Reader.NewIterWithOptions.
+type IterOptions struct {
+	// Lower and Upper bound the keys of the table surfaced by the iterator. A
+	// nil bound is unbounded.
+	Lower, Upper []byte
+
+	// Filterer, if non-nil, is used to skip the blocks of the table whose
+	// block properties don't intersect the filterer's block property filters.
+	Filterer *BlockPropertiesFilterer
+
+	// UseFilterBlock permits the table's filter block to be consulted by
+	// SeekPrefixGE.
+	UseFilterBlock bool
+
+	// BypassCache, if true, causes data blocks that are not already in the
+	// block cache to be read and decompressed into memory owned by the
+	// iterator rather than added to the cache, so that a large scan does not
+	// evict the working set of other readers.
+	BypassCache bool
+
+	// SkipCorruptBlocks, if true, causes data blocks that fail checksum
+	// verification or are otherwise corrupt to be skipped, and counted in the
+	// iterator's stats, rather than surfacing an error.
+	SkipCorruptBlocks bool
+}
+
 // WriterOptions holds the parameters used to control building an sstable.
 type WriterOptions struct {
 	// BlockRestartInterval is the number of keys between restart points
@@ -166,9 +217,28 @@ type WriterOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// ZstdDictionary is an optional, pre-trained zstd dictionary. If non-empty
+	// and Compression is ZstdCompression, the table's blocks are compressed
+	// using the dictionary, which is stored within the table so that readers
+	// may decompress its blocks. Dictionary compression is most effective when
+	// values are small and repetitive across blocks. Tables written with a
+	// dictionary require a table format of TableFormatPebblev1 or later, and
+	// cannot be read by versions of Pebble that predate dictionary support.
+	//
+	// With cgo, the dictionary may be a zstd-format dictionary or raw content.
+	// Without cgo, only zstd-format dictionaries are supported.
+	//
+	// The dictionary must not be modified while a Writer is using it.
+	ZstdDictionary []byte
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
+	// If the Comparer provides a Split function, the filter is built over the
+	// prefixes of keys rather than the full keys, and SeekPrefixGE probes the
+	// filter with the seek prefix. A table containing a prefix under any
+	// suffix passes the filter for every seek key with that prefix.
+	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
 	// package.
 	//
@@ -198,6 +268,12 @@ type WriterOptions struct {
 	// with the value stored in the sstable when it was written.
 	MergerName string
 
+	// RangeKeyMerger defines the associative merge operation to use for
+	// merging the values of overlapping RangeKeySets with the same suffix that
+	// are added to the sstable through Writer.RangeKeySet. If nil, the newer of
+	// two overlapping RangeKeySets with the same suffix shadows the older one.
+	RangeKeyMerger *RangeKeyMerger
+
 	// TableFormat specifies the format version for writing sstables. The default
 	// is TableFormatRocksDBv2 which creates RocksDB compatible sstables. Use
 	// TableFormatLevelDB to create LevelDB compatible sstable which can be used
@@ -221,6 +297,16 @@ type WriterOptions struct {
 	// compress data blocks and write datablocks to disk in parallel with the
 	// Writer client goroutine.
 	Parallelism bool
+
+	// WriteLimiter, if set, limits the rate at which the Writer writes blocks
+	// to its file.
+	WriteLimiter WriteLimiter
+
+	// ValidateComparer enables checking that the keys returned by the
+	// Comparer's Separator and Successor functions for the index block
+	// satisfy their contracts. A violation fails the Writer with an error,
+	// rather than producing an sstable with an index that misdirects reads.
+	ValidateComparer bool
 }
 
 func (o WriterOptions) ensureDefaults() WriterOptions {
@@ -0,0 +1,42 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+// SplitDecision is returned by an OutputSplitter to indicate whether the
+// current output sstable should be finished before a key.
+type SplitDecision int
+
+const (
+	// NoSplit indicates that the key may be added to the current output.
+	NoSplit SplitDecision = iota
+	// SplitNow indicates that the current output should be finished, and the
+	// key added to a new output.
+	SplitNow
+)
+
+// String implements the fmt.Stringer interface.
+func (d SplitDecision) String() string {
+	if d == NoSplit {
+		return "no-split"
+	}
+	return "split-now"
+}
+
+// OutputSplitter may be used by flushes and compactions to switch to a new
+// output sstable in addition to their own policies (eg, output file sizes),
+// for instance to keep the interval collected by a block property collector
+// narrow within each output sstable.
+type OutputSplitter interface {
+	// ShouldSplitBefore is called with each key before it's added to the
+	// output, along with the Writer for the current output. The Writer is nil
+	// if no key has been added to the current output yet, in which case the
+	// key begins a new output and any per-output state should be reset.
+	//
+	// Splits are only performed between user keys, so a key may be added to
+	// the current output despite a SplitNow decision. Once ShouldSplitBefore
+	// returns SplitNow, it must continue to do so until it's called with a
+	// nil Writer.
+	ShouldSplitBefore(key InternalKey, tw *Writer) SplitDecision
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hllPrecision is the number of bits of a hash selecting the register of a
+// hyperLogLog. The standard error of an estimate is 1.04/sqrt(2^hllPrecision),
+// about 0.8%.
+const hllPrecision = 14
+
+// hyperLogLog is a HyperLogLog sketch estimating the number of distinct
+// values added to it.
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func (h *hyperLogLog) add(v []byte) {
+	x := xxhash.Sum64(v)
+	i := x >> (64 - hllPrecision)
+	// The rank of the remaining bits is the position of their leftmost one
+	// bit. The sentinel bit bounds the rank when they're all zero.
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	if rank := uint8(bits.LeadingZeros64(w)) + 1; rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// estimate returns the estimated number of distinct values added.
+func (h *hyperLogLog) estimate() uint64 {
+	const m = float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Small cardinalities are more accurately estimated by linear
+		// counting of the empty registers.
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// PrefixCardinalityCollector is a TablePropertyCollector estimating the
+// number of distinct prefixes of the point keys in a table, as determined by
+// a Split function, using a HyperLogLog sketch. The estimate is recorded in
+// the table's Properties.NumDistinctPrefixes.
+type PrefixCardinalityCollector struct {
+	split Split
+	// prefix is the prefix of the last key added. Keys are usually added in
+	// order, so consecutive keys with the same prefix aren't hashed.
+	prefix    []byte
+	hasPrefix bool
+	hll       hyperLogLog
+}
+
+var _ TablePropertyCollector = (*PrefixCardinalityCollector)(nil)
+
+// NewPrefixCardinalityCollector returns a PrefixCardinalityCollector for the
+// prefixes determined by split. It may be used as the constructor of a table
+// property collector as follows:
+//
+//	func() TablePropertyCollector {
+//		return NewPrefixCardinalityCollector(comparer.Split)
+//	}
+func NewPrefixCardinalityCollector(split Split) *PrefixCardinalityCollector {
+	return &PrefixCardinalityCollector{split: split}
+}
+
+// Add implements the TablePropertyCollector interface.
+func (c *PrefixCardinalityCollector) Add(key InternalKey, value []byte) error {
+	if key.Kind() == InternalKeyKindRangeDelete {
+		return nil
+	}
+	prefix := key.UserKey[:c.split(key.UserKey)]
+	if c.hasPrefix && bytes.Equal(prefix, c.prefix) {
+		return nil
+	}
+	c.prefix = append(c.prefix[:0], prefix...)
+	c.hasPrefix = true
+	c.hll.add(prefix)
+	return nil
+}
+
+// Finish implements the TablePropertyCollector interface.
+func (c *PrefixCardinalityCollector) Finish(userProps map[string]string) error {
+	n := c.hll.estimate()
+	if n == 0 {
+		return nil
+	}
+	var buf [binary.MaxVarintLen64]byte
+	userProps[propNumDistinctPrefixesName] = string(buf[:binary.PutUvarint(buf[:], n)])
+	return nil
+}
+
+// Name implements the TablePropertyCollector interface.
+func (c *PrefixCardinalityCollector) Name() string {
+	return "pebble.prefix-cardinality"
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixCardinalityCollector(t *testing.T) {
+	// The standard error of a HyperLogLog estimate. Estimates are checked to
+	// lie within three standard errors of the cardinality.
+	stdErr := 1.04 / math.Sqrt(1<<hllPrecision)
+
+	write := func(numPrefixes, keysPerPrefix int, collect bool) *Properties {
+		opts := WriterOptions{
+			Comparer:    testkeys.Comparer,
+			TableFormat: TableFormatPebblev2,
+		}
+		if collect {
+			opts.TablePropertyCollectors = []func() TablePropertyCollector{
+				func() TablePropertyCollector {
+					return NewPrefixCardinalityCollector(testkeys.Comparer.Split)
+				},
+			}
+		}
+		f := &memFile{}
+		w := NewWriter(f, opts)
+		for i := 0; i < numPrefixes; i++ {
+			for j := keysPerPrefix; j > 0; j-- {
+				k := []byte(fmt.Sprintf("prefix%07d@%d", i, j))
+				require.NoError(t, w.Set(k, nil))
+			}
+		}
+		require.NoError(t, w.Close())
+		r, err := NewMemReader(f.Bytes(), ReaderOptions{Comparer: testkeys.Comparer})
+		require.NoError(t, err)
+		defer r.Close()
+		props := r.Properties
+		return &props
+	}
+
+	for _, numPrefixes := range []int{1, 10, 1000, 100000} {
+		t.Run(fmt.Sprint(numPrefixes), func(t *testing.T) {
+			props := write(numPrefixes, 3, true)
+			require.InDelta(t, numPrefixes, props.NumDistinctPrefixes, 3*stdErr*float64(numPrefixes)+1)
+			require.Contains(t, props.PropertyCollectorNames, "pebble.prefix-cardinality")
+			_, ok := props.UserProperties[propNumDistinctPrefixesName]
+			require.False(t, ok)
+		})
+	}
+
+	// Without the collector, the property isn't written.
+	require.Zero(t, write(10, 3, false).NumDistinctPrefixes)
+}
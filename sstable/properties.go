@@ -18,6 +18,7 @@ import (
 
 const propertiesBlockRestartInterval = math.MaxInt32
 const propGlobalSeqnumName = "rocksdb.external_sst_file.global_seqno"
+const propNumDistinctPrefixesName = "pebble.num.distinct-prefixes"
 
 var propTagMap = make(map[string]reflect.StructField)
 var propBoolTrue = []byte{'1'}
@@ -108,6 +109,10 @@ type Properties struct {
 	// The number of deletion entries in this table, including both point and
 	// range deletions.
 	NumDeletions uint64 `prop:"rocksdb.deleted.keys"`
+	// An estimate of the number of distinct prefixes, as determined by
+	// Comparer.Split, of the point keys in this table. Zero unless the table
+	// was written with a PrefixCardinalityCollector.
+	NumDistinctPrefixes uint64 `prop:"pebble.num.distinct-prefixes"`
 	// The number of entries in this table.
 	NumEntries uint64 `prop:"rocksdb.num.entries"`
 	// The number of merge operands in the table.
@@ -120,6 +125,9 @@ type Properties struct {
 	NumRangeKeySets uint64 `prop:"pebble.num.range-key-sets"`
 	// The number of RANGEKEYUNSETs in this table.
 	NumRangeKeyUnsets uint64 `prop:"pebble.num.range-key-unsets"`
+	// The number of SINGLEDELs in this table. These are not included in
+	// NumDeletions.
+	NumSingleDeletions uint64 `prop:"pebble.num.single-deletions"`
 	// Timestamp of the earliest key. 0 if unknown.
 	OldestKeyTime uint64 `prop:"rocksdb.oldest.key.time"`
 	// The name of the prefix extractor used in this table. Empty if no prefix
@@ -329,6 +337,9 @@ func (p *Properties) save(w *rawBlockWriter) {
 		p.saveString(m, unsafe.Offsetof(p.MergerName), p.MergerName)
 	}
 	p.saveUvarint(m, unsafe.Offsetof(p.NumDataBlocks), p.NumDataBlocks)
+	if p.NumDistinctPrefixes > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.NumDistinctPrefixes), p.NumDistinctPrefixes)
+	}
 	p.saveUvarint(m, unsafe.Offsetof(p.NumEntries), p.NumEntries)
 	p.saveUvarint(m, unsafe.Offsetof(p.NumDeletions), p.NumDeletions)
 	p.saveUvarint(m, unsafe.Offsetof(p.NumMergeOperands), p.NumMergeOperands)
@@ -340,6 +351,9 @@ func (p *Properties) save(w *rawBlockWriter) {
 		p.saveUvarint(m, unsafe.Offsetof(p.RawRangeKeyKeySize), p.RawRangeKeyKeySize)
 		p.saveUvarint(m, unsafe.Offsetof(p.RawRangeKeyValueSize), p.RawRangeKeyValueSize)
 	}
+	if p.NumSingleDeletions > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.NumSingleDeletions), p.NumSingleDeletions)
+	}
 	p.saveUvarint(m, unsafe.Offsetof(p.OldestKeyTime), p.OldestKeyTime)
 	if p.PrefixExtractorName != "" {
 		p.saveString(m, unsafe.Offsetof(p.PrefixExtractorName), p.PrefixExtractorName)
@@ -82,12 +82,14 @@ func TestPropertiesSave(t *testing.T) {
 		MergerName:               "merge operator name",
 		NumDataBlocks:            14,
 		NumDeletions:             15,
+		NumDistinctPrefixes:      27,
 		NumEntries:               16,
 		NumMergeOperands:         17,
 		NumRangeDeletions:        18,
 		NumRangeKeyDels:          19,
 		NumRangeKeySets:          20,
 		NumRangeKeyUnsets:        21,
+		NumSingleDeletions:       26,
 		OldestKeyTime:            22,
 		PrefixExtractorName:      "prefix extractor name",
 		PrefixFiltering:          true,
@@ -12,6 +12,8 @@ import (
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
@@ -223,6 +225,16 @@ type singleLevelIterator struct {
 	// is high).
 	useFilter              bool
 	lastBloomFilterMatched bool
+
+	// bypassCache specifies whether data blocks read by this iterator should
+	// be kept out of the block cache. Data blocks already present in the cache
+	// are still used. Index and filter blocks are always cached.
+	bypassCache bool
+
+	// skipCorruptBlocks specifies whether data blocks (and second-level index
+	// blocks) that fail to load due to corruption should be skipped, as if
+	// excluded by a block-property filter, rather than failing iteration.
+	skipCorruptBlocks bool
 }
 
 // singleLevelIterator implements the base.InternalIterator interface.
@@ -274,7 +286,10 @@ func checkTwoLevelIterator(obj interface{}) {
 // synonmous with Reader.NewIter, but allows for reusing of the iterator
 // between different Readers.
 func (i *singleLevelIterator) init(
-	r *Reader, lower, upper []byte, filterer *BlockPropertiesFilterer, useFilter bool,
+	r *Reader,
+	lower, upper []byte,
+	filterer *BlockPropertiesFilterer,
+	useFilter, bypassCache, skipCorruptBlocks bool,
 ) error {
 	if r.err != nil {
 		return r.err
@@ -288,6 +303,8 @@ func (i *singleLevelIterator) init(
 	i.upper = upper
 	i.bpfs = filterer
 	i.useFilter = useFilter
+	i.bypassCache = bypassCache
+	i.skipCorruptBlocks = skipCorruptBlocks
 	i.reader = r
 	i.cmp = r.Compare
 	err = i.index.initHandle(i.cmp, indexH, r.Properties.GlobalSeqNum)
@@ -398,8 +415,11 @@ func (i *singleLevelIterator) loadBlock(dir int8) loadBlockResult {
 		}
 		// blockIntersects
 	}
-	block, err := i.readBlockWithStats(i.dataBH, &i.dataRS)
+	block, err := i.readBlockWithStats(i.dataBH, &i.dataRS, i.bypassCache, cache.BlockTypeData)
 	if err != nil {
+		if i.maybeSkipCorruptBlock(err) {
+			return loadBlockIrrelevant
+		}
 		i.err = err
 		return loadBlockFailed
 	}
@@ -407,6 +427,10 @@ func (i *singleLevelIterator) loadBlock(dir int8) loadBlockResult {
 	if i.err != nil {
 		// The block is partially loaded, and we don't want it to appear valid.
 		i.data.invalidate()
+		if i.maybeSkipCorruptBlock(i.err) {
+			i.err = nil
+			return loadBlockIrrelevant
+		}
 		return loadBlockFailed
 	}
 	i.initBounds()
@@ -480,15 +504,32 @@ func (i *singleLevelIterator) resolveMaybeExcluded(dir int8) intersectsResult {
 	return blockIntersects
 }
 
+// maybeSkipCorruptBlock returns true if the iterator is configured to skip
+// corrupt blocks and err indicates corruption, in which case the caller should
+// treat the block as though it were excluded by a block-property filter.
+func (i *singleLevelIterator) maybeSkipCorruptBlock(err error) bool {
+	if !i.skipCorruptBlocks || !errors.Is(err, base.ErrCorruption) {
+		return false
+	}
+	i.stats.SkippedCorruptBlocks++
+	// The block's keys are skipped like those of a filtered block.
+	i.maybeFilteredKeysSingleLevel = true
+	return true
+}
+
 func (i *singleLevelIterator) readBlockWithStats(
-	bh BlockHandle, raState *readaheadState,
+	bh BlockHandle, raState *readaheadState, bypassCache bool, kind cache.BlockType,
 ) (cache.Handle, error) {
-	block, cacheHit, err := i.reader.readBlock(bh, nil /* transform */, raState)
+	start := time.Now()
+	block, cacheHit, err := i.reader.readBlockInternal(bh, nil /* transform */, raState, bypassCache, kind)
 	if err == nil {
 		n := bh.Length
 		i.stats.BlockBytes += n
+		i.stats.BlockCount++
 		if cacheHit {
 			i.stats.BlockBytesInCache += n
+		} else {
+			i.stats.BlockReadDuration += time.Since(start)
 		}
 	}
 	return block, err
@@ -1358,8 +1399,13 @@ func (i *twoLevelIterator) loadIndex(dir int8) loadBlockResult {
 		}
 		// blockIntersects
 	}
-	indexBlock, err := i.readBlockWithStats(bhp.BlockHandle, nil /* readaheadState */)
+	indexBlock, err := i.readBlockWithStats(
+		bhp.BlockHandle, nil /* readaheadState */, false /* bypassCache */, cache.BlockTypeIndex)
 	if err != nil {
+		if i.maybeSkipCorruptBlock(err) {
+			i.maybeFilteredKeysTwoLevel = true
+			return loadBlockIrrelevant
+		}
 		i.err = err
 		return loadBlockFailed
 	}
@@ -1434,23 +1480,35 @@ func (i *twoLevelIterator) resolveMaybeExcluded(dir int8) intersectsResult {
 }
 
 func (i *twoLevelIterator) init(
-	r *Reader, lower, upper []byte, filterer *BlockPropertiesFilterer, useFilter bool,
+	r *Reader,
+	lower, upper []byte,
+	filterer *BlockPropertiesFilterer,
+	useFilter, bypassCache, skipCorruptBlocks bool,
 ) error {
 	if r.err != nil {
 		return r.err
 	}
-	topLevelIndexH, err := r.readIndex()
-	if err != nil {
-		return err
+	var topLevelIndexH cache.Handle
+	if r.topLevelIndex == nil {
+		var err error
+		if topLevelIndexH, err = r.readIndex(); err != nil {
+			return err
+		}
 	}
 
 	i.lower = lower
 	i.upper = upper
 	i.bpfs = filterer
 	i.useFilter = useFilter
+	i.bypassCache = bypassCache
+	i.skipCorruptBlocks = skipCorruptBlocks
 	i.reader = r
 	i.cmp = r.Compare
-	err = i.topLevelIndex.initHandle(i.cmp, topLevelIndexH, r.Properties.GlobalSeqNum)
+	if r.topLevelIndex != nil {
+		i.topLevelIndex.initDecoded(i.cmp, r.topLevelIndex, r.Properties.GlobalSeqNum)
+		return nil
+	}
+	err := i.topLevelIndex.initHandle(i.cmp, topLevelIndexH, r.Properties.GlobalSeqNum)
 	if err != nil {
 		// blockIter.Close releases topLevelIndexH and always returns a nil error
 		_ = i.topLevelIndex.Close()
@@ -2358,6 +2416,7 @@ type Reader struct {
 	filterBH          BlockHandle
 	rangeDelBH        BlockHandle
 	rangeKeyBH        BlockHandle
+	compressionDictBH BlockHandle
 	rangeDelTransform blockTransform
 	propertiesBH      BlockHandle
 	metaIndexBH       BlockHandle
@@ -2370,12 +2429,23 @@ type Reader struct {
 	checksumType      ChecksumType
 	tableFilter       *tableFilterReader
 	tableFormat       TableFormat
-	Properties        Properties
+	// zstdDict holds the zstd dictionary used to compress the table's blocks,
+	// if the table was written with one.
+	zstdDict   []byte
+	Properties Properties
+	// pinnedIndexAndFilter is atomically set to 1 once the index and filter
+	// blocks have been pinned in the block cache by PinIndexAndFilter.
+	pinnedIndexAndFilter uint32
+	// topLevelIndex, if non-nil, holds a reader-owned decoded copy of a
+	// two-level table's top-level index block. It is populated when the
+	// Reader is opened, if ReaderOptions.TopLevelIndexCacheSize permits.
+	topLevelIndex *decodedBlock
 }
 
 // Close implements DB.Close, as documented in the pebble package.
 func (r *Reader) Close() error {
 	r.opts.Cache.Unref()
+	r.topLevelIndex = nil
 
 	if r.err != nil {
 		if r.file != nil {
@@ -2402,12 +2472,24 @@ func (r *Reader) Close() error {
 func (r *Reader) NewIterWithBlockPropertyFilters(
 	lower, upper []byte, filterer *BlockPropertiesFilterer, useFilterBlock bool,
 ) (Iterator, error) {
+	return r.NewIterWithOptions(IterOptions{
+		Lower:          lower,
+		Upper:          upper,
+		Filterer:       filterer,
+		UseFilterBlock: useFilterBlock,
+	})
+}
+
+// NewIterWithOptions returns an iterator for the contents of the table,
+// configured by the provided IterOptions. If an error occurs,
+// NewIterWithOptions cleans up after itself and returns a nil iterator.
+func (r *Reader) NewIterWithOptions(o IterOptions) (Iterator, error) {
 	// NB: pebble.tableCache wraps the returned iterator with one which performs
 	// reference counting on the Reader, preventing the Reader from being closed
 	// until the final iterator closes.
 	if r.Properties.IndexType == twoLevelIndex {
 		i := twoLevelIterPool.Get().(*twoLevelIterator)
-		err := i.init(r, lower, upper, filterer, useFilterBlock)
+		err := i.init(r, o.Lower, o.Upper, o.Filterer, o.UseFilterBlock, o.BypassCache, o.SkipCorruptBlocks)
 		if err != nil {
 			return nil, err
 		}
@@ -2415,7 +2497,7 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 	}
 
 	i := singleLevelIterPool.Get().(*singleLevelIterator)
-	err := i.init(r, lower, upper, filterer, useFilterBlock)
+	err := i.init(r, o.Lower, o.Upper, o.Filterer, o.UseFilterBlock, o.BypassCache, o.SkipCorruptBlocks)
 	if err != nil {
 		return nil, err
 	}
@@ -2434,7 +2516,7 @@ func (r *Reader) NewIter(lower, upper []byte) (Iterator, error) {
 func (r *Reader) NewCompactionIter(bytesIterated *uint64) (Iterator, error) {
 	if r.Properties.IndexType == twoLevelIndex {
 		i := twoLevelIterPool.Get().(*twoLevelIterator)
-		err := i.init(r, nil /* lower */, nil /* upper */, nil, false /* useFilter */)
+		err := i.init(r, nil /* lower */, nil /* upper */, nil, false /* useFilter */, false /* bypassCache */, false /* skipCorruptBlocks */)
 		if err != nil {
 			return nil, err
 		}
@@ -2445,7 +2527,7 @@ func (r *Reader) NewCompactionIter(bytesIterated *uint64) (Iterator, error) {
 		}, nil
 	}
 	i := singleLevelIterPool.Get().(*singleLevelIterator)
-	err := i.init(r, nil /* lower */, nil /* upper */, nil, false /* useFilter */)
+	err := i.init(r, nil /* lower */, nil /* upper */, nil, false /* useFilter */, false /* bypassCache */, false /* skipCorruptBlocks */)
 	if err != nil {
 		return nil, err
 	}
@@ -2456,6 +2538,56 @@ func (r *Reader) NewCompactionIter(bytesIterated *uint64) (Iterator, error) {
 	}, nil
 }
 
+// NewBlockIter returns an iterator over the records of the data block with the
+// provided handle, such as one of the handles of Layout.Data, without
+// traversing the table's index. The block's checksum is verified, and the
+// block decompressed, when it's read. Unlike the iterators returned by
+// NewIter, the iterator does not support bounds.
+func (r *Reader) NewBlockIter(h BlockHandle) (Iterator, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	block, _, err := r.readBlock(h, nil /* transform */, nil /* raState */, cache.BlockTypeData)
+	if err != nil {
+		return nil, err
+	}
+	i := &dataBlockIter{}
+	if err := i.initHandle(r.Compare, block, r.Properties.GlobalSeqNum); err != nil {
+		_ = i.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+// dataBlockIter is a blockIter over a single data block, returned by
+// Reader.NewBlockIter.
+type dataBlockIter struct {
+	blockIter
+	closeHook func(i Iterator) error
+}
+
+var _ Iterator = (*dataBlockIter)(nil)
+
+// MaybeFilteredKeys implements the Iterator interface. A dataBlockIter never
+// filters keys.
+func (i *dataBlockIter) MaybeFilteredKeys() bool {
+	return false
+}
+
+// SetCloseHook implements the Iterator interface.
+func (i *dataBlockIter) SetCloseHook(fn func(i Iterator) error) {
+	i.closeHook = fn
+}
+
+// Close implements the Iterator interface.
+func (i *dataBlockIter) Close() error {
+	var err error
+	if i.closeHook != nil {
+		err = i.closeHook(i)
+	}
+	return firstError(err, i.blockIter.Close())
+}
+
 // NewRawRangeDelIter returns an internal iterator for the contents of the
 // range-del block for the table. Returns nil if the table does not contain
 // any range deletions.
@@ -2474,6 +2606,73 @@ func (r *Reader) NewRawRangeDelIter() (keyspan.FragmentIterator, error) {
 	return i, nil
 }
 
+// RawRangeDelIters is a batch of iterators over the contents of a table's
+// range-del block, obtained through Reader.NewRawRangeDelIters. The batch and
+// its iterators are pooled, and must be returned through Release.
+type RawRangeDelIters struct {
+	// Iters holds the batch's iterators. The iterators must not be closed
+	// individually; closing the iterators is the responsibility of Release.
+	Iters []keyspan.FragmentIterator
+	iters []fragmentBlockIter
+}
+
+var rawRangeDelItersPool = sync.Pool{
+	New: func() interface{} {
+		return &RawRangeDelIters{}
+	},
+}
+
+// NewRawRangeDelIters returns a batch of n independent iterators over the
+// contents of the range-del block for the table, allocated from a pool to
+// avoid per-iterator allocations when many are needed, such as during a
+// compaction with many input tables. Returns nil if the table does not
+// contain any range deletions.
+//
+// The iterators remain valid until the batch is released, and must not
+// outlive the Reader. Each iterator may be used on a separate goroutine,
+// but an individual iterator must not be used concurrently.
+func (r *Reader) NewRawRangeDelIters(n int) (*RawRangeDelIters, error) {
+	if r.rangeDelBH.Length == 0 || n <= 0 {
+		return nil, nil
+	}
+	b := rawRangeDelItersPool.Get().(*RawRangeDelIters)
+	if cap(b.iters) < n {
+		b.iters = make([]fragmentBlockIter, n)
+		b.Iters = make([]keyspan.FragmentIterator, n)
+	}
+	b.iters = b.iters[:n]
+	b.Iters = b.Iters[:n]
+	for j := range b.iters {
+		h, err := r.readRangeDel()
+		if err == nil {
+			err = b.iters[j].blockIter.initHandle(r.Compare, h, r.Properties.GlobalSeqNum)
+		}
+		if err != nil {
+			b.iters = b.iters[:j]
+			b.Iters = b.Iters[:j]
+			_ = b.Release()
+			return nil, err
+		}
+		b.Iters[j] = &b.iters[j]
+	}
+	return b, nil
+}
+
+// Release closes the batch's iterators and returns the batch to the pool.
+// Neither the batch nor any of its iterators may be used after Release.
+func (b *RawRangeDelIters) Release() error {
+	var err error
+	for j := range b.iters {
+		err = firstError(err, b.iters[j].Close())
+		b.iters[j] = fragmentBlockIter{blockIter: b.iters[j].blockIter.resetForReuse()}
+		b.Iters[j] = nil
+	}
+	b.iters = b.iters[:0]
+	b.Iters = b.Iters[:0]
+	rawRangeDelItersPool.Put(b)
+	return err
+}
+
 // NewRawRangeKeyIter returns an internal iterator for the contents of the
 // range-key block for the table. Returns nil if the table does not contain any
 // range keys.
@@ -2492,27 +2691,88 @@ func (r *Reader) NewRawRangeKeyIter() (keyspan.FragmentIterator, error) {
 	return i, nil
 }
 
+// PinIndexAndFilter reads the table's top-level index block and its filter
+// block, if any, into the block cache and pins them there, exempting them from
+// eviction until the table's blocks are evicted with Cache.EvictFile. Calls
+// after the blocks have been pinned are no-ops.
+func (r *Reader) PinIndexAndFilter() error {
+	if atomic.LoadUint32(&r.pinnedIndexAndFilter) == 1 {
+		return nil
+	}
+	blocks := [...]struct {
+		bh   BlockHandle
+		kind cache.BlockType
+	}{
+		{r.indexBH, cache.BlockTypeIndex},
+		{r.filterBH, cache.BlockTypeFilter},
+	}
+	for _, b := range blocks {
+		if b.bh.Length == 0 {
+			continue
+		}
+		h, _, err := r.readBlock(b.bh, nil /* transform */, nil /* readaheadState */, b.kind)
+		if err != nil {
+			return err
+		}
+		// Pinning fails only if the block was evicted since it was read, or if
+		// it is too large to be cached at all. Leave pinnedIndexAndFilter
+		// unset so that a later call retries.
+		pinned := r.opts.Cache.Pin(r.cacheID, r.fileNum, b.bh.Offset)
+		h.Release()
+		if !pinned {
+			return nil
+		}
+	}
+	atomic.StoreUint32(&r.pinnedIndexAndFilter, 1)
+	return nil
+}
+
 func (r *Reader) readIndex() (cache.Handle, error) {
 	h, _, err :=
-		r.readBlock(r.indexBH, nil /* transform */, nil /* readaheadState */)
+		r.readBlock(r.indexBH, nil /* transform */, nil /* readaheadState */, cache.BlockTypeIndex)
 	return h, err
 }
 
+// maybeDecodeTopLevelIndex reads and decodes the top-level index block of a
+// two-level table into memory owned by the Reader, if the uncompressed block
+// fits within ReaderOptions.TopLevelIndexCacheSize.
+func (r *Reader) maybeDecodeTopLevelIndex() error {
+	limit := r.opts.TopLevelIndexCacheSize
+	if limit <= 0 || r.Properties.IndexPartitions == 0 || r.indexBH.Length > uint64(limit) {
+		return nil
+	}
+	h, err := r.readIndex()
+	if err != nil {
+		return err
+	}
+	defer h.Release()
+	if len(h.Get()) > limit {
+		return nil
+	}
+	data := append(block(nil), h.Get()...)
+	d, err := decodeBlock(r.Compare, data)
+	if err != nil {
+		return err
+	}
+	r.topLevelIndex = d
+	return nil
+}
+
 func (r *Reader) readFilter() (cache.Handle, error) {
 	h, _, err :=
-		r.readBlock(r.filterBH, nil /* transform */, nil /* readaheadState */)
+		r.readBlock(r.filterBH, nil /* transform */, nil /* readaheadState */, cache.BlockTypeFilter)
 	return h, err
 }
 
 func (r *Reader) readRangeDel() (cache.Handle, error) {
 	h, _, err :=
-		r.readBlock(r.rangeDelBH, r.rangeDelTransform, nil /* readaheadState */)
+		r.readBlock(r.rangeDelBH, r.rangeDelTransform, nil /* readaheadState */, cache.BlockTypeRangeDel)
 	return h, err
 }
 
 func (r *Reader) readRangeKey() (cache.Handle, error) {
 	h, _, err :=
-		r.readBlock(r.rangeKeyBH, nil /* transform */, nil /* readaheadState */)
+		r.readBlock(r.rangeKeyBH, nil /* transform */, nil /* readaheadState */, cache.BlockTypeRangeKey)
 	return h, err
 }
 
@@ -2540,9 +2800,23 @@ func checkChecksum(
 
 // readBlock reads and decompresses a block from disk into memory.
 func (r *Reader) readBlock(
-	bh BlockHandle, transform blockTransform, raState *readaheadState,
+	bh BlockHandle, transform blockTransform, raState *readaheadState, kind cache.BlockType,
+) (_ cache.Handle, cacheHit bool, _ error) {
+	return r.readBlockInternal(bh, transform, raState, false /* bypassCache */, kind)
+}
+
+// readBlockInternal is like readBlock, but if bypassCache is true a block not
+// already present in the cache is returned through an uncached handle rather
+// than being added to the cache. The cache lookup is attributed to the
+// provided kind of block.
+func (r *Reader) readBlockInternal(
+	bh BlockHandle,
+	transform blockTransform,
+	raState *readaheadState,
+	bypassCache bool,
+	kind cache.BlockType,
 ) (_ cache.Handle, cacheHit bool, _ error) {
-	if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
+	if h := r.opts.Cache.GetBlock(r.cacheID, r.fileNum, bh.Offset, kind); h.Get() != nil {
 		if raState != nil {
 			raState.recordCacheHit(int64(bh.Offset), int64(bh.Length+blockTrailerLen))
 		}
@@ -2603,7 +2877,7 @@ func (r *Reader) readBlock(
 	b = b[:bh.Length]
 	v.Truncate(len(b))
 
-	decoded, err := decompressBlock(r.opts.Cache, typ, b)
+	decoded, err := decompressBlock(r.opts.Cache, typ, b, r.zstdDict)
 	if decoded != nil {
 		r.opts.Cache.Free(v)
 		v = decoded
@@ -2628,6 +2902,9 @@ func (r *Reader) readBlock(
 		v = newV
 	}
 
+	if bypassCache {
+		return r.opts.Cache.NewUncachedHandle(v), false, nil
+	}
 	h := r.opts.Cache.Set(r.cacheID, r.fileNum, bh.Offset, v)
 	return h, false, nil
 }
@@ -2676,7 +2953,7 @@ func (r *Reader) transformRangeDelV1(b []byte) ([]byte, error) {
 }
 
 func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
-	b, _, err := r.readBlock(metaindexBH, nil /* transform */, nil /* readaheadState */)
+	b, _, err := r.readBlock(metaindexBH, nil /* transform */, nil /* readaheadState */, cache.BlockTypeMeta)
 	if err != nil {
 		return err
 	}
@@ -2706,7 +2983,7 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 	}
 
 	if bh, ok := meta[metaPropertiesName]; ok {
-		b, _, err = r.readBlock(bh, nil /* transform */, nil /* readaheadState */)
+		b, _, err = r.readBlock(bh, nil /* transform */, nil /* readaheadState */, cache.BlockTypeProperties)
 		if err != nil {
 			return err
 		}
@@ -2731,6 +3008,21 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		r.rangeKeyBH = bh
 	}
 
+	if bh, ok := meta[metaCompressionDictName]; ok {
+		b, _, err = r.readBlock(bh, nil /* transform */, nil /* readaheadState */, cache.BlockTypeMeta)
+		if err != nil {
+			return err
+		}
+		r.compressionDictBH = bh
+		// The dictionary is copied out of the block cache, as it must remain
+		// available for the lifetime of the Reader.
+		r.zstdDict = append([]byte(nil), b.Get()...)
+		b.Release()
+		if len(r.zstdDict) == 0 {
+			return base.CorruptionErrorf("pebble/table: invalid table (empty compression dictionary)")
+		}
+	}
+
 	for name, fp := range r.opts.Filters {
 		types := []struct {
 			ftype  FilterType
@@ -2768,13 +3060,14 @@ func (r *Reader) Layout() (*Layout, error) {
 	}
 
 	l := &Layout{
-		Data:       make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
-		Filter:     r.filterBH,
-		RangeDel:   r.rangeDelBH,
-		RangeKey:   r.rangeKeyBH,
-		Properties: r.propertiesBH,
-		MetaIndex:  r.metaIndexBH,
-		Footer:     r.footerBH,
+		Data:            make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
+		Filter:          r.filterBH,
+		RangeDel:        r.rangeDelBH,
+		RangeKey:        r.rangeKeyBH,
+		CompressionDict: r.compressionDictBH,
+		Properties:      r.propertiesBH,
+		MetaIndex:       r.metaIndexBH,
+		Footer:          r.footerBH,
 	}
 
 	indexH, err := r.readIndex()
@@ -2815,7 +3108,7 @@ func (r *Reader) Layout() (*Layout, error) {
 			l.Index = append(l.Index, indexBH.BlockHandle)
 
 			subIndex, _, err := r.readBlock(
-				indexBH.BlockHandle, nil /* transform */, nil /* readaheadState */)
+				indexBH.BlockHandle, nil /* transform */, nil /* readaheadState */, cache.BlockTypeIndex)
 			if err != nil {
 				return nil, err
 			}
@@ -2860,7 +3153,7 @@ func (r *Reader) ValidateBlockChecksums() error {
 		blocks[i] = l.Data[i].BlockHandle
 	}
 	blocks = append(blocks, l.Index...)
-	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.CompressionDict, l.Properties, l.MetaIndex)
 
 	// Sorting by offset ensures we are performing a sequential scan of the
 	// file.
@@ -2880,7 +3173,7 @@ func (r *Reader) ValidateBlockChecksums() error {
 		}
 
 		// Read the block, which validates the checksum.
-		h, _, err := r.readBlock(bh, nil /* transform */, blockRS)
+		h, _, err := r.readBlock(bh, nil /* transform */, blockRS, cache.BlockTypeUnknown)
 		if err != nil {
 			return err
 		}
@@ -2943,7 +3236,7 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 			return 0, errCorruptIndexEntry
 		}
 		startIdxBlock, _, err := r.readBlock(
-			startIdxBH.BlockHandle, nil /* transform */, nil /* readaheadState */)
+			startIdxBH.BlockHandle, nil /* transform */, nil /* readaheadState */, cache.BlockTypeIndex)
 		if err != nil {
 			return 0, err
 		}
@@ -2964,7 +3257,7 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 				return 0, errCorruptIndexEntry
 			}
 			endIdxBlock, _, err := r.readBlock(
-				endIdxBH.BlockHandle, nil /* transform */, nil /* readaheadState */)
+				endIdxBH.BlockHandle, nil /* transform */, nil /* readaheadState */, cache.BlockTypeIndex)
 			if err != nil {
 				return 0, err
 			}
@@ -3098,6 +3391,12 @@ func NewReader(f ReadableFile, o ReaderOptions, extraOpts ...ReaderOption) (*Rea
 				errors.Safe(r.fileNum), errors.Safe(r.Properties.MergerName))
 		}
 	}
+	if r.err == nil && o.VerifyChecksumsOnOpen {
+		r.err = r.ValidateBlockChecksums()
+	}
+	if r.err == nil {
+		r.err = r.maybeDecodeTopLevelIndex()
+	}
 	if r.err != nil {
 		return nil, r.Close()
 	}
@@ -3110,15 +3409,16 @@ type Layout struct {
 	// ValidateBlockChecksums, which validates a static list of BlockHandles
 	// referenced in this struct.
 
-	Data       []BlockHandleWithProperties
-	Index      []BlockHandle
-	TopIndex   BlockHandle
-	Filter     BlockHandle
-	RangeDel   BlockHandle
-	RangeKey   BlockHandle
-	Properties BlockHandle
-	MetaIndex  BlockHandle
-	Footer     BlockHandle
+	Data            []BlockHandleWithProperties
+	Index           []BlockHandle
+	TopIndex        BlockHandle
+	Filter          BlockHandle
+	RangeDel        BlockHandle
+	RangeKey        BlockHandle
+	CompressionDict BlockHandle
+	Properties      BlockHandle
+	MetaIndex       BlockHandle
+	Footer          BlockHandle
 }
 
 // Describe returns a description of the layout. If the verbose parameter is
@@ -3150,6 +3450,9 @@ func (l *Layout) Describe(
 	if l.RangeKey.Length != 0 {
 		blocks = append(blocks, block{l.RangeKey, "range-key"})
 	}
+	if l.CompressionDict.Length != 0 {
+		blocks = append(blocks, block{l.CompressionDict, "compression-dict"})
+	}
 	if l.Properties.Length != 0 {
 		blocks = append(blocks, block{l.Properties, "properties"})
 	}
@@ -3221,7 +3524,7 @@ func (l *Layout) Describe(
 			continue
 		}
 
-		h, _, err := r.readBlock(b.BlockHandle, nil /* transform */, nil /* readaheadState */)
+		h, _, err := r.readBlock(b.BlockHandle, nil /* transform */, nil /* readaheadState */, cache.BlockTypeUnknown)
 		if err != nil {
 			fmt.Fprintf(w, "  [err: %s]\n", err)
 			continue
@@ -14,8 +14,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,6 +27,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/errorfs"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
@@ -1137,3 +1141,525 @@ func BenchmarkLayout(b *testing.B) {
 	b.StopTimer()
 	r.Close()
 }
+
+func buildRangeDelTable(t testing.TB, c *cache.Cache, fileNum base.FileNum, n int) *Reader {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{})
+	for i := 0; i < n; i++ {
+		start := []byte(fmt.Sprintf("%05d", 2*i))
+		end := []byte(fmt.Sprintf("%05d", 2*i+1))
+		require.NoError(t, w.DeleteRange(start, end))
+	}
+	require.NoError(t, w.Close())
+	r, err := NewMemReader(f.Bytes(), ReaderOptions{Cache: c})
+	require.NoError(t, err)
+	r.fileNum = fileNum
+	return r
+}
+
+func TestReaderNewRawRangeDelIters(t *testing.T) {
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	r := buildRangeDelTable(t, c, 1, 100)
+	defer r.Close()
+
+	collect := func(iter keyspan.FragmentIterator) []string {
+		var spans []string
+		for s := iter.First(); s != nil; s = iter.Next() {
+			spans = append(spans, s.String())
+		}
+		return spans
+	}
+	iter, err := r.NewRawRangeDelIter()
+	require.NoError(t, err)
+	expected := collect(iter)
+	require.NoError(t, iter.Close())
+	require.Len(t, expected, 100)
+
+	// Iterators obtained from the same batch are independent, and may each be
+	// used from a separate goroutine.
+	for _, n := range []int{1, 4, 16} {
+		b, err := r.NewRawRangeDelIters(n)
+		require.NoError(t, err)
+		require.Len(t, b.Iters, n)
+		var wg sync.WaitGroup
+		results := make([][]string, n)
+		for j := range b.Iters {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				results[j] = collect(b.Iters[j])
+			}(j)
+		}
+		wg.Wait()
+		for j := range results {
+			require.Equal(t, expected, results[j])
+		}
+		require.NoError(t, b.Release())
+	}
+
+	// A table with no range deletions returns a nil batch.
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{})
+	require.NoError(t, w.Set([]byte("a"), nil))
+	require.NoError(t, w.Close())
+	r2, err := NewMemReader(f.Bytes(), ReaderOptions{})
+	require.NoError(t, err)
+	defer r2.Close()
+	b, err := r2.NewRawRangeDelIters(4)
+	require.NoError(t, err)
+	require.Nil(t, b)
+}
+
+func BenchmarkNewRawRangeDelIters(b *testing.B) {
+	// Simulate obtaining the range deletion iterators for a wide compaction
+	// over many input tables.
+	const numTables = 200
+	c := cache.New(16 << 20)
+	defer c.Unref()
+	readers := make([]*Reader, numTables)
+	for i := range readers {
+		readers[i] = buildRangeDelTable(b, c, base.FileNum(i+1), 10)
+	}
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+
+	b.Run("single", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, r := range readers {
+				iter, err := r.NewRawRangeDelIter()
+				if err != nil {
+					b.Fatal(err)
+				}
+				iter.First()
+				iter.Close()
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, r := range readers {
+				iters, err := r.NewRawRangeDelIters(1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				iters.Iters[0].First()
+				if err := iters.Release(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkTwoLevelIterCreation(b *testing.B) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{BlockSize: 4 << 10, IndexBlockSize: 4 << 10})
+	var ikey InternalKey
+	for i := uint64(0); i < 1e6; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		ikey.UserKey = key
+		if err := w.Add(ikey, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1 << 20} {
+		b.Run(fmt.Sprintf("top-level-index-cache-size=%d", size), func(b *testing.B) {
+			c := cache.New(128 << 20)
+			defer c.Unref()
+			r, err := NewMemReader(f.Data(), ReaderOptions{
+				Cache:                  c,
+				TopLevelIndexCacheSize: size,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer r.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := iter.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderBypassCache(t *testing.T) {
+	for _, indexBlockSize := range []int{0, 256} {
+		t.Run(fmt.Sprintf("indexBlockSize=%d", indexBlockSize), func(t *testing.T) {
+			// The cache is far smaller than the table, so a scan that populates
+			// the cache would evict the hot blocks.
+			c := cache.New(256 << 10)
+			defer c.Unref()
+
+			const numKeys = 20000
+			f := &memFile{}
+			w := NewWriter(f, WriterOptions{
+				BlockSize:      1024,
+				IndexBlockSize: indexBlockSize,
+				Compression:    NoCompression,
+			})
+			value := bytes.Repeat([]byte("v"), 100)
+			for i := 0; i < numKeys; i++ {
+				require.NoError(t, w.Set([]byte(fmt.Sprintf("%06d", i)), value))
+			}
+			require.NoError(t, w.Close())
+			r, err := NewMemReader(f.Bytes(), ReaderOptions{Cache: c})
+			require.NoError(t, err)
+			defer r.Close()
+
+			l, err := r.Layout()
+			require.NoError(t, err)
+			require.Greater(t, len(l.Data), 1000)
+
+			// Read the first few data blocks through a regular iterator,
+			// populating the cache.
+			const hotKeys = 50
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			n := 0
+			for key, _ := iter.First(); key != nil && n < hotKeys; key, _ = iter.Next() {
+				n++
+			}
+			require.NoError(t, iter.Close())
+			var hot []uint64
+			for _, bh := range l.Data {
+				if h := c.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
+					hot = append(hot, bh.Offset)
+					h.Release()
+				}
+			}
+			require.NotEmpty(t, hot)
+			before := c.Metrics()
+
+			// Scan the whole table, bypassing the cache.
+			iter, err = r.NewIterWithOptions(IterOptions{UseFilterBlock: true, BypassCache: true})
+			require.NoError(t, err)
+			n = 0
+			for key, v := iter.First(); key != nil; key, v = iter.Next() {
+				require.Equal(t, fmt.Sprintf("%06d", n), string(key.UserKey))
+				require.Equal(t, value, v)
+				n++
+			}
+			require.NoError(t, iter.Error())
+			require.NoError(t, iter.Close())
+			require.Equal(t, numKeys, n)
+
+			// The hot blocks survived the scan, and no data blocks were added
+			// to the cache.
+			for _, off := range hot {
+				h := c.Get(r.cacheID, r.fileNum, off)
+				require.NotNil(t, h.Get(), "hot block at offset %d was evicted", off)
+				h.Release()
+			}
+			after := c.Metrics()
+			require.Equal(t, before.Count, after.Count)
+			require.Equal(t, before.Size, after.Size)
+		})
+	}
+}
+
+func TestReaderPrefixBloomFilter(t *testing.T) {
+	// Bloom filters are built over the prefixes of keys, as determined by
+	// Comparer.Split, so a SeekPrefixGE probes the filter with the prefix
+	// regardless of the suffix the seek key carries.
+	filter := bloom.FilterPolicy(20)
+	tables := [][]string{
+		{"apple", "banana"},
+		{"cherry", "kiwi"},
+		{"apple", "kiwi", "lemon"},
+	}
+	prefixes := []string{"apple", "banana", "cherry", "date", "kiwi", "lemon", "mango"}
+
+	for ti, table := range tables {
+		f := &memFile{}
+		w := NewWriter(f, WriterOptions{
+			Comparer:     testkeys.Comparer,
+			FilterPolicy: filter,
+		})
+		for _, prefix := range table {
+			for ts := 30; ts > 0; ts -= 10 {
+				k := append([]byte(prefix), testkeys.Suffix(ts)...)
+				require.NoError(t, w.Set(k, []byte("v")))
+			}
+		}
+		require.NoError(t, w.Close())
+		r, err := NewMemReader(f.Bytes(), ReaderOptions{
+			Comparer: testkeys.Comparer,
+			Filters:  map[string]FilterPolicy{filter.Name(): filter},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, r.tableFilter)
+
+		for _, prefix := range prefixes {
+			contains := false
+			for _, p := range table {
+				contains = contains || p == prefix
+			}
+			// Probe with suffixes both present and absent within the
+			// table, and with no suffix at all.
+			for _, ts := range []int{0, 5, 20, 100} {
+				key := []byte(prefix)
+				if ts > 0 {
+					key = append(key, testkeys.Suffix(ts)...)
+				}
+				iter, err := r.NewIter(nil, nil)
+				require.NoError(t, err)
+				sli := iter.(*singleLevelIterator)
+				k, _ := iter.SeekPrefixGE([]byte(prefix), key, base.SeekGEFlagsNone)
+				require.Equal(t, contains, sli.lastBloomFilterMatched,
+					"table %d, seek key %q", ti, key)
+				if !contains {
+					// The filter rejected the table without reading a data
+					// block.
+					require.Nil(t, k)
+					require.Zero(t, sli.stats.BlockBytes)
+				} else if ts == 0 || ts >= 10 {
+					// The seek key sorts at or before one of the table's
+					// keys with the prefix.
+					require.NotNil(t, k, "table %d, seek key %q", ti, key)
+					require.Equal(t, prefix, string(k.UserKey[:testkeys.Comparer.Split(k.UserKey)]))
+				}
+				require.NoError(t, iter.Close())
+			}
+		}
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestReaderLayoutOffsets(t *testing.T) {
+	filter := bloom.FilterPolicy(10)
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f, WriterOptions{
+		BlockSize:      256,
+		Comparer:       testkeys.Comparer,
+		FilterPolicy:   filter,
+		IndexBlockSize: 256,
+		TableFormat:    TableFormatPebblev2,
+	})
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("value")))
+	}
+	require.NoError(t, w.DeleteRange([]byte("key0100"), []byte("key0200")))
+	require.NoError(t, w.RangeKeySet([]byte("key0300"), []byte("key0400"), nil, []byte("value")))
+	require.NoError(t, w.Close())
+
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	r, err := NewReader(f, ReaderOptions{
+		Comparer: testkeys.Comparer,
+		Filters:  map[string]FilterPolicy{filter.Name(): filter},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Equal(t, int(r.Properties.NumDataBlocks), len(l.Data))
+	require.Greater(t, len(l.Index), 1)
+	for _, bh := range []BlockHandle{
+		l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex,
+	} {
+		require.NotZero(t, bh.Length)
+	}
+
+	// The blocks, each followed by its trailer, tile the file up to the
+	// footer, which ends the file.
+	var blocks []BlockHandle
+	for _, bh := range l.Data {
+		blocks = append(blocks, bh.BlockHandle)
+	}
+	blocks = append(blocks, l.Index...)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Offset < blocks[j].Offset })
+	var offset uint64
+	for _, bh := range blocks {
+		require.Equal(t, offset, bh.Offset)
+		offset += bh.Length + blockTrailerLen
+	}
+	require.Equal(t, offset, l.Footer.Offset)
+	require.Equal(t, uint64(stat.Size()), l.Footer.Offset+l.Footer.Length)
+
+	// Each data block read from the reported offset holds the expected keys.
+	var i int
+	for _, bh := range l.Data {
+		h, _, err := r.readBlock(bh.BlockHandle, nil /* transform */, nil /* readaheadState */, cache.BlockTypeData)
+		require.NoError(t, err)
+		iter, err := newBlockIter(r.Compare, h.Get())
+		require.NoError(t, err)
+		for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+			require.Equal(t, fmt.Sprintf("key%04d", i), string(key.UserKey))
+			i++
+		}
+		require.NoError(t, iter.Close())
+		h.Release()
+	}
+	require.Equal(t, 1000, i)
+}
+
+func TestReaderVerifyChecksumsOnOpen(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f, WriterOptions{BlockSize: 256})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("value")))
+	}
+	require.NoError(t, w.Close())
+
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	data := make([]byte, stat.Size())
+	_, err = f.ReadAt(data, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r, err := NewMemReader(data, ReaderOptions{VerifyChecksumsOnOpen: true})
+	require.NoError(t, err)
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Greater(t, len(l.Data), 2)
+
+	// Corrupt a single byte of the third data block. Opening the table
+	// succeeds unless checksums are verified on open.
+	bh := l.Data[2].BlockHandle
+	data[bh.Offset+bh.Length/2] ^= 0xff
+	r, err = NewMemReader(data, ReaderOptions{})
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	_, err = NewMemReader(data, ReaderOptions{VerifyChecksumsOnOpen: true})
+	require.Error(t, err)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	require.Contains(t, err.Error(), fmt.Sprintf("checksum mismatch at %d/%d", bh.Offset, bh.Length))
+}
+
+func TestReaderNewBlockIter(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{BlockSize: 256, Compression: SnappyCompression})
+	var want []string
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key%04d", i)
+		require.NoError(t, w.Set([]byte(k), []byte("value")))
+		want = append(want, k)
+	}
+	require.NoError(t, w.Close())
+	data := f.Data()
+
+	r, err := NewMemReader(data, ReaderOptions{})
+	require.NoError(t, err)
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Greater(t, len(l.Data), 2)
+
+	// Each block iterator observes exactly the keys of its block, which
+	// together are the keys of the table.
+	var got []string
+	for _, bh := range l.Data {
+		iter, err := r.NewBlockIter(bh.BlockHandle)
+		require.NoError(t, err)
+		var keys []string
+		for k, v := iter.First(); k != nil; k, v = iter.Next() {
+			require.Equal(t, "value", string(v))
+			keys = append(keys, string(k.UserKey))
+		}
+		require.NotEmpty(t, keys)
+		var last []string
+		for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+			last = append([]string{string(k.UserKey)}, last...)
+		}
+		require.Equal(t, keys, last)
+		require.NoError(t, iter.Close())
+		got = append(got, keys...)
+	}
+	require.Equal(t, want, got)
+	require.NoError(t, r.Close())
+
+	// A corrupt block fails its checksum verification.
+	bh := l.Data[2].BlockHandle
+	data[bh.Offset+bh.Length/2] ^= 0xff
+	r, err = NewMemReader(data, ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = r.NewBlockIter(bh)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	iter, err := r.NewBlockIter(l.Data[1].BlockHandle)
+	require.NoError(t, err)
+	require.NoError(t, iter.Close())
+}
+
+func TestReaderTopLevelIndexCache(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{BlockSize: 256, IndexBlockSize: 256})
+	var want []string
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key%04d", i)
+		require.NoError(t, w.Set([]byte(k), []byte("value")))
+		want = append(want, k)
+	}
+	require.NoError(t, w.Close())
+	data := f.Data()
+
+	scan := func(r *Reader) (fwd, rev []string) {
+		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+		require.NoError(t, err)
+		for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+			require.Equal(t, r.Properties.GlobalSeqNum, k.SeqNum())
+			fwd = append(fwd, string(k.UserKey))
+		}
+		for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+			rev = append([]string{string(k.UserKey)}, rev...)
+		}
+		k, _ := iter.SeekGE([]byte("key0500"), base.SeekGEFlagsNone)
+		require.Equal(t, "key0500", string(k.UserKey))
+		k, _ = iter.SeekLT([]byte("key0500"), base.SeekLTFlagsNone)
+		require.Equal(t, "key0499", string(k.UserKey))
+		require.NoError(t, iter.Close())
+		return fwd, rev
+	}
+
+	// A limit smaller than the top-level index leaves it in the block cache.
+	r, err := NewMemReader(data, ReaderOptions{TopLevelIndexCacheSize: 16})
+	require.NoError(t, err)
+	require.Greater(t, r.Properties.IndexPartitions, uint64(1))
+	require.Nil(t, r.topLevelIndex)
+	require.NoError(t, r.Close())
+
+	r, err = NewMemReader(data, ReaderOptions{TopLevelIndexCacheSize: 64 << 10})
+	require.NoError(t, err)
+	require.NotNil(t, r.topLevelIndex)
+	for _, seqNum := range []uint64{0, 42} {
+		r.Properties.GlobalSeqNum = seqNum
+		fwd, rev := scan(r)
+		require.Equal(t, want, fwd)
+		require.Equal(t, want, rev)
+	}
+	require.NoError(t, r.Close())
+	require.Nil(t, r.topLevelIndex)
+}
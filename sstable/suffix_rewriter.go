@@ -112,6 +112,7 @@ func rewriteBlocks(
 	restartInterval int,
 	checksumType ChecksumType,
 	compression Compression,
+	dict []byte,
 	input []BlockHandleWithProperties,
 	output []blockWithSpan,
 	totalWorkers, worker int,
@@ -187,7 +188,7 @@ func rewriteBlocks(
 
 		keyAlloc, output[i].end = cloneKeyWithBuf(scratch, keyAlloc)
 
-		finished := compressAndChecksum(bw.finish(), compression, &buf)
+		finished := compressAndChecksum(bw.finish(), compression, dict, &buf)
 
 		// copy our finished block into the output buffer.
 		sz := len(finished) + blockTrailerLen
@@ -237,6 +238,7 @@ func rewriteDataBlocksToWriter(
 				w.dataBlockBuf.dataBlock.restartInterval,
 				w.blockBuf.checksummer.checksumType,
 				w.compression,
+				w.zstdDict,
 				data,
 				blocks,
 				concurrency,
@@ -446,7 +448,7 @@ func readBlockBuf(r *Reader, bh BlockHandle, buf []byte) ([]byte, []byte, error)
 	if cap(buf) < decompressedLen {
 		buf = make([]byte, decompressedLen)
 	}
-	res, err := decompressInto(typ, raw[prefix:], buf[:decompressedLen])
+	res, err := decompressInto(typ, raw[prefix:], buf[:decompressedLen], r.zstdDict)
 	return res, buf, err
 }
 
@@ -87,6 +87,7 @@ The table file format looks like:
 [index block] (for single level index)
 [meta rangedel block] (optional)
 [meta range key block] (optional)
+[meta compression dictionary block] (optional)
 [meta properties block]
 [metaindex block]
 [footer]
@@ -96,7 +97,9 @@ A Reader eagerly loads the footer, metaindex block and meta properties block,
 because the data contained in those blocks is needed on every read, and even
 before reading. For example, the meta properties block is used to verify the
 comparer and merger are compatible, and the metaindex block contains the
-location of the meta properties (and other meta blocks). In situations where
+location of the meta properties (and other meta blocks). A table compressed
+with a zstd dictionary also has its compression dictionary block loaded
+eagerly, since it is required to decompress any data block. In situations where
 file system locality matters, or one wants to minimize number of read
 requests when eagerly loading these blocks, having these three as a suffix
 of the file is convenient.
@@ -182,10 +185,11 @@ const (
 	levelDBFormatVersion  = 0
 	rocksDBFormatVersion2 = 2
 
-	metaRangeKeyName   = "pebble.range_key"
-	metaPropertiesName = "rocksdb.properties"
-	metaRangeDelName   = "rocksdb.range_del"
-	metaRangeDelV2Name = "rocksdb.range_del2"
+	metaCompressionDictName = "pebble.compression_dict"
+	metaRangeKeyName        = "pebble.range_key"
+	metaPropertiesName      = "rocksdb.properties"
+	metaRangeDelName        = "rocksdb.range_del"
+	metaRangeDelV2Name      = "rocksdb.range_del2"
 
 	// Index Types.
 	// A space efficient index block that is optimized for binary-search-based
@@ -247,6 +251,10 @@ const (
 	lz4hcCompressionBlockType  blockType = 5
 	xpressCompressionBlockType blockType = 6
 	zstdCompressionBlockType   blockType = 7
+	// zstdDictCompressionBlockType is a Pebble-specific block type for blocks
+	// compressed with zstd using the dictionary stored within the table's
+	// compression dictionary meta block.
+	zstdDictCompressionBlockType blockType = 8
 )
 
 // String implements fmt.Stringer.
@@ -268,6 +276,8 @@ func (t blockType) String() string {
 		return "xpress"
 	case 7:
 		return "zstd"
+	case 8:
+		return "zstd-dict"
 	default:
 		panic(errors.Newf("sstable: unknown block type: %d", t))
 	}
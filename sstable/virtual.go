@@ -0,0 +1,183 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// VirtualBounds describe the key range of a virtual sstable within the
+// physical sstable backing it. Only the keys of the physical sstable within
+// the bounds are visible through the virtual sstable.
+type VirtualBounds struct {
+	// Lower is the smallest user key of the virtual sstable, inclusive.
+	Lower []byte
+	// Upper is the largest user key of the virtual sstable. It's inclusive,
+	// unless UpperExclusive is set.
+	Upper          []byte
+	UpperExclusive bool
+}
+
+// virtualIter wraps an iterator over a physical sstable, hiding the keys that
+// lie outside of the bounds of a virtual sstable.
+type virtualIter struct {
+	cmp    Compare
+	iter   Iterator
+	bounds VirtualBounds
+	// compaction is true if iter is a compaction iterator, which only
+	// supports forward iteration from First without seeking.
+	compaction bool
+}
+
+var _ Iterator = (*virtualIter)(nil)
+
+// NewVirtualIter returns an iterator over the point keys of the provided
+// iterator, which must be an iterator over the physical sstable backing a
+// virtual sstable, that lie within the virtual sstable's bounds. Closing the
+// returned iterator closes the wrapped iterator.
+//
+// A compaction iterator may be wrapped, in which case the returned iterator
+// must also only be used for forward iteration from First. As a compaction
+// iterator cannot seek, the keys before the lower bound are stepped over.
+func NewVirtualIter(cmp Compare, iter Iterator, bounds VirtualBounds) Iterator {
+	_, compaction := iter.(*compactionIterator)
+	return &virtualIter{cmp: cmp, iter: iter, bounds: bounds, compaction: compaction}
+}
+
+func (i *virtualIter) aboveUpper(key []byte) bool {
+	c := i.cmp(key, i.bounds.Upper)
+	return c > 0 || (c == 0 && i.bounds.UpperExclusive)
+}
+
+func (i *virtualIter) checkUpper(key *InternalKey, value []byte) (*InternalKey, []byte) {
+	if key != nil && i.aboveUpper(key.UserKey) {
+		return nil, nil
+	}
+	return key, value
+}
+
+func (i *virtualIter) checkLower(key *InternalKey, value []byte) (*InternalKey, []byte) {
+	if key != nil && i.cmp(key.UserKey, i.bounds.Lower) < 0 {
+		return nil, nil
+	}
+	return key, value
+}
+
+// seekLastWithinUpper positions the wrapped iterator at the last key at or
+// below the virtual upper bound. The caller must have determined that the
+// wrapped iterator contains a key above the upper bound, so that seeking to
+// the upper bound respects the wrapped iterator's own bounds.
+func (i *virtualIter) seekLastWithinUpper() (*InternalKey, []byte) {
+	key, value := i.iter.SeekLT(i.bounds.Upper, base.SeekLTFlagsNone)
+	if i.bounds.UpperExclusive {
+		return key, value
+	}
+	// SeekLT positions before every key with the user key of the inclusive
+	// upper bound. Step forward over any such keys.
+	for {
+		next, _ := i.iter.Next()
+		if next == nil || i.cmp(next.UserKey, i.bounds.Upper) > 0 {
+			return i.iter.Prev()
+		}
+	}
+}
+
+// SeekGE implements internalIterator.SeekGE, as documented in the pebble
+// package.
+func (i *virtualIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, []byte) {
+	if i.cmp(key, i.bounds.Lower) < 0 {
+		key = i.bounds.Lower
+	}
+	return i.checkUpper(i.iter.SeekGE(key, flags))
+}
+
+// SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
+// pebble package.
+func (i *virtualIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, []byte) {
+	if i.cmp(key, i.bounds.Lower) < 0 {
+		key = i.bounds.Lower
+	}
+	return i.checkUpper(i.iter.SeekPrefixGE(prefix, key, flags))
+}
+
+// SeekLT implements internalIterator.SeekLT, as documented in the pebble
+// package.
+func (i *virtualIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, []byte) {
+	k, v := i.iter.SeekLT(key, flags)
+	if k != nil && i.aboveUpper(k.UserKey) {
+		k, v = i.seekLastWithinUpper()
+	}
+	return i.checkLower(k, v)
+}
+
+// First implements internalIterator.First, as documented in the pebble
+// package.
+func (i *virtualIter) First() (*InternalKey, []byte) {
+	k, v := i.iter.First()
+	if k != nil && i.cmp(k.UserKey, i.bounds.Lower) < 0 {
+		if !i.compaction {
+			k, v = i.iter.SeekGE(i.bounds.Lower, base.SeekGEFlagsNone)
+		}
+		for k != nil && i.cmp(k.UserKey, i.bounds.Lower) < 0 {
+			k, v = i.iter.Next()
+		}
+	}
+	return i.checkUpper(k, v)
+}
+
+// Last implements internalIterator.Last, as documented in the pebble package.
+func (i *virtualIter) Last() (*InternalKey, []byte) {
+	k, v := i.iter.Last()
+	if k != nil && i.aboveUpper(k.UserKey) {
+		k, v = i.seekLastWithinUpper()
+	}
+	return i.checkLower(k, v)
+}
+
+// Next implements internalIterator.Next, as documented in the pebble package.
+func (i *virtualIter) Next() (*InternalKey, []byte) {
+	return i.checkUpper(i.iter.Next())
+}
+
+// Prev implements internalIterator.Prev, as documented in the pebble package.
+func (i *virtualIter) Prev() (*InternalKey, []byte) {
+	return i.checkLower(i.iter.Prev())
+}
+
+// Error implements internalIterator.Error, as documented in the pebble
+// package.
+func (i *virtualIter) Error() error {
+	return i.iter.Error()
+}
+
+// Close implements internalIterator.Close, as documented in the pebble
+// package.
+func (i *virtualIter) Close() error {
+	return i.iter.Close()
+}
+
+// SetBounds implements internalIterator.SetBounds, as documented in the pebble
+// package.
+func (i *virtualIter) SetBounds(lower, upper []byte) {
+	i.iter.SetBounds(lower, upper)
+}
+
+// MaybeFilteredKeys implements Iterator.MaybeFilteredKeys.
+func (i *virtualIter) MaybeFilteredKeys() bool {
+	return i.iter.MaybeFilteredKeys()
+}
+
+// SetCloseHook implements Iterator.SetCloseHook.
+func (i *virtualIter) SetCloseHook(fn func(i Iterator) error) {
+	i.iter.SetCloseHook(fn)
+}
+
+func (i *virtualIter) String() string {
+	return fmt.Sprintf("virtual(%s)", i.iter)
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualIter(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f, WriterOptions{})
+	for _, k := range []InternalKey{
+		base.MakeInternalKey([]byte("a"), 1, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("b"), 1, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("c"), 1, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("d"), 3, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("d"), 2, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("e"), 1, base.InternalKeyKindSet),
+	} {
+		require.NoError(t, w.Add(k, nil))
+	}
+	require.NoError(t, w.Close())
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	r, err := NewReader(f, ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	format := func(key *InternalKey, _ []byte) string {
+		if key == nil {
+			return "."
+		}
+		return fmt.Sprintf("%s#%d", key.UserKey, key.SeqNum())
+	}
+	run := func(bounds VirtualBounds, compaction bool, ops func(i Iterator) []string) string {
+		var iter Iterator
+		var err error
+		if compaction {
+			var bytesIterated uint64
+			iter, err = r.NewCompactionIter(&bytesIterated)
+		} else {
+			iter, err = r.NewIter(nil /* lower */, nil /* upper */)
+		}
+		require.NoError(t, err)
+		iter = NewVirtualIter(base.DefaultComparer.Compare, iter, bounds)
+		defer func() { require.NoError(t, iter.Close()) }()
+		return strings.Join(ops(iter), " ")
+	}
+	scan := func(i Iterator) []string {
+		var res []string
+		for k, v := i.First(); ; k, v = i.Next() {
+			res = append(res, format(k, v))
+			if k == nil {
+				break
+			}
+		}
+		for k, v := i.Last(); ; k, v = i.Prev() {
+			res = append(res, format(k, v))
+			if k == nil {
+				break
+			}
+		}
+		return res
+	}
+	seeks := func(i Iterator) []string {
+		return []string{
+			format(i.SeekGE([]byte("a"), base.SeekGEFlagsNone)),
+			format(i.SeekGE([]byte("c"), base.SeekGEFlagsNone)),
+			format(i.SeekGE([]byte("e"), base.SeekGEFlagsNone)),
+			format(i.SeekPrefixGE([]byte("a"), []byte("a"), base.SeekGEFlagsNone)),
+			format(i.SeekLT([]byte("f"), base.SeekLTFlagsNone)),
+			format(i.SeekLT([]byte("c"), base.SeekLTFlagsNone)),
+			format(i.SeekLT([]byte("b"), base.SeekLTFlagsNone)),
+		}
+	}
+
+	inclusive := VirtualBounds{Lower: []byte("b"), Upper: []byte("d")}
+	exclusive := VirtualBounds{Lower: []byte("b"), Upper: []byte("d"), UpperExclusive: true}
+	testCases := []struct {
+		bounds     VirtualBounds
+		ops        func(i Iterator) []string
+		compaction bool
+		want       string
+	}{
+		{inclusive, scan, false, "b#1 c#1 d#3 d#2 . d#2 d#3 c#1 b#1 ."},
+		{exclusive, scan, false, "b#1 c#1 . c#1 b#1 ."},
+		{inclusive, seeks, false, "b#1 c#1 . b#1 d#2 b#1 ."},
+		{exclusive, seeks, false, "b#1 c#1 . b#1 c#1 b#1 ."},
+		{inclusive, func(i Iterator) []string {
+			var res []string
+			for k, v := i.First(); ; k, v = i.Next() {
+				res = append(res, format(k, v))
+				if k == nil {
+					break
+				}
+			}
+			return res
+		}, true, "b#1 c#1 d#3 d#2 ."},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s-%s-%t-%t", tc.bounds.Lower, tc.bounds.Upper, tc.bounds.UpperExclusive, tc.compaction), func(t *testing.T) {
+			require.Equal(t, tc.want, run(tc.bounds, tc.compaction, tc.ops))
+		})
+	}
+}
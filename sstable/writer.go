@@ -136,15 +136,19 @@ type Writer struct {
 	indexBlockSize          int
 	indexBlockSizeThreshold int
 	compare                 Compare
+	rangeKeyMerge           base.RangeKeyMerge
 	split                   Split
 	formatKey               base.FormatKey
 	compression             Compression
+	zstdDict                []byte
 	separator               Separator
 	successor               Successor
 	tableFormat             TableFormat
 	cache                   *cache.Cache
 	restartInterval         int
 	checksumType            ChecksumType
+	writeLimiter            WriteLimiter
+	validateComparer        bool
 	// disableKeyOrderChecks disables the checks that keys are added to an
 	// sstable in order. It is intended for internal use only in the construction
 	// of invalid sstables for testing. See tool/make_test_sstables.go.
@@ -588,8 +592,8 @@ func (d *dataBlockBuf) finish() {
 	d.uncompressed = d.dataBlock.finish()
 }
 
-func (d *dataBlockBuf) compressAndChecksum(c Compression) {
-	d.compressed = compressAndChecksum(d.uncompressed, c, &d.blockBuf)
+func (d *dataBlockBuf) compressAndChecksum(c Compression, dict []byte) {
+	d.compressed = compressAndChecksum(d.uncompressed, c, dict, &d.blockBuf)
 }
 
 func (d *dataBlockBuf) shouldFlush(
@@ -758,6 +762,8 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 		w.props.NumDeletions++
 	case InternalKeyKindMerge:
 		w.props.NumMergeOperands++
+	case base.InternalKeyKindSingleDelete:
+		w.props.NumSingleDeletions++
 	}
 	w.props.RawKeySize += uint64(key.Size())
 	w.props.RawValueSize += uint64(len(value))
@@ -941,7 +947,7 @@ func (w *Writer) coalesceSpans(span keyspan.Span) {
 	// owned by this span and it's safe to mutate.
 	w.rangeKeyCoalesced.Start = span.Start
 	w.rangeKeyCoalesced.End = span.End
-	err := rangekey.Coalesce(w.compare, span.Keys, &w.rangeKeyCoalesced.Keys)
+	err := rangekey.Coalesce(w.compare, w.rangeKeyMerge, span.Keys, &w.rangeKeyCoalesced.Keys)
 	if err != nil {
 		w.err = errors.Newf("sstable: could not coalesce span: %s", err)
 		return
@@ -1095,7 +1101,7 @@ func (w *Writer) flush(key InternalKey) error {
 	}
 
 	w.dataBlockBuf.finish()
-	w.dataBlockBuf.compressAndChecksum(w.compression)
+	w.dataBlockBuf.compressAndChecksum(w.compression, w.zstdDict)
 
 	// Determine if the index block should be flushed. Since we're accessing the
 	// dataBlockBuf.dataBlock.curKey here, we have to make sure that once we start
@@ -1104,7 +1110,10 @@ func (w *Writer) flush(key InternalKey) error {
 	// byte slice which supports "sep" will eventually be copied when "sep" is
 	// added to the index block.
 	prevKey := base.DecodeInternalKey(w.dataBlockBuf.dataBlock.curKey)
-	sep := w.indexEntrySep(prevKey, key, w.dataBlockBuf)
+	sep, err := w.indexEntrySep(prevKey, key, w.dataBlockBuf)
+	if err != nil {
+		return err
+	}
 	// We determine that we should flush an index block from the Writer client
 	// goroutine, but we actually finish the index block from the writeQueue.
 	// When we determine that an index block should be flushed, we need to call
@@ -1214,7 +1223,9 @@ func (w *Writer) maybeAddBlockPropertiesToBlockHandle(
 	return BlockHandleWithProperties{BlockHandle: bh, Props: w.dataBlockBuf.dataBlockProps}, nil
 }
 
-func (w *Writer) indexEntrySep(prevKey, key InternalKey, dataBlockBuf *dataBlockBuf) InternalKey {
+func (w *Writer) indexEntrySep(
+	prevKey, key InternalKey, dataBlockBuf *dataBlockBuf,
+) (InternalKey, error) {
 	// Make a rough guess that we want key-sized scratch to compute the separator.
 	if cap(dataBlockBuf.sepScratch) < key.Size() {
 		dataBlockBuf.sepScratch = make([]byte, 0, key.Size()*2)
@@ -1222,11 +1233,27 @@ func (w *Writer) indexEntrySep(prevKey, key InternalKey, dataBlockBuf *dataBlock
 
 	var sep InternalKey
 	if key.UserKey == nil && key.Trailer == 0 {
+		if w.validateComparer {
+			succ := w.successor(dataBlockBuf.sepScratch[:0], prevKey.UserKey)
+			if w.compare(prevKey.UserKey, succ) > 0 {
+				return sep, errors.Errorf("pebble: comparer %s: Successor(%s) = %s, which sorts before its argument",
+					errors.Safe(w.props.ComparerName), w.formatKey(prevKey.UserKey), w.formatKey(succ))
+			}
+		}
 		sep = prevKey.Successor(w.compare, w.successor, dataBlockBuf.sepScratch[:0])
 	} else {
+		if w.validateComparer {
+			s := w.separator(dataBlockBuf.sepScratch[:0], prevKey.UserKey, key.UserKey)
+			if w.compare(prevKey.UserKey, s) > 0 ||
+				(w.compare(prevKey.UserKey, key.UserKey) < 0 && w.compare(s, key.UserKey) >= 0) {
+				return sep, errors.Errorf("pebble: comparer %s: Separator(%s, %s) = %s, which does not lie within [%s, %s)",
+					errors.Safe(w.props.ComparerName), w.formatKey(prevKey.UserKey), w.formatKey(key.UserKey),
+					w.formatKey(s), w.formatKey(prevKey.UserKey), w.formatKey(key.UserKey))
+			}
+		}
 		sep = prevKey.Separator(w.compare, w.separator, dataBlockBuf.sepScratch[:0], key)
 	}
-	return sep
+	return sep, nil
 }
 
 // addIndexEntry adds an index entry for the specified key and block handle.
@@ -1289,14 +1316,16 @@ func (w *Writer) addPrevDataBlockToIndexBlockProps() {
 func (w *Writer) addIndexEntrySync(
 	prevKey, key InternalKey, bhp BlockHandleWithProperties, tmp []byte,
 ) error {
-	sep := w.indexEntrySep(prevKey, key, w.dataBlockBuf)
+	sep, err := w.indexEntrySep(prevKey, key, w.dataBlockBuf)
+	if err != nil {
+		return err
+	}
 	shouldFlush := supportsTwoLevelIndex(
 		w.tableFormat) && w.indexBlock.shouldFlush(
 		sep, encodedBHPEstimatedSize, w.indexBlockSize, w.indexBlockSizeThreshold,
 	)
 	var flushableIndexBlock *indexBlockBuf
 	var props []byte
-	var err error
 	if shouldFlush {
 		flushableIndexBlock = w.indexBlock
 		w.indexBlock = newIndexBlockBuf(w.coordination.parallelismEnabled)
@@ -1449,10 +1478,12 @@ func (w *Writer) writeTwoLevelIndex() (BlockHandle, error) {
 	return w.writeBlock(w.topLevelIndexBlock.finish(), w.compression, &w.blockBuf)
 }
 
-func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf) []byte {
+func compressAndChecksum(
+	b []byte, compression Compression, dict []byte, blockBuf *blockBuf,
+) []byte {
 	// Compress the buffer, discarding the result if the improvement isn't at
 	// least 12.5%.
-	blockType, compressed := compressBlock(compression, b, blockBuf.compressedBuf)
+	blockType, compressed := compressBlock(compression, dict, b, blockBuf.compressedBuf)
 	if blockType != noCompressionBlockType && cap(compressed) > cap(blockBuf.compressedBuf) {
 		blockBuf.compressedBuf = compressed[:cap(compressed)]
 	}
@@ -1482,6 +1513,10 @@ func (w *Writer) writeCompressedBlock(block []byte, blockTrailerBuf []byte) (Blo
 		w.cache.Delete(w.cacheID, w.fileNum, bh.Offset)
 	}
 
+	if w.writeLimiter != nil {
+		w.writeLimiter.Wait(len(block) + blockTrailerLen)
+	}
+
 	// Write the bytes to the file.
 	n, err := w.writer.Write(block)
 	if err != nil {
@@ -1500,7 +1535,7 @@ func (w *Writer) writeCompressedBlock(block []byte, blockTrailerBuf []byte) (Blo
 func (w *Writer) writeBlock(
 	b []byte, compression Compression, blockBuf *blockBuf,
 ) (BlockHandle, error) {
-	b = compressAndChecksum(b, compression, blockBuf)
+	b = compressAndChecksum(b, compression, w.zstdDict, blockBuf)
 	return w.writeCompressedBlock(b, blockBuf.tmp[:])
 }
 
@@ -1515,6 +1550,15 @@ func (w *Writer) assertFormatCompatibility() error {
 		)
 	}
 
+	// PebbleDBv1: zstd dictionary compression. The dictionary compressed
+	// block type is not understood by RocksDB.
+	if len(w.zstdDict) > 0 && w.tableFormat < TableFormatPebblev1 {
+		return errors.Newf(
+			"table format version %s is less than the minimum required version %s for zstd dictionary compression",
+			w.tableFormat, TableFormatPebblev1,
+		)
+	}
+
 	// PebbleDBv2: range keys.
 	if w.props.NumRangeKeys() > 0 && w.tableFormat < TableFormatPebblev2 {
 		return errors.Newf(
@@ -1682,6 +1726,19 @@ func (w *Writer) Close() (err error) {
 		}
 	}
 
+	// Write the compression dictionary block, if any. The dictionary block name
+	// sorts before the range key block name, so its block handle is added to
+	// the metaindex block first.
+	if len(w.zstdDict) > 0 {
+		bh, err := w.writeBlock(w.zstdDict, NoCompression, &w.blockBuf)
+		if err != nil {
+			w.err = err
+			return w.err
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(metaCompressionDictName)}, w.blockBuf.tmp[:n])
+	}
+
 	// Add the range key block handle to the metaindex block. Note that we add the
 	// block handle to the metaindex block before the other meta blocks as the
 	// metaindex block entries must be sorted, and the range key block name sorts
@@ -1908,6 +1965,8 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		cache:                   o.Cache,
 		restartInterval:         o.BlockRestartInterval,
 		checksumType:            o.Checksum,
+		writeLimiter:            o.WriteLimiter,
+		validateComparer:        o.ValidateComparer,
 		indexBlock:              newIndexBlockBuf(o.Parallelism),
 		rangeDelBlock: blockWriter{
 			restartInterval: 1,
@@ -1930,6 +1989,14 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		checksummer: checksummer{checksumType: o.Checksum},
 	}
 
+	if len(o.ZstdDictionary) > 0 && o.Compression == ZstdCompression {
+		if err := validateZstdDict(o.ZstdDictionary); err != nil {
+			w.err = errors.Wrap(err, "pebble: invalid zstd dictionary")
+			return w
+		}
+		w.zstdDict = o.ZstdDictionary
+	}
+
 	w.coordination.init(o.Parallelism, w)
 
 	if f == nil {
@@ -1967,6 +2034,9 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 	w.props.ComparerName = o.Comparer.Name
 	w.props.CompressionName = o.Compression.String()
 	w.props.MergerName = o.MergerName
+	if o.RangeKeyMerger != nil {
+		w.rangeKeyMerge = o.RangeKeyMerger.Merge
+	}
 	w.props.PropertyCollectorNames = "[]"
 	w.props.ExternalFormatVersion = rocksDBExternalFormatVersion
 
@@ -624,6 +624,156 @@ func TestWriter_TableFormatCompatibility(t *testing.T) {
 	}
 }
 
+func TestWriterZstdDictionary(t *testing.T) {
+	if !useStandardZstdLib {
+		t.Skip("raw content dictionaries require the standard zstd library")
+	}
+
+	// Values are small and share most of their content, so each block has
+	// little redundancy of its own but a great deal in common with a
+	// dictionary trained on similar values.
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf(
+			`{"id":%d,"status":"active","region":"us-east-1","plan":"enterprise","tags":["a","b"]}`, i))
+	}
+	var dict []byte
+	for i := 0; i < 64; i++ {
+		dict = append(dict, value(i*7919)...)
+	}
+
+	const numKeys = 2000
+	writeTable := func(opts WriterOptions) []byte {
+		f := &memFile{}
+		w := NewWriter(f, opts)
+		for i := 0; i < numKeys; i++ {
+			key := []byte(fmt.Sprintf("key%06d", i))
+			require.NoError(t, w.Set(key, value(i)))
+		}
+		require.NoError(t, w.Close())
+		return f.Bytes()
+	}
+	opts := WriterOptions{
+		BlockSize:   256,
+		Compression: ZstdCompression,
+		TableFormat: TableFormatPebblev1,
+	}
+	plain := writeTable(opts)
+	opts.ZstdDictionary = dict
+	dictCompressed := writeTable(opts)
+	require.Less(t, len(dictCompressed), len(plain))
+
+	r, err := NewMemReader(dictCompressed, ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+	require.Equal(t, dict, r.zstdDict)
+
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	i := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		require.Equal(t, fmt.Sprintf("key%06d", i), string(k.UserKey))
+		require.Equal(t, value(i), v)
+		i++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, numKeys, i)
+	require.NoError(t, r.ValidateBlockChecksums())
+
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(dict)), l.CompressionDict.Length)
+
+	// A table written without a dictionary has no dictionary block.
+	r2, err := NewMemReader(plain, ReaderOptions{})
+	require.NoError(t, err)
+	require.Nil(t, r2.zstdDict)
+	require.NoError(t, r2.Close())
+
+	// Blocks compressed with a dictionary fail cleanly when there is no
+	// dictionary with which to decompress them.
+	typ, compressed := compressBlock(ZstdCompression, dict, value(0), nil)
+	require.Equal(t, zstdDictCompressionBlockType, typ)
+	decodedLen, prefixLen, err := decompressedLen(typ, compressed)
+	require.NoError(t, err)
+	_, err = decompressInto(typ, compressed[prefixLen:], make([]byte, decodedLen), nil)
+	require.True(t, errors.Is(err, base.ErrCorruption))
+	decoded, err := decompressInto(typ, compressed[prefixLen:], make([]byte, decodedLen), dict)
+	require.NoError(t, err)
+	require.Equal(t, value(0), decoded)
+
+	// The dictionary compressed block type is not understood by RocksDB, so
+	// the dictionary requires a Pebble table format.
+	f := &memFile{}
+	opts.TableFormat = TableFormatRocksDBv2
+	w := NewWriter(f, opts)
+	require.NoError(t, w.Set([]byte("a"), value(0)))
+	require.Error(t, w.Close())
+
+	// The dictionary is ignored if zstd compression is not in use.
+	opts.TableFormat = TableFormatPebblev1
+	opts.Compression = SnappyCompression
+	r3, err := NewMemReader(writeTable(opts), ReaderOptions{})
+	require.NoError(t, err)
+	require.Nil(t, r3.zstdDict)
+	require.NoError(t, r3.Close())
+}
+
+func TestWriterChecksumRoundTrip(t *testing.T) {
+	const numKeys = 1000
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, i%100)
+	}
+	for _, checksum := range []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64} {
+		for _, parallelism := range []bool{false, true} {
+			t.Run(fmt.Sprintf("checksum=%s,parallelism=%t", checksum, parallelism), func(t *testing.T) {
+				f := &memFile{}
+				w := NewWriter(f, WriterOptions{
+					BlockSize:      256,
+					IndexBlockSize: 256,
+					Checksum:       checksum,
+					Parallelism:    parallelism,
+					TableFormat:    TableFormatPebblev2,
+				})
+				for i := 0; i < numKeys; i++ {
+					require.NoError(t, w.Set([]byte(fmt.Sprintf("key%06d", i)), value(i)))
+				}
+				require.NoError(t, w.Close())
+
+				// The reader detects the checksum type from the footer, and
+				// verifies every block's checksum with it.
+				r, err := NewMemReader(f.Bytes(), ReaderOptions{})
+				require.NoError(t, err)
+				defer r.Close()
+				require.Equal(t, checksum, r.checksumType)
+				require.NoError(t, r.ValidateBlockChecksums())
+
+				iter, err := r.NewIter(nil, nil)
+				require.NoError(t, err)
+				i := 0
+				for k, v := iter.First(); k != nil; k, v = iter.Next() {
+					require.Equal(t, fmt.Sprintf("key%06d", i), string(k.UserKey))
+					require.Equal(t, value(i), v)
+					i++
+				}
+				require.NoError(t, iter.Close())
+				require.Equal(t, numKeys, i)
+
+				// A corrupted data block fails verification.
+				l, err := r.Layout()
+				require.NoError(t, err)
+				data := append([]byte(nil), f.Bytes()...)
+				data[l.Data[len(l.Data)/2].Offset] ^= 0xff
+				r2, err := NewMemReader(data, ReaderOptions{})
+				require.NoError(t, err)
+				defer r2.Close()
+				err = r2.ValidateBlockChecksums()
+				require.True(t, errors.Is(err, base.ErrCorruption))
+				require.Contains(t, err.Error(), "checksum mismatch")
+			})
+		}
+	}
+}
+
 // Tests for races, such as https://github.com/cockroachdb/cockroach/issues/77194,
 // in the Writer.
 func TestWriterRace(t *testing.T) {
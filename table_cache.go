@@ -9,10 +9,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"runtime/debug"
 	"runtime/pprof"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/cockroachdb/errors"
@@ -21,6 +23,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -69,8 +72,11 @@ type tableCacheOpts struct {
 	cacheID       uint64
 	dirname       string
 	fs            vfs.FS
+	objProvider   objstorage.Provider
 	opts          sstable.ReaderOptions
 	filterMetrics *FilterMetrics
+	// pinTopLevelIndexAndFilter is Options.Experimental.PinTopLevelIndexAndFilter.
+	pinTopLevelIndexAndFilter bool
 }
 
 // tableCacheContainer contains the table cache and
@@ -81,12 +87,22 @@ type tableCacheContainer struct {
 	// dbOpts contains fields relevant to the table cache
 	// which are unique to each DB.
 	dbOpts tableCacheOpts
+
+	// blobFiles holds the readers of the DB's blob files, from which the
+	// values of the BLOBSET keys surfaced by newIters are read.
+	blobFiles *blobFileCache
 }
 
 // newTableCacheContainer will panic if the underlying cache in the table cache
 // doesn't match Options.Cache.
 func newTableCacheContainer(
-	tc *TableCache, cacheID uint64, dirname string, fs vfs.FS, opts *Options, size int,
+	tc *TableCache,
+	cacheID uint64,
+	dirname string,
+	fs vfs.FS,
+	objProvider objstorage.Provider,
+	opts *Options,
+	size int,
 ) *tableCacheContainer {
 	// We will release a ref to table cache acquired here when tableCacheContainer.close is called.
 	if tc != nil {
@@ -106,9 +122,12 @@ func newTableCacheContainer(
 	t.dbOpts.cacheID = cacheID
 	t.dbOpts.dirname = dirname
 	t.dbOpts.fs = fs
+	t.dbOpts.objProvider = objProvider
 	t.dbOpts.opts = opts.MakeReaderOptions()
 	t.dbOpts.filterMetrics = &FilterMetrics{}
+	t.dbOpts.pinTopLevelIndexAndFilter = opts.Experimental.PinTopLevelIndexAndFilter
 	t.dbOpts.atomic.iterCount = new(int32)
+	t.blobFiles = newBlobFileCache(fs, dirname)
 	return t
 }
 
@@ -129,23 +148,32 @@ func (c *tableCacheContainer) close() error {
 			shard.removeDB(&c.dbOpts)
 		}
 	}
+	err = firstError(err, c.blobFiles.close())
 	return firstError(err, c.tableCache.Unref())
 }
 
 func (c *tableCacheContainer) newIters(
 	file *manifest.FileMetadata, opts *IterOptions, internalOpts internalIterOpts,
 ) (internalIterator, keyspan.FragmentIterator, error) {
-	return c.tableCache.getShard(file.FileNum).newIters(file, opts, internalOpts, &c.dbOpts)
+	iter, rangeDelIter, err := c.tableCache.getShard(file.PhysicalFileNum()).newIters(file, opts, internalOpts, &c.dbOpts)
+	if err != nil || len(file.BlobReferences) == 0 || internalOpts.compaction {
+		return iter, rangeDelIter, err
+	}
+	return &blobValueIter{
+		internalIterator: iter,
+		blobFiles:        c.blobFiles,
+		lazy:             internalOpts.lazyBlobValues,
+	}, rangeDelIter, nil
 }
 
 func (c *tableCacheContainer) newRangeKeyIter(
 	file *manifest.FileMetadata, opts *keyspan.SpanIterOptions,
 ) (keyspan.FragmentIterator, error) {
-	return c.tableCache.getShard(file.FileNum).newRangeKeyIter(file, opts, &c.dbOpts)
+	return c.tableCache.getShard(file.PhysicalFileNum()).newRangeKeyIter(file, opts, &c.dbOpts)
 }
 
 func (c *tableCacheContainer) getTableProperties(file *fileMetadata) (*sstable.Properties, error) {
-	return c.tableCache.getShard(file.FileNum).getTableProperties(file, &c.dbOpts)
+	return c.tableCache.getShard(file.PhysicalFileNum()).getTableProperties(file, &c.dbOpts)
 }
 
 func (c *tableCacheContainer) evict(fileNum FileNum) {
@@ -171,7 +199,7 @@ func (c *tableCacheContainer) metrics() (CacheMetrics, FilterMetrics) {
 }
 
 func (c *tableCacheContainer) withReader(meta *fileMetadata, fn func(*sstable.Reader) error) error {
-	s := c.tableCache.getShard(meta.FileNum)
+	s := c.tableCache.getShard(meta.PhysicalFileNum())
 	v := s.findNode(meta, &c.dbOpts)
 	defer s.unrefValue(v)
 	if v.err != nil {
@@ -388,6 +416,9 @@ func (c *tableCacheShard) newIters(
 		c.unrefValue(v)
 		return nil, nil, err
 	}
+	if rangeDelIter != nil && file.Virtual {
+		rangeDelIter = truncateToVirtualBounds(dbOpts.opts.Comparer.Compare, rangeDelIter, file)
+	}
 
 	if !ok {
 		c.unrefValue(v)
@@ -407,14 +438,31 @@ func (c *tableCacheShard) newIters(
 
 	var iter sstable.Iterator
 	useFilter := true
+	bypassCache := false
+	skipCorruptBlocks := false
+	pinIndexAndFilter := false
 	if opts != nil {
 		useFilter = manifest.LevelToInt(opts.level) != 6 || opts.UseL6Filters
+		bypassCache = opts.NoCachePollution
+		skipCorruptBlocks = opts.SkipCorruptBlocks
+		pinIndexAndFilter = dbOpts.pinTopLevelIndexAndFilter && manifest.LevelToInt(opts.level) <= 1
 	}
 	if internalOpts.bytesIterated != nil {
 		iter, err = v.reader.NewCompactionIter(internalOpts.bytesIterated)
 	} else {
-		iter, err = v.reader.NewIterWithBlockPropertyFilters(
-			opts.GetLowerBound(), opts.GetUpperBound(), filterer, useFilter)
+		if pinIndexAndFilter {
+			err = v.reader.PinIndexAndFilter()
+		}
+		if err == nil {
+			iter, err = v.reader.NewIterWithOptions(sstable.IterOptions{
+				Lower:             opts.GetLowerBound(),
+				Upper:             opts.GetUpperBound(),
+				Filterer:          filterer,
+				UseFilterBlock:    useFilter,
+				BypassCache:       bypassCache,
+				SkipCorruptBlocks: skipCorruptBlocks,
+			})
+		}
 	}
 	if err != nil {
 		if rangeDelIter != nil {
@@ -434,9 +482,29 @@ func (c *tableCacheShard) newIters(
 		c.mu.iters[iter] = debug.Stack()
 		c.mu.Unlock()
 	}
+	if file.Virtual {
+		iter = sstable.NewVirtualIter(dbOpts.opts.Comparer.Compare, iter, sstable.VirtualBounds{
+			Lower:          file.Smallest.UserKey,
+			Upper:          file.Largest.UserKey,
+			UpperExclusive: file.Largest.IsExclusiveSentinel(),
+		})
+	}
 	return iter, rangeDelIter, nil
 }
 
+// truncateToVirtualBounds truncates the spans of iter, an iterator over the
+// range deletions or range keys of the sstable backing the virtual sstable
+// file, to the bounds of the virtual sstable.
+func truncateToVirtualBounds(
+	cmp Compare, iter keyspan.FragmentIterator, file *fileMetadata,
+) keyspan.FragmentIterator {
+	var upper []byte
+	if file.Largest.IsExclusiveSentinel() {
+		upper = file.Largest.UserKey
+	}
+	return keyspan.Truncate(cmp, iter, file.Smallest.UserKey, upper, &file.Smallest, &file.Largest)
+}
+
 func (c *tableCacheShard) newRangeKeyIter(
 	file *manifest.FileMetadata, opts *keyspan.SpanIterOptions, dbOpts *tableCacheOpts,
 ) (keyspan.FragmentIterator, error) {
@@ -481,6 +549,9 @@ func (c *tableCacheShard) newRangeKeyIter(
 	if err != nil || iter == nil {
 		return nil, err
 	}
+	if file.Virtual {
+		iter = truncateToVirtualBounds(dbOpts.opts.Comparer.Compare, iter, file)
+	}
 
 	return iter, nil
 }
@@ -512,7 +583,7 @@ func (c *tableCacheShard) releaseNode(n *tableCacheNode) {
 //
 // c.mu must be held when calling this.
 func (c *tableCacheShard) unlinkNode(n *tableCacheNode) {
-	key := tableCacheKey{n.cacheID, n.meta.FileNum}
+	key := tableCacheKey{n.cacheID, n.meta.PhysicalFileNum()}
 	delete(c.mu.nodes, key)
 
 	switch n.ptype {
@@ -569,7 +640,7 @@ func (c *tableCacheShard) unrefValue(v *tableCacheValue) {
 func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *tableCacheValue {
 	// Fast-path for a hit in the cache.
 	c.mu.RLock()
-	key := tableCacheKey{dbOpts.cacheID, meta.FileNum}
+	key := tableCacheKey{dbOpts.cacheID, meta.PhysicalFileNum()}
 	if n := c.mu.nodes[key]; n != nil && n.value != nil {
 		// Fast-path hit.
 		//
@@ -657,7 +728,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 func (c *tableCacheShard) addNode(n *tableCacheNode, dbOpts *tableCacheOpts) {
 	c.evictNodes()
 	n.cacheID = dbOpts.cacheID
-	key := tableCacheKey{n.cacheID, n.meta.FileNum}
+	key := tableCacheKey{n.cacheID, n.meta.PhysicalFileNum()}
 	c.mu.nodes[key] = n
 
 	n.links.next = n
@@ -799,7 +870,7 @@ func (c *tableCacheShard) removeDB(dbOpts *tableCacheOpts) {
 		}
 
 		if node.cacheID == dbOpts.cacheID {
-			fileNums = append(fileNums, node.meta.FileNum)
+			fileNums = append(fileNums, node.meta.PhysicalFileNum())
 		}
 		node = node.next()
 	}
@@ -873,13 +944,20 @@ type tableCacheValue struct {
 
 func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *tableCacheOpts) {
 	// Try opening the fileTypeTable first.
-	var f vfs.File
-	v.filename = base.MakeFilepath(dbOpts.fs, dbOpts.dirname, fileTypeTable, meta.FileNum)
-	f, v.err = dbOpts.fs.Open(v.filename, vfs.RandomReadsOption)
+	var r objstorage.Readable
+	objMeta := dbOpts.objProvider.Lookup(meta.PhysicalFileNum())
+	v.filename = dbOpts.objProvider.Path(objMeta)
+	r, v.err = dbOpts.objProvider.Open(meta.PhysicalFileNum())
 	if v.err == nil {
-		cacheOpts := private.SSTableCacheOpts(dbOpts.cacheID, meta.FileNum).(sstable.ReaderOption)
-		reopenOpt := sstable.FileReopenOpt{FS: dbOpts.fs, Filename: v.filename}
-		v.reader, v.err = sstable.NewReader(f, dbOpts.opts, cacheOpts, dbOpts.filterMetrics, reopenOpt)
+		cacheOpts := private.SSTableCacheOpts(dbOpts.cacheID, meta.PhysicalFileNum()).(sstable.ReaderOption)
+		if f, ok := r.(*objstorage.LocalReadable); ok {
+			// Local sstables are read through their file directly, which the
+			// reader may reopen for sequential reads.
+			reopenOpt := sstable.FileReopenOpt{FS: dbOpts.fs, Filename: v.filename}
+			v.reader, v.err = sstable.NewReader(f.File, dbOpts.opts, cacheOpts, dbOpts.filterMetrics, reopenOpt)
+		} else {
+			v.reader, v.err = sstable.NewReader(readableFile{r}, dbOpts.opts, cacheOpts, dbOpts.filterMetrics)
+		}
 	}
 	if v.err == nil {
 		if meta.SmallestSeqNum == meta.LargestSeqNum {
@@ -891,7 +969,7 @@ func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *t
 		defer c.mu.Unlock()
 		// Lookup the node in the cache again as it might have already been
 		// removed.
-		key := tableCacheKey{dbOpts.cacheID, meta.FileNum}
+		key := tableCacheKey{dbOpts.cacheID, meta.PhysicalFileNum()}
 		n := c.mu.nodes[key]
 		if n != nil && n.value == v {
 			c.releaseNode(n)
@@ -977,3 +1055,27 @@ func (n *tableCacheNode) unlink() *tableCacheNode {
 	n.links.next = n
 	return next
 }
+
+// readableFile adapts an objstorage.Readable to the sstable.ReadableFile
+// interface.
+type readableFile struct {
+	objstorage.Readable
+}
+
+// Stat implements sstable.ReadableFile.
+func (f readableFile) Stat() (os.FileInfo, error) {
+	return readableFileInfo{size: f.Size()}, nil
+}
+
+// readableFileInfo is the os.FileInfo of a readableFile, describing only its
+// size.
+type readableFileInfo struct {
+	size int64
+}
+
+func (i readableFileInfo) Name() string       { return "" }
+func (i readableFileInfo) Size() int64        { return i.size }
+func (i readableFileInfo) Mode() os.FileMode  { return 0 }
+func (i readableFileInfo) ModTime() time.Time { return time.Time{} }
+func (i readableFileInfo) IsDir() bool        { return false }
+func (i readableFileInfo) Sys() interface{}   { return nil }
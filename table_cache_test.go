@@ -18,7 +18,11 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -191,7 +195,8 @@ func newTableCacheContainerTest(
 		opts.Cache = tc.cache
 	}
 
-	c := newTableCacheContainer(tc, opts.Cache.NewID(), dirname, fs, opts, tableCacheTestCacheSize)
+	objProvider := objstorage.New(objstorage.Settings{FS: fs, FSDirName: dirname})
+	c := newTableCacheContainer(tc, opts.Cache.NewID(), dirname, fs, objProvider, opts, tableCacheTestCacheSize)
 	return c, fs, nil
 }
 
@@ -882,6 +887,7 @@ func TestTableCacheClockPro(t *testing.T) {
 	dbOpts.cacheID = 0
 	dbOpts.dirname = ""
 	dbOpts.fs = mem
+	dbOpts.objProvider = objstorage.New(objstorage.Settings{FS: mem})
 	dbOpts.opts = opts.MakeReaderOptions()
 
 	scanner := bufio.NewScanner(f)
@@ -913,3 +919,113 @@ func TestTableCacheClockPro(t *testing.T) {
 		line++
 	}
 }
+
+func TestTableCachePinTopLevelIndexAndFilter(t *testing.T) {
+	for _, pin := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pin=%t", pin), func(t *testing.T) {
+			// NB: Memtables reserve their size from the cache.
+			c := cache.New(1 << 20)
+			defer c.Unref()
+			opts := &Options{
+				Cache:        c,
+				Comparer:     testkeys.Comparer,
+				FS:           vfs.NewMem(),
+				MemTableSize: 256 << 10,
+			}
+			opts.Levels = []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}}
+			opts.Experimental.PinTopLevelIndexAndFilter = pin
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			// Write enough data to L6 to fill the cache many times over.
+			value := bytes.Repeat([]byte("x"), 1<<10)
+			for i := 0; i < 4000; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("b%04d", i)), value, nil))
+			}
+			require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+			// And a small table to L0.
+			for i := 0; i < 100; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("a%03d", i)), []byte("value"), nil))
+			}
+			require.NoError(t, d.Flush())
+
+			seek := func() {
+				iter := d.NewIter(nil)
+				require.True(t, iter.SeekPrefixGE([]byte("a050")))
+				require.NoError(t, iter.Close())
+			}
+			// Reading the L0 table pins its blocks, if enabled.
+			seek()
+			if m := d.Metrics(); pin {
+				require.Greater(t, m.BlockCache.PinnedSize, int64(0))
+			} else {
+				require.Equal(t, int64(0), m.BlockCache.PinnedSize)
+			}
+
+			// Fill the cache with data blocks.
+			iter := d.NewIter(nil)
+			for iter.First(); iter.Valid(); iter.Next() {
+			}
+			require.NoError(t, iter.Close())
+
+			before := d.Metrics().BlockCache.ByType["filter"]
+			seek()
+			after := d.Metrics().BlockCache.ByType["filter"]
+			// Only the L0 table consults its filter, since L6 filters are
+			// disabled by default.
+			expected := cache.HitMiss{Misses: 1}
+			if pin {
+				expected = cache.HitMiss{Hits: 1}
+			}
+			require.Equal(t, expected, cache.HitMiss{
+				Hits:   after.Hits - before.Hits,
+				Misses: after.Misses - before.Misses,
+			})
+		})
+	}
+}
+
+func TestTableCacheTopLevelIndexCacheSize(t *testing.T) {
+	for _, size := range []int{0, 64 << 10} {
+		t.Run(fmt.Sprintf("size=%d", size), func(t *testing.T) {
+			c := cache.New(1 << 20)
+			defer c.Unref()
+			opts := &Options{
+				Cache:        c,
+				FS:           vfs.NewMem(),
+				MemTableSize: 256 << 10,
+			}
+			opts.Levels = []LevelOptions{{BlockSize: 256, IndexBlockSize: 256}}
+			opts.Experimental.TopLevelIndexCacheSize = size
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("k%04d", i)), []byte("value"), nil))
+			}
+			require.NoError(t, d.Compact([]byte("k"), []byte("l"), false))
+
+			seek := func() {
+				iter := d.NewIter(nil)
+				require.True(t, iter.SeekGE([]byte("k0500")))
+				require.NoError(t, iter.Close())
+			}
+			seek()
+			before := d.Metrics().BlockCache.ByType["index"]
+			seek()
+			after := d.Metrics().BlockCache.ByType["index"]
+			// Every seek looks up a second-level index block. The top-level
+			// index block is looked up too, unless the reader retains it.
+			expected := int64(2)
+			if size > 0 {
+				expected = 1
+			}
+			require.Equal(t, cache.HitMiss{Hits: expected}, cache.HitMiss{
+				Hits:   after.Hits - before.Hits,
+				Misses: after.Misses - before.Misses,
+			})
+		})
+	}
+}
@@ -124,7 +124,14 @@ func (d *DB) collectTableStats() bool {
 	}
 
 	maybeCompact := false
+	v := d.mu.versions.currentVersion()
 	for _, c := range collected {
+		// Count the table's keys if it's still in the current version. Tables
+		// deleted while the stats were loading were never counted, and a table
+		// whose stats are already valid was collected twice.
+		if !c.fileMetadata.Stats.Valid && c.liveIn(v, d.cmp) {
+			d.mu.versions.metrics.Keys.addTable(&c.TableStats)
+		}
 		c.fileMetadata.Stats = c.TableStats
 		maybeCompact = maybeCompact || c.fileMetadata.Stats.RangeDeletionsBytesEstimate > 0
 	}
@@ -142,7 +149,6 @@ func (d *DB) collectTableStats() bool {
 		// and clear the hint.
 		//
 		// See DB.maybeUpdateDeleteCompactionHints.
-		v := d.mu.versions.currentVersion()
 		keepHints := hints[:0]
 		for _, h := range hints {
 			if v.Contains(h.tombstoneLevel, d.cmp, h.tombstoneFile) {
@@ -162,6 +168,17 @@ type collectedStats struct {
 	manifest.TableStats
 }
 
+// liveIn returns true if the table is in any level of the version. The table
+// may have been moved since its stats were loaded.
+func (c collectedStats) liveIn(v *version, cmp Compare) bool {
+	for level := range v.Levels {
+		if v.Contains(level, cmp, c.fileMetadata) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *DB) loadNewFileStats(
 	rs *readState, pending []manifest.NewFileEntry,
 ) ([]collectedStats, []deleteCompactionHint) {
@@ -255,8 +272,7 @@ func (d *DB) loadTableStats(
 	var stats manifest.TableStats
 	var compactionHints []deleteCompactionHint
 	err := d.tableCache.withReader(meta, func(r *sstable.Reader) (err error) {
-		stats.NumEntries = r.Properties.NumEntries
-		stats.NumDeletions = r.Properties.NumDeletions
+		setKeyCountStats(&stats, &r.Properties)
 		if r.Properties.NumPointDeletions() > 0 {
 			if err = d.loadTablePointKeyStats(r, v, level, meta, &stats); err != nil {
 				return
@@ -270,12 +286,30 @@ func (d *DB) loadTableStats(
 		// TODO(travers): Once we have real-world data, consider collecting
 		// additional stats that may provide improved heuristics for compaction
 		// picking.
-		stats.NumRangeKeys = r.Properties.NumRangeKeys()
 		return
 	})
 	if err != nil {
 		return stats, nil, err
 	}
+	if meta.Virtual {
+		// The properties of the backing sstable also describe keys outside of
+		// the virtual sstable's bounds. Scale the statistics derived from them
+		// by the fraction of the backing sstable the virtual sstable occupies.
+		scale := func(v uint64) uint64 {
+			return uint64(float64(v) * float64(meta.Size) / float64(meta.FileBacking.Size))
+		}
+		stats.NumEntries = scale(stats.NumEntries)
+		stats.NumDeletions = scale(stats.NumDeletions)
+		stats.NumRangeKeys = scale(stats.NumRangeKeys)
+		stats.NumMergeOperands = scale(stats.NumMergeOperands)
+		stats.NumSingleDeletions = scale(stats.NumSingleDeletions)
+		stats.NumRangeDeletions = scale(stats.NumRangeDeletions)
+		stats.NumRangeKeySets = scale(stats.NumRangeKeySets)
+		stats.NumRangeKeyUnsets = scale(stats.NumRangeKeyUnsets)
+		stats.NumRangeKeyDels = scale(stats.NumRangeKeyDels)
+		stats.PointDeletionsBytesEstimate = scale(stats.PointDeletionsBytesEstimate)
+	}
+	stats.TombstoneDensity = tombstoneDensity(stats.NumEntries, stats.NumDeletions)
 	stats.Valid = true
 	return stats, compactionHints, nil
 }
@@ -500,11 +534,7 @@ func (d *DB) estimateReclaimedSizeBeneath(
 					// is expected to be minimal relative to point keys.
 					continue
 				}
-				var size uint64
-				err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
-					size, err = r.EstimateDiskUsage(start, end)
-					return err
-				})
+				size, err := d.estimateTableDiskUsage(file, start, end)
 				if err != nil {
 					return 0, hintSeqNum, err
 				}
@@ -552,15 +582,40 @@ func maybeSetStatsFromProperties(meta *fileMetadata, props *sstable.Properties)
 
 	meta.Stats = manifest.TableStats{
 		Valid:                       true,
-		NumEntries:                  props.NumEntries,
-		NumDeletions:                props.NumDeletions,
-		NumRangeKeys:                props.NumRangeKeys(),
 		PointDeletionsBytesEstimate: pointEstimate,
 		RangeDeletionsBytesEstimate: 0,
+		TombstoneDensity:            tombstoneDensity(props.NumEntries, props.NumDeletions),
 	}
+	setKeyCountStats(&meta.Stats, props)
 	return true
 }
 
+// setKeyCountStats populates the counts of the table's keys by kind from its
+// properties.
+func setKeyCountStats(stats *manifest.TableStats, props *sstable.Properties) {
+	stats.NumEntries = props.NumEntries
+	stats.NumDeletions = props.NumDeletions
+	stats.NumRangeKeys = props.NumRangeKeys()
+	stats.NumMergeOperands = props.NumMergeOperands
+	stats.NumSingleDeletions = props.NumSingleDeletions
+	stats.NumRangeDeletions = props.NumRangeDeletions
+	stats.NumRangeKeySets = props.NumRangeKeySets
+	stats.NumRangeKeyUnsets = props.NumRangeKeyUnsets
+	stats.NumRangeKeyDels = props.NumRangeKeyDels
+}
+
+// tombstoneDensity returns the fraction of a table's entries that are point or
+// range deletions.
+func tombstoneDensity(numEntries, numDeletions uint64) float64 {
+	if numEntries == 0 {
+		return 0
+	}
+	if numDeletions >= numEntries {
+		return 1
+	}
+	return float64(numDeletions) / float64(numEntries)
+}
+
 func pointDeletionsBytesEstimate(props *sstable.Properties, avgKeySize, avgValSize uint64) uint64 {
 	if props.NumEntries == 0 {
 		return 0
@@ -730,6 +785,9 @@ func newCombinedDeletionKeyspanIter(
 		return nil, err
 	}
 	if iter != nil {
+		if m.Virtual {
+			iter = truncateToVirtualBounds(cmp, iter, m)
+		}
 		// Wrap the range key iterator in a filter that elides keys other than range
 		// key deletions.
 		iter = keyspan.Filter(iter, func(in *keyspan.Span, out *keyspan.Span) (keep bool) {
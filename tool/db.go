@@ -28,6 +28,7 @@ type dbT struct {
 	Root       *cobra.Command
 	Check      *cobra.Command
 	Checkpoint *cobra.Command
+	Diff       *cobra.Command
 	Get        *cobra.Command
 	Logs       *cobra.Command
 	LSM        *cobra.Command
@@ -86,6 +87,17 @@ database not be in use by another process.
 		Args: cobra.ExactArgs(2),
 		Run:  d.runCheckpoint,
 	}
+	d.Diff = &cobra.Command{
+		Use:   "diff <dir-a> <dir-b>",
+		Short: "print keys differing between two DBs",
+		Long: `
+Print the keys present in only one of two DBs, or present in both with
+differing values, within the range specified by --start and --end. Requires
+that the specified databases not be in use by another process.
+`,
+		Args: cobra.ExactArgs(2),
+		Run:  d.runDiff,
+	}
 	d.Get = &cobra.Command{
 		Use:   "get <dir> <key>",
 		Short: "get value for a key",
@@ -149,26 +161,28 @@ use by another process.
 		Run:  d.runSpace,
 	}
 
-	d.Root.AddCommand(d.Check, d.Checkpoint, d.Get, d.Logs, d.LSM, d.Properties, d.Scan, d.Set, d.Space)
+	d.Root.AddCommand(d.Check, d.Checkpoint, d.Diff, d.Get, d.Logs, d.LSM, d.Properties, d.Scan, d.Set, d.Space)
 	d.Root.PersistentFlags().BoolVarP(&d.verbose, "verbose", "v", false, "verbose output")
 
-	for _, cmd := range []*cobra.Command{d.Check, d.Checkpoint, d.Get, d.LSM, d.Properties, d.Scan, d.Set, d.Space} {
+	for _, cmd := range []*cobra.Command{d.Check, d.Checkpoint, d.Diff, d.Get, d.LSM, d.Properties, d.Scan, d.Set, d.Space} {
 		cmd.Flags().StringVar(
 			&d.comparerName, "comparer", "", "comparer name (use default if empty)")
 		cmd.Flags().StringVar(
 			&d.mergerName, "merger", "", "merger name (use default if empty)")
 	}
 
-	for _, cmd := range []*cobra.Command{d.Scan, d.Space} {
+	for _, cmd := range []*cobra.Command{d.Diff, d.Scan, d.Space} {
 		cmd.Flags().Var(
 			&d.start, "start", "start key for the range")
 		cmd.Flags().Var(
 			&d.end, "end", "end key for the range")
 	}
 
-	d.Scan.Flags().Var(
-		&d.fmtKey, "key", "key formatter")
-	for _, cmd := range []*cobra.Command{d.Scan, d.Get} {
+	for _, cmd := range []*cobra.Command{d.Diff, d.Scan} {
+		cmd.Flags().Var(
+			&d.fmtKey, "key", "key formatter")
+	}
+	for _, cmd := range []*cobra.Command{d.Diff, d.Scan, d.Get} {
 		cmd.Flags().Var(
 			&d.fmtValue, "value", "value formatter")
 	}
@@ -323,6 +337,47 @@ func (d *dbT) runCheckpoint(cmd *cobra.Command, args []string) {
 	}
 }
 
+func (d *dbT) runDiff(cmd *cobra.Command, args []string) {
+	dbA, err := d.openDB(args[0])
+	if err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+		return
+	}
+	defer d.closeDB(dbA)
+	dbB, err := d.openDB(args[1])
+	if err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+		return
+	}
+	defer d.closeDB(dbB)
+
+	// Update the internal formatter if this comparator has one specified.
+	if d.opts.Comparer != nil {
+		d.fmtKey.setForComparer(d.opts.Comparer.Name, d.comparers)
+		d.fmtValue.setForComparer(d.opts.Comparer.Name, d.comparers)
+	}
+
+	diff := newDiff(dbA, dbB, d.opts.Comparer, d.start, d.end)
+	var count int64
+	for diff.Next() {
+		e := diff.Entry()
+		switch e.Kind {
+		case DiffOnlyInA:
+			fmt.Fprintf(stdout, "%s %s %s\n", e.Kind, d.fmtKey.fn(e.Key), d.fmtValue.fn(e.Key, e.ValueA))
+		case DiffOnlyInB:
+			fmt.Fprintf(stdout, "%s %s %s\n", e.Kind, d.fmtKey.fn(e.Key), d.fmtValue.fn(e.Key, e.ValueB))
+		default:
+			fmt.Fprintf(stdout, "%s %s %s %s\n", e.Kind, d.fmtKey.fn(e.Key),
+				d.fmtValue.fn(e.Key, e.ValueA), d.fmtValue.fn(e.Key, e.ValueB))
+		}
+		count++
+	}
+	if err := diff.Close(); err != nil {
+		fmt.Fprintf(stdout, "%s\n", err)
+	}
+	fmt.Fprintf(stdout, "found %d %s\n", count, makePlural("difference", count))
+}
+
 func (d *dbT) runGet(cmd *cobra.Command, args []string) {
 	db, err := d.openDB(args[0])
 	if err != nil {
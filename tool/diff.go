@@ -0,0 +1,208 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package tool
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// DiffKind identifies the way in which a key differs between two DBs.
+type DiffKind int
+
+const (
+	// DiffOnlyInA indicates the key is only present in the first DB.
+	DiffOnlyInA DiffKind = iota
+	// DiffOnlyInB indicates the key is only present in the second DB.
+	DiffOnlyInB
+	// DiffValue indicates the key is present in both DBs, with different
+	// values.
+	DiffValue
+)
+
+// String implements fmt.Stringer.
+func (k DiffKind) String() string {
+	switch k {
+	case DiffOnlyInA:
+		return "only-in-a"
+	case DiffOnlyInB:
+		return "only-in-b"
+	case DiffValue:
+		return "value"
+	}
+	return "unknown"
+}
+
+// DiffEntry describes a key that differs between two DBs.
+type DiffEntry struct {
+	Kind DiffKind
+	Key  []byte
+	// ValueA and ValueB hold the key's value in the first and second DBs
+	// respectively, and are nil if the key is absent from the DB.
+	ValueA []byte
+	ValueB []byte
+}
+
+// DiffOptions configures DiffDBs.
+type DiffOptions struct {
+	// Options, if non-nil, are used to open both DBs. The DBs are always
+	// opened read-only.
+	Options *pebble.Options
+	// LowerBound and UpperBound, if non-nil, restrict the diff to the keys
+	// within [LowerBound, UpperBound).
+	LowerBound []byte
+	UpperBound []byte
+}
+
+// Diff streams the keys that differ between two DBs, in key order. The DBs
+// are scanned in lockstep, so a Diff holds no more than one key and value
+// from each DB in memory regardless of their size.
+//
+//	diff, err := DiffDBs(a, b, nil)
+//	...
+//	for diff.Next() {
+//	  e := diff.Entry()
+//	  ...
+//	}
+//	err = diff.Close()
+type Diff struct {
+	cmp            pebble.Compare
+	dbs            [2]*pebble.DB
+	iters          [2]*pebble.Iterator
+	valid          [2]bool
+	started        bool
+	entry          DiffEntry
+	closeDBsOnDone bool
+}
+
+// DiffDBs opens the DBs in the directories a and b, and returns a Diff of
+// their keys. The DBs are closed when the Diff is closed.
+func DiffDBs(a, b string, opts *DiffOptions) (*Diff, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	var dbOpts pebble.Options
+	if opts.Options != nil {
+		dbOpts = *opts.Options
+	}
+	dbOpts.ReadOnly = true
+
+	dbA, err := pebble.Open(a, &dbOpts)
+	if err != nil {
+		return nil, err
+	}
+	dbB, err := pebble.Open(b, &dbOpts)
+	if err != nil {
+		_ = dbA.Close()
+		return nil, err
+	}
+	d := newDiff(dbA, dbB, dbOpts.Comparer, opts.LowerBound, opts.UpperBound)
+	d.closeDBsOnDone = true
+	return d, nil
+}
+
+func newDiff(a, b *pebble.DB, cmp *pebble.Comparer, lower, upper []byte) *Diff {
+	if cmp == nil {
+		cmp = pebble.DefaultComparer
+	}
+	d := &Diff{cmp: cmp.Compare, dbs: [2]*pebble.DB{a, b}}
+	iterOpts := &pebble.IterOptions{LowerBound: lower, UpperBound: upper}
+	for i := range d.dbs {
+		d.iters[i] = d.dbs[i].NewIter(iterOpts)
+	}
+	return d
+}
+
+// Next advances the Diff to the next key that differs between the DBs,
+// returning false once no keys remain or an error is encountered.
+func (d *Diff) Next() bool {
+	if !d.started {
+		d.started = true
+		for i := range d.iters {
+			d.valid[i] = d.iters[i].First()
+		}
+	} else {
+		d.advance(d.entry.Kind)
+	}
+	for {
+		if !d.valid[0] && !d.valid[1] {
+			return false
+		}
+
+		var c int
+		switch {
+		case !d.valid[1]:
+			c = -1
+		case !d.valid[0]:
+			c = +1
+		default:
+			c = d.cmp(d.iters[0].Key(), d.iters[1].Key())
+		}
+		switch {
+		case c < 0:
+			d.entry = DiffEntry{Kind: DiffOnlyInA, Key: d.iters[0].Key(), ValueA: d.iters[0].Value()}
+		case c > 0:
+			d.entry = DiffEntry{Kind: DiffOnlyInB, Key: d.iters[1].Key(), ValueB: d.iters[1].Value()}
+		default:
+			valueA, valueB := d.iters[0].Value(), d.iters[1].Value()
+			if bytes.Equal(valueA, valueB) {
+				d.advance(DiffValue)
+				continue
+			}
+			d.entry = DiffEntry{Kind: DiffValue, Key: d.iters[0].Key(), ValueA: valueA, ValueB: valueB}
+		}
+		return true
+	}
+}
+
+// advance steps past the current key in whichever DBs contain it, as
+// indicated by kind.
+func (d *Diff) advance(kind DiffKind) {
+	switch kind {
+	case DiffOnlyInA:
+		d.valid[0] = d.iters[0].Next()
+	case DiffOnlyInB:
+		d.valid[1] = d.iters[1].Next()
+	default:
+		d.valid[0] = d.iters[0].Next()
+		d.valid[1] = d.iters[1].Next()
+	}
+}
+
+// Entry returns the current difference. The entry's key and values are only
+// valid until the next call to Next.
+func (d *Diff) Entry() DiffEntry {
+	return d.entry
+}
+
+// Error returns any error encountered while scanning the DBs.
+func (d *Diff) Error() error {
+	for i := range d.iters {
+		if err := d.iters[i].Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the Diff's iterators, closing the DBs if they were opened
+// by DiffDBs. It returns any error encountered while scanning the DBs.
+func (d *Diff) Close() error {
+	var err error
+	for i := range d.iters {
+		if cerr := d.iters[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	if d.closeDBsOnDone {
+		for i := range d.dbs {
+			if cerr := d.dbs[i].Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
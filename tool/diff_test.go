@@ -0,0 +1,62 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package tool
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffDBs(t *testing.T) {
+	mem := vfs.NewMem()
+	write := func(dir string, fn func(db *pebble.DB)) {
+		db, err := pebble.Open(dir, &pebble.Options{FS: mem})
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			k := []byte(fmt.Sprintf("key%03d", i))
+			require.NoError(t, db.Set(k, k, nil))
+		}
+		fn(db)
+		require.NoError(t, db.Flush())
+		require.NoError(t, db.Close())
+	}
+	write("a", func(db *pebble.DB) {
+		require.NoError(t, db.Set([]byte("key050a"), []byte("x"), nil))
+		require.NoError(t, db.Set([]byte("key070"), []byte("a"), nil))
+	})
+	write("b", func(db *pebble.DB) {
+		require.NoError(t, db.Delete([]byte("key010"), nil))
+		require.NoError(t, db.Set([]byte("key070"), []byte("b"), nil))
+		require.NoError(t, db.Set([]byte("key999"), []byte("y"), nil))
+	})
+
+	diff := func(opts *DiffOptions) []string {
+		opts.Options = &pebble.Options{FS: mem}
+		d, err := DiffDBs("a", "b", opts)
+		require.NoError(t, err)
+		var res []string
+		for d.Next() {
+			e := d.Entry()
+			res = append(res, fmt.Sprintf("%s %s %q %q", e.Kind, e.Key, e.ValueA, e.ValueB))
+		}
+		require.NoError(t, d.Close())
+		return res
+	}
+
+	require.Equal(t, []string{
+		`only-in-a key010 "key010" ""`,
+		`only-in-a key050a "x" ""`,
+		`value key070 "a" "b"`,
+		`only-in-b key999 "" "y"`,
+	}, diff(&DiffOptions{}))
+
+	require.Equal(t, []string{
+		`only-in-a key050a "x" ""`,
+	}, diff(&DiffOptions{LowerBound: []byte("key020"), UpperBound: []byte("key070")}))
+}
@@ -181,6 +181,10 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 					empty = false
 					fmt.Fprintf(stdout, "  next-file-num: %d\n", ve.NextFileNum)
 				}
+				for _, n := range ve.FailedOverLogNums {
+					empty = false
+					fmt.Fprintf(stdout, "  failed-over-log-num: %d\n", n)
+				}
 				if ve.LastSeqNum != 0 {
 					empty = false
 					fmt.Fprintf(stdout, "  last-seq-num:  %d\n", ve.LastSeqNum)
@@ -56,6 +56,11 @@ type versionSet struct {
 		// commitPipeline.
 		visibleSeqNum uint64 // visible seqNum (<= logSeqNum)
 
+		// The upper bound on sequence numbers that are guaranteed to have been
+		// synced to the WAL. Ratcheted upwards by the commitPipeline as synced
+		// batches commit.
+		syncedSeqNum uint64 // synced seqNum (<= visibleSeqNum)
+
 		// Number of bytes present in sstables being written by in-progress
 		// compactions. This value will be zero if there are no in-progress
 		// compactions. Updated and read atomically.
@@ -87,15 +92,32 @@ type versionSet struct {
 	obsoleteTables    []*manifest.FileMetadata
 	obsoleteManifests []fileInfo
 	obsoleteOptions   []fileInfo
+	obsoleteBlobFiles []fileInfo
 
 	// Zombie tables which have been removed from the current version but are
 	// still referenced by an inuse iterator.
 	zombieTables map[FileNum]uint64 // filenum -> size
 
+	// fileBackings holds the physical sstables backing virtual sstables, keyed
+	// by file number. A physical sstable is present from the moment it's first
+	// used to back a virtual sstable until it's no longer referenced by any
+	// file, virtual or physical, of any version.
+	fileBackings map[FileNum]*manifest.FileBacking
+
+	// blobFiles holds the blob files referenced by the sstables of any
+	// version, keyed by file number. A blob file becomes obsolete once it's no
+	// longer referenced by any sstable.
+	blobFiles map[FileNum]*blobFileRefs
+
 	// minUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	minUnflushedLogNum FileNum
 
+	// failedOverLogNums holds the file numbers of the unflushed WALs that were
+	// abandoned by WAL failover (see versionEdit.FailedOverLogNums). They're
+	// carried forward into each new MANIFEST until they're flushed.
+	failedOverLogNums []FileNum
+
 	// The next file number. A single counter is used to assign file numbers
 	// for the WAL, MANIFEST, sstable, and OPTIONS files.
 	nextFileNum FileNum
@@ -130,6 +152,8 @@ func (vs *versionSet) init(
 	vs.versions.Init(mu)
 	vs.obsoleteFn = vs.addObsoleteLocked
 	vs.zombieTables = make(map[FileNum]uint64)
+	vs.fileBackings = make(map[FileNum]*manifest.FileBacking)
+	vs.blobFiles = make(map[FileNum]*blobFileRefs)
 	vs.nextFileNum = 1
 	vs.manifestMarker = marker
 	vs.setCurrent = setCurrent
@@ -157,7 +181,7 @@ func (vs *versionSet) create(
 	// Note that a "snapshot" version edit is written to the manifest when it is
 	// created.
 	vs.manifestFileNum = vs.getNextFileNum()
-	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum)
+	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum, nil /* failedOverLogNums */)
 	if err == nil {
 		if err = vs.manifest.Flush(); err != nil {
 			vs.opts.Logger.Fatalf("MANIFEST flush failed: %v", err)
@@ -244,6 +268,7 @@ func (vs *versionSet) load(
 		if ve.MinUnflushedLogNum != 0 {
 			vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 		}
+		vs.failedOverLogNums = append(vs.failedOverLogNums, ve.FailedOverLogNums...)
 		if ve.NextFileNum != 0 {
 			vs.nextFileNum = ve.NextFileNum
 		}
@@ -274,6 +299,7 @@ func (vs *versionSet) load(
 		}
 	}
 	vs.markFileNumUsed(vs.minUnflushedLogNum)
+	vs.pruneFailedOverLogNums()
 
 	newVersion, _, err := bve.Apply(nil, vs.cmp, opts.Comparer.FormatKey, opts.FlushSplitBytes, opts.Experimental.ReadCompactionRate)
 	if err != nil {
@@ -281,6 +307,13 @@ func (vs *versionSet) load(
 	}
 	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
 	vs.append(newVersion)
+	vs.initFileBackings(newVersion)
+	for _, lm := range newVersion.Levels {
+		iter := lm.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			vs.refBlobFilesLocked(f)
+		}
+	}
 
 	for i := range vs.metrics.Levels {
 		l := &vs.metrics.Levels[i]
@@ -404,6 +437,7 @@ func (vs *versionSet) logAndApply(
 	// to be called.
 	minUnflushedLogNum := vs.minUnflushedLogNum
 	nextFileNum := vs.nextFileNum
+	failedOverLogNums := append([]FileNum(nil), vs.failedOverLogNums...)
 
 	var zombies map[FileNum]uint64
 	if err := func() error {
@@ -422,7 +456,7 @@ func (vs *versionSet) logAndApply(
 		}
 
 		if newManifestFileNum != 0 {
-			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum); err != nil {
+			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum, failedOverLogNums); err != nil {
 				vs.opts.EventListener.ManifestCreated(ManifestCreateInfo{
 					JobID:   jobID,
 					Path:    base.MakeFilepath(vs.fs, vs.dirname, fileTypeManifest, newManifestFileNum),
@@ -482,16 +516,32 @@ func (vs *versionSet) logAndApply(
 
 	// Update the zombie tables set first, as installation of the new version
 	// will unref the previous version which could result in addObsoleteLocked
-	// being called.
+	// being called. Similarly, reference the blob files of the new sstables
+	// before their references from the obsolete sstables are released. An
+	// sstable that's moved between levels retains its references.
 	for fileNum, size := range zombies {
 		vs.zombieTables[fileNum] = size
 	}
+	for _, nf := range ve.NewFiles {
+		moved := false
+		for df := range ve.DeletedFiles {
+			if df.FileNum == nf.Meta.FileNum {
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			vs.refBlobFilesLocked(nf.Meta)
+		}
+	}
 
 	// Install the new version.
 	vs.append(newVersion)
 	if ve.MinUnflushedLogNum != 0 {
 		vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 	}
+	vs.failedOverLogNums = append(vs.failedOverLogNums, ve.FailedOverLogNums...)
+	vs.pruneFailedOverLogNums()
 	if newManifestFileNum != 0 {
 		if vs.manifestFileNum != 0 {
 			vs.obsoleteManifests = append(vs.obsoleteManifests, fileInfo{
@@ -505,6 +555,33 @@ func (vs *versionSet) logAndApply(
 	for level, update := range metrics {
 		vs.metrics.Levels[level].Add(update)
 	}
+	// Update the key counts of the tables with loaded stats. A moved table is
+	// both deleted and added, leaving its counts unchanged. Tables whose stats
+	// are loaded later are counted by collectTableStats.
+	for _, f := range ve.DeletedFiles {
+		if f != nil && f.Stats.Valid {
+			vs.metrics.Keys.removeTable(&f.Stats)
+		}
+	}
+	for _, nf := range ve.NewFiles {
+		if nf.Meta.Stats.Valid {
+			vs.metrics.Keys.addTable(&nf.Meta.Stats)
+		}
+	}
+	if invariants.Enabled {
+		var keys KeyMetrics
+		for _, lm := range newVersion.Levels {
+			iter := lm.Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				if f.Stats.Valid {
+					keys.addTable(&f.Stats)
+				}
+			}
+		}
+		if keys != vs.metrics.Keys {
+			vs.opts.Logger.Fatalf("versionSet metrics Keys = %+v, actual counts = %+v", vs.metrics.Keys, keys)
+		}
+	}
 	for i := range vs.metrics.Levels {
 		l := &vs.metrics.Levels[i]
 		l.Sublevels = 0
@@ -530,47 +607,93 @@ func (vs *versionSet) logAndApply(
 	return nil
 }
 
-func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*compactionLevel) {
+func (vs *versionSet) incrementCompactions(
+	kind CompactionKind, extraLevels []*compactionLevel, levelMetrics map[int]*LevelMetrics,
+) {
+	if kind != CompactionKindFlush {
+		if vs.metrics.Compact.ByKind == nil {
+			vs.metrics.Compact.ByKind = make(map[CompactionKind]CompactionKindMetrics)
+		}
+		m := vs.metrics.Compact.ByKind[kind]
+		m.Count++
+		for _, l := range levelMetrics {
+			m.BytesRead += l.BytesRead
+			m.BytesWritten += l.BytesCompacted
+			m.BytesMoved += l.BytesMoved
+		}
+		vs.metrics.Compact.ByKind[kind] = m
+	}
+
 	switch kind {
-	case compactionKindDefault:
+	case CompactionKindDefault:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.DefaultCount++
 
-	case compactionKindFlush:
+	case CompactionKindFlush:
 		vs.metrics.Flush.Count++
 
-	case compactionKindMove:
+	case CompactionKindMove:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.MoveCount++
 
-	case compactionKindDeleteOnly:
+	case CompactionKindDeleteOnly:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.DeleteOnlyCount++
 
-	case compactionKindElisionOnly:
+	case CompactionKindElisionOnly:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.ElisionOnlyCount++
 
-	case compactionKindRead:
+	case CompactionKindRead:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.ReadCount++
 
-	case compactionKindRewrite:
+	case CompactionKindRewrite:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.RewriteCount++
+
+	case CompactionKindTombstoneDensity:
+		vs.metrics.Compact.Count++
+
+	case CompactionKindFilterRewrite:
+		vs.metrics.Compact.Count++
 	}
 	if len(extraLevels) > 0 {
 		vs.metrics.Compact.MultiLevelCount++
 	}
 }
 
+// pruneFailedOverLogNums forgets the abandoned WALs that have been flushed,
+// and are no longer needed for recovery.
+func (vs *versionSet) pruneFailedOverLogNums() {
+	n := 0
+	for _, fileNum := range vs.failedOverLogNums {
+		if fileNum >= vs.minUnflushedLogNum {
+			vs.failedOverLogNums[n] = fileNum
+			n++
+		}
+	}
+	vs.failedOverLogNums = vs.failedOverLogNums[:n]
+}
+
+// isFailedOverLog returns true if the WAL with the given file number was
+// abandoned by WAL failover.
+func (vs *versionSet) isFailedOverLog(fileNum FileNum) bool {
+	for _, n := range vs.failedOverLogNums {
+		if n == fileNum {
+			return true
+		}
+	}
+	return false
+}
+
 func (vs *versionSet) incrementCompactionBytes(numBytes int64) {
 	atomic.AddInt64(&vs.atomic.atomicInProgressBytes, numBytes)
 }
 
 // createManifest creates a manifest file that contains a snapshot of vs.
 func (vs *versionSet) createManifest(
-	dirname string, fileNum, minUnflushedLogNum, nextFileNum FileNum,
+	dirname string, fileNum, minUnflushedLogNum, nextFileNum FileNum, failedOverLogNums []FileNum,
 ) (err error) {
 	var (
 		filename     = base.MakeFilepath(vs.fs, dirname, fileTypeManifest, fileNum)
@@ -615,6 +738,7 @@ func (vs *versionSet) createManifest(
 	// VersionEdit that had those fields).
 	snapshot.MinUnflushedLogNum = minUnflushedLogNum
 	snapshot.NextFileNum = nextFileNum
+	snapshot.FailedOverLogNums = failedOverLogNums
 
 	w, err1 := manifest.Next()
 	if err1 != nil {
@@ -674,7 +798,7 @@ func (vs *versionSet) addLiveFileNums(m map[FileNum]struct{}) {
 		for _, lm := range v.Levels {
 			iter := lm.Iter()
 			for f := iter.First(); f != nil; f = iter.Next() {
-				m[f.FileNum] = struct{}{}
+				m[f.PhysicalFileNum()] = struct{}{}
 			}
 		}
 		if v == current {
@@ -683,17 +807,117 @@ func (vs *versionSet) addLiveFileNums(m map[FileNum]struct{}) {
 	}
 }
 
+// initFileBackings populates the file backings from the virtual sstables of
+// v, the version loaded from the manifest. Virtual sstables sharing a backing
+// sstable are decoded with distinct FileBackings, which are deduplicated here.
+func (vs *versionSet) initFileBackings(v *version) {
+	for _, lm := range v.Levels {
+		iter := lm.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if !f.Virtual {
+				continue
+			}
+			b, ok := vs.fileBackings[f.FileBacking.FileNum]
+			if !ok {
+				b = f.FileBacking
+				vs.fileBackings[b.FileNum] = b
+			}
+			f.FileBacking = b
+			b.Ref()
+		}
+	}
+}
+
+// fileBackingLocked returns the FileBacking for the physical sstable
+// containing the keys of f, for use by a new virtual sstable. If f is a
+// physical sstable not yet backing any virtual sstables, a FileBacking
+// referenced by f is created. The caller is responsible for referencing the
+// returned FileBacking on behalf of each virtual sstable it creates.
+//
+// DB.mu must be held when calling this.
+func (vs *versionSet) fileBackingLocked(f *manifest.FileMetadata) *manifest.FileBacking {
+	if b, ok := vs.fileBackings[f.PhysicalFileNum()]; ok {
+		return b
+	}
+	b := &manifest.FileBacking{FileNum: f.FileNum, Size: f.Size}
+	b.Ref()
+	vs.fileBackings[b.FileNum] = b
+	return b
+}
+
+// blobFileRefs tracks the sstables referencing a blob file.
+type blobFileRefs struct {
+	size uint64
+	refs int
+}
+
+// refBlobFilesLocked references the blob files referenced by f, an sstable
+// being added to the LSM.
+//
+// DB.mu must be held when calling this.
+func (vs *versionSet) refBlobFilesLocked(f *manifest.FileMetadata) {
+	for _, ref := range f.BlobReferences {
+		b, ok := vs.blobFiles[ref.FileNum]
+		if !ok {
+			b = &blobFileRefs{size: ref.Size}
+			vs.blobFiles[ref.FileNum] = b
+		}
+		b.refs++
+	}
+}
+
+// unrefBlobFilesLocked releases the references of f, an obsolete sstable, to
+// its blob files. Blob files that are no longer referenced become obsolete.
+//
+// DB.mu must be held when calling this.
+func (vs *versionSet) unrefBlobFilesLocked(f *manifest.FileMetadata) {
+	for _, ref := range f.BlobReferences {
+		b, ok := vs.blobFiles[ref.FileNum]
+		if !ok {
+			vs.opts.Logger.Fatalf("MANIFEST blob file %s referenced by %s not tracked", ref.FileNum, f.FileNum)
+		}
+		if b.refs--; b.refs > 0 {
+			continue
+		}
+		delete(vs.blobFiles, ref.FileNum)
+		vs.obsoleteBlobFiles = append(vs.obsoleteBlobFiles, fileInfo{
+			fileNum:  ref.FileNum,
+			fileSize: b.size,
+		})
+	}
+}
+
 func (vs *versionSet) addObsoleteLocked(obsolete []*manifest.FileMetadata) {
+	obsoletePhysical := obsolete[:0]
 	for _, fileMeta := range obsolete {
+		vs.unrefBlobFilesLocked(fileMeta)
 		// Note that the obsolete tables are no longer zombie by the definition of
 		// zombie, but we leave them in the zombie tables map until they are
 		// deleted from disk.
 		if _, ok := vs.zombieTables[fileMeta.FileNum]; !ok {
 			vs.opts.Logger.Fatalf("MANIFEST obsolete table %s not marked as zombie", fileMeta.FileNum)
 		}
+		b, ok := vs.fileBackings[fileMeta.PhysicalFileNum()]
+		if !ok {
+			obsoletePhysical = append(obsoletePhysical, fileMeta)
+			continue
+		}
+		// The file is, or is backed by, a physical sstable that backs virtual
+		// sstables. The physical sstable only becomes obsolete once it's no
+		// longer referenced by any file.
+		delete(vs.zombieTables, fileMeta.FileNum)
+		if b.Unref() > 0 {
+			continue
+		}
+		delete(vs.fileBackings, b.FileNum)
+		vs.zombieTables[b.FileNum] = b.Size
+		obsoletePhysical = append(obsoletePhysical, &manifest.FileMetadata{
+			FileNum: b.FileNum,
+			Size:    b.Size,
+		})
 	}
-	vs.obsoleteTables = append(vs.obsoleteTables, obsolete...)
-	vs.incrementObsoleteTablesLocked(obsolete)
+	vs.obsoleteTables = append(vs.obsoleteTables, obsoletePhysical...)
+	vs.incrementObsoleteTablesLocked(obsoletePhysical)
 }
 
 func (vs *versionSet) incrementObsoleteTablesLocked(obsolete []*manifest.FileMetadata) {
@@ -0,0 +1,218 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyOp identifies a class of filesystem operations into which a
+// LatencyFS may inject latency.
+type LatencyOp int
+
+const (
+	// LatencyOpWrite is File.Write.
+	LatencyOpWrite LatencyOp = iota
+	// LatencyOpSync is File.Sync, on both files and directories.
+	LatencyOpSync
+	// LatencyOpOpen is FS.Create, FS.Open, FS.OpenDir and FS.ReuseForWrite.
+	LatencyOpOpen
+	numLatencyOps
+)
+
+// String implements fmt.Stringer.
+func (op LatencyOp) String() string {
+	switch op {
+	case LatencyOpWrite:
+		return "write"
+	case LatencyOpSync:
+		return "sync"
+	case LatencyOpOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// LatencyConfig configures the latency injected by a LatencyFS.
+type LatencyConfig struct {
+	// Write, Sync and Open are the delays injected before each operation of
+	// the corresponding LatencyOp. A zero duration injects no delay.
+	Write time.Duration
+	Sync  time.Duration
+	Open  time.Duration
+	// Filter, if non-nil, restricts the injected latency to operations on
+	// files whose path Filter returns true for. The path of a file is the name
+	// with which it was created or opened.
+	Filter func(path string) bool
+}
+
+// LatencyFS wraps an FS, injecting configurable latency into write, sync and
+// open operations. The delays may be changed at runtime through SetDelay,
+// allowing tests to simulate a disk that becomes slow and later recovers.
+type LatencyFS struct {
+	inner  FS
+	filter func(path string) bool
+	// delays holds the current delay, in nanoseconds, of each LatencyOp. It
+	// is accessed atomically.
+	delays [numLatencyOps]int64
+	// sleep is time.Sleep, overridable by tests.
+	sleep func(time.Duration)
+}
+
+var _ FS = (*LatencyFS)(nil)
+
+// NewLatencyFS wraps the provided FS with an FS that injects the latency
+// configured by cfg.
+func NewLatencyFS(inner FS, cfg LatencyConfig) *LatencyFS {
+	fs := &LatencyFS{inner: inner, filter: cfg.Filter, sleep: time.Sleep}
+	fs.delays[LatencyOpWrite] = int64(cfg.Write)
+	fs.delays[LatencyOpSync] = int64(cfg.Sync)
+	fs.delays[LatencyOpOpen] = int64(cfg.Open)
+	return fs
+}
+
+// SetDelay sets the delay injected before each operation of the provided
+// LatencyOp. A zero duration disables injection for the operation. SetDelay
+// may be called concurrently with filesystem operations, and takes effect for
+// operations beginning after it returns, including those on files that are
+// already open.
+func (fs *LatencyFS) SetDelay(op LatencyOp, d time.Duration) {
+	atomic.StoreInt64(&fs.delays[op], int64(d))
+}
+
+// Delay returns the delay currently injected before each operation of the
+// provided LatencyOp.
+func (fs *LatencyFS) Delay(op LatencyOp) time.Duration {
+	return time.Duration(atomic.LoadInt64(&fs.delays[op]))
+}
+
+// Unwrap returns the underlying FS. This may be called by vfs.Root to access
+// the underlying filesystem.
+func (fs *LatencyFS) Unwrap() FS {
+	return fs.inner
+}
+
+func (fs *LatencyFS) maybeDelay(op LatencyOp, path string) {
+	d := atomic.LoadInt64(&fs.delays[op])
+	if d <= 0 || (fs.filter != nil && !fs.filter(path)) {
+		return
+	}
+	fs.sleep(time.Duration(d))
+}
+
+func (fs *LatencyFS) wrap(f File, path string) File {
+	if f == nil {
+		return nil
+	}
+	return WithFd(f, &latencyFile{File: f, fs: fs, path: path})
+}
+
+// Create implements FS.Create.
+func (fs *LatencyFS) Create(name string) (File, error) {
+	fs.maybeDelay(LatencyOpOpen, name)
+	f, err := fs.inner.Create(name)
+	return fs.wrap(f, name), err
+}
+
+// Link implements FS.Link.
+func (fs *LatencyFS) Link(oldname, newname string) error {
+	return fs.inner.Link(oldname, newname)
+}
+
+// Open implements FS.Open.
+func (fs *LatencyFS) Open(name string, opts ...OpenOption) (File, error) {
+	fs.maybeDelay(LatencyOpOpen, name)
+	f, err := fs.inner.Open(name, opts...)
+	return fs.wrap(f, name), err
+}
+
+// OpenDir implements FS.OpenDir.
+func (fs *LatencyFS) OpenDir(name string) (File, error) {
+	fs.maybeDelay(LatencyOpOpen, name)
+	f, err := fs.inner.OpenDir(name)
+	return fs.wrap(f, name), err
+}
+
+// Remove implements FS.Remove.
+func (fs *LatencyFS) Remove(name string) error {
+	return fs.inner.Remove(name)
+}
+
+// RemoveAll implements FS.RemoveAll.
+func (fs *LatencyFS) RemoveAll(name string) error {
+	return fs.inner.RemoveAll(name)
+}
+
+// Rename implements FS.Rename.
+func (fs *LatencyFS) Rename(oldname, newname string) error {
+	return fs.inner.Rename(oldname, newname)
+}
+
+// ReuseForWrite implements FS.ReuseForWrite.
+func (fs *LatencyFS) ReuseForWrite(oldname, newname string) (File, error) {
+	fs.maybeDelay(LatencyOpOpen, newname)
+	f, err := fs.inner.ReuseForWrite(oldname, newname)
+	return fs.wrap(f, newname), err
+}
+
+// MkdirAll implements FS.MkdirAll.
+func (fs *LatencyFS) MkdirAll(dir string, perm os.FileMode) error {
+	return fs.inner.MkdirAll(dir, perm)
+}
+
+// Lock implements FS.Lock.
+func (fs *LatencyFS) Lock(name string) (io.Closer, error) {
+	return fs.inner.Lock(name)
+}
+
+// List implements FS.List.
+func (fs *LatencyFS) List(dir string) ([]string, error) {
+	return fs.inner.List(dir)
+}
+
+// Stat implements FS.Stat.
+func (fs *LatencyFS) Stat(name string) (os.FileInfo, error) {
+	return fs.inner.Stat(name)
+}
+
+// PathBase implements FS.PathBase.
+func (fs *LatencyFS) PathBase(path string) string {
+	return fs.inner.PathBase(path)
+}
+
+// PathJoin implements FS.PathJoin.
+func (fs *LatencyFS) PathJoin(elem ...string) string {
+	return fs.inner.PathJoin(elem...)
+}
+
+// PathDir implements FS.PathDir.
+func (fs *LatencyFS) PathDir(path string) string {
+	return fs.inner.PathDir(path)
+}
+
+// GetDiskUsage implements FS.GetDiskUsage.
+func (fs *LatencyFS) GetDiskUsage(path string) (DiskUsage, error) {
+	return fs.inner.GetDiskUsage(path)
+}
+
+type latencyFile struct {
+	File
+	fs   *LatencyFS
+	path string
+}
+
+func (f *latencyFile) Write(p []byte) (int, error) {
+	f.fs.maybeDelay(LatencyOpWrite, f.path)
+	return f.File.Write(p)
+}
+
+func (f *latencyFile) Sync() error {
+	f.fs.maybeDelay(LatencyOpSync, f.path)
+	return f.File.Sync()
+}
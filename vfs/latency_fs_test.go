@@ -0,0 +1,128 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyFS(t *testing.T) {
+	fs := NewLatencyFS(NewMem(), LatencyConfig{
+		Write: 1 * time.Millisecond,
+		Sync:  2 * time.Millisecond,
+		Open:  3 * time.Millisecond,
+	})
+	var buf strings.Builder
+	fs.sleep = func(d time.Duration) {
+		fmt.Fprintf(&buf, "sleep %s\n", d)
+	}
+	expect := func(op, want string) {
+		t.Helper()
+		require.Equal(t, want, buf.String(), op)
+		buf.Reset()
+	}
+
+	require.NoError(t, fs.MkdirAll("dir", 0755))
+	expect("mkdir", "")
+	f, err := fs.Create("dir/foo")
+	require.NoError(t, err)
+	expect("create", "sleep 3ms\n")
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	expect("write", "sleep 1ms\n")
+	require.NoError(t, f.Sync())
+	expect("sync", "sleep 2ms\n")
+	require.NoError(t, f.Close())
+	expect("close", "")
+
+	require.NoError(t, fs.Rename("dir/foo", "dir/bar"))
+	expect("rename", "")
+	fi, err := fs.Stat("dir/bar")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), fi.Size())
+	expect("stat", "")
+	ls, err := fs.List("dir")
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar"}, ls)
+	expect("list", "")
+
+	f, err = fs.Open("dir/bar")
+	require.NoError(t, err)
+	expect("open", "sleep 3ms\n")
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	expect("read", "")
+	require.NoError(t, f.Close())
+
+	d, err := fs.OpenDir("dir")
+	require.NoError(t, err)
+	expect("open-dir", "sleep 3ms\n")
+	require.NoError(t, d.Sync())
+	expect("sync-dir", "sleep 2ms\n")
+	require.NoError(t, d.Close())
+
+	// Disabling a delay takes effect for files that are already open.
+	f, err = fs.ReuseForWrite("dir/bar", "dir/baz")
+	require.NoError(t, err)
+	expect("reuse-for-write", "sleep 3ms\n")
+	fs.SetDelay(LatencyOpWrite, 0)
+	require.Equal(t, time.Duration(0), fs.Delay(LatencyOpWrite))
+	_, err = f.Write([]byte("world"))
+	require.NoError(t, err)
+	expect("write", "")
+	fs.SetDelay(LatencyOpSync, 0)
+	require.NoError(t, f.Sync())
+	expect("sync", "")
+	fs.SetDelay(LatencyOpSync, 5*time.Millisecond)
+	require.NoError(t, f.Sync())
+	expect("sync", "sleep 5ms\n")
+	require.NoError(t, f.Close())
+
+	require.NoError(t, fs.Remove("dir/baz"))
+	expect("remove", "")
+}
+
+func TestLatencyFSFilter(t *testing.T) {
+	var filtered []string
+	fs := NewLatencyFS(NewMem(), LatencyConfig{
+		Sync: time.Millisecond,
+		Filter: func(path string) bool {
+			filtered = append(filtered, path)
+			return strings.HasPrefix(path, "slow/")
+		},
+	})
+	var sleeps int
+	fs.sleep = func(time.Duration) { sleeps++ }
+
+	for _, name := range []string{"slow/a", "fast/b"} {
+		require.NoError(t, fs.MkdirAll(fs.PathDir(name), 0755))
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		require.NoError(t, f.Sync())
+		require.NoError(t, f.Close())
+	}
+	// Only the syncs consult the filter, since only syncs have a delay, and
+	// only the sync within the slow directory is delayed.
+	require.Equal(t, []string{"slow/a", "fast/b"}, filtered)
+	require.Equal(t, 1, sleeps)
+}
+
+func TestLatencyFSDelay(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	fs := NewLatencyFS(NewMem(), LatencyConfig{Sync: delay})
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, f.Sync())
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(delay))
+	require.NoError(t, f.Close())
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := pebble.Open("", &pebble.Options{FS: mem})
+	require.NoError(t, err)
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+	b = d.NewBatch()
+	require.NoError(t, b.DeleteRange([]byte("b"), []byte("c"), nil))
+	require.NoError(t, b.Merge([]byte("c"), []byte("3"), nil))
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Close())
+
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var path string
+	for _, name := range ls {
+		if strings.HasSuffix(name, ".log") {
+			path = name
+		}
+	}
+	require.NotEmpty(t, path)
+
+	dump := func(path string) ([]string, error) {
+		var batches []string
+		err := Dump(mem, path, func(seqNum uint64, b *pebble.Batch) error {
+			var entries []string
+			for r := b.Reader(); ; {
+				kind, ukey, value, ok := r.Next()
+				if !ok {
+					break
+				}
+				entries = append(entries, fmt.Sprintf("%s:%s:%s", kind, ukey, value))
+			}
+			batches = append(batches, fmt.Sprintf("#%d %s", seqNum, strings.Join(entries, " ")))
+			return nil
+		})
+		return batches, err
+	}
+	batches, err := dump(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"#1 SET:a:1 SET:b:2",
+		"#3 DEL:a:",
+		"#4 RANGEDEL:b:c MERGE:c:3",
+	}, batches)
+
+	// Errors returned by the visitor are returned by Dump.
+	visitErr := errors.New("visit error")
+	require.Equal(t, visitErr, Dump(mem, path, func(uint64, *pebble.Batch) error { return visitErr }))
+
+	f, err := mem.Open(path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	// The chunks of a WAL record its log number, so the modified copies of the
+	// WAL retain its filename.
+	writeLog := func(dir string, data []byte) string {
+		require.NoError(t, mem.MkdirAll(dir, 0755))
+		name := mem.PathJoin(dir, path)
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		return name
+	}
+
+	// Find the offsets of the WAL's records.
+	_, logNum, ok := base.ParseFilename(mem, path)
+	require.True(t, ok)
+	var offsets []int64
+	rr := record.NewReader(bytes.NewReader(data), logNum)
+	for {
+		offset := rr.Offset()
+		_, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		offsets = append(offsets, offset)
+	}
+	require.Len(t, offsets, 3)
+
+	// A partially written trailing record is reported after visiting the
+	// preceding batches.
+	batches, err = dump(writeLog("truncated", data[:offsets[2]+15]))
+	require.Equal(t, []string{
+		"#1 SET:a:1 SET:b:2",
+		"#3 DEL:a:",
+	}, batches)
+	var corruptionErr *CorruptionError
+	require.True(t, errors.As(err, &corruptionErr))
+	require.Equal(t, offsets[2], corruptionErr.Offset)
+
+	// A corrupt record is reported at its offset.
+	corrupt := append([]byte(nil), data...)
+	corrupt[offsets[1]+15] ^= 0xff
+	corruptPath := writeLog("corrupt", corrupt)
+	batches, err = dump(corruptPath)
+	require.Equal(t, []string{"#1 SET:a:1 SET:b:2"}, batches)
+	require.True(t, errors.As(err, &corruptionErr))
+	require.Equal(t, offsets[1], corruptionErr.Offset)
+	require.Contains(t, err.Error(), fmt.Sprintf("corrupt WAL %q at offset %d", corruptPath, offsets[1]))
+}
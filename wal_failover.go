@@ -0,0 +1,165 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// walFailover holds the state of failover of the WAL to the secondary
+// directory configured by Options.WALFailover.
+type walFailover struct {
+	// dirname is the path of the secondary directory, and dir is an open
+	// handle to it. Both are unset if WAL failover is not configured.
+	dirname string
+	dir     vfs.File
+	// unhealthy is set to 1 (atomically) once a write or sync of a WAL in the
+	// primary WAL directory exceeds the configured latency threshold. It is
+	// never reset: once failed over, all subsequent WALs are written to the
+	// secondary directory until the DB is reopened. In particular, WALs in the
+	// primary directory are never recycled while an abandoned WAL may still be
+	// being written.
+	unhealthy uint32
+	// closing is the number of WALs abandoned by failover that are still
+	// being closed in the background. Protected by DB.mu.
+	closing int
+}
+
+// markPrimaryWALUnhealthy records that the primary WAL directory is
+// unhealthy. The next write will rotate the WAL into the secondary directory.
+func (d *DB) markPrimaryWALUnhealthy(name string, duration time.Duration) {
+	if atomic.CompareAndSwapUint32(&d.walFailover.unhealthy, 0, 1) {
+		d.opts.Logger.Infof("WAL %s write or sync exceeded %s; failing over to %s",
+			name, duration, d.walFailover.dirname)
+	}
+}
+
+// walFailedOver returns true if WAL failover is configured and the primary
+// WAL directory has been deemed unhealthy.
+func (d *DB) walFailedOver() bool {
+	return d.walFailover.dir != nil && atomic.LoadUint32(&d.walFailover.unhealthy) == 1
+}
+
+// shouldFailoverWALLocked returns true if the current WAL resides in the
+// primary WAL directory and that directory has been deemed unhealthy, in which
+// case the WAL should be rotated into the secondary directory.
+//
+// d.mu must be held when calling this.
+func (d *DB) shouldFailoverWALLocked() bool {
+	if d.opts.DisableWAL || !d.walFailedOver() {
+		return false
+	}
+	q := d.mu.log.queue
+	return len(q) > 0 && q[len(q)-1].dir == ""
+}
+
+// closeFailedOverLogLocked closes the LogWriter of a WAL in the primary WAL
+// directory that was abandoned by failover. The close may block indefinitely
+// on the unhealthy directory, so it's performed in the background. DB.Close
+// waits for it to complete.
+//
+// d.mu must be held when calling this.
+func (d *DB) closeFailedOverLogLocked(w *record.LogWriter, logNum FileNum) {
+	d.walFailover.closing++
+	go func() {
+		err := w.Close()
+		metrics := w.Metrics()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err != nil {
+			d.opts.Logger.Infof("WAL %s abandoned by failover failed to close: %v",
+				base.MakeFilepath(d.opts.FS, d.walDirname, fileTypeLog, logNum), err)
+		}
+		d.mergeLogWriterMetricsLocked(metrics)
+		d.walFailover.closing--
+		d.mu.compact.cond.Broadcast()
+	}()
+}
+
+// logDirname returns the directory containing the WAL described by fi.
+func (d *DB) logDirname(fi fileInfo) string {
+	if fi.dir != "" {
+		return fi.dir
+	}
+	return d.walDirname
+}
+
+// scanObsoleteSecondaryLogs adds the WALs within the WAL failover secondary
+// directory that are no longer needed to the log queue, from which they'll be
+// deleted by a subsequent call to deleteObsoleteFiles. The same restrictions
+// as scanObsoleteFiles apply.
+func (d *DB) scanObsoleteSecondaryLogs(list []string) {
+	var obsoleteLogs []fileInfo
+	for _, filename := range list {
+		fileType, fileNum, ok := base.ParseFilename(d.opts.FS, filename)
+		if !ok || fileType != fileTypeLog || fileNum >= d.mu.versions.minUnflushedLogNum {
+			continue
+		}
+		fi := fileInfo{fileNum: fileNum, dir: d.walFailover.dirname}
+		path := d.opts.FS.PathJoin(d.walFailover.dirname, filename)
+		if stat, err := d.opts.FS.Stat(path); err == nil {
+			fi.fileSize = uint64(stat.Size())
+		}
+		obsoleteLogs = append(obsoleteLogs, fi)
+	}
+	d.mu.log.queue = merge(d.mu.log.queue, obsoleteLogs)
+	d.mu.versions.metrics.WAL.Files += int64(len(obsoleteLogs))
+}
+
+// walFailoverFile wraps a WAL file within the primary WAL directory,
+// reporting any write or sync that takes longer than the configured threshold.
+// An operation is reported as soon as the threshold elapses, rather than when
+// it completes, so that a write or sync that never completes still triggers
+// failover.
+type walFailoverFile struct {
+	vfs.File
+	name      string
+	threshold time.Duration
+	onSlow    func(name string, duration time.Duration)
+}
+
+func (f *walFailoverFile) Write(p []byte) (int, error) {
+	defer f.watch()()
+	return f.File.Write(p)
+}
+
+func (f *walFailoverFile) Sync() error {
+	defer f.watch()()
+	return f.File.Sync()
+}
+
+// watch arms a timer reporting the operation in progress as slow once the
+// threshold elapses. The returned function disarms it.
+func (f *walFailoverFile) watch() func() {
+	t := time.AfterFunc(f.threshold, func() {
+		f.onSlow(f.name, f.threshold)
+	})
+	return func() { t.Stop() }
+}
+
+// newLogFile wraps a newly created WAL file for use by the LogWriter. If WAL
+// failover is configured and the file resides in the primary WAL directory,
+// its writes and syncs are monitored for slowness.
+func (d *DB) newLogFile(f vfs.File, name string, secondary bool) vfs.File {
+	f = vfs.NewSyncingFile(f, vfs.SyncingFileOptions{
+		NoSyncOnClose:   d.opts.NoSyncOnClose,
+		BytesPerSync:    d.opts.WALBytesPerSync,
+		PreallocateSize: d.walPreallocateSize(),
+	})
+	if d.walFailover.dir != nil && !secondary {
+		f = &walFailoverFile{
+			File:      f,
+			name:      name,
+			threshold: d.opts.WALFailover.UnhealthyLatencyThreshold,
+			onSlow:    d.markPrimaryWALUnhealthy,
+		}
+	}
+	return f
+}
@@ -0,0 +1,258 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALFailover(t *testing.T) {
+	// Inject latency into operations on files within the primary WAL
+	// directory, counting the delayed operations.
+	var delayed int64
+	fs := vfs.NewLatencyFS(vfs.NewMem(), vfs.LatencyConfig{
+		Filter: func(path string) bool {
+			if vfs.Default.PathDir(path) != "wal" {
+				return false
+			}
+			atomic.AddInt64(&delayed, 1)
+			return true
+		},
+	})
+	opts := &Options{
+		FS:     fs,
+		WALDir: "wal",
+		WALFailover: &WALFailoverOptions{
+			Secondary:                 "wal-secondary",
+			UnhealthyLatencyThreshold: 10 * time.Millisecond,
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	listLogs := func(dir string) []string {
+		ls, err := fs.List(dir)
+		require.NoError(t, err)
+		var logs []string
+		for _, filename := range ls {
+			if ft, _, ok := base.ParseFilename(fs, filename); ok && ft == fileTypeLog {
+				logs = append(logs, filename)
+			}
+		}
+		sort.Strings(logs)
+		return logs
+	}
+	set := func(i int) {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		require.NoError(t, d.Set(key, key, Sync))
+	}
+
+	for i := 0; i < 10; i++ {
+		set(i)
+	}
+	require.Empty(t, listLogs("wal-secondary"))
+
+	// Slow down syncs of the primary WAL. The first slow sync deems the
+	// primary WAL directory unhealthy, and the subsequent write rotates the WAL
+	// into the secondary directory.
+	fs.SetDelay(vfs.LatencyOpSync, 50*time.Millisecond)
+	set(10)
+	set(11)
+	require.Len(t, listLogs("wal-secondary"), 1)
+
+	// Writes continue via the secondary directory, without syncing the primary
+	// WAL.
+	syncs := atomic.LoadInt64(&delayed)
+	for i := 12; i < 100; i++ {
+		set(i)
+	}
+	require.Equal(t, syncs, atomic.LoadInt64(&delayed))
+	require.Len(t, listLogs("wal-secondary"), 1)
+
+	// The rotation into the secondary directory doesn't force a flush, so the
+	// WALs in both directories are required for recovery.
+	primaryLogs := listLogs("wal")
+	require.NotEmpty(t, primaryLogs)
+	require.NoError(t, d.Close())
+
+	fs.SetDelay(vfs.LatencyOpSync, 0)
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	iter := d.NewIter(nil)
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		require.Equal(t, fmt.Sprintf("key%03d", n), string(iter.Key()))
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 100, n)
+
+	// After replaying, the WALs from both directories are obsolete and are
+	// removed. The reopened DB writes its new WAL to the primary directory.
+	require.Empty(t, listLogs("wal-secondary"))
+	require.NoError(t, d.Close())
+	logs := listLogs("wal")
+	require.Len(t, logs, 1)
+	require.NotContains(t, primaryLogs, logs[0])
+}
+
+func TestWALFailoverInvalidOptions(t *testing.T) {
+	_, err := Open("", &Options{
+		FS:          vfs.NewMem(),
+		WALFailover: &WALFailoverOptions{},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "WALFailover.Secondary must be specified")
+
+	_, err = Open("db", &Options{
+		FS:          vfs.NewMem(),
+		WALFailover: &WALFailoverOptions{Secondary: "db"},
+	})
+	require.EqualError(t, err,
+		`pebble: WAL failover secondary directory "db" must differ from the WAL directory`)
+}
+
+// stallingFS wraps an FS, stalling syncs of files within the primary WAL
+// directory while stall is non-nil, until stall is closed.
+type stallingFS struct {
+	vfs.FS
+	mu    sync.Mutex
+	stall chan struct{}
+}
+
+func (fs *stallingFS) wrap(name string, f vfs.File, err error) (vfs.File, error) {
+	if err != nil || fs.PathDir(name) != "wal" {
+		return f, err
+	}
+	return &stallingFile{File: f, fs: fs}, nil
+}
+
+func (fs *stallingFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	return fs.wrap(name, f, err)
+}
+
+func (fs *stallingFS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	return fs.wrap(newname, f, err)
+}
+
+type stallingFile struct {
+	vfs.File
+	fs *stallingFS
+}
+
+func (f *stallingFile) Sync() error {
+	f.fs.mu.Lock()
+	stall := f.fs.stall
+	f.fs.mu.Unlock()
+	if stall != nil {
+		<-stall
+	}
+	return f.File.Sync()
+}
+
+func TestWALFailoverStalledPrimary(t *testing.T) {
+	fs := &stallingFS{FS: vfs.NewMem()}
+	opts := &Options{
+		FS:     fs,
+		WALDir: "wal",
+		WALFailover: &WALFailoverOptions{
+			Secondary:                 "wal-secondary",
+			UnhealthyLatencyThreshold: 10 * time.Millisecond,
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	set := func(i int) {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		require.NoError(t, d.Set(key, key, Sync))
+	}
+	for i := 0; i < 10; i++ {
+		set(i)
+	}
+
+	// Stall syncs of the primary WAL indefinitely. The stalled sync deems the
+	// primary WAL directory unhealthy while it's still in progress.
+	stall := make(chan struct{})
+	fs.mu.Lock()
+	fs.stall = stall
+	fs.mu.Unlock()
+	stalled := make(chan struct{})
+	go func() {
+		defer close(stalled)
+		set(10)
+	}()
+	for !d.walFailedOver() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Writes continue via the secondary directory while the write to the
+	// primary WAL remains stalled. The abandoned primary WAL is recorded in the
+	// MANIFEST.
+	for i := 11; i < 20; i++ {
+		set(i)
+	}
+	select {
+	case <-stalled:
+		t.Fatal("write to the stalled primary WAL completed")
+	default:
+	}
+	d.mu.Lock()
+	failedOverLogNums := append([]FileNum(nil), d.mu.versions.failedOverLogNums...)
+	d.mu.Unlock()
+	require.Len(t, failedOverLogNums, 1)
+
+	fs.mu.Lock()
+	fs.stall = nil
+	fs.mu.Unlock()
+	close(stall)
+	<-stalled
+	require.NoError(t, d.Close())
+
+	// Simulate a torn write at the tail of the abandoned WAL by truncating its
+	// final record, and the 11-byte EOF trailer that follows it. The abandoned WAL is followed by the WAL in the secondary
+	// directory, but its unclean tail is tolerated.
+	path := base.MakeFilepath(fs, "wal", fileTypeLog, failedOverLogNums[0])
+	f, err := fs.Open(path)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = fs.Create(path)
+	require.NoError(t, err)
+	_, err = f.Write(data[:len(data)-12])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		_, closer, err := d.Get(key)
+		if i == 10 {
+			require.ErrorIs(t, err, ErrNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+	// The replayed WALs were flushed, so the abandoned WAL is forgotten.
+	d.mu.Lock()
+	require.Empty(t, d.mu.versions.failedOverLogNums)
+	d.mu.Unlock()
+	require.NoError(t, d.Close())
+}